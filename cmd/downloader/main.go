@@ -75,6 +75,7 @@ var (
 	disableIPV6                    bool
 	disableIPV4                    bool
 	seedbox                        bool
+	webseedOnly                    bool
 )
 
 func init() {
@@ -97,6 +98,7 @@ func init() {
 	rootCmd.Flags().BoolVar(&disableIPV6, "downloader.disable.ipv6", utils.DisableIPV6.Value, utils.DisableIPV6.Usage)
 	rootCmd.Flags().BoolVar(&disableIPV4, "downloader.disable.ipv4", utils.DisableIPV4.Value, utils.DisableIPV6.Usage)
 	rootCmd.Flags().BoolVar(&seedbox, "seedbox", false, "Turns downloader into independent (doesn't need Erigon) software which discover/download/seed new files - useful for Erigon network, and can work on very cheap hardware. It will: 1) download .torrent from webseed 2) download new files after upgrade 3) we planing add discovery of new files soon")
+	rootCmd.Flags().BoolVar(&webseedOnly, "webseed.only", false, "Download files only over HTTP(S) from webseeds, without BitTorrent peers. Useful in networks where BitTorrent is blocked")
 	rootCmd.PersistentFlags().BoolVar(&verify, "verify", false, utils.DownloaderVerifyFlag.Usage)
 	rootCmd.PersistentFlags().StringVar(&_verifyFiles, "verify.files", "", "Limit list of files to verify")
 	rootCmd.PersistentFlags().BoolVar(&verifyFailfast, "verify.failfast", false, "Stop on first found error. Report it and exit")
@@ -215,8 +217,13 @@ func Downloader(ctx context.Context, logger log.Logger) error {
 	downloadernat.DoNat(natif, cfg.ClientConfig, logger)
 
 	cfg.AddTorrentsFromDisk = true // always true unless using uploader - which wants control of torrent files
+	if webseedOnly {
+		cfg.WebSeedOnly = true
+		cfg.ClientConfig.DisableTrackers = true
+		cfg.ClientConfig.NoDHT = true
+	}
 
-	d, err := downloader.New(ctx, cfg, dirs, logger, log.LvlInfo, seedbox)
+	d, err := downloader.New(ctx, cfg, dirs, logger, log.LvlInfo, seedbox || webseedOnly)
 	if err != nil {
 		return err
 	}
@@ -330,7 +337,7 @@ func manifest(ctx context.Context, logger log.Logger) error {
 	extList := []string{
 		".torrent",
 		".seg", ".idx", // e2
-		".kv", ".kvi", ".bt", ".kvei", // e3 domain
+		".kv", ".kvi", ".bt", ".kvei", ".vlog", // e3 domain
 		".v", ".vi", //e3 hist
 		".ef", ".efi", //e3 idx
 		".txt", //salt.txt
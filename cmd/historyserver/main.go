@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/kv/remotedbserver"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/ledgerwatch/erigon/cmd/utils"
+	"github.com/ledgerwatch/erigon/common/paths"
+	"github.com/ledgerwatch/erigon/core/state/temporal"
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
+	"github.com/ledgerwatch/erigon/turbo/debug"
+	"github.com/ledgerwatch/erigon/turbo/logging"
+)
+
+var (
+	datadirCli     string
+	historyApiAddr string
+	rateLimit      uint32
+	reopenEvery    time.Duration
+	searchDirs     []string
+
+	TLSCertfile string
+	TLSCACert   string
+	TLSKeyFile  string
+)
+
+func init() {
+	utils.CobraFlags(rootCmd, debug.Flags, utils.MetricFlags, logging.Flags)
+	rootCmd.Flags().StringVar(&datadirCli, utils.DataDirFlag.Name, paths.DefaultDataDir(), utils.DataDirFlag.Usage)
+	if err := rootCmd.MarkFlagDirname(utils.DataDirFlag.Name); err != nil {
+		panic(err)
+	}
+	rootCmd.Flags().StringVar(&historyApiAddr, "history.api.addr", "localhost:9095", "history service <host>:<port>")
+	rootCmd.Flags().Uint32Var(&rateLimit, "history.api.ratelimit", kv.ReadersLimit-128, "Amount of requests server handle simultaneously - requests over this limit will wait")
+	rootCmd.Flags().StringSliceVar(&searchDirs, utils.SnapStateSearchDirsFlag.Name, nil, utils.SnapStateSearchDirsFlag.Usage)
+	rootCmd.Flags().DurationVar(&reopenEvery, "history.reopen.every", time.Minute, "How often to pick up files newly produced by node")
+	rootCmd.PersistentFlags().StringVar(&TLSCertfile, "tls.cert", "", "certificate for client side TLS handshake")
+	rootCmd.PersistentFlags().StringVar(&TLSKeyFile, "tls.key", "", "key file for client side TLS handshake")
+	rootCmd.PersistentFlags().StringVar(&TLSCACert, "tls.cacert", "", "CA certificate for client side TLS handshake")
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "historyserver",
+	Short: "Launch history-serving process - serves historical state (HistoryGet, IndexRange, HistoryRange) from frozen files of Erigon's datadir over gRPC, read-only",
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		debug.Exit()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := debug.SetupCobra(cmd, "historyserver")
+		if err := doHistoryServer(cmd.Context(), logger); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Error(err.Error())
+			}
+			return
+		}
+	},
+}
+
+func doHistoryServer(ctx context.Context, logger log.Logger) error {
+	dirs := datadir.New(datadirCli)
+
+	// node owns chaindata: use empty in-memory db, all history is read from files
+	db := memdb.New(dirs.Tmp)
+	defer db.Close()
+	agg, err := libstate.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, ethconfig.HistoryV3AggregationStep, db, logger)
+	if err != nil {
+		return err
+	}
+	defer agg.Close()
+	agg.SetReadOnly(true)
+	agg.SetSearchDirs(searchDirs)
+	if err = agg.OpenFolder(); err != nil {
+		return err
+	}
+	tdb, err := temporal.NewFilesOnly(db, agg)
+	if err != nil {
+		return err
+	}
+
+	creds, err := grpcutil.TLS(TLSCACert, TLSCertfile, TLSKeyFile)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", historyApiAddr)
+	if err != nil {
+		return fmt.Errorf("could not create listener: %w, addr=%s", err, historyApiAddr)
+	}
+	grpcServer := grpcutil.NewServer(rateLimit, creds)
+	remote.RegisterKVServer(grpcServer, remotedbserver.NewKvServer(ctx, tdb, nil, nil, nil, logger))
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("history server fail", "err", err)
+		}
+	}()
+	defer grpcServer.GracefulStop()
+	logger.Info("History server started", "on", historyApiAddr, "files_end_txnum", agg.EndTxNumMinimax())
+
+	reopen := time.NewTicker(reopenEvery)
+	defer reopen.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-reopen.C:
+			// node keeps producing and merging files. Files deleted by node stay readable until last reader is done.
+			if err := agg.OpenFolder(); err != nil {
+				logger.Warn("[history] reopen files", "err", err)
+				continue
+			}
+			logger.Debug("[history] files reopened", "files_end_txnum", agg.EndTxNumMinimax())
+		}
+	}
+}
+
+func main() {
+	ctx, cancel := common.RootContext()
+	defer cancel()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
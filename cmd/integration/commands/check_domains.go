@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
+	"github.com/ledgerwatch/erigon/turbo/debug"
+)
+
+var (
+	checkFromStep, checkToStep uint64
+	checkDomainFiles           bool
+	checkDomainsStep           uint64
+)
+
+func init() {
+	withDataDir(cmdCheckDomains)
+	withWorkers(cmdCheckDomains)
+	cmdCheckDomains.Flags().Uint64Var(&checkFromStep, "from-step", 0, "check files starting from this step")
+	cmdCheckDomains.Flags().Uint64Var(&checkToStep, "to-step", math.MaxUint64/2, "check files ending before this step")
+	cmdCheckDomains.Flags().BoolVar(&checkDomainFiles, "domains", false, "also check files of domains (<datadir>/state, recent history in <datadir>/statedb): .kv latest values are replayed against history")
+	cmdCheckDomains.Flags().Uint64Var(&checkDomainsStep, "domains.step", ethconfig.HistoryV3AggregationStep, "aggregation step of domain files")
+	withStorageContracts(cmdCheckDomains)
+
+	rootCmd.AddCommand(cmdCheckDomains)
+}
+
+var cmdCheckDomains = &cobra.Command{
+	Use:   "check_domains",
+	Short: "Cross-check frozen history/inverted index files and their accessor indices",
+	Long: `For every history file in step range: every value of .v has posting in matching .ef (and vice-versa), .vi resolves all (txNum, key) pairs.
+For every inverted index file: keys are sorted, txNums are within file's range, .efi resolves all keys.
+With --domains - same for history files of domains, and every value of .kv file equals value which history has at end of file's range.
+Files are checked in parallel by --exec.workers.`,
+	Example: "go run ./cmd/integration check_domains --datadir=... --from-step=0 --to-step=64",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := debug.SetupCobra(cmd, "integration")
+		db, err := openDB(dbCfg(kv.ChainDB, chaindata), false, logger)
+		if err != nil {
+			logger.Error("Opening DB", "error", err)
+			return
+		}
+		defer db.Close()
+
+		if err := checkDomains(cmd.Context(), db, logger); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				logger.Error(err.Error())
+			}
+			return
+		}
+	},
+}
+
+func checkDomains(ctx context.Context, db kv.RwDB, logger log.Logger) error {
+	sn, borSn, agg := allSnapshots(ctx, db, logger)
+	defer sn.Close()
+	defer borSn.Close()
+	defer agg.Close()
+
+	start := time.Now()
+	reports, err := agg.IntegrityCheck(ctx, checkFromStep, checkToStep, int(workers))
+	if err != nil {
+		return err
+	}
+	if checkDomainFiles {
+		domainReports, err := checkDomainsLatest(ctx, logger)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, domainReports...)
+	}
+	var keys, txNums uint64
+	var broken int
+	for _, r := range reports {
+		keys += r.Keys
+		txNums += r.TxNums
+		if len(r.Errs) == 0 {
+			continue
+		}
+		broken++
+		for _, e := range r.Errs {
+			logger.Error("[integrity] check_domains", "files", r.Files, "err", e)
+		}
+	}
+	logger.Info("[integrity] check_domains done", "checked", len(reports), "broken", broken, "keys", keys, "txNums", txNums, "took", time.Since(start))
+	if broken > 0 {
+		return fmt.Errorf("check_domains: %d of %d files are broken", broken, len(reports))
+	}
+	return nil
+}
+
+func checkDomainsLatest(ctx context.Context, logger log.Logger) ([]libstate.IntegrityReport, error) {
+	dirs := datadir.New(datadirCli)
+	stateDb, err := dbCfg(kv.ChainDB, filepath.Join(dirs.DataDir, "statedb")).Readonly().Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stateDb.Close()
+	// commitment domain is not checked: mode and trie variant don't matter
+	agg, err := libstate.NewAggregator(filepath.Join(dirs.DataDir, "state"), dirs.Tmp, checkDomainsStep, libstate.CommitmentModeDisabled, commitment.VariantHexPatriciaTrie, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer agg.Close()
+	for _, addr := range storageContracts {
+		if err = agg.AddStorageContract(libcommon.HexToAddress(addr).Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err = agg.ReopenFolder(); err != nil {
+		return nil, err
+	}
+	return agg.IntegrityCheck(ctx, checkFromStep, checkToStep, int(workers), stateDb)
+}
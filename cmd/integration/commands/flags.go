@@ -39,6 +39,11 @@ var (
 
 	_forceSetHistoryV3    bool
 	workers, reconWorkers uint64
+	storageContracts      []string
+	valueLogThreshold     int
+	stepMeta              bool
+	codeByHash            bool
+	stateSearchDirs       []string
 )
 
 func must(err error) {
@@ -111,6 +116,7 @@ func withDataDir2(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&datadirCli, utils.DataDirFlag.Name, "", utils.DataDirFlag.Usage)
 	must(cmd.MarkFlagDirname(utils.DataDirFlag.Name))
 	must(cmd.MarkFlagRequired(utils.DataDirFlag.Name))
+	cmd.Flags().StringSliceVar(&stateSearchDirs, utils.SnapStateSearchDirsFlag.Name, nil, utils.SnapStateSearchDirsFlag.Usage)
 	cmd.Flags().IntVar(&databaseVerbosity, "database.verbosity", 2, "Enabling internal db logs. Very high verbosity levels may require recompile db. Default: 2, means warning.")
 }
 
@@ -121,6 +127,7 @@ func withDataDir(cmd *cobra.Command) {
 
 	cmd.Flags().StringVar(&chaindata, "chaindata", "", "path to the db")
 	must(cmd.MarkFlagDirname("chaindata"))
+	cmd.Flags().StringSliceVar(&stateSearchDirs, utils.SnapStateSearchDirsFlag.Name, nil, utils.SnapStateSearchDirsFlag.Usage)
 
 	cmd.Flags().IntVar(&databaseVerbosity, "database.verbosity", 2, "Enabling internal db logs. Very high verbosity levels may require recompile db. Default: 2, means warning")
 }
@@ -169,3 +176,13 @@ func withCommitment(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&commitmentTrie, "commitment.trie", "hex", "hex - use Hex Patricia Hashed Trie for commitments, bin - use of binary patricia trie")
 	cmd.Flags().IntVar(&commitmentFreq, "commitment.freq", 1000000, "how many blocks to skip between calculating commitment")
 }
+
+func withStorageContracts(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&storageContracts, "storage.contracts", nil, "comma separated addresses of contracts which storage is kept in dedicated files. Must be the same for datadir")
+}
+
+func withDomainFiles(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&valueLogThreshold, "domain.vlog.threshold", 0, "values of this size or bigger are written to .vlog files next to new .kv files, 0 - disabled. Existing files are read in format they were written")
+	cmd.Flags().BoolVar(&stepMeta, "domain.step.meta", false, "new merged .kv files store step of each value: exact age of values and prune of values which files have. Existing files are read in format they were written")
+	cmd.Flags().BoolVar(&codeByHash, "domain.code.byhash", false, "new merged .kv files of code domain store each bytecode once, addresses refer to it by codeHash. Existing files are read in format they were written")
+}
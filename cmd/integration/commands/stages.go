@@ -1460,6 +1460,17 @@ func allSnapshots(ctx context.Context, db kv.RoDB, logger log.Logger) (*freezebl
 		if err != nil {
 			panic(err)
 		}
+		_aggSingleton.SetSearchDirs(stateSearchDirs)
+		if err = db.View(ctx, func(tx kv.Tx) error {
+			codec, err := libstate.ReadAccountCodec(tx)
+			if err != nil {
+				return err
+			}
+			_aggSingleton.SetAccountCodec(codec)
+			return nil
+		}); err != nil {
+			panic(err)
+		}
 		err = _aggSingleton.OpenFolder()
 		if err != nil {
 			panic(err)
@@ -1521,6 +1532,14 @@ func allDomains(ctx context.Context, db kv.RoDB, stepSize uint64, mode libstate.
 		if err != nil {
 			panic(err)
 		}
+		for _, addr := range storageContracts {
+			if err = _aggDomainSingleton.AddStorageContract(libcommon.HexToAddress(addr).Bytes()); err != nil {
+				panic(err)
+			}
+		}
+		_aggDomainSingleton.SetValueLogThreshold(valueLogThreshold)
+		_aggDomainSingleton.SetStepMeta(stepMeta)
+		_aggDomainSingleton.SetCodeByHash(codeByHash)
 		if err = _aggDomainSingleton.ReopenFolder(); err != nil {
 			panic(err)
 		}
@@ -1571,6 +1590,16 @@ func newDomains(ctx context.Context, db kv.RwDB, stepSize uint64, mode libstate.
 
 	allSn, agg := allDomains(ctx, db, stepSize, mode, trie, logger)
 	cfg.Snapshot = allSn.Cfg()
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		codec, err := libstate.ReadAccountCodec(tx)
+		if err != nil {
+			return err
+		}
+		agg.SetAccountCodec(codec)
+		return nil
+	}); err != nil {
+		panic(err)
+	}
 
 	blockReader, _ := blocksIO(db, logger)
 	engine, _ := initConsensusEngine(ctx, chainConfig, cfg.Dirs.DataDir, db, blockReader, logger)
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -40,6 +41,8 @@ func init() {
 	withHeimdall(readDomains)
 	withWorkers(readDomains)
 	withStartTx(readDomains)
+	withStorageContracts(readDomains)
+	withDomainFiles(readDomains)
 
 	rootCmd.AddCommand(readDomains)
 }
@@ -137,7 +140,7 @@ func requestDomains(chainDb, stateDb kv.RwDB, ctx context.Context, readDomain st
 	agg.SetTx(stateTx)
 	defer agg.StartWrites().FinishWrites()
 
-	latestBlock, latestTx, err := agg.SeekCommitment()
+	latestBlock, latestTx, err := agg.SeekCommitmentWithHint(libstate.CommitmentHint{FromTxNum: startTxNum, ToTxNum: math.MaxUint64})
 	if err != nil && startTxNum != 0 {
 		return fmt.Errorf("failed to seek commitment to tx %d: %w", startTxNum, err)
 	}
@@ -162,7 +165,16 @@ func requestDomains(chainDb, stateDb kv.RwDB, ctx context.Context, readDomain st
 				logger.Error("failed to read account", "addr", addr, "err", err)
 				continue
 			}
-			fmt.Printf("%x: nonce=%d balance=%d code=%x root=%x\n", addr, acc.Nonce, acc.Balance.Uint64(), acc.CodeHash, acc.Root)
+			if acc == nil {
+				fmt.Printf("%x: not found\n", addr)
+				continue
+			}
+			_, step, err := r.ac.ReadAccountDataWithStep(addr, r.roTx)
+			if err != nil {
+				logger.Error("failed to read account", "addr", addr, "err", err)
+				continue
+			}
+			fmt.Printf("%x: nonce=%d balance=%d code=%x root=%x step=%d\n", addr, acc.Nonce, acc.Balance.Uint64(), acc.CodeHash, acc.Root, step)
 		}
 	case "storage":
 		for _, addr := range addrs {
@@ -172,7 +184,12 @@ func requestDomains(chainDb, stateDb kv.RwDB, ctx context.Context, readDomain st
 				logger.Error("failed to read storage", "addr", a.String(), "key", s.String(), "err", err)
 				continue
 			}
-			fmt.Printf("%s %s -> %x\n", a.String(), s.String(), st)
+			_, step, err := r.ac.ReadAccountStorageWithStep(a.Bytes(), s.Bytes(), r.roTx)
+			if err != nil {
+				logger.Error("failed to read storage", "addr", a.String(), "key", s.String(), "err", err)
+				continue
+			}
+			fmt.Printf("%s %s -> %x step=%d\n", a.String(), s.String(), st, step)
 		}
 	case "code":
 		for _, addr := range addrs {
@@ -212,7 +229,7 @@ func (rw *ReaderWrapper4) ReadAccountData(address libcommon.Address) (*accounts.
 		return nil, nil
 	}
 	var a accounts.Account
-	if err := accounts.DeserialiseV3(&a, enc); err != nil {
+	if err := accounts.Deserialise(rw.ac.AccountCodec(), &a, enc); err != nil {
 		return nil, err
 	}
 	return &a, nil
@@ -245,7 +262,7 @@ func (rw *ReaderWrapper4) ReadAccountIncarnation(address libcommon.Address) (uin
 }
 
 func (ww *WriterWrapper4) UpdateAccountData(address libcommon.Address, original, account *accounts.Account) error {
-	value := accounts.SerialiseV3(account)
+	value := accounts.Serialise(ww.w.AccountCodec(), account)
 	if err := ww.w.UpdateAccountData(address.Bytes(), value); err != nil {
 		return err
 	}
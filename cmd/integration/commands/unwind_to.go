@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
+	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
+	"github.com/ledgerwatch/erigon-lib/wrap"
+
+	"github.com/ledgerwatch/erigon/cmd/hack/tool/fromdb"
+	"github.com/ledgerwatch/erigon/eth/stagedsync"
+	"github.com/ledgerwatch/erigon/eth/stagedsync/stages"
+	"github.com/ledgerwatch/erigon/turbo/debug"
+)
+
+var unwindToBlock, unwindToTxNum uint64
+var unwindToDryRun bool
+
+func init() {
+	withDataDir(cmdUnwindTo)
+	withChain(cmdUnwindTo)
+	withHeimdall(cmdUnwindTo)
+	cmdUnwindTo.Flags().Uint64Var(&unwindToBlock, "block", 0, "unwind all stages to this block (state after this block stays)")
+	cmdUnwindTo.Flags().Uint64Var(&unwindToTxNum, "txnum", 0, "unwind all stages to the last block which ends at or before this txNum")
+	cmdUnwindTo.Flags().BoolVar(&unwindToDryRun, "dry-run", false, "only run safety checks and print unwind point")
+	cmdUnwindTo.MarkFlagsMutuallyExclusive("block", "txnum")
+
+	rootCmd.AddCommand(cmdUnwindTo)
+}
+
+var cmdUnwindTo = &cobra.Command{
+	Use:   "unwind_to",
+	Short: "Unwind state and progress of all stages to given block or txNum - to recover from bad block without full re-sync",
+	Long: `Refuses to unwind:
+  - below frozen block snapshots - they are immutable
+  - below frozen state files (HistoryV3) - they are immutable
+  - below pruned history/changesets - state of target block can't be restored`,
+	Example: "go run ./cmd/integration unwind_to --datadir=... --chain=mainnet --block=18000000",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := debug.SetupCobra(cmd, "integration")
+		db, err := openDB(dbCfg(kv.ChainDB, chaindata), true, logger)
+		if err != nil {
+			logger.Error("Opening DB", "error", err)
+			return
+		}
+		defer db.Close()
+
+		if err := unwindTo(cmd.Context(), db, logger); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				logger.Error(err.Error())
+			}
+			return
+		}
+	},
+}
+
+func unwindTo(ctx context.Context, db kv.RwDB, logger log.Logger) error {
+	if unwindToBlock == 0 && unwindToTxNum == 0 {
+		return errors.New("unwind_to: one of --block or --txnum is required")
+	}
+	dirs := datadir.New(datadirCli)
+	if err := datadir.ApplyMigrations(dirs); err != nil {
+		return err
+	}
+	sn, borSn, agg := allSnapshots(ctx, db, logger)
+	defer sn.Close()
+	defer borSn.Close()
+	defer agg.Close()
+
+	_, _, sync, _, _ := newSync(ctx, db, nil /* miningConfig */, logger)
+	historyV3, pm := kvcfg.HistoryV3.FromDB(db), fromdb.PruneMode(db)
+
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unwindPoint := unwindToBlock
+	if unwindToTxNum > 0 {
+		ok, blockNum, err := rawdbv3.TxNums.FindBlockNum(tx, unwindToTxNum)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("unwind_to: block of txNum=%d not found", unwindToTxNum)
+		}
+		maxTxNum, err := rawdbv3.TxNums.Max(tx, blockNum)
+		if err != nil {
+			return err
+		}
+		// txNum is in the middle of block - state can be unwound only to block boundary
+		if maxTxNum != unwindToTxNum {
+			if blockNum == 0 {
+				return fmt.Errorf("unwind_to: txNum=%d is inside genesis block", unwindToTxNum)
+			}
+			blockNum--
+		}
+		unwindPoint = blockNum
+	}
+
+	execProgress, err := stages.GetStageProgress(tx, stages.Execution)
+	if err != nil {
+		return err
+	}
+	headersProgress, err := stages.GetStageProgress(tx, stages.Headers)
+	if err != nil {
+		return err
+	}
+	if unwindPoint >= headersProgress {
+		return fmt.Errorf("unwind_to: nothing to unwind, unwind point %d is not below headers progress %d", unwindPoint, headersProgress)
+	}
+	unwindPointTxNum, err := rawdbv3.TxNums.Max(tx, unwindPoint)
+	if err != nil {
+		return err
+	}
+	if err := checkUnwindPoint(unwindPoint, unwindPointTxNum, execProgress, sn.BlocksAvailable(), agg.EndTxNumMinimax(), historyV3, pm.History.PruneTo(execProgress), pm.History.Enabled()); err != nil {
+		return err
+	}
+
+	logger.Info("[unwind_to] unwinding", "from_block", headersProgress, "exec_progress", execProgress, "to_block", unwindPoint, "to_txnum", unwindPointTxNum, "dry_run", unwindToDryRun)
+	if unwindToDryRun {
+		return nil
+	}
+
+	// stage unwinders rewind their progress; Execution stage also unwinds AggregatorV3 (when HistoryV3)
+	sync.UnwindTo(unwindPoint, stagedsync.StagedUnwind)
+	if err := sync.RunUnwind(db, wrap.TxContainer{Tx: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.Info("[unwind_to] done", "block", unwindPoint)
+	return nil
+}
+
+// checkUnwindPoint - state after block `unwindPoint` must be restorable from mutable data
+func checkUnwindPoint(unwindPoint, unwindPointTxNum, execProgress, frozenBlocks, frozenTxNum uint64, historyV3 bool, historyPrunedTo uint64, historyPruneEnabled bool) error {
+	if frozenBlocks > 0 && unwindPoint < frozenBlocks {
+		return fmt.Errorf("unwind_to: block %d is inside frozen block snapshots (available up to %d)", unwindPoint, frozenBlocks)
+	}
+	if historyV3 {
+		// frozen state files are immutable: state can be unwound only inside DB-part of history
+		if unwindPointTxNum+1 < frozenTxNum {
+			return fmt.Errorf("unwind_to: txNum %d of block %d is inside frozen state files (up to txNum %d)", unwindPointTxNum, unwindPoint, frozenTxNum)
+		}
+		return nil
+	}
+	// changesets are needed to unwind state and re-compute state root
+	if historyPruneEnabled && unwindPoint < execProgress && unwindPoint < historyPrunedTo {
+		return fmt.Errorf("unwind_to: history is pruned up to block %d, can't unwind to %d", historyPrunedTo, unwindPoint)
+	}
+	return nil
+}
@@ -98,7 +98,9 @@ func RootCommand() (*cobra.Command, *httpcfg.HttpCfg) {
 	rootCmd.PersistentFlags().IntVar(&cfg.DBReadConcurrency, utils.DBReadConcurrencyFlag.Name, utils.DBReadConcurrencyFlag.Value, utils.DBReadConcurrencyFlag.Usage)
 	rootCmd.PersistentFlags().BoolVar(&cfg.TraceCompatibility, "trace.compat", false, "Bug for bug compatibility with OE for trace_ routines")
 	rootCmd.PersistentFlags().StringVar(&cfg.TxPoolApiAddr, "txpool.api.addr", "", "txpool api network address, for example: 127.0.0.1:9090 (default: use value of --private.api.addr)")
+	rootCmd.PersistentFlags().StringVar(&cfg.HistoryApiAddr, "history.api.addr", "", "history server (cmd/historyserver) network address: point reads of history are served by it first, for example: 127.0.0.1:9095. Only without --datadir")
 	rootCmd.PersistentFlags().BoolVar(&cfg.Sync.UseSnapshots, "snapshot", true, utils.SnapshotFlag.Usage)
+	rootCmd.PersistentFlags().StringSliceVar(&cfg.Snap.StateSearchDirs, utils.SnapStateSearchDirsFlag.Name, nil, utils.SnapStateSearchDirsFlag.Usage)
 
 	rootCmd.PersistentFlags().StringVar(&stateCacheStr, "state.cache", "0MB", "Amount of data to store in StateCache (enabled if no --datadir set). Set 0 to disable StateCache. Defaults to 0MB RAM")
 	rootCmd.PersistentFlags().BoolVar(&cfg.GRPCServerEnabled, "grpc", false, "Enable GRPC server")
@@ -141,6 +143,10 @@ func RootCommand() (*cobra.Command, *httpcfg.HttpCfg) {
 	rootCmd.PersistentFlags().IntVar(&cfg.ReturnDataLimit, utils.RpcReturnDataLimit.Name, utils.RpcReturnDataLimit.Value, utils.RpcReturnDataLimit.Usage)
 	rootCmd.PersistentFlags().BoolVar(&cfg.AllowUnprotectedTxs, utils.AllowUnprotectedTxs.Name, utils.AllowUnprotectedTxs.Value, utils.AllowUnprotectedTxs.Usage)
 	rootCmd.PersistentFlags().IntVar(&cfg.MaxGetProofRewindBlockCount, utils.RpcMaxGetProofRewindBlockCount.Name, utils.RpcMaxGetProofRewindBlockCount.Value, utils.RpcMaxGetProofRewindBlockCount.Usage)
+	rootCmd.PersistentFlags().Uint64Var(&cfg.GetLogsMaxBlocks, utils.RpcGetLogsMaxBlocksFlag.Name, utils.RpcGetLogsMaxBlocksFlag.Value, utils.RpcGetLogsMaxBlocksFlag.Usage)
+	rootCmd.PersistentFlags().IntVar(&cfg.GetLogsMaxResults, utils.RpcGetLogsMaxResultsFlag.Name, utils.RpcGetLogsMaxResultsFlag.Value, utils.RpcGetLogsMaxResultsFlag.Usage)
+	rootCmd.PersistentFlags().DurationVar(&cfg.GetLogsTimeout, utils.RpcGetLogsTimeoutFlag.Name, utils.RpcGetLogsTimeoutFlag.Value, utils.RpcGetLogsTimeoutFlag.Usage)
+	rootCmd.PersistentFlags().IntVar(&cfg.StateReadsLimit, utils.RpcStateReadsLimitFlag.Name, utils.RpcStateReadsLimitFlag.Value, utils.RpcStateReadsLimitFlag.Usage)
 	rootCmd.PersistentFlags().Uint64Var(&cfg.OtsMaxPageSize, utils.OtsSearchMaxCapFlag.Name, utils.OtsSearchMaxCapFlag.Value, utils.OtsSearchMaxCapFlag.Usage)
 	rootCmd.PersistentFlags().DurationVar(&cfg.RPCSlowLogThreshold, utils.RPCSlowFlag.Name, utils.RPCSlowFlag.Value, utils.RPCSlowFlag.Usage)
 
@@ -312,7 +318,15 @@ func RemoteServices(ctx context.Context, cfg *httpcfg.HttpCfg, logger log.Logger
 
 	remoteBackendClient := remote.NewETHBACKENDClient(conn)
 	remoteKvClient := remote.NewKVClient(conn)
-	remoteKv, err := remotedb.NewRemote(gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion), logger, remoteKvClient).Open()
+	remoteKvOpts := remotedb.NewRemote(gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion), logger, remoteKvClient)
+	if cfg.HistoryApiAddr != "" {
+		historyConn, err := grpcutil.Connect(creds, cfg.HistoryApiAddr)
+		if err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, ff, nil, fmt.Errorf("could not connect to history server: %w", err)
+		}
+		remoteKvOpts = remoteKvOpts.WithHistoryServer(remote.NewKVClient(historyConn))
+	}
+	remoteKv, err := remoteKvOpts.Open()
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, nil, ff, nil, fmt.Errorf("could not connect to remoteKv: %w", err)
 	}
@@ -385,7 +399,19 @@ func RemoteServices(ctx context.Context, cfg *httpcfg.HttpCfg, logger log.Logger
 		if agg, err = libstate.NewAggregatorV3(ctx, cfg.Dirs.SnapHistory, cfg.Dirs.Tmp, ethconfig.HistoryV3AggregationStep, db, logger); err != nil {
 			return nil, nil, nil, nil, nil, nil, nil, ff, nil, fmt.Errorf("create aggregator: %w", err)
 		}
+		agg.SetReadOnly(true) // files are produced by Erigon
+		agg.SetSearchDirs(cfg.Snap.StateSearchDirs)
 		_ = agg.OpenFolder()
+		if err = db.View(ctx, func(tx kv.Tx) error {
+			codec, err := libstate.ReadAccountCodec(tx)
+			if err != nil {
+				return err
+			}
+			agg.SetAccountCodec(codec)
+			return nil
+		}); err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, ff, nil, fmt.Errorf("read account codec: %w", err)
+		}
 
 		db.View(context.Background(), func(tx kv.Tx) error {
 			agg.LogStats(tx, func(endTxNumMinimax uint64) uint64 {
@@ -437,10 +463,12 @@ func RemoteServices(ctx context.Context, cfg *httpcfg.HttpCfg, logger log.Logger
 		})
 		if histV3Enabled {
 			logger.Info("HistoryV3", "enable", histV3Enabled)
-			db, err = temporal.New(rwKv, agg, systemcontracts.SystemContractCodeLookup[cc.ChainName])
+			agg.SetReadLimits(cfg.StateReadsLimit, 0)
+			tdb, err := temporal.New(rwKv, agg, systemcontracts.SystemContractCodeLookup[cc.ChainName])
 			if err != nil {
 				return nil, nil, nil, nil, nil, nil, nil, nil, nil, err
 			}
+			db = tdb.WithReadClass(libstate.ReadClassRPC)
 		}
 		stateCache = kvcache.NewDummy()
 	}
@@ -52,6 +52,7 @@ type HttpCfg struct {
 	DBReadConcurrency    int
 	TraceCompatibility   bool // Bug for bug compatibility for trace_ routines with OpenEthereum
 	TxPoolApiAddr        string
+	HistoryApiAddr       string
 	StateCache           kvcache.CoherentConfig
 	Snap                 ethconfig.BlocksFreezing
 	Sync                 ethconfig.Sync
@@ -78,6 +79,11 @@ type HttpCfg struct {
 	ReturnDataLimit             int  // Maximum number of bytes returned from calls (like eth_call)
 	AllowUnprotectedTxs         bool // Whether to allow non EIP-155 protected transactions  txs over RPC
 	MaxGetProofRewindBlockCount int  //Max GetProof rewind block count
+	// eth_getLogs budgets
+	GetLogsMaxBlocks  uint64
+	GetLogsMaxResults int
+	GetLogsTimeout    time.Duration
+	StateReadsLimit   int // see AggregatorV3.SetReadLimits
 	// Ots API
 	OtsMaxPageSize uint64
 
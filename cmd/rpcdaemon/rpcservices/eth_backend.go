@@ -98,6 +98,12 @@ func (back *RemoteBackend) FrozenFiles() (list []string)          { return back.
 func (back *RemoteBackend) FreezingCfg() ethconfig.BlocksFreezing {
 	return back.blockReader.FreezingCfg()
 }
+func (back *RemoteBackend) EarliestAvailableBlock() uint64 {
+	return back.blockReader.EarliestAvailableBlock()
+}
+func (back *RemoteBackend) FrozenRawBodies(ctx context.Context, fromBlock, count uint64) ([]*types.RawBody, error) {
+	return back.blockReader.FrozenRawBodies(ctx, fromBlock, count)
+}
 func (back *RemoteBackend) EnsureVersionCompatibility() bool {
 	versionReply, err := back.remoteEthBackend.Version(context.Background(), &emptypb.Empty{}, grpc.WaitForReady(true))
 	if err != nil {
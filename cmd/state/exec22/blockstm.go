@@ -0,0 +1,304 @@
+package exec22
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type txStatus uint8
+
+const (
+	statusReadyToExecute txStatus = iota
+	statusExecuting
+	statusExecuted
+	statusAborting
+)
+
+type TaskKind uint8
+
+const (
+	TaskExecution TaskKind = iota
+	TaskValidation
+)
+
+// Scheduler - collaborative scheduler of Block-STM: workers take lowest tx to execute or to validate, aborted
+// txs are re-executed with next incarnation, txs which read ESTIMATE wait for tx they depend on.
+type Scheduler struct {
+	txCount        int64
+	executionIdx   atomic.Int64
+	validationIdx  atomic.Int64
+	decreaseCnt    atomic.Int64
+	numActiveTasks atomic.Int64
+	done           atomic.Bool
+
+	lock         []sync.Mutex // per tx: guards status, incarnation, dependencies
+	status       []txStatus
+	incarnation  []int
+	dependencies [][]int // txs waiting for re-execution of tx
+}
+
+func NewScheduler(txCount int) *Scheduler {
+	return &Scheduler{
+		txCount:      int64(txCount),
+		lock:         make([]sync.Mutex, txCount),
+		status:       make([]txStatus, txCount),
+		incarnation:  make([]int, txCount),
+		dependencies: make([][]int, txCount),
+	}
+}
+
+func (s *Scheduler) Done() bool { return s.done.Load() }
+
+// NextTask - ok=false: nothing to do right now (or Done)
+func (s *Scheduler) NextTask() (v Version, kind TaskKind, ok bool) {
+	if s.validationIdx.Load() < s.executionIdx.Load() {
+		v, ok = s.nextVersionToValidate()
+		return v, TaskValidation, ok
+	}
+	v, ok = s.nextVersionToExecute()
+	return v, TaskExecution, ok
+}
+
+// AddDependency - tx `txIndex` has read ESTIMATE of `blocking`. false - `blocking` is already re-executed: just
+// execute tx again.
+func (s *Scheduler) AddDependency(txIndex, blocking int) bool {
+	s.lock[blocking].Lock()
+	if s.status[blocking] == statusExecuted {
+		s.lock[blocking].Unlock()
+		return false
+	}
+	s.lock[txIndex].Lock()
+	s.status[txIndex] = statusAborting
+	s.lock[txIndex].Unlock()
+	s.dependencies[blocking] = append(s.dependencies[blocking], txIndex)
+	s.lock[blocking].Unlock()
+	s.numActiveTasks.Add(-1)
+	return true
+}
+
+// FinishExecution - returns validation task of same version, if it can be done by same worker right away
+func (s *Scheduler) FinishExecution(v Version, wroteNewLocation bool) (next Version, kind TaskKind, ok bool) {
+	s.lock[v.TxIndex].Lock()
+	s.status[v.TxIndex] = statusExecuted
+	deps := s.dependencies[v.TxIndex]
+	s.dependencies[v.TxIndex] = nil
+	s.lock[v.TxIndex].Unlock()
+	s.resumeDependencies(deps)
+
+	if s.validationIdx.Load() > int64(v.TxIndex) { // higher txs are validated already, this one - not yet
+		if !wroteNewLocation {
+			return v, TaskValidation, true
+		}
+		s.decreaseValidationIdx(int64(v.TxIndex))
+	}
+	s.numActiveTasks.Add(-1)
+	return Version{}, 0, false
+}
+
+// TryValidationAbort - only one of concurrent validations of version aborts it
+func (s *Scheduler) TryValidationAbort(v Version) bool {
+	s.lock[v.TxIndex].Lock()
+	defer s.lock[v.TxIndex].Unlock()
+	if s.incarnation[v.TxIndex] == v.Incarnation && s.status[v.TxIndex] == statusExecuted {
+		s.status[v.TxIndex] = statusAborting
+		return true
+	}
+	return false
+}
+
+// FinishValidation - returns re-execution task of aborted tx, if it can be done by same worker right away
+func (s *Scheduler) FinishValidation(txIndex int, aborted bool) (next Version, kind TaskKind, ok bool) {
+	if aborted {
+		s.setReadyStatus(txIndex)
+		s.decreaseValidationIdx(int64(txIndex) + 1)
+		if s.executionIdx.Load() > int64(txIndex) {
+			if next, ok = s.tryIncarnate(int64(txIndex)); ok {
+				return next, TaskExecution, true
+			}
+			return Version{}, 0, false // tryIncarnate did decrement active tasks
+		}
+	}
+	s.numActiveTasks.Add(-1)
+	return Version{}, 0, false
+}
+
+func (s *Scheduler) decreaseExecutionIdx(target int64) {
+	for cur := s.executionIdx.Load(); cur > target && !s.executionIdx.CompareAndSwap(cur, target); cur = s.executionIdx.Load() {
+	}
+	s.decreaseCnt.Add(1)
+}
+
+func (s *Scheduler) decreaseValidationIdx(target int64) {
+	for cur := s.validationIdx.Load(); cur > target && !s.validationIdx.CompareAndSwap(cur, target); cur = s.validationIdx.Load() {
+	}
+	s.decreaseCnt.Add(1)
+}
+
+func (s *Scheduler) checkDone() {
+	observed := s.decreaseCnt.Load()
+	if s.executionIdx.Load() >= s.txCount && s.validationIdx.Load() >= s.txCount && s.numActiveTasks.Load() == 0 && observed == s.decreaseCnt.Load() {
+		s.done.Store(true)
+	}
+}
+
+func (s *Scheduler) tryIncarnate(txIndex int64) (Version, bool) {
+	if txIndex < s.txCount {
+		s.lock[txIndex].Lock()
+		if s.status[txIndex] == statusReadyToExecute {
+			s.status[txIndex] = statusExecuting
+			v := Version{TxIndex: int(txIndex), Incarnation: s.incarnation[txIndex]}
+			s.lock[txIndex].Unlock()
+			return v, true
+		}
+		s.lock[txIndex].Unlock()
+	}
+	s.numActiveTasks.Add(-1)
+	return Version{}, false
+}
+
+func (s *Scheduler) nextVersionToExecute() (Version, bool) {
+	if s.executionIdx.Load() >= s.txCount {
+		s.checkDone()
+		return Version{}, false
+	}
+	s.numActiveTasks.Add(1)
+	return s.tryIncarnate(s.executionIdx.Add(1) - 1)
+}
+
+func (s *Scheduler) nextVersionToValidate() (Version, bool) {
+	if s.validationIdx.Load() >= s.txCount {
+		s.checkDone()
+		return Version{}, false
+	}
+	s.numActiveTasks.Add(1)
+	if idx := s.validationIdx.Add(1) - 1; idx < s.txCount {
+		s.lock[idx].Lock()
+		status, incarnation := s.status[idx], s.incarnation[idx]
+		s.lock[idx].Unlock()
+		if status == statusExecuted {
+			return Version{TxIndex: int(idx), Incarnation: incarnation}, true
+		}
+	}
+	s.numActiveTasks.Add(-1)
+	return Version{}, false
+}
+
+func (s *Scheduler) setReadyStatus(txIndex int) {
+	s.lock[txIndex].Lock()
+	s.incarnation[txIndex]++
+	s.status[txIndex] = statusReadyToExecute
+	s.lock[txIndex].Unlock()
+}
+
+func (s *Scheduler) resumeDependencies(deps []int) {
+	if len(deps) == 0 {
+		return
+	}
+	minDep := deps[0]
+	for _, d := range deps {
+		s.setReadyStatus(d)
+		if d < minDep {
+			minDep = d
+		}
+	}
+	s.decreaseExecutionIdx(int64(minDep))
+}
+
+// MVView - reads of one incarnation of tx. Keys not written by lower txs of block are read from `state`.
+type MVView struct {
+	mv      *MVMemory
+	txIndex int
+	reads   []ReadDescriptor
+	state   func(table, key string) ([]byte, error)
+}
+
+// Read - *DependencyError must be returned by execute func of ExecuteBlockSTM as is
+func (v *MVView) Read(table, key string) ([]byte, error) {
+	d, val, ok, err := v.mv.Read(table, key, v.txIndex)
+	if err != nil {
+		return nil, err
+	}
+	v.reads = append(v.reads, d)
+	if ok {
+		return val, nil
+	}
+	return v.state(table, key)
+}
+
+// ExecuteFunc - executes tx `v.TxIndex` reading state only by `view`. Returned error (except *DependencyError)
+// is result of tx like its writes: it's final only if read set of tx is valid.
+type ExecuteFunc func(v Version, view *MVView) ([]VersionedWrite, error)
+
+// ExecuteBlockSTM - executes `txCount` txs of block by `workers` in parallel. `state` - reads of state before block
+// (StateV3/DB), must be thread-safe. Returns memory with final write sets of txs (see MVMemory.TxWrites), which
+// are same as serial execution would produce.
+func ExecuteBlockSTM(ctx context.Context, txCount, workers int, state func(table, key string) ([]byte, error), execute ExecuteFunc) (*MVMemory, error) {
+	mv, s := NewMVMemory(txCount), NewScheduler(txCount)
+	txErrs := make([]error, txCount) // of last incarnation
+
+	tryExecute := func(v Version) (next Version, kind TaskKind, ok bool) {
+		for {
+			view := &MVView{mv: mv, txIndex: v.TxIndex, state: state}
+			writes, err := execute(v, view)
+			var dep *DependencyError
+			if errors.As(err, &dep) {
+				if s.AddDependency(v.TxIndex, dep.TxIndex) {
+					return Version{}, 0, false
+				}
+				continue // dependency is resolved already
+			}
+			txErrs[v.TxIndex] = err
+			return s.FinishExecution(v, mv.Record(v, view.reads, writes))
+		}
+	}
+	needsReexecution := func(v Version) (next Version, kind TaskKind, ok bool) {
+		aborted := !mv.ValidateReadSet(v.TxIndex) && s.TryValidationAbort(v)
+		if aborted {
+			mv.ConvertWritesToEstimates(v.TxIndex)
+		}
+		return s.FinishValidation(v.TxIndex, aborted)
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			var v Version
+			var kind TaskKind
+			var ok bool
+			for !s.Done() {
+				select {
+				case <-gCtx.Done():
+					return gCtx.Err()
+				default:
+				}
+				if ok {
+					if kind == TaskExecution {
+						v, kind, ok = tryExecute(v)
+					} else {
+						v, kind, ok = needsReexecution(v)
+					}
+					continue
+				}
+				if v, kind, ok = s.NextTask(); !ok {
+					runtime.Gosched()
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	for i, err := range txErrs {
+		if err != nil {
+			return mv, fmt.Errorf("tx %d: %w", i, err)
+		}
+	}
+	return mv, nil
+}
@@ -0,0 +1,126 @@
+package exec22
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func u64(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func enc(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// tx `i` adds `i` to counter and to one of 4 accounts, and moves balance of account it reads to next account
+func testTx(i int, read func(table, key string) ([]byte, error)) ([]VersionedWrite, error) {
+	counter, err := read("t", "counter")
+	if err != nil {
+		return nil, err
+	}
+	from, to := fmt.Sprintf("acc%d", i%4), fmt.Sprintf("acc%d", (i+1)%4)
+	fromBal, err := read("t", from)
+	if err != nil {
+		return nil, err
+	}
+	toBal, err := read("t", to)
+	if err != nil {
+		return nil, err
+	}
+	return []VersionedWrite{
+		{Table: "t", Key: "counter", Val: enc(u64(counter) + uint64(i))},
+		{Table: "t", Key: from, Val: enc(0)},
+		{Table: "t", Key: to, Val: enc(u64(toBal) + u64(fromBal) + uint64(i))},
+	}, nil
+}
+
+func TestExecuteBlockSTM(t *testing.T) {
+	initial := map[string][]byte{"counter": enc(100), "acc0": enc(1), "acc1": enc(2), "acc2": enc(3), "acc3": enc(4)}
+	state := func(table, key string) ([]byte, error) { return initial[key], nil }
+
+	for _, txCount := range []int{0, 1, 7, 200} {
+		// serial
+		expect := map[string][]byte{}
+		for k, v := range initial {
+			expect[k] = v
+		}
+		for i := 0; i < txCount; i++ {
+			writes, err := testTx(i, func(table, key string) ([]byte, error) { return expect[key], nil })
+			require.NoError(t, err)
+			for _, w := range writes {
+				expect[w.Key] = w.Val
+			}
+		}
+
+		for _, workers := range []int{1, 4, 16} {
+			mv, err := ExecuteBlockSTM(context.Background(), txCount, workers, state, func(v Version, view *MVView) ([]VersionedWrite, error) {
+				return testTx(v.TxIndex, view.Read)
+			})
+			require.NoError(t, err)
+			got := map[string][]byte{}
+			for k, v := range initial {
+				got[k] = v
+			}
+			for i := 0; i < txCount; i++ {
+				for _, w := range mv.TxWrites(i) {
+					got[w.Key] = w.Val
+				}
+			}
+			require.Equal(t, expect, got, "txs=%d, workers=%d", txCount, workers)
+		}
+	}
+}
+
+func TestExecuteBlockSTMTxError(t *testing.T) {
+	state := func(table, key string) ([]byte, error) { return nil, nil }
+	errBadTx := errors.New("bad tx")
+	_, err := ExecuteBlockSTM(context.Background(), 10, 4, state, func(v Version, view *MVView) ([]VersionedWrite, error) {
+		if _, err := view.Read("t", "k"); err != nil {
+			return nil, err
+		}
+		if v.TxIndex == 5 {
+			return nil, errBadTx
+		}
+		return []VersionedWrite{{Table: "t", Key: "k", Val: enc(uint64(v.TxIndex))}}, nil
+	})
+	require.ErrorIs(t, err, errBadTx)
+}
+
+func TestMVMemory(t *testing.T) {
+	mv := NewMVMemory(3)
+	require.False(t, mv.Record(Version{TxIndex: 0}, nil, nil))
+	require.True(t, mv.Record(Version{TxIndex: 0}, nil, []VersionedWrite{{Table: "t", Key: "a", Val: []byte{1}}}))
+
+	d, v, ok, err := mv.Read("t", "a", 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{1}, v)
+	require.Equal(t, ReadDescriptor{Table: "t", Key: "a", Kind: ReadFromTx, Version: Version{TxIndex: 0}}, d)
+	_, _, ok, err = mv.Read("t", "a", 0) // own and higher writes are not visible
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	mv.Record(Version{TxIndex: 2}, []ReadDescriptor{d}, nil)
+	require.True(t, mv.ValidateReadSet(2))
+
+	mv.ConvertWritesToEstimates(0)
+	_, _, _, err = mv.Read("t", "a", 2)
+	var dep *DependencyError
+	require.ErrorAs(t, err, &dep)
+	require.Equal(t, 0, dep.TxIndex)
+	require.False(t, mv.ValidateReadSet(2))
+
+	// re-execution doesn't write `a` anymore
+	require.False(t, mv.Record(Version{TxIndex: 0, Incarnation: 1}, nil, nil))
+	_, _, ok, err = mv.Read("t", "a", 2)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.False(t, mv.ValidateReadSet(2))
+}
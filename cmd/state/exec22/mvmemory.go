@@ -0,0 +1,180 @@
+package exec22
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Block-STM (https://arxiv.org/abs/2203.06871) - optimistic parallel execution of txs of one block:
+//   - MVMemory - multi-version memory: every key has values written by txs of block, by tx index. Tx reads value
+//     of highest lower tx which wrote key, or state before block if no one wrote it
+//   - read set of tx is set of versions it has read; it's valid while re-reading gives same versions
+//   - Scheduler - which tx to execute or validate next, and re-execution of txs with invalid read set
+//
+// Unlike ReadsValid (which compares values read by tx with StateV3 when tx is applied), conflicts are detected
+// inside of block, before any tx of block is applied, so txs of block see writes of each other.
+
+// Version - tx which wrote value: index of tx in block and number of its re-execution
+type Version struct {
+	TxIndex     int
+	Incarnation int
+}
+
+type ReadKind uint8
+
+const (
+	ReadFromState ReadKind = iota // no lower tx of block wrote key: value from StateV3/DB
+	ReadFromTx                    // value written by lower tx of block
+)
+
+// ReadDescriptor - one read of tx: what kind of value it has seen
+type ReadDescriptor struct {
+	Table, Key string
+	Kind       ReadKind
+	Version    Version // of ReadFromTx
+}
+
+type VersionedWrite struct {
+	Table, Key string
+	Val        []byte
+}
+
+// DependencyError - tx read value which lower tx is going to re-write (value is ESTIMATE): tx must wait for
+// re-execution of TxIndex
+type DependencyError struct {
+	TxIndex int
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("read of estimate: depends on tx %d", e.TxIndex)
+}
+
+type mvEntry struct {
+	txIndex     int
+	incarnation int
+	estimate    bool
+	val         []byte
+}
+
+// MVMemory - multi-version memory of one block. Thread-safe.
+type MVMemory struct {
+	lock        sync.RWMutex
+	data        map[string][]mvEntry // table+key -> writes sorted by txIndex
+	lastWritten [][]string           // txIndex -> keys written by last incarnation
+	lastReads   [][]ReadDescriptor   // txIndex -> read set of last incarnation
+	txWrites    [][]VersionedWrite   // txIndex -> write set of last incarnation
+}
+
+func NewMVMemory(txCount int) *MVMemory {
+	return &MVMemory{
+		data:        map[string][]mvEntry{},
+		lastWritten: make([][]string, txCount),
+		lastReads:   make([][]ReadDescriptor, txCount),
+		txWrites:    make([][]VersionedWrite, txCount),
+	}
+}
+
+func mvKey(table, key string) string { return table + "\x00" + key }
+
+// Read - value of key as seen by tx `txIndex`. ok=false - no lower tx wrote key: caller reads state before block.
+// *DependencyError - lower tx which wrote key is being re-executed.
+func (mv *MVMemory) Read(table, key string, txIndex int) (d ReadDescriptor, val []byte, ok bool, err error) {
+	mv.lock.RLock()
+	defer mv.lock.RUnlock()
+	d = ReadDescriptor{Table: table, Key: key, Kind: ReadFromState}
+	entries := mv.data[mvKey(table, key)]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].txIndex >= txIndex }) - 1
+	if i < 0 {
+		return d, nil, false, nil
+	}
+	e := entries[i]
+	if e.estimate {
+		return d, nil, false, &DependencyError{TxIndex: e.txIndex}
+	}
+	d.Kind, d.Version = ReadFromTx, Version{TxIndex: e.txIndex, Incarnation: e.incarnation}
+	return d, e.val, true, nil
+}
+
+// Record - result of execution of `v`. wroteNewLocation - tx wrote key which previous incarnation didn't write:
+// higher txs must be validated again.
+func (mv *MVMemory) Record(v Version, reads []ReadDescriptor, writes []VersionedWrite) (wroteNewLocation bool) {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	prev := make(map[string]struct{}, len(mv.lastWritten[v.TxIndex]))
+	for _, k := range mv.lastWritten[v.TxIndex] {
+		prev[k] = struct{}{}
+	}
+	written := make([]string, 0, len(writes))
+	for _, w := range writes {
+		k := mvKey(w.Table, w.Key)
+		written = append(written, k)
+		if _, ok := prev[k]; ok {
+			delete(prev, k)
+		} else {
+			wroteNewLocation = true
+		}
+		mv.put(k, mvEntry{txIndex: v.TxIndex, incarnation: v.Incarnation, val: w.Val})
+	}
+	for k := range prev { // not written by this incarnation anymore
+		mv.remove(k, v.TxIndex)
+	}
+	mv.lastWritten[v.TxIndex] = written
+	mv.lastReads[v.TxIndex] = reads
+	mv.txWrites[v.TxIndex] = writes
+	return wroteNewLocation
+}
+
+// ConvertWritesToEstimates - tx is aborted: readers of its values must wait for its re-execution
+func (mv *MVMemory) ConvertWritesToEstimates(txIndex int) {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	for _, k := range mv.lastWritten[txIndex] {
+		entries := mv.data[k]
+		if i := sort.Search(len(entries), func(i int) bool { return entries[i].txIndex >= txIndex }); i < len(entries) && entries[i].txIndex == txIndex {
+			entries[i].estimate = true
+		}
+	}
+}
+
+// ValidateReadSet - re-read all keys of last read set of tx: false if any read would see other version now
+func (mv *MVMemory) ValidateReadSet(txIndex int) bool {
+	mv.lock.RLock()
+	reads := mv.lastReads[txIndex]
+	mv.lock.RUnlock()
+	for _, r := range reads {
+		d, _, _, err := mv.Read(r.Table, r.Key, txIndex)
+		if err != nil || d.Kind != r.Kind || d.Version != r.Version {
+			return false
+		}
+	}
+	return true
+}
+
+// TxWrites - write set of last incarnation of tx. After Scheduler.Done - final writes of tx, to apply in tx order.
+func (mv *MVMemory) TxWrites(txIndex int) []VersionedWrite {
+	mv.lock.RLock()
+	defer mv.lock.RUnlock()
+	return mv.txWrites[txIndex]
+}
+
+func (mv *MVMemory) put(k string, e mvEntry) {
+	entries := mv.data[k]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].txIndex >= e.txIndex })
+	if i < len(entries) && entries[i].txIndex == e.txIndex {
+		entries[i] = e
+		return
+	}
+	entries = append(entries, mvEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	mv.data[k] = entries
+}
+
+func (mv *MVMemory) remove(k string, txIndex int) {
+	entries := mv.data[k]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].txIndex >= txIndex })
+	if i < len(entries) && entries[i].txIndex == txIndex {
+		mv.data[k] = append(entries[:i], entries[i+1:]...)
+	}
+}
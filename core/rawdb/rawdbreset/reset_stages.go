@@ -210,10 +210,12 @@ var stateHistoryV3Buckets = []string{
 	kv.TblLogTopicsKeys, kv.TblLogTopicsIdx,
 	kv.TblTracesFromKeys, kv.TblTracesFromIdx,
 	kv.TblTracesToKeys, kv.TblTracesToIdx,
+	kv.TblTxLookupKeys, kv.TblTxLookupIdx,
+	kv.TblPruningProgress,
 }
 var stateHistoryV4Buckets = []string{
 	kv.TblAccountKeys, kv.TblStorageKeys, kv.TblCodeKeys,
-	kv.TblCommitmentKeys, kv.TblCommitmentVals, kv.TblCommitmentHistoryKeys, kv.TblCommitmentHistoryVals, kv.TblCommitmentIdx,
+	kv.TblCommitmentKeys, kv.TblCommitmentVals, kv.TblCommitmentHistoryKeys, kv.TblCommitmentHistoryVals, kv.TblCommitmentIdx, kv.TblCommitmentState,
 }
 
 func clearStageProgress(tx kv.RwTx, stagesList ...stages.SyncStage) error {
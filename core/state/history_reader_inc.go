@@ -107,7 +107,7 @@ func (hr *HistoryReaderInc) ReadAccountData(address common.Address) (*accounts.A
 		return nil, nil
 	}
 	var a accounts.Account
-	if err = accounts.DeserialiseV3(&a, enc); err != nil {
+	if err = accounts.Deserialise(hr.as.AccountCodec(), &a, enc); err != nil {
 		return nil, err
 	}
 	if hr.trace {
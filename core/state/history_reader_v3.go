@@ -6,6 +6,9 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon/core/state/temporal"
 	"github.com/ledgerwatch/erigon/core/types/accounts"
 	"github.com/ledgerwatch/erigon/eth/ethconfig"
 )
@@ -15,6 +18,7 @@ type HistoryReaderV3 struct {
 	txNum uint64
 	trace bool
 	ttx   kv.TemporalTx
+	codec libtypes.AccountCodec // of ttx, resolved on first read
 }
 
 func NewHistoryReaderV3() *HistoryReaderV3 {
@@ -23,7 +27,7 @@ func NewHistoryReaderV3() *HistoryReaderV3 {
 
 func (hr *HistoryReaderV3) SetTx(tx kv.Tx) {
 	if ttx, casted := tx.(kv.TemporalTx); casted {
-		hr.ttx = ttx
+		hr.ttx, hr.codec = ttx, nil
 	} else {
 		panic("why")
 	}
@@ -39,8 +43,13 @@ func (hr *HistoryReaderV3) ReadAccountData(address common.Address) (*accounts.Ac
 		}
 		return nil, err
 	}
+	if hr.codec == nil {
+		if hr.codec, err = AccountCodec(hr.ttx); err != nil {
+			return nil, err
+		}
+	}
 	var a accounts.Account
-	if err := accounts.DeserialiseV3(&a, enc); err != nil {
+	if err := accounts.Deserialise(hr.codec, &a, enc); err != nil {
 		return nil, fmt.Errorf("ReadAccountData(%x): %w", address, err)
 	}
 	if hr.trace {
@@ -266,3 +275,12 @@ func (s *PlainState) ForEachStorage(addr common.Address, startLocation common.Ha
 	return innerErr
 }
 */
+
+// AccountCodec - encoding of accounts history of db of `tx`: codec of aggregator for temporal tx,
+// codec persisted in db config otherwise (for example: remote tx of rpcdaemon)
+func AccountCodec(tx kv.Tx) (libtypes.AccountCodec, error) {
+	if ttx, ok := tx.(*temporal.Tx); ok {
+		return ttx.AggCtx().AccountCodec(), nil
+	}
+	return libstate.ReadAccountCodec(tx)
+}
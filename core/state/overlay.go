@@ -0,0 +1,127 @@
+package state
+
+import (
+	"github.com/holiman/uint256"
+
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+var _ StateReader = (*OverlayState)(nil)
+var _ StateWriter = (*OverlayState)(nil)
+
+// OverlayState - in-memory layer on top of any StateReader (latest, historical, ...). It's StateReader and StateWriter
+// at the same time: writes stay in memory and never reach underlying reader, reads see own writes first.
+//
+// Use-case: simulation of bundles of transactions (eth_callMany) - each bundle is executed on own IntraBlockState
+// and committed to overlay by IntraBlockState.CommitBlock - then next bundle sees its changes.
+// Not thread-safe.
+type OverlayState struct {
+	base StateReader
+
+	accounts     map[libcommon.Address]*accounts.Account // nil value - account deleted
+	storage      map[libcommon.Address]map[libcommon.Hash][]byte
+	cleared      map[libcommon.Address]struct{} // storage of base reader is not visible: account was deleted or storage replaced
+	code         map[libcommon.Hash][]byte
+	incarnations map[libcommon.Address]uint64 // incarnations of deleted accounts
+}
+
+func NewOverlayState(base StateReader) *OverlayState {
+	return &OverlayState{
+		base:         base,
+		accounts:     map[libcommon.Address]*accounts.Account{},
+		storage:      map[libcommon.Address]map[libcommon.Hash][]byte{},
+		cleared:      map[libcommon.Address]struct{}{},
+		code:         map[libcommon.Hash][]byte{},
+		incarnations: map[libcommon.Address]uint64{},
+	}
+}
+
+func (o *OverlayState) ReadAccountData(address libcommon.Address) (*accounts.Account, error) {
+	if a, ok := o.accounts[address]; ok {
+		if a == nil {
+			return nil, nil
+		}
+		var cpy accounts.Account
+		cpy.Copy(a)
+		return &cpy, nil
+	}
+	return o.base.ReadAccountData(address)
+}
+
+func (o *OverlayState) ReadAccountStorage(address libcommon.Address, incarnation uint64, key *libcommon.Hash) ([]byte, error) {
+	if v, ok := o.storage[address][*key]; ok {
+		if len(v) == 0 {
+			return nil, nil
+		}
+		return v, nil
+	}
+	if _, ok := o.cleared[address]; ok {
+		return nil, nil
+	}
+	return o.base.ReadAccountStorage(address, incarnation, key)
+}
+
+func (o *OverlayState) ReadAccountCode(address libcommon.Address, incarnation uint64, codeHash libcommon.Hash) ([]byte, error) {
+	if code, ok := o.code[codeHash]; ok {
+		return code, nil
+	}
+	return o.base.ReadAccountCode(address, incarnation, codeHash)
+}
+
+func (o *OverlayState) ReadAccountCodeSize(address libcommon.Address, incarnation uint64, codeHash libcommon.Hash) (int, error) {
+	if code, ok := o.code[codeHash]; ok {
+		return len(code), nil
+	}
+	return o.base.ReadAccountCodeSize(address, incarnation, codeHash)
+}
+
+func (o *OverlayState) ReadAccountIncarnation(address libcommon.Address) (uint64, error) {
+	if inc, ok := o.incarnations[address]; ok {
+		return inc, nil
+	}
+	return o.base.ReadAccountIncarnation(address)
+}
+
+func (o *OverlayState) UpdateAccountData(address libcommon.Address, original, account *accounts.Account) error {
+	a := new(accounts.Account)
+	a.Copy(account)
+	o.accounts[address] = a
+	return nil
+}
+
+func (o *OverlayState) UpdateAccountCode(address libcommon.Address, incarnation uint64, codeHash libcommon.Hash, code []byte) error {
+	o.code[codeHash] = libcommon.Copy(code)
+	return nil
+}
+
+func (o *OverlayState) DeleteAccount(address libcommon.Address, original *accounts.Account) error {
+	o.accounts[address] = nil
+	o.ClearStorage(address)
+	if original.Incarnation > 0 {
+		o.incarnations[address] = original.Incarnation
+	}
+	return nil
+}
+
+func (o *OverlayState) WriteAccountStorage(address libcommon.Address, incarnation uint64, key *libcommon.Hash, original, value *uint256.Int) error {
+	m, ok := o.storage[address]
+	if !ok {
+		m = map[libcommon.Hash][]byte{}
+		o.storage[address] = m
+	}
+	m[*key] = value.Bytes()
+	return nil
+}
+
+func (o *OverlayState) CreateContract(address libcommon.Address) error {
+	o.ClearStorage(address)
+	return nil
+}
+
+// ClearStorage - makes all storage of account empty, for example to replace it by caller-supplied one
+func (o *OverlayState) ClearStorage(address libcommon.Address) {
+	delete(o.storage, address)
+	o.cleared[address] = struct{}{}
+}
@@ -0,0 +1,67 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/chain"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayState(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	rules := &chain.Rules{}
+	addr, contract := libcommon.HexToAddress("0x1"), libcommon.HexToAddress("0x2")
+	key := libcommon.HexToHash("0x3")
+
+	// base state
+	ibs := New(NewPlainStateReader(tx))
+	ibs.AddBalance(addr, uint256.NewInt(10))
+	ibs.CreateAccount(contract, true)
+	ibs.SetCode(contract, []byte{0x1})
+	ibs.SetState(contract, &key, *uint256.NewInt(1))
+	require.NoError(t, ibs.CommitBlock(rules, NewPlainStateWriterNoHistory(tx)))
+
+	overlay := NewOverlayState(NewPlainStateReader(tx))
+	ibs = New(overlay)
+	ibs.AddBalance(addr, uint256.NewInt(5))
+	ibs.SetState(contract, &key, *uint256.NewInt(2))
+	require.NoError(t, ibs.CommitBlock(rules, overlay))
+
+	// changes are visible to next IntraBlockState on top of overlay, but not in db
+	var v uint256.Int
+	ibs = New(overlay)
+	require.Equal(t, uint64(15), ibs.GetBalance(addr).Uint64())
+	ibs.GetState(contract, &key, &v)
+	require.Equal(t, uint64(2), v.Uint64())
+	require.Equal(t, []byte{0x1}, ibs.GetCode(contract))
+
+	ibs = New(NewPlainStateReader(tx))
+	require.Equal(t, uint64(10), ibs.GetBalance(addr).Uint64())
+	ibs.GetState(contract, &key, &v)
+	require.Equal(t, uint64(1), v.Uint64())
+
+	// selfdestruct hides account and its storage of underlying state
+	ibs = New(overlay)
+	ibs.Selfdestruct(contract)
+	require.NoError(t, ibs.CommitBlock(rules, overlay))
+	acc, err := overlay.ReadAccountData(contract)
+	require.NoError(t, err)
+	require.Nil(t, acc)
+	enc, err := overlay.ReadAccountStorage(contract, 1, &key)
+	require.NoError(t, err)
+	require.Nil(t, enc)
+	inc, err := overlay.ReadAccountIncarnation(contract)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), inc)
+
+	// replaced storage
+	other := libcommon.HexToHash("0x4")
+	overlay.ClearStorage(contract)
+	require.NoError(t, overlay.WriteAccountStorage(contract, 2, &other, nil, uint256.NewInt(7)))
+	enc, err = overlay.ReadAccountStorage(contract, 2, &other)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7}, enc)
+}
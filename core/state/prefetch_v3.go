@@ -0,0 +1,166 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/dbutils"
+	types2 "github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+// PrefetcherV3 - reads keys which next block is likely to touch (senders, recipients, access lists), concurrently
+// with execution of current block. Keys are pre-sorted and read by ReadLatestMulti. Values are not kept: StateReaderV3 reads state as usual - from StateV3 or DB,
+// but pages of DB are already in page cache, and workers don't wait for disk on cold accounts.
+type PrefetcherV3 struct {
+	db     kv.RoDB
+	hints  chan types2.AccessList
+	wg     sync.WaitGroup
+	logger log.Logger
+}
+
+func NewPrefetcherV3(ctx context.Context, db kv.RoDB, logger log.Logger) *PrefetcherV3 {
+	p := &PrefetcherV3{db: db, hints: make(chan types2.AccessList, 1), logger: logger}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for hints := range p.hints {
+			if err := p.prefetch(ctx, hints); err != nil {
+				p.logger.Debug("[exec] prefetch", "err", err)
+			}
+		}
+	}()
+	return p
+}
+
+// Hint - doesn't block: if previous hints are not read yet - new ones are dropped
+func (p *PrefetcherV3) Hint(hints types2.AccessList) {
+	if p == nil || len(hints) == 0 {
+		return
+	}
+	select {
+	case p.hints <- hints:
+	default:
+	}
+}
+
+func (p *PrefetcherV3) Close() {
+	if p == nil {
+		return
+	}
+	close(p.hints)
+	p.wg.Wait()
+}
+
+func (p *PrefetcherV3) prefetch(ctx context.Context, hints types2.AccessList) error {
+	return p.db.View(ctx, func(tx kv.Tx) error {
+		addrs := make([][]byte, 0, len(hints))
+		for i := range hints {
+			addrs = append(addrs, hints[i].Address[:])
+		}
+		sortKeys(addrs)
+		encs, err := ReadLatestMulti(ctx, tx, kv.PlainState, addrs)
+		if err != nil {
+			return err
+		}
+		incarnations := make(map[string]uint64, len(addrs))
+		var codeHashes [][]byte
+		var acc accounts.Account
+		for i, enc := range encs {
+			if len(enc) == 0 {
+				continue
+			}
+			if err := acc.DecodeForStorage(enc); err != nil {
+				return err
+			}
+			incarnations[string(addrs[i])] = acc.Incarnation
+			if !acc.IsEmptyCodeHash() {
+				codeHashes = append(codeHashes, common.Copy(acc.CodeHash[:]))
+			}
+		}
+		sortKeys(codeHashes)
+		if _, err = ReadLatestMulti(ctx, tx, kv.Code, codeHashes); err != nil {
+			return err
+		}
+
+		var storageKeys [][]byte
+		for _, h := range hints {
+			incarnation, ok := incarnations[string(h.Address[:])]
+			if !ok {
+				continue
+			}
+			for _, key := range h.StorageKeys {
+				storageKeys = append(storageKeys, dbutils.PlainGenerateCompositeStorageKey(h.Address[:], incarnation, key[:]))
+			}
+		}
+		sortKeys(storageKeys)
+		_, err = ReadLatestMulti(ctx, tx, kv.PlainState, storageKeys)
+		return err
+	})
+}
+
+func sortKeys(keys [][]byte) {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+}
+
+// ReadLatestMulti - latest values of sorted `keys` of DB table (PlainState, Code, ...), nil - key not found.
+// One cursor walks keys in order once: neighbour keys share pages of DB, and key which falls before the position of
+// previous seek is known to be absent without another seek. Duplicates are allowed. Values are valid until `tx` end.
+func ReadLatestMulti(ctx context.Context, tx kv.Tx, table string, keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return vals, nil
+	}
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var k, v []byte
+	for i, key := range keys {
+		if i > 0 && bytes.Compare(keys[i-1], key) > 0 {
+			return nil, fmt.Errorf("ReadLatestMulti: keys are not sorted: %x > %x", keys[i-1], key)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if i == 0 || (k != nil && bytes.Compare(k, key) < 0) {
+			if k, v, err = c.Seek(key); err != nil {
+				return nil, err
+			}
+		}
+		if k != nil && bytes.Equal(k, key) {
+			vals[i] = v
+		}
+	}
+	return vals, nil
+}
+
+// BlockPrefetchHints - accounts (and storage keys of access lists) which execution of `b` is likely to touch.
+// Senders must be already recovered.
+func BlockPrefetchHints(b *types.Block) types2.AccessList {
+	txs := b.Transactions()
+	hints := make(types2.AccessList, 0, 2*len(txs)+1)
+	hints = append(hints, types2.AccessTuple{Address: b.Coinbase()})
+	for _, txn := range txs {
+		if sender, ok := txn.GetSender(); ok {
+			hints = append(hints, types2.AccessTuple{Address: sender})
+		}
+		if to := txn.GetTo(); to != nil {
+			hints = append(hints, types2.AccessTuple{Address: *to})
+		}
+		hints = append(hints, txn.GetAccessList()...)
+	}
+	return hints
+}
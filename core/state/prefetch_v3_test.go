@@ -0,0 +1,102 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/dbutils"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	types2 "github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+func TestBlockPrefetchHints(t *testing.T) {
+	coinbase, sender, to := common.Address{1}, common.Address{2}, common.Address{3}
+	plain := types.NewTransaction(0, to, uint256.NewInt(1), 21000, uint256.NewInt(1), nil)
+	plain.SetSender(sender)
+	withList := &types.AccessListTx{
+		LegacyTx:   types.LegacyTx{CommonTx: types.CommonTx{Gas: 50000, Value: uint256.NewInt(0)}, GasPrice: uint256.NewInt(1)},
+		ChainID:    uint256.NewInt(1),
+		AccessList: types2.AccessList{{Address: to, StorageKeys: []common.Hash{{7}}}},
+	}
+	b := types.NewBlock(&types.Header{Coinbase: coinbase}, []types.Transaction{plain, withList}, nil, nil, nil)
+
+	require.Equal(t, types2.AccessList{
+		{Address: coinbase},
+		{Address: sender},
+		{Address: to},
+		{Address: to, StorageKeys: []common.Hash{{7}}},
+	}, BlockPrefetchHints(b))
+}
+
+func TestPrefetcherV3(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	addr, key := common.Address{1}, common.Hash{2}
+	acc := accounts.NewAccount()
+	acc.Incarnation = 1
+	acc.CodeHash = common.Hash{3}
+	enc := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(enc)
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		if err := tx.Put(kv.PlainState, addr[:], enc); err != nil {
+			return err
+		}
+		if err := tx.Put(kv.Code, acc.CodeHash[:], []byte{0x60}); err != nil {
+			return err
+		}
+		return tx.Put(kv.PlainState, dbutils.PlainGenerateCompositeStorageKey(addr[:], acc.Incarnation, key[:]), []byte{1})
+	}))
+
+	p := NewPrefetcherV3(context.Background(), db, log.New())
+	require.NoError(t, p.prefetch(context.Background(), types2.AccessList{{Address: addr, StorageKeys: []common.Hash{key}}, {Address: common.Address{9}}}))
+	p.Hint(types2.AccessList{{Address: addr}})
+	p.Close()
+
+	var nilPrefetcher *PrefetcherV3
+	nilPrefetcher.Hint(types2.AccessList{{Address: addr}})
+	nilPrefetcher.Close()
+}
+
+func TestReadLatestMulti(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	ctx := context.Background()
+	addr1, addr2, addr3 := common.Address{1}, common.Address{2}, common.Address{3}
+	slot1 := dbutils.PlainGenerateCompositeStorageKey(addr1[:], 1, common.Hash{1}.Bytes())
+	slot2 := dbutils.PlainGenerateCompositeStorageKey(addr1[:], 1, common.Hash{2}.Bytes())
+	slot3 := dbutils.PlainGenerateCompositeStorageKey(addr1[:], 1, common.Hash{3}.Bytes())
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		for k, v := range map[string][]byte{string(addr1[:]): {1}, string(addr3[:]): {3}, string(slot1): {11}, string(slot3): {13}} {
+			if err := tx.Put(kv.PlainState, []byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	tx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	missed := common.Address{9}
+	keys := [][]byte{addr1[:], slot1, slot2, slot3, addr2[:], addr3[:], addr3[:], missed[:]}
+	vals, err := ReadLatestMulti(ctx, tx, kv.PlainState, keys)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{1}, {11}, nil, {13}, nil, {3}, {3}, nil}, vals)
+	for i, key := range keys { // same as one-by-one reads
+		v, err := tx.GetOne(kv.PlainState, key)
+		require.NoError(t, err)
+		require.Equal(t, v, vals[i], "key %x", key)
+	}
+
+	vals, err = ReadLatestMulti(ctx, tx, kv.PlainState, nil)
+	require.NoError(t, err)
+	require.Empty(t, vals)
+	_, err = ReadLatestMulti(ctx, tx, kv.PlainState, [][]byte{addr3[:], addr1[:]})
+	require.ErrorContains(t, err, "not sorted")
+}
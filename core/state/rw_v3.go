@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -23,6 +24,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/metrics"
 	libstate "github.com/ledgerwatch/erigon-lib/state"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/erigon/cmd/state/exec22"
 	"github.com/ledgerwatch/erigon/core/state/temporal"
 	"github.com/ledgerwatch/erigon/core/types/accounts"
@@ -34,9 +36,20 @@ const StorageTable = "Storage"
 
 var execTxsDone = metrics.NewCounter(`exec_txs_done`)
 
+// stateTables - tables of StateV3, in order of Flush
+var stateTables = []string{kv.PlainState, StorageTable, kv.Code, kv.PlainContractCode, kv.IncarnationMap}
+
+var stateSizeGauges = map[string]metrics.Gauge{
+	kv.PlainState:        metrics.NewGauge(`exec_state_size{table="accounts"}`),
+	StorageTable:         metrics.NewGauge(`exec_state_size{table="storage"}`),
+	kv.Code:              metrics.NewGauge(`exec_state_size{table="code"}`),
+	kv.PlainContractCode: metrics.NewGauge(`exec_state_size{table="contract_code"}`),
+	kv.IncarnationMap:    metrics.NewGauge(`exec_state_size{table="incarnations"}`),
+}
+
 type StateV3 struct {
 	lock           sync.RWMutex
-	sizeEstimate   int
+	payloadSize    map[string]int // table -> size of keys and values, see SizeEstimate
 	chCode         map[string][]byte
 	chAccs         map[string][]byte
 	chStorage      *btree2.Map[string, []byte]
@@ -48,24 +61,38 @@ type StateV3 struct {
 	triggerLock  sync.Mutex
 
 	tmpdir              string
-	applyPrevAccountBuf []byte // buffer for ApplyState. Doesn't need mutex because Apply is single-threaded
-	addrIncBuf          []byte // buffer for ApplyState. Doesn't need mutex because Apply is single-threaded
+	applyPrevAccountBuf []byte    // buffer for ApplyState. Doesn't need mutex because Apply is single-threaded
+	addrIncBuf          []byte    // buffer for ApplyState. Doesn't need mutex because Apply is single-threaded
+	wal                 *StateWAL // optional, see SetWAL
+	accountCodec        libtypes.AccountCodec
 	logger              log.Logger
+
+	storageDels    []storageDel // storage history of SELFDESTRUCT-ed accounts in current block, see writeStorageDels
+	storageDelsAgg *libstate.AggregatorV3
 }
 
-func NewStateV3(tmpdir string, logger log.Logger) *StateV3 {
+// storageDel - account address + incarnation, deleted at txNum
+type storageDel struct {
+	addr  []byte
+	txNum uint64
+}
+
+// NewStateV3 - `accountCodec` is encoding of accounts history of datadir, see AggregatorV3.AccountCodec
+func NewStateV3(tmpdir string, accountCodec libtypes.AccountCodec, logger log.Logger) *StateV3 {
 	rs := &StateV3{
 		tmpdir:         tmpdir,
 		triggers:       map[uint64]*exec22.TxTask{},
 		senderTxNums:   map[common.Address]uint64{},
+		payloadSize:    map[string]int{},
 		chCode:         map[string][]byte{},
 		chAccs:         map[string][]byte{},
-		chStorage:      btree2.NewMap[string, []byte](128),
+		chStorage:      btree2.NewMap[string, []byte](stateBtreeDegree),
 		chIncs:         map[string][]byte{},
 		chContractCode: map[string][]byte{},
 
 		applyPrevAccountBuf: make([]byte, 256),
 		addrIncBuf:          make([]byte, 20+8),
+		accountCodec:        accountCodec,
 		logger:              logger,
 	}
 	return rs
@@ -76,44 +103,34 @@ func (rs *StateV3) put(table string, key, val []byte) {
 }
 
 func (rs *StateV3) puts(table string, key string, val []byte) {
+	var old []byte
+	var existed bool
 	switch table {
 	case StorageTable:
-		if old, ok := rs.chStorage.Set(key, val); ok {
-			rs.sizeEstimate += len(val) - len(old)
-		} else {
-			rs.sizeEstimate += len(key) + len(val)
-		}
+		old, existed = rs.chStorage.Set(key, val)
 	case kv.PlainState:
-		if old, ok := rs.chAccs[key]; ok {
-			rs.sizeEstimate += len(val) - len(old)
-		} else {
-			rs.sizeEstimate += len(key) + len(val)
-		}
+		old, existed = rs.chAccs[key]
 		rs.chAccs[key] = val
 	case kv.Code:
-		if old, ok := rs.chCode[key]; ok {
-			rs.sizeEstimate += len(val) - len(old)
-		} else {
-			rs.sizeEstimate += len(key) + len(val)
-		}
+		old, existed = rs.chCode[key]
 		rs.chCode[key] = val
 	case kv.IncarnationMap:
-		if old, ok := rs.chIncs[key]; ok {
-			rs.sizeEstimate += len(val) - len(old)
-		} else {
-			rs.sizeEstimate += len(key) + len(val)
-		}
+		old, existed = rs.chIncs[key]
 		rs.chIncs[key] = val
 	case kv.PlainContractCode:
-		if old, ok := rs.chContractCode[key]; ok {
-			rs.sizeEstimate += len(val) - len(old)
-		} else {
-			rs.sizeEstimate += len(key) + len(val)
-		}
+		old, existed = rs.chContractCode[key]
 		rs.chContractCode[key] = val
 	default:
 		panic(table)
 	}
+	if existed {
+		rs.payloadSize[table] += allocSize(len(val)) - allocSize(len(old))
+	} else {
+		rs.payloadSize[table] += allocSize(len(key)) + allocSize(len(val))
+	}
+	if rs.wal != nil {
+		rs.wal.put(table, key, val)
+	}
 }
 
 func (rs *StateV3) Get(table string, key []byte) (v []byte, ok bool) {
@@ -198,29 +215,88 @@ func (rs *StateV3) flushBtree(ctx context.Context, rwTx kv.RwTx, table string, m
 func (rs *StateV3) Flush(ctx context.Context, rwTx kv.RwTx, logPrefix string, logEvery *time.Ticker) error {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
-
-	if err := rs.flushMap(ctx, rwTx, kv.PlainState, rs.chAccs, logPrefix, logEvery); err != nil {
+	if err := rs.flushStorageDels(rwTx); err != nil {
 		return err
 	}
-	rs.chAccs = map[string][]byte{}
-	if err := rs.flushBtree(ctx, rwTx, kv.PlainState, rs.chStorage, logPrefix, logEvery); err != nil {
-		return err
+	for _, table := range stateTables {
+		if err := rs.flushTable(ctx, rwTx, table, logPrefix, logEvery); err != nil {
+			return err
+		}
 	}
-	rs.chStorage.Clear()
-	if err := rs.flushMap(ctx, rwTx, kv.Code, rs.chCode, logPrefix, logEvery); err != nil {
+	rs.sizeEstimate() // metrics
+	return nil
+}
+
+// FlushTable - writes one table of StateV3 (one of: kv.PlainState, StorageTable, kv.Code, kv.PlainContractCode,
+// kv.IncarnationMap) to `rwTx`, others stay in RAM. Reads of StateV3 go to RAM and then to DB, so `rwTx` must be
+// the tx of readers - it can't be committed until Flush.
+func (rs *StateV3) FlushTable(ctx context.Context, rwTx kv.RwTx, table string, logPrefix string, logEvery *time.Ticker) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if err := rs.flushStorageDels(rwTx); err != nil {
 		return err
 	}
-	rs.chCode = map[string][]byte{}
-	if err := rs.flushMap(ctx, rwTx, kv.PlainContractCode, rs.chContractCode, logPrefix, logEvery); err != nil {
+	if err := rs.flushTable(ctx, rwTx, table, logPrefix, logEvery); err != nil {
 		return err
 	}
-	rs.chContractCode = map[string][]byte{}
-	if err := rs.flushMap(ctx, rwTx, kv.IncarnationMap, rs.chIncs, logPrefix, logEvery); err != nil {
-		return err
+	rs.sizeEstimate() // metrics
+	return nil
+}
+
+// FlushLargest - FlushTable of largest tables until SizeEstimate is not above `target`. Returns flushed tables.
+func (rs *StateV3) FlushLargest(ctx context.Context, rwTx kv.RwTx, target uint64, logPrefix string, logEvery *time.Ticker) (flushed []string, err error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if err = rs.flushStorageDels(rwTx); err != nil {
+		return nil, err
+	}
+	for rs.sizeEstimate() > target {
+		var largest string
+		var largestSize uint64
+		for _, table := range stateTables {
+			if size := rs.tableSize(table); size > largestSize {
+				largest, largestSize = table, size
+			}
+		}
+		if err = rs.flushTable(ctx, rwTx, largest, logPrefix, logEvery); err != nil {
+			return flushed, err
+		}
+		flushed = append(flushed, largest)
 	}
-	rs.chIncs = map[string][]byte{}
+	return flushed, nil
+}
 
-	rs.sizeEstimate = 0
+func (rs *StateV3) flushTable(ctx context.Context, rwTx kv.RwTx, table string, logPrefix string, logEvery *time.Ticker) error {
+	switch table {
+	case kv.PlainState:
+		if err := rs.flushMap(ctx, rwTx, kv.PlainState, rs.chAccs, logPrefix, logEvery); err != nil {
+			return err
+		}
+		rs.chAccs = map[string][]byte{}
+	case StorageTable:
+		if err := rs.flushBtree(ctx, rwTx, kv.PlainState, rs.chStorage, logPrefix, logEvery); err != nil {
+			return err
+		}
+		rs.chStorage.Clear()
+	case kv.Code:
+		if err := rs.flushMap(ctx, rwTx, kv.Code, rs.chCode, logPrefix, logEvery); err != nil {
+			return err
+		}
+		rs.chCode = map[string][]byte{}
+	case kv.PlainContractCode:
+		if err := rs.flushMap(ctx, rwTx, kv.PlainContractCode, rs.chContractCode, logPrefix, logEvery); err != nil {
+			return err
+		}
+		rs.chContractCode = map[string][]byte{}
+	case kv.IncarnationMap:
+		if err := rs.flushMap(ctx, rwTx, kv.IncarnationMap, rs.chIncs, logPrefix, logEvery); err != nil {
+			return err
+		}
+		rs.chIncs = map[string][]byte{}
+	default:
+		panic(table)
+	}
+	delete(rs.payloadSize, table)
 	return nil
 }
 
@@ -309,9 +385,9 @@ func (rs *StateV3) writeStateHistory(roTx kv.Tx, txTask *exec22.TxTask, agg *lib
 			copy(addr1, addr)
 			binary.BigEndian.PutUint64(addr1[len(addr):], original.Incarnation)
 
-			prev := rs.applyPrevAccountBuf[:accounts.SerialiseV3Len(original)]
-			accounts.SerialiseV3To(original, prev)
-			if err := agg.AddAccountPrev(addr, prev); err != nil {
+			prev := rs.applyPrevAccountBuf[:accounts.SerialiseLen(rs.accountCodec, original)]
+			accounts.SerialiseTo(rs.accountCodec, original, prev)
+			if err := rs.addAccountPrev(agg, addr, prev); err != nil {
 				return err
 			}
 			codeHashBytes := original.CodeHash.Bytes()
@@ -323,48 +399,18 @@ func (rs *StateV3) writeStateHistory(roTx kv.Tx, txTask *exec22.TxTask, agg *lib
 					return err
 				}
 			}
-			if err := agg.AddCodePrev(addr, codePrev); err != nil {
+			if err := rs.addCodePrev(agg, addr, codePrev); err != nil {
 				return err
 			}
-			// Iterate over storage
-			var k, v []byte
-			_, _ = k, v
-			var e error
-			if k, v, e = cursor.Seek(addr1); err != nil {
-				return e
-			}
-			if !bytes.HasPrefix(k, addr1) {
-				k = nil
-			}
-			//TODO: try full-scan, then can replace btree by map
-			iter := rs.chStorage.Iter()
-			for ok := iter.Seek(string(addr1)); ok; ok = iter.Next() {
-				key := []byte(iter.Key())
-				if !bytes.HasPrefix(key, addr1) {
-					break
-				}
-				for ; e == nil && k != nil && bytes.HasPrefix(k, addr1) && bytes.Compare(k, key) <= 0; k, v, e = cursor.Next() {
-					if !bytes.Equal(k, key) {
-						// Skip the cursor item when the key is equal, i.e. prefer the item from the changes tree
-						if e = agg.AddStoragePrev(addr, k[28:], v); e != nil {
-							return e
-						}
-					}
-				}
-				if e != nil {
-					return e
-				}
-				if e = agg.AddStoragePrev(addr, key[28:], iter.Value()); e != nil {
-					break
-				}
-			}
-			for ; e == nil && k != nil && bytes.HasPrefix(k, addr1); k, v, e = cursor.Next() {
-				if e = agg.AddStoragePrev(addr, k[28:], v); e != nil {
-					return e
-				}
+			if dbg.LazySelfDestruct && rs.wal == nil {
+				rs.storageDels = append(rs.storageDels, storageDel{addr: common.Copy(addr1), txNum: txTask.TxNum})
+				rs.storageDelsAgg = agg
+				continue
 			}
-			if e != nil {
-				return e
+			if err := rs.walkStorage(cursor, addr1, func(k, v []byte) error {
+				return rs.addStoragePrev(agg, addr, k[28:], v)
+			}); err != nil {
+				return err
 			}
 		}
 	}
@@ -394,13 +440,87 @@ func (rs *StateV3) writeStateHistory(roTx kv.Tx, txTask *exec22.TxTask, agg *lib
 				}
 			}
 		}
-		if err := agg.AddCodePrev(addr, codePrev); err != nil {
+		if err := rs.addCodePrev(agg, addr, codePrev); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// walkStorage - storage of `addrInc` (address + incarnation): changes in RAM merged with `cursor` of kv.PlainState
+func (rs *StateV3) walkStorage(cursor kv.Cursor, addrInc []byte, walker func(k, v []byte) error) error {
+	k, v, e := cursor.Seek(addrInc)
+	if e != nil {
+		return e
+	}
+	if !bytes.HasPrefix(k, addrInc) {
+		k = nil
+	}
+	//TODO: try full-scan, then can replace btree by map
+	iter := rs.chStorage.Iter()
+	for ok := iter.Seek(string(addrInc)); ok; ok = iter.Next() {
+		key := []byte(iter.Key())
+		if !bytes.HasPrefix(key, addrInc) {
+			break
+		}
+		for ; e == nil && k != nil && bytes.HasPrefix(k, addrInc) && bytes.Compare(k, key) <= 0; k, v, e = cursor.Next() {
+			if !bytes.Equal(k, key) {
+				// Skip the cursor item when the key is equal, i.e. prefer the item from the changes tree
+				if e = walker(k, v); e != nil {
+					return e
+				}
+			}
+		}
+		if e != nil {
+			return e
+		}
+		if e = walker(key, iter.Value()); e != nil {
+			return e
+		}
+	}
+	for ; e == nil && k != nil && bytes.HasPrefix(k, addrInc); k, v, e = cursor.Next() {
+		if e = walker(k, v); e != nil {
+			return e
+		}
+	}
+	return e
+}
+
+// flushStorageDels - writeStorageDels outside of ApplyState. Normally ApplyState of the block's last tx already did
+// it, so it's no-op. Called under rs.lock.
+func (rs *StateV3) flushStorageDels(roTx kv.Tx) error {
+	if len(rs.storageDels) == 0 {
+		return nil
+	}
+	agg := rs.storageDelsAgg
+	defer agg.BatchHistoryWriteStart().BatchHistoryWriteEnd()
+	return rs.writeStorageDels(roTx, agg)
+}
+
+// writeStorageDels - writes storage history of SELFDESTRUCT-ed accounts, deferred by writeStateHistory. Storage of
+// deleted incarnation is never written again (re-created account gets next incarnation), so it's the same as at
+// txNum of deletion. Called under rs.lock and agg.BatchHistoryWriteStart.
+func (rs *StateV3) writeStorageDels(roTx kv.Tx, agg *libstate.AggregatorV3) error {
+	if len(rs.storageDels) == 0 {
+		return nil
+	}
+	cursor, err := roTx.Cursor(kv.PlainState)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for _, del := range rs.storageDels {
+		addr := del.addr[:length.Addr]
+		if err := rs.walkStorage(cursor, del.addr, func(k, v []byte) error {
+			return agg.AddStoragePrevAt(del.txNum, addr, k[28:], v)
+		}); err != nil {
+			return err
+		}
+	}
+	rs.storageDels, rs.storageDelsAgg = nil, nil
+	return nil
+}
+
 func (rs *StateV3) applyState(roTx kv.Tx, txTask *exec22.TxTask, agg *libstate.AggregatorV3) error {
 	emptyRemoval := txTask.Rules.IsSpuriousDragon
 	rs.lock.Lock()
@@ -423,7 +543,7 @@ func (rs *StateV3) applyState(roTx kv.Tx, txTask *exec22.TxTask, agg *libstate.A
 		}
 		if len(enc0) > 0 {
 			// Need to convert before balance increase
-			enc0 = accounts.SerialiseV3(&a)
+			enc0 = accounts.Serialise(rs.accountCodec, &a)
 		}
 		a.Balance.Add(&a.Balance, &increase)
 		var enc1 []byte
@@ -434,7 +554,7 @@ func (rs *StateV3) applyState(roTx kv.Tx, txTask *exec22.TxTask, agg *libstate.A
 			a.EncodeForStorage(enc1)
 		}
 		rs.put(kv.PlainState, addrBytes, enc1)
-		if err := agg.AddAccountPrev(addrBytes, enc0); err != nil {
+		if err := rs.addAccountPrev(agg, addrBytes, enc0); err != nil {
 			return err
 		}
 	}
@@ -453,12 +573,25 @@ func (rs *StateV3) ApplyState(roTx kv.Tx, txTask *exec22.TxTask, agg *libstate.A
 	defer agg.BatchHistoryWriteStart().BatchHistoryWriteEnd()
 
 	agg.SetTxNum(txTask.TxNum)
+	if rs.wal != nil {
+		rs.wal.txNum(txTask.TxNum)
+	}
 	if err := rs.writeStateHistory(roTx, txTask, agg); err != nil {
 		return err
 	}
 	if err := rs.applyState(roTx, txTask, agg); err != nil {
 		return err
 	}
+	if txTask.Final {
+		// history deferred by SELFDESTRUCT-s is written by apply of the block: agg.Flush may happen between any
+		// two ApplyState, and history of applied blocks must be complete in it
+		rs.lock.Lock()
+		err := rs.writeStorageDels(roTx, agg)
+		rs.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
 
 	returnReadList(txTask.ReadLists)
 	returnWriteList(txTask.WriteLists)
@@ -474,36 +607,42 @@ func (rs *StateV3) ApplyHistory(txTask *exec22.TxTask, agg *libstate.AggregatorV
 	defer agg.BatchHistoryWriteStart().BatchHistoryWriteEnd()
 
 	for addrS, enc0 := range txTask.AccountPrevs {
-		if err := agg.AddAccountPrev([]byte(addrS), enc0); err != nil {
+		if err := rs.addAccountPrev(agg, []byte(addrS), enc0); err != nil {
 			return err
 		}
 	}
 	for compositeS, val := range txTask.StoragePrevs {
 		composite := []byte(compositeS)
-		if err := agg.AddStoragePrev(composite[:20], composite[28:], val); err != nil {
+		if err := rs.addStoragePrev(agg, composite[:20], composite[28:], val); err != nil {
 			return err
 		}
 	}
 	if txTask.TraceFroms != nil {
 		for addr := range txTask.TraceFroms {
-			if err := agg.PutIdx(kv.TblTracesFromIdx, addr[:]); err != nil {
+			if err := rs.putIdx(agg, kv.TblTracesFromIdx, addr[:]); err != nil {
 				return err
 			}
 		}
 	}
 	if txTask.TraceTos != nil {
 		for addr := range txTask.TraceTos {
-			if err := agg.PutIdx(kv.TblTracesToIdx, addr[:]); err != nil {
+			if err := rs.putIdx(agg, kv.TblTracesToIdx, addr[:]); err != nil {
 				return err
 			}
 		}
 	}
+	if txTask.Tx != nil {
+		txnHash := txTask.Tx.Hash()
+		if err := rs.putIdx(agg, kv.TblTxLookupIdx, txnHash[:]); err != nil {
+			return err
+		}
+	}
 	for _, log := range txTask.Logs {
-		if err := agg.PutIdx(kv.TblLogAddressIdx, log.Address[:]); err != nil {
+		if err := rs.putIdx(agg, kv.TblLogAddressIdx, log.Address[:]); err != nil {
 			return err
 		}
 		for _, topic := range log.Topics {
-			if err := agg.PutIdx(kv.LogTopicIndex, topic[:]); err != nil {
+			if err := rs.putIdx(agg, kv.LogTopicIndex, topic[:]); err != nil {
 				return err
 			}
 		}
@@ -511,6 +650,81 @@ func (rs *StateV3) ApplyHistory(txTask *exec22.TxTask, agg *libstate.AggregatorV
 	return nil
 }
 
+func (rs *StateV3) addAccountPrev(agg *libstate.AggregatorV3, addr, prev []byte) error {
+	if rs.wal != nil {
+		rs.wal.prev(walAccountPrev, addr, nil, prev)
+	}
+	return agg.AddAccountPrev(addr, prev)
+}
+
+func (rs *StateV3) addStoragePrev(agg *libstate.AggregatorV3, addr, loc, prev []byte) error {
+	if rs.wal != nil {
+		rs.wal.prev(walStoragePrev, addr, loc, prev)
+	}
+	return agg.AddStoragePrev(addr, loc, prev)
+}
+
+func (rs *StateV3) addCodePrev(agg *libstate.AggregatorV3, addr, prev []byte) error {
+	if rs.wal != nil {
+		rs.wal.prev(walCodePrev, addr, nil, prev)
+	}
+	return agg.AddCodePrev(addr, prev)
+}
+
+func (rs *StateV3) putIdx(agg *libstate.AggregatorV3, idx kv.InvertedIdx, key []byte) error {
+	if rs.wal != nil {
+		rs.wal.idx(string(idx), key)
+	}
+	return agg.PutIdx(idx, key)
+}
+
+// SetWAL - writes of ApplyState/ApplyHistory are recorded to `wal`. Single-threaded, like Apply
+func (rs *StateV3) SetWAL(wal *StateWAL) { rs.wal = wal }
+
+// WALBlockEnd - see StateWAL.BlockEnd. No-op without WAL
+func (rs *StateV3) WALBlockEnd(blockNum, txNum uint64, hash common.Hash) error {
+	if rs.wal == nil {
+		return nil
+	}
+	return rs.wal.BlockEnd(blockNum, txNum, hash)
+}
+
+// ReplayWAL - applies writes of blocks recorded by `wal` after `startTxNum` (see StateWAL.Replay), then records
+// next writes to `wal`. Returns last replayed block and txNum.
+func (rs *StateV3) ReplayWAL(wal *StateWAL, startTxNum uint64, canonical func(blockNum uint64) (common.Hash, error), agg *libstate.AggregatorV3) (blockNum, txNum uint64, ok bool, err error) {
+	defer agg.BatchHistoryWriteStart().BatchHistoryWriteEnd()
+	blockNum, txNum, ok, err = wal.Replay(startTxNum, canonical, func(block []walRecord) error {
+		rs.lock.Lock()
+		defer rs.lock.Unlock()
+		for _, r := range block {
+			var err error
+			switch r.op {
+			case walTxNum:
+				agg.SetTxNum(r.txNum)
+			case walPut:
+				rs.puts(r.table, string(r.key1), r.val)
+			case walAccountPrev:
+				err = agg.AddAccountPrev(r.key1, r.val)
+			case walStoragePrev:
+				err = agg.AddStoragePrev(r.key1, r.key2, r.val)
+			case walCodePrev:
+				err = agg.AddCodePrev(r.key1, r.val)
+			case walIdx:
+				err = agg.PutIdx(kv.InvertedIdx(r.table), r.key1)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, false, err
+	}
+	rs.wal = wal
+	return blockNum, txNum, ok, nil
+}
+
 func recoverCodeHashPlain(acc *accounts.Account, db kv.Tx, key []byte) {
 	var address common.Address
 	copy(address[:], key)
@@ -528,7 +742,7 @@ func (rs *StateV3) Unwind(ctx context.Context, tx kv.RwTx, blockUnwindTo, txUnwi
 		if len(k) == length.Addr {
 			if len(v) > 0 {
 				var acc accounts.Account
-				if err := accounts.DeserialiseV3(&acc, v); err != nil {
+				if err := accounts.Deserialise(rs.accountCodec, &acc, v); err != nil {
 					return fmt.Errorf("%w, %x", err, v)
 				}
 				currentInc = acc.Incarnation
@@ -656,11 +870,87 @@ func (rs *StateV3) DoneCount() uint64 {
 	return execTxsDone.GetValueUint64()
 }
 
-func (rs *StateV3) SizeEstimate() (r uint64) {
+// SizeEstimate - RAM used by not flushed state: keys and values plus structure of maps and btree (see mapSize,
+// btreeSize). Per-table sizes are exposed by `exec_state_size{table="..."}` metrics.
+//
+// It's compared with --batchSize by exec loop. Before it was `2 * (len(k)+len(v))` - x2 as flat overhead of
+// data-structures. For small keys and values (accounts, storage) new estimate is close to that (within ~15%),
+// for big values (code) it's ~2 times smaller: batches of code-heavy blocks are flushed later, but RAM used
+// by them is still within --batchSize.
+func (rs *StateV3) SizeEstimate() uint64 {
 	rs.lock.RLock()
-	r = uint64(rs.sizeEstimate)
-	rs.lock.RUnlock()
-	return r * 2 // multiply 2 here, to cover data-structures overhead. more precise accounting - expensive.
+	defer rs.lock.RUnlock()
+	return rs.sizeEstimate()
+}
+
+func (rs *StateV3) sizeEstimate() (r uint64) {
+	for _, table := range stateTables {
+		size := rs.tableSize(table)
+		stateSizeGauges[table].SetUint64(size)
+		r += size
+	}
+	return r
+}
+
+func (rs *StateV3) tableSize(table string) uint64 {
+	var structure int
+	switch table {
+	case kv.PlainState:
+		structure = mapSize(len(rs.chAccs))
+	case StorageTable:
+		structure = btreeSize(rs.chStorage.Len(), stateBtreeDegree)
+	case kv.Code:
+		structure = mapSize(len(rs.chCode))
+	case kv.PlainContractCode:
+		structure = mapSize(len(rs.chContractCode))
+	case kv.IncarnationMap:
+		structure = mapSize(len(rs.chIncs))
+	default:
+		panic(table)
+	}
+	return uint64(rs.payloadSize[table] + structure)
+}
+
+const stateBtreeDegree = 128
+
+// allocSize - heap size of key or value of `n` bytes: malloc rounds small objects up to size class
+func allocSize(n int) int {
+	switch {
+	case n == 0:
+		return 0
+	case n <= 16:
+		return (n + 7) &^ 7
+	case n <= 256:
+		return (n + 15) &^ 15
+	default:
+		return (n + 127) &^ 127
+	}
+}
+
+// mapSize - buckets of Go map[string][]byte of `count` items. Bucket: 8 tophash bytes, 8 string headers,
+// 8 slice headers and overflow pointer. Map grows (doubles buckets) when average load of bucket reaches 6.5.
+func mapSize(count int) int {
+	if count == 0 {
+		return 0
+	}
+	const bucketSize = 8 + 8*16 + 8*24 + 8
+	buckets := 1
+	for count*2 > buckets*13 {
+		buckets <<= 1
+	}
+	return buckets * bucketSize
+}
+
+// btreeSize - nodes of btree2.Map[string, []byte] of `count` items. Item: string and slice headers. Nodes are
+// filled by ~70% after random inserts and `items` slices of nodes have spare capacity after append: ~1.5 slots
+// per item. Children pointers of branches are negligible: one branch per `degree` leaves.
+func btreeSize(count, degree int) int {
+	if count == 0 {
+		return 0
+	}
+	const itemSize, nodeSize = 16 + 24, 8 + 8 + 24 + 8
+	nodes := count/degree + 1 // max items per node is 2*degree-1
+	return count*itemSize*3/2 + nodes*nodeSize
 }
 
 func (rs *StateV3) ReadsValid(readLists map[string]*libstate.KvList) bool {
@@ -694,6 +984,16 @@ func (rs *StateV3) ReadsValid(readLists map[string]*libstate.KvList) bool {
 }
 
 func (rs *StateV3) readsValidMap(table string, list *libstate.KvList, m map[string][]byte) bool {
+	if len(list.Prefixes) > 0 {
+		seen := seenKeys(list)
+		for k, v := range m {
+			for _, prefix := range list.Prefixes {
+				if strings.HasPrefix(k, prefix) && !prefixReadValid(seen, list, k, v) {
+					return false
+				}
+			}
+		}
+	}
 	switch table {
 	case CodeSizeTable:
 		for i, key := range list.Keys {
@@ -716,6 +1016,22 @@ func (rs *StateV3) readsValidMap(table string, list *libstate.KvList, m map[stri
 }
 
 func (rs *StateV3) readsValidBtree(table string, list *libstate.KvList, m *btree2.Map[string, []byte]) bool {
+	if len(list.Prefixes) > 0 {
+		seen := seenKeys(list)
+		for _, prefix := range list.Prefixes {
+			valid := true
+			m.Ascend(prefix, func(k string, v []byte) bool {
+				if !strings.HasPrefix(k, prefix) {
+					return false
+				}
+				valid = prefixReadValid(seen, list, k, v)
+				return valid
+			})
+			if !valid {
+				return false
+			}
+		}
+	}
 	for i, key := range list.Keys {
 		if val, ok := m.Get(key); ok {
 			if !bytes.Equal(list.Vals[i], val) {
@@ -726,6 +1042,25 @@ func (rs *StateV3) readsValidBtree(table string, list *libstate.KvList, m *btree
 	return true
 }
 
+// seenKeys - index of keys in `list`, for validation of prefix-range reads
+func seenKeys(list *libstate.KvList) map[string]int {
+	seen := make(map[string]int, len(list.Keys))
+	for i, k := range list.Keys {
+		seen[k] = i
+	}
+	return seen
+}
+
+// prefixReadValid - key `k` with current value `v` in range of prefix-range read: reader must have seen same value,
+// or not seen key which is deleted now
+func prefixReadValid(seen map[string]int, list *libstate.KvList, k string, v []byte) bool {
+	i, ok := seen[k]
+	if !ok {
+		return len(v) == 0
+	}
+	return bytes.Equal(list.Vals[i], v)
+}
+
 // StateWriterBufferedV3 - used by parallel workers to accumulate updates and then send them to conflict-resolution.
 type StateWriterBufferedV3 struct {
 	rs           *StateV3
@@ -772,7 +1107,7 @@ func (w *StateWriterBufferedV3) UpdateAccountData(address common.Address, origin
 	w.writeLists[kv.PlainState].Push(string(addressBytes), value)
 	var prev []byte
 	if original.Initialised {
-		prev = accounts.SerialiseV3(original)
+		prev = accounts.Serialise(w.rs.accountCodec, original)
 	}
 	if w.accountPrevs == nil {
 		w.accountPrevs = map[string][]byte{}
@@ -910,6 +1245,66 @@ func (r *StateReaderV3) ReadAccountStorage(address common.Address, incarnation u
 	return enc, nil
 }
 
+// ForEachStorage - non-empty storage of account in key order: from StateV3 and DB. Recorded as prefix-range read,
+// so ReadsValid rejects tx if other tx did add or change any slot of account after it.
+func (r *StateReaderV3) ForEachStorage(address common.Address, incarnation uint64, cb func(key common.Hash, val []byte) bool) error {
+	prefix := dbutils.PlainGenerateStoragePrefix(address.Bytes(), incarnation)
+	var ramKeys []string
+	var ramVals [][]byte
+	r.rs.lock.RLock()
+	r.rs.chStorage.Ascend(string(prefix), func(k string, v []byte) bool {
+		if !strings.HasPrefix(k, string(prefix)) {
+			return false
+		}
+		ramKeys, ramVals = append(ramKeys, k), append(ramVals, v)
+		return true
+	})
+	r.rs.lock.RUnlock()
+
+	c, err := r.tx.Cursor(kv.PlainState)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if !r.discardReadList {
+		r.readLists[StorageTable].PushPrefix(string(prefix))
+	}
+	emit := func(k string, v []byte) bool {
+		if len(v) == 0 { // deleted in StateV3
+			return true
+		}
+		if !r.discardReadList {
+			r.readLists[StorageTable].Push(k, v)
+		}
+		return cb(common.BytesToHash([]byte(k[len(prefix):])), v)
+	}
+	i := 0
+	k, v, err := c.Seek(prefix)
+	for ; err == nil && k != nil && bytes.HasPrefix(k, prefix); k, v, err = c.Next() {
+		for ; i < len(ramKeys) && ramKeys[i] < string(k); i++ {
+			if !emit(ramKeys[i], ramVals[i]) {
+				return nil
+			}
+		}
+		if i < len(ramKeys) && ramKeys[i] == string(k) {
+			v = ramVals[i]
+			i++
+		}
+		if !emit(string(k), v) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for ; i < len(ramKeys); i++ {
+		if !emit(ramKeys[i], ramVals[i]) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (r *StateReaderV3) ReadAccountCode(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
 	addr, codeHashBytes := address.Bytes(), codeHash.Bytes()
 	enc, ok := r.rs.Get(kv.Code, codeHashBytes)
@@ -985,7 +1380,7 @@ var writeListPool = sync.Pool{
 func newWriteList() map[string]*libstate.KvList {
 	v := writeListPool.Get().(map[string]*libstate.KvList)
 	for _, tbl := range v {
-		tbl.Keys, tbl.Vals = tbl.Keys[:0], tbl.Vals[:0]
+		tbl.Reset()
 	}
 	return v
 }
@@ -1011,7 +1406,7 @@ var readListPool = sync.Pool{
 func newReadList() map[string]*libstate.KvList {
 	v := readListPool.Get().(map[string]*libstate.KvList)
 	for _, tbl := range v {
-		tbl.Keys, tbl.Vals = tbl.Keys[:0], tbl.Vals[:0]
+		tbl.Reset()
 	}
 	return v
 }
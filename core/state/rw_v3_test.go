@@ -0,0 +1,242 @@
+package state
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/chain"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/dbutils"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon/cmd/state/exec22"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+func TestReadsValidPrefix(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	addr, other := common.Address{1}, common.Address{2}
+	slot := func(a common.Address, i byte) string {
+		return string(dbutils.PlainGenerateCompositeStorageKey(a[:], 1, common.Hash{i}.Bytes()))
+	}
+	require.NoError(t, tx.Put(kv.PlainState, []byte(slot(addr, 1)), []byte{1}))
+	require.NoError(t, tx.Put(kv.PlainState, []byte(slot(addr, 3)), []byte{3}))
+
+	rs := NewStateV3(t.TempDir(), libtypes.AccountCodecV3, log.New())
+	rs.puts(StorageTable, slot(addr, 2), []byte{2})
+	rs.puts(StorageTable, slot(addr, 3), nil) // deleted
+
+	r := NewStateReaderV3(rs)
+	r.SetTx(tx)
+	var seen []common.Hash
+	require.NoError(t, r.ForEachStorage(addr, 1, func(key common.Hash, val []byte) bool {
+		seen = append(seen, key)
+		return true
+	}))
+	require.Equal(t, []common.Hash{{1}, {2}}, seen)
+	require.True(t, rs.ReadsValid(r.ReadSet()))
+
+	// other account and deletion of not seen slot don't conflict
+	rs.puts(StorageTable, slot(other, 1), []byte{1})
+	rs.puts(StorageTable, slot(addr, 4), nil)
+	require.True(t, rs.ReadsValid(r.ReadSet()))
+
+	// new slot in range
+	rs.puts(StorageTable, slot(addr, 5), []byte{5})
+	require.False(t, rs.ReadsValid(r.ReadSet()))
+}
+
+func TestSizeEstimate(t *testing.T) {
+	heapAlloc := func() uint64 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	rnd := rand.New(rand.NewSource(1))
+	randBytes := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+
+	before := heapAlloc()
+	rs := NewStateV3(t.TempDir(), libtypes.AccountCodecV3, log.New())
+	for i := 0; i < 100_000; i++ {
+		rs.puts(kv.PlainState, string(randBytes(20)), randBytes(70))
+		rs.puts(StorageTable, string(randBytes(60)), randBytes(32))
+	}
+	used, estimate := float64(heapAlloc()-before), float64(rs.SizeEstimate())
+	require.InDelta(t, used, estimate, used*0.15, "used=%.0f, estimate=%.0f", used, estimate)
+
+	// overwrite doesn't add key
+	key := string(randBytes(20))
+	rs.puts(kv.PlainState, key, make([]byte, 10))
+	estimate = float64(rs.SizeEstimate())
+	rs.puts(kv.PlainState, key, make([]byte, 20))
+	require.Equal(t, estimate+16, float64(rs.SizeEstimate())) // size classes of 10 and 20 bytes
+
+	_, tx := memdb.NewTestTx(t)
+	logEvery := time.NewTicker(time.Minute)
+	defer logEvery.Stop()
+	require.NoError(t, rs.Flush(context.Background(), tx, "test", logEvery))
+	require.Zero(t, rs.SizeEstimate())
+	runtime.KeepAlive(rs)
+}
+
+func TestFlushLargest(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	logEvery := time.NewTicker(time.Minute)
+	defer logEvery.Stop()
+	addr := common.Address{1}
+	rs := NewStateV3(t.TempDir(), libtypes.AccountCodecV3, log.New())
+	rs.puts(kv.PlainState, string(addr[:]), []byte{1})
+	for i := 0; i < 1000; i++ {
+		key := dbutils.PlainGenerateCompositeStorageKey(addr[:], 1, common.BytesToHash([]byte{byte(i >> 8), byte(i)}).Bytes())
+		rs.puts(StorageTable, string(key), []byte{byte(i)})
+	}
+
+	flushed, err := rs.FlushLargest(context.Background(), tx, rs.SizeEstimate()/2, "test", logEvery)
+	require.NoError(t, err)
+	require.Equal(t, []string{StorageTable}, flushed)
+	require.Zero(t, rs.chStorage.Len())
+	_, ok := rs.Get(kv.PlainState, addr[:])
+	require.True(t, ok)
+	require.NotZero(t, rs.SizeEstimate())
+
+	key := dbutils.PlainGenerateCompositeStorageKey(addr[:], 1, common.BytesToHash([]byte{0, 7}).Bytes())
+	v, err := tx.GetOne(kv.PlainState, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7}, v)
+
+	flushed, err = rs.FlushLargest(context.Background(), tx, rs.SizeEstimate(), "test", logEvery)
+	require.NoError(t, err)
+	require.Empty(t, flushed)
+
+	require.NoError(t, rs.FlushTable(context.Background(), tx, kv.PlainState, "test", logEvery))
+	require.Zero(t, rs.SizeEstimate())
+}
+
+func TestLazySelfDestruct(t *testing.T) {
+	defer func(v bool) { dbg.LazySelfDestruct = v }(dbg.LazySelfDestruct)
+	dbg.LazySelfDestruct = true
+	ctx, logger := context.Background(), log.New()
+	db := memdb.NewTestDB(t)
+	agg, err := libstate.NewAggregatorV3(ctx, t.TempDir(), t.TempDir(), 16, db, logger)
+	require.NoError(t, err)
+	defer agg.Close()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	defer agg.FinishWrites()
+	logEvery := time.NewTicker(time.Minute)
+	defer logEvery.Stop()
+
+	// slot 1 is in DB, slot 2 in RAM
+	addr := common.Address{1}
+	slot := func(i byte) []byte {
+		return dbutils.PlainGenerateCompositeStorageKey(addr[:], 1, common.Hash{i}.Bytes())
+	}
+	require.NoError(t, tx.Put(kv.PlainState, slot(1), []byte{1}))
+	rs := NewStateV3(t.TempDir(), libtypes.AccountCodecV3, logger)
+	rs.puts(StorageTable, string(slot(2)), []byte{2})
+
+	agg.SetTxNum(5)
+	txTask := &exec22.TxTask{TxNum: 5, AccountDels: map[string]*accounts.Account{
+		string(addr[:]): {Initialised: true, Incarnation: 1},
+	}}
+	require.NoError(t, rs.writeStateHistory(tx, txTask, agg))
+	require.Len(t, rs.storageDels, 1) // storage is not walked by apply
+
+	agg.SetTxNum(9)
+	require.NoError(t, rs.Flush(ctx, tx, "test", logEvery))
+	require.Empty(t, rs.storageDels)
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	ac := agg.MakeContext()
+	defer ac.Close()
+	for i := byte(1); i <= 2; i++ {
+		v, ok, err := ac.ReadAccountStorageNoStateWithRecent(addr[:], common.Hash{i}.Bytes(), 5, tx)
+		require.NoError(t, err)
+		require.True(t, ok, i)
+		require.Equal(t, []byte{i}, v)
+		_, ok, err = ac.ReadAccountStorageNoStateWithRecent(addr[:], common.Hash{i}.Bytes(), 6, tx)
+		require.NoError(t, err)
+		require.False(t, ok, i)
+	}
+}
+
+func TestLazySelfDestructRecreate(t *testing.T) {
+	defer func(v bool) { dbg.LazySelfDestruct = v }(dbg.LazySelfDestruct)
+	dbg.LazySelfDestruct = true
+	ctx, logger := context.Background(), log.New()
+	db := memdb.NewTestDB(t)
+	agg, err := libstate.NewAggregatorV3(ctx, t.TempDir(), t.TempDir(), 16, db, logger)
+	require.NoError(t, err)
+	defer agg.Close()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	defer agg.FinishWrites()
+
+	addr := common.Address{1}
+	slot := func(incarnation uint64, i byte) []byte {
+		return dbutils.PlainGenerateCompositeStorageKey(addr[:], incarnation, common.Hash{i}.Bytes())
+	}
+	require.NoError(t, tx.Put(kv.PlainState, slot(1, 1), []byte{1}))
+	rs := NewStateV3(t.TempDir(), libtypes.AccountCodecV3, logger)
+	rs.puts(StorageTable, string(slot(1, 2)), []byte{2})
+
+	// one block: txNum 5 - SELFDESTRUCT, txNum 6 - re-create and write slot 1, txNum 7 - end of block
+	rules := &chain.Rules{}
+	require.NoError(t, rs.ApplyState(tx, &exec22.TxTask{TxNum: 5, Rules: rules, AccountDels: map[string]*accounts.Account{
+		string(addr[:]): {Initialised: true, Incarnation: 1},
+	}}, agg))
+	require.Len(t, rs.storageDels, 1) // storage is not walked by apply of tx
+
+	recreate := &exec22.TxTask{TxNum: 6, Rules: rules, StoragePrevs: map[string][]byte{string(slot(2, 1)): nil}}
+	require.NoError(t, rs.ApplyState(tx, recreate, agg))
+	require.NoError(t, rs.ApplyHistory(recreate, agg))
+	rs.puts(StorageTable, string(slot(2, 1)), []byte{7})
+
+	require.NoError(t, rs.ApplyState(tx, &exec22.TxTask{TxNum: 7, Rules: rules, Final: true}, agg))
+	require.Empty(t, rs.storageDels) // written by apply of the block
+
+	// agg.Flush without rs.Flush: history of the block is already complete
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	ac := agg.MakeContext()
+	defer ac.Close()
+	read := func(i byte, txNum uint64) ([]byte, bool) {
+		v, ok, err := ac.ReadAccountStorageNoStateWithRecent(addr[:], common.Hash{i}.Bytes(), txNum, tx)
+		require.NoError(t, err)
+		return v, ok
+	}
+	v, ok := read(1, 5)
+	require.True(t, ok)
+	require.Equal(t, []byte{1}, v) // old incarnation before SELFDESTRUCT
+	v, ok = read(1, 6)
+	require.True(t, ok)
+	require.Empty(t, v) // deleted before re-create
+	_, ok = read(1, 7)
+	require.False(t, ok) // latest: value of new incarnation
+
+	v, ok = read(2, 5)
+	require.True(t, ok)
+	require.Equal(t, []byte{2}, v)
+	_, ok = read(2, 6)
+	require.False(t, ok)
+}
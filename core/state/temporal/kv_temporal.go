@@ -3,6 +3,7 @@ package temporal
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 	"github.com/ledgerwatch/erigon-lib/state"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/erigon/core/state/historyv2read"
 	"github.com/ledgerwatch/erigon/core/systemcontracts"
 	"github.com/ledgerwatch/erigon/core/types"
@@ -60,7 +62,7 @@ import (
 //      1. Application - rely on TemporalDB (Ex: ExecutionLayer) or just DB (Ex: TxPool, Sentry, Downloader).
 
 type tRestoreCodeHash func(tx kv.Getter, key, v []byte, force *common.Hash) ([]byte, error)
-type tConvertAccount func(v []byte) ([]byte, error)
+type tConvertAccount func(c libtypes.AccountCodec, v []byte) ([]byte, error)
 type tParseIncarnation func(v []byte) (uint64, error)
 
 type DB struct {
@@ -72,6 +74,9 @@ type DB struct {
 	restoreCodeHash      tRestoreCodeHash
 	parseInc             tParseIncarnation
 	systemContractLookup map[common.Address][]common.CodeRecord
+
+	filesOnly bool            // see NewFilesOnly
+	readClass state.ReadClass // see WithReadClass
 }
 
 func New(db kv.RwDB, agg *state.AggregatorV3, systemContractLookup map[common.Address][]common.CodeRecord) (*DB, error) {
@@ -101,6 +106,41 @@ func New(db kv.RwDB, agg *state.AggregatorV3, systemContractLookup map[common.Ad
 		systemContractLookup: systemContractLookup,
 	}, nil
 }
+
+// NewFilesOnly - read-only TemporalDB which serves history only from immutable files of `agg`, without chain data.
+// Allows run separated history-serving process (cmd/historyserver) next to node which handles chain tip and produces
+// files into same dir: heavy archive queries can be scaled horizontally.
+//   - `db` must be empty (in-memory) DB: it's used only to provide kv.Tx interface and is not read
+//   - history after end of files is only in DB of node: HistoryGet, IndexRange, ... return ErrHistoryNotAvailable
+//   - HistoryGet's and DomainGetAsOf's ok=false means "not changed between ts and end of files": client takes value
+//     from node (see remotedb's WithHistoryServer)
+//   - latest state is not available: DomainGet and DomainRange return ErrLatestStateNotAvailable
+func NewFilesOnly(db kv.RwDB, agg *state.AggregatorV3) (*DB, error) {
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		return kvcfg.HistoryV3.ForceWrite(tx, true)
+	}); err != nil {
+		return nil, err
+	}
+	agg.SetReadOnly(true)
+	return &DB{RwDB: db, agg: agg,
+		convertV3toV2: accounts.ConvertV3toV2, convertV2toV3: accounts.ConvertV2toV3,
+		restoreCodeHash: historyv2read.RestoreCodeHash, parseInc: accounts.DecodeIncarnationFromStorage,
+		filesOnly: true,
+	}, nil
+}
+
+// ErrLatestStateNotAvailable - DB opened by NewFilesOnly has no latest state
+var ErrLatestStateNotAvailable = errors.New("latest state is not available in files-only mode")
+
+// WithReadClass - view of same DB whose transactions read history as class `c` (see state.ReadClass):
+// RPC gets own view, so its lookups are limited by AggregatorV3.SetReadLimits and don't compete with sync.
+func (db *DB) WithReadClass(c state.ReadClass) *DB {
+	cp := *db
+	cp.readClass = c
+	return &cp
+}
+
+func (db *DB) FilesOnly() bool          { return db.filesOnly }
 func (db *DB) Agg() *state.AggregatorV3 { return db.agg }
 func (db *DB) InternalDB() kv.RwDB      { return db.RwDB }
 
@@ -112,6 +152,7 @@ func (db *DB) BeginTemporalRo(ctx context.Context) (kv.TemporalTx, error) {
 	tx := &Tx{MdbxTx: kvTx.(*mdbx.MdbxTx), db: db}
 
 	tx.aggCtx = db.agg.MakeContext()
+	tx.aggCtx.SetReadClass(db.readClass)
 	return tx, nil
 }
 func (db *DB) ViewTemporal(ctx context.Context, f func(tx kv.TemporalTx) error) error {
@@ -144,6 +185,7 @@ func (db *DB) BeginTemporalRw(ctx context.Context) (kv.RwTx, error) {
 	tx := &Tx{MdbxTx: kvTx.(*mdbx.MdbxTx), db: db}
 
 	tx.aggCtx = db.agg.MakeContext()
+	tx.aggCtx.SetReadClass(db.readClass)
 	return tx, nil
 }
 func (db *DB) BeginRw(ctx context.Context) (kv.RwTx, error) {
@@ -169,6 +211,7 @@ func (db *DB) BeginTemporalRwNosync(ctx context.Context) (kv.RwTx, error) {
 	tx := &Tx{MdbxTx: kvTx.(*mdbx.MdbxTx), db: db}
 
 	tx.aggCtx = db.agg.MakeContext()
+	tx.aggCtx.SetReadClass(db.readClass)
 	return tx, nil
 }
 func (db *DB) BeginRwNosync(ctx context.Context) (kv.RwTx, error) {
@@ -212,7 +255,52 @@ func (tx *Tx) Commit() error {
 	return tx.MdbxTx.Commit()
 }
 
+// checkInFiles - in files-only mode history of txNums >= end of files is not available
+func (tx *Tx) checkInFiles(name string, ts uint64) error {
+	if !tx.db.filesOnly {
+		return nil
+	}
+	if end := tx.aggCtx.FilesEndTxNum(); ts >= end {
+		return fmt.Errorf("%w: %s, txNum=%d, files end at %d", state.ErrHistoryNotAvailable, name, ts, end)
+	}
+	return nil
+}
+
+// checkRangeInFiles - like checkInFiles for [fromTs, toTs) range. toTs=-1 means unbounded.
+func (tx *Tx) checkRangeInFiles(name string, fromTs, toTs int, asc order.By) error {
+	if !tx.db.filesOnly {
+		return nil
+	}
+	last := toTs - 1
+	if asc == order.Desc {
+		last = fromTs
+	}
+	if last < 0 {
+		return fmt.Errorf("%w: %s, unbounded range, files end at %d", state.ErrHistoryNotAvailable, name, tx.aggCtx.FilesEndTxNum())
+	}
+	return tx.checkInFiles(name, uint64(last))
+}
+
+// checkRangeServed - history before earliest file (partial snapshot sync) is not available:
+// range which reads it would silently miss values.
+func checkRangeServed(name string, earliest uint64, fromTs, toTs int, asc order.By) error {
+	if earliest == 0 {
+		return nil
+	}
+	first := fromTs
+	if asc == order.Desc {
+		first = toTs + 1 // toTs=-1 means unbounded
+	}
+	if first < int(earliest) {
+		return fmt.Errorf("%w: %s, range starts at txNum=%d, earliest=%d", state.ErrHistoryNotAvailable, name, first, earliest)
+	}
+	return nil
+}
+
 func (tx *Tx) DomainRange(name kv.Domain, fromKey, toKey []byte, asOfTs uint64, asc order.By, limit int) (it iter.KV, err error) {
+	if tx.db.filesOnly {
+		return nil, ErrLatestStateNotAvailable
+	}
 	if asc == order.Desc {
 		panic("not supported yet")
 	}
@@ -225,7 +313,7 @@ func (tx *Tx) DomainRange(name kv.Domain, fromKey, toKey []byte, asOfTs uint64,
 			if len(v) == 0 {
 				return k[:20], v, nil
 			}
-			v, err = tx.db.convertV3toV2(v)
+			v, err = tx.db.convertV3toV2(tx.aggCtx.AccountCodec(), v)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -303,6 +391,9 @@ func (tx *Tx) DomainGet(name kv.Domain, key, key2 []byte) (v []byte, ok bool, er
 	if ethconfig.EnableHistoryV4InTest {
 		panic("implement me")
 	}
+	if tx.db.filesOnly {
+		return nil, false, ErrLatestStateNotAvailable
+	}
 	switch name {
 	case kv.AccountsDomain:
 		v, err = tx.GetOne(kv.PlainState, key)
@@ -321,6 +412,9 @@ func (tx *Tx) DomainGetAsOf(name kv.Domain, key, key2 []byte, ts uint64) (v []by
 	if ethconfig.EnableHistoryV4InTest {
 		panic("implement me")
 	}
+	if tx.db.filesOnly {
+		return tx.domainGetAsOfFiles(name, key, key2, ts)
+	}
 	switch name {
 	case kv.AccountsDomain:
 		v, ok, err = tx.HistoryGet(kv.AccountsHistory, key, ts)
@@ -332,7 +426,7 @@ func (tx *Tx) DomainGetAsOf(name kv.Domain, key, key2 []byte, ts uint64) (v []by
 		}
 		v, err = tx.GetOne(kv.PlainState, key)
 		if len(v) > 0 {
-			v, err = accounts.ConvertV2toV3(v)
+			v, err = accounts.ConvertV2toV3(tx.aggCtx.AccountCodec(), v)
 			if err != nil {
 				return nil, false, err
 			}
@@ -363,7 +457,27 @@ func (tx *Tx) DomainGetAsOf(name kv.Domain, key, key2 []byte, ts uint64) (v []by
 	}
 }
 
+// domainGetAsOfFiles - DomainGetAsOf of files-only mode: only history part of it, there is no latest state to fall back
+func (tx *Tx) domainGetAsOfFiles(name kv.Domain, key, key2 []byte, ts uint64) (v []byte, ok bool, err error) {
+	switch name {
+	case kv.AccountsDomain:
+		return tx.HistoryGet(kv.AccountsHistory, key, ts)
+	case kv.StorageDomain:
+		return tx.HistoryGet(kv.StorageHistory, append(common.Copy(key[:20]), key2...), ts)
+	case kv.CodeDomain:
+		return tx.HistoryGet(kv.CodeHistory, key, ts)
+	default:
+		panic(fmt.Sprintf("unexpected: %s", name))
+	}
+}
+
 func (tx *Tx) HistoryGet(name kv.History, key []byte, ts uint64) (v []byte, ok bool, err error) {
+	if earliest := tx.aggCtx.HistoryEarliestTxNum(name); ts < earliest {
+		return nil, false, fmt.Errorf("%w: %s, txNum=%d, earliest=%d", state.ErrHistoryNotAvailable, name, ts, earliest)
+	}
+	if err := tx.checkInFiles(string(name), ts); err != nil {
+		return nil, false, err
+	}
 	switch name {
 	case kv.AccountsHistory:
 		v, ok, err = tx.aggCtx.ReadAccountDataNoStateWithRecent(key, ts, tx.MdbxTx)
@@ -374,7 +488,7 @@ func (tx *Tx) HistoryGet(name kv.History, key []byte, ts uint64) (v []byte, ok b
 			return v, ok, nil
 		}
 		/*
-			v, err = tx.db.convertV3toV2(v)
+			v, err = tx.db.convertV3toV2(tx.aggCtx.AccountCodec(), v)
 			if err != nil {
 				return nil, false, err
 			}
@@ -393,7 +507,7 @@ func (tx *Tx) HistoryGet(name kv.History, key []byte, ts uint64) (v []byte, ok b
 				return nil, false, err
 			}
 			if len(v) > 0 {
-				v, err = tx.db.convertV2toV3(v)
+				v, err = tx.db.convertV2toV3(tx.aggCtx.AccountCodec(), v)
 				if err != nil {
 					return nil, false, err
 				}
@@ -410,6 +524,10 @@ func (tx *Tx) HistoryGet(name kv.History, key []byte, ts uint64) (v []byte, ok b
 }
 
 func (tx *Tx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (timestamps iter.U64, err error) {
+	// range before earliest file is rejected by AggregatorV3Context.IndexRange
+	if err := tx.checkRangeInFiles(string(name), fromTs, toTs, asc); err != nil {
+		return nil, err
+	}
 	timestamps, err = tx.aggCtx.IndexRange(name, k, fromTs, toTs, asc, limit, tx.MdbxTx)
 	if err != nil {
 		return nil, err
@@ -427,6 +545,12 @@ func (tx *Tx) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limi
 	if limit >= 0 {
 		panic("not implemented yet")
 	}
+	if err := checkRangeServed(string(name), tx.aggCtx.HistoryEarliestTxNum(name), fromTs, toTs, asc); err != nil {
+		return nil, err
+	}
+	if err := tx.checkRangeInFiles(string(name), fromTs, toTs, asc); err != nil {
+		return nil, err
+	}
 	switch name {
 	case kv.AccountsHistory:
 		it, err = tx.aggCtx.AccountHistoryRange(fromTs, toTs, asc, limit, tx)
@@ -446,6 +570,26 @@ func (tx *Tx) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limi
 	return it, err
 }
 
+// HistoryChangedKeys - keys of history changed in [fromTs, toTs), without values
+func (tx *Tx) HistoryChangedKeys(name kv.History, fromTs, toTs uint64) (it iter.KV, err error) {
+	if err := checkRangeServed(string(name), tx.aggCtx.HistoryEarliestTxNum(name), int(fromTs), int(toTs), order.Asc); err != nil {
+		return nil, err
+	}
+	if toTs > 0 {
+		if err := tx.checkInFiles(string(name), toTs-1); err != nil {
+			return nil, err
+		}
+	}
+	it, err = tx.aggCtx.HistoryChangedKeys(name, fromTs, toTs, tx)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := it.(kv.Closer); ok {
+		tx.resourcesToClose = append(tx.resourcesToClose, closer)
+	}
+	return it, nil
+}
+
 // TODO: need remove `gspec` param (move SystemContractCodeLookup feature somewhere)
 func NewTestDB(tb testing.TB, dirs datadir.Dirs, gspec *types.Genesis) (histV3 bool, db kv.RwDB, agg *state.AggregatorV3) {
 	historyV3 := ethconfig.EnableHistoryV3InTest
@@ -0,0 +1,97 @@
+package temporal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/state"
+)
+
+func TestFilesOnly(t *testing.T) {
+	require := require.New(t)
+	ctx, logger := context.Background(), log.New()
+	dirs := datadir.New(t.TempDir())
+	dir.MustExist(dirs.SnapHistory, dirs.Tmp)
+
+	// node: account changed at txNum=5, 20 (in files) and 40 (in DB)
+	nodeDB := memdb.NewTestDB(t)
+	agg, err := state.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, 16, nodeDB, logger)
+	require.NoError(err)
+	defer agg.Close()
+	addr, addr2 := []byte("addr_of_20_bytes____"), []byte("another_addr________")
+	require.NoError(nodeDB.Update(ctx, func(tx kv.RwTx) error {
+		agg.SetTx(tx)
+		agg.StartWrites()
+		defer agg.FinishWrites()
+		for txNum, prev := range map[uint64]string{5: "v0", 20: "v1", 40: "v2"} {
+			agg.SetTxNum(txNum)
+			require.NoError(agg.AddAccountPrev(addr, []byte(prev)))
+		}
+		agg.SetTxNum(47)
+		require.NoError(agg.AddAccountPrev(addr2, []byte("v0")))
+		return agg.Flush(ctx, tx)
+	}))
+	agg.KeepInDB(0)
+	require.NoError(agg.TriggerFilesBuild())
+	require.Eventually(func() bool { return agg.EndTxNumMinimax() == 32 }, 10*time.Second, 10*time.Millisecond)
+
+	// history server: same files, empty DB
+	filesAgg, err := state.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, 16, memdb.NewTestDB(t), logger)
+	require.NoError(err)
+	defer filesAgg.Close()
+	require.NoError(filesAgg.OpenFolder())
+	db, err := NewFilesOnly(memdb.NewTestDB(t), filesAgg)
+	require.NoError(err)
+	require.True(filesAgg.ReadOnly())
+
+	tx, err := db.BeginTemporalRo(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+
+	v, ok, err := tx.HistoryGet(kv.AccountsHistory, addr, 3)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal("v0", string(v))
+	v, ok, err = tx.DomainGetAsOf(kv.AccountsDomain, addr, nil, 10)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal("v1", string(v))
+
+	// not changed between txNum and end of files: value is in node
+	_, ok, err = tx.HistoryGet(kv.AccountsHistory, addr, 25)
+	require.NoError(err)
+	require.False(ok)
+	_, ok, err = tx.DomainGetAsOf(kv.AccountsDomain, addr, nil, 25)
+	require.NoError(err)
+	require.False(ok)
+
+	// after end of files
+	_, _, err = tx.HistoryGet(kv.AccountsHistory, addr, 35)
+	require.ErrorIs(err, state.ErrHistoryNotAvailable)
+	_, err = tx.IndexRange(kv.AccountsHistoryIdx, addr, 0, 40, order.Asc, -1)
+	require.ErrorIs(err, state.ErrHistoryNotAvailable)
+	_, err = tx.IndexRange(kv.AccountsHistoryIdx, addr, -1, 10, order.Desc, -1)
+	require.ErrorIs(err, state.ErrHistoryNotAvailable)
+	it, err := tx.IndexRange(kv.AccountsHistoryIdx, addr, 0, 32, order.Asc, -1)
+	require.NoError(err)
+	var txNums []uint64
+	for it.HasNext() {
+		txNum, err := it.Next()
+		require.NoError(err)
+		txNums = append(txNums, txNum)
+	}
+	require.Equal([]uint64{5, 20}, txNums)
+
+	// no latest state
+	_, _, err = tx.DomainGet(kv.AccountsDomain, addr, nil)
+	require.ErrorIs(err, ErrLatestStateNotAvailable)
+}
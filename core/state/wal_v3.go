@@ -0,0 +1,297 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+// StateWAL - on-disk log of writes done by StateV3.ApplyState/ApplyHistory since last commit: writes of StateV3
+// and history writes of AggregatorV3. Exec writes it block by block; after crash it's replayed on startup (instead of
+// re-execution of blocks) up to last block which was fully written.
+//
+// File: header (magic and txNum of first record), then blocks. Block: records, then block-end record with blockNum,
+// last txNum, hash and crc32 of records of block. Record: op byte and fields, field is uvarint(len+1) (0 - nil)
+// and bytes. Torn or corrupted tail of file is dropped.
+//
+// Blocks are written to OS on every block-end (survives crash of process), fsync is not done.
+type StateWAL struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	crc  uint32 // of records of current block
+	buf  []byte
+	err  error // sticky: returned by BlockEnd
+}
+
+type walOp byte
+
+const (
+	walTxNum walOp = iota + 1
+	walPut
+	walAccountPrev
+	walStoragePrev
+	walCodePrev
+	walIdx
+	walBlockEnd
+)
+
+var walMagic = []byte("EWAL\x01")
+
+const walMaxField = 256 << 20
+
+var errWalCorrupted = errors.New("corrupted")
+
+// walRecord - `table` of walPut/walIdx, `key2` of walStoragePrev, `txNum` of walTxNum/walBlockEnd
+type walRecord struct {
+	op         walOp
+	txNum      uint64
+	table      string
+	key1, key2 []byte
+	val        []byte
+
+	// walBlockEnd
+	blockNum uint64
+	hash     common.Hash
+	crc      uint32
+}
+
+func OpenStateWAL(path string) (*StateWAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &StateWAL{path: path, f: f}, nil
+}
+
+func (w *StateWAL) Close() {
+	if w == nil || w.f == nil {
+		return
+	}
+	if w.w != nil {
+		_ = w.w.Flush()
+	}
+	_ = w.f.Close()
+	w.f = nil
+}
+
+// Replay - applies blocks of file which continue `startTxNum` and are canonical (`canonical` returns hash of
+// canonical block), block by block. Tail which can't be applied is dropped: next blocks are written after last
+// applied one. ok=false - nothing applied, file is started from `startTxNum`.
+func (w *StateWAL) Replay(startTxNum uint64, canonical func(blockNum uint64) (common.Hash, error), apply func(block []walRecord) error) (blockNum, txNum uint64, ok bool, err error) {
+	if _, err = w.f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+	r := &walReader{r: bufio.NewReaderSize(w.f, 1<<20)}
+	var end int64
+	header := make([]byte, len(walMagic)+8)
+	if _, err = io.ReadFull(r, header); err == nil && bytes.Equal(header[:len(walMagic)], walMagic) && binary.BigEndian.Uint64(header[len(walMagic):]) == startTxNum {
+		var block []walRecord
+		r.crc = 0
+		for {
+			crc := r.crc
+			rec, rerr := r.readRecord()
+			if rerr != nil {
+				break // end of file or torn tail
+			}
+			if rec.op != walBlockEnd {
+				block = append(block, rec)
+				continue
+			}
+			if rec.crc != crc {
+				break
+			}
+			hash, cerr := canonical(rec.blockNum)
+			if cerr != nil {
+				return 0, 0, false, cerr
+			}
+			if hash != rec.hash {
+				break // unwound
+			}
+			if err = apply(block); err != nil {
+				return 0, 0, false, err
+			}
+			blockNum, txNum, ok, end = rec.blockNum, rec.txNum, true, r.n
+			block, r.crc = nil, 0
+		}
+	}
+	if !ok {
+		return 0, 0, false, w.reset(startTxNum)
+	}
+	if err = w.f.Truncate(end); err != nil {
+		return 0, 0, false, err
+	}
+	if _, err = w.f.Seek(end, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+	w.w, w.crc = bufio.NewWriterSize(w.f, 1<<20), 0
+	return blockNum, txNum, true, nil
+}
+
+func (w *StateWAL) reset(startTxNum uint64) error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.w, w.crc, w.err = bufio.NewWriterSize(w.f, 1<<20), 0, nil
+	if _, err := w.w.Write(binary.BigEndian.AppendUint64(common.Copy(walMagic), startTxNum)); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *StateWAL) write(buf []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, buf)
+	_, w.err = w.w.Write(buf)
+}
+
+func appendWalField(buf, field []byte) []byte {
+	if field == nil {
+		return binary.AppendUvarint(buf, 0)
+	}
+	return append(binary.AppendUvarint(buf, uint64(len(field))+1), field...)
+}
+
+func (w *StateWAL) txNum(txNum uint64) {
+	w.buf = binary.AppendUvarint(append(w.buf[:0], byte(walTxNum)), txNum)
+	w.write(w.buf)
+}
+
+func (w *StateWAL) put(table, key string, val []byte) {
+	w.buf = appendWalField(appendWalField(append(w.buf[:0], byte(walPut)), []byte(table)), []byte(key))
+	w.buf = appendWalField(w.buf, val)
+	w.write(w.buf)
+}
+
+// prev - walAccountPrev, walStoragePrev or walCodePrev
+func (w *StateWAL) prev(op walOp, key1, key2, val []byte) {
+	w.buf = appendWalField(append(w.buf[:0], byte(op)), key1)
+	if op == walStoragePrev {
+		w.buf = appendWalField(w.buf, key2)
+	}
+	w.buf = appendWalField(w.buf, val)
+	w.write(w.buf)
+}
+
+func (w *StateWAL) idx(table string, key []byte) {
+	w.buf = appendWalField(appendWalField(append(w.buf[:0], byte(walIdx)), []byte(table)), key)
+	w.write(w.buf)
+}
+
+// BlockEnd - all writes of block `blockNum` (last tx of block is `txNum`) are recorded
+func (w *StateWAL) BlockEnd(blockNum, txNum uint64, hash common.Hash) error {
+	if w.err != nil {
+		return fmt.Errorf("state WAL %s: %w", w.path, w.err)
+	}
+	w.buf = append(w.buf[:0], byte(walBlockEnd))
+	w.buf = binary.BigEndian.AppendUint64(w.buf, blockNum)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, txNum)
+	w.buf = append(w.buf, hash[:]...)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, w.crc)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return fmt.Errorf("state WAL %s: %w", w.path, err)
+	}
+	w.crc = 0
+	if err := w.w.Flush(); err != nil {
+		return fmt.Errorf("state WAL %s: %w", w.path, err)
+	}
+	return nil
+}
+
+// walReader - counts offset and crc32 of read bytes
+type walReader struct {
+	r   *bufio.Reader
+	n   int64
+	crc uint32
+}
+
+func (r *walReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.crc = crc32.Update(r.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+func (r *walReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.n++
+	r.crc = crc32.Update(r.crc, crc32.IEEETable, []byte{b})
+	return b, nil
+}
+
+func (r *walReader) readField() ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l == 0 {
+		return nil, nil
+	}
+	if l-1 > walMaxField {
+		return nil, errWalCorrupted
+	}
+	field := make([]byte, l-1)
+	if _, err = io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func (r *walReader) readRecord() (rec walRecord, err error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.op = walOp(op)
+	var table []byte
+	switch rec.op {
+	case walTxNum:
+		rec.txNum, err = binary.ReadUvarint(r)
+	case walPut:
+		if table, err = r.readField(); err == nil {
+			if rec.key1, err = r.readField(); err == nil {
+				rec.val, err = r.readField()
+			}
+		}
+	case walAccountPrev, walCodePrev:
+		if rec.key1, err = r.readField(); err == nil {
+			rec.val, err = r.readField()
+		}
+	case walStoragePrev:
+		if rec.key1, err = r.readField(); err == nil {
+			if rec.key2, err = r.readField(); err == nil {
+				rec.val, err = r.readField()
+			}
+		}
+	case walIdx:
+		if table, err = r.readField(); err == nil {
+			rec.key1, err = r.readField()
+		}
+	case walBlockEnd:
+		var b [8 + 8 + 32 + 4]byte
+		if _, err = io.ReadFull(r, b[:]); err == nil {
+			rec.blockNum, rec.txNum = binary.BigEndian.Uint64(b[:]), binary.BigEndian.Uint64(b[8:])
+			copy(rec.hash[:], b[16:48])
+			rec.crc = binary.BigEndian.Uint32(b[48:])
+		}
+	default:
+		err = errWalCorrupted
+	}
+	rec.table = string(table)
+	return rec, err
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+func TestStateWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exec.wal")
+	hashes := map[uint64]common.Hash{10: {10}, 11: {11}, 12: {12}}
+	canonical := func(blockNum uint64) (common.Hash, error) { return hashes[blockNum], nil }
+	replay := func(startTxNum uint64) (blocks [][]walRecord, blockNum, txNum uint64, ok bool) {
+		w, err := OpenStateWAL(path)
+		require.NoError(t, err)
+		defer w.Close()
+		blockNum, txNum, ok, err = w.Replay(startTxNum, canonical, func(block []walRecord) error {
+			blocks = append(blocks, block)
+			return nil
+		})
+		require.NoError(t, err)
+		return blocks, blockNum, txNum, ok
+	}
+
+	w, err := OpenStateWAL(path)
+	require.NoError(t, err)
+	_, _, ok, err := w.Replay(100, canonical, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+	w.txNum(100)
+	w.put(StorageTable, "k1", []byte{1})
+	w.put(StorageTable, "k2", nil)
+	w.prev(walStoragePrev, []byte{1}, []byte{2}, []byte{})
+	require.NoError(t, w.BlockEnd(10, 100, common.Hash{10}))
+	w.txNum(101)
+	w.prev(walAccountPrev, []byte{3}, nil, nil)
+	w.idx("LogAddressIdx", []byte{4})
+	require.NoError(t, w.BlockEnd(11, 101, common.Hash{11}))
+	w.txNum(102)
+	w.put(StorageTable, "k3", []byte{3}) // block without end
+	w.Close()
+
+	blocks, blockNum, txNum, ok := replay(100)
+	require.True(t, ok)
+	require.Equal(t, uint64(11), blockNum)
+	require.Equal(t, uint64(101), txNum)
+	require.Equal(t, [][]walRecord{
+		{
+			{op: walTxNum, txNum: 100},
+			{op: walPut, table: StorageTable, key1: []byte("k1"), val: []byte{1}},
+			{op: walPut, table: StorageTable, key1: []byte("k2")},
+			{op: walStoragePrev, key1: []byte{1}, key2: []byte{2}, val: []byte{}},
+		},
+		{
+			{op: walTxNum, txNum: 101},
+			{op: walAccountPrev, key1: []byte{3}},
+			{op: walIdx, table: "LogAddressIdx", key1: []byte{4}},
+		},
+	}, blocks)
+
+	// tail is dropped by replay: next block is written after block 11
+	w, err = OpenStateWAL(path)
+	require.NoError(t, err)
+	_, _, ok, err = w.Replay(100, canonical, func([]walRecord) error { return nil })
+	require.NoError(t, err)
+	require.True(t, ok)
+	w.txNum(102)
+	require.NoError(t, w.BlockEnd(12, 102, common.Hash{12}))
+	w.Close()
+	blocks, blockNum, _, _ = replay(100)
+	require.Equal(t, 3, len(blocks))
+	require.Equal(t, uint64(12), blockNum)
+
+	// corrupted block and everything after it are dropped
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(walMagic)+8+3] ^= 0xff // inside of `put` of block 10
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	blocks, _, _, ok = replay(100)
+	require.False(t, ok)
+	require.Empty(t, blocks)
+
+	// not canonical block
+	w, err = OpenStateWAL(path)
+	require.NoError(t, err)
+	_, _, _, err = w.Replay(100, canonical, nil)
+	require.NoError(t, err)
+	w.txNum(100)
+	require.NoError(t, w.BlockEnd(10, 100, common.Hash{0xff}))
+	w.Close()
+	_, _, _, ok = replay(100)
+	require.False(t, ok)
+
+	// other startTxNum: file is started from scratch
+	w, err = OpenStateWAL(path)
+	require.NoError(t, err)
+	_, _, _, err = w.Replay(100, canonical, nil)
+	require.NoError(t, err)
+	w.txNum(100)
+	require.NoError(t, w.BlockEnd(10, 100, common.Hash{10}))
+	w.Close()
+	_, _, _, ok = replay(90)
+	require.False(t, ok)
+	_, _, _, ok = replay(100)
+	require.False(t, ok)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/holiman/uint256"
 	libcommon "github.com/ledgerwatch/erigon-lib/common"
 	rlp2 "github.com/ledgerwatch/erigon-lib/rlp"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
 
 	"github.com/ledgerwatch/erigon/crypto"
 	"github.com/ledgerwatch/erigon/rlp"
@@ -603,185 +604,62 @@ func (a *Account) Equals(acc *Account) bool {
 		a.Incarnation == acc.Incarnation
 }
 
-func ConvertV3toV2(v []byte) ([]byte, error) {
+// ConvertV3toV2 - history value encoded by `c` to PlainState encoding
+func ConvertV3toV2(c libtypes.AccountCodec, v []byte) ([]byte, error) {
 	var a Account
-	if err := DeserialiseV3(&a, v); err != nil {
+	if err := Deserialise(c, &a, v); err != nil {
 		return nil, fmt.Errorf("ConvertV3toV2(%x): %w", v, err)
 	}
 	v = make([]byte, a.EncodingLengthForStorage())
 	a.EncodeForStorage(v)
 	return v, nil
 }
-func ConvertV2toV3(v []byte) ([]byte, error) {
+func ConvertV2toV3(c libtypes.AccountCodec, v []byte) ([]byte, error) {
 	var a Account
 	if err := a.DecodeForStorage(v); err != nil {
 		return nil, fmt.Errorf("ConvertV3toV2(%x): %w", v, err)
 	}
-	return SerialiseV3(&a), nil
+	return Serialise(c, &a), nil
 }
 
-// DeserialiseV3 - method to deserialize accounts in Erigon22 history
-func DeserialiseV3(a *Account, enc []byte) error {
+// DeserialiseV3 - method to deserialize accounts in Erigon22 history. Default encoding, see Deserialise
+func DeserialiseV3(a *Account, enc []byte) error { return Deserialise(libtypes.AccountCodecV3, a, enc) }
+func SerialiseV3(a *Account) []byte              { return Serialise(libtypes.AccountCodecV3, a) }
+func SerialiseV3Len(a *Account) (l int)          { return SerialiseLen(libtypes.AccountCodecV3, a) }
+func SerialiseV3To(a *Account, value []byte)     { SerialiseTo(libtypes.AccountCodecV3, a, value) }
+
+// Deserialise - account encoded by `c`: codec of datadir (chain.Config.AccountCodec), see AggregatorV3.AccountCodec
+func Deserialise(c libtypes.AccountCodec, a *Account, enc []byte) error {
 	a.Reset()
-	pos := 0
-	nonceBytes := int(enc[pos])
-	pos++
-	if nonceBytes > 0 {
-		a.Nonce = bytesToUint64(enc[pos : pos+nonceBytes])
-		pos += nonceBytes
-	}
-	balanceBytes := int(enc[pos])
-	pos++
-	if balanceBytes > 0 {
-		a.Balance.SetBytes(enc[pos : pos+balanceBytes])
-		pos += balanceBytes
-	}
-	codeHashBytes := int(enc[pos])
-	pos++
-	if codeHashBytes > 0 {
-		copy(a.CodeHash[:], enc[pos:pos+codeHashBytes])
-		pos += codeHashBytes
+	nonce, codeHash, incarnation, err := c.Decode(enc, &a.Balance)
+	if err != nil {
+		return err
 	}
-	if pos >= len(enc) {
-		return fmt.Errorf("deserialse2: %d >= %d ", pos, len(enc))
-	}
-	incBytes := int(enc[pos])
-	pos++
-	if incBytes > 0 {
-		a.Incarnation = bytesToUint64(enc[pos : pos+incBytes])
+	a.Nonce, a.Incarnation = nonce, incarnation
+	if codeHash != nil {
+		copy(a.CodeHash[:], codeHash)
 	}
 	return nil
 }
 
-func SerialiseV3(a *Account) []byte {
-	var l int
-	l++
-	if a.Nonce > 0 {
-		l += libcommon.BitLenToByteLen(bits.Len64(a.Nonce))
-	}
-	l++
-	if !a.Balance.IsZero() {
-		l += a.Balance.ByteLen()
-	}
-	l++
-	if !a.IsEmptyCodeHash() {
-		l += 32
-	}
-	l++
-	if a.Incarnation > 0 {
-		l += libcommon.BitLenToByteLen(bits.Len64(a.Incarnation))
-	}
-	value := make([]byte, l)
-	pos := 0
-	if a.Nonce == 0 {
-		value[pos] = 0
-		pos++
-	} else {
-		nonceBytes := libcommon.BitLenToByteLen(bits.Len64(a.Nonce))
-		value[pos] = byte(nonceBytes)
-		var nonce = a.Nonce
-		for i := nonceBytes; i > 0; i-- {
-			value[pos+i] = byte(nonce)
-			nonce >>= 8
-		}
-		pos += nonceBytes + 1
-	}
-	if a.Balance.IsZero() {
-		value[pos] = 0
-		pos++
-	} else {
-		balanceBytes := a.Balance.ByteLen()
-		value[pos] = byte(balanceBytes)
-		pos++
-		a.Balance.WriteToSlice(value[pos : pos+balanceBytes])
-		pos += balanceBytes
-	}
-	if a.IsEmptyCodeHash() {
-		value[pos] = 0
-		pos++
-	} else {
-		value[pos] = 32
-		pos++
-		copy(value[pos:pos+32], a.CodeHash[:])
-		pos += 32
-	}
-	if a.Incarnation == 0 {
-		value[pos] = 0
-	} else {
-		incBytes := libcommon.BitLenToByteLen(bits.Len64(a.Incarnation))
-		value[pos] = byte(incBytes)
-		var inc = a.Incarnation
-		for i := incBytes; i > 0; i-- {
-			value[pos+i] = byte(inc)
-			inc >>= 8
-		}
-	}
+func Serialise(c libtypes.AccountCodec, a *Account) []byte {
+	value := make([]byte, SerialiseLen(c, a))
+	SerialiseTo(c, a, value)
 	return value
 }
 
-func SerialiseV3Len(a *Account) (l int) {
-	l++
-	if a.Nonce > 0 {
-		l += libcommon.BitLenToByteLen(bits.Len64(a.Nonce))
-	}
-	l++
-	if !a.Balance.IsZero() {
-		l += a.Balance.ByteLen()
-	}
-	l++
-	if !a.IsEmptyCodeHash() {
-		l += 32
-	}
-	l++
-	if a.Incarnation > 0 {
-		l += libcommon.BitLenToByteLen(bits.Len64(a.Incarnation))
-	}
-	return l
+func SerialiseLen(c libtypes.AccountCodec, a *Account) (l int) {
+	return c.EncodeLen(a.Nonce, &a.Balance, a.codeHashV3(), a.Incarnation)
 }
 
-func SerialiseV3To(a *Account, value []byte) {
-	pos := 0
-	if a.Nonce == 0 {
-		value[pos] = 0
-		pos++
-	} else {
-		nonceBytes := libcommon.BitLenToByteLen(bits.Len64(a.Nonce))
-		value[pos] = byte(nonceBytes)
-		var nonce = a.Nonce
-		for i := nonceBytes; i > 0; i-- {
-			value[pos+i] = byte(nonce)
-			nonce >>= 8
-		}
-		pos += nonceBytes + 1
-	}
-	if a.Balance.IsZero() {
-		value[pos] = 0
-		pos++
-	} else {
-		balanceBytes := a.Balance.ByteLen()
-		value[pos] = byte(balanceBytes)
-		pos++
-		a.Balance.WriteToSlice(value[pos : pos+balanceBytes])
-		pos += balanceBytes
-	}
+func SerialiseTo(c libtypes.AccountCodec, a *Account, value []byte) {
+	c.EncodeTo(value, a.Nonce, &a.Balance, a.codeHashV3(), a.Incarnation)
+}
+
+// codeHashV3 - nil for account without code
+func (a *Account) codeHashV3() []byte {
 	if a.IsEmptyCodeHash() {
-		value[pos] = 0
-		pos++
-	} else {
-		value[pos] = 32
-		pos++
-		copy(value[pos:pos+32], a.CodeHash[:])
-		pos += 32
-	}
-	if a.Incarnation == 0 {
-		value[pos] = 0
-	} else {
-		incBytes := libcommon.BitLenToByteLen(bits.Len64(a.Incarnation))
-		value[pos] = byte(incBytes)
-		var inc = a.Incarnation
-		for i := incBytes; i > 0; i-- {
-			value[pos+i] = byte(inc)
-			inc >>= 8
-		}
+		return nil
 	}
+	return a.CodeHash[:]
 }
@@ -34,5 +34,7 @@ func Setup(ctx *cli.Context, metricsMux *http.ServeMux, node *node.ErigonNode) {
 	SetupBootnodesAccess(debugMux, node)
 	SetupStagesAccess(debugMux, diagnostic)
 	SetupMemAccess(debugMux)
+	SetupStateFilesAccess(ctx, debugMux, node)
+	SetupStateDebugAccess(debugMux)
 
 }
@@ -0,0 +1,32 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+)
+
+// SetupStateDebugAccess - GET /state-debug: domains with enabled debug output,
+// POST /state-debug?domain=commitment&enabled=true - enable/disable debug output of domain
+func SetupStateDebugAccess(metricsMux *http.ServeMux) {
+	metricsMux.HandleFunc("/state-debug", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			domain := r.URL.Query().Get("domain")
+			if domain == "" {
+				http.Error(w, "domain parameter is required", http.StatusBadRequest)
+				return
+			}
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled parameter must be true or false", http.StatusBadRequest)
+				return
+			}
+			libstate.SetDomainDebug(domain, enabled)
+		}
+		json.NewEncoder(w).Encode(libstate.DebugDomains())
+	})
+}
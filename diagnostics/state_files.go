@@ -0,0 +1,66 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ledgerwatch/erigon/cmd/utils"
+	"github.com/ledgerwatch/erigon/turbo/node"
+)
+
+func SetupStateFilesAccess(ctx *cli.Context, metricsMux *http.ServeMux, node *node.ErigonNode) {
+	metricsMux.HandleFunc("/state-files-stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		writeStateFilesStats(w, node)
+	})
+	// metrics server has no authentication: mutating endpoint is served only if operator enabled it
+	if !ctx.Bool(utils.DiagnosticsStateReplaceFlag.Name) {
+		return
+	}
+	// POST /state-files-replace?files=accounts.0-32.v,accounts.0-32.ef - reopen files replaced on disk.
+	// No CORS header: web pages of other origins must not be able to trigger it from operator's browser
+	metricsMux.HandleFunc("/state-files-replace", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		replaceStateFiles(w, r, node)
+	})
+}
+
+// writeStateFilesStats - per-file access counters of history/inverted index files, hottest first
+func writeStateFilesStats(w http.ResponseWriter, node *node.ErigonNode) {
+	agg := node.Backend().Agg()
+	if agg == nil {
+		http.Error(w, "HistoryV3 is not enabled", http.StatusNotFound)
+		return
+	}
+	stats := agg.FilesAccessStats()
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Queries > stats[j].Queries })
+	json.NewEncoder(w).Encode(stats)
+}
+
+func replaceStateFiles(w http.ResponseWriter, r *http.Request, node *node.ErigonNode) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	agg := node.Backend().Agg()
+	if agg == nil {
+		http.Error(w, "HistoryV3 is not enabled", http.StatusNotFound)
+		return
+	}
+	files := r.URL.Query().Get("files")
+	if files == "" {
+		http.Error(w, "files parameter is required", http.StatusBadRequest)
+		return
+	}
+	replaced, err := agg.ReplaceFiles(r.Context(), strings.Split(files, ","))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(replaced)
+}
@@ -90,6 +90,9 @@ type Config struct {
 	// For deposit contract logs are needed by CL to validate/produce blocks.
 	// All logs should be available to a validating node through eth_getLogs
 	NoPruneContracts map[common.Address]bool `json:"noPruneContracts,omitempty"`
+
+	// Encoding of accounts in state, see types.AccountCodec. Empty - "v3"
+	AccountCodec string `json:"accountCodec,omitempty"`
 }
 
 type BorConfig interface {
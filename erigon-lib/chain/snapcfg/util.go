@@ -405,6 +405,11 @@ var KnownWebseeds = map[string][]string{
 	networkname.ChiadoChainName:     webseedsParse(webseed.Chiado),
 }
 
+// KnownManifestSigners - hex-encoded ed25519 public keys of publishers, trusted to sign snaptype.Manifest of chain.
+// Publisher adds its key here with first release of signed manifest. Users add more by --snap.manifest.signers.
+// If chain has no signers - manifest is not fetched from webseeds, and only integrity (root) of local one is checked.
+var KnownManifestSigners = map[string][]string{}
+
 func webseedsParse(in []byte) (res []string) {
 	a := map[string]string{}
 	if err := toml.Unmarshal(in, &a); err != nil {
@@ -1275,11 +1275,15 @@ func (bph *BinPatriciaHashed) RootHash() ([]byte, error) {
 }
 
 func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error) {
+	return bph.ReviewKeysIter(SliceKeys(plainKeys, hashedKeys))
+}
+
+// ReviewKeysIter - like ReviewKeys, but keys are streamed: caller doesn't need to hold all touched keys in RAM
+func (bph *BinPatriciaHashed) ReviewKeysIter(keys KeysIter) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error) {
 	branchNodeUpdates = make(map[string]BranchData)
 
 	stagedBinaryCell := new(BinaryCell)
-	for i, hashedKey := range hashedKeys {
-		plainKey := plainKeys[i]
+	err = keys(func(plainKey, hashedKey []byte) error {
 		hashedKey = hexToBin(hashedKey)
 		if bph.trace {
 			fmt.Printf("plainKey=[%x], hashedKey=[%x], currentKey=[%x]\n", plainKey, hashedKey, bph.currentKey[:bph.currentKeyLen])
@@ -1287,7 +1291,7 @@ func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 		// Keep folding until the currentKey is the prefix of the key we modify
 		for bph.needFolding(hashedKey) {
 			if branchData, updateKey, err := bph.fold(); err != nil {
-				return nil, nil, fmt.Errorf("fold: %w", err)
+				return fmt.Errorf("fold: %w", err)
 			} else if branchData != nil {
 				branchNodeUpdates[string(updateKey)] = branchData
 			}
@@ -1295,7 +1299,7 @@ func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 		// Now unfold until we step on an empty cell
 		for unfolding := bph.needUnfolding(hashedKey); unfolding > 0; unfolding = bph.needUnfolding(hashedKey) {
 			if err := bph.unfold(hashedKey, unfolding); err != nil {
-				return nil, nil, fmt.Errorf("unfold: %w", err)
+				return fmt.Errorf("unfold: %w", err)
 			}
 		}
 
@@ -1303,7 +1307,7 @@ func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 		stagedBinaryCell.fillEmpty()
 		if len(plainKey) == bph.accountKeyLen {
 			if err := bph.accountFn(plainKey, stagedBinaryCell); err != nil {
-				return nil, nil, fmt.Errorf("accountFn for key %x failed: %w", plainKey, err)
+				return fmt.Errorf("accountFn for key %x failed: %w", plainKey, err)
 			}
 			if !stagedBinaryCell.Delete {
 				cell := bph.updateBinaryCell(plainKey, hashedKey)
@@ -1314,8 +1318,8 @@ func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 				}
 			}
 		} else {
-			if err = bph.storageFn(plainKey, stagedBinaryCell); err != nil {
-				return nil, nil, fmt.Errorf("storageFn for key %x failed: %w", plainKey, err)
+			if err := bph.storageFn(plainKey, stagedBinaryCell); err != nil {
+				return fmt.Errorf("storageFn for key %x failed: %w", plainKey, err)
 			}
 			if !stagedBinaryCell.Delete {
 				bph.updateBinaryCell(plainKey, hashedKey).setStorage(stagedBinaryCell.Storage[:stagedBinaryCell.StorageLen])
@@ -1331,6 +1335,10 @@ func (bph *BinPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 			}
 			bph.deleteBinaryCell(hashedKey)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 	// Folding everything up to the root
 	for bph.activeRows > 0 {
@@ -26,6 +26,9 @@ type Trie interface {
 
 	ReviewKeys(pk, hk [][]byte) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error)
 
+	// ReviewKeysIter - same as ReviewKeys, keys are read from iterator
+	ReviewKeysIter(keys KeysIter) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error)
+
 	ProcessUpdates(pk, hk [][]byte, updates []Update) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error)
 
 	ResetFns(
@@ -38,6 +41,22 @@ type Trie interface {
 	SetTrace(bool)
 }
 
+// KeysIter - calls yield for each touched key (plain and hashed) in order of hashed keys, stops at first error.
+// Keys may be reused by iterator after yield returns.
+type KeysIter func(yield func(plainKey, hashedKey []byte) error) error
+
+// SliceKeys - KeysIter over in-memory lists of keys
+func SliceKeys(plainKeys, hashedKeys [][]byte) KeysIter {
+	return func(yield func(plainKey, hashedKey []byte) error) error {
+		for i, hashedKey := range hashedKeys {
+			if err := yield(plainKeys[i], hashedKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 type TrieVariant string
 
 const (
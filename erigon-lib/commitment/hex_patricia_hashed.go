@@ -1250,18 +1250,22 @@ func (hph *HexPatriciaHashed) RootHash() ([]byte, error) {
 }
 
 func (hph *HexPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error) {
+	return hph.ReviewKeysIter(SliceKeys(plainKeys, hashedKeys))
+}
+
+// ReviewKeysIter - like ReviewKeys, but keys are streamed: caller doesn't need to hold all touched keys in RAM
+func (hph *HexPatriciaHashed) ReviewKeysIter(keys KeysIter) (rootHash []byte, branchNodeUpdates map[string]BranchData, err error) {
 	branchNodeUpdates = make(map[string]BranchData)
 
 	stagedCell := new(Cell)
-	for i, hashedKey := range hashedKeys {
-		plainKey := plainKeys[i]
+	err = keys(func(plainKey, hashedKey []byte) error {
 		if hph.trace {
 			fmt.Printf("plainKey=[%x], hashedKey=[%x], currentKey=[%x]\n", plainKey, hashedKey, hph.currentKey[:hph.currentKeyLen])
 		}
 		// Keep folding until the currentKey is the prefix of the key we modify
 		for hph.needFolding(hashedKey) {
 			if branchData, updateKey, err := hph.fold(); err != nil {
-				return nil, nil, fmt.Errorf("fold: %w", err)
+				return fmt.Errorf("fold: %w", err)
 			} else if branchData != nil {
 				branchNodeUpdates[string(updateKey)] = branchData
 			}
@@ -1269,7 +1273,7 @@ func (hph *HexPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 		// Now unfold until we step on an empty cell
 		for unfolding := hph.needUnfolding(hashedKey); unfolding > 0; unfolding = hph.needUnfolding(hashedKey) {
 			if err := hph.unfold(hashedKey, unfolding); err != nil {
-				return nil, nil, fmt.Errorf("unfold: %w", err)
+				return fmt.Errorf("unfold: %w", err)
 			}
 		}
 
@@ -1277,7 +1281,7 @@ func (hph *HexPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 		stagedCell.fillEmpty()
 		if len(plainKey) == hph.accountKeyLen {
 			if err := hph.accountFn(plainKey, stagedCell); err != nil {
-				return nil, nil, fmt.Errorf("accountFn for key %x failed: %w", plainKey, err)
+				return fmt.Errorf("accountFn for key %x failed: %w", plainKey, err)
 			}
 			if !stagedCell.Delete {
 				cell := hph.updateCell(plainKey, hashedKey)
@@ -1288,8 +1292,8 @@ func (hph *HexPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 				}
 			}
 		} else {
-			if err = hph.storageFn(plainKey, stagedCell); err != nil {
-				return nil, nil, fmt.Errorf("storageFn for key %x failed: %w", plainKey, err)
+			if err := hph.storageFn(plainKey, stagedCell); err != nil {
+				return fmt.Errorf("storageFn for key %x failed: %w", plainKey, err)
 			}
 			if !stagedCell.Delete {
 				hph.updateCell(plainKey, hashedKey).setStorage(stagedCell.Storage[:stagedCell.StorageLen])
@@ -1305,6 +1309,10 @@ func (hph *HexPatriciaHashed) ReviewKeys(plainKeys, hashedKeys [][]byte) (rootHa
 			}
 			hph.deleteCell(hashedKey)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 	// Folding everything up to the root
 	for hph.activeRows > 0 {
@@ -0,0 +1,103 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datadir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+var ErrSnapshotsDirLocked = errors.New("snapshots dir is used for writes by another process")
+
+const (
+	snapshotsLockFile  = "WRITER_LOCK"
+	snapshotsOwnerFile = "WRITER_LOCK.owner" // separated from lock file: on some OS locked file can't be written
+)
+
+// SnapshotsLockOwner - metadata of process which holds writer lock of snapshots dir. Only for humans.
+type SnapshotsLockOwner struct {
+	Pid      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Cmd      string    `json:"cmd"`
+	Since    time.Time `json:"since"`
+}
+
+func (o SnapshotsLockOwner) String() string {
+	return fmt.Sprintf("pid=%d, host=%s, cmd=%q, since=%s", o.Pid, o.Hostname, o.Cmd, o.Since.Format(time.RFC3339))
+}
+
+// SnapshotsLock - advisory cross-process lock of snapshots dir. Taken by process which does
+// destructive operations on files (build, merge, delete). Readers don't need it.
+type SnapshotsLock struct {
+	dir  string
+	lock *flock.Flock
+}
+
+// TryLockSnapshots - returns ErrSnapshotsDirLocked (with owner info) if dir is locked by another process
+func TryLockSnapshots(dir string) (*SnapshotsLock, error) {
+	l := flock.New(filepath.Join(dir, snapshotsLockFile))
+	locked, err := l.TryLock()
+	if err != nil {
+		return nil, convertFileLockError(err)
+	}
+	if !locked {
+		if owner, err := ReadSnapshotsLockOwner(dir); err == nil {
+			return nil, fmt.Errorf("%w: %s, dir=%s", ErrSnapshotsDirLocked, owner, dir)
+		}
+		return nil, fmt.Errorf("%w: dir=%s", ErrSnapshotsDirLocked, dir)
+	}
+
+	hostname, _ := os.Hostname()
+	owner := SnapshotsLockOwner{Pid: os.Getpid(), Hostname: hostname, Cmd: strings.Join(os.Args, " "), Since: time.Now()}
+	data, err := json.Marshal(owner)
+	if err != nil {
+		_ = l.Unlock()
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotsOwnerFile), data, 0644); err != nil {
+		_ = l.Unlock()
+		return nil, err
+	}
+	return &SnapshotsLock{dir: dir, lock: l}, nil
+}
+
+// ReadSnapshotsLockOwner - owner of last taken lock. Doesn't check if lock is still taken.
+func ReadSnapshotsLockOwner(dir string) (owner SnapshotsLockOwner, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotsOwnerFile))
+	if err != nil {
+		return owner, err
+	}
+	if err = json.Unmarshal(data, &owner); err != nil {
+		return owner, err
+	}
+	return owner, nil
+}
+
+func (l *SnapshotsLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	_ = os.Remove(filepath.Join(l.dir, snapshotsOwnerFile))
+	return l.lock.Unlock()
+}
@@ -0,0 +1,28 @@
+package datadir
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTryLockSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	l, err := TryLockSnapshots(dir)
+	require.NoError(t, err)
+
+	owner, err := ReadSnapshotsLockOwner(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), owner.Pid)
+
+	_, err = TryLockSnapshots(dir)
+	require.True(t, errors.Is(err, ErrSnapshotsDirLocked), err)
+	require.Contains(t, err.Error(), owner.String())
+
+	require.NoError(t, l.Unlock())
+	l, err = TryLockSnapshots(dir)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+}
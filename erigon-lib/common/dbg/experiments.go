@@ -33,6 +33,50 @@ var (
 
 var StagesOnlyBlocks = EnvBool("STAGES_ONLY_BLOCKS", false)
 
+// evict files produced by background merges (and their source files) from page cache: merges read and write
+// hundreds of GB and evict hot data
+var MergeDropPageCache = EnvBool("MERGE_DROP_PAGE_CACHE", false)
+
+// pin compression workers to NUMA nodes (round-robin): less cross-node memory traffic on multi-socket servers
+var CompressNUMAPin = EnvBool("COMPRESS_NUMA_PIN", false)
+
+// log every decision to delete state file (after merge) and every unlink at Info level (Debug by default)
+var SnapshotsDeleteAudit = EnvBool("SNAPSHOTS_DELETE_AUDIT", false)
+
+// don't unlink state files which became useless after merge - only log them. To diagnose "my file disappeared" reports
+var SnapshotsDeleteDryRun = EnvBool("SNAPSHOTS_DELETE_DRY_RUN", false)
+
+// track stack of every reference to state file: report leaked references (contexts which were never closed),
+// refcount underflow and reads of already closed files
+var TraceFilesRefs = EnvBool("TRACE_FILES_REFS", false)
+
+// amount of keys touched by batch, kept in RAM for commitment evaluation - then spilled to tmpdir. 0 - never spill
+var CommitmentSpillKeys = EnvInt("COMMITMENT_SPILL_KEYS", 0)
+
+// after unwind of Exec stage (HistoryV3): compare every unwound key of PlainState with history as of unwind target,
+// report mismatches per domain and fail the unwind. Expensive for deep unwinds
+var VerifyUnwind = EnvBool("VERIFY_UNWIND", false)
+
+// new .kv/.v state files get per-block checksums (see seg.Compressor.SetChecksums): corrupted block of file is detected
+// on read. Files without checksums are read as before
+var SegChecksums = EnvBool("SEG_CHECKSUMS", false)
+
+// storage of SELFDESTRUCT-ed contract is deleted lazily: execution of tx doesn't walk its slots. They are walked once
+// later - when step of legacy Aggregator is collated (prefix tombstones), or when StateV3 is flushed (history of slots)
+var LazySelfDestruct = EnvBool("LAZY_SELFDESTRUCT", false)
+
+// Exec (HistoryV3): read accounts, code and access-list storage of next block into page cache while current block
+// is executed - see state.PrefetcherV3
+var ExecPrefetch = EnvBool("EXEC_PREFETCH", false)
+
+// buffered history WAL keeps previous values snappy-compressed (in RAM and in spilled tmp files): more steps fit
+// before flush to DB. Values are decompressed on flush
+var CompressWAL = EnvBool("COMPRESS_WAL", false)
+
+// Exec (HistoryV3, not parallel): record writes of executed blocks to <datadir>/exec.wal, after crash they are
+// replayed on startup instead of re-execution - see state.StateWAL
+var ExecWAL = EnvBool("EXEC_WAL", false)
+
 var doMemstat = true
 
 func init() {
@@ -17,8 +17,11 @@
 package dir
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -43,6 +46,18 @@ func Exist(path string) bool {
 	return true
 }
 
+// ReadOnlyFS - dir is on read-only mount (NFS export, read-only container volume, ...).
+// Probing by creating of temporary file, because mount flags are not portable.
+func ReadOnlyFS(dir string) bool {
+	f, err := os.CreateTemp(dir, ".rw-probe-*")
+	if err != nil {
+		return errors.Is(err, syscall.EROFS)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return false
+}
+
 func FileExist(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil && os.IsNotExist(err) {
@@ -148,3 +163,35 @@ func ListFiles(dir string, extensions ...string) (paths []string, err error) {
 	}
 	return paths, nil
 }
+
+// LinkOrCopyFile - hard-link `src` to `dst`, or copy it if they are on different filesystems. Existing `dst` is kept:
+// it's for immutable files - same name means same content.
+func LinkOrCopyFile(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil { // backup into existing dir: files are immutable, same name - same content
+		return nil
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmpPath := dst + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
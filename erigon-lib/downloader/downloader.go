@@ -1004,6 +1004,15 @@ func (d *Downloader) mainLoop(silent bool) error {
 				}
 
 				switch {
+				case d.cfg.WebSeedOnly:
+					if t.Info() == nil { // .torrent file is not downloaded from webseed yet
+						continue
+					}
+					d.logger.Debug("[snapshots] Downloading from webseed over http", "file", t.Name())
+					delete(waiting, t.Name())
+					if err := d.httpWebseedDownload(t, downloadComplete, sem); err != nil {
+						d.logger.Debug("[snapshots] Can't start webseed http download", "file", t.Name(), "err", err)
+					}
 				case len(t.PeerConns()) > 0:
 					d.logger.Debug("[snapshots] Downloading from torrent", "file", t.Name(), "peers", len(t.PeerConns()))
 					delete(waiting, t.Name())
@@ -2093,9 +2102,10 @@ func (d *Downloader) VerifyData(ctx context.Context, whiteList []string, failFas
 // have .seg no .torrent => get .torrent from .seg
 func (d *Downloader) AddNewSeedableFile(ctx context.Context, name string) error {
 	ff, isStateFile, ok := snaptype.ParseFileName("", name)
+	canonical, isCanonical := d.canonicalFile(name)
 	if ok {
 		if isStateFile {
-			if !snaptype.E3Seedable(name) {
+			if !isCanonical && !snaptype.E3Seedable(name) {
 				return nil
 			}
 		} else {
@@ -2106,6 +2116,7 @@ func (d *Downloader) AddNewSeedableFile(ctx context.Context, name string) error
 	}
 
 	// if we don't have the torrent file we build it if we have the .seg file
+	hadTorrentFile := d.torrentFiles.Exists(name)
 	err := BuildTorrentIfNeed(ctx, name, d.SnapDir(), d.torrentFiles)
 	if err != nil {
 		return fmt.Errorf("AddNewSeedableFile: %w", err)
@@ -2114,6 +2125,19 @@ func (d *Downloader) AddNewSeedableFile(ctx context.Context, name string) error
 	if err != nil {
 		return fmt.Errorf("AddNewSeedableFile: %w", err)
 	}
+	if isCanonical {
+		// locally built file has same name as published one, but different content: seeding it would split swarm
+		if canonical.Hash != ts.InfoHash.HexString() {
+			d.logger.Warn("[snapshots] locally built file doesn't match published one, not seeding", "file", name, "expected", canonical.Hash, "got", ts.InfoHash.HexString())
+			if !hadTorrentFile {
+				if err := d.torrentFiles.Delete(name); err != nil {
+					d.logger.Debug("[snapshots] remove .torrent", "file", name, "err", err)
+				}
+			}
+			return nil
+		}
+		d.logger.Debug("[snapshots] seeding locally built file", "file", name, "hash", canonical.Hash)
+	}
 	_, _, err = addTorrentFile(ctx, ts, d.torrentClient, d.db, d.webseeds)
 	if err != nil {
 		return fmt.Errorf("addTorrentFile: %w", err)
@@ -2121,6 +2145,14 @@ func (d *Downloader) AddNewSeedableFile(ctx context.Context, name string) error
 	return nil
 }
 
+// canonicalFile - published (preverified) file with given name
+func (d *Downloader) canonicalFile(name string) (snapcfg.PreverifiedItem, bool) {
+	if d.cfg.SnapshotConfig == nil {
+		return snapcfg.PreverifiedItem{}, false
+	}
+	return d.cfg.SnapshotConfig.Preverified.Get(filepath.ToSlash(name))
+}
+
 func (d *Downloader) alreadyHaveThisName(name string) bool {
 	for _, t := range d.torrentClient.Torrents() {
 		if t.Info() != nil {
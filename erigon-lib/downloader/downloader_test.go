@@ -2,10 +2,12 @@ package downloader
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
 	lg "github.com/anacrolix/log"
+	"github.com/ledgerwatch/erigon-lib/chain/snapcfg"
 	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	downloadercfg2 "github.com/ledgerwatch/erigon-lib/downloader/downloadercfg"
 	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
@@ -65,3 +67,38 @@ func TestNoEscape(t *testing.T) {
 	err = BuildTorrentIfNeed(ctx, "./../a.seg", dirs.Snap, tf)
 	require.Error(err)
 }
+
+func TestAddNewSeedableFileCanonical(t *testing.T) {
+	require := require.New(t)
+	dirs := datadir.New(t.TempDir())
+	ctx := context.Background()
+	name := "history/v1-accounts.0-1.v" // too small for E3Seedable, but published
+	err := os.WriteFile(filepath.Join(dirs.Snap, name), []byte("accounts history"), 0644)
+	require.NoError(err)
+
+	cfg, err := downloadercfg2.New(dirs, "", lg.Info, 0, 0, 0, 0, 0, nil, nil, "testnet", false)
+	require.NoError(err)
+	cfg.SnapshotConfig = &snapcfg.Cfg{Preverified: snapcfg.Preverified{{Name: name, Hash: "aa"}}}
+	d, err := New(ctx, cfg, dirs, log.New(), log.LvlInfo, true)
+	require.NoError(err)
+	defer d.Close()
+
+	// hash doesn't match published one: not seeded
+	err = d.AddNewSeedableFile(ctx, name)
+	require.NoError(err)
+	require.False(d.torrentFiles.Exists(name))
+	require.Equal(0, len(d.torrentClient.Torrents()))
+
+	err = BuildTorrentIfNeed(ctx, name, dirs.Snap, d.torrentFiles)
+	require.NoError(err)
+	ts, err := d.torrentFiles.LoadByName(name)
+	require.NoError(err)
+	require.NoError(d.torrentFiles.Delete(name))
+	cfg.SnapshotConfig.Preverified[0].Hash = ts.InfoHash.HexString()
+
+	// hash matches: seeded
+	err = d.AddNewSeedableFile(ctx, name)
+	require.NoError(err)
+	_, ok := d.torrentClient.Torrent(ts.InfoHash)
+	require.True(ok)
+}
@@ -53,6 +53,7 @@ type Cfg struct {
 	WebSeedFiles                    []string
 	SnapshotConfig                  *snapcfg.Cfg
 	DownloadTorrentFilesFromWebseed bool
+	WebSeedOnly                     bool // download files only over HTTP(S) webseeds: for networks where BitTorrent is blocked
 	AddTorrentsFromDisk             bool
 	SnapshotLock                    bool
 	ChainName                       string
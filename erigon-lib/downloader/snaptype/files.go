@@ -182,6 +182,24 @@ func IsStateFile(name string) (ok bool) {
 	return true
 }
 
+// StateFileSteps - steps range [from, to) of state file. Example: `history/v1-accounts.0-32.v` -> 0, 32
+func StateFileSteps(name string) (from, to uint64, ok bool) {
+	_, name = filepath.Split(name)
+	subs := stateFileRegex.FindStringSubmatch(name)
+	if len(subs) != 6 {
+		return 0, 0, false
+	}
+	from, err := strconv.ParseUint(subs[3], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	to, err = strconv.ParseUint(subs[4], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
 const Erigon3SeedableSteps = 32
 
 // Use-cases:
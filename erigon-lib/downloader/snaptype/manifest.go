@@ -0,0 +1,319 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snaptype
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFileName - signed list of published frozen files. Lives in `snapshots` dir.
+const ManifestFileName = "snapshots-manifest.json"
+
+// PeerManifestFileName - manifest of state peer (see --snap.state.peers). Files downloaded by it are verified against it.
+// Kept separately: local ManifestFileName is published by chain's known signers and never replaced by peer's one.
+const PeerManifestFileName = "snapshots-manifest-peer.json"
+
+const ManifestVersion = 1
+
+type ManifestEntry struct {
+	Name   string `json:"name"` // path relative to `snapshots` dir. Example: `history/v1-accounts.0-32.v`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// TorrentHash - hex infohash of file's .torrent, if publisher seeds it. Not part of Root:
+	// file downloaded by it is verified by Sha256 anyway (see VerifyFiles)
+	TorrentHash string `json:"torrent_hash,omitempty"`
+}
+
+// Manifest - list of files with sizes and hashes. Root is merkle root of entries, Signature - ed25519 signature of Root.
+type Manifest struct {
+	Version   int             `json:"version"`
+	Files     []ManifestEntry `json:"files"`
+	Root      string          `json:"root"`
+	Signer    string          `json:"signer,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// BuildManifest - hash files `names` (relative to `snapDir`) and calc merkle root. Manifest is not signed.
+func BuildManifest(ctx context.Context, snapDir string, names []string) (*Manifest, error) {
+	m := &Manifest{Version: ManifestVersion, Files: make([]ManifestEntry, 0, len(names))}
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		size, h, err := sha256File(filepath.Join(snapDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("BuildManifest: %w", err)
+		}
+		m.Files = append(m.Files, ManifestEntry{Name: filepath.ToSlash(name), Size: size, Sha256: hex.EncodeToString(h)})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	m.Root = hex.EncodeToString(m.merkleRoot())
+	return m, nil
+}
+
+func (m *Manifest) Sign(key ed25519.PrivateKey) {
+	m.Signer = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	m.Signature = hex.EncodeToString(ed25519.Sign(key, m.merkleRoot()))
+}
+
+// Verify - check that Root matches entries and (if `trustedSigners` not empty) that Root is signed by one of them
+func (m *Manifest) Verify(trustedSigners []string) error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("manifest: unsupported version %d", m.Version)
+	}
+	root := m.merkleRoot()
+	if hex.EncodeToString(root) != m.Root {
+		return fmt.Errorf("manifest: root mismatch: expected %s, got %x", m.Root, root)
+	}
+	if len(trustedSigners) == 0 {
+		return nil
+	}
+	trusted := false
+	for _, s := range trustedSigners {
+		if s == m.Signer {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("manifest: signer %s is not trusted", m.Signer)
+	}
+	pub, err := hex.DecodeString(m.Signer)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("manifest: invalid signer %s", m.Signer)
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("manifest: invalid signature: %w", err)
+	}
+	if !ed25519.Verify(pub, root, sig) {
+		return fmt.Errorf("manifest: bad signature")
+	}
+	return nil
+}
+
+// VerifyFiles - check size and hash of every file in manifest which exists in `snapDir` and matches `filter`.
+// Files which are not in manifest are ignored - they are not published yet (for example: produced locally).
+func (m *Manifest) VerifyFiles(ctx context.Context, snapDir string, filter func(name string) bool) (checked int, err error) {
+	return m.verifyFiles(ctx, snapDir, filter, nil)
+}
+
+// VerifiedFilesCacheName - cache of VerifyFilesCached in `snapshots` dir: name -> size, modification time and hash of
+// file when it was verified. Safe to delete.
+const VerifiedFilesCacheName = "snapshots-manifest-verified.json"
+
+type verifiedFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"` // unix nano
+	Sha256  string `json:"sha256"`
+}
+
+// VerifyFilesCached - like VerifyFiles, but files which have same size and modification time as when they were verified
+// last time are not hashed again (see VerifiedFilesCacheName): for verification on every start.
+func (m *Manifest) VerifyFilesCached(ctx context.Context, snapDir string, filter func(name string) bool) (checked int, err error) {
+	cachePath := filepath.Join(snapDir, VerifiedFilesCacheName)
+	cache := map[string]verifiedFile{}
+	if data, err := os.ReadFile(cachePath); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil { // broken cache - just verify all files
+			cache = map[string]verifiedFile{}
+		}
+	}
+	checked, err = m.verifyFiles(ctx, snapDir, filter, cache)
+	if err != nil {
+		return checked, err
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return checked, err
+	}
+	if err = os.WriteFile(cachePath+".tmp", data, 0644); err != nil {
+		return checked, err
+	}
+	return checked, os.Rename(cachePath+".tmp", cachePath)
+}
+
+// verifyFiles - if `cache` is not nil: files found in it are not hashed, verified files are added to it
+func (m *Manifest) verifyFiles(ctx context.Context, snapDir string, filter func(name string) bool, cache map[string]verifiedFile) (checked int, err error) {
+	for _, f := range m.Files {
+		if filter != nil && !filter(f.Name) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return checked, ctx.Err()
+		default:
+		}
+		fPath := filepath.Join(snapDir, filepath.FromSlash(f.Name))
+		st, err := os.Stat(fPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return checked, err
+		}
+		if st.Size() != f.Size {
+			return checked, fmt.Errorf("manifest: file %s size mismatch: expected %d, got %d", f.Name, f.Size, st.Size())
+		}
+		verified := verifiedFile{Size: st.Size(), ModTime: st.ModTime().UnixNano(), Sha256: f.Sha256}
+		if cached, ok := cache[f.Name]; ok && cached == verified {
+			checked++
+			continue
+		}
+		_, h, err := sha256File(fPath)
+		if err != nil {
+			return checked, err
+		}
+		if hex.EncodeToString(h) != f.Sha256 {
+			return checked, fmt.Errorf("manifest: file %s hash mismatch: expected %s, got %x", f.Name, f.Sha256, h)
+		}
+		if cache != nil {
+			cache[f.Name] = verified
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+// Missing - entries matching `filter` which are absent in `snapDir` or have different size: what local node must download
+// to have all files of manifest. Hashes are not checked here - see VerifyFiles.
+func (m *Manifest) Missing(snapDir string, filter func(name string) bool) (missing []ManifestEntry, err error) {
+	for _, f := range m.Files {
+		if filter != nil && !filter(f.Name) {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(snapDir, filepath.FromSlash(f.Name)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				missing = append(missing, f)
+				continue
+			}
+			return nil, err
+		}
+		if st.Size() != f.Size {
+			missing = append(missing, f)
+		}
+	}
+	return missing, nil
+}
+
+// merkleRoot - binary sha256 tree. leaf = sha256(name_len | name | size | file_sha256), odd node promoted as-is.
+func (m *Manifest) merkleRoot() []byte {
+	if len(m.Files) == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	level := make([][]byte, 0, len(m.Files))
+	for _, f := range m.Files {
+		fileHash, _ := hex.DecodeString(f.Sha256)
+		hasher := sha256.New()
+		var num [8]byte
+		binary.BigEndian.PutUint64(num[:], uint64(len(f.Name)))
+		hasher.Write(num[:])
+		hasher.Write([]byte(f.Name))
+		binary.BigEndian.PutUint64(num[:], uint64(f.Size))
+		hasher.Write(num[:])
+		hasher.Write(fileHash)
+		level = append(level, hasher.Sum(nil))
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, h[:])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func ReadManifest(fPath string) (*Manifest, error) {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", fPath, err)
+	}
+	return m, nil
+}
+
+// FetchManifest - download manifest published by peer, for example: `http://peer:8080/snapshots-manifest.json`
+func FetchManifest(ctx context.Context, client *http.Client, url string) (*Manifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest %s: unexpected status %s", url, resp.Status)
+	}
+	m := &Manifest{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", url, err)
+	}
+	return m, nil
+}
+
+const maxManifestSize = 64 * 1024 * 1024
+
+func WriteManifest(fPath string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := fPath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fPath)
+}
+
+func sha256File(fPath string) (int64, []byte, error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, h.Sum(nil), nil
+}
@@ -0,0 +1,93 @@
+package snaptype
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	require := require.New(t)
+	ctx, snapDir := context.Background(), t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(snapDir, "history"), 0755))
+	names := []string{"history/v1-accounts.0-32.v", "history/v1-accounts.0-32.ef", "history/v1-storage.0-32.v"}
+	for i, name := range names {
+		require.NoError(os.WriteFile(filepath.Join(snapDir, name), []byte{byte(i), 1, 2, 3}, 0644))
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	m, err := BuildManifest(ctx, snapDir, names)
+	require.NoError(err)
+	m.Sign(priv)
+	manifestPath := filepath.Join(snapDir, ManifestFileName)
+	require.NoError(WriteManifest(manifestPath, m))
+
+	m, err = ReadManifest(manifestPath)
+	require.NoError(err)
+	require.NoError(m.Verify(nil))
+	require.NoError(m.Verify([]string{hex.EncodeToString(pub)}))
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	require.Error(m.Verify([]string{hex.EncodeToString(otherPub)}))
+
+	checked, err := m.VerifyFiles(ctx, snapDir, IsStateFile)
+	require.NoError(err)
+	require.Equal(3, checked)
+
+	checked, err = m.VerifyFilesCached(ctx, snapDir, IsStateFile)
+	require.NoError(err)
+	require.Equal(3, checked)
+	require.FileExists(filepath.Join(snapDir, VerifiedFilesCacheName))
+
+	// corrupted file
+	require.NoError(os.WriteFile(filepath.Join(snapDir, names[2]), []byte{9, 1, 2, 3}, 0644))
+	_, err = m.VerifyFiles(ctx, snapDir, IsStateFile)
+	require.ErrorContains(err, "hash mismatch")
+	// file changed after it was verified (new modification time): hashed again
+	require.NoError(os.Chtimes(filepath.Join(snapDir, names[2]), time.Now(), time.Now().Add(time.Hour)))
+	_, err = m.VerifyFilesCached(ctx, snapDir, IsStateFile)
+	require.ErrorContains(err, "hash mismatch")
+
+	// tampered entry breaks root
+	m.Files[0].Size++
+	require.Error(m.Verify(nil))
+}
+
+func TestManifestMissing(t *testing.T) {
+	require := require.New(t)
+	ctx, peerDir, localDir := context.Background(), t.TempDir(), t.TempDir()
+	names := []string{"history/v1-accounts.0-32.v", "history/v1-accounts.32-48.v", "history/v1-accounts.48-50.v"}
+	for _, dir := range []string{peerDir, localDir} {
+		require.NoError(os.MkdirAll(filepath.Join(dir, "history"), 0755))
+	}
+	for _, name := range names {
+		require.NoError(os.WriteFile(filepath.Join(peerDir, name), []byte{1, 2, 3}, 0644))
+	}
+	m, err := BuildManifest(ctx, peerDir, names)
+	require.NoError(err)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(peerDir)))
+	defer srv.Close()
+	require.NoError(WriteManifest(filepath.Join(peerDir, ManifestFileName), m))
+	m, err = FetchManifest(ctx, srv.Client(), srv.URL+"/"+ManifestFileName)
+	require.NoError(err)
+	require.NoError(m.Verify(nil))
+
+	// local node has first file and partial second file
+	require.NoError(os.WriteFile(filepath.Join(localDir, names[0]), []byte{1, 2, 3}, 0644))
+	require.NoError(os.WriteFile(filepath.Join(localDir, names[1]), []byte{1}, 0644))
+	missing, err := m.Missing(localDir, IsStateFile)
+	require.NoError(err)
+	require.Equal(2, len(missing))
+	require.Equal(names[1], missing[0].Name)
+	require.Equal(names[2], missing[1].Name)
+}
@@ -101,7 +101,7 @@ func seedableSegmentFiles(dir string, chainName string) ([]string, error) {
 func seedableStateFilesBySubDir(dir, subDir string) ([]string, error) {
 	historyDir := filepath.Join(dir, subDir)
 	dir2.MustExist(historyDir)
-	files, err := dir2.ListFiles(historyDir, ".kv", ".v", ".ef")
+	files, err := dir2.ListFiles(historyDir, ".kv", ".vlog", ".v", ".ef")
 	if err != nil {
 		return nil, err
 	}
@@ -162,6 +162,21 @@ func BuildTorrentIfNeed(ctx context.Context, fName, root string, torrentFiles *T
 	return CreateTorrentFileFromInfo(root, info, nil, torrentFiles)
 }
 
+// InfoHashOfFile - infohash of torrent which would be built for file `name` (relative to snapshots dir) with content
+// of `fPath`. Allows to verify file fetched not by BitTorrent (http mirror, rsync, ...) against preverified hash.
+func InfoHashOfFile(fPath, name string) (metainfo.Hash, error) {
+	info := &metainfo.Info{PieceLength: downloadercfg.DefaultPieceSize, Name: name}
+	if err := info.BuildFromFilePath(fPath); err != nil {
+		return metainfo.Hash{}, fmt.Errorf("InfoHashOfFile: %w", err)
+	}
+	info.Name = name
+	mi, err := CreateMetaInfo(info, nil)
+	if err != nil {
+		return metainfo.Hash{}, err
+	}
+	return mi.HashInfoBytes(), nil
+}
+
 // BuildTorrentFilesIfNeed - create .torrent files from .seg files (big IO) - if .seg files were added manually
 func BuildTorrentFilesIfNeed(ctx context.Context, dirs datadir.Dirs, torrentFiles *TorrentFiles, chain string, ignore snapcfg.Preverified) error {
 	logEvery := time.NewTicker(20 * time.Second)
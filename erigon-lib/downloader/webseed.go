@@ -222,7 +222,7 @@ func (d *WebSeeds) downloadTorrentFilesFromProviders(ctx context.Context, rootDi
 	for fileName, tUrls := range urlsByName {
 		name := fileName
 		addedNew++
-		if !strings.HasSuffix(name, ".seg.torrent") {
+		if !webseedTorrentSupported(name) {
 			_, fName := filepath.Split(name)
 			d.logger.Log(d.verbosity, "[snapshots] webseed has .torrent, but we skip it because this file-type not supported yet", "name", fName)
 			continue
@@ -317,6 +317,12 @@ func validateTorrentBytes(fileName string, b []byte, whitelist snapcfg.Preverifi
 	return nil
 }
 
+// webseedTorrentSupported - block files (.seg) and state/history files (.kv, .v, .ef, ...) can be distributed by webseeds
+func webseedTorrentSupported(torrentFileName string) bool {
+	_, _, ok := snaptype.ParseFileName("", strings.TrimSuffix(torrentFileName, ".torrent"))
+	return ok
+}
+
 func nameWhitelisted(fileName string, whitelist snapcfg.Preverified) bool {
 	return whitelist.Contains(strings.TrimSuffix(fileName, ".torrent"))
 }
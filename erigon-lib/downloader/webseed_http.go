@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/ledgerwatch/erigon-lib/downloader/downloadercfg"
+	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
+	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/sync/semaphore"
+)
+
+// webseedHttpClient - no global timeout: files are big. Stalled connections are detected by server/tcp keepalive.
+var webseedHttpClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	ResponseHeaderTimeout: time.Minute,
+	IdleConnTimeout:       time.Minute,
+	MaxIdleConnsPerHost:   4,
+}}
+
+const partFileSuffix = ".part"
+
+// httpWebseedDownload - download file of torrent `t` over plain HTTP(S) from it's webseeds, without BitTorrent peers.
+// Used when `--webseed.only` is set: for environments where BitTorrent traffic is blocked.
+func (d *Downloader) httpWebseedDownload(t *torrent.Torrent, statusChan chan downloadStatus, sem *semaphore.Weighted) error {
+	name := t.Name()
+	urls, ok := d.webseeds.ByFileName(name)
+	if !ok || len(urls) == 0 {
+		return fmt.Errorf("no webseeds for file: %s", name)
+	}
+	info, _, ok := snaptype.ParseFileName(d.SnapDir(), name)
+	if !ok {
+		return fmt.Errorf("can't parse filename: %s", name)
+	}
+	mi := t.Metainfo()
+	tInfo := t.Info()
+	infoHash := t.InfoHash()
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&mi)
+	if err != nil {
+		return fmt.Errorf("can't get torrent spec for %s: %w", name, err)
+	}
+	spec.ChunkSize = downloadercfg.DefaultNetworkChunkSize
+	spec.DisallowDataDownload = true
+
+	d.lock.Lock()
+	t.Drop()
+	d.downloading[name] = struct{}{}
+	d.lock.Unlock()
+
+	if err := sem.Acquire(d.ctx, 1); err != nil {
+		return err
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer sem.Release(1)
+
+		var err error
+		for _, u := range urls {
+			if err = downloadFromWebseed(d.ctx, webseedHttpClient, u, tInfo, info.Path, d.logger); err == nil {
+				break
+			}
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+			d.logger.Debug("[snapshots] webseed http download failed, trying next", "file", name, "url", u, "err", err)
+		}
+		if err == nil {
+			var localHash []byte
+			localHash, err = fileHashBytes(d.ctx, info, &d.stats, d.lock)
+			if err == nil && !bytes.Equal(infoHash.Bytes(), localHash) {
+				err = fmt.Errorf("hash mismatch: expected: 0x%x, got: 0x%x", infoHash.Bytes(), localHash)
+				if ferr := os.Remove(info.Path); ferr != nil {
+					d.logger.Warn("Couldn't remove invalid file", "file", name, "path", info.Path, "err", ferr)
+				}
+			}
+		}
+		if err != nil {
+			d.logger.Warn("[snapshots] webseed http download failed", "file", name, "err", err)
+		}
+
+		statusChan <- downloadStatus{
+			name:     name,
+			length:   tInfo.TotalLength(),
+			infoHash: infoHash,
+			spec:     spec,
+			err:      err,
+		}
+	}()
+	return nil
+}
+
+// downloadFromWebseed - download `url` into `dst` file.
+//   - data written to `dst.part` file, and renamed to `dst` only after full download
+//   - download resumes from existing `dst.part` file: only pieces which pass hash-check are re-used
+//   - every piece is verified by hash from torrent's info before write
+func downloadFromWebseed(ctx context.Context, client *http.Client, url string, info *metainfo.Info, dst string, logger log.Logger) error {
+	partPath := dst + partFileSuffix
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pieceIdx, err := verifiedPiecesPrefix(f, info)
+	if err != nil {
+		return err
+	}
+	offset := int64(pieceIdx) * info.PieceLength
+	if err = f.Truncate(offset); err != nil {
+		return err
+	}
+	if pieceIdx < info.NumPieces() {
+		if offset > 0 {
+			logger.Debug("[snapshots] webseed http download resume", "file", info.Name, "from", offset)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webseed.http: %w, url=%s", err, url)
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			if offset > 0 { // server doesn't support ranges: start from scratch
+				pieceIdx, offset = 0, 0
+				if err = f.Truncate(0); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("webseed.http: unexpected status %s, url=%s", resp.Status, url)
+		}
+
+		buf := make([]byte, info.PieceLength)
+		for ; pieceIdx < info.NumPieces(); pieceIdx++ {
+			p := info.Piece(pieceIdx)
+			chunk := buf[:p.Length()]
+			if _, err = io.ReadFull(resp.Body, chunk); err != nil {
+				return fmt.Errorf("webseed.http: piece %d: %w, url=%s", pieceIdx, err, url)
+			}
+			if sum := sha1.Sum(chunk); !bytes.Equal(sum[:], p.Hash().Bytes()) { //nolint:gosec
+				return fmt.Errorf("webseed.http: piece %d hash mismatch, url=%s", pieceIdx, url)
+			}
+			if _, err = f.WriteAt(chunk, offset); err != nil {
+				return err
+			}
+			offset += int64(len(chunk))
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partPath, dst)
+}
+
+// verifiedPiecesPrefix - amount of pieces at the beginning of partially-downloaded file, which pass hash-check
+func verifiedPiecesPrefix(f *os.File, info *metainfo.Info) (int, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, info.PieceLength)
+	var i int
+	for ; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		if p.Offset()+p.Length() > st.Size() {
+			break
+		}
+		chunk := buf[:p.Length()]
+		if _, err := f.ReadAt(chunk, p.Offset()); err != nil {
+			return i, err
+		}
+		if sum := sha1.Sum(chunk); !bytes.Equal(sum[:], p.Hash().Bytes()) { //nolint:gosec
+			break
+		}
+	}
+	return i, nil
+}
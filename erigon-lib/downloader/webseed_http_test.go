@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFromWebseedResume(t *testing.T) {
+	require := require.New(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 5) // 80 bytes
+	info := &metainfo.Info{Name: "v1-000000-000500-headers.seg", PieceLength: 32, Length: int64(len(data))}
+	for i := 0; i < len(data); i += int(info.PieceLength) {
+		end := i + int(info.PieceLength)
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[i:end]) //nolint:gosec
+		info.Pieces = append(info.Pieces, sum[:]...)
+	}
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, info.Name, time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dst := filepath.Join(t.TempDir(), info.Name)
+	// first piece is valid, second is corrupted: must be re-downloaded
+	partial := append(append([]byte{}, data[:32]...), []byte("corrupted-corrupted-corrupted-xx")...)
+	require.NoError(os.WriteFile(dst+partFileSuffix, partial, 0644))
+
+	require.NoError(downloadFromWebseed(context.Background(), http.DefaultClient, srv.URL, info, dst, log.New()))
+	got, err := os.ReadFile(dst)
+	require.NoError(err)
+	require.Equal(data, got)
+	require.Equal([]string{"bytes=32-"}, ranges)
+	_, err = os.Stat(dst + partFileSuffix)
+	require.True(os.IsNotExist(err))
+
+	// corrupted server data is rejected
+	data[70] ^= 0xff
+	dst2 := filepath.Join(t.TempDir(), info.Name)
+	require.Error(downloadFromWebseed(context.Background(), http.DefaultClient, srv.URL, info, dst2, log.New()))
+	_, err = os.Stat(dst2)
+	require.True(os.IsNotExist(err))
+}
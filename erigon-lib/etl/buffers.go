@@ -48,6 +48,7 @@ type Buffer interface {
 	Put(k, v []byte)
 	Get(i int, keyBuf, valBuf []byte) ([]byte, []byte)
 	Len() int
+	Size() int // approximate size of data in RAM: upper bound of size on disk
 	Reset()
 	SizeLimit() int
 	Prealloc(predictKeysAmount, predictDataAmount int)
@@ -39,6 +39,7 @@ type fileDataProvider struct {
 	reader     io.Reader
 	byteReader io.ByteReader // Different interface to the same object as reader
 	wg         *errgroup.Group
+	release    func() // returns reserved space to TmpPolicy
 }
 
 // FlushToDisk - `doFsync` is true only for 'critical' collectors (which should not loose).
@@ -47,8 +48,17 @@ func FlushToDisk(logPrefix string, b Buffer, tmpdir string, doFsync bool, lvl lo
 		return nil, nil
 	}
 
-	provider := &fileDataProvider{reader: nil, wg: &errgroup.Group{}}
+	tmpdir, release, err := reserveTmp(tmpdir, uint64(b.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %w", logPrefix, err)
+	}
+	provider := &fileDataProvider{reader: nil, wg: &errgroup.Group{}, release: release}
 	provider.wg.Go(func() error {
+		defer func() {
+			if provider.file == nil { // Dispose releases only created files
+				release()
+			}
+		}()
 		b.Sort()
 
 		// if we are going to create files in the system temp dir, we don't need any
@@ -104,6 +114,9 @@ func (p *fileDataProvider) Dispose() {
 		_ = p.file.Close()
 		_ = os.Remove(p.file.Name())
 		p.file = nil
+		if p.release != nil {
+			p.release()
+		}
 	}
 }
 
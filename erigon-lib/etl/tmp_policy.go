@@ -0,0 +1,119 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// TmpPolicy - limits of temp files which collectors (and seg.Compressor, recsplit - through them) write into Dir (dirs.Tmp):
+//   - Quota: max total size of live temp files in Dir. 0 - unlimited
+//   - MinFree: temp file is not written if less than MinFree bytes would be left on partition of dir. 0 - off
+//   - Spill: dirs (usually on other partitions) used when Dir is over Quota or MinFree. Not limited by Quota
+//
+// Sub-dirs of Dir are mapped to same sub-dirs of spill dirs. If no dir fits - collector fails with ErrTmpQuota
+// early, instead of filling datadir partition in the middle of merge.
+type TmpPolicy struct {
+	Dir     string
+	Quota   datasize.ByteSize
+	MinFree datasize.ByteSize
+	Spill   []string
+}
+
+var ErrTmpQuota = errors.New("no space for temp files")
+
+var (
+	tmpPolicy   atomic.Pointer[TmpPolicy]
+	tmpUsedLock sync.Mutex
+	tmpUsed     = map[string]uint64{} // root dir of policy -> size of live temp files
+)
+
+// SetTmpPolicy - applied to all temp files created after call
+func SetTmpPolicy(p TmpPolicy) { tmpPolicy.Store(&p) }
+
+// diskFree - var for tests
+var diskFree = func(dir string) (uint64, error) {
+	u, err := disk.Usage(dir)
+	if err != nil {
+		return 0, err
+	}
+	return u.Free, nil
+}
+
+// PickTmpDir - dir for temp file of unknown size, which caller was going to create in `tmpdir`
+func PickTmpDir(tmpdir string) (string, error) {
+	dir, _, err := reserveTmp(tmpdir, 0)
+	return dir, err
+}
+
+// reserveTmp - dir for temp file of approximately `size` bytes, which caller was going to create in `tmpdir`.
+// `release` must be called after file is removed.
+func reserveTmp(tmpdir string, size uint64) (dir string, release func(), err error) {
+	p := tmpPolicy.Load()
+	if p == nil || p.Dir == "" || tmpdir == "" {
+		return tmpdir, func() {}, nil
+	}
+	rel, err := filepath.Rel(p.Dir, tmpdir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return tmpdir, func() {}, nil // not under policy
+	}
+
+	tmpUsedLock.Lock()
+	defer tmpUsedLock.Unlock()
+	var reasons []string
+	for i, root := range append([]string{p.Dir}, p.Spill...) {
+		dir = filepath.Join(root, rel)
+		used := tmpUsed[root]
+		if i == 0 && p.Quota > 0 && used+size > p.Quota.Bytes() {
+			reasons = append(reasons, fmt.Sprintf("%s: used %s of quota %s", root, datasize.ByteSize(used).HumanReadable(), p.Quota.HumanReadable()))
+			continue
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return "", nil, err
+		}
+		if p.MinFree > 0 {
+			free, err := diskFree(dir)
+			if err != nil {
+				return "", nil, err
+			}
+			if free < size+p.MinFree.Bytes() {
+				reasons = append(reasons, fmt.Sprintf("%s: free %s, min free %s", root, datasize.ByteSize(free).HumanReadable(), p.MinFree.HumanReadable()))
+				continue
+			}
+		}
+		if i > 0 {
+			log.Debug("[etl] spill temp file", "dir", dir, "size", datasize.ByteSize(size).HumanReadable(), "reasons", strings.Join(reasons, "; "))
+		}
+		tmpUsed[root] += size
+		return dir, func() {
+			tmpUsedLock.Lock()
+			defer tmpUsedLock.Unlock()
+			tmpUsed[root] -= size
+		}, nil
+	}
+	return "", nil, fmt.Errorf("%w: need %s in %s (%s), see --tmpdir.quota, --tmpdir.minfree, --tmpdir.spill", ErrTmpQuota, datasize.ByteSize(size).HumanReadable(), tmpdir, strings.Join(reasons, "; "))
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTmpPolicy(t *testing.T) {
+	tmp, spill := t.TempDir(), t.TempDir()
+	free := map[string]uint64{tmp: 1000, spill: 1000}
+	defer func(f func(string) (uint64, error)) { diskFree = f }(diskFree)
+	diskFree = func(dir string) (uint64, error) {
+		for root, v := range free {
+			if strings.HasPrefix(dir, root) {
+				return v, nil
+			}
+		}
+		return 0, nil
+	}
+	defer tmpPolicy.Store(nil)
+
+	SetTmpPolicy(TmpPolicy{Dir: tmp, Quota: 300, MinFree: 100})
+	dir, release1, err := reserveTmp(filepath.Join(tmp, "sub"), 200)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(tmp, "sub"), dir)
+
+	// over quota, no spill dirs
+	_, _, err = reserveTmp(tmp, 200)
+	require.ErrorIs(t, err, ErrTmpQuota)
+
+	// dirs out of policy are not limited
+	other := t.TempDir()
+	dir, _, err = reserveTmp(other, 10_000)
+	require.NoError(t, err)
+	require.Equal(t, other, dir)
+
+	// over quota - spill
+	SetTmpPolicy(TmpPolicy{Dir: tmp, Quota: 300, MinFree: 100, Spill: []string{spill}})
+	dir, release2, err := reserveTmp(filepath.Join(tmp, "sub"), 200)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(spill, "sub"), dir)
+
+	// quota released
+	release1()
+	dir, release3, err := reserveTmp(tmp, 200)
+	require.NoError(t, err)
+	require.Equal(t, tmp, dir)
+	release2()
+	release3()
+
+	// not enough free space
+	free[tmp], free[spill] = 250, 250
+	_, _, err = reserveTmp(tmp, 200)
+	require.ErrorIs(t, err, ErrTmpQuota)
+
+	// free space is not checked if MinFree is off
+	SetTmpPolicy(TmpPolicy{Dir: tmp, Quota: 300})
+	dir, release4, err := reserveTmp(tmp, 200)
+	require.NoError(t, err)
+	require.Equal(t, tmp, dir)
+	release4()
+	SetTmpPolicy(TmpPolicy{Dir: tmp, Quota: 300, MinFree: 100, Spill: []string{spill}})
+
+	// collector fails early
+	c := NewCollector(t.Name(), tmp, NewSortableBuffer(datasize.B), log.New())
+	defer c.Close()
+	require.ErrorIs(t, c.Collect([]byte("key"), make([]byte, 300)), ErrTmpQuota)
+}
@@ -18,6 +18,7 @@ package kvcfg
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ledgerwatch/erigon-lib/kv"
 )
@@ -26,6 +27,8 @@ type ConfigKey []byte
 
 var (
 	HistoryV3 = ConfigKey("history.v3")
+	// AccountCodec - name of encoding of accounts in state (chain.Config.AccountCodec). Empty - datadir is not initialised yet
+	AccountCodec = ConfigKey("account.codec")
 )
 
 func (k ConfigKey) Enabled(tx kv.Tx) (bool, error) { return kv.GetBool(tx, kv.DatabaseInfo, k) }
@@ -61,3 +64,26 @@ func (k ConfigKey) ForceWrite(tx kv.RwTx, enabled bool) error {
 	}
 	return nil
 }
+
+func (k ConfigKey) GetString(tx kv.Getter) (string, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, k)
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// EnsureNotChangedString - writes `value` if key is not set yet, returns kv.ErrChanged if db has other value
+func (k ConfigKey) EnsureNotChangedString(tx kv.RwTx, value string) error {
+	v, err := tx.GetOne(kv.DatabaseInfo, k)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return tx.Put(kv.DatabaseInfo, k, []byte(value))
+	}
+	if string(v) != value {
+		return fmt.Errorf("%w: %s is %q in db, but %q is configured", kv.ErrChanged, k, v, value)
+	}
+	return nil
+}
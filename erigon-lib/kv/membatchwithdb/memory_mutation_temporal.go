@@ -0,0 +1,52 @@
+/*
+   Copyright 2023 Erigon contributors
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package membatchwithdb
+
+import (
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// TemporalMemoryBatch - MemoryMutation on top of kv.TemporalTx.
+// Temporal methods read underlying tx: history is immutable and batch doesn't change it,
+// so unwind-like calculations (for example: hashed state at old block) can be done in-memory.
+type TemporalMemoryBatch struct {
+	*MemoryMutation
+	temporal kv.TemporalTx
+}
+
+func NewTemporalMemoryBatch(tx kv.TemporalTx, tmpDir string, logger log.Logger) *TemporalMemoryBatch {
+	return &TemporalMemoryBatch{MemoryMutation: NewMemoryBatch(tx, tmpDir, logger), temporal: tx}
+}
+
+func (m *TemporalMemoryBatch) DomainGet(name kv.Domain, k, k2 []byte) (v []byte, ok bool, err error) {
+	return m.temporal.DomainGet(name, k, k2)
+}
+func (m *TemporalMemoryBatch) DomainGetAsOf(name kv.Domain, k, k2 []byte, ts uint64) (v []byte, ok bool, err error) {
+	return m.temporal.DomainGetAsOf(name, k, k2, ts)
+}
+func (m *TemporalMemoryBatch) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte, ok bool, err error) {
+	return m.temporal.HistoryGet(name, k, ts)
+}
+func (m *TemporalMemoryBatch) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (timestamps iter.U64, err error) {
+	return m.temporal.IndexRange(name, k, fromTs, toTs, asc, limit)
+}
+func (m *TemporalMemoryBatch) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limit int) (it iter.KV, err error) {
+	return m.temporal.HistoryRange(name, fromTs, toTs, asc, limit)
+}
+func (m *TemporalMemoryBatch) DomainRange(name kv.Domain, fromKey, toKey []byte, ts uint64, asc order.By, limit int) (it iter.KV, err error) {
+	return m.temporal.DomainRange(name, fromKey, toKey, ts, asc, limit)
+}
@@ -40,6 +40,7 @@ import (
 // generate the messages and services
 type remoteOpts struct {
 	remoteKV    remote.KVClient
+	historyKV   remote.KVClient // see WithHistoryServer
 	log         log.Logger
 	bucketsCfg  kv.TableCfg
 	DialAddress string
@@ -90,6 +91,14 @@ func (opts remoteOpts) WithBucketsConfig(c kv.TableCfg) remoteOpts {
 	return opts
 }
 
+// WithHistoryServer - point reads of history (HistoryGet, DomainGetAsOf) are served by history server (cmd/historyserver)
+// first. It has only history of files: not found there means "not changed between ts and end of files" (or ts is after
+// files, or server failed) - then node serves it, node has full history.
+func (opts remoteOpts) WithHistoryServer(historyKV remote.KVClient) remoteOpts {
+	opts.historyKV = historyKV
+	return opts
+}
+
 func (opts remoteOpts) Open() (*DB, error) {
 	targetSemCount := int64(runtime.GOMAXPROCS(-1)) - 1
 	if targetSemCount <= 1 {
@@ -649,6 +658,13 @@ func (c *remoteCursorDupSort) LastDup() ([]byte, error)           { return c.las
 
 // Temporal Methods
 func (tx *tx) DomainGetAsOf(name kv.Domain, k, k2 []byte, ts uint64) (v []byte, ok bool, err error) {
+	if tx.db.opts.historyKV != nil {
+		// without TxId: history server has no tx of node
+		reply, err := tx.db.opts.historyKV.DomainGet(tx.ctx, &remote.DomainGetReq{Table: string(name), K: k, K2: k2, Ts: ts})
+		if err == nil && reply.Ok {
+			return reply.V, true, nil
+		}
+	}
 	reply, err := tx.db.remoteKV.DomainGet(tx.ctx, &remote.DomainGetReq{TxId: tx.id, Table: string(name), K: k, K2: k2, Ts: ts})
 	if err != nil {
 		return nil, false, err
@@ -666,7 +682,7 @@ func (tx *tx) DomainGet(name kv.Domain, k, k2 []byte) (v []byte, ok bool, err er
 
 func (tx *tx) DomainRange(name kv.Domain, fromKey, toKey []byte, ts uint64, asc order.By, limit int) (it iter.KV, err error) {
 	return iter.PaginateKV(func(pageToken string) (keys, vals [][]byte, nextPageToken string, err error) {
-		reply, err := tx.db.remoteKV.DomainRange(tx.ctx, &remote.DomainRangeReq{TxId: tx.id, Table: string(name), FromKey: fromKey, ToKey: toKey, Ts: ts, OrderAscend: bool(asc), Limit: int64(limit)})
+		reply, err := tx.db.remoteKV.DomainRange(tx.ctx, &remote.DomainRangeReq{TxId: tx.id, Table: string(name), FromKey: fromKey, ToKey: toKey, Ts: ts, OrderAscend: bool(asc), Limit: int64(limit), PageToken: pageToken})
 		if err != nil {
 			return nil, nil, "", err
 		}
@@ -674,6 +690,12 @@ func (tx *tx) DomainRange(name kv.Domain, fromKey, toKey []byte, ts uint64, asc
 	}), nil
 }
 func (tx *tx) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte, ok bool, err error) {
+	if tx.db.opts.historyKV != nil {
+		reply, err := tx.db.opts.historyKV.HistoryGet(tx.ctx, &remote.HistoryGetReq{Table: string(name), K: k, Ts: ts})
+		if err == nil && reply.Ok {
+			return reply.V, true, nil
+		}
+	}
 	reply, err := tx.db.remoteKV.HistoryGet(tx.ctx, &remote.HistoryGetReq{TxId: tx.id, Table: string(name), K: k, Ts: ts})
 	if err != nil {
 		return nil, false, err
@@ -682,7 +704,7 @@ func (tx *tx) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte, ok boo
 }
 func (tx *tx) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limit int) (it iter.KV, err error) {
 	return iter.PaginateKV(func(pageToken string) (keys, vals [][]byte, nextPageToken string, err error) {
-		reply, err := tx.db.remoteKV.HistoryRange(tx.ctx, &remote.HistoryRangeReq{TxId: tx.id, Table: string(name), FromTs: int64(fromTs), ToTs: int64(toTs), OrderAscend: bool(asc), Limit: int64(limit)})
+		reply, err := tx.db.remoteKV.HistoryRange(tx.ctx, &remote.HistoryRangeReq{TxId: tx.id, Table: string(name), FromTs: int64(fromTs), ToTs: int64(toTs), OrderAscend: bool(asc), Limit: int64(limit), PageToken: pageToken})
 		if err != nil {
 			return nil, nil, "", err
 		}
@@ -692,7 +714,7 @@ func (tx *tx) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limi
 
 func (tx *tx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (timestamps iter.U64, err error) {
 	return iter.PaginateU64(func(pageToken string) (arr []uint64, nextPageToken string, err error) {
-		req := &remote.IndexRangeReq{TxId: tx.id, Table: string(name), K: k, FromTs: int64(fromTs), ToTs: int64(toTs), OrderAscend: bool(asc), Limit: int64(limit)}
+		req := &remote.IndexRangeReq{TxId: tx.id, Table: string(name), K: k, FromTs: int64(fromTs), ToTs: int64(toTs), OrderAscend: bool(asc), Limit: int64(limit), PageToken: pageToken}
 		reply, err := tx.db.remoteKV.IndexRange(tx.ctx, req)
 		if err != nil {
 			return nil, "", err
@@ -711,7 +733,7 @@ func (tx *tx) Prefix(table string, prefix []byte) (iter.KV, error) {
 
 func (tx *tx) rangeOrderLimit(table string, fromPrefix, toPrefix []byte, asc order.By, limit int) (iter.KV, error) {
 	return iter.PaginateKV(func(pageToken string) (keys [][]byte, values [][]byte, nextPageToken string, err error) {
-		req := &remote.RangeReq{TxId: tx.id, Table: table, FromPrefix: fromPrefix, ToPrefix: toPrefix, OrderAscend: bool(asc), Limit: int64(limit)}
+		req := &remote.RangeReq{TxId: tx.id, Table: table, FromPrefix: fromPrefix, ToPrefix: toPrefix, OrderAscend: bool(asc), Limit: int64(limit), PageToken: pageToken}
 		reply, err := tx.db.remoteKV.Range(tx.ctx, req)
 		if err != nil {
 			return nil, nil, "", err
@@ -0,0 +1,59 @@
+package remotedb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// historyKV - KVClient which serves only HistoryGet from map. Nil map - server fails
+type historyKV struct {
+	remote.KVClient
+	history map[string]string
+	calls   int
+}
+
+func (h *historyKV) HistoryGet(ctx context.Context, in *remote.HistoryGetReq, opts ...grpc.CallOption) (*remote.HistoryGetReply, error) {
+	h.calls++
+	if h.history == nil {
+		return nil, errors.New("history is not available")
+	}
+	v, ok := h.history[string(in.K)]
+	return &remote.HistoryGetReply{V: []byte(v), Ok: ok}, nil
+}
+
+func TestHistoryGet_WithHistoryServer(t *testing.T) {
+	node := &historyKV{history: map[string]string{"a": "node_a", "b": "node_b"}}
+	files := &historyKV{history: map[string]string{"a": "files_a"}}
+	db, err := NewRemote(gointerfaces.Version{}, log.New(), node).WithHistoryServer(files).Open()
+	require.NoError(t, err)
+	tx := &tx{db: db, ctx: context.Background()}
+
+	v, ok, err := tx.HistoryGet(kv.AccountsHistory, []byte("a"), 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "files_a", string(v))
+	require.Zero(t, node.calls)
+
+	// not changed until end of files: node serves it
+	v, ok, err = tx.HistoryGet(kv.AccountsHistory, []byte("b"), 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "node_b", string(v))
+
+	// history server failed or ts is after end of files: node serves it
+	files.history = nil
+	v, ok, err = tx.HistoryGet(kv.AccountsHistory, []byte("a"), 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "node_a", string(v))
+	require.Equal(t, 2, node.calls)
+}
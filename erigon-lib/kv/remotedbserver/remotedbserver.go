@@ -17,12 +17,16 @@
 package remotedbserver
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -64,7 +68,8 @@ const MaxTxTTL = 60 * time.Second
 // 6.0.0 - Blocks now have system-txs - in the begin/end of block
 // 6.1.0 - Add methods Range, IndexRange, HistoryGet, HistoryRange
 // 6.2.0 - Add HistoryFiles to reply of Snapshots() method
-var KvServiceAPIVersion = &types.VersionReply{Major: 6, Minor: 2, Patch: 0}
+// 6.3.0 - Server-side DomainRange, HistoryRange and paginated IndexRange. Inside Tx page token continues server-side cursor
+var KvServiceAPIVersion = &types.VersionReply{Major: 6, Minor: 3, Patch: 0}
 
 type KvServer struct {
 	remote.UnimplementedKVServer // must be embedded to have forward compatible implementations.
@@ -78,6 +83,7 @@ type KvServer struct {
 
 	//v3 fields
 	txIdGen    atomic.Uint64
+	pageIdGen  atomic.Uint64
 	txsMapLock *sync.RWMutex
 	txs        map[uint64]*threadSafeTx
 
@@ -89,6 +95,23 @@ type KvServer struct {
 type threadSafeTx struct {
 	kv.Tx
 	sync.Mutex
+	pages map[uint64]*pageCursor // unfinished paginated requests, see withPage. Survive renew, closed with rollback
+}
+
+// suspendPages - closes iterators before tx renew: next page re-opens them on new tx after last sent item
+func (tx *threadSafeTx) suspendPages() {
+	for _, c := range tx.pages {
+		c.close()
+		c.pos.lastK, c.pos.lastV = common.Copy(c.pos.lastK), common.Copy(c.pos.lastV) // they point to memory of old tx
+		c.pos.reopened = true
+	}
+}
+
+func (tx *threadSafeTx) closePages() {
+	for id, c := range tx.pages {
+		c.close()
+		delete(tx.pages, id)
+	}
 }
 
 //go:generate mockgen -destination=./snapshots_mock.go -package=remotedbserver . Snapshots
@@ -156,6 +179,7 @@ func (s *KvServer) renew(ctx context.Context, id uint64) (err error) {
 	if ok {
 		tx.Lock()
 		defer tx.Unlock()
+		tx.suspendPages()
 		tx.Rollback()
 	}
 	newTx, errBegin := s.kv.BeginRo(ctx)
@@ -163,6 +187,9 @@ func (s *KvServer) renew(ctx context.Context, id uint64) (err error) {
 		return fmt.Errorf("kvserver: %w", err)
 	}
 	s.txs[id] = &threadSafeTx{Tx: newTx}
+	if ok {
+		s.txs[id].pages = tx.pages
+	}
 	return nil
 }
 
@@ -176,6 +203,7 @@ func (s *KvServer) rollback(id uint64) {
 	if ok {
 		tx.Lock()
 		defer tx.Unlock()
+		tx.closePages()
 		tx.Rollback() //nolint
 		delete(s.txs, id)
 	}
@@ -190,6 +218,10 @@ func (s *KvServer) rollback(id uint64) {
 //	client, portion of data it to client, then read next portion in another `with` call.
 //	It will allow cooperative access to `tx` object
 func (s *KvServer) with(id uint64, f func(kv.Tx) error) error {
+	return s.withThreadSafeTx(id, func(tx *threadSafeTx) error { return f(tx.Tx) })
+}
+
+func (s *KvServer) withThreadSafeTx(id uint64, f func(*threadSafeTx) error) error {
 	s.txsMapLock.RLock()
 	tx, ok := s.txs[id]
 	s.txsMapLock.RUnlock()
@@ -210,7 +242,146 @@ func (s *KvServer) with(id uint64, f func(kv.Tx) error) error {
 			s.logger.Info(fmt.Sprintf("[kv_server] with %d unlock %s\n", id, dbg.Stack()[:2]))
 		}
 	}()
-	return f(tx.Tx)
+	return f(tx)
+}
+
+// pageCursorPrefix - page token of request inside Tx: iterator of request stays open on server between pages
+const pageCursorPrefix = "cursor:"
+
+// pageCursor - unfinished paginated request inside Tx
+type pageCursor struct {
+	it  any // nil after renew of tx: next page re-opens it
+	pos *pagePos
+}
+
+func (c *pageCursor) close() {
+	if casted, ok := c.it.(iter.Closer); ok {
+		casted.Close()
+	}
+	c.it = nil
+}
+
+// pagePos - position of paginated request: how many items are sent and which was last. Allows to continue
+// request after renew of tx (MaxTxTTL): iterator is re-opened at last sent item, or from beginning if
+// it can't seek, and items up to last sent one are skipped.
+type pagePos struct {
+	asc     bool
+	dupSort bool // pairs with same key are ordered by value
+	limit   int  // -1 - unlimited
+	sent    int
+
+	lastK, lastV []byte
+	lastTs       uint64 // IndexRange
+	reopened     bool
+}
+
+// full - request's limit is reached
+func (p *pagePos) full() bool { return p.limit >= 0 && p.sent >= p.limit }
+
+// skip - true for items of re-opened iterator up to last sent item (inclusive)
+func (p *pagePos) skip(k, v []byte) bool {
+	if !p.reopened {
+		return false
+	}
+	cmp := bytes.Compare(k, p.lastK)
+	if cmp == 0 && p.dupSort {
+		cmp = bytes.Compare(v, p.lastV)
+	}
+	if !p.asc {
+		cmp = -cmp
+	}
+	if cmp <= 0 {
+		return true
+	}
+	p.reopened = false
+	return false
+}
+
+func (p *pagePos) sentItem(k, v []byte) {
+	p.lastK, p.lastV = k, v
+	p.sent++
+}
+
+// skipTs - like `skip`, for timestamps of IndexRange
+func (p *pagePos) skipTs(ts uint64) bool {
+	if !p.reopened {
+		return false
+	}
+	if (p.asc && ts <= p.lastTs) || (!p.asc && ts >= p.lastTs) {
+		return true
+	}
+	p.reopened = false
+	return false
+}
+
+func (p *pagePos) sentTs(ts uint64) {
+	p.lastTs = ts
+	p.sent++
+}
+
+// remainingLimit - limit of iterator opened at current position
+func (p *pagePos) remainingLimit() int {
+	if p.limit < 0 || p.reopened { // re-opened iterator also returns skipped items
+		return -1
+	}
+	return p.limit - p.sent
+}
+
+// withPage - like `with`, for one page of paginated request. First page opens iterator by `open` at `pos`, next pages
+// continue it: page token refers to iterator kept in `tx` (cursor continuation), so every page costs O(pageSize) -
+// without re-opening iterator and seeking/skipping already sent items. `fill` reads one page, more=false - request
+// is finished. Iterators are closed when request is finished or with tx rollback. On tx renew iterators are closed,
+// but requests stay: next page re-opens iterator by `open` on new tx, and `fill` continues after `pos`.
+func withPage[T any](s *KvServer, id uint64, pageToken string, pos *pagePos, open func(tx kv.Tx, pos *pagePos) (T, error), fill func(it T, pos *pagePos) (more bool, err error)) (nextPageToken string, err error) {
+	err = s.withThreadSafeTx(id, func(tx *threadSafeTx) error {
+		var pageID uint64
+		c := &pageCursor{pos: pos}
+		if pageToken != "" {
+			if pageID, err = strconv.ParseUint(strings.TrimPrefix(pageToken, pageCursorPrefix), 10, 64); err != nil || !strings.HasPrefix(pageToken, pageCursorPrefix) {
+				return fmt.Errorf("unexpected page token inside txn %d: %q", id, pageToken)
+			}
+			var ok bool
+			if c, ok = tx.pages[pageID]; !ok {
+				return fmt.Errorf("page cursor %d not found: request is finished", pageID)
+			}
+		}
+		if c.it == nil {
+			it, err := open(tx.Tx, c.pos)
+			if err != nil {
+				return err
+			}
+			c.it = it
+		}
+		it, ok := c.it.(T)
+		if !ok {
+			return fmt.Errorf("page cursor %d belongs to another request", pageID)
+		}
+		more, err := fill(it, c.pos)
+		if err != nil || !more {
+			delete(tx.pages, pageID)
+			c.close()
+			return err
+		}
+		if pageID == 0 {
+			pageID = s.pageIdGen.Add(1)
+			if tx.pages == nil {
+				tx.pages = map[uint64]*pageCursor{}
+			}
+			tx.pages[pageID] = c
+		}
+		nextPageToken = pageCursorPrefix + strconv.FormatUint(pageID, 10)
+		return nil
+	})
+	return nextPageToken, err
+}
+
+// withTxOrNew - like `with`, but id=0 runs `f` in new read transaction, which lives only during this call.
+// It allows unary requests without opening `Tx` stream (which also gives cursors over raw tables).
+func (s *KvServer) withTxOrNew(ctx context.Context, id uint64, f func(kv.Tx) error) error {
+	if id == 0 {
+		return s.kv.View(ctx, f)
+	}
+	return s.with(id, f)
 }
 
 func (s *KvServer) Tx(stream remote.KV_TxServer) error {
@@ -533,9 +704,9 @@ func (s *StateChangePubSub) remove(id uint) {
 // Temporal methods
 //
 
-func (s *KvServer) DomainGet(_ context.Context, req *remote.DomainGetReq) (reply *remote.DomainGetReply, err error) {
+func (s *KvServer) DomainGet(ctx context.Context, req *remote.DomainGetReq) (reply *remote.DomainGetReply, err error) {
 	reply = &remote.DomainGetReply{}
-	if err := s.with(req.TxId, func(tx kv.Tx) error {
+	if err := s.withTxOrNew(ctx, req.TxId, func(tx kv.Tx) error {
 		ttx, ok := tx.(kv.TemporalTx)
 		if !ok {
 			return fmt.Errorf("server DB doesn't implement kv.Temporal interface")
@@ -557,9 +728,9 @@ func (s *KvServer) DomainGet(_ context.Context, req *remote.DomainGetReq) (reply
 	}
 	return reply, nil
 }
-func (s *KvServer) HistoryGet(_ context.Context, req *remote.HistoryGetReq) (reply *remote.HistoryGetReply, err error) {
+func (s *KvServer) HistoryGet(ctx context.Context, req *remote.HistoryGetReq) (reply *remote.HistoryGetReply, err error) {
 	reply = &remote.HistoryGetReply{}
-	if err := s.with(req.TxId, func(tx kv.Tx) error {
+	if err := s.withTxOrNew(ctx, req.TxId, func(tx kv.Tx) error {
 		ttx, ok := tx.(kv.TemporalTx)
 		if !ok {
 			return fmt.Errorf("server DB doesn't implement kv.Temporal interface")
@@ -577,46 +748,112 @@ func (s *KvServer) HistoryGet(_ context.Context, req *remote.HistoryGetReq) (rep
 
 const PageSizeLimit = 4 * 4096
 
-func (s *KvServer) IndexRange(_ context.Context, req *remote.IndexRangeReq) (*remote.IndexRangeReply, error) {
+func (s *KvServer) IndexRange(ctx context.Context, req *remote.IndexRangeReq) (*remote.IndexRangeReply, error) {
+	if req.PageSize <= 0 || req.PageSize > PageSizeLimit {
+		req.PageSize = PageSizeLimit
+	}
 	reply := &remote.IndexRangeReply{}
-	from, limit := int(req.FromTs), int(req.Limit)
+	from, pos := int(req.FromTs), &pagePos{asc: req.OrderAscend, limit: limitOrUnlimited(req.Limit)}
+	open := func(tx kv.Tx, pos *pagePos) (iter.U64, error) {
+		ttx, ok := tx.(kv.TemporalTx)
+		if !ok {
+			return nil, fmt.Errorf("server DB doesn't implement kv.Temporal interface")
+		}
+		from := from
+		if pos.reopened {
+			from = int(pos.lastTs)
+		}
+		return ttx.IndexRange(kv.InvertedIdx(req.Table), req.K, from, int(req.ToTs), order.By(req.OrderAscend), pos.remainingLimit())
+	}
+	fill := func(it iter.U64, pos *pagePos) (more bool, err error) {
+		for it.HasNext() && len(reply.Timestamps) < int(req.PageSize) && !pos.full() {
+			v, err := it.Next()
+			if err != nil {
+				return false, err
+			}
+			if pos.skipTs(v) {
+				continue
+			}
+			reply.Timestamps = append(reply.Timestamps, v)
+			pos.sentTs(v)
+		}
+		return it.HasNext() && !pos.full(), nil
+	}
+	if req.TxId != 0 {
+		var err error
+		if reply.NextPageToken, err = withPage(s, req.TxId, req.PageToken, pos, open, fill); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+
+	// without Tx request can't stay open between pages: next page seeks to NextTimeStamp
 	if req.PageToken != "" {
 		var pagination remote.IndexPagination
 		if err := unmarshalPagination(req.PageToken, &pagination); err != nil {
 			return nil, err
 		}
-		from, limit = int(pagination.NextTimeStamp), int(pagination.Limit)
+		from, pos.limit = int(pagination.NextTimeStamp), int(pagination.Limit)
+	}
+	if err := s.kv.View(ctx, func(tx kv.Tx) error {
+		it, err := open(tx, pos)
+		if err != nil {
+			return err
+		}
+		more, err := fill(it, pos)
+		if err != nil || !more {
+			return err
+		}
+		next, err := it.Next()
+		if err != nil {
+			return err
+		}
+		reply.NextPageToken, err = marshalPagination(&remote.IndexPagination{NextTimeStamp: int64(next), Limit: int64(pos.remainingLimit())})
+		return err
+	}); err != nil {
+		return nil, err
 	}
+	return reply, nil
+}
+
+func (s *KvServer) HistoryRange(ctx context.Context, req *remote.HistoryRangeReq) (*remote.Pairs, error) {
 	if req.PageSize <= 0 || req.PageSize > PageSizeLimit {
 		req.PageSize = PageSizeLimit
 	}
-
-	if err := s.with(req.TxId, func(tx kv.Tx) error {
+	reply := &remote.Pairs{}
+	pos := &pagePos{asc: req.OrderAscend, limit: limitOrUnlimited(req.Limit)}
+	open := func(tx kv.Tx, pos *pagePos) (iter.KV, error) {
 		ttx, ok := tx.(kv.TemporalTx)
 		if !ok {
-			return fmt.Errorf("server DB doesn't implement kv.Temporal interface")
+			return nil, fmt.Errorf("server DB doesn't implement kv.Temporal interface")
+		}
+		// HistoryRange has no `fromKey` parameter: re-opened iterator skips already sent keys
+		return ttx.HistoryRange(kv.History(req.Table), int(req.FromTs), int(req.ToTs), order.By(req.OrderAscend), pos.remainingLimit())
+	}
+	fill := func(it iter.KV, pos *pagePos) (bool, error) { return fillPairs(reply, it, int(req.PageSize), pos) }
+	if req.TxId != 0 {
+		var err error
+		if reply.NextPageToken, err = withPage(s, req.TxId, req.PageToken, pos, open, fill); err != nil {
+			return nil, err
 		}
-		it, err := ttx.IndexRange(kv.InvertedIdx(req.Table), req.K, from, int(req.ToTs), order.By(req.OrderAscend), limit)
+		return reply, nil
+	}
+
+	// without Tx next page can't continue HistoryRange
+	if req.PageToken != "" {
+		return nil, fmt.Errorf("HistoryRange: page token without txn")
+	}
+	if err := s.kv.View(ctx, func(tx kv.Tx) error {
+		it, err := open(tx, pos)
 		if err != nil {
 			return err
 		}
-		for it.HasNext() {
-			v, err := it.Next()
-			if err != nil {
-				return err
-			}
-			reply.Timestamps = append(reply.Timestamps, v)
-			limit--
+		more, err := fill(it, pos)
+		if err != nil {
+			return err
 		}
-		if len(reply.Timestamps) == PageSizeLimit && it.HasNext() {
-			next, err := it.Next()
-			if err != nil {
-				return err
-			}
-			reply.NextPageToken, err = marshalPagination(&remote.IndexPagination{NextTimeStamp: int64(next), Limit: int64(limit)})
-			if err != nil {
-				return err
-			}
+		if more {
+			return fmt.Errorf("HistoryRange: result is bigger than page size %d, paginate it inside txn", req.PageSize)
 		}
 		return nil
 	}); err != nil {
@@ -625,60 +862,115 @@ func (s *KvServer) IndexRange(_ context.Context, req *remote.IndexRangeReq) (*re
 	return reply, nil
 }
 
-func (s *KvServer) Range(_ context.Context, req *remote.RangeReq) (*remote.Pairs, error) {
-	from, limit := req.FromPrefix, int(req.Limit)
+func (s *KvServer) DomainRange(ctx context.Context, req *remote.DomainRangeReq) (*remote.Pairs, error) {
+	if req.PageSize <= 0 || req.PageSize > PageSizeLimit {
+		req.PageSize = PageSizeLimit
+	}
+	reply := &remote.Pairs{}
+	from, pos := req.FromKey, &pagePos{asc: req.OrderAscend, limit: limitOrUnlimited(req.Limit)}
+	open := func(tx kv.Tx, pos *pagePos) (iter.KV, error) {
+		ttx, ok := tx.(kv.TemporalTx)
+		if !ok {
+			return nil, fmt.Errorf("server DB doesn't implement kv.Temporal interface")
+		}
+		ts := req.Ts
+		if req.Latest {
+			ts = math.MaxUint64
+		}
+		from := from
+		if pos.reopened {
+			from = pos.lastK
+		}
+		return ttx.DomainRange(kv.Domain(req.Table), from, req.ToKey, ts, order.By(req.OrderAscend), pos.remainingLimit())
+	}
+	fill := func(it iter.KV, pos *pagePos) (bool, error) { return fillPairs(reply, it, int(req.PageSize), pos) }
+	if req.TxId != 0 {
+		var err error
+		if reply.NextPageToken, err = withPage(s, req.TxId, req.PageToken, pos, open, fill); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+
+	// without Tx request can't stay open between pages: next page seeks to NextKey
 	if req.PageToken != "" {
 		var pagination remote.ParisPagination
 		if err := unmarshalPagination(req.PageToken, &pagination); err != nil {
 			return nil, err
 		}
-		from, limit = pagination.NextKey, int(pagination.Limit)
+		from, pos.limit = pagination.NextKey, int(pagination.Limit)
+	}
+	if err := s.kv.View(ctx, func(tx kv.Tx) error {
+		it, err := open(tx, pos)
+		if err != nil {
+			return err
+		}
+		more, err := fill(it, pos)
+		if err != nil || !more {
+			return err
+		}
+		nextK, _, err := it.Next()
+		if err != nil {
+			return err
+		}
+		reply.NextPageToken, err = marshalPagination(&remote.ParisPagination{NextKey: nextK, Limit: int64(pos.remainingLimit())})
+		return err
+	}); err != nil {
+		return nil, err
 	}
+	return reply, nil
+}
+
+func (s *KvServer) Range(_ context.Context, req *remote.RangeReq) (*remote.Pairs, error) {
 	if req.PageSize <= 0 || req.PageSize > PageSizeLimit {
 		req.PageSize = PageSizeLimit
 	}
-
 	reply := &remote.Pairs{}
+	pos := &pagePos{asc: req.OrderAscend, dupSort: kv.ChaindataTablesCfg[req.Table].Flags&kv.DupSort != 0, limit: limitOrUnlimited(req.Limit)}
 	var err error
-	if err = s.with(req.TxId, func(tx kv.Tx) error {
-		var it iter.KV
-		if req.OrderAscend {
-			it, err = tx.RangeAscend(req.Table, from, req.ToPrefix, limit)
-			if err != nil {
-				return err
-			}
-		} else {
-			it, err = tx.RangeDescend(req.Table, from, req.ToPrefix, limit)
-			if err != nil {
-				return err
-			}
-		}
-		for it.HasNext() {
-			k, v, err := it.Next()
-			if err != nil {
-				return err
-			}
-			reply.Keys = append(reply.Keys, k)
-			reply.Values = append(reply.Values, v)
-			limit--
+	reply.NextPageToken, err = withPage(s, req.TxId, req.PageToken, pos, func(tx kv.Tx, pos *pagePos) (iter.KV, error) {
+		from := req.FromPrefix
+		if pos.reopened {
+			from = pos.lastK
 		}
-		if len(reply.Keys) == PageSizeLimit && it.HasNext() {
-			nextK, _, err := it.Next()
-			if err != nil {
-				return err
-			}
-			reply.NextPageToken, err = marshalPagination(&remote.ParisPagination{NextKey: nextK, Limit: int64(limit)})
-			if err != nil {
-				return err
-			}
+		if req.OrderAscend {
+			return tx.RangeAscend(req.Table, from, req.ToPrefix, pos.remainingLimit())
 		}
-		return nil
-	}); err != nil {
+		return tx.RangeDescend(req.Table, from, req.ToPrefix, pos.remainingLimit())
+	}, func(it iter.KV, pos *pagePos) (bool, error) {
+		return fillPairs(reply, it, int(req.PageSize), pos)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return reply, nil
 }
 
+// fillPairs - reads up to `pageSize` pairs from `it` into `reply`, more=true - request has more pairs
+func fillPairs(reply *remote.Pairs, it iter.KV, pageSize int, pos *pagePos) (more bool, err error) {
+	for it.HasNext() && len(reply.Keys) < pageSize && !pos.full() {
+		k, v, err := it.Next()
+		if err != nil {
+			return false, err
+		}
+		if pos.skip(k, v) {
+			continue
+		}
+		reply.Keys = append(reply.Keys, k)
+		reply.Values = append(reply.Values, v)
+		pos.sentItem(k, v)
+	}
+	return it.HasNext() && !pos.full(), nil
+}
+
+// limitOrUnlimited - request's limit <= 0 means unlimited, -1 for iterators
+func limitOrUnlimited(limit int64) int {
+	if limit <= 0 {
+		return -1
+	}
+	return int(limit)
+}
+
 // see: https://cloud.google.com/apis/design/design_patterns
 func marshalPagination(m proto.Message) (string, error) {
 	pageToken, err := proto.Marshal(m)
@@ -19,6 +19,7 @@ package remotedbserver
 import (
 	"context"
 	"runtime"
+	"sync/atomic"
 	"testing"
 
 	"github.com/ledgerwatch/log/v3"
@@ -26,8 +27,11 @@ import (
 	"go.uber.org/mock/gomock"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
 )
 
 func TestKvServer_renew(t *testing.T) {
@@ -139,3 +143,244 @@ func TestKVServerSnapshotsReturnsEmptyIfNoBlockSnapshots(t *testing.T) {
 	require.Empty(t, reply.BlocksFiles)
 	require.Empty(t, reply.HistoryFiles)
 }
+
+func TestKvServer_RangePagination(t *testing.T) {
+	require, ctx, db := require.New(t), context.Background(), memdb.NewTestDB(t)
+	require.NoError(db.Update(ctx, func(tx kv.RwTx) error {
+		for i := byte(1); i <= 5; i++ {
+			if err := tx.Put(kv.HeaderNumber, []byte{i}, []byte{i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	s := NewKvServer(ctx, db, nil, nil, nil, log.New())
+	id, err := s.begin(ctx)
+	require.NoError(err)
+	defer s.rollback(id)
+
+	var keys [][]byte
+	var pages int
+	req := &remote.RangeReq{TxId: id, Table: kv.HeaderNumber, OrderAscend: true, Limit: 4, PageSize: 2}
+	for {
+		reply, err := s.Range(ctx, req)
+		require.NoError(err)
+		require.LessOrEqual(len(reply.Keys), 2)
+		keys = append(keys, reply.Keys...)
+		pages++
+		if reply.NextPageToken == "" {
+			break
+		}
+		req.PageToken = reply.NextPageToken
+	}
+	require.Equal([][]byte{{1}, {2}, {3}, {4}}, keys)
+	require.Equal(2, pages)
+
+	// dupsort table: after renew pagination continues inside duplicates of last sent key
+	require.NoError(db.Update(ctx, func(tx kv.RwTx) error {
+		for _, v := range []byte{1, 2, 3} {
+			if err := tx.Put(kv.AccountChangeSet, []byte{1}, []byte{v}); err != nil {
+				return err
+			}
+		}
+		return tx.Put(kv.AccountChangeSet, []byte{2}, []byte{1})
+	}))
+	require.NoError(s.renew(ctx, id))
+	for _, asc := range []bool{true, false} {
+		var values [][]byte
+		req = &remote.RangeReq{TxId: id, Table: kv.AccountChangeSet, OrderAscend: asc, PageSize: 1}
+		if !asc {
+			req.FromPrefix = []byte{2}
+		}
+		for {
+			reply, err := s.Range(ctx, req)
+			require.NoError(err)
+			values = append(values, reply.Values...)
+			if reply.NextPageToken == "" {
+				break
+			}
+			require.NoError(s.renew(ctx, id))
+			req.PageToken = reply.NextPageToken
+		}
+		if asc {
+			require.Equal([][]byte{{1}, {2}, {3}, {1}}, values)
+		} else {
+			require.Equal([][]byte{{1}, {3}, {2}, {1}}, values)
+		}
+	}
+}
+
+func TestKvServer_UnaryWithoutTx(t *testing.T) {
+	require, ctx, db := require.New(t), context.Background(), memdb.NewTestDB(t)
+	s := NewKvServer(ctx, db, nil, nil, nil, log.New())
+
+	// TxId=0: request runs in own read transaction, instead of failing on unknown txn
+	_, err := s.DomainGet(ctx, &remote.DomainGetReq{Table: string(kv.AccountsDomain), Latest: true})
+	require.ErrorContains(err, "kv.Temporal")
+	_, err = s.IndexRange(ctx, &remote.IndexRangeReq{Table: string(kv.LogAddrIdx), ToTs: -1})
+	require.ErrorContains(err, "kv.Temporal")
+
+	_, err = s.DomainGet(ctx, &remote.DomainGetReq{TxId: 42, Table: string(kv.AccountsDomain), Latest: true})
+	require.ErrorContains(err, "already rollback")
+}
+
+// temporalTestDB - memdb with temporal methods over table kv.HeaderNumber: keys of HistoryRange and DomainRange
+// are keys of table, timestamps of IndexRange - [fromTs, toTs). Counts opened iterators.
+type temporalTestDB struct {
+	kv.RwDB
+	opened atomic.Int64
+}
+
+func (db *temporalTestDB) BeginRo(ctx context.Context) (kv.Tx, error) {
+	tx, err := db.RwDB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &temporalTestTx{Tx: tx, db: db}, nil
+}
+
+func (db *temporalTestDB) View(ctx context.Context, f func(tx kv.Tx) error) error {
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+type temporalTestTx struct {
+	kv.Tx
+	db *temporalTestDB
+}
+
+func (tx *temporalTestTx) DomainGet(name kv.Domain, k, k2 []byte) (v []byte, ok bool, err error) {
+	return nil, false, nil
+}
+func (tx *temporalTestTx) DomainGetAsOf(name kv.Domain, k, k2 []byte, ts uint64) (v []byte, ok bool, err error) {
+	return nil, false, nil
+}
+func (tx *temporalTestTx) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte, ok bool, err error) {
+	return nil, false, nil
+}
+func (tx *temporalTestTx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (iter.U64, error) {
+	tx.db.opened.Add(1)
+	var res []uint64
+	for ts := fromTs; ts < toTs && (limit < 0 || len(res) < limit); ts++ {
+		res = append(res, uint64(ts))
+	}
+	return iter.Array(res), nil
+}
+func (tx *temporalTestTx) HistoryRange(name kv.History, fromTs, toTs int, asc order.By, limit int) (iter.KV, error) {
+	tx.db.opened.Add(1)
+	return tx.RangeAscend(kv.HeaderNumber, nil, nil, limit)
+}
+func (tx *temporalTestTx) DomainRange(name kv.Domain, fromKey, toKey []byte, ts uint64, asc order.By, limit int) (iter.KV, error) {
+	tx.db.opened.Add(1)
+	return tx.RangeAscend(kv.HeaderNumber, fromKey, toKey, limit)
+}
+
+func TestKvServer_TemporalPagination(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	db := &temporalTestDB{RwDB: memdb.NewTestDB(t)}
+	require.NoError(db.Update(ctx, func(tx kv.RwTx) error {
+		for i := byte(1); i <= 5; i++ {
+			if err := tx.Put(kv.HeaderNumber, []byte{i}, []byte{i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	s := NewKvServer(ctx, db, nil, nil, nil, log.New())
+	id, err := s.begin(ctx)
+	require.NoError(err)
+
+	pairs := func(req *remote.HistoryRangeReq) (keys [][]byte, pages int) {
+		for {
+			reply, err := s.HistoryRange(ctx, req)
+			require.NoError(err)
+			require.LessOrEqual(len(reply.Keys), int(req.PageSize))
+			keys = append(keys, reply.Keys...)
+			pages++
+			if reply.NextPageToken == "" {
+				return keys, pages
+			}
+			req.PageToken = reply.NextPageToken
+		}
+	}
+
+	// inside txn pages continue iterator of first page: it's opened once and closed at the end
+	keys, pages := pairs(&remote.HistoryRangeReq{TxId: id, Table: string(kv.AccountsHistory), FromTs: 0, ToTs: -1, OrderAscend: true, PageSize: 2})
+	require.Equal([][]byte{{1}, {2}, {3}, {4}, {5}}, keys)
+	require.Equal(3, pages)
+	require.Equal(int64(1), db.opened.Load())
+	require.NoError(s.withThreadSafeTx(id, func(tx *threadSafeTx) error {
+		require.Empty(tx.pages)
+		return nil
+	}))
+
+	var timestamps []uint64
+	ireq := &remote.IndexRangeReq{TxId: id, Table: string(kv.LogAddrIdx), FromTs: 10, ToTs: 20, OrderAscend: true, Limit: 5, PageSize: 2}
+	for {
+		reply, err := s.IndexRange(ctx, ireq)
+		require.NoError(err)
+		timestamps = append(timestamps, reply.Timestamps...)
+		if reply.NextPageToken == "" {
+			break
+		}
+		ireq.PageToken = reply.NextPageToken
+	}
+	require.Equal([]uint64{10, 11, 12, 13, 14}, timestamps)
+	require.Equal(int64(2), db.opened.Load())
+
+	// renew of txn (MaxTxTTL) doesn't break unfinished requests: next page re-opens iterator and continues after last sent item
+	hreq := &remote.HistoryRangeReq{TxId: id, Table: string(kv.AccountsHistory), FromTs: 0, ToTs: -1, OrderAscend: true, PageSize: 2}
+	reply, err := s.HistoryRange(ctx, hreq)
+	require.NoError(err)
+	require.Equal([][]byte{{1}, {2}}, reply.Keys)
+	ireq = &remote.IndexRangeReq{TxId: id, Table: string(kv.LogAddrIdx), FromTs: 10, ToTs: 20, OrderAscend: true, Limit: 5, PageSize: 2}
+	ireply, err := s.IndexRange(ctx, ireq)
+	require.NoError(err)
+	require.Equal([]uint64{10, 11}, ireply.Timestamps)
+	require.NoError(s.renew(ctx, id))
+	hreq.PageToken = reply.NextPageToken
+	keys, _ = pairs(hreq)
+	require.Equal([][]byte{{3}, {4}, {5}}, keys)
+	timestamps = nil
+	for ireq.PageToken = ireply.NextPageToken; ireq.PageToken != ""; ireq.PageToken = ireply.NextPageToken {
+		ireply, err = s.IndexRange(ctx, ireq)
+		require.NoError(err)
+		timestamps = append(timestamps, ireply.Timestamps...)
+	}
+	require.Equal([]uint64{12, 13, 14}, timestamps)
+	require.Equal(int64(6), db.opened.Load()) // both re-opened once after renew
+
+	// unfinished request is closed with txn
+	dreq := &remote.DomainRangeReq{TxId: id, Table: string(kv.AccountsDomain), OrderAscend: true, PageSize: 2}
+	reply, err = s.DomainRange(ctx, dreq)
+	require.NoError(err)
+	require.Equal([][]byte{{1}, {2}}, reply.Keys)
+	require.NotEmpty(reply.NextPageToken)
+	s.rollback(id)
+	dreq.PageToken = reply.NextPageToken
+	_, err = s.DomainRange(ctx, dreq)
+	require.ErrorContains(err, "already rollback")
+
+	// without txn DomainRange is continued by key, HistoryRange can't be continued
+	dreq = &remote.DomainRangeReq{Table: string(kv.AccountsDomain), FromKey: []byte{2}, OrderAscend: true, Limit: 3, PageSize: 2}
+	keys = nil
+	for {
+		reply, err := s.DomainRange(ctx, dreq)
+		require.NoError(err)
+		keys = append(keys, reply.Keys...)
+		if reply.NextPageToken == "" {
+			break
+		}
+		dreq.PageToken = reply.NextPageToken
+	}
+	require.Equal([][]byte{{2}, {3}, {4}}, keys)
+	_, err = s.HistoryRange(ctx, &remote.HistoryRangeReq{Table: string(kv.AccountsHistory), ToTs: -1, OrderAscend: true, PageSize: 2})
+	require.ErrorContains(err, "paginate it inside txn")
+	keys, _ = pairs(&remote.HistoryRangeReq{Table: string(kv.AccountsHistory), ToTs: -1, OrderAscend: true})
+	require.Len(keys, 5)
+}
@@ -28,7 +28,8 @@ import (
 // 5.0 - BlockTransaction table now has canonical ids (txs of non-canonical blocks moving to NonCanonicalTransaction table)
 // 6.0 - BlockTransaction table now has system-txs before and after block (records are absent if block has no system-tx, but sequence increasing)
 // 6.1 - Canonical/NonCanonical/BadBlock transitions now stored in same table: kv.EthTx. Add kv.BadBlockNumber table
-var DBSchemaVersion = types.VersionReply{Major: 6, Minor: 1, Patch: 0}
+// 6.2 - Add kv.TblPruningProgress table
+var DBSchemaVersion = types.VersionReply{Major: 6, Minor: 2, Patch: 0}
 
 // ChaindataTables
 
@@ -396,6 +397,7 @@ const (
 	TblCommitmentHistoryKeys = "CommitmentHistoryKeys"
 	TblCommitmentHistoryVals = "CommitmentHistoryVals"
 	TblCommitmentIdx         = "CommitmentIdx"
+	TblCommitmentState       = "CommitmentState" // txNum_u64 -> encoded trie state (to restart commitment computation)
 
 	TblLogAddressKeys = "LogAddressKeys"
 	TblLogAddressIdx  = "LogAddressIdx"
@@ -407,6 +409,13 @@ const (
 	TblTracesToKeys   = "TracesToKeys"
 	TblTracesToIdx    = "TracesToIdx"
 
+	// txHash -> txNum, replaces growing TxLookup table when HistoryV3
+	TblTxLookupKeys = "TxLookupKeys"
+	TblTxLookupIdx  = "TxLookupIdx"
+
+	// name of history/inverted index -> first not-pruned txNum (u64 BE). To resume long prunes after restart
+	TblPruningProgress = "PruningProgress"
+
 	Snapshots = "Snapshots" // name -> hash
 
 	//State Reconstitution
@@ -620,6 +629,7 @@ var ChaindataTables = []string{
 	TblCommitmentHistoryKeys,
 	TblCommitmentHistoryVals,
 	TblCommitmentIdx,
+	TblCommitmentState,
 
 	TblLogAddressKeys,
 	TblLogAddressIdx,
@@ -630,6 +640,9 @@ var ChaindataTables = []string{
 	TblTracesFromIdx,
 	TblTracesToKeys,
 	TblTracesToIdx,
+	TblTxLookupKeys,
+	TblTxLookupIdx,
+	TblPruningProgress,
 
 	Snapshots,
 	MaxTxNum,
@@ -795,6 +808,8 @@ var ChaindataTablesCfg = TableCfg{
 	TblTracesFromIdx:         {Flags: DupSort},
 	TblTracesToKeys:          {Flags: DupSort},
 	TblTracesToIdx:           {Flags: DupSort},
+	TblTxLookupKeys:          {Flags: DupSort},
+	TblTxLookupIdx:           {Flags: DupSort},
 	RAccountKeys:             {Flags: DupSort},
 	RAccountIdx:              {Flags: DupSort},
 	RStorageKeys:             {Flags: DupSort},
@@ -919,4 +934,5 @@ const (
 	LogAddrIdx    InvertedIdx = "LogAddrIdx"
 	TracesFromIdx InvertedIdx = "TracesFromIdx"
 	TracesToIdx   InvertedIdx = "TracesToIdx"
+	TxLookupIdx   InvertedIdx = "TxLookupIdx"
 )
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpansFile - OpenTelemetry tracer provider which writes finished spans to file, one JSON object per line.
+// Spans of erigon (collate, build, merge and prune of state files, commitment) are few and long: file exporter
+// is enough to see where time of background work goes, without collector and OTel SDK dependency.
+type SpansFile struct {
+	lock sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// StartSpansFile - registers SpansFile of `path` as global tracer provider (otel.SetTracerProvider):
+// spans started by otel.Tracer of any package are written to it. Close flushes the file.
+func StartSpansFile(path string) (*SpansFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	p := &SpansFile{f: f, w: w, enc: json.NewEncoder(w)}
+	otel.SetTracerProvider(p)
+	return p, nil
+}
+
+func (p *SpansFile) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	return &fileTracer{p: p, scope: name}
+}
+
+func (p *SpansFile) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.f == nil {
+		return nil
+	}
+	err := p.w.Flush()
+	if closeErr := p.f.Close(); err == nil {
+		err = closeErr
+	}
+	p.f = nil
+	return err
+}
+
+type spanRecord struct {
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentSpanId,omitempty"`
+	Name       string            `json:"name"`
+	Scope      string            `json:"scope"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	DurationMs float64           `json:"durationMs"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Events     []spanEventRecord `json:"events,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	StatusDesc string            `json:"statusDescription,omitempty"`
+}
+
+type spanEventRecord struct {
+	Name       string            `json:"name"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (p *SpansFile) write(rec *spanRecord) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.f == nil { // closed
+		return
+	}
+	_ = p.enc.Encode(rec)
+}
+
+type fileTracer struct {
+	p     *SpansFile
+	scope string
+}
+
+func (t *fileTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	s := &fileSpan{t: t, rec: spanRecord{Name: name, Scope: t.scope, Start: cfg.Timestamp()}}
+	if s.rec.Start.IsZero() {
+		s.rec.Start = time.Now()
+	}
+	var traceID trace.TraceID
+	if !cfg.NewRoot() && parent.IsValid() {
+		traceID = parent.TraceID()
+		s.rec.ParentID = parent.SpanID().String()
+	} else {
+		_, _ = rand.Read(traceID[:])
+	}
+	var spanID trace.SpanID
+	_, _ = rand.Read(spanID[:])
+	s.sc = trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	s.rec.TraceID, s.rec.SpanID = traceID.String(), spanID.String()
+	s.SetAttributes(cfg.Attributes()...)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type fileSpan struct {
+	t     *fileTracer
+	sc    trace.SpanContext
+	lock  sync.Mutex
+	rec   spanRecord
+	ended bool
+}
+
+func attrsMap(m map[string]string, kv []attribute.KeyValue) map[string]string {
+	if len(kv) > 0 && m == nil {
+		m = make(map[string]string, len(kv))
+	}
+	for _, a := range kv {
+		m[string(a.Key)] = a.Value.Emit()
+	}
+	return m
+}
+
+func (s *fileSpan) End(options ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(options...)
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.rec.End = cfg.Timestamp()
+	if s.rec.End.IsZero() {
+		s.rec.End = time.Now()
+	}
+	s.rec.DurationMs = float64(s.rec.End.Sub(s.rec.Start).Microseconds()) / 1000
+	rec := s.rec
+	s.lock.Unlock()
+	s.t.p.write(&rec)
+}
+
+func (s *fileSpan) AddEvent(name string, options ...trace.EventOption) {
+	cfg := trace.NewEventConfig(options...)
+	ev := spanEventRecord{Name: name, Time: cfg.Timestamp(), Attributes: attrsMap(nil, cfg.Attributes())}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.rec.Events = append(s.rec.Events, ev)
+	}
+}
+
+func (s *fileSpan) IsRecording() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.ended
+}
+
+func (s *fileSpan) RecordError(err error, options ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception", append(options, trace.WithAttributes(attribute.String("exception.message", err.Error())))...)
+}
+
+func (s *fileSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func (s *fileSpan) SetStatus(code codes.Code, description string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rec.Status, s.rec.StatusDesc = code.String(), description
+}
+
+func (s *fileSpan) SetName(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rec.Name = name
+}
+
+func (s *fileSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.rec.Attributes = attrsMap(s.rec.Attributes, kv)
+	}
+}
+
+func (s *fileSpan) TracerProvider() trace.TracerProvider { return s.t.p }
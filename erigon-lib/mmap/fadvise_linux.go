@@ -0,0 +1,23 @@
+//go:build linux
+
+package mmap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// FadviseDontNeed - evict clean pages of file from OS page cache. Dirty pages are not evicted:
+// call it after `fsync`.
+func FadviseDontNeed(f *os.File) error {
+	err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+	if err != nil && !errors.Is(err, syscall.ENOSYS) {
+		// Ignore not implemented error in kernel because it still works.
+		return fmt.Errorf("fadvise: %w", err)
+	}
+	return nil
+}
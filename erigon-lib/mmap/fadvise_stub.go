@@ -0,0 +1,7 @@
+//go:build !linux
+
+package mmap
+
+import "os"
+
+func FadviseDontNeed(f *os.File) error { return nil }
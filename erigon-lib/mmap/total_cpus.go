@@ -0,0 +1,18 @@
+package mmap
+
+import (
+	"math"
+	"runtime"
+
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+)
+
+// TotalCPUs - amount of CPUs available to process: GOMAXPROCS, limited by cgroup CPU quota.
+// Go runtime doesn't take cgroup quota into account - containers with CPU quota get GOMAXPROCS of whole machine.
+func TotalCPUs() int {
+	cpus := runtime.GOMAXPROCS(-1)
+	if quota, err := cgroupsCPULimit(); err == nil && quota > 0 {
+		cpus = cmp.Min(cpus, int(math.Ceil(quota)))
+	}
+	return cmp.Max(1, cpus)
+}
@@ -31,6 +31,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/containerd/cgroups/v3"
 	"github.com/containerd/cgroups/v3/cgroup1"
@@ -116,3 +119,85 @@ func cgroupsV2MemoryLimit() (uint64, error) {
 		return stat.Memory.UsageLimit, nil
 	}
 }
+
+// cgroupsCPULimit will try to discover the CPU quota (amount of CPUs, may be fractional)
+// from the cgroup of the process. Returns 0 if cgroup has no CPU quota.
+func cgroupsCPULimit() (float64, error) {
+	switch cgroups.Mode() {
+	case cgroups.Unified:
+		return cgroupsV2CPULimit()
+	case cgroups.Legacy:
+		return cgroupsV1CPULimit()
+	case cgroups.Unavailable:
+		fallthrough
+	default:
+		return 0, errors.New("cgroups not supported in this environment")
+	}
+}
+
+func cgroupsV1CPULimit() (float64, error) {
+	// use self path unless our PID is 1, in which case we're running inside
+	// a container and our limits are in the root path.
+	path := "/"
+	if pid := os.Getpid(); pid != 1 {
+		paths, _, err := cgroups.ParseCgroupFileUnified("/proc/self/cgroup")
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse cgroup1 paths of process: %w", err)
+		}
+		if p, ok := paths["cpu"]; ok {
+			path = p
+		}
+	}
+	quota, err := readCgroupInt(filepath.Join("/sys/fs/cgroup/cpu", path, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, err
+	}
+	period, err := readCgroupInt(filepath.Join("/sys/fs/cgroup/cpu", path, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, err
+	}
+	if quota <= 0 || period <= 0 { // -1 means no quota
+		return 0, nil
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func cgroupsV2CPULimit() (float64, error) {
+	pid := os.Getpid()
+	path, err := cgroup2.PidGroupPath(pid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load cgroup2 path for process pid %d: %w", pid, err)
+	}
+	// format: "$MAX $PERIOD", where $MAX can be "max" - means no quota
+	data, err := os.ReadFile(filepath.Join("/sys/fs/cgroup", path, "cpu.max"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup2 cpu.max: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected cgroup2 cpu.max format: %q", data)
+	}
+	if fields[0] == "max" {
+		return 0, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cgroup2 cpu.max: %w", err)
+	}
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cgroup2 cpu.max: %w", err)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0, nil
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup1 %s: %w", filepath.Base(path), err)
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -9,3 +9,7 @@ import (
 func cgroupsMemoryLimit() (uint64, error) {
 	return 0, errors.New("cgroups not supported in this environment")
 }
+
+func cgroupsCPULimit() (float64, error) {
+	return 0, errors.New("cgroups not supported in this environment")
+}
@@ -315,6 +315,14 @@ func (efi *EliasFanoIter) Reset() {
 	efi.idx = 0
 }
 
+// ResetTo - re-use iterator for `ef` (for example after ef.Reset), without allocation of new one
+func (efi *EliasFanoIter) ResetTo(ef *EliasFano) {
+	efi.ef = ef
+	efi.lowerBits, efi.upperBits = ef.lowerBits, ef.upperBits
+	efi.count, efi.l, efi.lowerBitsMask = ef.count, ef.l, ef.lowerBitsMask
+	efi.Reset()
+}
+
 func (efi *EliasFanoIter) SeekDeprecated(n uint64) {
 	efi.Reset()
 	_, i, ok := efi.ef.search(n)
@@ -436,9 +444,20 @@ func (ef *EliasFano) Reset(r []byte) {
 	ef.deriveFields()
 }
 
+// Max, Count, Min, Seek - read serialized EliasFano without ReadEliasFano: no allocations, cheap on hot paths (every key of merge)
 func Max(r []byte) uint64   { return binary.BigEndian.Uint64(r[8:16]) - 1 }
 func Count(r []byte) uint64 { return binary.BigEndian.Uint64(r[:8]) + 1 }
 
+// Seek - returns the value in the sequence, equal or greater than given value
+func Seek(r []byte, n uint64) (uint64, bool) {
+	if n > Max(r) {
+		return 0, false
+	}
+	var ef EliasFano
+	ef.Reset(r)
+	return ef.Search(n)
+}
+
 const uint64Size = 8
 
 func Min(r []byte) uint64 {
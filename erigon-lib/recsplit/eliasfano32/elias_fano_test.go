@@ -291,3 +291,43 @@ func BenchmarkName(b *testing.B) {
 		}
 	})
 }
+
+func TestSerializedAccessors(t *testing.T) {
+	offsets := []uint64{1, 4, 6, 8, 10, 14, 16, 19, 22, 34, 37, 39, 41, 43, 48, 51, 54, 58, 62}
+	ef := NewEliasFano(uint64(len(offsets)), offsets[len(offsets)-1])
+	for _, offset := range offsets {
+		ef.AddOffset(offset)
+	}
+	ef.Build()
+	buf := ef.AppendBytes(nil)
+
+	require.Equal(t, ef.Count(), Count(buf))
+	require.Equal(t, ef.Max(), Max(buf))
+	require.Equal(t, ef.Min(), Min(buf))
+	for v := uint64(0); v <= 64; v++ {
+		expect, expectOk := ef.Search(v)
+		n, ok := Seek(buf, v)
+		require.Equal(t, expectOk, ok, v)
+		require.Equal(t, expect, n, v)
+	}
+	require.Zero(t, testing.AllocsPerRun(100, func() { Seek(buf, 20) }))
+
+	// iterator re-used for another sequence
+	other := NewEliasFano(2, 1000)
+	other.AddOffset(7)
+	other.AddOffset(1000)
+	other.Build()
+	var reused EliasFano
+	reused.Reset(buf)
+	efi := ef.Iterator()
+	efi.Next()
+	reused.Reset(other.AppendBytes(nil))
+	efi.ResetTo(&reused)
+	var values []uint64
+	for efi.HasNext() {
+		v, err := efi.Next()
+		require.NoError(t, err)
+		values = append(values, v)
+	}
+	require.Equal(t, []uint64{7, 1000}, values)
+}
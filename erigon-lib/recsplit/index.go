@@ -31,6 +31,7 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/exp/slices"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/mmap"
@@ -62,10 +63,14 @@ const (
 	//
 	// See also: https://github.com/ledgerwatch/erigon/issues/9486
 	LessFalsePositives Features = 0b10 //
+	// BucketFallback - list of buckets where 64-bit fingerprints of keys collided: keys of them use fallbackFingerprint.
+	// Build doesn't restart with new salt on such collision (it doubled worst-case build time), only bucket re-split.
+	// Set only if collision happened.
+	BucketFallback Features = 0b100
 )
 
 // SupportedFeaturs - if see feature not from this list (likely after downgrade) - return IncompatibleErr and recommend for user manually delete file
-var SupportedFeatures = []Features{Enums, LessFalsePositives}
+var SupportedFeatures = []Features{Enums, LessFalsePositives, BucketFallback}
 var IncompatibleErr = errors.New("incompatible. can re-build such files by command 'erigon snapshots index'")
 
 // Index implements index lookup from the file created by the RecSplit
@@ -98,6 +103,8 @@ type Index struct {
 	lessFalsePositives bool
 	existence          []byte
 
+	fallbackBuckets []uint64 // ascending, see BucketFallback
+
 	readers *sync.Pool
 }
 
@@ -109,13 +116,18 @@ func MustOpen(indexFile string) *Index {
 	return idx
 }
 
-func OpenIndex(indexFilePath string) (*Index, error) {
+func OpenIndex(indexFilePath string) (idx *Index, err error) {
 	_, fName := filepath.Split(indexFilePath)
-	idx := &Index{
+	idx = &Index{
 		filePath: indexFilePath,
 		fileName: fName,
 	}
-	var err error
+	defer func() { // truncated or corrupted file
+		if rec := recover(); rec != nil {
+			idx.Close()
+			idx, err = nil, fmt.Errorf("open index: %s, %+v, trace: %s", indexFilePath, rec, dbg.Stack())
+		}
+	}()
 	idx.f, err = os.Open(indexFilePath)
 	if err != nil {
 		return nil, err
@@ -190,6 +202,15 @@ func OpenIndex(indexFilePath string) (*Index, error) {
 			offset += int(arrSz)
 		}
 	}
+	if features&BucketFallback != No {
+		n := binary.BigEndian.Uint64(idx.data[offset:])
+		offset += 8
+		idx.fallbackBuckets = make([]uint64, n)
+		for i := range idx.fallbackBuckets {
+			idx.fallbackBuckets[i] = binary.BigEndian.Uint64(idx.data[offset:])
+			offset += 8
+		}
+	}
 	// Size of golomb rice params
 	golombParamSize := binary.BigEndian.Uint16(idx.data[offset:])
 	offset += 4
@@ -278,6 +299,11 @@ func (idx *Index) KeyCount() uint64 {
 	return idx.keyCount
 }
 
+// Salt - seed of hash function, chosen randomly when index is built (see RecSplitArgs.Salt). Every index has own salt.
+func (idx *Index) Salt() uint32 {
+	return idx.salt
+}
+
 // Lookup is not thread-safe because it used id.hasher
 func (idx *Index) Lookup(bucketHash, fingerprint uint64) (uint64, bool) {
 	if idx.keyCount == 0 {
@@ -291,6 +317,11 @@ func (idx *Index) Lookup(bucketHash, fingerprint uint64) (uint64, bool) {
 	gr.data = idx.grData
 
 	bucket := remap(bucketHash, idx.bucketCount)
+	if len(idx.fallbackBuckets) > 0 {
+		if _, ok := slices.BinarySearch(idx.fallbackBuckets, bucket); ok {
+			fingerprint = fallbackFingerprint(bucketHash, fingerprint)
+		}
+	}
 	cumKeys, cumKeysNext, bitPos := idx.ef.Get3(bucket)
 	m := uint16(cumKeysNext - cumKeys) // Number of keys in this bucket
 	gr.ReadReset(int(bitPos), idx.skipBits(m))
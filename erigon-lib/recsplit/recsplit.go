@@ -31,6 +31,7 @@ import (
 	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/spaolacci/murmur3"
+	"golang.org/x/exp/slices"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/assert"
@@ -59,6 +60,13 @@ func remix(z uint64) uint64 {
 	return z ^ (z >> 31)
 }
 
+// fallbackFingerprint - fingerprint of keys in buckets where 64-bit fingerprints collided (see BucketFallback feature).
+// Keys with same bucket and fingerprint have different `bucketHash` (full 64 bits, not only bucket number) with
+// high probability - then they can be separated without re-hashing of all keys with new salt.
+func fallbackFingerprint(bucketHash, fingerprint uint64) uint64 {
+	return fingerprint ^ remix(bucketHash)
+}
+
 // RecSplit is the implementation of Recursive Split algorithm for constructing perfect hash mapping, described in
 // https://arxiv.org/pdf/1910.06416.pdf Emmanuel Esposito, Thomas Mueller Graf, and Sebastiano Vigna.
 // Recsplit: Minimal perfect hashing via recursive splitting. In 2020 Proceedings of the Symposium on Algorithm Engineering and Experiments (ALENEX),
@@ -85,6 +93,9 @@ type RecSplit struct {
 	count             []uint16
 	currentBucket     []uint64 // 64-bit fingerprints of keys in the current bucket accumulated before the recsplit is performed for that bucket
 	currentBucketOffs []uint64 // Index offsets for the current bucket
+	currentBucketHi   []uint64 // bucketHash of keys in the current bucket, need only for fallbackFingerprint
+	fallbackBuckets   []uint64 // Buckets which use fallbackFingerprint, ascending
+	sortBuf           []uint64
 	offsetBuffer      []uint64
 	buffer            []uint64
 	golombRice        []uint32
@@ -191,6 +202,7 @@ func NewRecSplit(args RecSplitArgs, logger log.Logger) (*RecSplit, error) {
 	}
 	rs.currentBucket = make([]uint64, 0, args.BucketSize)
 	rs.currentBucketOffs = make([]uint64, 0, args.BucketSize)
+	rs.currentBucketHi = make([]uint64, 0, args.BucketSize)
 	rs.maxOffset = 0
 	rs.bucketSizeAcc = make([]uint64, 1, bucketCount+1)
 	rs.bucketPosAcc = make([]uint64, 1, bucketCount+1)
@@ -263,6 +275,8 @@ func (rs *RecSplit) ResetNextSalt() {
 	}
 	rs.currentBucket = rs.currentBucket[:0]
 	rs.currentBucketOffs = rs.currentBucketOffs[:0]
+	rs.currentBucketHi = rs.currentBucketHi[:0]
+	rs.fallbackBuckets = rs.fallbackBuckets[:0]
 	rs.maxOffset = 0
 	rs.bucketSizeAcc = rs.bucketSizeAcc[:1] // First entry is always zero
 	rs.bucketPosAcc = rs.bucketPosAcc[:1]   // First entry is always zero
@@ -347,7 +361,12 @@ func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
 	rs.hasher.Reset()
 	rs.hasher.Write(key) //nolint:errcheck
 	hi, lo := rs.hasher.Sum128()
-	binary.BigEndian.PutUint64(rs.bucketKeyBuf[:], remap(hi, rs.bucketCount))
+	return rs.addHashed(hi, lo, offset)
+}
+
+func (rs *RecSplit) addHashed(hi, lo uint64, offset uint64) error {
+	// sorting by `hi` groups keys by bucket (remap is monotonic) and keeps whole `hi` for fallbackFingerprint
+	binary.BigEndian.PutUint64(rs.bucketKeyBuf[:], hi)
 	binary.BigEndian.PutUint64(rs.bucketKeyBuf[8:], lo)
 	binary.BigEndian.PutUint64(rs.numBuf[:], offset)
 	if offset > rs.maxOffset {
@@ -402,11 +421,16 @@ func (rs *RecSplit) recsplitCurrentBucket() error {
 	rs.bucketSizeAcc[int(rs.currentBucketIdx)+1] += uint64(len(rs.currentBucket))
 	// Sets of size 0 and 1 are not further processed, just write them to index
 	if len(rs.currentBucket) > 1 {
-		for i, key := range rs.currentBucket[1:] {
-			if key == rs.currentBucket[i] {
+		if key, ok := rs.duplicateFingerprint(); ok {
+			// re-build only this bucket with fallback fingerprints, instead of restart of whole build with next salt
+			for i := range rs.currentBucket {
+				rs.currentBucket[i] = fallbackFingerprint(rs.currentBucketHi[i], rs.currentBucket[i])
+			}
+			if _, ok = rs.duplicateFingerprint(); ok {
 				rs.collision = true
 				return fmt.Errorf("%w: %x", ErrCollision, key)
 			}
+			rs.fallbackBuckets = append(rs.fallbackBuckets, rs.currentBucketIdx)
 		}
 		bitPos := rs.gr.bitCount
 		if rs.buffer == nil {
@@ -442,9 +466,21 @@ func (rs *RecSplit) recsplitCurrentBucket() error {
 	// clear for the next buckey
 	rs.currentBucket = rs.currentBucket[:0]
 	rs.currentBucketOffs = rs.currentBucketOffs[:0]
+	rs.currentBucketHi = rs.currentBucketHi[:0]
 	return nil
 }
 
+func (rs *RecSplit) duplicateFingerprint() (uint64, bool) {
+	rs.sortBuf = append(rs.sortBuf[:0], rs.currentBucket...)
+	slices.Sort(rs.sortBuf)
+	for i, key := range rs.sortBuf[1:] {
+		if key == rs.sortBuf[i] {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
 // recsplit applies recSplit algorithm to the given bucket
 func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary []uint64) ([]uint64, error) {
 	if rs.trace {
@@ -550,8 +586,9 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 
 // loadFuncBucket is required to satisfy the type etl.LoadFunc type, to use with collector.Load
 func (rs *RecSplit) loadFuncBucket(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
-	// k is the BigEndian encoding of the bucket number, and the v is the key that is assigned into that bucket
-	bucketIdx := binary.BigEndian.Uint64(k)
+	// k is the BigEndian encoding of the bucketHash and fingerprint, and the v is the offset (or enum) of key
+	hi := binary.BigEndian.Uint64(k)
+	bucketIdx := remap(hi, rs.bucketCount)
 	if rs.currentBucketIdx != bucketIdx {
 		if rs.currentBucketIdx != math.MaxUint64 {
 			if err := rs.recsplitCurrentBucket(); err != nil {
@@ -562,6 +599,7 @@ func (rs *RecSplit) loadFuncBucket(k, v []byte, _ etl.CurrentTableReader, _ etl.
 	}
 	rs.currentBucket = append(rs.currentBucket, binary.BigEndian.Uint64(k[8:]))
 	rs.currentBucketOffs = append(rs.currentBucketOffs, binary.BigEndian.Uint64(v))
+	rs.currentBucketHi = append(rs.currentBucketHi, hi)
 	return nil
 }
 
@@ -680,6 +718,9 @@ func (rs *RecSplit) Build(ctx context.Context) error {
 			features |= LessFalsePositives
 		}
 	}
+	if len(rs.fallbackBuckets) > 0 { // files without collisions stay readable by older versions
+		features |= BucketFallback
+	}
 	if err := rs.indexW.WriteByte(byte(features)); err != nil {
 		return fmt.Errorf("writing enums = true: %w", err)
 	}
@@ -692,6 +733,18 @@ func (rs *RecSplit) Build(ctx context.Context) error {
 	if err := rs.flushExistenceFilter(); err != nil {
 		return err
 	}
+	if len(rs.fallbackBuckets) > 0 {
+		binary.BigEndian.PutUint64(rs.numBuf[:], uint64(len(rs.fallbackBuckets)))
+		if _, err := rs.indexW.Write(rs.numBuf[:]); err != nil {
+			return fmt.Errorf("writing fallback buckets: %w", err)
+		}
+		for _, bucket := range rs.fallbackBuckets {
+			binary.BigEndian.PutUint64(rs.numBuf[:], bucket)
+			if _, err := rs.indexW.Write(rs.numBuf[:]); err != nil {
+				return fmt.Errorf("writing fallback buckets: %w", err)
+			}
+		}
+	}
 
 	// Write out the size of golomb rice params
 	binary.BigEndian.PutUint16(rs.numBuf[:], uint16(len(rs.golombRice)))
@@ -772,7 +825,7 @@ func (rs *RecSplit) Stats() (int, int) {
 }
 
 // Collision returns true if there was a collision detected during mapping of keys
-// into 64-bit values
+// into 64-bit values, which fallback fingerprints of bucket didn't resolve (duplicated keys or extremely unlikely)
 // RecSplit needs to be reset, re-populated with keys, and rebuilt
 func (rs *RecSplit) Collision() bool {
 	return rs.collision
@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/ledgerwatch/log/v3"
+	"github.com/spaolacci/murmur3"
 )
 
 func TestRecSplit2(t *testing.T) {
@@ -134,6 +135,51 @@ func TestIndexLookup(t *testing.T) {
 	}
 }
 
+func TestBucketFallback(t *testing.T) {
+	logger := log.New()
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:   100,
+		BucketSize: 10,
+		Salt:       0,
+		TmpDir:     tmpDir,
+		IndexFile:  indexFile,
+		LeafSize:   8,
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi, lo := make([]uint64, 100), make([]uint64, 100)
+	for i := 0; i < 100; i++ {
+		hi[i], lo[i] = murmur3.Sum128([]byte(fmt.Sprintf("key %d", i)))
+	}
+	// fingerprints collision in same bucket: must not fail build
+	hi[99], lo[99] = hi[98]+1, lo[98]
+	for i := 0; i < 100; i++ {
+		if err = rs.addHashed(hi[i], lo[i], uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Collision() {
+		t.Errorf("collision must be resolved by fallback")
+	}
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if len(idx.fallbackBuckets) != 1 || idx.fallbackBuckets[0] != remap(hi[98], idx.bucketCount) {
+		t.Errorf("unexpected fallback buckets: %d", idx.fallbackBuckets)
+	}
+	for i := 0; i < 100; i++ {
+		offset, _ := idx.Lookup(hi[i], lo[i])
+		if offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+}
+
 func TestTwoLayerIndex(t *testing.T) {
 	logger := log.New()
 	tmpDir := t.TempDir()
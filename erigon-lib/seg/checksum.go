@@ -0,0 +1,122 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package seg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+)
+
+// Optional checksums trailer. Appended after compressed data, so files without it are read as before,
+// and old readers of file with trailer only see some garbage after last word (they never read there):
+//
+//	data (header, dictionaries, words) | crc32c of every block of data: 4 bytes each | blockSize: 4 bytes | dataEnd: 8 bytes | magic: 8 bytes
+//
+// Blocks are verified lazily by Getter (once per block per open file), so flipped bit in value becomes
+// ErrChecksumMismatch on read - instead of wrong state root hours later.
+const (
+	checksumBlockSize  = 4096
+	checksumFooterSize = 4 + 8 + 8
+)
+
+var (
+	checksumMagic       = []byte("ERGNCRC1")
+	checksumTable       = crc32.MakeTable(crc32.Castagnoli)
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+)
+
+// appendChecksums - calculate checksums of all data written to `f` and append trailer to the end of `f`
+func appendChecksums(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	dataEnd := st.Size()
+	blocks := (dataEnd + checksumBlockSize - 1) / checksumBlockSize
+	trailer := make([]byte, 0, blocks*4+checksumFooterSize)
+	buf := make([]byte, checksumBlockSize)
+	for off := int64(0); off < dataEnd; off += checksumBlockSize {
+		n, err := f.ReadAt(buf[:cmp.Min(checksumBlockSize, dataEnd-off)], off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		trailer = binary.BigEndian.AppendUint32(trailer, crc32.Checksum(buf[:n], checksumTable))
+	}
+	trailer = binary.BigEndian.AppendUint32(trailer, checksumBlockSize)
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(dataEnd))
+	trailer = append(trailer, checksumMagic...)
+	_, err = f.WriteAt(trailer, dataEnd)
+	return err
+}
+
+type checksums struct {
+	data      []byte // data of file, without trailer
+	blockSize uint64
+	crcs      []byte          // 4 bytes per block
+	verified  []atomic.Uint64 // bitmap of already verified blocks
+}
+
+// readChecksums - parse trailer of file. nil - file has no trailer.
+func readChecksums(file []byte) (*checksums, error) {
+	if len(file) < checksumFooterSize || !bytes.Equal(file[len(file)-len(checksumMagic):], checksumMagic) {
+		return nil, nil
+	}
+	footer := file[len(file)-checksumFooterSize:]
+	blockSize := uint64(binary.BigEndian.Uint32(footer[:4]))
+	dataEnd := binary.BigEndian.Uint64(footer[4:12])
+	if blockSize == 0 || dataEnd > uint64(len(file)) {
+		return nil, fmt.Errorf("checksums trailer is invalid: blockSize=%d, dataEnd=%d", blockSize, dataEnd)
+	}
+	blocks := (dataEnd + blockSize - 1) / blockSize
+	if dataEnd+blocks*4+checksumFooterSize != uint64(len(file)) {
+		return nil, fmt.Errorf("checksums trailer is invalid: blockSize=%d, dataEnd=%d, fileSize=%d", blockSize, dataEnd, len(file))
+	}
+	return &checksums{
+		data:      file[:dataEnd],
+		blockSize: blockSize,
+		crcs:      file[dataEnd : dataEnd+blocks*4],
+		verified:  make([]atomic.Uint64, (blocks+63)/64),
+	}, nil
+}
+
+// verify - blocks which have any byte of [from, to). Thread-safe.
+func (c *checksums) verify(from, to uint64) error {
+	to = cmp.Min(to, uint64(len(c.data)))
+	for b := from / c.blockSize; b*c.blockSize < to; b++ {
+		word, bit := &c.verified[b/64], uint64(1)<<(b%64)
+		if word.Load()&bit != 0 {
+			continue
+		}
+		start := b * c.blockSize
+		end := cmp.Min(start+c.blockSize, uint64(len(c.data)))
+		if crc32.Checksum(c.data[start:end], checksumTable) != binary.BigEndian.Uint32(c.crcs[b*4:]) {
+			return fmt.Errorf("%w: block %d, offset %d", ErrChecksumMismatch, b, start)
+		}
+		for old := word.Load(); !word.CompareAndSwap(old, old|bit); old = word.Load() {
+		}
+	}
+	return nil
+}
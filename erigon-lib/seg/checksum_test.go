@@ -0,0 +1,121 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package seg
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksums(t *testing.T) {
+	logger := log.New()
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "compressed")
+	c, err := NewCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2, log.LvlDebug, logger)
+	require.NoError(t, err)
+	defer c.Close()
+	c.DisableFsync()
+	c.SetChecksums(true)
+
+	rnd := rand.New(rand.NewSource(0))
+	words := make([][]byte, 2000)
+	for i := range words {
+		words[i] = make([]byte, 32)
+		rnd.Read(words[i])
+		require.NoError(t, c.AddWord(words[i]))
+	}
+	require.NoError(t, c.Compress())
+
+	d, err := NewDecompressor(file)
+	require.NoError(t, err)
+	require.True(t, d.HasChecksums())
+	require.NoError(t, d.VerifyChecksums())
+	g := d.MakeGetter()
+	for i := 0; g.HasNext(); i++ {
+		w, _ := g.Next(nil)
+		require.Equal(t, words[i], w)
+	}
+	d.Close()
+
+	// flip bit in last words
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	dataEnd := binary.BigEndian.Uint64(data[len(data)-checksumFooterSize+4:])
+	data[dataEnd-10] ^= 0x01
+	require.NoError(t, os.WriteFile(file, data, 0644))
+
+	d, err = NewDecompressor(file)
+	require.NoError(t, err)
+	defer d.Close()
+	require.ErrorIs(t, d.VerifyChecksums(), ErrChecksumMismatch)
+	g = d.MakeGetter()
+	for g.HasNext() {
+		g.Next(nil)
+	}
+	require.ErrorIs(t, g.Err(), ErrChecksumMismatch)
+
+	// Reset clears error
+	g.Reset(0)
+	require.NoError(t, g.Err())
+	w, _ := g.Next(nil)
+	require.Equal(t, words[0], w)
+	require.NoError(t, g.Err())
+}
+
+func TestGetterOffsetOutOfRange(t *testing.T) {
+	logger := log.New()
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "compressed")
+	c, err := NewCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2, log.LvlDebug, logger)
+	require.NoError(t, err)
+	defer c.Close()
+	c.DisableFsync()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.AddUncompressedWord([]byte("word")))
+	}
+	require.NoError(t, c.Compress())
+
+	d, err := NewDecompressor(file)
+	require.NoError(t, err)
+	defer d.Close()
+	require.False(t, d.HasChecksums())
+	g := d.MakeGetter()
+
+	// corrupted index: offset after end of file
+	g.Reset(uint64(g.Size()) + 100)
+	require.ErrorIs(t, g.Err(), ErrOffsetOutOfRange)
+	require.False(t, g.HasNext())
+
+	// offset in the middle of word: its length is read from data
+	g.Reset(uint64(g.Size()) - 2)
+	w, _ := g.NextUncompressed()
+	require.Nil(t, w)
+	require.ErrorIs(t, g.Err(), ErrOffsetOutOfRange)
+	require.False(t, g.HasNext())
+
+	g.Reset(0)
+	w, _ = g.NextUncompressed()
+	require.NoError(t, g.Err())
+	require.Equal(t, "word", string(w))
+}
@@ -72,7 +72,9 @@ type Compressor struct {
 	lvl              log.Lvl
 	trace            bool
 	logger           log.Logger
-	noFsync          bool // fsync is enabled by default, but tests can manually disable
+	noFsync          bool   // fsync is enabled by default, but tests can manually disable
+	checksums        bool   // append per-block checksums trailer - see checksum.go
+	features         uint64 // features trailer, 0 - no trailer - see features.go
 }
 
 func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, minPatternScore uint64, workers int, lvl log.Lvl, logger log.Logger) (*Compressor, error) {
@@ -83,7 +85,12 @@ func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, mi
 	// It allows to atomically create a ".seg" file (the downloader will not see partial ".seg" files).
 	tmpOutFilePath := filepath.Join(dir, fileName) + ".tmp"
 
-	uncompressedPath := filepath.Join(tmpDir, fileName) + ".idt"
+	// size of .idt is not known in advance: only free space of tmp dir is checked (see etl.TmpPolicy)
+	idtDir, err := etl.PickTmpDir(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	uncompressedPath := filepath.Join(idtDir, fileName) + ".idt"
 	uncompressedFile, err := NewRawWordsFile(uncompressedPath)
 	if err != nil {
 		return nil, err
@@ -99,7 +106,10 @@ func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, mi
 		collector.LogLvl(lvl)
 
 		suffixCollectors[i] = collector
-		go extractPatternsInSuperstrings(ctx, superstrings, collector, minPatternScore, wg, logger)
+		go func(i int) {
+			defer pinToNUMANode(i)()
+			extractPatternsInSuperstrings(ctx, superstrings, collector, minPatternScore, wg, logger)
+		}(i)
 	}
 
 	return &Compressor{
@@ -131,6 +141,14 @@ func (c *Compressor) Workers() int        { return c.workers }
 
 func (c *Compressor) Count() int { return int(c.wordsCount) }
 
+// SetChecksums - add per-block checksums to file: Getter will detect corrupted blocks on read. Readers without
+// checksums support can read such files too.
+func (c *Compressor) SetChecksums(v bool) { c.checksums = v }
+
+// SetFeatures - bitmask of formats of words, stored in file: see Decompressor.Features. 0 - file has no trailer.
+func (c *Compressor) SetFeatures(features uint64) { c.features = features }
+func (c *Compressor) Features() uint64            { return c.features }
+
 func (c *Compressor) AddWord(word []byte) error {
 	select {
 	case <-c.ctx.Done():
@@ -213,6 +231,16 @@ func (c *Compressor) Compress() error {
 	if err := compressWithPatternCandidates(c.ctx, c.trace, c.logPrefix, c.tmpOutFilePath, cf, c.uncompressedFile, c.workers, db, c.lvl, c.logger); err != nil {
 		return err
 	}
+	if c.checksums {
+		if err = appendChecksums(cf); err != nil {
+			return err
+		}
+	}
+	if c.features != 0 {
+		if err = appendFeatures(cf, c.features); err != nil {
+			return err
+		}
+	}
 	if err = c.fsync(cf); err != nil {
 		return err
 	}
@@ -113,6 +113,8 @@ type Decompressor struct {
 	modTime         time.Time
 	wordsCount      uint64
 	emptyWordsCount uint64
+	checksums       *checksums // nil - file has no checksums trailer
+	features        uint64     // see Compressor.SetFeatures
 
 	filePath, fileName string
 }
@@ -182,6 +184,13 @@ func NewDecompressor(compressedFilePath string) (d *Decompressor, err error) {
 	}
 	// read patterns from file
 	d.data = d.mmapHandle1[:d.size]
+	d.features, d.data = readFeatures(d.data)
+	if d.checksums, err = readChecksums(d.data); err != nil {
+		return nil, err
+	}
+	if d.checksums != nil {
+		d.data = d.checksums.data
+	}
 	defer d.EnableReadAhead().DisableReadAhead() //speedup opening on slow drives
 
 	d.wordsCount = binary.BigEndian.Uint64(d.data[:8])
@@ -270,6 +279,11 @@ func NewDecompressor(compressedFilePath string) (d *Decompressor, err error) {
 		}
 	}
 	d.wordsStart = pos + 8 + dictSize
+	if d.checksums != nil {
+		if err = d.checksums.verify(0, d.wordsStart); err != nil {
+			return nil, fmt.Errorf("%s: dictionary: %w", fName, err)
+		}
+	}
 	return d, nil
 }
 
@@ -370,6 +384,23 @@ func (d *Decompressor) DataHandle() unsafe.Pointer {
 	return unsafe.Pointer(&d.data[0])
 }
 
+// Features - bitmask of formats of words, set by writer of file (see Compressor.SetFeatures)
+func (d *Decompressor) Features() uint64 { return d.features }
+
+// HasChecksums - file has per-block checksums (see Compressor.SetChecksums). Blocks are verified by Getter on read.
+func (d *Decompressor) HasChecksums() bool { return d.checksums != nil }
+
+// VerifyChecksums - verify all blocks of file. nil if file has no checksums.
+func (d *Decompressor) VerifyChecksums() error {
+	if d.checksums == nil {
+		return nil
+	}
+	if err := d.checksums.verify(0, uint64(len(d.data))); err != nil {
+		return fmt.Errorf("%s: %w", d.fileName, err)
+	}
+	return nil
+}
+
 func (d *Decompressor) Size() int64 {
 	return d.size
 }
@@ -447,6 +478,9 @@ type Getter struct {
 	dataP       uint64
 	dataBit     int // Value 0..7 - position of the bit
 	trace       bool
+	checksums   *checksums // nil - no checksums in file
+	wordsStart  uint64     // offset of `data` in file - checksums are by offsets in file
+	err         error      // corrupted data found by last Reset/Next - see Err
 }
 
 func (g *Getter) Trace(t bool)     { g.trace = t }
@@ -551,22 +585,74 @@ func (d *Decompressor) MakeGetter() *Getter {
 		data:        d.data[d.wordsStart:],
 		patternDict: d.dict,
 		fName:       d.fileName,
+		checksums:   d.checksums,
+		wordsStart:  d.wordsStart,
 	}
 }
 
+// Err - corrupted data found since last Reset: offset out of file, or block of word doesn't match its checksum
+// (files with checksums). Word returned together with error must not be used. Getter's read methods have
+// no error in signature: callers which read files with checksums check Err after read.
+func (g *Getter) Err() error { return g.err }
+
+func (g *Getter) setErr(err error) {
+	if g.err == nil {
+		g.err = fmt.Errorf("file: %s, %w", g.fName, err)
+	}
+}
+
+// verifyChecksums - of blocks read by word which starts at `from` and ends at current offset
+func (g *Getter) verifyChecksums(from uint64) {
+	if g.checksums == nil {
+		return
+	}
+	if err := g.checksums.verify(g.wordsStart+from, g.wordsStart+g.dataP); err != nil {
+		g.setErr(err)
+	}
+}
+
+// recoverCorrupted - decoding of corrupted word (bit flip in huffman codes) may go out of data before
+// checksums are verified. For files with checksums such panic becomes error of Getter. Must be deferred directly.
+func (g *Getter) recoverCorrupted(from uint64) {
+	if rec := recover(); rec != nil {
+		g.corrupted(from, rec)
+	}
+}
+
+func (g *Getter) corrupted(from uint64, rec any) {
+	if g.checksums == nil {
+		panic(rec)
+	}
+	if err := g.checksums.verify(g.wordsStart+from, g.wordsStart+uint64(len(g.data))); err != nil {
+		g.setErr(err)
+	} else {
+		g.setErr(fmt.Errorf("%w: word at offset %d: %v", ErrOffsetOutOfRange, from, rec))
+	}
+	g.dataP, g.dataBit = uint64(len(g.data)), 0
+}
+
+// Reset - moves Getter to `offset` and clears Err. Offset out of data (corrupted index) is Err, not panic
 func (g *Getter) Reset(offset uint64) {
+	g.err = nil
+	if offset > uint64(len(g.data)) {
+		g.setErr(fmt.Errorf("%w: offset %d, size %d", ErrOffsetOutOfRange, offset, len(g.data)))
+		offset = uint64(len(g.data))
+	}
 	g.dataP = offset
 	g.dataBit = 0
 }
 
 func (g *Getter) HasNext() bool {
-	return g.dataP < uint64(len(g.data))
+	return g.err == nil && g.dataP < uint64(len(g.data))
 }
 
 // Next extracts a compressed word from current offset in the file
 // and appends it to the given buf, returning the result of appending
 // After extracting next word, it moves to the beginning of the next one
 func (g *Getter) Next(buf []byte) ([]byte, uint64) {
+	if g.checksums != nil {
+		defer g.recoverCorrupted(g.dataP)
+	}
 	savePos := g.dataP
 	wordLen := g.nextPos(true)
 	wordLen-- // because when create huffman tree we do ++ , because 0 is terminator
@@ -578,6 +664,7 @@ func (g *Getter) Next(buf []byte) ([]byte, uint64) {
 		if buf == nil { // wordLen == 0, means we have valid record of 0 size. nil - is the marker of "something not found"
 			buf = []byte{}
 		}
+		g.verifyChecksums(savePos)
 		return buf, g.dataP
 	}
 
@@ -629,10 +716,12 @@ func (g *Getter) Next(buf []byte) ([]byte, uint64) {
 	}
 	g.dataP = postLoopPos
 	g.dataBit = 0
+	g.verifyChecksums(savePos)
 	return buf, postLoopPos
 }
 
 func (g *Getter) NextUncompressed() ([]byte, uint64) {
+	savePos := g.dataP
 	wordLen := g.nextPos(true)
 	wordLen-- // because when create huffman tree we do ++ , because 0 is terminator
 	if wordLen == 0 {
@@ -640,6 +729,7 @@ func (g *Getter) NextUncompressed() ([]byte, uint64) {
 			g.dataP++
 			g.dataBit = 0
 		}
+		g.verifyChecksums(savePos)
 		return g.data[g.dataP:g.dataP], g.dataP
 	}
 	g.nextPos(false)
@@ -648,7 +738,13 @@ func (g *Getter) NextUncompressed() ([]byte, uint64) {
 		g.dataBit = 0
 	}
 	pos := g.dataP
+	if pos > uint64(len(g.data)) || wordLen > uint64(len(g.data))-pos {
+		g.setErr(fmt.Errorf("%w: word at offset %d, length %d, size %d", ErrOffsetOutOfRange, savePos, wordLen, len(g.data)))
+		g.dataP = uint64(len(g.data))
+		return nil, g.dataP
+	}
 	g.dataP += wordLen
+	g.verifyChecksums(savePos)
 	return g.data[pos:g.dataP], g.dataP
 }
 
@@ -1019,13 +1115,17 @@ func (g *Getter) MatchPrefixUncompressed(prefix []byte) int {
 // It is important to allocate enough buf size. Could throw an error if word in file is larger then the buf size.
 // After extracting next word, it moves to the beginning of the next one
 func (g *Getter) FastNext(buf []byte) ([]byte, uint64) {
+	savePos := g.dataP
 	defer func() {
 		if rec := recover(); rec != nil {
+			if g.checksums != nil {
+				g.corrupted(savePos, rec)
+				return
+			}
 			panic(fmt.Sprintf("file: %s, %s, %s", g.fName, rec, dbg.Stack()))
 		}
 	}()
 
-	savePos := g.dataP
 	wordLen := g.nextPos(true)
 	wordLen-- // because when create huffman tree we do ++ , because 0 is terminator
 	// decoded := make([]byte, wordLen)
@@ -1034,6 +1134,7 @@ func (g *Getter) FastNext(buf []byte) ([]byte, uint64) {
 			g.dataP++
 			g.dataBit = 0
 		}
+		g.verifyChecksums(savePos)
 		return buf[:wordLen], g.dataP
 	}
 	bufPos := 0 // Tracking position in buf where to insert part of the word
@@ -1075,5 +1176,6 @@ func (g *Getter) FastNext(buf []byte) ([]byte, uint64) {
 	}
 	g.dataP = postLoopPos
 	g.dataBit = 0
+	g.verifyChecksums(savePos)
 	return buf[:wordLen], postLoopPos
 }
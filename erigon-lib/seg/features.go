@@ -0,0 +1,51 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package seg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// Optional features trailer: bitmask of formats of words, defined by owner of file (see Compressor.SetFeatures).
+// Readers must not guess format of words by other files or settings: file tells it. Appended last, after checksums:
+//
+//	data (header, dictionaries, words) | checksums trailer (optional) | features: 8 bytes | magic: 8 bytes
+const featuresTrailerSize = 8 + 8
+
+var featuresMagic = []byte("ERGNFEA1")
+
+// appendFeatures - append trailer to the end of `f`
+func appendFeatures(f *os.File, features uint64) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	trailer := binary.BigEndian.AppendUint64(make([]byte, 0, featuresTrailerSize), features)
+	trailer = append(trailer, featuresMagic...)
+	_, err = f.WriteAt(trailer, st.Size())
+	return err
+}
+
+// readFeatures - parse trailer of file, returns file without trailer. 0 - file has no trailer.
+func readFeatures(file []byte) (features uint64, rest []byte) {
+	if len(file) < featuresTrailerSize || !bytes.Equal(file[len(file)-len(featuresMagic):], featuresMagic) {
+		return 0, file
+	}
+	return binary.BigEndian.Uint64(file[len(file)-featuresTrailerSize:]), file[:len(file)-featuresTrailerSize]
+}
@@ -0,0 +1,61 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package seg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatures(t *testing.T) {
+	tmpDir := t.TempDir()
+	compress := func(features uint64, checksums bool) *Decompressor {
+		file := filepath.Join(tmpDir, fmt.Sprintf("compressed-%d-%t", features, checksums))
+		c, err := NewCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2, log.LvlDebug, log.New())
+		require.NoError(t, err)
+		defer c.Close()
+		c.DisableFsync()
+		c.SetChecksums(checksums)
+		c.SetFeatures(features)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, c.AddWord([]byte(fmt.Sprintf("word %d", i))))
+		}
+		require.NoError(t, c.Compress())
+		d, err := NewDecompressor(file)
+		require.NoError(t, err)
+		t.Cleanup(d.Close)
+		return d
+	}
+	for _, checksums := range []bool{false, true} {
+		for _, features := range []uint64{0, 0b101} {
+			d := compress(features, checksums)
+			require.Equal(t, features, d.Features())
+			require.Equal(t, checksums, d.HasChecksums())
+			require.NoError(t, d.VerifyChecksums())
+			g := d.MakeGetter()
+			for i := 0; g.HasNext(); i++ {
+				w, _ := g.Next(nil)
+				require.Equal(t, fmt.Sprintf("word %d", i), string(w))
+			}
+		}
+	}
+}
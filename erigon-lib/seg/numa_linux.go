@@ -0,0 +1,122 @@
+//go:build linux
+
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package seg
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+)
+
+var (
+	numaNodesCPUs     [][]int
+	numaNodesCPUsOnce sync.Once
+)
+
+// numaNodes - list of CPUs of each NUMA node. Empty if machine has 1 node or topology is unknown.
+func numaNodes() [][]int {
+	numaNodesCPUsOnce.Do(func() {
+		dirs, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+		if err != nil || len(dirs) < 2 {
+			return
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			data, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+			if err != nil {
+				numaNodesCPUs = nil
+				return
+			}
+			cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+			if err != nil {
+				numaNodesCPUs = nil
+				return
+			}
+			if len(cpus) > 0 { // memory-only nodes have no CPUs
+				numaNodesCPUs = append(numaNodesCPUs, cpus)
+			}
+		}
+		if len(numaNodesCPUs) < 2 {
+			numaNodesCPUs = nil
+		}
+	})
+	return numaNodesCPUs
+}
+
+// parseCPUList - parse kernel's cpulist format: "0-3,8,10-11"
+func parseCPUList(s string) (cpus []int, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil {
+				return nil, err
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// pinToNUMANode - when dbg.CompressNUMAPin is set: lock current goroutine to OS thread and bind thread to CPUs
+// of NUMA node `worker % nodesAmount` - workers of one file are spread round-robin between nodes.
+// Usage: `defer pinToNUMANode(i)()`
+func pinToNUMANode(worker int) (unpin func()) {
+	if !dbg.CompressNUMAPin {
+		return func() {}
+	}
+	nodes := numaNodes()
+	if len(nodes) == 0 {
+		return func() {}
+	}
+	runtime.LockOSThread()
+	var prev, set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &prev); err != nil {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+	for _, cpu := range nodes[worker%len(nodes)] {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+	return func() {
+		_ = unix.SchedSetaffinity(0, &prev)
+		runtime.UnlockOSThread()
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package seg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-3,8,10-11")
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 8, 10, 11}, cpus)
+
+	cpus, err = parseCPUList("")
+	require.NoError(t, err)
+	require.Empty(t, cpus)
+
+	_, err = parseCPUList("0-a")
+	require.Error(t, err)
+}
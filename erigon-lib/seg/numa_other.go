@@ -0,0 +1,5 @@
+//go:build !linux
+
+package seg
+
+func pinToNUMANode(worker int) (unpin func()) { return func() {} }
@@ -291,7 +291,10 @@ func compressWithPatternCandidates(ctx context.Context, trace bool, logPrefix, s
 			posMap := make(map[uint64]uint64)
 			posMaps = append(posMaps, posMap)
 			wg.Add(1)
-			go coverWordsByPatternsWorker(trace, ch, out, &wg, &pt, inputSize, outputSize, posMap)
+			go func(i int) {
+				defer pinToNUMANode(i)()
+				coverWordsByPatternsWorker(trace, ch, out, &wg, &pt, inputSize, outputSize, posMap)
+			}(i)
 		}
 	}
 	t := time.Now()
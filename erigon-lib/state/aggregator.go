@@ -23,73 +23,169 @@ import (
 	"math"
 	"math/bits"
 	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/log/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ledgerwatch/erigon-lib/commitment"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/metrics"
+	"github.com/ledgerwatch/erigon-lib/types"
 )
 
 // StepsInBiggestFile - files of this size are completely frozen/immutable.
 // files of smaller size are also immutable, but can be removed after merge to bigger files.
 const StepsInBiggestFile = 32
 
+// aggMetrics - metrics of one aggregator: files building, merges, prune and commitment.
+// `instance` label is added if not empty, see AggregatorV3.SetMetricsLabel
+type aggMetrics struct {
+	currentTx, currentBlock                           metrics.Gauge
+	runningMerges, runningCollations, pruningProgress metrics.Gauge
+	collationSize, collationSizeHist, stepCurrent     metrics.Gauge
+	collateTook, pruneTook, pruneHistTook, stepTook   metrics.Histogram
+	pruneSize                                         metrics.Counter
+	buildTook                                         metrics.Summary
+	commitmentKeys, commitmentUpdates                 metrics.Counter
+	commitmentUpdatesApplied                          metrics.Counter
+	commitmentRunning                                 metrics.Gauge
+	commitmentTook                                    metrics.Summary
+	commitmentWriteTook                               metrics.Histogram
+}
+
+func newAggMetrics(instance string) *aggMetrics {
+	name := func(n string) string {
+		if instance == "" {
+			return n
+		}
+		return fmt.Sprintf(`%s{instance="%s"}`, n, instance)
+	}
+	return &aggMetrics{
+		currentTx:                metrics.GetOrCreateGauge(name("domain_tx_processed")),
+		currentBlock:             metrics.GetOrCreateGauge(name("domain_block_current")),
+		runningMerges:            metrics.GetOrCreateGauge(name("domain_running_merges")),
+		runningCollations:        metrics.GetOrCreateGauge(name("domain_running_collations")),
+		collateTook:              metrics.GetOrCreateHistogram(name("domain_collate_took")),
+		pruneTook:                metrics.GetOrCreateHistogram(name("domain_prune_took")),
+		pruneHistTook:            metrics.GetOrCreateHistogram(name("domain_prune_hist_took")),
+		pruningProgress:          metrics.GetOrCreateGauge(name("domain_pruning_progress")),
+		collationSize:            metrics.GetOrCreateGauge(name("domain_collation_size")),
+		collationSizeHist:        metrics.GetOrCreateGauge(name("domain_collation_hist_size")),
+		pruneSize:                metrics.GetOrCreateCounter(name("domain_prune_size")),
+		buildTook:                metrics.GetOrCreateSummary(name("domain_build_files_took")),
+		stepCurrent:              metrics.GetOrCreateGauge(name("domain_step_current")),
+		stepTook:                 metrics.GetOrCreateHistogram(name("domain_step_took")),
+		commitmentKeys:           metrics.GetOrCreateCounter(name("domain_commitment_keys")),
+		commitmentRunning:        metrics.GetOrCreateGauge(name("domain_running_commitment")),
+		commitmentTook:           metrics.GetOrCreateSummary(name("domain_commitment_took")),
+		commitmentWriteTook:      metrics.GetOrCreateHistogram(name("domain_commitment_write_took")),
+		commitmentUpdates:        metrics.GetOrCreateCounter(name("domain_commitment_updates")),
+		commitmentUpdatesApplied: metrics.GetOrCreateCounter(name("domain_commitment_updates_applied")),
+	}
+}
+
 var (
-	mxCurrentTx                = metrics.GetOrCreateGauge("domain_tx_processed")
-	mxCurrentBlock             = metrics.GetOrCreateGauge("domain_block_current")
-	mxRunningMerges            = metrics.GetOrCreateGauge("domain_running_merges")
-	mxRunningCollations        = metrics.GetOrCreateGauge("domain_running_collations")
-	mxCollateTook              = metrics.GetOrCreateHistogram("domain_collate_took")
-	mxPruneTook                = metrics.GetOrCreateHistogram("domain_prune_took")
-	mxPruneHistTook            = metrics.GetOrCreateHistogram("domain_prune_hist_took")
-	mxPruningProgress          = metrics.GetOrCreateGauge("domain_pruning_progress")
-	mxCollationSize            = metrics.GetOrCreateGauge("domain_collation_size")
-	mxCollationSizeHist        = metrics.GetOrCreateGauge("domain_collation_hist_size")
-	mxPruneSize                = metrics.GetOrCreateCounter("domain_prune_size")
-	mxBuildTook                = metrics.GetOrCreateSummary("domain_build_files_took")
-	mxStepCurrent              = metrics.GetOrCreateGauge("domain_step_current")
-	mxStepTook                 = metrics.GetOrCreateHistogram("domain_step_took")
-	mxCommitmentKeys           = metrics.GetOrCreateCounter("domain_commitment_keys")
-	mxCommitmentRunning        = metrics.GetOrCreateGauge("domain_running_commitment")
-	mxCommitmentTook           = metrics.GetOrCreateSummary("domain_commitment_took")
-	mxCommitmentWriteTook      = metrics.GetOrCreateHistogram("domain_commitment_write_took")
-	mxCommitmentUpdates        = metrics.GetOrCreateCounter("domain_commitment_updates")
-	mxCommitmentUpdatesApplied = metrics.GetOrCreateCounter("domain_commitment_updates_applied")
+	defaultAggMetrics = newAggMetrics("")
+
+	mxMergeTook      = metrics.NewHistTimer("domain_merge_took")
+	mxBuildFileTook  = metrics.NewHistTimer("domain_build_file_took")
+	mxBuildIndexTook = metrics.NewHistTimer("domain_build_index_took")
 )
 
+// fileTook - starts timer of building of file `name` (like `accounts.0-16.kv`), labeled by domain, file type and
+// span of file in steps. `instance` - see AggregatorV3.SetMetricsLabel, empty for default aggregator
+func fileTook(h *metrics.HistTimer, name, instance string) *metrics.HistTimer {
+	name = filepath.Base(name)
+	ext := filepath.Ext(name)
+	domain, stepRange, _ := strings.Cut(strings.TrimSuffix(name, ext), ".")
+	var from, to uint64
+	if _, err := fmt.Sscanf(stepRange, "%d-%d", &from, &to); err != nil || to < from {
+		from, to = 0, 0
+	}
+	tags := []string{"domain", domain, "file", strings.TrimPrefix(ext, "."), "span", strconv.FormatUint(to-from, 10)}
+	if instance != "" {
+		tags = append(tags, "instance", instance)
+	}
+	return h.Tag(tags...)
+}
+
+var tracer = otel.Tracer("github.com/ledgerwatch/erigon-lib/state")
+
+// startSpan - OpenTelemetry span of aggregation operation `op` over steps of `domain`. Spans are no-op until
+// tracer provider is registered by otel.SetTracerProvider (for example by metrics.StartSpansFile, --trace.spans).
+func startSpan(ctx context.Context, op, domain string, txFrom, txTo, aggregationStep uint64) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "state."+op, trace.WithAttributes(
+		attribute.String("domain", domain),
+		attribute.Int64("step.from", int64(txFrom/aggregationStep)),
+		attribute.Int64("step.to", int64(txTo/aggregationStep)),
+	))
+}
+
+// StartCommitmentSpan - span of commitment computed outside of aggregator: AggregatorV3 has no commitment
+// domain, commitment of blocks [fromBlock, toBlock] is computed by Trie stage. See startSpan
+func StartCommitmentSpan(ctx context.Context, fromBlock, toBlock uint64) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "state.compute_commitment", trace.WithAttributes(
+		attribute.String("domain", "commitment"),
+		attribute.Int64("block.from", int64(fromBlock)),
+		attribute.Int64("block.to", int64(toBlock)),
+	))
+}
+
+// pprofLabels - labels current goroutine (and goroutines started by it: compression/index workers) by operation,
+// domain and step range, so CPU profiles attribute time to files. Returned func restores labels of `ctx`.
+func pprofLabels(ctx context.Context, op, domain string, txFrom, txTo, aggregationStep uint64) (context.Context, func()) {
+	labeled := pprof.WithLabels(ctx, pprof.Labels("op", op, "domain", domain,
+		"steps", fmt.Sprintf("%d-%d", txFrom/aggregationStep, txTo/aggregationStep)))
+	pprof.SetGoroutineLabels(labeled)
+	return labeled, func() { pprof.SetGoroutineLabels(ctx) }
+}
+
 type Aggregator struct {
-	db              kv.RwDB
-	aggregationStep uint64
-	accounts        *Domain
-	storage         *Domain
-	code            *Domain
-	commitment      *DomainCommitted
-	logAddrs        *InvertedIndex
-	logTopics       *InvertedIndex
-	tracesFrom      *InvertedIndex
-	tracesTo        *InvertedIndex
-	txNum           uint64
-	seekTxNum       uint64
-	blockNum        uint64
-	stepDoneNotice  chan [length.Hash]byte
-	rwTx            kv.RwTx
-	stats           FilesStats
-	tmpdir          string
-	defaultCtx      *AggregatorContext
+	db               kv.RwDB
+	aggregationStep  uint64
+	accounts         *Domain
+	storage          *Domain
+	code             *Domain
+	commitment       *DomainCommitted
+	logAddrs         *InvertedIndex
+	logTopics        *InvertedIndex
+	tracesFrom       *InvertedIndex
+	tracesTo         *InvertedIndex
+	extraDomains     []*Domain         // chain-specific, see RegisterDomain
+	storageContracts map[string]int    // contract address -> index in extraDomains, see AddStorageContract
+	storageKeep      func([]byte) bool // filter of storage keys set before AddStorageContract, applied to all storage files
+	extraIndices     []*InvertedIndex  // chain-specific, see RegisterInvertedIndex
+	txNum            uint64
+	seekTxNum        uint64
+	blockNum         uint64
+	stepDoneNotice   chan [length.Hash]byte
+	rwTx             kv.RwTx
+	stats            FilesStats
+	dir, tmpdir      string
+	defaultCtx       *AggregatorContext
 
 	ps     *background.ProgressSet
 	logger log.Logger
+
+	accountCodec types.AccountCodec // encoding of values of accounts domain, see SetAccountCodec
+	mx           *aggMetrics        // see SetMetricsLabel
 }
 
 //type exposedMetrics struct {
@@ -109,7 +205,7 @@ type Aggregator struct {
 //}
 
 func NewAggregator(dir, tmpdir string, aggregationStep uint64, commitmentMode CommitmentMode, commitTrieVariant commitment.TrieVariant, logger log.Logger) (*Aggregator, error) {
-	a := &Aggregator{aggregationStep: aggregationStep, ps: background.NewProgressSet(), tmpdir: tmpdir, stepDoneNotice: make(chan [length.Hash]byte, 1), logger: logger}
+	a := &Aggregator{aggregationStep: aggregationStep, ps: background.NewProgressSet(), dir: dir, tmpdir: tmpdir, stepDoneNotice: make(chan [length.Hash]byte, 1), logger: logger, accountCodec: types.AccountCodecV3, mx: defaultAggMetrics}
 
 	closeAgg := true
 	defer func() {
@@ -127,6 +223,9 @@ func NewAggregator(dir, tmpdir string, aggregationStep uint64, commitmentMode Co
 	if a.storage, err = NewDomain(dir, tmpdir, aggregationStep, "storage", kv.TblStorageKeys, kv.TblStorageVals, kv.TblStorageHistoryKeys, kv.TblStorageHistoryVals, kv.TblStorageIdx, false, false, logger); err != nil {
 		return nil, err
 	}
+	if dbg.LazySelfDestruct && commitmentMode == CommitmentModeDisabled { // commitment would touch every deleted slot anyway
+		a.storage.tombstonePrefixLen = length.Addr // storage key is addr+location, see DeleteAccount
+	}
 	if a.code, err = NewDomain(dir, tmpdir, aggregationStep, "code", kv.TblCodeKeys, kv.TblCodeVals, kv.TblCodeHistoryKeys, kv.TblCodeHistoryVals, kv.TblCodeIdx, true, true, logger); err != nil {
 		return nil, err
 	}
@@ -135,7 +234,7 @@ func NewAggregator(dir, tmpdir string, aggregationStep uint64, commitmentMode Co
 	if err != nil {
 		return nil, err
 	}
-	a.commitment = NewCommittedDomain(commitd, commitmentMode, commitTrieVariant, logger)
+	a.commitment = NewCommittedDomain(commitd, kv.TblCommitmentState, commitmentMode, commitTrieVariant, logger)
 
 	if a.logAddrs, err = NewInvertedIndex(dir, tmpdir, aggregationStep, "logaddrs", kv.TblLogAddressKeys, kv.TblLogAddressIdx, false, nil, logger); err != nil {
 		return nil, err
@@ -179,6 +278,11 @@ func (a *Aggregator) ReopenFolder() (err error) {
 		if err = a.buildMissedIdxBlocking(a.commitment.Domain); err != nil {
 			return err
 		}
+		for _, d := range a.extraDomains {
+			if err = a.buildMissedIdxBlocking(d); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err = a.accounts.OpenFolder(); err != nil {
@@ -205,6 +309,16 @@ func (a *Aggregator) ReopenFolder() (err error) {
 	if err = a.tracesTo.OpenFolder(); err != nil {
 		return fmt.Errorf("OpenFolder: %w", err)
 	}
+	for _, d := range a.extraDomains {
+		if err = d.OpenFolder(); err != nil {
+			return fmt.Errorf("OpenFolder: %w", err)
+		}
+	}
+	for _, ii := range a.extraIndices {
+		if err = ii.OpenFolder(); err != nil {
+			return fmt.Errorf("OpenFolder: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -234,6 +348,16 @@ func (a *Aggregator) ReopenList(fNames []string) error {
 	if err = a.tracesTo.OpenList(fNames); err != nil {
 		return err
 	}
+	for _, d := range a.extraDomains {
+		if err = d.OpenList(fNames); err != nil {
+			return err
+		}
+	}
+	for _, ii := range a.extraIndices {
+		if err = ii.OpenList(fNames); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -243,6 +367,9 @@ func (a *Aggregator) GetAndResetStats() DomainStats {
 	stats.Accumulate(a.storage.GetAndResetStats())
 	stats.Accumulate(a.code.GetAndResetStats())
 	stats.Accumulate(a.commitment.GetAndResetStats())
+	for _, d := range a.extraDomains {
+		stats.Accumulate(d.GetAndResetStats())
+	}
 
 	var tto, tfrom, ltopics, laddr DomainStats
 	tto.FilesCount, tto.DataSize, tto.IndexSize = a.tracesTo.collectFilesStat()
@@ -254,6 +381,11 @@ func (a *Aggregator) GetAndResetStats() DomainStats {
 	stats.Accumulate(tfrom)
 	stats.Accumulate(ltopics)
 	stats.Accumulate(laddr)
+	for _, ii := range a.extraIndices {
+		var is DomainStats
+		is.FilesCount, is.DataSize, is.IndexSize = ii.collectFilesStat()
+		stats.Accumulate(is)
+	}
 	return stats
 }
 
@@ -289,6 +421,12 @@ func (a *Aggregator) Close() {
 	if a.tracesTo != nil {
 		a.tracesTo.Close()
 	}
+	for _, d := range a.extraDomains {
+		d.Close()
+	}
+	for _, ii := range a.extraIndices {
+		ii.Close()
+	}
 }
 
 func (a *Aggregator) SetTx(tx kv.RwTx) {
@@ -301,10 +439,16 @@ func (a *Aggregator) SetTx(tx kv.RwTx) {
 	a.logTopics.SetTx(tx)
 	a.tracesFrom.SetTx(tx)
 	a.tracesTo.SetTx(tx)
+	for _, d := range a.extraDomains {
+		d.SetTx(tx)
+	}
+	for _, ii := range a.extraIndices {
+		ii.SetTx(tx)
+	}
 }
 
 func (a *Aggregator) SetTxNum(txNum uint64) {
-	mxCurrentTx.SetUint64(txNum)
+	a.mx.currentTx.SetUint64(txNum)
 
 	a.txNum = txNum
 	a.accounts.SetTxNum(txNum)
@@ -315,11 +459,28 @@ func (a *Aggregator) SetTxNum(txNum uint64) {
 	a.logTopics.SetTxNum(txNum)
 	a.tracesFrom.SetTxNum(txNum)
 	a.tracesTo.SetTxNum(txNum)
+	for _, d := range a.extraDomains {
+		d.SetTxNum(txNum)
+	}
+	for _, ii := range a.extraIndices {
+		ii.SetTxNum(txNum)
+	}
+}
+
+// SetMetricsLabel - same as AggregatorV3.SetMetricsLabel. Must be called before ReopenFolder
+func (a *Aggregator) SetMetricsLabel(label string) {
+	a.mx = newAggMetrics(label)
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code, a.commitment.Domain}, a.extraDomains...) {
+		d.metricsLabel, d.mx = label, a.mx
+	}
+	for _, ii := range append([]*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo}, a.extraIndices...) {
+		ii.metricsLabel, ii.mx = label, a.mx
+	}
 }
 
 func (a *Aggregator) SetBlockNum(blockNum uint64) {
 	a.blockNum = blockNum
-	mxCurrentBlock.SetUint64(blockNum)
+	a.mx.currentBlock.SetUint64(blockNum)
 }
 
 func (a *Aggregator) SetWorkers(i int) {
@@ -331,7 +492,42 @@ func (a *Aggregator) SetWorkers(i int) {
 	a.logTopics.compressWorkers = i
 	a.tracesFrom.compressWorkers = i
 	a.tracesTo.compressWorkers = i
+	for _, d := range a.extraDomains {
+		d.compressWorkers = i
+	}
+	for _, ii := range a.extraIndices {
+		ii.compressWorkers = i
+	}
+}
+
+// SetStepMeta - write merged .kv files in kv format v2 (step of each value is stored next to it, see Domain.stepMeta).
+// Commitment domain is not affected: its values are transformed on merge.
+// Can be changed for existing datadir: format is recorded in each file, applies to new merged files.
+func (a *Aggregator) SetStepMeta(v bool) {
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code}, a.extraDomains...) {
+		d.stepMeta = v
+	}
+}
+
+// SetCodeByHash - merged files of code domain store code once per codeHash (see Domain.codeByHash).
+// Can be changed for existing datadir: layout is recorded in each file, applies to new merged files.
+func (a *Aggregator) SetCodeByHash(v bool) {
+	a.code.codeByHash = v
+}
+
+// SetValueLogThreshold - values of this size or bigger are stored in .vlog files next to .kv (see value_log.go), 0 - disabled.
+// Commitment domain is not affected. Can be changed for existing datadir: applies to new files only.
+func (a *Aggregator) SetValueLogThreshold(threshold int) {
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code}, a.extraDomains...) {
+		d.valueLogThreshold = threshold
+	}
+}
+
+// SetAccountCodec - encoding of values of accounts domain (chain.Config.AccountCodec). Commitment decodes accounts only via it.
+func (a *Aggregator) SetAccountCodec(c types.AccountCodec) {
+	a.accountCodec = c
 }
+func (a *Aggregator) AccountCodec() types.AccountCodec { return a.accountCodec }
 
 func (a *Aggregator) SetCommitmentMode(mode CommitmentMode) {
 	a.commitment.mode = mode
@@ -348,6 +544,11 @@ func (a *Aggregator) EndTxNumMinimax() uint64 {
 	if txNum := a.commitment.endTxNumMinimax(); txNum < min {
 		min = txNum
 	}
+	for _, d := range a.extraDomains {
+		if txNum := d.endTxNumMinimax(); txNum < min {
+			min = txNum
+		}
+	}
 	if txNum := a.logAddrs.endTxNumMinimax(); txNum < min {
 		min = txNum
 	}
@@ -360,6 +561,11 @@ func (a *Aggregator) EndTxNumMinimax() uint64 {
 	if txNum := a.tracesTo.endTxNumMinimax(); txNum < min {
 		min = txNum
 	}
+	for _, ii := range a.extraIndices {
+		if txNum := ii.endTxNumMinimax(); txNum < min {
+			min = txNum
+		}
+	}
 	return min
 }
 
@@ -374,6 +580,11 @@ func (a *Aggregator) DomainEndTxNumMinimax() uint64 {
 	if txNum := a.commitment.endTxNumMinimax(); txNum < min {
 		min = txNum
 	}
+	for _, d := range a.extraDomains {
+		if txNum := d.endTxNumMinimax(); txNum < min {
+			min = txNum
+		}
+	}
 	return min
 }
 
@@ -390,6 +601,51 @@ func (a *Aggregator) SeekCommitment() (blockNum, txNum uint64, err error) {
 	return blockNum, txNum + 1, nil
 }
 
+// CommitmentHint - what caller (staged sync) expects to find by SeekCommitment: usually derived from stage progress
+type CommitmentHint struct {
+	FromTxNum, ToTxNum uint64 // window of txNum of last stored commitment, inclusive
+	BlockNum           uint64 // optional, only for diagnostics
+}
+
+type CommitmentDivergedError struct {
+	Hint                   CommitmentHint
+	FoundBlock, FoundTxNum uint64
+	FilesTxNum             uint64
+}
+
+func (e *CommitmentDivergedError) Error() string {
+	if e.FoundTxNum == 0 {
+		return fmt.Sprintf("commitment state not found: expected at txNum [%d, %d] (block %d), files end at txNum %d",
+			e.Hint.FromTxNum, e.Hint.ToTxNum, e.Hint.BlockNum, e.FilesTxNum)
+	}
+	return fmt.Sprintf("commitment state diverged from expected: found at txNum %d (block %d), expected at txNum [%d, %d] (block %d), files end at txNum %d",
+		e.FoundTxNum, e.FoundBlock, e.Hint.FromTxNum, e.Hint.ToTxNum, e.Hint.BlockNum, e.FilesTxNum)
+}
+
+// SeekCommitmentWithHint - same as SeekCommitment, but doesn't scan steps before hint.FromTxNum
+// and returns *CommitmentDivergedError if found commitment is out of hint's window (or not found) - instead of
+// silently starting from genesis.
+func (a *Aggregator) SeekCommitmentWithHint(hint CommitmentHint) (blockNum, txNum uint64, err error) {
+	filesTxNum := a.EndTxNumMinimax()
+	sinceTx := filesTxNum
+	if hintTx := (hint.FromTxNum/a.aggregationStep + 1) * a.aggregationStep; hint.FromTxNum > 0 && hintTx > sinceTx {
+		sinceTx = hintTx
+	}
+	blockNum, txNum, err = a.commitment.SeekCommitment(a.aggregationStep, sinceTx)
+	if err != nil {
+		return 0, 0, err
+	}
+	notFound := txNum == 0
+	if (notFound && hint.FromTxNum > 0) || (!notFound && (txNum < hint.FromTxNum || txNum > hint.ToTxNum)) {
+		return 0, 0, &CommitmentDivergedError{Hint: hint, FoundBlock: blockNum, FoundTxNum: txNum, FilesTxNum: filesTxNum}
+	}
+	if notFound {
+		return 0, 0, nil
+	}
+	a.seekTxNum = txNum + 1
+	return blockNum, txNum + 1, nil
+}
+
 func (a *Aggregator) mergeDomainSteps(ctx context.Context) error {
 	mergeStartedAt := time.Now()
 	maxEndTxNum := a.DomainEndTxNumMinimax()
@@ -435,26 +691,41 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 
 	defer logEvery.Stop()
 
-	for _, d := range []*Domain{a.accounts, a.storage, a.code, a.commitment.Domain} {
+	// tombstones of step become deletions of keys they cover: step files get them as any other deletions
+	var resolved int
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code, a.commitment.Domain}, a.extraDomains...) {
+		n, err := d.resolveTombstones(ctx, txFrom, txTo)
+		if err != nil {
+			return err
+		}
+		resolved += n
+	}
+	if resolved > 0 {
+		if err := a.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code, a.commitment.Domain}, a.extraDomains...) {
 		wg.Add(1)
 
-		mxRunningCollations.Inc()
+		a.mx.runningCollations.Inc()
 		start := time.Now()
 		collation, err := d.collateStream(ctx, step, txFrom, txTo, d.tx)
-		mxRunningCollations.Dec()
-		mxCollateTook.ObserveDuration(start)
-
-		//mxCollationSize.Set(uint64(collation.valuesComp.Count()))
-		mxCollationSizeHist.SetInt(collation.historyComp.Count())
+		a.mx.runningCollations.Dec()
+		a.mx.collateTook.ObserveDuration(start)
 
 		if err != nil {
 			collation.Close()
 			return fmt.Errorf("domain collation %q has failed: %w", d.filenameBase, err)
 		}
 
+		//a.mx.collationSize.Set(uint64(collation.valuesComp.Count()))
+		a.mx.collationSizeHist.SetInt(collation.historyComp.Count())
+
 		go func(wg *sync.WaitGroup, d *Domain, collation Collation) {
 			defer wg.Done()
-			mxRunningMerges.Inc()
+			a.mx.runningMerges.Inc()
 
 			start := time.Now()
 			sf, err := d.buildFiles(ctx, step, collation, a.ps)
@@ -464,25 +735,34 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 				errCh <- err
 
 				sf.Close()
-				mxRunningMerges.Dec()
+				a.mx.runningMerges.Dec()
 				return
 			}
 
-			mxRunningMerges.Dec()
+			a.mx.runningMerges.Dec()
 
 			d.integrateFiles(sf, step*a.aggregationStep, (step+1)*a.aggregationStep)
 			d.stats.LastFileBuildingTook = time.Since(start)
 		}(&wg, d, collation)
-
-		mxPruningProgress.Add(2) // domain and history
+	}
+	// prune only after all collations: domains of storage contracts share DB tables with storage domain
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code, a.commitment.Domain}, a.extraDomains...) {
+		if d.tablesOwner != nil {
+			continue
+		}
+		a.mx.pruningProgress.Add(2) // domain and history
 		if err := d.prune(ctx, step, txFrom, txTo, math.MaxUint64, logEvery); err != nil {
 			return err
 		}
-		mxPruningProgress.Dec()
-		mxPruningProgress.Dec()
+		a.mx.pruningProgress.Dec()
+		a.mx.pruningProgress.Dec()
 
-		mxPruneTook.Observe(d.stats.LastPruneTook.Seconds())
-		mxPruneHistTook.Observe(d.stats.LastPruneHistTook.Seconds())
+		a.mx.pruneTook.Observe(d.stats.LastPruneTook.Seconds())
+		a.mx.pruneHistTook.Observe(d.stats.LastPruneHistTook.Seconds())
+	}
+	// states of steps before pruned one are not needed to restart commitment (see SeekCommitment)
+	if err := a.commitment.PruneStates(ctx, txFrom); err != nil {
+		return fmt.Errorf("prune commitment states: %w", err)
 	}
 
 	// when domain files are build and db is pruned, we can merge them
@@ -496,14 +776,14 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 	}(&wg)
 
 	// indices are built concurrently
-	for _, d := range []*InvertedIndex{a.logTopics, a.logAddrs, a.tracesFrom, a.tracesTo} {
+	for _, d := range append([]*InvertedIndex{a.logTopics, a.logAddrs, a.tracesFrom, a.tracesTo}, a.extraIndices...) {
 		wg.Add(1)
 
-		mxRunningCollations.Inc()
+		a.mx.runningCollations.Inc()
 		start := time.Now()
 		collation, err := d.collate(ctx, step*a.aggregationStep, (step+1)*a.aggregationStep, d.tx)
-		mxRunningCollations.Dec()
-		mxCollateTook.ObserveDuration(start)
+		a.mx.runningCollations.Dec()
+		a.mx.collateTook.ObserveDuration(start)
 
 		if err != nil {
 			return fmt.Errorf("index collation %q has failed: %w", d.filenameBase, err)
@@ -512,7 +792,7 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 		go func(wg *sync.WaitGroup, d *InvertedIndex, tx kv.Tx) {
 			defer wg.Done()
 
-			mxRunningMerges.Inc()
+			a.mx.runningMerges.Inc()
 			start := time.Now()
 
 			sf, err := d.buildFiles(ctx, step, collation, a.ps)
@@ -522,33 +802,33 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 				return
 			}
 
-			mxRunningMerges.Dec()
-			mxBuildTook.ObserveDuration(start)
+			a.mx.runningMerges.Dec()
+			a.mx.buildTook.ObserveDuration(start)
 
 			d.integrateFiles(sf, step*a.aggregationStep, (step+1)*a.aggregationStep)
 
 			icx := d.MakeContext()
-			mxRunningMerges.Inc()
+			a.mx.runningMerges.Inc()
 
 			if err := d.mergeRangesUpTo(ctx, d.endTxNumMinimax(), maxSpan, workers, icx, a.ps); err != nil {
 				errCh <- err
 
-				mxRunningMerges.Dec()
+				a.mx.runningMerges.Dec()
 				icx.Close()
 				return
 			}
 
-			mxRunningMerges.Dec()
+			a.mx.runningMerges.Dec()
 			icx.Close()
 		}(&wg, d, d.tx)
 
-		mxPruningProgress.Inc()
+		a.mx.pruningProgress.Inc()
 		startPrune := time.Now()
 		if err := d.prune(ctx, txFrom, txTo, math.MaxUint64, logEvery); err != nil {
 			return err
 		}
-		mxPruneTook.ObserveDuration(startPrune)
-		mxPruningProgress.Dec()
+		a.mx.pruneTook.ObserveDuration(startPrune)
+		a.mx.pruningProgress.Dec()
 	}
 
 	go func() {
@@ -565,7 +845,7 @@ func (a *Aggregator) aggregate(ctx context.Context, step uint64) error {
 		"range", fmt.Sprintf("%.2fM-%.2fM", float64(txFrom)/10e5, float64(txTo)/10e5),
 		"took", time.Since(stepStartedAt))
 
-	mxStepTook.ObserveDuration(stepStartedAt)
+	a.mx.stepTook.ObserveDuration(stepStartedAt)
 
 	return nil
 }
@@ -601,7 +881,7 @@ func (a *Aggregator) mergeLoopStep(ctx context.Context, maxEndTxNum uint64, work
 	closeAll = false
 
 	for _, s := range []DomainStats{a.accounts.stats, a.code.stats, a.storage.stats} {
-		mxBuildTook.Observe(s.LastFileBuildingTook.Seconds())
+		a.mx.buildTook.Observe(s.LastFileBuildingTook.Seconds())
 	}
 
 	a.logger.Info("[stat] finished merge step",
@@ -615,6 +895,7 @@ type Ranges struct {
 	storage    DomainRanges
 	code       DomainRanges
 	commitment DomainRanges
+	extra      []DomainRanges // same order as Aggregator.extraDomains
 }
 
 func (r Ranges) String() string {
@@ -622,7 +903,15 @@ func (r Ranges) String() string {
 }
 
 func (r Ranges) any() bool {
-	return r.accounts.any() || r.storage.any() || r.code.any() || r.commitment.any()
+	if r.accounts.any() || r.storage.any() || r.code.any() || r.commitment.any() {
+		return true
+	}
+	for _, er := range r.extra {
+		if er.any() {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *Aggregator) findMergeRange(maxEndTxNum, maxSpan uint64) Ranges {
@@ -631,6 +920,10 @@ func (a *Aggregator) findMergeRange(maxEndTxNum, maxSpan uint64) Ranges {
 	r.storage = a.storage.findMergeRange(maxEndTxNum, maxSpan)
 	r.code = a.code.findMergeRange(maxEndTxNum, maxSpan)
 	r.commitment = a.commitment.findMergeRange(maxEndTxNum, maxSpan)
+	r.extra = make([]DomainRanges, len(a.extraDomains))
+	for i, d := range a.extraDomains {
+		r.extra[i] = d.findMergeRange(maxEndTxNum, maxSpan)
+	}
 	//if r.any() {
 	//log.Info(fmt.Sprintf("findMergeRange(%d, %d)=%+v\n", maxEndTxNum, maxSpan, r))
 	//}
@@ -654,15 +947,25 @@ type SelectedStaticFiles struct {
 	storageI       int
 	accountsI      int
 	commitmentI    int
+	extra          []domainStaticFiles // same order as Aggregator.extraDomains
+}
+
+type domainStaticFiles struct {
+	values, idx, hist []*filesItem
+	i                 int
 }
 
 func (sf SelectedStaticFiles) Close() {
-	for _, group := range [][]*filesItem{
+	groups := [][]*filesItem{
 		sf.accounts, sf.accountsIdx, sf.accountsHist,
 		sf.storage, sf.storageIdx, sf.storageHist,
 		sf.code, sf.codeIdx, sf.codeHist,
 		sf.commitment, sf.commitmentIdx, sf.commitmentHist,
-	} {
+	}
+	for _, e := range sf.extra {
+		groups = append(groups, e.values, e.idx, e.hist)
+	}
+	for _, group := range groups {
 		for _, item := range group {
 			if item != nil {
 				if item.decompressor != nil {
@@ -674,6 +977,7 @@ func (sf SelectedStaticFiles) Close() {
 				if item.bindex != nil {
 					item.bindex.Close()
 				}
+				item.vlog.Close()
 			}
 		}
 	}
@@ -693,6 +997,13 @@ func (a *Aggregator) staticFilesInRange(r Ranges, ac *AggregatorContext) Selecte
 	if r.commitment.any() {
 		sf.commitment, sf.commitmentIdx, sf.commitmentHist, sf.commitmentI = ac.commitment.staticFilesInRange(r.commitment)
 	}
+	sf.extra = make([]domainStaticFiles, len(r.extra))
+	for i, er := range r.extra {
+		if er.any() {
+			e := &sf.extra[i]
+			e.values, e.idx, e.hist, e.i = ac.extraDomains[i].staticFilesInRange(er)
+		}
+	}
 	return sf
 }
 
@@ -705,16 +1016,25 @@ type MergedFiles struct {
 	codeIdx, codeHist             *filesItem
 	commitment                    *filesItem
 	commitmentIdx, commitmentHist *filesItem
+	extra                         []domainMergedFiles // same order as Aggregator.extraDomains
+}
+
+type domainMergedFiles struct {
+	values, idx, hist *filesItem
 }
 
 func (mf MergedFiles) Close() {
-	for _, item := range []*filesItem{
+	items := []*filesItem{
 		mf.accounts, mf.accountsIdx, mf.accountsHist,
 		mf.storage, mf.storageIdx, mf.storageHist,
 		mf.code, mf.codeIdx, mf.codeHist,
 		mf.commitment, mf.commitmentIdx, mf.commitmentHist,
 		//mf.logAddrs, mf.logTopics, mf.tracesFrom, mf.tracesTo,
-	} {
+	}
+	for _, e := range mf.extra {
+		items = append(items, e.values, e.idx, e.hist)
+	}
+	for _, item := range items {
 		if item != nil {
 			if item.decompressor != nil {
 				item.decompressor.Close()
@@ -725,6 +1045,7 @@ func (mf MergedFiles) Close() {
 			if item.bindex != nil {
 				item.bindex.Close()
 			}
+			item.vlog.Close()
 		}
 	}
 }
@@ -746,17 +1067,34 @@ func (a *Aggregator) mergeFiles(ctx context.Context, files SelectedStaticFiles,
 	}()
 
 	var (
-		errCh      = make(chan error, 4)
+		errCh      = make(chan error, 4+len(r.extra))
 		wg         sync.WaitGroup
 		predicates sync.WaitGroup
 	)
 
-	wg.Add(4)
+	wg.Add(4 + len(r.extra))
 	predicates.Add(2)
 
+	mf.extra = make([]domainMergedFiles, len(r.extra))
+	for i := range r.extra {
+		go func(i int) {
+			a.mx.runningMerges.Inc()
+			defer a.mx.runningMerges.Dec()
+			defer wg.Done()
+
+			var err error
+			if r.extra[i].any() {
+				e, f := &mf.extra[i], files.extra[i]
+				if e.values, e.idx, e.hist, err = a.extraDomains[i].mergeFiles(ctx, f.values, f.idx, f.hist, r.extra[i], workers, a.ps); err != nil {
+					errCh <- err
+				}
+			}
+		}(i)
+	}
+
 	go func() {
-		mxRunningMerges.Inc()
-		defer mxRunningMerges.Dec()
+		a.mx.runningMerges.Inc()
+		defer a.mx.runningMerges.Dec()
 		defer wg.Done()
 
 		var err error
@@ -768,8 +1106,8 @@ func (a *Aggregator) mergeFiles(ctx context.Context, files SelectedStaticFiles,
 	}()
 
 	go func(predicates *sync.WaitGroup) {
-		mxRunningMerges.Inc()
-		defer mxRunningMerges.Dec()
+		a.mx.runningMerges.Inc()
+		defer a.mx.runningMerges.Dec()
 
 		defer wg.Done()
 		defer predicates.Done()
@@ -781,8 +1119,8 @@ func (a *Aggregator) mergeFiles(ctx context.Context, files SelectedStaticFiles,
 		}
 	}(&predicates)
 	go func(predicates *sync.WaitGroup) {
-		mxRunningMerges.Inc()
-		defer mxRunningMerges.Dec()
+		a.mx.runningMerges.Inc()
+		defer a.mx.runningMerges.Dec()
 
 		defer wg.Done()
 		defer predicates.Done()
@@ -798,8 +1136,8 @@ func (a *Aggregator) mergeFiles(ctx context.Context, files SelectedStaticFiles,
 		defer wg.Done()
 		predicates.Wait()
 
-		mxRunningMerges.Inc()
-		defer mxRunningMerges.Dec()
+		a.mx.runningMerges.Inc()
+		defer a.mx.runningMerges.Dec()
 
 		var err error
 		// requires storage|accounts to be merged at this point
@@ -830,22 +1168,41 @@ func (a *Aggregator) integrateMergedFiles(outs SelectedStaticFiles, in MergedFil
 	a.storage.integrateMergedFiles(outs.storage, outs.storageIdx, outs.storageHist, in.storage, in.storageIdx, in.storageHist)
 	a.code.integrateMergedFiles(outs.code, outs.codeIdx, outs.codeHist, in.code, in.codeIdx, in.codeHist)
 	a.commitment.integrateMergedFiles(outs.commitment, outs.commitmentIdx, outs.commitmentHist, in.commitment, in.commitmentIdx, in.commitmentHist)
+	for i, d := range a.extraDomains {
+		o, n := outs.extra[i], in.extra[i]
+		d.integrateMergedFiles(o.values, o.idx, o.hist, n.values, n.idx, n.hist)
+	}
 }
 
 func (a *Aggregator) cleanAfterNewFreeze(in MergedFiles) {
-	a.accounts.cleanAfterFreeze(in.accountsHist.endTxNum)
-	a.storage.cleanAfterFreeze(in.storageHist.endTxNum)
-	a.code.cleanAfterFreeze(in.codeHist.endTxNum)
-	a.commitment.cleanAfterFreeze(in.commitment.endTxNum)
+	if in.accountsHist != nil && in.accountsHist.frozen {
+		a.accounts.cleanAfterFreeze(in.accountsHist.endTxNum)
+	}
+	if in.storageHist != nil && in.storageHist.frozen {
+		a.storage.cleanAfterFreeze(in.storageHist.endTxNum)
+	}
+	if in.codeHist != nil && in.codeHist.frozen {
+		a.code.cleanAfterFreeze(in.codeHist.endTxNum)
+	}
+	if in.commitment != nil && in.commitment.frozen {
+		a.commitment.cleanAfterFreeze(in.commitment.endTxNum)
+	}
+	for i, d := range a.extraDomains {
+		if in.extra[i].hist != nil && in.extra[i].hist.frozen {
+			d.cleanAfterFreeze(in.extra[i].hist.endTxNum)
+		}
+	}
 }
 
 // ComputeCommitment evaluates commitment for processed state.
 // If `saveStateAfter`=true, then trie state will be saved to DB after commitment evaluation.
 func (a *Aggregator) ComputeCommitment(saveStateAfter, trace bool) (rootHash []byte, err error) {
+	_, span := startSpan(context.Background(), "compute_commitment", a.commitment.filenameBase, a.txNum, a.txNum, a.aggregationStep)
+	defer span.End()
 	// if commitment mode is Disabled, there will be nothing to compute on.
-	mxCommitmentRunning.Inc()
+	a.mx.commitmentRunning.Inc()
 	rootHash, branchNodeUpdates, err := a.commitment.ComputeCommitment(trace)
-	mxCommitmentRunning.Dec()
+	a.mx.commitmentRunning.Dec()
 
 	if err != nil {
 		return nil, err
@@ -854,10 +1211,10 @@ func (a *Aggregator) ComputeCommitment(saveStateAfter, trace bool) (rootHash []b
 		saveStateAfter = false
 	}
 
-	mxCommitmentKeys.AddUint64(a.commitment.comKeys)
-	mxCommitmentTook.Observe(a.commitment.comTook.Seconds())
+	a.mx.commitmentKeys.AddUint64(a.commitment.comKeys)
+	a.mx.commitmentTook.Observe(a.commitment.comTook.Seconds())
 
-	defer func(t time.Time) { mxCommitmentWriteTook.ObserveDuration(t) }(time.Now())
+	defer func(t time.Time) { a.mx.commitmentWriteTook.ObserveDuration(t) }(time.Now())
 
 	for pref, update := range branchNodeUpdates {
 		prefix := []byte(pref)
@@ -866,7 +1223,7 @@ func (a *Aggregator) ComputeCommitment(saveStateAfter, trace bool) (rootHash []b
 		if err != nil {
 			return nil, err
 		}
-		mxCommitmentUpdates.Inc()
+		a.mx.commitmentUpdates.Inc()
 		stated := commitment.BranchData(stateValue)
 		merged, err := a.commitment.branchMerger.Merge(stated, update)
 		if err != nil {
@@ -875,17 +1232,18 @@ func (a *Aggregator) ComputeCommitment(saveStateAfter, trace bool) (rootHash []b
 		if bytes.Equal(stated, merged) {
 			continue
 		}
-		if trace {
-			fmt.Printf("computeCommitment merge [%x] [%x]+[%x]=>[%x]\n", prefix, stated, update, merged)
+		if trace || domainDebug(a.commitment.filenameBase) {
+			a.logger.Debug("[dbg] computeCommitment merge", "prefix", fmt.Sprintf("%x", prefix),
+				"stated", fmt.Sprintf("%x", stated), "update", fmt.Sprintf("%x", update), "merged", fmt.Sprintf("%x", merged))
 		}
 		if err = a.UpdateCommitmentData(prefix, merged); err != nil {
 			return nil, err
 		}
-		mxCommitmentUpdatesApplied.Inc()
+		a.mx.commitmentUpdatesApplied.Inc()
 	}
 
 	if saveStateAfter {
-		if err := a.commitment.storeCommitmentState(a.blockNum, a.txNum); err != nil {
+		if err := a.commitment.StoreState(a.blockNum, a.txNum); err != nil {
 			return nil, err
 		}
 	}
@@ -917,8 +1275,8 @@ func (a *Aggregator) FinishTx() (err error) {
 		return nil
 	}
 
-	mxRunningMerges.Inc()
-	defer mxRunningMerges.Dec()
+	a.mx.runningMerges.Inc()
+	defer a.mx.runningMerges.Dec()
 
 	a.commitment.patriciaTrie.ResetFns(a.defaultCtx.branchFn, a.defaultCtx.accountFn, a.defaultCtx.storageFn)
 	rootHash, err := a.ComputeCommitment(true, false)
@@ -926,7 +1284,7 @@ func (a *Aggregator) FinishTx() (err error) {
 		return err
 	}
 	step := a.txNum / a.aggregationStep
-	mxStepCurrent.SetUint64(step)
+	a.mx.stepCurrent.SetUint64(step)
 
 	if step == 0 {
 		a.notifyAggregated(rootHash)
@@ -973,8 +1331,11 @@ func (a *Aggregator) DeleteAccount(addr []byte) error {
 	if err := a.code.Delete(addr, nil); err != nil {
 		return err
 	}
+	if a.storage.tombstonePrefixLen > 0 { // O(1) for any amount of slots, see dbg.LazySelfDestruct
+		return a.storage.DeletePrefix(addr)
+	}
 	var e error
-	if err := a.storage.defaultDc.IteratePrefix(addr, func(k, _ []byte) {
+	if err := a.storageOf(addr).defaultDc.IteratePrefix(addr, func(k, _ []byte) {
 		a.commitment.TouchPlainKey(k, nil, a.commitment.TouchPlainKeyStorage)
 		if e == nil {
 			e = a.storage.Delete(k, nil)
@@ -1023,6 +1384,12 @@ func (a *Aggregator) StartWrites() *Aggregator {
 	a.logTopics.StartWrites()
 	a.tracesFrom.StartWrites()
 	a.tracesTo.StartWrites()
+	for _, d := range a.extraDomains {
+		d.StartWrites()
+	}
+	for _, ii := range a.extraIndices {
+		ii.StartWrites()
+	}
 
 	if a.defaultCtx != nil {
 		a.defaultCtx.Close()
@@ -1038,6 +1405,12 @@ func (a *Aggregator) StartWrites() *Aggregator {
 		tracesFrom: a.tracesFrom.MakeContext(),
 		tracesTo:   a.tracesTo.MakeContext(),
 	}
+	for _, d := range a.extraDomains {
+		a.defaultCtx.extraDomains = append(a.defaultCtx.extraDomains, d.defaultDc)
+	}
+	for _, ii := range a.extraIndices {
+		a.defaultCtx.extraIndices = append(a.defaultCtx.extraIndices, ii.MakeContext())
+	}
 	a.commitment.patriciaTrie.ResetFns(a.defaultCtx.branchFn, a.defaultCtx.accountFn, a.defaultCtx.storageFn)
 	return a
 }
@@ -1051,6 +1424,12 @@ func (a *Aggregator) FinishWrites() {
 	a.logTopics.FinishWrites()
 	a.tracesFrom.FinishWrites()
 	a.tracesTo.FinishWrites()
+	for _, d := range a.extraDomains {
+		d.FinishWrites()
+	}
+	for _, ii := range a.extraIndices {
+		ii.FinishWrites()
+	}
 }
 
 // Flush - must be called before Collate, if you did some writes
@@ -1065,6 +1444,12 @@ func (a *Aggregator) Flush(ctx context.Context) error {
 		a.tracesFrom.Rotate(),
 		a.tracesTo.Rotate(),
 	}
+	for _, d := range a.extraDomains {
+		flushers = append(flushers, d.Rotate())
+	}
+	for _, ii := range a.extraIndices {
+		flushers = append(flushers, ii.Rotate())
+	}
 	defer func(t time.Time) { a.logger.Debug("[snapshots] history flush", "took", time.Since(t)) }(time.Now())
 	for _, f := range flushers {
 		if err := f.Flush(ctx, a.rwTx); err != nil {
@@ -1107,10 +1492,13 @@ type AggregatorContext struct {
 	tracesFrom *InvertedIndexContext
 	tracesTo   *InvertedIndexContext
 	keyBuf     []byte
+
+	extraDomains []*DomainContext        // same order as Aggregator.extraDomains
+	extraIndices []*InvertedIndexContext // same order as Aggregator.extraIndices
 }
 
 func (a *Aggregator) MakeContext() *AggregatorContext {
-	return &AggregatorContext{
+	ac := &AggregatorContext{
 		a:          a,
 		accounts:   a.accounts.MakeContext(),
 		storage:    a.storage.MakeContext(),
@@ -1121,19 +1509,36 @@ func (a *Aggregator) MakeContext() *AggregatorContext {
 		tracesFrom: a.tracesFrom.MakeContext(),
 		tracesTo:   a.tracesTo.MakeContext(),
 	}
+	for _, d := range a.extraDomains {
+		ac.extraDomains = append(ac.extraDomains, d.MakeContext())
+	}
+	for _, ii := range a.extraIndices {
+		ac.extraIndices = append(ac.extraIndices, ii.MakeContext())
+	}
+	return ac
 }
 
 func (ac *AggregatorContext) ReadAccountData(addr []byte, roTx kv.Tx) ([]byte, error) {
 	return ac.accounts.Get(addr, nil, roTx)
 }
 
+// ReadAccountDataWithStep - ReadAccountData and step in which value was written, see DomainContext.GetWithStep
+func (ac *AggregatorContext) ReadAccountDataWithStep(addr []byte, roTx kv.Tx) ([]byte, uint64, error) {
+	return ac.accounts.GetWithStep(addr, nil, roTx)
+}
+
 func (ac *AggregatorContext) ReadAccountDataBeforeTxNum(addr []byte, txNum uint64, roTx kv.Tx) ([]byte, error) {
 	v, err := ac.accounts.GetBeforeTxNum(addr, txNum, roTx)
 	return v, err
 }
 
 func (ac *AggregatorContext) ReadAccountStorage(addr []byte, loc []byte, roTx kv.Tx) ([]byte, error) {
-	return ac.storage.Get(addr, loc, roTx)
+	return ac.storageOf(addr).Get(addr, loc, roTx)
+}
+
+// ReadAccountStorageWithStep - ReadAccountStorage and step in which value was written, see DomainContext.GetWithStep
+func (ac *AggregatorContext) ReadAccountStorageWithStep(addr []byte, loc []byte, roTx kv.Tx) ([]byte, uint64, error) {
+	return ac.storageOf(addr).GetWithStep(addr, loc, roTx)
 }
 
 func (ac *AggregatorContext) ReadAccountStorageBeforeTxNum(addr []byte, loc []byte, txNum uint64, roTx kv.Tx) ([]byte, error) {
@@ -1144,7 +1549,7 @@ func (ac *AggregatorContext) ReadAccountStorageBeforeTxNum(addr []byte, loc []by
 	}
 	copy(ac.keyBuf, addr)
 	copy(ac.keyBuf[len(addr):], loc)
-	v, err := ac.storage.GetBeforeTxNum(ac.keyBuf, txNum, roTx)
+	v, err := ac.storageOf(addr).GetBeforeTxNum(ac.keyBuf, txNum, roTx)
 	return v, err
 }
 
@@ -1174,6 +1579,8 @@ func (ac *AggregatorContext) ReadAccountCodeSize(addr []byte, roTx kv.Tx) (int,
 	return len(code), nil
 }
 
+func (ac *AggregatorContext) AccountCodec() types.AccountCodec { return ac.a.accountCodec }
+
 func (ac *AggregatorContext) ReadAccountCodeSizeBeforeTxNum(addr []byte, txNum uint64, roTx kv.Tx) (int, error) {
 	code, err := ac.code.GetBeforeTxNum(addr, txNum, roTx)
 	if err != nil {
@@ -1204,9 +1611,11 @@ func (ac *AggregatorContext) accountFn(plainKey []byte, cell *commitment.Cell) e
 	cell.Balance.Clear()
 	copy(cell.CodeHash[:], commitment.EmptyCodeHash)
 	if len(encAccount) > 0 {
-		nonce, balance, chash := DecodeAccountBytes(encAccount)
+		nonce, chash, _, err := ac.a.accountCodec.Decode(encAccount, &cell.Balance)
+		if err != nil {
+			return fmt.Errorf("accountFn %x: %w", plainKey, err)
+		}
 		cell.Nonce = nonce
-		cell.Balance.Set(balance)
 		if chash != nil {
 			copy(cell.CodeHash[:], chash)
 		}
@@ -1262,6 +1671,12 @@ func (ac *AggregatorContext) Close() {
 	ac.logTopics.Close()
 	ac.tracesFrom.Close()
 	ac.tracesTo.Close()
+	for _, dc := range ac.extraDomains {
+		dc.Close()
+	}
+	for _, ic := range ac.extraIndices {
+		ic.Close()
+	}
 }
 
 func DecodeAccountBytes(enc []byte) (nonce uint64, balance *uint256.Int, hash []byte) {
@@ -2,6 +2,7 @@ package state
 
 import (
 	"bytes"
+	"container/heap"
 	"context"
 	"fmt"
 	"math/rand"
@@ -252,3 +253,45 @@ func Benchmark_Recsplit_Find_ExternalFile(b *testing.B) {
 		require.EqualValues(b, keys[p], key)
 	}
 }
+
+func BenchmarkKvList(b *testing.B) {
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", i)
+	}
+	fill := func(l *KvList) {
+		for _, k := range keys {
+			l.Push(k, []byte(k))
+		}
+	}
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fill(&KvList{})
+		}
+	})
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		l := &KvList{}
+		for i := 0; i < b.N; i++ {
+			fill(l)
+			l.Reset()
+		}
+	})
+}
+
+func BenchmarkCursorHeap(b *testing.B) {
+	b.ReportAllocs()
+	var cp CursorHeap
+	for i := 0; i < b.N; i++ {
+		heap.Init(&cp)
+		for j := 0; j < 16; j++ {
+			ci := newCursorItem()
+			ci.key, ci.endTxNum = []byte{byte(j)}, uint64(j)
+			heap.Push(&cp, ci)
+		}
+		for cp.Len() > 0 {
+			putCursorItem(heap.Pop(&cp).(*CursorItem))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+//go:build chaos
+
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+// Crash-consistency harness: `go test -tags chaos -run Chaos ./state/`
+// For every chaosPoint injects:
+//   - error: write/fsync of files failed - operation returns error, aggregator keeps working
+//   - kill: `kill -9` of process (child process exits in hook, without any defer)
+//   - partial_write: `kill -9` while next file is written - leaves truncated .tmp files
+//
+// then re-opens datadir and checks invariants of recovery: OpenFolder succeeds, visible files are contiguous,
+// consistent and have accessor indices; build and merge of all steps can be finished after restart.
+
+const (
+	chaosSteps        = 4
+	chaosStepSize     = 16
+	chaosKillExitCode = 42
+
+	chaosEnvDir   = "CHAOS_DIR"
+	chaosEnvPoint = "CHAOS_POINT"
+	chaosEnvFault = "CHAOS_FAULT"
+)
+
+var errChaos = errors.New("chaos: injected fault")
+
+var chaosPoints = []chaosPoint{chaosBuildFiles, chaosIntegrateFiles, chaosMergeFiles, chaosIntegrateMergedFiles}
+
+func TestAggregatorV3_Chaos(t *testing.T) {
+	for _, p := range chaosPoints {
+		p := p
+		t.Run(string(p)+"/error", func(t *testing.T) {
+			dir := t.TempDir()
+			fillChaosData(t, dir)
+			db, agg := openChaosAgg(t, dir)
+			defer db.Close()
+			defer agg.Close()
+			require.NoError(t, agg.OpenFolder())
+
+			var hit bool
+			setChaosHook(func(at chaosPoint) error {
+				if at != p || hit {
+					return nil
+				}
+				hit = true
+				return errChaos
+			})
+			err := chaosWorkload(context.Background(), agg)
+			setChaosHook(nil)
+			require.ErrorIs(t, err, errChaos)
+
+			checkChaosRecovery(t, agg)
+		})
+		for _, fault := range []string{"kill", "partial_write"} {
+			fault := fault
+			t.Run(string(p)+"/"+fault, func(t *testing.T) {
+				dir := t.TempDir()
+				fillChaosData(t, dir)
+
+				cmd := exec.Command(os.Args[0], "-test.run=^TestAggregatorV3_ChaosChild$")
+				cmd.Env = append(os.Environ(), chaosEnvDir+"="+dir, chaosEnvPoint+"="+string(p), chaosEnvFault+"="+fault)
+				out, err := cmd.CombinedOutput()
+				var exitErr *exec.ExitError
+				require.True(t, errors.As(err, &exitErr), "child was not killed: %v, %s", err, out)
+				require.Equal(t, chaosKillExitCode, exitErr.ExitCode(), "%s", out)
+
+				db, agg := openChaosAgg(t, dir)
+				defer db.Close()
+				defer agg.Close()
+				checkChaosRecovery(t, agg)
+			})
+		}
+	}
+}
+
+// TestAggregatorV3_ChaosChild - process which is killed by TestAggregatorV3_Chaos
+func TestAggregatorV3_ChaosChild(t *testing.T) {
+	dir := os.Getenv(chaosEnvDir)
+	if dir == "" {
+		t.Skip("started by TestAggregatorV3_Chaos")
+	}
+	p, fault := chaosPoint(os.Getenv(chaosEnvPoint)), os.Getenv(chaosEnvFault)
+	db, agg := openChaosAgg(t, dir)
+	require.NoError(t, agg.OpenFolder())
+	setChaosHook(func(at chaosPoint) error {
+		if at != p {
+			return nil
+		}
+		if fault == "partial_write" {
+			writeTruncatedTmpFiles(t, agg.dir)
+		}
+		os.Exit(chaosKillExitCode)
+		return nil
+	})
+	require.NoError(t, chaosWorkload(context.Background(), agg))
+	agg.Close()
+	db.Close()
+}
+
+func openChaosAgg(t *testing.T, dir string) (kv.RwDB, *AggregatorV3) {
+	t.Helper()
+	logger := log.New()
+	db := mdbx.NewMDBX(logger).Path(filepath.Join(dir, "db")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return kv.ChaindataTablesCfg
+	}).MustOpen()
+	snapDir, tmpdir := filepath.Join(dir, "e4"), filepath.Join(dir, "e4tmp")
+	require.NoError(t, os.MkdirAll(snapDir, 0740))
+	require.NoError(t, os.MkdirAll(tmpdir, 0740))
+	agg, err := NewAggregatorV3(context.Background(), snapDir, tmpdir, chaosStepSize, db, logger)
+	require.NoError(t, err)
+	return db, agg
+}
+
+func fillChaosData(t *testing.T, dir string) {
+	t.Helper()
+	db, agg := openChaosAgg(t, dir)
+	defer db.Close()
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var key [32]byte
+	for txNum := uint64(0); txNum < chaosSteps*chaosStepSize; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(key[:], txNum%7)
+		require.NoError(t, agg.AddAccountPrev(key[:20], key[:8]))
+		require.NoError(t, agg.AddStoragePrev(key[:20], key[:], key[:8]))
+		require.NoError(t, agg.AddCodePrev(key[:20], key[:]))
+		require.NoError(t, agg.PutIdx(kv.TblLogAddressIdx, key[:20]))
+		require.NoError(t, agg.PutIdx(kv.LogTopicIndex, key[:]))
+		require.NoError(t, agg.PutIdx(kv.TblTracesFromIdx, key[:20]))
+		require.NoError(t, agg.PutIdx(kv.TblTracesToIdx, key[:20]))
+		binary.BigEndian.PutUint64(key[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, key[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+}
+
+// chaosWorkload - build files of all steps which are not in files yet, then merge them. Can be re-started after any fault.
+func chaosWorkload(ctx context.Context, agg *AggregatorV3) error {
+	for step := agg.EndTxNumMinimax() / agg.aggregationStep; step < chaosSteps; step++ {
+		if err := agg.buildFilesInBackground(ctx, step); err != nil {
+			return err
+		}
+	}
+	return agg.MergeLoop(ctx, 1)
+}
+
+// writeTruncatedTmpFiles - same garbage as process killed during write of next file: compressor and index builders
+// write to .tmp file and rename it when file is ready
+func writeTruncatedTmpFiles(t *testing.T, dir string) {
+	for _, pattern := range []string{"*.v", "*.vi", "*.ef", "*.efi"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		require.NoError(t, err)
+		for _, fPath := range matches {
+			data, err := os.ReadFile(fPath)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(fPath+".tmp", data[:len(data)/2], 0644))
+		}
+	}
+}
+
+func checkChaosRecovery(t *testing.T, agg *AggregatorV3) {
+	t.Helper()
+	ctx := context.Background()
+	check := func() {
+		t.Helper()
+		require.NoError(t, agg.OpenFolder())
+		require.NoError(t, agg.BuildMissedIndices(ctx, 1))
+
+		ac := agg.MakeContext()
+		defer ac.Close()
+		components := [][]ctxItem{
+			ac.accounts.files, ac.accounts.ic.files, ac.storage.files, ac.storage.ic.files, ac.code.files, ac.code.ic.files,
+			ac.logAddrs.files, ac.logTopics.files, ac.tracesFrom.files, ac.tracesTo.files, ac.txLookup.files,
+		}
+		for _, files := range components {
+			var endTxNum uint64
+			for _, item := range files {
+				require.Equal(t, endTxNum, item.startTxNum, "gap or overlap before %s", item.src.fileNames())
+				require.NotNil(t, item.src.index, "no accessor index: %s", item.src.fileNames())
+				endTxNum = item.endTxNum
+			}
+		}
+		reports, err := agg.IntegrityCheck(ctx, 0, chaosSteps, 1)
+		require.NoError(t, err)
+		for _, r := range reports {
+			require.Empty(t, r.Errs, r.Files)
+		}
+	}
+
+	check()
+	require.NoError(t, chaosWorkload(ctx, agg))
+	check()
+	require.Equal(t, uint64(chaosSteps*chaosStepSize), agg.EndTxNumMinimax())
+}
@@ -0,0 +1,255 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+// DomainCfg - chain-specific domain (for forks/L2s). Tables must be present in TableCfg of DB.
+type DomainCfg struct {
+	Name string // base of file names, must be unique
+
+	KeysTable, ValsTable               string
+	HistoryKeysTable, HistoryValsTable string
+	IdxTable                           string
+
+	CompressVals, LargeValues bool
+}
+
+// RegisterDomain - adds domain which is collated, built, merged and pruned together with built-in ones.
+// Must be called right after NewAggregator: before ReopenFolder, StartWrites and MakeContext.
+func (a *Aggregator) RegisterDomain(cfg DomainCfg) error {
+	if a.nameIsTaken(cfg.Name) {
+		return fmt.Errorf("RegisterDomain: name %q is already used", cfg.Name)
+	}
+	d, err := NewDomain(a.dir, a.tmpdir, a.aggregationStep, cfg.Name, cfg.KeysTable, cfg.ValsTable, cfg.HistoryKeysTable, cfg.HistoryValsTable, cfg.IdxTable, cfg.CompressVals, cfg.LargeValues, a.logger)
+	if err != nil {
+		return err
+	}
+	d.metricsLabel, d.mx = a.accounts.metricsLabel, a.mx
+	a.extraDomains = append(a.extraDomains, d)
+	return nil
+}
+
+// AddStorageContract - storage of contract `addr` is stored in dedicated files with own indices: merges of them
+// don't rewrite files of other contracts and vice versa. For few contracts which own large part of storage domain.
+// DB tables are shared with storage domain: only it writes and prunes them.
+// Must be called right after NewAggregator (same as RegisterDomain) and never changed for datadir:
+// files of storage domain built before have keys of all contracts.
+func (a *Aggregator) AddStorageContract(addr []byte) error {
+	if len(addr) != length.Addr {
+		return fmt.Errorf("AddStorageContract: invalid address %x", addr)
+	}
+	s := a.storage
+	name := fmt.Sprintf("%s_%x", s.filenameBase, addr)
+	if a.nameIsTaken(name) {
+		return fmt.Errorf("AddStorageContract: %x is already added", addr)
+	}
+	d, err := NewDomain(a.dir, a.tmpdir, a.aggregationStep, name, s.keysTable, s.valsTable, s.indexKeysTable, s.historyValsTable, s.indexTable, s.compressVals, s.largeValues, a.logger)
+	if err != nil {
+		return err
+	}
+	if a.storageContracts == nil {
+		a.storageContracts = map[string]int{}
+		// filter may be already set: for example by watch list
+		a.storageKeep = s.keep
+		s.keep = func(key []byte) bool { // storage key is addr+location
+			if a.storageKeep != nil && !a.storageKeep(key) {
+				return false
+			}
+			if len(key) < length.Addr {
+				return true
+			}
+			_, ok := a.storageContracts[string(key[:length.Addr])]
+			return !ok
+		}
+	}
+	prefix := common.Copy(addr)
+	d.keep = func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix) && (a.storageKeep == nil || a.storageKeep(key))
+	}
+	d.tablesOwner = s
+	d.metricsLabel, d.mx = s.metricsLabel, s.mx
+	d.tombstonePrefixLen = s.tombstonePrefixLen
+	a.storageContracts[string(prefix)] = len(a.extraDomains)
+	a.extraDomains = append(a.extraDomains, d)
+	return nil
+}
+
+// storageOf - domain which has files of storage of contract `addr`
+func (a *Aggregator) storageOf(addr []byte) *Domain {
+	if len(addr) < length.Addr {
+		return a.storage
+	}
+	if i, ok := a.storageContracts[string(addr[:length.Addr])]; ok {
+		return a.extraDomains[i]
+	}
+	return a.storage
+}
+
+func (ac *AggregatorContext) storageOf(addr []byte) *DomainContext {
+	if len(addr) < length.Addr {
+		return ac.storage
+	}
+	if i, ok := ac.a.storageContracts[string(addr[:length.Addr])]; ok {
+		return ac.extraDomains[i]
+	}
+	return ac.storage
+}
+
+// RegisterInvertedIndex - same as RegisterDomain, but for inverted index (key -> txNums)
+func (a *Aggregator) RegisterInvertedIndex(name, keysTable, idxTable string) error {
+	if a.nameIsTaken(name) {
+		return fmt.Errorf("RegisterInvertedIndex: name %q is already used", name)
+	}
+	ii, err := NewInvertedIndex(a.dir, a.tmpdir, a.aggregationStep, name, keysTable, idxTable, false, nil, a.logger)
+	if err != nil {
+		return err
+	}
+	ii.metricsLabel, ii.mx = a.accounts.metricsLabel, a.mx
+	a.extraIndices = append(a.extraIndices, ii)
+	return nil
+}
+
+func (a *Aggregator) nameIsTaken(name string) bool {
+	for _, d := range append([]*Domain{a.accounts, a.storage, a.code, a.commitment.Domain}, a.extraDomains...) {
+		if d.filenameBase == name {
+			return true
+		}
+	}
+	for _, ii := range append([]*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo}, a.extraIndices...) {
+		if ii.filenameBase == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Aggregator) extraDomain(name string) (int, error) {
+	for i, d := range a.extraDomains {
+		if d.filenameBase == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("domain %q is not registered", name)
+}
+
+func (a *Aggregator) extraIndex(name string) (int, error) {
+	for i, ii := range a.extraIndices {
+		if ii.filenameBase == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("inverted index %q is not registered", name)
+}
+
+// UpdateDomainData - write to registered domain. Empty value deletes key.
+func (a *Aggregator) UpdateDomainData(name string, key, value []byte) error {
+	i, err := a.extraDomain(name)
+	if err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		return a.extraDomains[i].Delete(key, nil)
+	}
+	return a.extraDomains[i].Put(key, nil, value)
+}
+
+func (a *Aggregator) AddIndexKey(name string, key []byte) error {
+	i, err := a.extraIndex(name)
+	if err != nil {
+		return err
+	}
+	return a.extraIndices[i].Add(key)
+}
+
+func (ac *AggregatorContext) ReadDomainData(name string, key []byte, roTx kv.Tx) ([]byte, error) {
+	i, err := ac.a.extraDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	return ac.extraDomains[i].Get(key, nil, roTx)
+}
+
+func (ac *AggregatorContext) ReadDomainDataBeforeTxNum(name string, key []byte, txNum uint64, roTx kv.Tx) ([]byte, error) {
+	i, err := ac.a.extraDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	return ac.extraDomains[i].GetBeforeTxNum(key, txNum, roTx)
+}
+
+func (ac *AggregatorContext) IndexIterator(name string, key []byte, startTxNum, endTxNum int, roTx kv.Tx) (iter.U64, error) {
+	i, err := ac.a.extraIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	return ac.extraIndices[i].IdxRange(key, startTxNum, endTxNum, order.Asc, -1, roTx)
+}
+
+// RegisterInvertedIndex - chain-specific inverted index of AggregatorV3: collated, built, merged and pruned together
+// with built-in ones. Written by AddIndexKey (or PutIdx with kv.InvertedIdx(name)), read by IndexRange with
+// kv.InvertedIdx(name). Tables must be present in TableCfg of DB. Must be called right after NewAggregatorV3.
+// AggregatorV3 has no domains (latest state is in PlainState) - so there is no RegisterDomain for it.
+func (a *AggregatorV3) RegisterInvertedIndex(name, keysTable, idxTable string) error {
+	for _, ii := range a.invertedIndices() {
+		if ii.filenameBase == name {
+			return fmt.Errorf("RegisterInvertedIndex: name %q is already used", name)
+		}
+	}
+	ii, err := NewInvertedIndex(a.dir, a.tmpdir, a.aggregationStep, name, keysTable, idxTable, false, nil, a.logger)
+	if err != nil {
+		return err
+	}
+	// settings of built-in indices, which may be set before registration
+	like := a.logTopics
+	ii.lockDir = a.lockDirForWrite
+	ii.retired = a.retired
+	ii.searchDirs = like.searchDirs
+	ii.compressWorkers = like.compressWorkers
+	ii.metricsLabel, ii.mx = like.metricsLabel, like.mx
+	ii.madv = like.madv
+	ii.noFsync = like.noFsync
+	ii.readLimiter = like.readLimiter
+	a.extraIndices = append(a.extraIndices, ii)
+	return nil
+}
+
+func (a *AggregatorV3) extraIndexByName(name string) (int, bool) {
+	for i, ii := range a.extraIndices {
+		if ii.filenameBase == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (a *AggregatorV3) AddIndexKey(name string, key []byte) error {
+	i, ok := a.extraIndexByName(name)
+	if !ok {
+		return fmt.Errorf("inverted index %q is not registered", name)
+	}
+	return a.extraIndices[i].Add(key)
+}
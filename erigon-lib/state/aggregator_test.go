@@ -1,14 +1,20 @@
 package state
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io/fs"
+	"math"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -22,8 +28,14 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/metrics"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/erigon-lib/seg"
+	"github.com/ledgerwatch/erigon-lib/types"
 )
 
 func testDbAndAggregator(t *testing.T, aggStep uint64) (string, kv.RwDB, *Aggregator) {
@@ -39,6 +51,23 @@ func testDbAndAggregator(t *testing.T, aggStep uint64) (string, kv.RwDB, *Aggreg
 	return path, db, agg
 }
 
+// testDbAndAggregatorV3 - in-memory chaindata and AggregatorV3 with dirs inside `path`
+func testDbAndAggregatorV3(t *testing.T, aggStep uint64) (string, kv.RwDB, *AggregatorV3) {
+	t.Helper()
+	path := t.TempDir()
+	logger := log.New()
+	db := mdbx.NewMDBX(logger).InMem(filepath.Join(path, "db4")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return kv.ChaindataTablesCfg
+	}).MustOpen()
+	t.Cleanup(db.Close)
+	dir, tmpdir := filepath.Join(path, "e4"), filepath.Join(path, "e4tmp")
+	require.NoError(t, os.MkdirAll(dir, 0740))
+	require.NoError(t, os.MkdirAll(tmpdir, 0740))
+	agg, err := NewAggregatorV3(context.Background(), dir, tmpdir, aggStep, db, logger)
+	require.NoError(t, err)
+	return path, db, agg
+}
+
 func TestAggregator_WinAccess(t *testing.T) {
 	_, db, agg := testDbAndAggregator(t, 100)
 	defer agg.Close()
@@ -157,6 +186,289 @@ func TestAggregator_Merge(t *testing.T) {
 	dc.Close()
 
 	require.EqualValues(t, otherMaxWrite, binary.BigEndian.Uint64(v[:]))
+
+	reports, err := agg.IntegrityCheck(context.Background(), 0, txs/agg.aggregationStep, 2, db)
+	require.NoError(t, err)
+	require.NotEmpty(t, reports)
+	for _, r := range reports {
+		require.Empty(t, r.Errs, r.Files)
+	}
+}
+
+func TestAggregator_RegisterDomain(t *testing.T) {
+	path := t.TempDir()
+	logger := log.New()
+	db := mdbx.NewMDBX(logger).InMem(filepath.Join(path, "db4")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		cfg := kv.TableCfg{
+			"CustomKeys":        {Flags: kv.DupSort},
+			"CustomVals":        {},
+			"CustomHistoryKeys": {Flags: kv.DupSort},
+			"CustomHistoryVals": {Flags: kv.DupSort},
+			"CustomIdx":         {Flags: kv.DupSort},
+			"CustomIIKeys":      {Flags: kv.DupSort},
+			"CustomIIIdx":       {Flags: kv.DupSort},
+		}
+		for name, c := range kv.ChaindataTablesCfg {
+			cfg[name] = c
+		}
+		return cfg
+	}).MustOpen()
+	t.Cleanup(db.Close)
+	agg, err := NewAggregator(filepath.Join(path, "e4"), filepath.Join(path, "e4tmp"), 100, CommitmentModeDirect, commitment.VariantHexPatriciaTrie, logger)
+	require.NoError(t, err)
+	defer agg.Close()
+
+	require.NoError(t, agg.RegisterDomain(DomainCfg{Name: "custom", KeysTable: "CustomKeys", ValsTable: "CustomVals",
+		HistoryKeysTable: "CustomHistoryKeys", HistoryValsTable: "CustomHistoryVals", IdxTable: "CustomIdx"}))
+	require.NoError(t, agg.RegisterInvertedIndex("customii", "CustomIIKeys", "CustomIIIdx"))
+	require.Error(t, agg.RegisterDomain(DomainCfg{Name: "accounts"}))
+	require.Error(t, agg.RegisterInvertedIndex("custom", "CustomIIKeys", "CustomIIIdx"))
+
+	tx, err := db.BeginRwNosync(context.Background())
+	require.NoError(t, err)
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	txs := uint64(1000)
+	for txNum := uint64(1); txNum <= txs; txNum++ {
+		agg.SetTxNum(txNum)
+		var k [8]byte
+		binary.BigEndian.PutUint64(k[:], txNum%10)
+		require.NoError(t, agg.UpdateDomainData("custom", k[:], []byte(fmt.Sprintf("v%d", txNum))))
+		require.NoError(t, agg.AddIndexKey("customii", k[:]))
+		require.NoError(t, agg.FinishTx())
+	}
+	require.Error(t, agg.UpdateDomainData("unknown", []byte("k"), []byte("v")))
+	agg.FinishWrites()
+	err = tx.Commit()
+	require.NoError(t, err)
+	tx = nil
+
+	require.NotEmpty(t, agg.extraDomains[0].Files())
+	require.NotEmpty(t, agg.extraIndices[0].Files())
+
+	roTx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer roTx.Rollback()
+
+	dc := agg.MakeContext()
+	defer dc.Close()
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], 3)
+	v, err := dc.ReadDomainData("custom", k[:], roTx)
+	require.NoError(t, err)
+	require.Equal(t, "v993", string(v))
+	v, err = dc.ReadDomainDataBeforeTxNum("custom", k[:], 500, roTx)
+	require.NoError(t, err)
+	require.Equal(t, "v493", string(v))
+
+	it, err := dc.IndexIterator("customii", k[:], 0, 100, roTx)
+	require.NoError(t, err)
+	txNums, err := iter.ToU64Arr(it)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 13, 23, 33, 43, 53, 63, 73, 83, 93}, txNums)
+}
+
+func TestReadAccountCodec(t *testing.T) {
+	db := mdbx.NewMDBX(log.New()).InMem(t.TempDir()).MustOpen()
+	t.Cleanup(db.Close)
+	ctx := context.Background()
+
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		c, err := ReadAccountCodec(tx) // not initialised datadir
+		require.NoError(t, err)
+		require.Equal(t, types.AccountCodecV3, c)
+
+		require.NoError(t, kvcfg.AccountCodec.EnsureNotChangedString(tx, "v3"))
+		require.NoError(t, kvcfg.AccountCodec.EnsureNotChangedString(tx, "v3"))
+		require.ErrorIs(t, kvcfg.AccountCodec.EnsureNotChangedString(tx, "other"), kv.ErrChanged)
+		c, err = ReadAccountCodec(tx)
+		require.NoError(t, err)
+		require.Equal(t, types.AccountCodecV3, c)
+
+		require.NoError(t, tx.Put(kv.DatabaseInfo, kvcfg.AccountCodec, []byte("unknown")))
+		_, err = ReadAccountCodec(tx)
+		require.Error(t, err)
+		return nil
+	}))
+}
+
+func TestAggregatorV3_RegisterInvertedIndex(t *testing.T) {
+	path := t.TempDir()
+	logger := log.New()
+	db := mdbx.NewMDBX(logger).InMem(filepath.Join(path, "db4")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		cfg := kv.TableCfg{
+			"CustomIIKeys": {Flags: kv.DupSort},
+			"CustomIIIdx":  {Flags: kv.DupSort},
+		}
+		for name, c := range kv.ChaindataTablesCfg {
+			cfg[name] = c
+		}
+		return cfg
+	}).MustOpen()
+	t.Cleanup(db.Close)
+	dir, tmpdir := filepath.Join(path, "e4"), filepath.Join(path, "e4tmp")
+	require.NoError(t, os.MkdirAll(dir, 0740))
+	require.NoError(t, os.MkdirAll(tmpdir, 0740))
+	agg, err := NewAggregatorV3(context.Background(), dir, tmpdir, 16, db, logger)
+	require.NoError(t, err)
+	defer agg.Close()
+
+	require.NoError(t, agg.RegisterInvertedIndex("customii", "CustomIIKeys", "CustomIIIdx"))
+	require.Error(t, agg.RegisterInvertedIndex("logaddrs", "CustomIIKeys", "CustomIIIdx"))
+	require.Error(t, agg.RegisterInvertedIndex("customii", "CustomIIKeys", "CustomIIIdx"))
+	require.NoError(t, agg.OpenFolder())
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var k [8]byte
+	for txNum := uint64(0); txNum < 64; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(k[:], txNum%4)
+		require.NoError(t, agg.AddAccountPrev(k[:], k[:]))
+		require.NoError(t, agg.AddIndexKey("customii", k[:]))
+	}
+	require.Error(t, agg.AddIndexKey("unknown", k[:]))
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	for step := uint64(0); step < 4; step++ {
+		require.NoError(t, agg.buildFilesInBackground(ctx, step))
+	}
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.Contains(t, agg.Files(), "customii.0-4.ef")
+
+	tx, err = db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+	fst, err := kv.FirstKey(tx, "CustomIIKeys")
+	require.NoError(t, err)
+	require.Nil(t, fst)
+
+	ac := agg.MakeContext()
+	defer ac.Close()
+	binary.BigEndian.PutUint64(k[:], 1)
+	it, err := ac.IndexRange("customii", k[:], -1, -1, order.Asc, -1, tx)
+	require.NoError(t, err)
+	txNums, err := iter.ToU64Arr(it)
+	require.NoError(t, err)
+	require.Len(t, txNums, 16)
+	require.Equal(t, uint64(1), txNums[0])
+	require.Equal(t, uint64(61), txNums[15])
+}
+
+func TestAggregator_StorageContract(t *testing.T) {
+	_, db, agg := testDbAndAggregator(t, 100)
+	defer agg.Close()
+
+	mega, other := bytes.Repeat([]byte{0xaa}, length.Addr), bytes.Repeat([]byte{0xbb}, length.Addr)
+	require.NoError(t, agg.AddStorageContract(mega))
+	require.Error(t, agg.AddStorageContract(mega))
+	require.Error(t, agg.AddStorageContract(mega[:10]))
+
+	tx, err := db.BeginRwNosync(context.Background())
+	require.NoError(t, err)
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	// commitment expects storage of existing accounts only
+	for _, addr := range [][]byte{mega, other} {
+		require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(1, uint256.NewInt(0), nil, 0)))
+	}
+	txs := uint64(1000)
+	for txNum := uint64(1); txNum <= txs; txNum++ {
+		agg.SetTxNum(txNum)
+		loc := make([]byte, length.Hash)
+		loc[0] = byte(txNum % 10)
+		for _, addr := range [][]byte{mega, other} {
+			require.NoError(t, agg.WriteAccountStorage(addr, loc, []byte(fmt.Sprintf("v%d", txNum))))
+		}
+		require.NoError(t, agg.FinishTx())
+	}
+	agg.FinishWrites()
+	err = tx.Commit()
+	require.NoError(t, err)
+	tx = nil
+
+	// each domain has files only of own keys
+	contract := agg.storageOf(mega)
+	require.NotEqual(t, agg.storage, contract)
+	for d, expect := range map[*Domain][]byte{agg.storage: other, contract: mega} {
+		var keys int
+		d.files.Walk(func(items []*filesItem) bool {
+			for _, item := range items {
+				g := item.decompressor.MakeGetter()
+				for g.HasNext() {
+					k, _ := g.NextUncompressed()
+					g.Skip()
+					require.True(t, bytes.HasPrefix(k, expect))
+					keys++
+				}
+			}
+			return true
+		})
+		require.Positive(t, keys)
+	}
+
+	roTx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer roTx.Rollback()
+
+	dc := agg.MakeContext()
+	defer dc.Close()
+	loc := make([]byte, length.Hash)
+	loc[0] = 3
+	for _, addr := range [][]byte{mega, other} {
+		v, err := dc.ReadAccountStorage(addr, loc, roTx)
+		require.NoError(t, err)
+		require.Equal(t, "v993", string(v))
+		v, err = dc.ReadAccountStorageBeforeTxNum(addr, loc, 500, roTx)
+		require.NoError(t, err)
+		require.Equal(t, "v493", string(v))
+	}
+}
+
+func TestAggregator_StorageContractKeep(t *testing.T) {
+	_, _, agg := testDbAndAggregator(t, 100)
+	defer agg.Close()
+
+	a, b, c := bytes.Repeat([]byte{0xaa}, length.Addr), bytes.Repeat([]byte{0xbb}, length.Addr), bytes.Repeat([]byte{0xcc}, length.Addr)
+	agg.storage.keep = func(key []byte) bool { return !bytes.HasPrefix(key, c) } // filter set before: kept for all storage files
+	require.NoError(t, agg.AddStorageContract(a))
+	require.NoError(t, agg.AddStorageContract(b))
+
+	loc := make([]byte, length.Hash)
+	for _, tc := range []struct {
+		addr                          []byte
+		storage, contractA, contractB bool
+	}{
+		{addr: a, contractA: true},
+		{addr: b, contractB: true},
+		{addr: c},
+		{addr: bytes.Repeat([]byte{0xdd}, length.Addr), storage: true},
+	} {
+		key := append(common.Copy(tc.addr), loc...)
+		require.Equal(t, tc.storage, agg.storage.keep(key), "%x", tc.addr)
+		require.Equal(t, tc.contractA, agg.storageOf(a).keep(key), "%x", tc.addr)
+		require.Equal(t, tc.contractB, agg.storageOf(b).keep(key), "%x", tc.addr)
+	}
 }
 
 // here we create a bunch of updates for further aggregation.
@@ -245,6 +557,13 @@ func TestAggregator_RestartOnDatadir(t *testing.T) {
 	require.GreaterOrEqual(t, sstartTx, startTx)
 	require.GreaterOrEqual(t, sstartTx, latestCommitTxNum)
 	_ = sstartTx
+
+	_, hintedStartTx, err := anotherAgg.SeekCommitmentWithHint(CommitmentHint{FromTxNum: startTx, ToTxNum: math.MaxUint64})
+	require.NoError(t, err)
+	require.Equal(t, sstartTx, hintedStartTx)
+	_, _, err = anotherAgg.SeekCommitmentWithHint(CommitmentHint{FromTxNum: txs * 2, ToTxNum: math.MaxUint64})
+	var diverged *CommitmentDivergedError
+	require.ErrorAs(t, err, &diverged)
 	rwTx.Rollback()
 	rwTx = nil
 
@@ -482,6 +801,38 @@ func Test_EncodeCommitmentState(t *testing.T) {
 	require.EqualValues(t, cs.trieState, dec.trieState)
 }
 
+func Test_DecodeCommitmentStatePastVersions(t *testing.T) {
+	trieState := []byte{1, 2, 3, 4}
+
+	// v0: unversioned encoding of previous releases
+	v0 := make([]byte, 18)
+	binary.BigEndian.PutUint64(v0[0:8], 1000)
+	binary.BigEndian.PutUint64(v0[8:16], 10)
+	binary.BigEndian.PutUint16(v0[16:18], uint16(len(trieState)))
+	v0 = append(v0, trieState...)
+
+	var dec commitmentState
+	require.NoError(t, dec.Decode(v0))
+	require.Equal(t, commitmentStateV0, dec.version)
+	require.EqualValues(t, 1000, dec.txNum)
+	require.EqualValues(t, 10, dec.blockNum)
+	require.Equal(t, trieState, dec.trieState)
+
+	// re-encoded in latest version
+	buf, err := dec.Encode()
+	require.NoError(t, err)
+	var dec2 commitmentState
+	require.NoError(t, dec2.Decode(buf))
+	require.Equal(t, commitmentStateLatest, dec2.version)
+	require.Equal(t, dec.txNum, dec2.txNum)
+	require.Equal(t, dec.blockNum, dec2.blockNum)
+	require.Equal(t, dec.trieState, dec2.trieState)
+
+	require.Error(t, dec.Decode(v0[:20]))
+	buf[0] = commitmentStateLatest + 1
+	require.Error(t, dec.Decode(buf))
+}
+
 func Test_BtreeIndex_Seek(t *testing.T) {
 	tmp := t.TempDir()
 	logger := log.New()
@@ -636,3 +987,847 @@ func Test_InitBtreeIndex(t *testing.T) {
 	require.EqualValues(t, bt.KeyCount(), keyCount)
 	bt.Close()
 }
+
+func TestAggregatorV3_PinView(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	addr := []byte("addr_of_pinned_view_")
+	for _, txNum := range []uint64{10, 30} { // account had value "v10" before txNum=10 and "v30" before txNum=30
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte(fmt.Sprintf("v%d", txNum))))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+
+	agg.minimaxTxNumInFiles.Store(32)
+	v, err := agg.PinView(20, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), v.TxNum())
+	require.Equal(t, 1, agg.PinnedViews())
+
+	// reads are as of txNum of view
+	val, ok, err := v.ReadAccountData(addr, tx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("v30"), val)
+
+	// new files appeared after pin: prune must not touch DB steps which view doesn't see in files
+	agg.minimaxTxNumInFiles.Store(64)
+	require.Equal(t, uint64(32), agg.pruneTo())
+
+	require.NoError(t, v.Renew(time.Minute))
+	v.Release()
+	v.Release()
+	require.Equal(t, 0, agg.PinnedViews())
+	require.Equal(t, uint64(64), agg.pruneTo())
+	require.ErrorIs(t, v.Renew(time.Minute), ErrPinnedViewExpired)
+	_, _, err = v.ReadAccountData(addr, tx)
+	require.ErrorIs(t, err, ErrPinnedViewExpired)
+
+	// DB changes before txNum of view are not visible to it: prune may delete them
+	agg.minimaxTxNumInFiles.Store(32)
+	v, err = agg.PinView(40, time.Minute)
+	require.NoError(t, err)
+	agg.minimaxTxNumInFiles.Store(64)
+	require.Equal(t, uint64(40), agg.pruneTo())
+	v.Release()
+
+	// view is registered before PinView reads files boundary: prune can't pass its txNum while files are opened
+	reserved := &PinnedView{pins: agg.pins, txNum: 24, expiresAt: time.Now().Add(time.Minute)}
+	agg.pins.add(reserved)
+	require.Equal(t, uint64(24), agg.pruneTo())
+	require.True(t, agg.pins.activate(reserved, 32, agg.MakeContext()))
+	require.Equal(t, uint64(32), agg.pruneTo())
+	reserved.Release()
+	require.False(t, agg.pins.activate(reserved, 32, nil))
+
+	// expired view is unpinned without Release: doesn't block prune and releases files
+	v, err = agg.PinView(20, time.Nanosecond)
+	require.NoError(t, err)
+	defer v.Release()
+	time.Sleep(time.Millisecond)
+	require.Equal(t, uint64(64), agg.pruneTo())
+	require.Equal(t, 0, agg.PinnedViews())
+	require.Nil(t, v.ac)
+	require.ErrorIs(t, v.Renew(time.Minute), ErrPinnedViewExpired)
+	_, _, err = v.ReadAccountData(addr, tx)
+	require.ErrorIs(t, err, ErrPinnedViewExpired)
+}
+
+func TestAggregatorV3_PruneProgress(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+
+	var txKey [8]byte
+	for txNum := uint64(0); txNum < 100; txNum++ {
+		binary.BigEndian.PutUint64(txKey[:], txNum)
+		require.NoError(t, tx.Put(kv.TblLogAddressKeys, txKey[:], []byte("addr")))
+		require.NoError(t, tx.Put(kv.TblLogAddressIdx, []byte("addr"), txKey[:]))
+	}
+
+	require.NoError(t, agg.prune(ctx, 0, 64, 10))
+	pruned, ok, err := readPruneProgress(tx, agg.logAddrs.filenameBase)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(10), pruned)
+	require.Contains(t, agg.BackgroundProgress(), "prune logaddrs")
+
+	// empty tables: nothing to prune up to txTo
+	pruned, ok, err = readPruneProgress(tx, agg.accounts.filenameBase)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(64), pruned)
+
+	// resume from saved progress
+	require.NoError(t, agg.prune(ctx, 0, 64, 10))
+	pruned, _, err = readPruneProgress(tx, agg.logAddrs.filenameBase)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), pruned)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, agg.prune(ctx, 0, 64, 10))
+	}
+	pruned, _, err = readPruneProgress(tx, agg.logAddrs.filenameBase)
+	require.NoError(t, err)
+	require.Equal(t, uint64(64), pruned)
+	require.NotContains(t, agg.BackgroundProgress(), "prune logaddrs")
+	fst, err := kv.FirstKey(tx, kv.TblLogAddressKeys)
+	require.NoError(t, err)
+	require.Equal(t, uint64(64), binary.BigEndian.Uint64(fst))
+}
+
+func TestAggregatorV3_PruneIdxKeys(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+	require.NoError(t, agg.SetPruneIdxKeys(kv.TblTracesToIdx, func(key []byte) bool { return bytes.Equal(key, []byte("keep")) }))
+	require.Error(t, agg.SetPruneIdxKeys("unknown", nil))
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+
+	var txKey [8]byte
+	put := func(from, to uint64) {
+		for txNum := from; txNum < to; txNum++ {
+			binary.BigEndian.PutUint64(txKey[:], txNum)
+			for _, key := range []string{"keep", "drop"} {
+				require.NoError(t, tx.Put(kv.TblTracesToKeys, txKey[:], []byte(key)))
+				require.NoError(t, tx.Put(kv.TblTracesToIdx, []byte(key), txKey[:]))
+			}
+		}
+	}
+	count := func(key string) (n int) {
+		require.NoError(t, tx.ForPrefix(kv.TblTracesToIdx, []byte(key), func(k, v []byte) error {
+			n++
+			return nil
+		}))
+		return n
+	}
+	put(100, 120)
+
+	// nothing to prune by files, but filter applies to all postings in DB
+	require.NoError(t, agg.prune(ctx, 0, 0, 10))
+	require.Equal(t, 20, count("keep"))
+	require.Equal(t, 0, count("drop"))
+	progress, ok, err := readPruneProgress(tx, agg.tracesTo.filenameBase+pruneKeysProgressSuffix)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(120), progress)
+
+	// postings of re-executed txs are filtered again
+	require.NoError(t, agg.Unwind(ctx, 110))
+	progress, _, err = readPruneProgress(tx, agg.tracesTo.filenameBase+pruneKeysProgressSuffix)
+	require.NoError(t, err)
+	require.Equal(t, uint64(110), progress)
+	put(110, 130)
+	require.NoError(t, agg.prune(ctx, 0, 0, 10))
+	require.Equal(t, 30, count("keep"))
+	require.Equal(t, 0, count("drop"))
+
+	// other indices are not filtered
+	binary.BigEndian.PutUint64(txKey[:], 200)
+	require.NoError(t, tx.Put(kv.TblTracesFromKeys, txKey[:], []byte("drop")))
+	require.NoError(t, tx.Put(kv.TblTracesFromIdx, []byte("drop"), txKey[:]))
+	require.NoError(t, agg.prune(ctx, 0, 0, 10))
+	v, err := tx.GetOne(kv.TblTracesFromIdx, []byte("drop"))
+	require.NoError(t, err)
+	require.Equal(t, txKey[:], v)
+}
+
+func TestAggregatorV3_PauseBackground(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+
+	agg.PauseBackground()
+	require.True(t, agg.BackgroundPaused())
+	require.False(t, agg.CanPrune(tx))
+	agg.BuildFilesInBackground(1_000)
+	require.False(t, agg.buildingFiles.Load())
+	agg.ResumeBackground()
+	require.False(t, agg.BackgroundPaused())
+
+	// requested budget is used once
+	require.NoError(t, agg.RequestPrune(time.Minute))
+	require.NoError(t, agg.PruneWithTiemout(ctx, time.Millisecond))
+	require.Zero(t, agg.pruneBudget.Load())
+
+	agg.SetReadOnly(true)
+	require.ErrorIs(t, agg.RequestPrune(time.Minute), ErrAggregatorReadOnly)
+	require.ErrorIs(t, agg.TriggerFilesBuild(), ErrAggregatorReadOnly)
+}
+
+func TestAggregatorV3_DirLock(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+	dir := filepath.Join(path, "e4")
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var key [8]byte
+	for txNum := uint64(0); txNum < 16; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(key[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblLogAddressIdx, key[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	sf, err := agg.buildFiles(ctx, 0, 0, agg.aggregationStep)
+	require.NoError(t, err)
+	agg.integrateFiles(sf, 0, agg.aggregationStep)
+	require.NoError(t, agg.lockDirForWrite())
+
+	// another instance on same dir: last reader of retired file doesn't remove it without writer lock
+	agg2, err := NewAggregatorV3(ctx, dir, filepath.Join(path, "e4tmp"), 16, db, agg.logger)
+	require.NoError(t, err)
+	defer agg2.Close()
+	require.NoError(t, agg2.OpenFolder())
+	ac2 := agg2.MakeContext()
+	ac2.logAddrs.files[0].src.markCanDelete(agg2.retired, "test", nil)
+	ac2.Close()
+	require.FileExists(t, filepath.Join(dir, "logaddrs.0-1.ef"))
+
+	// owner of lock removes
+	ac := agg.MakeContext()
+	ac.logAddrs.files[0].src.markCanDelete(agg.retired, "test", nil)
+	ac.Close()
+	require.NoFileExists(t, filepath.Join(dir, "logaddrs.0-1.ef"))
+}
+
+func TestAggregatorV3_MultipleInstances(t *testing.T) {
+	path := t.TempDir()
+	logger := log.New()
+	ctx := context.Background()
+	newAgg := func(name string) (kv.RwDB, *AggregatorV3) {
+		db := mdbx.NewMDBX(logger).InMem(filepath.Join(path, name, "db4")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+			return kv.ChaindataTablesCfg
+		}).MustOpen()
+		t.Cleanup(db.Close)
+		dir, tmpdir := filepath.Join(path, name, "e4"), filepath.Join(path, name, "e4tmp")
+		require.NoError(t, os.MkdirAll(dir, 0740))
+		require.NoError(t, os.MkdirAll(tmpdir, 0740))
+		agg, err := NewAggregatorV3(ctx, dir, tmpdir, 16, db, logger.New("chain", name))
+		require.NoError(t, err)
+		t.Cleanup(agg.Close)
+		agg.SetMetricsLabel(name)
+		return db, agg
+	}
+	db1, agg1 := newAgg("chain1")
+	_, agg2 := newAgg("chain2")
+	require.Equal(t, "chain1", agg1.accounts.metricsLabel)
+	require.Equal(t, "chain2", agg2.txLookup.metricsLabel)
+	merges2 := agg2.accounts.mx.runningMerges.GetValue()
+	agg1.accounts.mx.runningMerges.Inc()
+	defer agg1.accounts.mx.runningMerges.Dec()
+	require.Equal(t, merges2, agg2.accounts.mx.runningMerges.GetValue())
+	require.NotSame(t, defaultAggMetrics, agg1.logAddrs.mx)
+	require.NotSame(t, agg1.accounts.refs, agg2.accounts.refs)
+
+	tx, err := db1.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg1.SetTx(tx)
+	agg1.StartWrites()
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 40; txNum++ {
+		agg1.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg1.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg1.Flush(ctx, tx))
+	agg1.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	sf, err := agg1.buildFiles(ctx, 0, 0, 16)
+	require.NoError(t, err)
+	agg1.integrateFiles(sf, 0, 16)
+	require.NoError(t, agg1.db.View(ctx, func(tx kv.Tx) error {
+		agg1.UpdateLagMetrics(tx, 40)
+		return nil
+	}))
+
+	// files and background loops of one instance don't affect another
+	require.Equal(t, uint64(16), agg1.EndTxNumMinimax())
+	require.NoError(t, agg2.OpenFolder())
+	require.Zero(t, agg2.EndTxNumMinimax())
+	require.Empty(t, agg2.Files())
+	agg2.Close()
+	require.NoError(t, agg1.ctx.Err())
+	require.NoError(t, agg1.OpenFolder())
+	require.Equal(t, uint64(16), agg1.EndTxNumMinimax())
+}
+
+func TestAggregatorV3_TxLookup(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 40; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	// 2 steps go to files, their DB-part is pruned after that
+	for step := uint64(0); step < 2; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+	tx, err = db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+	fst, err := kv.FirstKey(tx, kv.TblTxLookupKeys)
+	require.NoError(t, err)
+	require.Equal(t, uint64(32), binary.BigEndian.Uint64(fst))
+
+	ac := agg.MakeContext()
+	for _, txNum := range []uint64{3, 17, 35} {
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		it, err := ac.IndexRange(kv.TxLookupIdx, txnHash[:], 0, -1, order.Asc, 1, tx)
+		require.NoError(t, err)
+		require.True(t, it.HasNext())
+		found, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, txNum, found)
+	}
+	binary.BigEndian.PutUint64(txnHash[:], 100)
+	it, err := ac.IndexRange(kv.TxLookupIdx, txnHash[:], 0, -1, order.Asc, 1, tx)
+	require.NoError(t, err)
+	require.False(t, it.HasNext())
+	from, to := ac.TxLookupFilesRange()
+	require.Equal(t, uint64(0), from)
+	require.Equal(t, uint64(32), to)
+	ac.Close()
+
+	// datadirs created before TxLookupIdx appeared have no its files: other files are still usable
+	agg.txLookup.closeWhatNotInList(nil)
+	agg.txLookup.reCalcRoFiles()
+	agg.recalcMaxTxNum()
+	require.Equal(t, uint64(32), agg.EndTxNumMinimax())
+	ac = agg.MakeContext()
+	defer ac.Close()
+	require.Equal(t, uint64(32), ac.FilesEndTxNum())
+	from, to = ac.TxLookupFilesRange()
+	require.Equal(t, uint64(0), from+to)
+}
+
+func TestAggregatorV3_PruneOldFiles(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	dir, tmpdir := filepath.Join(path, "e4"), filepath.Join(path, "e4tmp")
+	logger := agg.logger
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 56; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	for step := uint64(0); step < 3; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+
+	// disabled by default
+	removed, err := agg.PruneOldFiles()
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	// .torrent of deleted file is deleted too, Downloader is notified: it must not download file again
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "txlookup.0-1.ef.torrent"), []byte{1}, 0644))
+	var notified []string
+	agg.OnFilesDelete(func(deletedFileNames []string) { notified = append(notified, deletedFileNames...) })
+
+	// file is removed by its last reader, also frozen one: opened file can't be removed on some OS
+	agg.txLookup.files.Walk(func(items []*filesItem) bool {
+		for _, item := range items {
+			if item.startTxNum == 0 {
+				item.frozen = true
+			}
+		}
+		return true
+	})
+	reader := agg.MakeContext()
+	agg.SetKeepSteps(2)
+	removed, err = agg.PruneOldFiles()
+	require.NoError(t, err)
+	require.Equal(t, removed, notified)
+	require.NoFileExists(t, filepath.Join(dir, "txlookup.0-1.ef.torrent"))
+	require.Contains(t, removed, "txlookup.0-1.ef")
+	require.Contains(t, removed, "accounts.0-1.v")
+	require.NotContains(t, removed, "txlookup.1-2.ef")
+	require.FileExists(t, filepath.Join(dir, "txlookup.0-1.ef"))
+	reader.Close()
+	require.NoFileExists(t, filepath.Join(dir, "txlookup.0-1.ef"))
+	require.FileExists(t, filepath.Join(dir, "txlookup.1-2.ef"))
+
+	// OpenFolder also deletes old files: previous run may stop before background merge did it
+	agg.Close()
+	agg2, err := NewAggregatorV3(context.Background(), dir, tmpdir, 16, db, logger)
+	require.NoError(t, err)
+	defer agg2.Close()
+	agg2.SetKeepSteps(1)
+	require.NoError(t, agg2.OpenFolder())
+	require.NoFileExists(t, filepath.Join(dir, "txlookup.1-2.ef"))
+	require.FileExists(t, filepath.Join(dir, "txlookup.2-3.ef"))
+
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	ac := agg2.MakeContext()
+	defer ac.Close()
+	require.Equal(t, uint64(32), ac.EarliestServiceableTxNum())
+	binary.BigEndian.PutUint64(txnHash[:], 40)
+	it, err := ac.IndexRange(kv.TxLookupIdx, txnHash[:], 32, -1, order.Asc, 1, roTx)
+	require.NoError(t, err)
+	require.True(t, it.HasNext())
+}
+
+func TestAggregatorV3_ExpireFilesBefore(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	dir := filepath.Join(path, "e4")
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 56; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	for step := uint64(0); step < 3; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "accounts.0-1.v.torrent"), []byte{1}, 0644))
+	var notified []string
+	agg.OnFilesDelete(func(deletedFileNames []string) { notified = append(notified, deletedFileNames...) })
+
+	// cutoff in the middle of step 1: only files of step 0 have no txNums >= cutoff
+	removed, err := agg.ExpireFilesBefore(20)
+	require.NoError(t, err)
+	require.Equal(t, removed, notified) // Downloader must stop seeding them
+	require.NoFileExists(t, filepath.Join(dir, "accounts.0-1.v.torrent"))
+	require.Contains(t, removed, "txlookup.0-1.ef")
+	require.Contains(t, removed, "accounts.0-1.v")
+	require.NotContains(t, removed, "txlookup.1-2.ef")
+	require.NoFileExists(t, filepath.Join(dir, "txlookup.0-1.ef"))
+	require.FileExists(t, filepath.Join(dir, "txlookup.1-2.ef"))
+
+	// files up to last built txNum are never deleted
+	removed, err = agg.ExpireFilesBefore(48)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	ac := agg.MakeContext()
+	defer ac.Close()
+	require.Equal(t, uint64(16), ac.EarliestServiceableTxNum())
+	require.Equal(t, uint64(48), ac.FilesEndTxNum())
+}
+
+func TestAggregatorV3_FilesState(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 40; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	for step := uint64(0); step < 2; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+
+	fileState := func(name string) (FileState, bool) {
+		for _, st := range agg.FilesState() {
+			if st.Name == name {
+				return st, true
+			}
+		}
+		return FileState{}, false
+	}
+	st, ok := fileState("txlookup.0-1.ef")
+	require.True(t, ok)
+	require.True(t, st.Visible && st.Opened && st.Index)
+	require.False(t, st.CanDelete)
+
+	ac := agg.MakeContext()
+	merged, err := agg.MergeRange(ctx, "txlookup", 0, 2, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"txlookup.0-2.ef"}, merged)
+
+	st, ok = fileState("txlookup.0-2.ef")
+	require.True(t, ok)
+	require.True(t, st.Visible)
+	st, ok = fileState("txlookup.0-1.ef") // retired, but used by `ac`
+	require.True(t, ok)
+	require.False(t, st.Visible)
+	require.True(t, st.CanDelete)
+	require.Equal(t, int32(1), st.Refcount)
+	require.Contains(t, st.DeleteReason, "txlookup.0-2.ef")
+
+	// last reader closes retired files while FilesState is called (run with -race)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			agg.FilesState()
+		}
+	}()
+	ac.Close()
+	<-done
+	_, ok = fileState("txlookup.0-1.ef")
+	require.False(t, ok)
+	st, ok = fileState("txlookup.0-2.ef")
+	require.True(t, ok)
+	require.Zero(t, st.Refcount) // FilesState doesn't hold files
+}
+
+func TestAggregatorV3_KeepStepsInDB(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+	require.Error(t, agg.KeepStepsInDB("unknown", 1))
+	require.NoError(t, agg.KeepStepsInDB("txlookup", 1))
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 40; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+		require.NoError(t, agg.PutIdx(kv.TblTracesToIdx, txnHash[:20]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	for step := uint64(0); step < 2; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+	tx, err = db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+
+	// txlookup keeps 1 built step in DB, others are pruned up to files end
+	fst, err := kv.FirstKey(tx, kv.TblTxLookupKeys)
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), binary.BigEndian.Uint64(fst))
+	fst, err = kv.FirstKey(tx, kv.TblTracesToKeys)
+	require.NoError(t, err)
+	require.Equal(t, uint64(32), binary.BigEndian.Uint64(fst))
+	require.False(t, agg.CanPrune(tx))
+
+	// runtime change: next prune catches up. Only txlookup has data to prune - CanPrune must see it
+	require.NoError(t, agg.KeepStepsInDB("txlookup", 0))
+	require.True(t, agg.CanPrune(tx))
+	require.Equal(t, uint64(16), agg.CanPruneFrom(tx))
+	require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+	fst, err = kv.FirstKey(tx, kv.TblTxLookupKeys)
+	require.NoError(t, err)
+	require.Equal(t, uint64(32), binary.BigEndian.Uint64(fst))
+	require.False(t, agg.CanPrune(tx))
+}
+
+func TestAggregatorV3_Backup(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	tmpdir, backupDir := filepath.Join(path, "e4tmp"), filepath.Join(path, "backup")
+	logger := agg.logger
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 56; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	for step := uint64(0); step < 3; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+
+	names, txNum, err := agg.Backup(ctx, backupDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(48), txNum)
+	require.Contains(t, names, "txlookup.2-3.ef")
+	require.Contains(t, names, "accounts.0-1.v")
+	for _, name := range names {
+		require.FileExists(t, filepath.Join(backupDir, name))
+	}
+
+	// backup is usable by another aggregator
+	restored, err := NewAggregatorV3(ctx, backupDir, tmpdir, 16, db, logger)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.OpenFolder())
+	require.Equal(t, txNum, restored.EndTxNumMinimax())
+}
+
+func TestAggregatorV3_Quarantine(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	dir, tmpdir := filepath.Join(path, "e4"), filepath.Join(path, "e4tmp")
+	logger := agg.logger
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 56; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+		require.NoError(t, agg.PutIdx(kv.TblLogAddressIdx, txnHash[:20]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	for step := uint64(0); step < 3; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+	agg.Close()
+
+	// truncated data files and corrupted accessor index
+	require.NoError(t, os.Truncate(filepath.Join(dir, "txlookup.1-2.ef"), 10))
+	require.NoError(t, os.Truncate(filepath.Join(dir, "logaddrs.1-2.ef"), 10))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "txlookup.2-3.efi"), []byte{1, 2, 3}, 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "accounts.2-3.vi")))
+
+	// read-only aggregator (rpcdaemon, historyserver) doesn't own dir: broken files are not moved, files without index are not opened
+	roAgg, err := NewAggregatorV3(ctx, dir, tmpdir, 16, db, logger)
+	require.NoError(t, err)
+	defer roAgg.Close()
+	roAgg.SetReadOnly(true)
+	require.NoError(t, roAgg.OpenFolder())
+	require.FileExists(t, filepath.Join(dir, "txlookup.1-2.ef"))
+	require.NoDirExists(t, filepath.Join(dir, QuarantineDirName))
+	roAc := roAgg.MakeContext()
+	require.Equal(t, uint64(32), roAc.accounts.files[len(roAc.accounts.files)-1].endTxNum)
+	roAc.Close()
+
+	agg, err = NewAggregatorV3(ctx, dir, tmpdir, 16, db, logger)
+	require.NoError(t, err)
+	defer agg.Close()
+	require.NoError(t, agg.OpenFolder())
+
+	quarantined := map[string]string{}
+	for _, q := range agg.QuarantinedFiles() {
+		quarantined[q.Name] = q.Rebuild
+	}
+	require.Equal(t, map[string]string{
+		"txlookup.1-2.ef": RebuildDownload, "txlookup.1-2.efi": RebuildIndex, "txlookup.2-3.efi": RebuildIndex,
+		"logaddrs.1-2.ef": RebuildDownload, "logaddrs.1-2.efi": RebuildIndex,
+	}, quarantined)
+	require.FileExists(t, filepath.Join(dir, QuarantineDirName, "txlookup.1-2.ef"))
+	require.NoFileExists(t, filepath.Join(dir, "txlookup.1-2.ef"))
+
+	// reads across gap are rejected: no silent holes in history
+	ac := agg.MakeContext()
+	require.Equal(t, uint64(32), ac.txLookup.filesStartTxNum())
+	require.Equal(t, uint64(32), ac.EarliestServiceableTxNum())
+	require.Zero(t, ac.HistoryEarliestTxNum(kv.AccountsHistory))
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	_, err = ac.IndexRange(kv.LogAddrIdx, txnHash[:20], 0, 48, order.Asc, -1, roTx)
+	require.ErrorIs(t, err, ErrHistoryNotAvailable)
+	_, err = ac.IndexRange(kv.LogAddrIdx, txnHash[:20], 47, -1, order.Desc, -1, roTx)
+	require.ErrorIs(t, err, ErrHistoryNotAvailable)
+	_, err = ac.IndexRange(kv.LogAddrIdx, txnHash[:20], 32, 48, order.Asc, -1, roTx)
+	require.NoError(t, err)
+	roTx.Rollback()
+	ac.Close()
+
+	// quarantined accessor index is re-built in background, without restart
+	require.NoError(t, agg.BuildFiles(0))
+	require.FileExists(t, filepath.Join(dir, "txlookup.2-3.efi"))
+	require.FileExists(t, filepath.Join(dir, "accounts.2-3.vi"))
+
+	// file is picked up by read-only aggregator after owner built its index
+	require.NoError(t, roAgg.OpenFolder())
+	roAc = roAgg.MakeContext()
+	defer roAc.Close()
+	require.Equal(t, uint64(48), roAc.accounts.files[len(roAc.accounts.files)-1].endTxNum)
+}
+
+func TestIsCorruption(t *testing.T) {
+	require.True(t, isCorruption(fmt.Errorf("compressed file is too short: %d", 10)))
+	require.True(t, isCorruption(fmt.Errorf("file %s %w", "a.efi", recsplit.IncompatibleErr)))
+	require.False(t, isCorruption(syscall.ENOMEM)) // mmap
+	require.False(t, isCorruption(&fs.PathError{Op: "open", Path: "a.ef", Err: syscall.EMFILE}))
+	require.False(t, isCorruption(fmt.Errorf("a.ef: %w", &fs.PathError{Op: "open", Path: "a.ef", Err: syscall.EACCES})))
+}
+
+func TestAggregatorV3_Spans(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+	spansPath := filepath.Join(path, "spans.jsonl")
+	spans, err := metrics.StartSpansFile(spansPath)
+	require.NoError(t, err)
+	defer spans.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 20; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.AddAccountPrev(txnHash[:20], nil))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	sf, err := agg.buildFiles(ctx, 0, 0, 16)
+	require.NoError(t, err)
+	agg.integrateFiles(sf, 0, 16)
+	require.NoError(t, spans.Close())
+
+	f, err := os.Open(spansPath)
+	require.NoError(t, err)
+	defer f.Close()
+	type span struct {
+		SpanID, ParentSpanID, Name string
+		Attributes                 map[string]string
+	}
+	var buildStep span
+	collates := map[string]span{} // domain -> span
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s span
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		switch s.Name {
+		case "state.build_step":
+			buildStep = s
+		case "state.collate":
+			collates[s.Attributes["domain"]] = s
+		}
+	}
+	require.NoError(t, scanner.Err())
+	require.NotEmpty(t, buildStep.SpanID)
+	require.Equal(t, "0", buildStep.Attributes["step.from"])
+	require.Equal(t, "1", buildStep.Attributes["step.to"])
+	// ctx is propagated: collate of history and of inverted index are children of build
+	require.Equal(t, buildStep.SpanID, collates["accounts"].ParentSpanID)
+	require.Equal(t, buildStep.SpanID, collates["logaddrs"].ParentSpanID)
+}
@@ -23,20 +23,27 @@ import (
 	"fmt"
 	math2 "math"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
 	common2 "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	dir2 "github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/log/v3"
 	"golang.org/x/sync/errgroup"
 )
@@ -46,6 +53,8 @@ type AggregatorV3 struct {
 	db               kv.RoDB
 	storage          *History
 	tracesTo         *InvertedIndex
+	txLookup         *InvertedIndex
+	extraIndices     []*InvertedIndex // chain-specific, see RegisterInvertedIndex
 	backgroundResult *BackgroundResult
 	code             *History
 	logAddrs         *InvertedIndex
@@ -56,7 +65,8 @@ type AggregatorV3 struct {
 	dir              string
 	tmpdir           string
 	aggregationStep  uint64
-	keepInDB         uint64
+	keepInDB         atomic.Uint64 // txNums which stay in DB before building files, see KeepInDB
+	keepSteps        atomic.Uint64 // see SetKeepSteps
 
 	minimaxTxNumInFiles atomic.Uint64
 
@@ -75,10 +85,28 @@ type AggregatorV3 struct {
 	needSaveFilesListInDB atomic.Bool
 	wg                    sync.WaitGroup
 
-	onFreeze OnFreezeFunc
-	walLock  sync.RWMutex
+	onFreeze      OnFreezeFunc
+	onFilesDelete OnFilesDeleteFunc
+	walLock       sync.RWMutex
 
-	ps *background.ProgressSet
+	ps            *background.ProgressSet
+	pruneProgress map[string]*pruneProgress // accessed only by prune, which uses a.rwTx
+
+	pins *viewPins // see PinView
+
+	accountCodec types.AccountCodec // encoding of accounts history values, see SetAccountCodec
+
+	// cross-process writer lock of `dir`: taken on first destructive operation (build, merge, delete of files).
+	// readonly aggregator never takes it and refuses such operations.
+	dirLock       *datadir.SnapshotsLock
+	dirLockMu     sync.Mutex
+	dirLockClosed bool // lock is released by Close: not taken again by late readers which remove files
+	readonly      atomic.Bool
+
+	retired *retiredFiles // files which wait for deletion, see FilesState
+
+	paused      atomic.Bool  // see PauseBackground
+	pruneBudget atomic.Int64 // see RequestPrune
 
 	// next fields are set only if agg.doTraceCtx is true. can enable by env: TRACE_AGG=true
 	leakDetector *dbg.LeakDetector
@@ -87,20 +115,27 @@ type AggregatorV3 struct {
 
 type OnFreezeFunc func(frozenFileNames []string)
 
+// OnFilesDeleteFunc - called after files are deleted on purpose (see PruneOldFiles, ExpireFilesBefore):
+// they must not be seeded or downloaded again
+type OnFilesDeleteFunc func(deletedFileNames []string)
+
 func NewAggregatorV3(ctx context.Context, dir, tmpdir string, aggregationStep uint64, db kv.RoDB, logger log.Logger) (*AggregatorV3, error) {
 	ctx, ctxCancel := context.WithCancel(ctx)
 	a := &AggregatorV3{
 		ctx:              ctx,
 		ctxCancel:        ctxCancel,
 		onFreeze:         func(frozenFileNames []string) {},
+		onFilesDelete:    func(deletedFileNames []string) {},
 		dir:              dir,
 		tmpdir:           tmpdir,
 		aggregationStep:  aggregationStep,
 		db:               db,
-		keepInDB:         2 * aggregationStep,
 		leakDetector:     dbg.NewLeakDetector("agg", dbg.SlowTx()),
 		ps:               background.NewProgressSet(),
+		pruneProgress:    map[string]*pruneProgress{},
 		backgroundResult: &BackgroundResult{},
+		pins:             newViewPins(),
+		accountCodec:     types.AccountCodecV3,
 		logger:           logger,
 	}
 	var err error
@@ -125,13 +160,121 @@ func NewAggregatorV3(ctx context.Context, dir, tmpdir string, aggregationStep ui
 	if a.tracesTo, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "tracesto", kv.TblTracesToKeys, kv.TblTracesToIdx, false, nil, logger); err != nil {
 		return nil, err
 	}
+	if a.txLookup, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "txlookup", kv.TblTxLookupKeys, kv.TblTxLookupIdx, false, nil, logger); err != nil {
+		return nil, err
+	}
+	a.retired = newRetiredFiles()
+	a.retired.lockDir = a.lockDirForWrite
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo, a.txLookup} {
+		ii.lockDir = a.lockDirForWrite
+		ii.retired = a.retired
+	}
+	a.keepInDB.Store(2 * aggregationStep)
 	a.recalcMaxTxNum()
+	if dir2.ReadOnlyFS(dir) {
+		logger.Info("[snapshots] dir is on read-only filesystem: files will be served as-is, without building of missed indices and merges", "dir", dir)
+		a.readonly.Store(true)
+	}
 
 	return a, nil
 }
-func (a *AggregatorV3) OnFreeze(f OnFreezeFunc) { a.onFreeze = f }
+func (a *AggregatorV3) OnFreeze(f OnFreezeFunc)           { a.onFreeze = f }
+func (a *AggregatorV3) OnFilesDelete(f OnFilesDeleteFunc) { a.onFilesDelete = f }
+
+// SetReadOnly - for processes which only serve files of dir written by another process (rpcdaemon, tools):
+// don't take writer lock of dir and refuse to build, merge or delete files.
+func (a *AggregatorV3) SetReadOnly(v bool) { a.readonly.Store(v) }
+func (a *AggregatorV3) ReadOnly() bool     { return a.readonly.Load() }
+
+var ErrAggregatorReadOnly = errors.New("aggregator is read-only")
+var errAggregatorClosed = errors.New("aggregator is closed")
+
+// PauseBackground - background files build, merge, build of optional indices and prune are not started until
+// ResumeBackground (running ones are finished). Explicit TriggerFilesBuild and MergeRange still work.
+func (a *AggregatorV3) PauseBackground()       { a.paused.Store(true) }
+func (a *AggregatorV3) ResumeBackground()      { a.paused.Store(false) }
+func (a *AggregatorV3) BackgroundPaused() bool { return a.paused.Load() }
+
+// lockDirForWrite - must be called before any destructive operation on files of a.dir.
+// Lock is held until Close.
+func (a *AggregatorV3) lockDirForWrite() error {
+	if a.readonly.Load() {
+		return ErrAggregatorReadOnly
+	}
+	a.dirLockMu.Lock()
+	defer a.dirLockMu.Unlock()
+	if a.dirLock != nil {
+		return nil
+	}
+	if a.dirLockClosed {
+		return errAggregatorClosed
+	}
+	l, err := datadir.TryLockSnapshots(a.dir)
+	if err != nil {
+		if errors.Is(err, syscall.EROFS) { // remounted after start
+			a.readonly.Store(true)
+			return ErrAggregatorReadOnly
+		}
+		return err
+	}
+	a.dirLock = l
+	return nil
+}
+
+// SetSearchDirs - additional read-only dirs with files (for example shared archive volume), searched after `dir` in order.
+// OpenFolder opens union of files. New files (and merges) are created only in `dir`, files of searchDirs are never removed.
+// Must be called before OpenFolder.
+func (a *AggregatorV3) SetSearchDirs(dirs []string) {
+	a.accounts.SetSearchDirs(dirs)
+	a.storage.SetSearchDirs(dirs)
+	a.code.SetSearchDirs(dirs)
+	a.logAddrs.SetSearchDirs(dirs)
+	a.logTopics.SetSearchDirs(dirs)
+	a.tracesFrom.SetSearchDirs(dirs)
+	a.tracesTo.SetSearchDirs(dirs)
+	a.txLookup.SetSearchDirs(dirs)
+	for _, ii := range a.extraIndices {
+		ii.SetSearchDirs(dirs)
+	}
+}
+
+// SetWatchList - "archive of my contracts" mode: new files (and merges) keep history and indices only of given
+// addresses (accounts, storage, code, logAddrs, tracesFrom, tracesTo). DB-part is pruned as usual - so history of
+// other addresses is lost when files are built. logTopics are not filtered: topic is not bound to address.
+// Reads of other addresses inside files range return ErrHistoryNotAvailable. Empty list - disables filtering.
+// Must be called before OpenFolder.
+func (a *AggregatorV3) SetWatchList(addrs [][]byte) {
+	var keep func(key []byte) bool
+	if len(addrs) > 0 {
+		watched := make(map[string]struct{}, len(addrs))
+		for _, addr := range addrs {
+			watched[string(addr)] = struct{}{}
+		}
+		keep = func(key []byte) bool { // storage key is addr+location
+			if len(key) < length.Addr {
+				return false
+			}
+			_, ok := watched[string(key[:length.Addr])]
+			return ok
+		}
+	}
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.tracesFrom, a.tracesTo} {
+		ii.keep = keep
+	}
+}
 
 func (a *AggregatorV3) OpenFolder() error {
+	if err := a.openFolder(); err != nil {
+		return err
+	}
+	// minimal-state: previous run may stop before old files were deleted (they are deleted after background merge)
+	if _, err := a.PruneOldFiles(); err != nil {
+		a.logger.Warn("[snapshots] minimal-state", "err", err)
+	}
+	return nil
+}
+
+func (a *AggregatorV3) openFolder() error {
 	a.filesMutationLock.Lock()
 	defer a.filesMutationLock.Unlock()
 	var err error
@@ -156,6 +299,14 @@ func (a *AggregatorV3) OpenFolder() error {
 	if err = a.tracesTo.OpenFolder(); err != nil {
 		return fmt.Errorf("OpenFolder: %w", err)
 	}
+	if err = a.txLookup.OpenFolder(); err != nil {
+		return fmt.Errorf("OpenFolder: %w", err)
+	}
+	for _, ii := range a.extraIndices {
+		if err = ii.OpenFolder(); err != nil {
+			return fmt.Errorf("OpenFolder: %w", err)
+		}
+	}
 	a.recalcMaxTxNum()
 	return nil
 }
@@ -185,6 +336,14 @@ func (a *AggregatorV3) OpenList(fNames []string) error {
 	if err = a.tracesTo.OpenList(fNames); err != nil {
 		return err
 	}
+	if err = a.txLookup.OpenList(fNames); err != nil {
+		return err
+	}
+	for _, ii := range a.extraIndices {
+		if err = ii.OpenList(fNames); err != nil {
+			return err
+		}
+	}
 	a.recalcMaxTxNum()
 	return nil
 }
@@ -203,12 +362,29 @@ func (a *AggregatorV3) Close() {
 	a.logTopics.Close()
 	a.tracesFrom.Close()
 	a.tracesTo.Close()
+	a.txLookup.Close()
+	for _, ii := range a.extraIndices {
+		ii.Close()
+	}
+
+	a.dirLockMu.Lock()
+	defer a.dirLockMu.Unlock()
+	if err := a.dirLock.Unlock(); err != nil {
+		a.logger.Warn("[snapshots] unlock dir", "dir", a.dir, "err", err)
+	}
+	a.dirLock, a.dirLockClosed = nil, true
 }
 
 // CleanDir - call it manually on startup of Main application (don't call it from utilities or nother processes)
 //   - remove files ignored during opening of aggregator
 //   - remove files which marked as deleted but have no readers (usually last reader removing files marked as deleted)
 func (a *AggregatorV3) CleanDir() {
+	if err := a.lockDirForWrite(); err != nil {
+		if !errors.Is(err, ErrAggregatorReadOnly) {
+			a.logger.Warn("[snapshots] skip dir cleanup", "err", err)
+		}
+		return
+	}
 	a.accounts.deleteGarbageFiles()
 	a.storage.deleteGarbageFiles()
 	a.code.deleteGarbageFiles()
@@ -216,6 +392,10 @@ func (a *AggregatorV3) CleanDir() {
 	a.logTopics.deleteGarbageFiles()
 	a.tracesFrom.deleteGarbageFiles()
 	a.tracesTo.deleteGarbageFiles()
+	a.txLookup.deleteGarbageFiles()
+	for _, ii := range a.extraIndices {
+		ii.deleteGarbageFiles()
+	}
 
 	ac := a.MakeContext()
 	defer ac.Close()
@@ -226,6 +406,10 @@ func (a *AggregatorV3) CleanDir() {
 	ac.a.logTopics.cleanAfterFreeze(ac.logTopics.frozenTo())
 	ac.a.tracesFrom.cleanAfterFreeze(ac.tracesFrom.frozenTo())
 	ac.a.tracesTo.cleanAfterFreeze(ac.tracesTo.frozenTo())
+	ac.a.txLookup.cleanAfterFreeze(ac.txLookup.frozenTo())
+	for i, ii := range ac.a.extraIndices {
+		ii.cleanAfterFreeze(ac.extraIndices[i].frozenTo())
+	}
 }
 
 func (a *AggregatorV3) SetWorkers(i int) {
@@ -236,6 +420,24 @@ func (a *AggregatorV3) SetWorkers(i int) {
 	a.logTopics.compressWorkers = i
 	a.tracesFrom.compressWorkers = i
 	a.tracesTo.compressWorkers = i
+	a.txLookup.compressWorkers = i
+	for _, ii := range a.extraIndices {
+		ii.compressWorkers = i
+	}
+}
+
+// SetAccountCodec - encoding of accounts of this aggregator's datadir. Must be the one persisted in db (see ReadAccountCodec):
+// files and tables of existing datadir are already encoded by it
+func (a *AggregatorV3) SetAccountCodec(c types.AccountCodec) { a.accountCodec = c }
+func (a *AggregatorV3) AccountCodec() types.AccountCodec     { return a.accountCodec }
+
+// ReadAccountCodec - account codec persisted in db config (kvcfg.AccountCodec), default one if datadir is not initialised yet
+func ReadAccountCodec(tx kv.Getter) (types.AccountCodec, error) {
+	name, err := kvcfg.AccountCodec.GetString(tx)
+	if err != nil {
+		return nil, err
+	}
+	return types.AccountCodecByName(name)
 }
 
 func (a *AggregatorV3) HasBackgroundFilesBuild() bool { return a.ps.Has() }
@@ -255,9 +457,16 @@ func (a *AggregatorV3) Files() (res []string) {
 	res = append(res, a.logTopics.Files()...)
 	res = append(res, a.tracesFrom.Files()...)
 	res = append(res, a.tracesTo.Files()...)
+	res = append(res, a.txLookup.Files()...)
+	for _, ii := range a.extraIndices {
+		res = append(res, ii.Files()...)
+	}
 	return res
 }
 func (a *AggregatorV3) BuildOptionalMissedIndicesInBackground(ctx context.Context, workers int) {
+	if a.readonly.Load() || a.paused.Load() {
+		return
+	}
 	if ok := a.buildingOptionalIndices.CompareAndSwap(false, true); !ok {
 		return
 	}
@@ -271,27 +480,73 @@ func (a *AggregatorV3) BuildOptionalMissedIndicesInBackground(ctx context.Contex
 			if errors.Is(err, context.Canceled) {
 				return
 			}
-			log.Warn("[snapshots] merge", "err", err)
+			a.logger.Warn("[snapshots] merge", "err", err)
 		}
 	}()
 }
 
+// BuildOptionalMissedIndices - re-build accessor indices of visible files which are missing on disk (deleted or
+// quarantined while node works - BuildMissedIndices runs only on startup), then locality indices.
 func (ac *AggregatorV3Context) BuildOptionalMissedIndices(ctx context.Context, workers int) error {
-	g, ctx := errgroup.WithContext(ctx)
+	if err := ac.a.lockDirForWrite(); err != nil {
+		return err
+	}
+	startTime := time.Now()
+	ps := background.NewProgressSet()
+	g, gCtx := errgroup.WithContext(ctx)
 	g.SetLimit(workers)
-	if ac.accounts != nil {
-		g.Go(func() error { return ac.accounts.BuildOptionalMissedIndices(ctx) })
+	var scheduled int
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		scheduled += hc.BuildOptionalMissedIndices(gCtx, g, ps)
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		scheduled += ic.BuildOptionalMissedIndices(gCtx, g, ps)
+	}
+	if scheduled > 0 {
+		ac.a.logger.Warn("[snapshots] accessor indices are missing, re-building", "files", scheduled)
+		go func() {
+			logEvery := time.NewTicker(20 * time.Second)
+			defer logEvery.Stop()
+			for {
+				select {
+				case <-gCtx.Done():
+					return
+				case <-logEvery.C:
+					ac.a.logger.Info("[snapshots] Indexing", "progress", ps.String(), "took", time.Since(startTime).Round(time.Second))
+				}
+			}
+		}()
 	}
-	if ac.storage != nil {
-		g.Go(func() error { return ac.storage.BuildOptionalMissedIndices(ctx) })
+	if err := g.Wait(); err != nil {
+		return err
 	}
-	if ac.code != nil {
-		g.Go(func() error { return ac.code.BuildOptionalMissedIndices(ctx) })
+	if scheduled > 0 {
+		if err := ac.a.OpenFolder(); err != nil {
+			return err
+		}
+		ac.a.logger.Info("[snapshots] missing accessor indices re-built", "files", scheduled, "took", time.Since(startTime).Round(time.Second))
+	}
+
+	g, ctx = errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		hc := hc
+		g.Go(func() error { return hc.h.localityIndex.BuildMissedIndices(ctx, hc.ic) })
 	}
 	return g.Wait()
 }
 
 func (a *AggregatorV3) BuildMissedIndices(ctx context.Context, workers int) error {
+	if err := a.lockDirForWrite(); err != nil {
+		if errors.Is(err, ErrAggregatorReadOnly) { // files without indices are not visible - serve what we have
+			a.logger.Warn("[snapshots] read-only: skip building of missed indices", "dir", a.dir)
+			return nil
+		}
+		return err
+	}
+	for _, ii := range a.invertedIndices() { // builds all missing: quarantined ones too
+		ii.indexRebuild.Store(false)
+	}
 	startIndexingTime := time.Now()
 	{
 		ps := background.NewProgressSet()
@@ -308,7 +563,7 @@ func (a *AggregatorV3) BuildMissedIndices(ctx context.Context, workers int) erro
 				case <-logEvery.C:
 					var m runtime.MemStats
 					dbg.ReadMemStats(&m)
-					log.Info("[snapshots] Indexing", "progress", ps.String(), "total-indexing-time", time.Since(startIndexingTime).Round(time.Second).String(), "alloc", common2.ByteCount(m.Alloc), "sys", common2.ByteCount(m.Sys))
+					a.logger.Info("[snapshots] Indexing", "progress", ps.String(), "total-indexing-time", time.Since(startIndexingTime).Round(time.Second).String(), "alloc", common2.ByteCount(m.Alloc), "sys", common2.ByteCount(m.Sys))
 				}
 			}
 		}()
@@ -320,6 +575,10 @@ func (a *AggregatorV3) BuildMissedIndices(ctx context.Context, workers int) erro
 		a.logTopics.BuildMissedIndices(ctx, g, ps)
 		a.tracesFrom.BuildMissedIndices(ctx, g, ps)
 		a.tracesTo.BuildMissedIndices(ctx, g, ps)
+		a.txLookup.BuildMissedIndices(ctx, g, ps)
+		for _, ii := range a.extraIndices {
+			ii.BuildMissedIndices(ctx, g, ps)
+		}
 
 		if err := g.Wait(); err != nil {
 			return err
@@ -345,6 +604,10 @@ func (a *AggregatorV3) SetTx(tx kv.RwTx) {
 	a.logTopics.SetTx(tx)
 	a.tracesFrom.SetTx(tx)
 	a.tracesTo.SetTx(tx)
+	a.txLookup.SetTx(tx)
+	for _, ii := range a.extraIndices {
+		ii.SetTx(tx)
+	}
 }
 
 func (a *AggregatorV3) SetTxNum(txNum uint64) {
@@ -355,6 +618,10 @@ func (a *AggregatorV3) SetTxNum(txNum uint64) {
 	a.logTopics.SetTxNum(txNum)
 	a.tracesFrom.SetTxNum(txNum)
 	a.tracesTo.SetTxNum(txNum)
+	a.txLookup.SetTxNum(txNum)
+	for _, ii := range a.extraIndices {
+		ii.SetTxNum(txNum)
+	}
 }
 
 type AggV3Collation struct {
@@ -362,6 +629,8 @@ type AggV3Collation struct {
 	logTopics  map[string]*roaring64.Bitmap
 	tracesFrom map[string]*roaring64.Bitmap
 	tracesTo   map[string]*roaring64.Bitmap
+	txLookup   map[string]*roaring64.Bitmap
+	extra      []map[string]*roaring64.Bitmap // of AggregatorV3.extraIndices
 	accounts   HistoryCollation
 	storage    HistoryCollation
 	code       HistoryCollation
@@ -384,6 +653,14 @@ func (c AggV3Collation) Close() {
 	for _, b := range c.tracesTo {
 		bitmapdb.ReturnToPool64(b)
 	}
+	for _, b := range c.txLookup {
+		bitmapdb.ReturnToPool64(b)
+	}
+	for _, bitmaps := range c.extra {
+		for _, b := range bitmaps {
+			bitmapdb.ReturnToPool64(b)
+		}
+	}
 }
 
 func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64) (AggV3StaticFiles, error) {
@@ -392,6 +669,8 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64
 	//defer func(t time.Time) {
 	//	log.Info(fmt.Sprintf("[snapshot] build %d-%d", step, step+1), "took", time.Since(t))
 	//}(time.Now())
+	ctx, span := startSpan(ctx, "build_step", "all", txFrom, txTo, a.aggregationStep) // parent of collate/build spans
+	defer span.End()
 	var sf AggV3StaticFiles
 	var ac AggV3Collation
 	closeColl := true
@@ -407,7 +686,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64
 	//	defer wg.Done()
 	var err error
 	if err = a.db.View(ctx, func(tx kv.Tx) error {
-		ac.accounts, err = a.accounts.collate(step, txFrom, txTo, tx)
+		ac.accounts, err = a.accounts.collate(ctx, step, txFrom, txTo, tx)
 		return err
 	}); err != nil {
 		return sf, err
@@ -424,7 +703,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64
 	//	defer wg.Done()
 	//	var err error
 	if err = a.db.View(ctx, func(tx kv.Tx) error {
-		ac.storage, err = a.storage.collate(step, txFrom, txTo, tx)
+		ac.storage, err = a.storage.collate(ctx, step, txFrom, txTo, tx)
 		return err
 	}); err != nil {
 		return sf, err
@@ -440,7 +719,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64
 	//	defer wg.Done()
 	//	var err error
 	if err = a.db.View(ctx, func(tx kv.Tx) error {
-		ac.code, err = a.code.collate(step, txFrom, txTo, tx)
+		ac.code, err = a.code.collate(ctx, step, txFrom, txTo, tx)
 		return err
 	}); err != nil {
 		return sf, err
@@ -517,6 +796,38 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step, txFrom, txTo uint64
 	}
 	//}()
 	//go func() {
+	//	defer wg.Done()
+	//	var err error
+	if err = a.db.View(ctx, func(tx kv.Tx) error {
+		ac.txLookup, err = a.txLookup.collate(ctx, txFrom, txTo, tx)
+		return err
+	}); err != nil {
+		return sf, err
+		//errCh <- err
+	}
+
+	if sf.txLookup, err = a.txLookup.buildFiles(ctx, step, ac.txLookup, a.ps); err != nil {
+		return sf, err
+		//		errCh <- err
+	}
+	ac.extra = make([]map[string]*roaring64.Bitmap, len(a.extraIndices))
+	sf.extra = make([]InvertedFiles, len(a.extraIndices))
+	for i, ii := range a.extraIndices {
+		if err = a.db.View(ctx, func(tx kv.Tx) error {
+			ac.extra[i], err = ii.collate(ctx, txFrom, txTo, tx)
+			return err
+		}); err != nil {
+			return sf, err
+		}
+		if sf.extra[i], err = ii.buildFiles(ctx, step, ac.extra[i], a.ps); err != nil {
+			return sf, err
+		}
+	}
+	if err = chaos(chaosBuildFiles); err != nil {
+		return sf, err
+	}
+	//}()
+	//go func() {
 	//	wg.Wait()
 	//close(errCh)
 	//}()
@@ -540,6 +851,8 @@ type AggV3StaticFiles struct {
 	logTopics  InvertedFiles
 	tracesFrom InvertedFiles
 	tracesTo   InvertedFiles
+	txLookup   InvertedFiles
+	extra      []InvertedFiles // of AggregatorV3.extraIndices
 }
 
 func (sf AggV3StaticFiles) Close() {
@@ -550,6 +863,10 @@ func (sf AggV3StaticFiles) Close() {
 	sf.logTopics.Close()
 	sf.tracesFrom.Close()
 	sf.tracesTo.Close()
+	sf.txLookup.Close()
+	for _, f := range sf.extra {
+		f.Close()
+	}
 }
 
 func (a *AggregatorV3) BuildFiles(toTxNum uint64) (err error) {
@@ -570,7 +887,7 @@ Loop:
 				break Loop
 			}
 			if a.HasBackgroundFilesBuild() {
-				log.Info("[snapshots] Files build", "progress", a.BackgroundProgress())
+				a.logger.Info("[snapshots] Files build", "progress", a.BackgroundProgress())
 			}
 		}
 	}
@@ -579,10 +896,14 @@ Loop:
 }
 
 func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64) (err error) {
+	if err := a.lockDirForWrite(); err != nil {
+		return err
+	}
 	closeAll := true
 	//log.Info("[snapshots] history build", "step", fmt.Sprintf("%d-%d", step, step+1))
 	sf, err := a.buildFiles(ctx, step, step*a.aggregationStep, (step+1)*a.aggregationStep)
 	if err != nil {
+		sf.Close() // files which were built before error
 		return err
 	}
 	defer func() {
@@ -590,6 +911,9 @@ func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64)
 			sf.Close()
 		}
 	}()
+	if err = chaos(chaosIntegrateFiles); err != nil {
+		return err
+	}
 	a.integrateFiles(sf, step*a.aggregationStep, (step+1)*a.aggregationStep)
 	//a.notifyAboutNewSnapshots()
 
@@ -598,6 +922,9 @@ func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64)
 }
 
 func (a *AggregatorV3) mergeLoopStep(ctx context.Context, workers int) (somethingDone bool, err error) {
+	if err := a.lockDirForWrite(); err != nil {
+		return false, err
+	}
 	ac := a.MakeContext() // this need, to ensure we do all operations on files in "transaction-style", maybe we will ensure it on type-level in future
 	defer ac.Close()
 
@@ -627,10 +954,13 @@ func (a *AggregatorV3) mergeLoopStep(ctx context.Context, workers int) (somethin
 			in.Close()
 		}
 	}()
+	if err = chaos(chaosMergeFiles); err != nil {
+		return true, err
+	}
 	a.integrateMergedFiles(outs, in)
 	a.onFreeze(in.FrozenList())
 	closeAll = false
-	return true, nil
+	return true, chaos(chaosIntegrateMergedFiles)
 }
 func (a *AggregatorV3) MergeLoop(ctx context.Context, workers int) error {
 	for {
@@ -644,6 +974,133 @@ func (a *AggregatorV3) MergeLoop(ctx context.Context, workers int) error {
 	}
 }
 
+// MergeRange - synchronously merge files of one component (accounts, storage, code, logaddrs, logtopics, tracesfrom, tracesto, txlookup)
+// in steps [fromStep, toStep). Files must fully cover range. For histories .v and .ef files are merged together:
+// .vi of merged history is built from merged .ef.
+// Range must be one which background merge produces (see checkMergeAlignment).
+// Background merge uses same code, this is for operators who want to catch-up merges during maintenance windows.
+func (a *AggregatorV3) MergeRange(ctx context.Context, name string, fromStep, toStep uint64, workers int) (merged []string, err error) {
+	if err := checkMergeAlignment(fromStep, toStep); err != nil {
+		return nil, err
+	}
+	from, to := fromStep*a.aggregationStep, toStep*a.aggregationStep
+	if err := a.lockDirForWrite(); err != nil {
+		return nil, err
+	}
+	if ok := a.mergeingFiles.CompareAndSwap(false, true); !ok {
+		return nil, errors.New("merge: other merge is in progress")
+	}
+	defer a.mergeingFiles.Store(false)
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	var r RangesV3
+	historyRange := func(hc *HistoryContext) (HistoryRanges, error) {
+		if err := checkMergeCoverage(hc.files, from, to, a.aggregationStep); err != nil {
+			return HistoryRanges{}, err
+		}
+		return HistoryRanges{history: true, historyStartTxNum: from, historyEndTxNum: to, index: true, indexStartTxNum: from, indexEndTxNum: to}, nil
+	}
+	switch name {
+	case "accounts":
+		r.accounts, err = historyRange(ac.accounts)
+	case "storage":
+		r.storage, err = historyRange(ac.storage)
+	case "code":
+		r.code, err = historyRange(ac.code)
+	case "logaddrs":
+		err = checkMergeCoverage(ac.logAddrs.files, from, to, a.aggregationStep)
+		r.logAddrs, r.logAddrsStartTxNum, r.logAddrsEndTxNum = true, from, to
+	case "logtopics":
+		err = checkMergeCoverage(ac.logTopics.files, from, to, a.aggregationStep)
+		r.logTopics, r.logTopicsStartTxNum, r.logTopicsEndTxNum = true, from, to
+	case "tracesfrom":
+		err = checkMergeCoverage(ac.tracesFrom.files, from, to, a.aggregationStep)
+		r.tracesFrom, r.tracesFromStartTxNum, r.tracesFromEndTxNum = true, from, to
+	case "tracesto":
+		err = checkMergeCoverage(ac.tracesTo.files, from, to, a.aggregationStep)
+		r.tracesTo, r.tracesToStartTxNum, r.tracesToEndTxNum = true, from, to
+	case "txlookup":
+		err = checkMergeCoverage(ac.txLookup.files, from, to, a.aggregationStep)
+		r.txLookup, r.txLookupStartTxNum, r.txLookupEndTxNum = true, from, to
+	default:
+		i, ok := a.extraIndexByName(name)
+		if !ok {
+			return nil, fmt.Errorf("merge: unknown component %q", name)
+		}
+		err = checkMergeCoverage(ac.extraIndices[i].files, from, to, a.aggregationStep)
+		r.extra = make([]extraRange, len(a.extraIndices))
+		r.extra[i] = extraRange{needMerge: true, startTxNum: from, endTxNum: to}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	closeAll := true
+	outs, err := ac.staticFilesInRange(r)
+	defer func() {
+		if closeAll {
+			outs.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	in, err := ac.mergeFiles(ctx, outs, r, workers)
+	if err != nil {
+		return nil, err
+	}
+	a.integrateMergedFiles(outs, in)
+	a.onFreeze(in.FrozenList())
+	closeAll = false
+	for _, item := range append([]*filesItem{in.accountsIdx, in.accountsHist, in.storageIdx, in.storageHist, in.codeIdx, in.codeHist, in.logAddrs, in.logTopics, in.tracesFrom, in.tracesTo, in.txLookup}, in.extra...) {
+		if item != nil {
+			merged = append(merged, item.decompressor.FileName())
+		}
+	}
+	return merged, nil
+}
+
+// checkMergeAlignment - [fromStep, toStep) must be range of file which background merge produces (findMergeRange):
+// amount of steps is power of 2, not bigger than StepsInBiggestFile, and fromStep is multiple of it. Files of other
+// ranges would cross borders of files merged later - and never be merged further.
+func checkMergeAlignment(fromStep, toStep uint64) error {
+	if fromStep >= toStep {
+		return fmt.Errorf("merge: empty range %d-%d", fromStep, toStep)
+	}
+	span := toStep - fromStep
+	if span&(span-1) != 0 || fromStep%span != 0 || span > StepsInBiggestFile {
+		return fmt.Errorf("merge: range %d-%d is not aligned: amount of steps must be power of 2 (max %d) and from-step multiple of it", fromStep, toStep, StepsInBiggestFile)
+	}
+	return nil
+}
+
+// checkMergeCoverage - visible files must cover [from, to) without gaps, no file may cross range borders,
+// and there must be something to merge
+func checkMergeCoverage(files []ctxItem, from, to, aggStep uint64) error {
+	covered, n := from, 0
+	for _, item := range files {
+		if item.endTxNum <= from || item.startTxNum >= to {
+			continue
+		}
+		if item.startTxNum < from || item.endTxNum > to {
+			return fmt.Errorf("merge: file %d-%d crosses border of range %d-%d", item.startTxNum/aggStep, item.endTxNum/aggStep, from/aggStep, to/aggStep)
+		}
+		if item.startTxNum != covered {
+			return fmt.Errorf("merge: no files for steps %d-%d", covered/aggStep, item.startTxNum/aggStep)
+		}
+		covered = item.endTxNum
+		n++
+	}
+	if covered != to {
+		return fmt.Errorf("merge: no files for steps %d-%d", covered/aggStep, to/aggStep)
+	}
+	if n < 2 {
+		return fmt.Errorf("merge: range %d-%d is already one file", from/aggStep, to/aggStep)
+	}
+	return nil
+}
+
 func (a *AggregatorV3) integrateFiles(sf AggV3StaticFiles, txNumFrom, txNumTo uint64) {
 	a.filesMutationLock.Lock()
 	defer a.filesMutationLock.Unlock()
@@ -656,6 +1113,10 @@ func (a *AggregatorV3) integrateFiles(sf AggV3StaticFiles, txNumFrom, txNumTo ui
 	a.logTopics.integrateFiles(sf.logTopics, txNumFrom, txNumTo)
 	a.tracesFrom.integrateFiles(sf.tracesFrom, txNumFrom, txNumTo)
 	a.tracesTo.integrateFiles(sf.tracesTo, txNumFrom, txNumTo)
+	a.txLookup.integrateFiles(sf.txLookup, txNumFrom, txNumTo)
+	for i, ii := range a.extraIndices {
+		ii.integrateFiles(sf.extra[i], txNumFrom, txNumTo)
+	}
 }
 
 func (a *AggregatorV3) HasNewFrozenFiles() bool {
@@ -665,29 +1126,56 @@ func (a *AggregatorV3) HasNewFrozenFiles() bool {
 	return a.needSaveFilesListInDB.CompareAndSwap(true, false)
 }
 
+// Unwind - removes from DB all history and indices written since `txUnwindTo`.
+// Components touch disjoint tables, but all of them are unwound sequentially: they share one RwTx,
+// and MDBX write transactions can't be used from multiple goroutines.
 func (a *AggregatorV3) Unwind(ctx context.Context, txUnwindTo uint64) error {
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
-	if err := a.accounts.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
-	}
-	if err := a.storage.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
-	}
-	if err := a.code.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
-	}
-	if err := a.logAddrs.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
-	}
-	if err := a.logTopics.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
+	components := []struct {
+		name  string
+		prune func(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error
+	}{
+		{a.accounts.filenameBase, a.accounts.prune},
+		{a.storage.filenameBase, a.storage.prune},
+		{a.code.filenameBase, a.code.prune},
+		{a.logAddrs.filenameBase, a.logAddrs.prune},
+		{a.logTopics.filenameBase, a.logTopics.prune},
+		{a.tracesFrom.filenameBase, a.tracesFrom.prune},
+		{a.tracesTo.filenameBase, a.tracesTo.prune},
+		{a.txLookup.filenameBase, a.txLookup.prune},
+	}
+	for _, ii := range a.extraIndices {
+		components = append(components, struct {
+			name  string
+			prune func(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error
+		}{ii.filenameBase, ii.prune})
+	}
+	started := time.Now()
+	for i, c := range components {
+		t := time.Now()
+		if err := c.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
+			return fmt.Errorf("unwind %s: %w", c.name, err)
+		}
+		a.logger.Debug("[snapshots] unwind", "name", c.name, "progress", fmt.Sprintf("%d/%d", i+1, len(components)), "took", time.Since(t))
 	}
-	if err := a.tracesFrom.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
+	for _, ii := range a.invertedIndices() { // re-executed txs must be filtered again
+		if ii.pruneKeep == nil {
+			continue
+		}
+		name := ii.filenameBase + pruneKeysProgressSuffix
+		saved, ok, err := readPruneProgress(a.rwTx, name)
+		if err != nil {
+			return err
+		}
+		if ok && saved > txUnwindTo {
+			if err := writePruneProgress(a.rwTx, name, txUnwindTo); err != nil {
+				return err
+			}
+		}
 	}
-	if err := a.tracesTo.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
-		return err
+	if took := time.Since(started); took > 5*time.Second {
+		a.logger.Info("[snapshots] unwind done", "to_txnum", txUnwindTo, "took", took)
 	}
 	return nil
 }
@@ -718,6 +1206,15 @@ func (a *AggregatorV3) Warmup(ctx context.Context, txFrom, limit uint64) error {
 	e.Go(func() error {
 		return a.db.View(ctx, func(tx kv.Tx) error { return a.tracesTo.warmup(ctx, txFrom, limit, tx) })
 	})
+	e.Go(func() error {
+		return a.db.View(ctx, func(tx kv.Tx) error { return a.txLookup.warmup(ctx, txFrom, limit, tx) })
+	})
+	for _, ii := range a.extraIndices {
+		ii := ii
+		e.Go(func() error {
+			return a.db.View(ctx, func(tx kv.Tx) error { return ii.warmup(ctx, txFrom, limit, tx) })
+		})
+	}
 	return e.Wait()
 }
 
@@ -730,6 +1227,10 @@ func (a *AggregatorV3) DiscardHistory() *AggregatorV3 {
 	a.logTopics.DiscardHistory(a.tmpdir)
 	a.tracesFrom.DiscardHistory(a.tmpdir)
 	a.tracesTo.DiscardHistory(a.tmpdir)
+	a.txLookup.DiscardHistory(a.tmpdir)
+	for _, ii := range a.extraIndices {
+		ii.DiscardHistory(a.tmpdir)
+	}
 	return a
 }
 
@@ -744,6 +1245,10 @@ func (a *AggregatorV3) StartWrites() *AggregatorV3 {
 	a.logTopics.StartWrites()
 	a.tracesFrom.StartWrites()
 	a.tracesTo.StartWrites()
+	a.txLookup.StartWrites()
+	for _, ii := range a.extraIndices {
+		ii.StartWrites()
+	}
 	return a
 }
 func (a *AggregatorV3) StartUnbufferedWrites() *AggregatorV3 {
@@ -756,6 +1261,10 @@ func (a *AggregatorV3) StartUnbufferedWrites() *AggregatorV3 {
 	a.logTopics.StartWrites()
 	a.tracesFrom.StartWrites()
 	a.tracesTo.StartWrites()
+	a.txLookup.StartWrites()
+	for _, ii := range a.extraIndices {
+		ii.StartWrites()
+	}
 	return a
 }
 func (a *AggregatorV3) FinishWrites() {
@@ -768,6 +1277,10 @@ func (a *AggregatorV3) FinishWrites() {
 	a.logTopics.FinishWrites()
 	a.tracesFrom.FinishWrites()
 	a.tracesTo.FinishWrites()
+	a.txLookup.FinishWrites()
+	for _, ii := range a.extraIndices {
+		ii.FinishWrites()
+	}
 }
 
 type flusher interface {
@@ -777,7 +1290,7 @@ type flusher interface {
 func (a *AggregatorV3) rotate() []flusher {
 	a.walLock.Lock()
 	defer a.walLock.Unlock()
-	return []flusher{
+	flushers := []flusher{
 		a.accounts.Rotate(),
 		a.storage.Rotate(),
 		a.code.Rotate(),
@@ -785,11 +1298,16 @@ func (a *AggregatorV3) rotate() []flusher {
 		a.logTopics.Rotate(),
 		a.tracesFrom.Rotate(),
 		a.tracesTo.Rotate(),
+		a.txLookup.Rotate(),
 	}
+	for _, ii := range a.extraIndices {
+		flushers = append(flushers, ii.Rotate())
+	}
+	return flushers
 }
 func (a *AggregatorV3) Flush(ctx context.Context, tx kv.RwTx) error {
 	flushers := a.rotate()
-	defer func(t time.Time) { log.Debug("[snapshots] history flush", "took", time.Since(t)) }(time.Now())
+	defer func(t time.Time) { a.logger.Debug("[snapshots] history flush", "took", time.Since(t)) }(time.Now())
 	for _, f := range flushers {
 		if err := f.Flush(ctx, tx); err != nil {
 			return err
@@ -798,21 +1316,59 @@ func (a *AggregatorV3) Flush(ctx context.Context, tx kv.RwTx) error {
 	return nil
 }
 
+// CanPrune - any history or index has data in DB which is already in files: each of them is evaluated
+// with own keepStepsInDB, the same way as prune does
 func (a *AggregatorV3) CanPrune(tx kv.Tx) bool {
-	return a.CanPruneFrom(tx) < a.minimaxTxNumInFiles.Load()
+	if a.paused.Load() {
+		return false
+	}
+	pruneTo := a.pruneTo()
+	for _, ii := range a.invertedIndices() {
+		txTo := pruneTo
+		if keep := ii.keepStepsInDB.Load() * a.aggregationStep; keep > 0 {
+			if txTo <= keep {
+				continue
+			}
+			txTo -= keep
+		}
+		fst, _ := kv.FirstKey(tx, ii.indexKeysTable)
+		if len(fst) >= 8 && binary.BigEndian.Uint64(fst) < txTo {
+			return true
+		}
+	}
+	return false
 }
+
+// pruneTo - data in DB below this txNum is already in files and not needed by any pinned view
+func (a *AggregatorV3) pruneTo() uint64 {
+	return cmp.Min(a.minimaxTxNumInFiles.Load(), a.pins.pruneLimit())
+}
+
+// CanPruneFrom - smallest txNum in DB among all histories and indices, MaxUint64 if DB has no data
 func (a *AggregatorV3) CanPruneFrom(tx kv.Tx) uint64 {
-	fst, _ := kv.FirstKey(tx, kv.TblTracesToKeys)
-	fst2, _ := kv.FirstKey(tx, kv.TblStorageHistoryKeys)
-	if len(fst) > 0 && len(fst2) > 0 {
-		fstInDb := binary.BigEndian.Uint64(fst)
-		fstInDb2 := binary.BigEndian.Uint64(fst2)
-		return cmp.Min(fstInDb, fstInDb2)
+	from := uint64(math2.MaxUint64)
+	for _, ii := range a.invertedIndices() {
+		fst, _ := kv.FirstKey(tx, ii.indexKeysTable)
+		if len(fst) >= 8 {
+			from = cmp.Min(from, binary.BigEndian.Uint64(fst))
+		}
 	}
-	return math2.MaxUint64
+	return from
+}
+
+// RequestPrune - next PruneWithTiemout (done by exec stage) prunes with time budget of at least `budget`
+func (a *AggregatorV3) RequestPrune(budget time.Duration) error {
+	if a.readonly.Load() {
+		return ErrAggregatorReadOnly
+	}
+	a.pruneBudget.Store(int64(budget))
+	return nil
 }
 
 func (a *AggregatorV3) PruneWithTiemout(ctx context.Context, timeout time.Duration) error {
+	if budget := time.Duration(a.pruneBudget.Swap(0)); budget > timeout {
+		timeout = budget
+	}
 	t := time.Now()
 	for a.CanPrune(a.rwTx) && time.Since(t) < timeout {
 		if err := a.Prune(ctx, 1_000); err != nil { // prune part of retired data, before commit
@@ -823,7 +1379,7 @@ func (a *AggregatorV3) PruneWithTiemout(ctx context.Context, timeout time.Durati
 }
 
 func (a *AggregatorV3) StepsRangeInDBAsStr(tx kv.Tx) string {
-	return strings.Join([]string{
+	steps := []string{
 		a.accounts.stepsRangeInDBAsStr(tx),
 		a.storage.stepsRangeInDBAsStr(tx),
 		a.code.stepsRangeInDBAsStr(tx),
@@ -831,7 +1387,28 @@ func (a *AggregatorV3) StepsRangeInDBAsStr(tx kv.Tx) string {
 		a.logTopics.stepsRangeInDBAsStr(tx),
 		a.tracesFrom.stepsRangeInDBAsStr(tx),
 		a.tracesTo.stepsRangeInDBAsStr(tx),
-	}, ", ")
+		a.txLookup.stepsRangeInDBAsStr(tx),
+	}
+	for _, ii := range a.extraIndices {
+		steps = append(steps, ii.stepsRangeInDBAsStr(tx))
+	}
+	return strings.Join(steps, ", ")
+}
+
+// UpdateLagMetrics - exports per-domain gauges of data which is waiting for prune and for files build: growth of them
+// means stuck prune or merge loop
+func (a *AggregatorV3) UpdateLagMetrics(tx kv.Tx, headTxNum uint64) {
+	a.accounts.updateLagMetrics(tx, headTxNum)
+	a.storage.updateLagMetrics(tx, headTxNum)
+	a.code.updateLagMetrics(tx, headTxNum)
+	a.logAddrs.updateLagMetrics(tx, headTxNum)
+	a.logTopics.updateLagMetrics(tx, headTxNum)
+	a.tracesFrom.updateLagMetrics(tx, headTxNum)
+	a.tracesTo.updateLagMetrics(tx, headTxNum)
+	a.txLookup.updateLagMetrics(tx, headTxNum)
+	for _, ii := range a.extraIndices {
+		ii.updateLagMetrics(tx, headTxNum)
+	}
 }
 
 func (a *AggregatorV3) Prune(ctx context.Context, limit uint64) error {
@@ -845,34 +1422,126 @@ func (a *AggregatorV3) Prune(ctx context.Context, limit uint64) error {
 	//		_ = a.Warmup(ctx, 0, cmp.Max(a.aggregationStep, limit)) // warmup is asyn and moving faster than data deletion
 	//	}()
 	//}
-	return a.prune(ctx, 0, a.minimaxTxNumInFiles.Load(), limit)
+	if a.paused.Load() {
+		return nil
+	}
+	return a.prune(ctx, 0, a.pruneTo(), limit)
+}
+
+type pruner interface {
+	prune(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error
 }
 
+// prune - resumable: first not-pruned txNum of each history/inverted index is persisted in kv.TblPruningProgress.
+// Long prunes (after long offline) are done by many small `limit`-chunks in many transactions - so it's also shown in BackgroundProgress
 func (a *AggregatorV3) prune(ctx context.Context, txFrom, txTo, limit uint64) error {
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
-	if err := a.accounts.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
-	}
-	if err := a.storage.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	type component struct {
+		name, keysTable string
+		p               pruner
+		ii              *InvertedIndex
+	}
+	components := []component{
+		{a.accounts.filenameBase, a.accounts.indexKeysTable, a.accounts, a.accounts.InvertedIndex},
+		{a.storage.filenameBase, a.storage.indexKeysTable, a.storage, a.storage.InvertedIndex},
+		{a.code.filenameBase, a.code.indexKeysTable, a.code, a.code.InvertedIndex},
+		{a.logAddrs.filenameBase, a.logAddrs.indexKeysTable, a.logAddrs, a.logAddrs},
+		{a.logTopics.filenameBase, a.logTopics.indexKeysTable, a.logTopics, a.logTopics},
+		{a.tracesFrom.filenameBase, a.tracesFrom.indexKeysTable, a.tracesFrom, a.tracesFrom},
+		{a.tracesTo.filenameBase, a.tracesTo.indexKeysTable, a.tracesTo, a.tracesTo},
+		{a.txLookup.filenameBase, a.txLookup.indexKeysTable, a.txLookup, a.txLookup},
+	}
+	for _, ii := range a.extraIndices {
+		components = append(components, component{ii.filenameBase, ii.indexKeysTable, ii, ii})
+	}
+	for _, c := range components {
+		txTo := txTo
+		if keep := c.ii.keepStepsInDB.Load() * a.aggregationStep; keep > 0 {
+			if txTo <= keep {
+				continue
+			}
+			txTo -= keep
+		}
+		from := txFrom
+		saved, ok, err := readPruneProgress(a.rwTx, c.name)
+		if err != nil {
+			return err
+		}
+		if ok && saved > from {
+			from = saved
+		}
+		if from >= txTo {
+			continue
+		}
+		if err := c.p.prune(ctx, from, txTo, limit, logEvery); err != nil {
+			return err
+		}
+		next := txTo
+		fst, err := kv.FirstKey(a.rwTx, c.keysTable)
+		if err != nil {
+			return err
+		}
+		if len(fst) >= 8 {
+			next = cmp.Min(next, binary.BigEndian.Uint64(fst))
+		}
+		if !ok || next != saved {
+			if err := writePruneProgress(a.rwTx, c.name, next); err != nil {
+				return err
+			}
+		}
+		a.updatePruneProgress(c.name, from, next, txTo)
 	}
-	if err := a.code.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	return a.pruneIdxKeys(ctx, logEvery)
+}
+
+type pruneProgress struct {
+	p         *background.Progress
+	startFrom uint64 // txNum where prune did fall behind
+}
+
+// updatePruneProgress - shows progress of all chunks since prune did fall behind more than 1 step
+func (a *AggregatorV3) updatePruneProgress(name string, from, next, txTo uint64) {
+	pp, ok := a.pruneProgress[name]
+	if next >= txTo {
+		if ok {
+			a.ps.Delete(pp.p)
+			delete(a.pruneProgress, name)
+		}
+		return
 	}
-	if err := a.logAddrs.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	if !ok {
+		if txTo-from <= a.aggregationStep { // not behind: don't spam BackgroundProgress
+			return
+		}
+		pp = &pruneProgress{p: a.ps.AddNew("prune "+name, 0), startFrom: from}
+		a.pruneProgress[name] = pp
 	}
-	if err := a.logTopics.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	if next < pp.startFrom {
+		pp.startFrom = next
 	}
-	if err := a.tracesFrom.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	pp.p.Total.Store(txTo - pp.startFrom)
+	pp.p.Processed.Store(next - pp.startFrom)
+}
+
+// pruneKeysProgressSuffix - key of kv.TblPruningProgress for progress of SetPruneIdxKeys filter is name of index + suffix
+const pruneKeysProgressSuffix = "-keys"
+
+func readPruneProgress(tx kv.Getter, name string) (txNum uint64, ok bool, err error) {
+	v, err := tx.GetOne(kv.TblPruningProgress, []byte(name))
+	if err != nil {
+		return 0, false, err
 	}
-	if err := a.tracesTo.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
-		return err
+	if len(v) < 8 {
+		return 0, false, nil
 	}
-	return nil
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+func writePruneProgress(tx kv.Putter, name string, txNum uint64) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], txNum)
+	return tx.Put(kv.TblPruningProgress, []byte(name), v[:])
 }
 
 func (a *AggregatorV3) LogStats(tx kv.Tx, tx2block func(endTxNumMinimax uint64) uint64) {
@@ -906,7 +1575,7 @@ func (a *AggregatorV3) LogStats(tx kv.Tx, tx2block func(endTxNumMinimax uint64)
 
 	var m runtime.MemStats
 	dbg.ReadMemStats(&m)
-	log.Info("[snapshots] History Stat",
+	a.logger.Info("[snapshots] History Stat",
 		"blocks", fmt.Sprintf("%dk", (histBlockNumProgress+1)/1000),
 		"txs", fmt.Sprintf("%dm", a.minimaxTxNumInFiles.Load()/1_000_000),
 		"txNum2blockNum", strings.Join(str, ","),
@@ -944,6 +1613,8 @@ func (a *AggregatorV3) recalcMaxTxNum() {
 	if txNum := a.tracesTo.endTxNumMinimax(); txNum < min {
 		min = txNum
 	}
+	// txLookup is not here: datadirs created before it have no such files, and lookups fall back to TxLookup table.
+	// Same for extraIndices: registered on existing datadir - they have files only since registration.
 	a.minimaxTxNumInFiles.Store(min)
 }
 
@@ -959,14 +1630,28 @@ type RangesV3 struct {
 	tracesFromEndTxNum   uint64
 	tracesToStartTxNum   uint64
 	tracesToEndTxNum     uint64
+	txLookupStartTxNum   uint64
+	txLookupEndTxNum     uint64
 	logAddrs             bool
 	logTopics            bool
 	tracesFrom           bool
 	tracesTo             bool
+	txLookup             bool
+	extra                []extraRange // of AggregatorV3.extraIndices, nil - nothing to merge
+}
+
+type extraRange struct {
+	needMerge            bool
+	startTxNum, endTxNum uint64
 }
 
 func (r RangesV3) any() bool {
-	return r.accounts.any() || r.storage.any() || r.code.any() || r.logAddrs || r.logTopics || r.tracesFrom || r.tracesTo
+	for _, e := range r.extra {
+		if e.needMerge {
+			return true
+		}
+	}
+	return r.accounts.any() || r.storage.any() || r.code.any() || r.logAddrs || r.logTopics || r.tracesFrom || r.tracesTo || r.txLookup
 }
 
 func (ac *AggregatorV3Context) findMergeRange(maxEndTxNum, maxSpan uint64) RangesV3 {
@@ -978,6 +1663,11 @@ func (ac *AggregatorV3Context) findMergeRange(maxEndTxNum, maxSpan uint64) Range
 	r.logTopics, r.logTopicsStartTxNum, r.logTopicsEndTxNum = ac.a.logTopics.findMergeRange(maxEndTxNum, maxSpan)
 	r.tracesFrom, r.tracesFromStartTxNum, r.tracesFromEndTxNum = ac.a.tracesFrom.findMergeRange(maxEndTxNum, maxSpan)
 	r.tracesTo, r.tracesToStartTxNum, r.tracesToEndTxNum = ac.a.tracesTo.findMergeRange(maxEndTxNum, maxSpan)
+	r.txLookup, r.txLookupStartTxNum, r.txLookupEndTxNum = ac.a.txLookup.findMergeRange(maxEndTxNum, maxSpan)
+	r.extra = make([]extraRange, len(ac.a.extraIndices))
+	for i, ii := range ac.a.extraIndices {
+		r.extra[i].needMerge, r.extra[i].startTxNum, r.extra[i].endTxNum = ii.findMergeRange(maxEndTxNum, maxSpan)
+	}
 	//log.Info(fmt.Sprintf("findMergeRange(%d, %d)=%+v\n", maxEndTxNum, maxSpan, r))
 	return r
 }
@@ -986,6 +1676,7 @@ type SelectedStaticFilesV3 struct {
 	logTopics    []*filesItem
 	accountsHist []*filesItem
 	tracesTo     []*filesItem
+	txLookup     []*filesItem
 	storageIdx   []*filesItem
 	storageHist  []*filesItem
 	tracesFrom   []*filesItem
@@ -1000,11 +1691,13 @@ type SelectedStaticFilesV3 struct {
 	tracesFromI  int
 	accountsI    int
 	tracesToI    int
+	txLookupI    int
+	extra        [][]*filesItem // of AggregatorV3.extraIndices
 }
 
 func (sf SelectedStaticFilesV3) Close() {
-	for _, group := range [][]*filesItem{sf.accountsIdx, sf.accountsHist, sf.storageIdx, sf.accountsHist, sf.codeIdx, sf.codeHist,
-		sf.logAddrs, sf.logTopics, sf.tracesFrom, sf.tracesTo} {
+	for _, group := range append([][]*filesItem{sf.accountsIdx, sf.accountsHist, sf.storageIdx, sf.accountsHist, sf.codeIdx, sf.codeHist,
+		sf.logAddrs, sf.logTopics, sf.tracesFrom, sf.tracesTo, sf.txLookup}, sf.extra...) {
 		for _, item := range group {
 			if item != nil {
 				if item.decompressor != nil {
@@ -1049,6 +1742,17 @@ func (ac *AggregatorV3Context) staticFilesInRange(r RangesV3) (sf SelectedStatic
 	if r.tracesTo {
 		sf.tracesTo, sf.tracesToI = ac.tracesTo.staticFilesInRange(r.tracesToStartTxNum, r.tracesToEndTxNum)
 	}
+	if r.txLookup {
+		sf.txLookup, sf.txLookupI = ac.txLookup.staticFilesInRange(r.txLookupStartTxNum, r.txLookupEndTxNum)
+	}
+	if len(r.extra) > 0 {
+		sf.extra = make([][]*filesItem, len(r.extra))
+	}
+	for i, e := range r.extra {
+		if e.needMerge {
+			sf.extra[i], _ = ac.extraIndices[i].staticFilesInRange(e.startTxNum, e.endTxNum)
+		}
+	}
 	return sf, err
 }
 
@@ -1060,6 +1764,8 @@ type MergedFilesV3 struct {
 	logTopics                 *filesItem
 	tracesFrom                *filesItem
 	tracesTo                  *filesItem
+	txLookup                  *filesItem
+	extra                     []*filesItem // of AggregatorV3.extraIndices
 }
 
 func (mf MergedFilesV3) FrozenList() (frozen []string) {
@@ -1096,11 +1802,19 @@ func (mf MergedFilesV3) FrozenList() (frozen []string) {
 	if mf.tracesTo != nil && mf.tracesTo.frozen {
 		frozen = append(frozen, mf.tracesTo.decompressor.FileName())
 	}
+	if mf.txLookup != nil && mf.txLookup.frozen {
+		frozen = append(frozen, mf.txLookup.decompressor.FileName())
+	}
+	for _, item := range mf.extra {
+		if item != nil && item.frozen {
+			frozen = append(frozen, item.decompressor.FileName())
+		}
+	}
 	return frozen
 }
 func (mf MergedFilesV3) Close() {
-	for _, item := range []*filesItem{mf.accountsIdx, mf.accountsHist, mf.storageIdx, mf.storageHist, mf.codeIdx, mf.codeHist,
-		mf.logAddrs, mf.logTopics, mf.tracesFrom, mf.tracesTo} {
+	for _, item := range append([]*filesItem{mf.accountsIdx, mf.accountsHist, mf.storageIdx, mf.storageHist, mf.codeIdx, mf.codeHist,
+		mf.logAddrs, mf.logTopics, mf.tracesFrom, mf.tracesTo, mf.txLookup}, mf.extra...) {
 		if item != nil {
 			if item.decompressor != nil {
 				item.decompressor.Close()
@@ -1122,55 +1836,72 @@ func (ac *AggregatorV3Context) mergeFiles(ctx context.Context, files SelectedSta
 			mf.Close()
 		}
 	}()
+	var jobs []mergeJob
 	if r.accounts.any() {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.accountsIdx), run: func() (err error) {
 			mf.accountsIdx, mf.accountsHist, err = ac.a.accounts.mergeFiles(ctx, files.accountsIdx, files.accountsHist, r.accounts, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
-
 	if r.storage.any() {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.storageIdx), run: func() (err error) {
 			mf.storageIdx, mf.storageHist, err = ac.a.storage.mergeFiles(ctx, files.storageIdx, files.storageHist, r.storage, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
 	if r.code.any() {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.codeIdx), run: func() (err error) {
 			mf.codeIdx, mf.codeHist, err = ac.a.code.mergeFiles(ctx, files.codeIdx, files.codeHist, r.code, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
 	if r.logAddrs {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.logAddrs), run: func() (err error) {
 			mf.logAddrs, err = ac.a.logAddrs.mergeFiles(ctx, files.logAddrs, r.logAddrsStartTxNum, r.logAddrsEndTxNum, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
 	if r.logTopics {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.logTopics), run: func() (err error) {
 			mf.logTopics, err = ac.a.logTopics.mergeFiles(ctx, files.logTopics, r.logTopicsStartTxNum, r.logTopicsEndTxNum, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
 	if r.tracesFrom {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.tracesFrom), run: func() (err error) {
 			mf.tracesFrom, err = ac.a.tracesFrom.mergeFiles(ctx, files.tracesFrom, r.tracesFromStartTxNum, r.tracesFromEndTxNum, workers, ac.a.ps)
 			return err
-		})
+		}})
 	}
 	if r.tracesTo {
-		g.Go(func() error {
-			var err error
+		jobs = append(jobs, mergeJob{score: mergeScore(files.tracesTo), run: func() (err error) {
 			mf.tracesTo, err = ac.a.tracesTo.mergeFiles(ctx, files.tracesTo, r.tracesToStartTxNum, r.tracesToEndTxNum, workers, ac.a.ps)
 			return err
-		})
+		}})
+	}
+	if r.txLookup {
+		jobs = append(jobs, mergeJob{score: mergeScore(files.txLookup), run: func() (err error) {
+			mf.txLookup, err = ac.a.txLookup.mergeFiles(ctx, files.txLookup, r.txLookupStartTxNum, r.txLookupEndTxNum, workers, ac.a.ps)
+			return err
+		}})
+	}
+	if len(r.extra) > 0 {
+		mf.extra = make([]*filesItem, len(r.extra))
+	}
+	for i, e := range r.extra {
+		if !e.needMerge {
+			continue
+		}
+		i, e := i, e
+		jobs = append(jobs, mergeJob{score: mergeScore(files.extra[i]), run: func() (err error) {
+			mf.extra[i], err = ac.a.extraIndices[i].mergeFiles(ctx, files.extra[i], e.startTxNum, e.endTxNum, workers, ac.a.ps)
+			return err
+		}})
+	}
+	// errgroup starts jobs in order of `Go` calls: when workers < len(jobs) - hot ranges are merged first
+	sortMergeJobs(jobs)
+	for _, job := range jobs {
+		g.Go(job.run)
 	}
 	err := g.Wait()
 	if err == nil {
@@ -1179,6 +1910,29 @@ func (ac *AggregatorV3Context) mergeFiles(ctx context.Context, files SelectedSta
 	return mf, err
 }
 
+type mergeJob struct {
+	score uint64
+	run   func() error
+}
+
+// mergeScore - how many file lookups merge of `files` would save: files are queried one-by-one,
+// after merge 1 file will serve all queries of `len(files)` files
+func mergeScore(files []*filesItem) (score uint64) {
+	if len(files) < 2 {
+		return 0
+	}
+	for _, item := range files {
+		if item != nil {
+			score += item.queries.Load()
+		}
+	}
+	return score * uint64(len(files)-1)
+}
+
+func sortMergeJobs(jobs []mergeJob) {
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].score > jobs[j].score })
+}
+
 func (a *AggregatorV3) integrateMergedFiles(outs SelectedStaticFilesV3, in MergedFilesV3) (frozen []string) {
 	a.filesMutationLock.Lock()
 	defer a.filesMutationLock.Unlock()
@@ -1191,6 +1945,10 @@ func (a *AggregatorV3) integrateMergedFiles(outs SelectedStaticFilesV3, in Merge
 	a.logTopics.integrateMergedFiles(outs.logTopics, in.logTopics)
 	a.tracesFrom.integrateMergedFiles(outs.tracesFrom, in.tracesFrom)
 	a.tracesTo.integrateMergedFiles(outs.tracesTo, in.tracesTo)
+	a.txLookup.integrateMergedFiles(outs.txLookup, in.txLookup)
+	for i := range in.extra {
+		a.extraIndices[i].integrateMergedFiles(outs.extra[i], in.extra[i])
+	}
 	a.cleanAfterNewFreeze(in)
 	return frozen
 }
@@ -1216,14 +1974,104 @@ func (a *AggregatorV3) cleanAfterNewFreeze(in MergedFilesV3) {
 	if in.tracesTo != nil && in.tracesTo.frozen {
 		a.tracesTo.cleanAfterFreeze(in.tracesTo.endTxNum)
 	}
+	if in.txLookup != nil && in.txLookup.frozen {
+		a.txLookup.cleanAfterFreeze(in.txLookup.endTxNum)
+	}
+	for i, item := range in.extra {
+		if item != nil && item.frozen {
+			a.extraIndices[i].cleanAfterFreeze(item.endTxNum)
+		}
+	}
 }
 
 // KeepInDB - usually equal to one a.aggregationStep, but when we exec blocks from snapshots
-// we can set it to 0, because no re-org on this blocks are possible
-func (a *AggregatorV3) KeepInDB(v uint64) { a.keepInDB = v }
+// we can set it to 0, because no re-org on this blocks are possible. Can be changed at runtime:
+// bigger value - bigger chaindata, but less frequent files build.
+func (a *AggregatorV3) KeepInDB(v uint64) { a.keepInDB.Store(v) }
+
+// KeptInDB - current value set by KeepInDB
+func (a *AggregatorV3) KeptInDB() uint64 { return a.keepInDB.Load() }
+
+// invertedIndices - indices of all histories and all inverted indices, including extra ones
+func (a *AggregatorV3) invertedIndices() []*InvertedIndex {
+	return append([]*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo, a.txLookup}, a.extraIndices...)
+}
+
+// KeepStepsInDB - per history/index: how many steps stay in DB after they were built into files (0 by default).
+// Recent steps of hot domain can be served from DB, while others keep chaindata small. Can be changed at runtime.
+func (a *AggregatorV3) KeepStepsInDB(name string, steps uint64) error {
+	for _, ii := range a.invertedIndices() {
+		if ii.filenameBase == name {
+			ii.keepStepsInDB.Store(steps)
+			return nil
+		}
+	}
+	return fmt.Errorf("KeepStepsInDB: unknown history or index: %s", name)
+}
+
+// SetMetricsLabel - adds `instance` label to metrics of this aggregator: allows run several aggregators (different
+// datadirs/chains) in one process without mixing their series. Empty (default) - no label. Must be called before OpenFolder.
+// Background loops of aggregators are already independent: each has own ctx, logger and in-progress flags.
+func (a *AggregatorV3) SetMetricsLabel(label string) {
+	mx := newAggMetrics(label)
+	for _, ii := range a.invertedIndices() {
+		ii.metricsLabel, ii.mx = label, mx
+	}
+}
+
+// SetMadvConfig - madvise policy per file type, see MadvConfig. Must be called before OpenFolder.
+func (a *AggregatorV3) SetMadvConfig(cfg *MadvConfig) {
+	for _, ii := range a.invertedIndices() {
+		ii.madv = cfg
+	}
+}
 
 func (a *AggregatorV3) BuildFilesInBackground(txNum uint64) {
-	if (txNum + 1) <= a.minimaxTxNumInFiles.Load()+a.aggregationStep+a.keepInDB { // Leave one step worth in the DB
+	if a.readonly.Load() || a.paused.Load() {
+		return
+	}
+	a.rebuildQuarantinedInBackground()
+	a.buildFilesInBackgroundFrom(txNum)
+}
+
+// rebuildQuarantinedInBackground - accessor indices quarantined by OpenFolder after startup (BuildMissedIndices of
+// Snapshots stage did already run) are re-built from their data files. Quarantined data files are re-downloaded on
+// next start, see snapshotsync.
+func (a *AggregatorV3) rebuildQuarantinedInBackground() {
+	need := false
+	for _, ii := range a.invertedIndices() {
+		need = need || ii.indexRebuild.Load()
+	}
+	if !need {
+		return
+	}
+	if ok := a.buildingOptionalIndices.CompareAndSwap(false, true); !ok {
+		return
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer a.buildingOptionalIndices.Store(false)
+		if err := a.BuildMissedIndices(a.ctx, 1); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			a.logger.Warn("[snapshots] re-build of quarantined indices", "err", err)
+		}
+	}()
+}
+
+// TriggerFilesBuild - starts background build of files of all data in DB (also when background work is paused)
+func (a *AggregatorV3) TriggerFilesBuild() error {
+	if a.readonly.Load() {
+		return ErrAggregatorReadOnly
+	}
+	a.buildFilesInBackgroundFrom(lastIdInDB(a.db, a.accounts.indexKeysTable, a.logger))
+	return nil
+}
+
+func (a *AggregatorV3) buildFilesInBackgroundFrom(txNum uint64) {
+	if (txNum + 1) <= a.minimaxTxNumInFiles.Load()+a.aggregationStep+a.keepInDB.Load() { // Leave one step worth in the DB
 		return
 	}
 
@@ -1240,7 +2088,7 @@ func (a *AggregatorV3) BuildFilesInBackground(txNum uint64) {
 		defer a.buildingFiles.Store(false)
 
 		// check if db has enough data (maybe we didn't commit them yet)
-		lastInDB := lastIdInDB(a.db, a.accounts.indexKeysTable)
+		lastInDB := lastIdInDB(a.db, a.accounts.indexKeysTable, a.logger)
 		hasData = lastInDB >= toTxNum
 		if !hasData {
 			return
@@ -1250,17 +2098,20 @@ func (a *AggregatorV3) BuildFilesInBackground(txNum uint64) {
 		// - to reduce amount of small merges
 		// - to remove old data from db as early as possible
 		// - during files build, may happen commit of new data. on each loop step getting latest id in db
-		for step < lastIdInDB(a.db, a.accounts.indexKeysTable)/a.aggregationStep {
+		for step < lastIdInDB(a.db, a.accounts.indexKeysTable, a.logger)/a.aggregationStep {
 			if err := a.buildFilesInBackground(a.ctx, step); err != nil {
 				if errors.Is(err, context.Canceled) {
 					return
 				}
-				log.Warn("[snapshots] buildFilesInBackground", "err", err)
+				a.logger.Warn("[snapshots] buildFilesInBackground", "err", err)
 				break
 			}
 			step++
 		}
 
+		if a.paused.Load() {
+			return
+		}
 		if ok := a.mergeingFiles.CompareAndSwap(false, true); !ok {
 			return
 		}
@@ -1272,7 +2123,10 @@ func (a *AggregatorV3) BuildFilesInBackground(txNum uint64) {
 				if errors.Is(err, context.Canceled) {
 					return
 				}
-				log.Warn("[snapshots] merge", "err", err)
+				a.logger.Warn("[snapshots] merge", "err", err)
+			}
+			if _, err := a.PruneOldFiles(); err != nil {
+				a.logger.Warn("[snapshots] minimal-state", "err", err)
 			}
 
 			a.BuildOptionalMissedIndicesInBackground(a.ctx, 1)
@@ -1296,6 +2150,14 @@ func (a *AggregatorV3) AddStoragePrev(addr []byte, loc []byte, prev []byte) erro
 	return a.storage.AddPrevValue(addr, loc, prev)
 }
 
+// AddStoragePrevAt - AddStoragePrev at `txNum` before current one: history written after execution of tx
+func (a *AggregatorV3) AddStoragePrevAt(txNum uint64, addr []byte, loc []byte, prev []byte) error {
+	current := a.storage.txNum
+	defer a.storage.SetTxNum(current)
+	a.storage.SetTxNum(txNum)
+	return a.storage.AddPrevValue(addr, loc, prev)
+}
+
 // AddCodePrev - addr+inc => code
 func (a *AggregatorV3) AddCodePrev(addr []byte, prev []byte) error {
 	return a.code.AddPrevValue(addr, nil, prev)
@@ -1308,15 +2170,81 @@ func (a *AggregatorV3) PutIdx(idx kv.InvertedIdx, key []byte) error {
 		return a.tracesFrom.Add(key)
 	case kv.TblTracesToIdx:
 		return a.tracesTo.Add(key)
+	case kv.TblTxLookupIdx:
+		return a.txLookup.Add(key)
 	case kv.TblLogAddressIdx:
 		return a.logAddrs.Add(key)
 	case kv.LogTopicIndex:
 		return a.logTopics.Add(key)
 	default:
+		if i, ok := a.extraIndexByName(string(idx)); ok {
+			return a.extraIndices[i].Add(key)
+		}
 		panic(idx)
 	}
 }
 
+// SetPruneIdxKeys - DB-part of inverted index `idx` keeps only postings of keys for which `keep` returns true:
+// postings of other keys are deleted by Prune as soon as they are written, not when files are built. For nodes which
+// are interested only in handful of contracts: sheds most of not-yet-frozen logAddrs/tracesFrom/tracesTo.
+// Files are not affected (see SetWatchList). nil - disables filtering. Must be called before first Prune.
+func (a *AggregatorV3) SetPruneIdxKeys(idx kv.InvertedIdx, keep func(key []byte) bool) error {
+	var ii *InvertedIndex
+	switch idx {
+	case kv.TblTracesFromIdx:
+		ii = a.tracesFrom
+	case kv.TblTracesToIdx:
+		ii = a.tracesTo
+	case kv.TblTxLookupIdx:
+		ii = a.txLookup
+	case kv.TblLogAddressIdx:
+		ii = a.logAddrs
+	case kv.LogTopicIndex:
+		ii = a.logTopics
+	default:
+		i, ok := a.extraIndexByName(string(idx))
+		if !ok {
+			return fmt.Errorf("SetPruneIdxKeys: unknown inverted index %s", idx)
+		}
+		ii = a.extraIndices[i]
+	}
+	ii.pruneKeep = keep
+	return nil
+}
+
+// pruneIdxKeys - applies SetPruneIdxKeys filters to postings written since previous call. Progress (first not-checked
+// txNum) is persisted in kv.TblPruningProgress under `<name>-keys`, Unwind moves it back.
+func (a *AggregatorV3) pruneIdxKeys(ctx context.Context, logEvery *time.Ticker) error {
+	for _, ii := range a.invertedIndices() {
+		if ii.pruneKeep == nil {
+			continue
+		}
+		name := ii.filenameBase + pruneKeysProgressSuffix
+		from, _, err := readPruneProgress(a.rwTx, name)
+		if err != nil {
+			return err
+		}
+		lst, err := kv.LastKey(a.rwTx, ii.indexKeysTable)
+		if err != nil {
+			return err
+		}
+		if len(lst) < 8 {
+			continue
+		}
+		to := binary.BigEndian.Uint64(lst) + 1
+		if from >= to {
+			continue
+		}
+		if _, err := ii.pruneKeys(ctx, from, to, ii.pruneKeep, logEvery); err != nil {
+			return err
+		}
+		if err := writePruneProgress(a.rwTx, name, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DisableReadAhead - usage: `defer d.EnableReadAhead().DisableReadAhead()`. Please don't use this funcs without `defer` to avoid leak.
 func (a *AggregatorV3) DisableReadAhead() {
 	a.accounts.DisableReadAhead()
@@ -1326,6 +2254,10 @@ func (a *AggregatorV3) DisableReadAhead() {
 	a.logTopics.DisableReadAhead()
 	a.tracesFrom.DisableReadAhead()
 	a.tracesTo.DisableReadAhead()
+	a.txLookup.DisableReadAhead()
+	for _, ii := range a.extraIndices {
+		ii.DisableReadAhead()
+	}
 }
 func (a *AggregatorV3) EnableReadAhead() *AggregatorV3 {
 	a.accounts.EnableReadAhead()
@@ -1335,6 +2267,10 @@ func (a *AggregatorV3) EnableReadAhead() *AggregatorV3 {
 	a.logTopics.EnableReadAhead()
 	a.tracesFrom.EnableReadAhead()
 	a.tracesTo.EnableReadAhead()
+	a.txLookup.EnableReadAhead()
+	for _, ii := range a.extraIndices {
+		ii.EnableReadAhead()
+	}
 	return a
 }
 func (a *AggregatorV3) EnableMadvWillNeed() *AggregatorV3 {
@@ -1345,6 +2281,10 @@ func (a *AggregatorV3) EnableMadvWillNeed() *AggregatorV3 {
 	a.logTopics.EnableMadvWillNeed()
 	a.tracesFrom.EnableMadvWillNeed()
 	a.tracesTo.EnableMadvWillNeed()
+	a.txLookup.EnableMadvWillNeed()
+	for _, ii := range a.extraIndices {
+		ii.EnableMadvWillNeed()
+	}
 	return a
 }
 func (a *AggregatorV3) EnableMadvNormal() *AggregatorV3 {
@@ -1355,10 +2295,131 @@ func (a *AggregatorV3) EnableMadvNormal() *AggregatorV3 {
 	a.logTopics.EnableMadvNormalReadAhead()
 	a.tracesFrom.EnableMadvNormalReadAhead()
 	a.tracesTo.EnableMadvNormalReadAhead()
+	a.txLookup.EnableMadvNormalReadAhead()
+	for _, ii := range a.extraIndices {
+		ii.EnableMadvNormalReadAhead()
+	}
 	return a
 }
 
+// ErrHistoryNotAvailable - requested txNum is older than files which node has (partial snapshot sync)
+var ErrHistoryNotAvailable = errors.New("history is not available for requested txNum")
+
+// EarliestServiceableTxNum - history and indices are complete for txNums >= this value.
+// Node which downloaded only recent files (partial snapshot sync) has no files before it.
+func (ac *AggregatorV3Context) EarliestServiceableTxNum() uint64 {
+	var earliest uint64
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		earliest = cmp.Max(earliest, hc.filesStartTxNum())
+	}
+	for _, ic := range []*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo} {
+		earliest = cmp.Max(earliest, ic.filesStartTxNum())
+	}
+	return earliest
+}
+
+// HistoryEarliestTxNum - like EarliestServiceableTxNum, but of one history: historical state reads (as-of txNum)
+// don't depend on files of logs/traces/txLookup indices, which may start later.
+func (ac *AggregatorV3Context) HistoryEarliestTxNum(name kv.History) uint64 {
+	switch name {
+	case kv.AccountsHistory:
+		return ac.accounts.filesStartTxNum()
+	case kv.StorageHistory:
+		return ac.storage.filesStartTxNum()
+	case kv.CodeHistory:
+		return ac.code.filesStartTxNum()
+	default:
+		panic(fmt.Sprintf("unexpected: %s", name))
+	}
+}
+
+// IndexEarliestTxNum - like HistoryEarliestTxNum, for inverted index `name`.
+// TxLookupIdx has no such bound: txs not found in it are resolved by TxLookup table.
+func (ac *AggregatorV3Context) IndexEarliestTxNum(name kv.InvertedIdx) uint64 {
+	switch name {
+	case kv.AccountsHistoryIdx:
+		return ac.accounts.filesStartTxNum()
+	case kv.StorageHistoryIdx:
+		return ac.storage.filesStartTxNum()
+	case kv.CodeHistoryIdx:
+		return ac.code.filesStartTxNum()
+	case kv.LogTopicIdx:
+		return ac.logTopics.filesStartTxNum()
+	case kv.LogAddrIdx:
+		return ac.logAddrs.filesStartTxNum()
+	case kv.TracesFromIdx:
+		return ac.tracesFrom.filesStartTxNum()
+	case kv.TracesToIdx:
+		return ac.tracesTo.filesStartTxNum()
+	default:
+		if i, ok := ac.a.extraIndexByName(string(name)); ok {
+			return ac.extraIndices[i].filesStartTxNum()
+		}
+		return 0
+	}
+}
+
+// FilesEndTxNum - history and indices in files are complete for txNums < this value, newer ones are only in DB.
+func (ac *AggregatorV3Context) FilesEndTxNum() uint64 {
+	end := ac.accounts.filesEndTxNum()
+	for _, hc := range []*HistoryContext{ac.storage, ac.code} {
+		end = cmp.Min(end, hc.filesEndTxNum())
+	}
+	for _, ic := range []*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo} {
+		end = cmp.Min(end, ic.filesEndTxNum())
+	}
+	return end
+}
+
+// TxLookupFilesRange - txs of [startTxNum, endTxNum) are resolvable by TxLookupIdx files, without TxLookup table.
+// Files of datadirs created before TxLookupIdx appeared start later than 0.
+func (ac *AggregatorV3Context) TxLookupFilesRange() (startTxNum, endTxNum uint64) {
+	if len(ac.txLookup.files) == 0 {
+		return 0, 0
+	}
+	return ac.txLookup.filesStartTxNum(), ac.txLookup.filesEndTxNum()
+}
+
+// HistoryChangedKeys - keys of history `name` changed in [startTxNum, endTxNum), in ascending order, without values.
+// Walks only inverted index: cheaper than HistoryRange on wide ranges.
+func (ac *AggregatorV3Context) HistoryChangedKeys(name kv.History, startTxNum, endTxNum uint64, tx kv.Tx) (iter.KV, error) {
+	var ic *InvertedIndexContext
+	switch name {
+	case kv.AccountsHistory:
+		ic = ac.accounts.ic
+	case kv.StorageHistory:
+		ic = ac.storage.ic
+	case kv.CodeHistory:
+		ic = ac.code.ic
+	default:
+		return nil, fmt.Errorf("unexpected history name: %s", name)
+	}
+	it := ic.IterateChangedKeys(startTxNum, endTxNum, tx)
+	return &changedKeysIter{it: &it}, nil
+}
+
+// changedKeysIter - InvertedIterator1 as iter.KV with nil values
+type changedKeysIter struct {
+	it *InvertedIterator1
+}
+
+func (it *changedKeysIter) HasNext() bool { return it.it.HasNext() }
+func (it *changedKeysIter) Next() ([]byte, []byte, error) {
+	return it.it.Next(nil), nil, nil
+}
+func (it *changedKeysIter) Close() { it.it.Close() }
+
 func (ac *AggregatorV3Context) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int, tx kv.Tx) (timestamps iter.U64, err error) {
+	// range which starts before gap of files (partial snapshot sync, quarantined file) would silently miss timestamps
+	if earliest := ac.IndexEarliestTxNum(name); earliest > 0 {
+		first := fromTs
+		if asc == order.Desc {
+			first = toTs + 1 // toTs=-1 means unbounded
+		}
+		if first < int(earliest) {
+			return nil, fmt.Errorf("%w: %s, range starts at txNum=%d, earliest=%d", ErrHistoryNotAvailable, name, first, earliest)
+		}
+	}
 	switch name {
 	case kv.AccountsHistoryIdx:
 		return ac.accounts.IdxRange(k, fromTs, toTs, asc, limit, tx)
@@ -1374,7 +2435,12 @@ func (ac *AggregatorV3Context) IndexRange(name kv.InvertedIdx, k []byte, fromTs,
 		return ac.tracesFrom.IdxRange(k, fromTs, toTs, asc, limit, tx)
 	case kv.TracesToIdx:
 		return ac.tracesTo.IdxRange(k, fromTs, toTs, asc, limit, tx)
+	case kv.TxLookupIdx:
+		return ac.txLookup.IdxRange(k, fromTs, toTs, asc, limit, tx)
 	default:
+		if i, ok := ac.a.extraIndexByName(string(name)); ok {
+			return ac.extraIndices[i].IdxRange(k, fromTs, toTs, asc, limit, tx)
+		}
 		return nil, fmt.Errorf("unexpected history name: %s", name)
 	}
 }
@@ -1469,15 +2535,17 @@ func (a *AggregatorV3) Stats() FilesStats22 {
 }
 
 type AggregatorV3Context struct {
-	a          *AggregatorV3
-	accounts   *HistoryContext
-	storage    *HistoryContext
-	code       *HistoryContext
-	logAddrs   *InvertedIndexContext
-	logTopics  *InvertedIndexContext
-	tracesFrom *InvertedIndexContext
-	tracesTo   *InvertedIndexContext
-	keyBuf     []byte
+	a            *AggregatorV3
+	accounts     *HistoryContext
+	storage      *HistoryContext
+	code         *HistoryContext
+	logAddrs     *InvertedIndexContext
+	logTopics    *InvertedIndexContext
+	tracesFrom   *InvertedIndexContext
+	tracesTo     *InvertedIndexContext
+	txLookup     *InvertedIndexContext
+	extraIndices []*InvertedIndexContext
+	keyBuf       []byte
 
 	id uint64 // set only if TRACE_AGG=true
 }
@@ -1492,12 +2560,20 @@ func (a *AggregatorV3) MakeContext() *AggregatorV3Context {
 		logTopics:  a.logTopics.MakeContext(),
 		tracesFrom: a.tracesFrom.MakeContext(),
 		tracesTo:   a.tracesTo.MakeContext(),
+		txLookup:   a.txLookup.MakeContext(),
 
 		id: a.leakDetector.Add(),
 	}
-
+	if len(a.extraIndices) > 0 {
+		ac.extraIndices = make([]*InvertedIndexContext, len(a.extraIndices))
+		for i, ii := range a.extraIndices {
+			ac.extraIndices[i] = ii.MakeContext()
+		}
+	}
 	return ac
 }
+func (ac *AggregatorV3Context) AccountCodec() types.AccountCodec { return ac.a.accountCodec }
+
 func (ac *AggregatorV3Context) Close() {
 	ac.a.leakDetector.Del(ac.id)
 	ac.accounts.Close()
@@ -1507,6 +2583,10 @@ func (ac *AggregatorV3Context) Close() {
 	ac.logTopics.Close()
 	ac.tracesFrom.Close()
 	ac.tracesTo.Close()
+	ac.txLookup.Close()
+	for _, ic := range ac.extraIndices {
+		ic.Close()
+	}
 }
 
 // BackgroundResult - used only indicate that some work is done
@@ -1524,7 +2604,7 @@ func (br *BackgroundResult) GetAndReset() (bool, error) {
 	return has, err
 }
 
-func lastIdInDB(db kv.RoDB, table string) (lstInDb uint64) {
+func lastIdInDB(db kv.RoDB, table string, logger log.Logger) (lstInDb uint64) {
 	if err := db.View(context.Background(), func(tx kv.Tx) error {
 		lst, _ := kv.LastKey(tx, table)
 		if len(lst) > 0 {
@@ -1532,7 +2612,7 @@ func lastIdInDB(db kv.RoDB, table string) (lstInDb uint64) {
 		}
 		return nil
 	}); err != nil {
-		log.Warn("[snapshots] lastIdInDB", "err", err)
+		logger.Warn("[snapshots] lastIdInDB", "err", err)
 	}
 	return lstInDb
 }
@@ -1555,6 +2635,15 @@ func (a *AggregatorV3) MakeSteps() ([]*AggregatorStep, error) {
 	if len(accountSteps) != len(storageSteps) || len(storageSteps) != len(codeSteps) {
 		return nil, fmt.Errorf("different limit of steps (try merge snapshots): accountSteps=%d, storageSteps=%d, codeSteps=%d", len(accountSteps), len(storageSteps), len(codeSteps))
 	}
+	for _, hs := range [][]*HistoryStep{accountSteps, storageSteps, codeSteps} {
+		var end uint64 // reconstitution replays all history: gap (not downloaded or quarantined file) means wrong state
+		for _, st := range hs {
+			if st.indexFile.startTxNum > end {
+				return nil, fmt.Errorf("%w: files have gap at txNum=%d-%d", ErrHistoryNotAvailable, end, st.indexFile.startTxNum)
+			}
+			end = cmp.Max(end, st.indexFile.endTxNum)
+		}
+	}
 	steps := make([]*AggregatorStep, len(accountSteps))
 	for i, accountStep := range accountSteps {
 		steps[i] = &AggregatorStep{
@@ -1567,6 +2656,8 @@ func (a *AggregatorV3) MakeSteps() ([]*AggregatorStep, error) {
 	return steps, nil
 }
 
+func (as *AggregatorStep) AccountCodec() types.AccountCodec { return as.a.accountCodec }
+
 func (as *AggregatorStep) TxNumRange() (uint64, uint64) {
 	return as.accounts.indexFile.startTxNum, as.accounts.indexFile.endTxNum
 }
@@ -0,0 +1,232 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	math2 "math"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// PinnedView - consistent read view of state as of `txNum`.
+// Files are protected by refcount of underlying AggregatorV3Context (merge can't delete them),
+// and DB steps which view reads - txNums >= max(txNum, end of its files) - are protected from Prune
+// until lease expiration or Release.
+//
+// Long-running readers (analytical queries, proof generation) must call Renew before lease expiration.
+// Expired view is unpinned by next Prune or PinView: its files are released and reads return ErrPinnedViewExpired.
+type PinnedView struct {
+	pins *viewPins
+
+	lock sync.RWMutex         // reads vs unpin
+	ac   *AggregatorV3Context // nil after unpin
+
+	id        uint64
+	txNum     uint64
+	filesEnd  uint64 // view reads DB for txNums >= filesEnd. 0 while PinView opens files: DB data of txNums >= txNum is kept
+	expiresAt time.Time
+}
+
+var ErrPinnedViewExpired = errors.New("pinned view is released or its lease expired")
+
+func (v *PinnedView) TxNum() uint64 { return v.txNum }
+
+// pruneLimit - view needs DB data of txNums >= this value: history of txNums < filesEnd is in files of view,
+// and changes before txNum are not visible as of txNum
+func (v *PinnedView) pruneLimit() uint64 { return cmp.Max(v.filesEnd, v.txNum) }
+
+// ReadAccountData - account as of view's txNum. ok=false: account was not changed after txNum, read latest state.
+func (v *PinnedView) ReadAccountData(addr []byte, tx kv.Tx) (val []byte, ok bool, err error) {
+	err = v.View(func(ac *AggregatorV3Context) error {
+		val, ok, err = ac.ReadAccountDataNoStateWithRecent(addr, v.txNum, tx)
+		return err
+	})
+	return val, ok, err
+}
+
+// ReadAccountStorage - storage slot as of view's txNum, see ReadAccountData
+func (v *PinnedView) ReadAccountStorage(addr, loc []byte, tx kv.Tx) (val []byte, ok bool, err error) {
+	err = v.View(func(ac *AggregatorV3Context) error {
+		val, ok, err = ac.ReadAccountStorageNoStateWithRecent(addr, loc, v.txNum, tx)
+		return err
+	})
+	return val, ok, err
+}
+
+// ReadAccountCode - code as of view's txNum, see ReadAccountData
+func (v *PinnedView) ReadAccountCode(addr []byte, tx kv.Tx) (val []byte, ok bool, err error) {
+	err = v.View(func(ac *AggregatorV3Context) error {
+		val, ok, err = ac.ReadAccountCodeNoStateWithRecent(addr, v.txNum, tx)
+		return err
+	})
+	return val, ok, err
+}
+
+// View - run `f` on files of view. Unpin waits for `f`, so `ac` must not be used after `f` returns.
+func (v *PinnedView) View(f func(ac *AggregatorV3Context) error) error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	if v.ac == nil {
+		return fmt.Errorf("%w: id=%d, txNum=%d", ErrPinnedViewExpired, v.id, v.txNum)
+	}
+	return f(v.ac)
+}
+
+// Renew - extend lease of view. Returns error if lease already expired: DB data may be already pruned.
+func (v *PinnedView) Renew(lease time.Duration) error {
+	return v.pins.renew(v, lease)
+}
+
+// Release - unpin DB steps and close files of view. Safe to call multiple times.
+func (v *PinnedView) Release() {
+	v.pins.del(v.id)
+	v.unpin()
+}
+
+func (v *PinnedView) unpin() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.ac != nil {
+		v.ac.Close()
+		v.ac = nil
+	}
+}
+
+type viewPins struct {
+	lock   sync.Mutex
+	nextID uint64
+	views  map[uint64]*PinnedView
+}
+
+func newViewPins() *viewPins { return &viewPins{views: map[uint64]*PinnedView{}} }
+
+func (p *viewPins) add(v *PinnedView) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.nextID++
+	v.id = p.nextID
+	p.views[v.id] = v
+}
+
+// activate - set files of view registered by `add`. Returns false if view was already unpinned (lease expired).
+func (p *viewPins) activate(v *PinnedView, filesEnd uint64, ac *AggregatorV3Context) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.views[v.id]; !ok {
+		return false
+	}
+	v.filesEnd, v.ac = filesEnd, ac
+	return true
+}
+
+func (p *viewPins) del(id uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.views, id)
+}
+
+func (p *viewPins) renew(v *PinnedView, lease time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.views[v.id]; !ok {
+		return fmt.Errorf("%w: id=%d, txNum=%d", ErrPinnedViewExpired, v.id, v.txNum)
+	}
+	now := time.Now()
+	if now.After(v.expiresAt) {
+		return fmt.Errorf("%w: id=%d, txNum=%d, lease expired at %s", ErrPinnedViewExpired, v.id, v.txNum, v.expiresAt)
+	}
+	v.expiresAt = now.Add(lease)
+	return nil
+}
+
+// unpinExpired - forget views of expired lease and release their files: owner may never call Release.
+// Files are closed outside of lock - unpin waits for running reads of view.
+func (p *viewPins) unpinExpired() {
+	var expired []*PinnedView
+	p.lock.Lock()
+	now := time.Now()
+	for id, v := range p.views {
+		if now.After(v.expiresAt) {
+			expired = append(expired, v)
+			delete(p.views, id)
+		}
+	}
+	p.lock.Unlock()
+	for _, v := range expired {
+		v.unpin()
+	}
+}
+
+// pruneLimit - Prune must not delete DB data of txNums >= pruneLimit, because some live view reads it from DB
+func (p *viewPins) pruneLimit() uint64 {
+	p.unpinExpired()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	limit := uint64(math2.MaxUint64)
+	for _, v := range p.views {
+		limit = cmp.Min(limit, v.pruneLimit())
+	}
+	return limit
+}
+
+func (p *viewPins) len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.views)
+}
+
+// PinView - pin consistent view of files and DB steps for reading state as of `txNum`.
+// Pattern: `v, err := agg.PinView(txNum, time.Minute); defer v.Release()`
+func (a *AggregatorV3) PinView(txNum uint64, lease time.Duration) (*PinnedView, error) {
+	if lease <= 0 {
+		return nil, fmt.Errorf("PinView: lease must be positive, got %s", lease)
+	}
+	a.pins.unpinExpired()
+	// register before reading files boundary: Prune which starts after this point keeps DB data of txNums >= txNum,
+	// and Prune which started before is limited by files which already exist - they are in view's context
+	v := &PinnedView{
+		pins:      a.pins,
+		txNum:     txNum,
+		expiresAt: time.Now().Add(lease),
+	}
+	a.pins.add(v)
+	// read boundary before MakeContext: view may get bigger files than this boundary, but never smaller
+	filesEnd := a.minimaxTxNumInFiles.Load()
+	ac := a.MakeContext()
+	for _, name := range []kv.History{kv.AccountsHistory, kv.StorageHistory, kv.CodeHistory} {
+		if earliest := ac.HistoryEarliestTxNum(name); txNum < earliest {
+			a.pins.del(v.id)
+			ac.Close()
+			return nil, fmt.Errorf("PinView: txNum=%d, %s starts at %d: %w", txNum, name, earliest, ErrHistoryNotAvailable)
+		}
+	}
+	if !a.pins.activate(v, filesEnd, ac) {
+		ac.Close()
+		return nil, fmt.Errorf("PinView: %w: id=%d, txNum=%d", ErrPinnedViewExpired, v.id, v.txNum)
+	}
+	return v, nil
+}
+
+// PinnedViews - amount of live (not released) pinned views
+func (a *AggregatorV3) PinnedViews() int { return a.pins.len() }
@@ -0,0 +1,83 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+// ArchiveGetter - reads keys and values of .kv/.v/.ef files, hiding whether values of file are compressed.
+//
+// By default returned words are owned by caller (appended to given buf).
+// In ZeroCopy mode:
+//   - uncompressed words are slices of mmap'ed file: valid until files of context are closed (ctx.Close()).
+//     Must not be modified.
+//   - compressed words are decompressed into getter's own buffer: valid until next NextVal call on this getter.
+//
+// Callers which retain result longer than that must copy it on demand (common.Copy or append(buf[:0], v...)).
+type ArchiveGetter struct {
+	*seg.Getter
+	compressVals bool
+	zeroCopy     bool
+	valBuf       []byte // decompression buffer of ZeroCopy mode
+}
+
+func NewArchiveGetter(g *seg.Getter, compressVals bool) *ArchiveGetter {
+	return &ArchiveGetter{Getter: g, compressVals: compressVals}
+}
+
+// ZeroCopy - switch getter to mode where words are not copied. See ArchiveGetter doc for lifetime of results.
+func (g *ArchiveGetter) ZeroCopy() *ArchiveGetter {
+	g.zeroCopy = true
+	return g
+}
+
+// NextKey - keys (and .ef values) are always stored uncompressed
+func (g *ArchiveGetter) NextKey(buf []byte) ([]byte, uint64) {
+	w, pos := g.Getter.NextUncompressed()
+	if g.zeroCopy {
+		return w, pos
+	}
+	return appendWord(buf, w), pos
+}
+
+func (g *ArchiveGetter) NextVal(buf []byte) ([]byte, uint64) {
+	if g.compressVals {
+		if g.zeroCopy {
+			var pos uint64
+			g.valBuf, pos = g.Getter.Next(g.valBuf[:0])
+			return g.valBuf, pos
+		}
+		return g.Getter.Next(buf)
+	}
+	return g.NextKey(buf)
+}
+
+func (g *ArchiveGetter) SkipVal() (uint64, int) {
+	if g.compressVals {
+		return g.Getter.Skip()
+	}
+	return g.Getter.SkipUncompressed()
+}
+
+// appendWord - same as seg.Getter.Next: empty word is non-nil, because nil is the marker of "something not found"
+func appendWord(buf, w []byte) []byte {
+	if buf == nil && len(w) == 0 {
+		return []byte{}
+	}
+	return append(buf, w...)
+}
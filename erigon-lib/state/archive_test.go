@@ -0,0 +1,71 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+func TestArchiveGetter(t *testing.T) {
+	logger := log.New()
+	tmp := t.TempDir()
+
+	for _, compressVals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressVals=%t", compressVals), func(t *testing.T) {
+			dataPath := filepath.Join(tmp, fmt.Sprintf("%t.kv", compressVals))
+			comp, err := seg.NewCompressor(context.Background(), "cmp", dataPath, tmp, seg.MinPatternScore, 1, log.LvlDebug, logger)
+			require.NoError(t, err)
+			keys, vals := make([][]byte, 100), make([][]byte, 100)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key %d", i))
+				vals[i] = []byte(fmt.Sprintf("value of key %d - value of key %d", i, i))
+				if i%10 == 0 {
+					vals[i] = []byte{}
+				}
+				require.NoError(t, comp.AddUncompressedWord(keys[i]))
+				if compressVals {
+					require.NoError(t, comp.AddWord(vals[i]))
+				} else {
+					require.NoError(t, comp.AddUncompressedWord(vals[i]))
+				}
+			}
+			require.NoError(t, comp.Compress())
+			comp.Close()
+			d, err := seg.NewDecompressor(dataPath)
+			require.NoError(t, err)
+			defer d.Close()
+
+			// copy mode: results owned by caller
+			g := NewArchiveGetter(d.MakeGetter(), compressVals)
+			var k, v []byte
+			for i := 0; g.HasNext(); i++ {
+				k, _ = g.NextKey(nil)
+				v, _ = g.NextVal(nil)
+				require.Equal(t, keys[i], k)
+				require.Equal(t, vals[i], v)
+				require.NotNil(t, v)
+			}
+
+			// zero-copy mode: same words
+			g = NewArchiveGetter(d.MakeGetter(), compressVals).ZeroCopy()
+			for i := 0; g.HasNext(); i++ {
+				k, _ = g.NextKey(nil)
+				v, _ = g.NextVal(nil)
+				require.Equal(t, keys[i], k)
+				require.Equal(t, vals[i], v)
+			}
+
+			g.Reset(0)
+			g.NextKey(nil)
+			g.SkipVal()
+			k, _ = g.NextKey(nil)
+			require.Equal(t, keys[1], k)
+		})
+	}
+}
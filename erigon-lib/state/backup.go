@@ -0,0 +1,82 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+)
+
+// Backup - hot backup of files of aggregator into `destDir`, while aggregator keeps working.
+// Backup has exactly files visible by new AggregatorV3Context (it holds them open: merge can't remove them meanwhile).
+// If files are owned by another process (backup of running node), they may be removed by it's merge meanwhile:
+// then error is os.ErrNotExist and caller can re-open folder and retry.
+// Files are immutable - they are hard-linked, or copied if `destDir` is on another filesystem.
+// Returns names of files (relative to `destDir`) and txNum up to which all histories/indices have files.
+//
+// Consistency with DB: caller must open chaindata RoTx BEFORE Backup and copy DB by this tx. DB is pruned only
+// after files are built - so files seen by Backup and DB of earlier tx together have all data.
+func (a *AggregatorV3) Backup(ctx context.Context, destDir string) (names []string, txNum uint64, err error) {
+	if err := os.MkdirAll(destDir, 0740); err != nil {
+		return nil, 0, err
+	}
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	type component struct {
+		name  string
+		files [][]ctxItem // history: .v and .ef files
+	}
+	components := []component{
+		{a.accounts.filenameBase, [][]ctxItem{ac.accounts.files, ac.accounts.ic.files}},
+		{a.storage.filenameBase, [][]ctxItem{ac.storage.files, ac.storage.ic.files}},
+		{a.code.filenameBase, [][]ctxItem{ac.code.files, ac.code.ic.files}},
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		components = append(components, component{ic.ii.filenameBase, [][]ctxItem{ic.files}})
+	}
+
+	txNum = ^uint64(0)
+	for _, c := range components {
+		for _, files := range c.files {
+			var endTxNum uint64
+			for _, item := range files {
+				for _, path := range item.src.filePaths() {
+					select {
+					case <-ctx.Done():
+						return nil, 0, ctx.Err()
+					default:
+					}
+					name := filepath.Base(path)
+					if err := dir.LinkOrCopyFile(path, filepath.Join(destDir, name)); err != nil {
+						return nil, 0, fmt.Errorf("backup %s: %w", c.name, err)
+					}
+					names = append(names, name)
+				}
+				endTxNum = item.endTxNum
+			}
+			txNum = cmp.Min(txNum, endTxNum)
+		}
+	}
+	a.logger.Info("[snapshots] backup of state files done", "files", len(names), "to_step", txNum/a.aggregationStep, "dir", destDir)
+	return names, txNum, nil
+}
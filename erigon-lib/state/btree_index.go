@@ -132,7 +132,7 @@ func newBtAlloc(k, M uint64, trace bool) *btAlloc {
 		trace:   trace,
 	}
 	if trace {
-		fmt.Printf("k=%d d=%d, M=%d\n", k, d, M)
+		log.Trace("[btree] alloc", "k", k, "d", d, "M", M)
 	}
 	a.vx[0], a.vx[d] = 1, k
 
@@ -183,7 +183,7 @@ func newBtAlloc(k, M uint64, trace bool) *btAlloc {
 
 	if trace {
 		for i, v := range a.sons {
-			fmt.Printf("L%d=%v\n", i, v)
+			log.Trace("[btree] alloc level", "level", i, "sons", fmt.Sprintf("%v", v))
 		}
 	}
 
@@ -283,7 +283,7 @@ func (a *btAlloc) traverseDfs() {
 		a.nodes[0] = append(a.nodes[0], node{d: a.K})
 		a.N = a.K
 		if a.trace {
-			fmt.Printf("ncount=%d ∂%.5f\n", a.N, float64(a.N-a.K)/float64(a.N))
+			log.Trace("[btree] nodes", "ncount", a.N, "overhead", fmt.Sprintf("%.5f", float64(a.N-a.K)/float64(a.N)))
 		}
 		return
 	}
@@ -302,7 +302,7 @@ func (a *btAlloc) traverseDfs() {
 		if di > a.K {
 			a.N = di - 1 // actually filled node count
 			if a.trace {
-				fmt.Printf("ncount=%d ∂%.5f\n", a.N, float64(a.N-a.K)/float64(a.N))
+				log.Trace("[btree] nodes", "ncount", a.N, "overhead", fmt.Sprintf("%.5f", float64(a.N-a.K)/float64(a.N)))
 			}
 			break
 		}
@@ -311,7 +311,7 @@ func (a *btAlloc) traverseDfs() {
 		for i := uint64(0); i < bros; i++ {
 			c.di = di
 			if trace {
-				fmt.Printf("L%d |%d| d %2d s %2d\n", c.l, c.p, c.di, c.si)
+				log.Trace("[btree] leaf", "level", c.l, "p", c.p, "d", c.di, "s", c.si)
 			}
 			c.si++
 			di++
@@ -319,7 +319,7 @@ func (a *btAlloc) traverseDfs() {
 			if i == 0 {
 				pc.di = di
 				if trace {
-					fmt.Printf("P%d |%d| d %2d s %2d\n", pc.l, pc.p, pc.di, pc.si)
+					log.Trace("[btree] parent", "level", pc.l, "p", pc.p, "d", pc.di, "s", pc.si)
 				}
 				pc.si++
 				di++
@@ -339,7 +339,7 @@ func (a *btAlloc) traverseDfs() {
 			if c.p+2 >= uint64(len(a.sons[c.l])) {
 				stop = true // end of row
 				if trace {
-					fmt.Printf("F%d |%d| d %2d\n", c.l, c.p, c.di)
+					log.Trace("[btree] end of leaves", "level", c.l, "p", c.p, "d", c.di)
 				}
 			} else {
 				c.p += 2
@@ -370,7 +370,7 @@ func (a *btAlloc) traverseDfs() {
 					gp.di = di
 					di++
 					if trace {
-						fmt.Printf("P%d |%d| d %2d s %2d\n", gp.l, gp.p, gp.di, gp.si)
+						log.Trace("[btree] parent", "level", gp.l, "p", gp.p, "d", gp.di, "s", gp.si)
 					}
 					a.nodes[gp.l] = append(a.nodes[gp.l], node{p: gp.p, d: gp.di, s: gp.si, fc: uint64(len(a.nodes[l]) - 1)})
 					a.cursors[gp.l] = gp
@@ -380,7 +380,7 @@ func (a *btAlloc) traverseDfs() {
 					root.d = di
 					//di++
 					if trace {
-						fmt.Printf("ROOT | d %2d\n", root.d)
+						log.Trace("[btree] root", "d", root.d)
 					}
 				}
 			}
@@ -389,7 +389,7 @@ func (a *btAlloc) traverseDfs() {
 			if pi2 >= grands { // skip one step of si due to different parental filling order
 				if pc.p+2 >= uint64(len(a.sons[pc.l])) {
 					if trace {
-						fmt.Printf("EoRow %d |%d|\n", pc.l, pc.p)
+						log.Trace("[btree] end of row", "level", pc.l, "p", pc.p)
 					}
 					break // end of row
 				}
@@ -408,7 +408,7 @@ func (a *btAlloc) traverseDfs() {
 	}
 
 	if a.trace {
-		fmt.Printf("ncount=%d ∂%.5f\n", a.N, float64(a.N-a.K)/float64(a.N))
+		log.Trace("[btree] nodes", "ncount", a.N, "overhead", fmt.Sprintf("%.5f", float64(a.N-a.K)/float64(a.N)))
 	}
 }
 
@@ -484,7 +484,7 @@ func (a *btAlloc) seekLeast(lvl, d uint64) uint64 {
 
 func (a *btAlloc) Seek(ik []byte) (*Cursor, error) {
 	if a.trace {
-		fmt.Printf("seek key %x\n", ik)
+		log.Trace("[btree] seek", "key", fmt.Sprintf("%x", ik))
 	}
 
 	var (
@@ -503,7 +503,7 @@ func (a *btAlloc) Seek(ik []byte) (*Cursor, error) {
 		ln, lm, rm = a.bsNode(uint64(l), L, R, ik)
 		if ln.key == nil { // should return node which is nearest to key from the left so never nil
 			if a.trace {
-				fmt.Printf("found nil key %x pos_range[%d-%d] naccess_ram=%d\n", l, lm, rm, a.naccess)
+				log.Trace("[btree] nil node", "level", l, "lm", lm, "rm", rm, "naccess_ram", a.naccess)
 			}
 			return nil, fmt.Errorf("bt index nil node at level %d", l)
 		}
@@ -515,7 +515,7 @@ func (a *btAlloc) Seek(ik []byte) (*Cursor, error) {
 			minD = ln.d
 		case 0:
 			if a.trace {
-				fmt.Printf("found key %x v=%x naccess_ram=%d\n", ik, ln.val /*level[m].d,*/, a.naccess)
+				log.Trace("[btree] found in ram", "key", fmt.Sprintf("%x", ik), "val", fmt.Sprintf("%x", ln.val), "naccess_ram", a.naccess)
 			}
 			return a.newCursor(context.TODO(), common.Copy(ln.key), common.Copy(ln.val), ln.d), nil
 		}
@@ -543,7 +543,7 @@ func (a *btAlloc) Seek(ik []byte) (*Cursor, error) {
 		}
 
 		if a.trace {
-			fmt.Printf("range={%x d=%d p=%d} (%d, %d) L=%d naccess_ram=%d\n", ln.key, ln.d, ln.p, minD, maxD, l, a.naccess)
+			log.Trace("[btree] range", "key", fmt.Sprintf("%x", ln.key), "d", ln.d, "p", ln.p, "minD", minD, "maxD", maxD, "level", l, "naccess_ram", a.naccess)
 		}
 	}
 
@@ -551,25 +551,23 @@ func (a *btAlloc) Seek(ik []byte) (*Cursor, error) {
 	cursor, err := a.bsKey(ik, minD, maxD)
 	if err != nil {
 		if a.trace {
-			fmt.Printf("key %x not found\n", ik)
+			log.Trace("[btree] not found", "key", fmt.Sprintf("%x", ik))
 		}
 		return nil, err
 	}
 
 	if a.trace {
-		fmt.Printf("finally found key %x v=%x naccess_disk=%d\n", cursor.key, cursor.value, a.naccess)
+		log.Trace("[btree] found on disk", "key", fmt.Sprintf("%x", cursor.key), "val", fmt.Sprintf("%x", cursor.value), "naccess_disk", a.naccess)
 	}
 	return cursor, nil
 }
 
 func (a *btAlloc) fillSearchMx() {
 	for i, n := range a.nodes {
-		if a.trace {
-			fmt.Printf("D%d |%d| ", i, len(n))
-		}
+		var ds []uint64
 		for j, s := range n {
 			if a.trace {
-				fmt.Printf("%d ", s.d)
+				ds = append(ds, s.d)
 			}
 			if s.d >= a.K {
 				break
@@ -577,13 +575,13 @@ func (a *btAlloc) fillSearchMx() {
 
 			kb, v, err := a.dataLookup(s.d)
 			if err != nil {
-				fmt.Printf("d %d not found %v\n", s.d, err)
+				log.Warn("[btree] search mx: lookup failed", "d", s.d, "err", err)
 			}
 			a.nodes[i][j].key = common.Copy(kb)
 			a.nodes[i][j].val = common.Copy(v)
 		}
 		if a.trace {
-			fmt.Printf("\n")
+			log.Trace("[btree] search mx", "level", i, "nodes", len(n), "d", fmt.Sprintf("%v", ds))
 		}
 	}
 }
@@ -882,6 +880,9 @@ func BuildBtreeIndexWithDecompressor(indexPath string, kv *seg.Decompressor, p *
 		}
 	}
 	//fmt.Printf("emptys %d %#+v\n", emptys, ks)
+	if err := getter.Err(); err != nil {
+		return err
+	}
 
 	if err := iw.Build(); err != nil {
 		return err
@@ -926,6 +927,9 @@ func BuildBtreeIndex(dataPath, indexPath string, logger log.Logger) error {
 
 		pos, _ = getter.Skip()
 	}
+	if err := getter.Err(); err != nil {
+		return err
+	}
 	decomp.Close()
 
 	if err := iw.Build(); err != nil {
@@ -1060,15 +1064,24 @@ func (b *BtIndex) dataLookup(di uint64) ([]byte, []byte, error) {
 	offset := binary.BigEndian.Uint64(aux[:])
 	b.getter.Reset(offset)
 	if !b.getter.HasNext() {
+		if err := b.getter.Err(); err != nil {
+			return nil, nil, err
+		}
 		return nil, nil, fmt.Errorf("pair %d not found. keyCount=%d. file: %s", di, b.keyCount, b.FileName())
 	}
 
 	key, kp := b.getter.Next(nil)
 
 	if !b.getter.HasNext() {
+		if err := b.getter.Err(); err != nil {
+			return nil, nil, err
+		}
 		return nil, nil, fmt.Errorf("pair %d not found. keyCount=%d. file: %s", di, b.keyCount, b.FileName())
 	}
 	val, vp := b.getter.Next(nil)
+	if err := b.getter.Err(); err != nil {
+		return nil, nil, err
+	}
 	_, _ = kp, vp
 	return key, val, nil
 }
@@ -0,0 +1,29 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+// chaosPoint - place in files lifecycle where chaos harness (build tag `chaos`) injects faults: returned error
+// (failed write/fsync of files) or `kill -9` of process. Without the tag hooks are no-op and inlined.
+// See aggregator_chaos_test.go: after every fault OpenFolder must recover consistent set of files.
+type chaosPoint string
+
+const (
+	chaosBuildFiles           chaosPoint = "buildFiles"           // files of step are built, not integrated yet
+	chaosIntegrateFiles       chaosPoint = "integrateFiles"       // right before integration of built files
+	chaosMergeFiles           chaosPoint = "mergeFiles"           // merged files are built, not integrated yet
+	chaosIntegrateMergedFiles chaosPoint = "integrateMergedFiles" // merged files are integrated, merge sources are not deleted yet
+)
@@ -0,0 +1,5 @@
+//go:build !chaos
+
+package state
+
+func chaos(p chaosPoint) error { return nil }
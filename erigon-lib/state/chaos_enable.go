@@ -0,0 +1,23 @@
+//go:build chaos
+
+package state
+
+import "sync/atomic"
+
+var chaosHook atomic.Pointer[func(p chaosPoint) error]
+
+func chaos(p chaosPoint) error {
+	if h := chaosHook.Load(); h != nil {
+		return (*h)(p)
+	}
+	return nil
+}
+
+// setChaosHook - nil disables hook
+func setChaosHook(h func(p chaosPoint) error) {
+	if h == nil {
+		chaosHook.Store(nil)
+		return
+	}
+	chaosHook.Store(&h)
+}
@@ -28,6 +28,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -38,6 +39,7 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
@@ -50,6 +52,7 @@ type filesItem struct {
 	decompressor *seg.Decompressor
 	index        *recsplit.Index
 	bindex       *BtIndex
+	vlog         *valueLog // optional, only domain .kv files - see value_log.go
 	startTxNum   uint64
 	endTxNum     uint64
 
@@ -57,11 +60,24 @@ type filesItem struct {
 	// Cold: file of size < StepsInBiggestFile. Immutable, but can be closed/removed after merge to bigger file.
 	// Hot: Stored in DB. Providing Snapshot-Isolation by CopyOnWrite.
 	frozen   bool         // immutable, don't need atomic
-	refcount atomic.Int32 // only for `frozen=false`
+	refcount atomic.Int32 // amount of contexts which use file
 
 	// file can be deleted in 2 cases: 1. when `refcount == 0 && canDelete == true` 2. on app startup when `file.isSubsetOfFrozenFile()`
 	// other processes (which also reading files, may have same logic)
 	canDelete atomic.Bool
+
+	// access counters (contexts flush their counters on Close), see FilesAccessStats
+	queries   atomic.Uint64 // lookups served by file - hot files are merged first
+	misses    atomic.Uint64 // lookups of keys which are not in file
+	reads     atomic.Uint64 // values read from file
+	bytesRead atomic.Uint64 // size of read values (after decompression)
+
+	deleteReason atomic.Pointer[string]       // why canDelete was set - for audit log
+	replaced     atomic.Bool                  // file was replaced on disk by new one (see ReplaceFiles): close, but don't remove
+	pruned       atomic.Bool                  // deleted on purpose (see AggregatorV3.retirePruned): removed also when frozen
+	madvApplied  atomic.Bool                  // MadvConfig policy was applied to file, see MadvConfig.apply
+	external     bool                         // opened from read-only search dir (see InvertedIndex.searchDirs): close, but don't remove
+	retiredIn    atomic.Pointer[retiredFiles] // registry where markCanDelete did put file
 }
 
 func newFilesItem(startTxNum, endTxNum uint64, stepSize uint64) *filesItem {
@@ -81,34 +97,138 @@ func filesItemLess(i, j *filesItem) bool {
 	}
 	return i.endTxNum < j.endTxNum
 }
+
+// markCanDelete - file is not needed anymore: last reader will remove it (see closeFilesAndRemove).
+// `reason` and `coveredBy` are kept for audit log. `retired` - registry of aggregator, nil if not tracked
+func (i *filesItem) markCanDelete(retired *retiredFiles, reason string, coveredBy *filesItem) {
+	if coveredBy != nil {
+		reason = fmt.Sprintf("%s: %s", reason, strings.Join(coveredBy.fileNames(), ","))
+	}
+	i.deleteReason.Store(&reason)
+	if retired != nil {
+		i.retiredIn.Store(retired)
+		retired.add(i)
+	}
+	i.canDelete.Store(true)
+	log.Log(deleteAuditLvl(), "[snapshots] file can be deleted", "files", i.fileNames(), "reason", reason, "refcount", i.refcount.Load())
+}
+
+func (i *filesItem) fileNames() (names []string) {
+	if i.decompressor != nil {
+		names = append(names, i.decompressor.FileName())
+	}
+	if i.index != nil {
+		names = append(names, i.index.FileName())
+	}
+	if i.bindex != nil {
+		names = append(names, i.bindex.FileName())
+	}
+	if i.vlog != nil {
+		names = append(names, i.vlog.FileName())
+	}
+	return names
+}
+
+func (i *filesItem) filePaths() (paths []string) {
+	if i.decompressor != nil {
+		paths = append(paths, i.decompressor.FilePath())
+	}
+	if i.index != nil {
+		paths = append(paths, i.index.FilePath())
+	}
+	if i.bindex != nil {
+		paths = append(paths, i.bindex.FilePath())
+	}
+	if i.vlog != nil {
+		paths = append(paths, i.vlog.FilePath())
+	}
+	return paths
+}
+
+func deleteAuditLvl() log.Lvl {
+	if dbg.SnapshotsDeleteAudit {
+		return log.LvlInfo
+	}
+	return log.LvlDebug
+}
+
 func (i *filesItem) closeFilesAndRemove() {
+	retired := i.retiredIn.Load()
+	if retired != nil {
+		retired.remove(i)
+	}
+	reason := "unknown"
+	if r := i.deleteReason.Load(); r != nil {
+		reason = *r
+	}
+	if i.replaced.Load() || i.external {
+		i.closeFiles()
+		return
+	}
+	if !retired.ownsDir() { // dir is owned by another process (or read-only): files are removed by it, see CleanDir
+		log.Log(deleteAuditLvl(), "[snapshots] skip delete: no writer lock of dir", "files", i.fileNames(), "reason", reason)
+		i.closeFiles()
+		return
+	}
+	log.Log(deleteAuditLvl(), "[snapshots] delete", "files", i.fileNames(), "reason", reason, "frozen", i.frozen, "dry_run", dbg.SnapshotsDeleteDryRun)
 	if i.decompressor != nil {
 		i.decompressor.Close()
-		// paranoic-mode on: don't delete frozen files
-		if !i.frozen {
-			if err := os.Remove(i.decompressor.FilePath()); err != nil {
-				log.Trace("close", "err", err, "file", i.decompressor.FileName())
-			}
+		// paranoic-mode on: don't delete frozen files, unless they are pruned on purpose
+		if !i.frozen || i.pruned.Load() {
+			removeFile(i.decompressor.FilePath())
 		}
 		i.decompressor = nil
 	}
 	if i.index != nil {
 		i.index.Close()
-		// paranoic-mode on: don't delete frozen files
-		if !i.frozen {
-			if err := os.Remove(i.index.FilePath()); err != nil {
-				log.Trace("close", "err", err, "file", i.index.FileName())
-			}
+		// paranoic-mode on: don't delete frozen files, unless they are pruned on purpose
+		if !i.frozen || i.pruned.Load() {
+			removeFile(i.index.FilePath())
 		}
 		i.index = nil
 	}
 	if i.bindex != nil {
 		i.bindex.Close()
-		if err := os.Remove(i.bindex.FilePath()); err != nil {
-			log.Trace("close", "err", err, "file", i.bindex.FileName())
+		removeFile(i.bindex.FilePath())
+		i.bindex = nil
+	}
+	if i.vlog != nil {
+		i.vlog.Close()
+		// paranoic-mode on: don't delete frozen files, unless they are pruned on purpose
+		if !i.frozen || i.pruned.Load() {
+			removeFile(i.vlog.FilePath())
 		}
+		i.vlog = nil
+	}
+}
+
+func (i *filesItem) closeFiles() {
+	if i.decompressor != nil {
+		i.decompressor.Close()
+		i.decompressor = nil
+	}
+	if i.index != nil {
+		i.index.Close()
+		i.index = nil
+	}
+	if i.bindex != nil {
+		i.bindex.Close()
 		i.bindex = nil
 	}
+	if i.vlog != nil {
+		i.vlog.Close()
+		i.vlog = nil
+	}
+}
+
+// removeFile - respects dbg.SnapshotsDeleteDryRun
+func removeFile(path string) {
+	if dbg.SnapshotsDeleteDryRun {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Trace("close", "err", err, "file", filepath.Base(path))
+	}
 }
 
 type DomainStats struct {
@@ -160,6 +280,23 @@ type Domain struct {
 	stats       DomainStats
 	mergesCount uint64
 
+	// stepMeta - new merged .kv files are written in kv format v2: values are prefixed by step in which they were
+	// written (8 bytes BigEndian). Single-step files don't need it: step is in file name. Format is recorded in file
+	// (kvFeatureStepMeta), so setting can be changed for datadir: it applies to new merged files. Makes prune exact.
+	stepMeta bool
+	// codeByHash - content-addressed code storage, see domain_code.go
+	codeByHash bool
+	// valueLogThreshold - values of this size or bigger are stored in .vlog files, see value_log.go. 0 - disabled
+	valueLogThreshold int
+	// tablesOwner - DB tables are written and pruned by other domain, this one only builds files of its keys (see keep).
+	// See Aggregator.AddStorageContract
+	tablesOwner *Domain
+	// tombstonePrefixLen - keys of this length are prefix tombstones, see domain_tombstone.go. 0 - disabled
+	tombstonePrefixLen int
+	// tombstoneWrites - key -> txNum of last write, for keys under tombstones written since last Rotate:
+	// such txNums are not in DB history yet. Only tablesOwner has it
+	tombstoneWrites map[string]uint64
+
 	garbageFiles []*filesItem // files that exist on disk, but ignored on opening folder - because they are garbage
 	logger       log.Logger
 }
@@ -184,6 +321,41 @@ func NewDomain(dir, tmpdir string, aggregationStep uint64,
 	return d, nil
 }
 
+// kvFeatureStepMeta - values of .kv are in kv format v2, see Domain.stepMeta
+const kvFeatureStepMeta uint64 = 1 << 1
+
+// withStepMeta - new merged .kv file of range [startTxNum, endTxNum) is written in kv format v2
+func (d *Domain) withStepMeta(startTxNum, endTxNum uint64) bool {
+	return d.stepMeta && endTxNum-startTxNum > d.aggregationStep
+}
+
+// hasStepMeta - values of .kv file are in kv format v2
+func hasStepMeta(item *filesItem) bool {
+	return item.decompressor.Features()&kvFeatureStepMeta != 0
+}
+
+// fileValue - value and step of value-word of .kv file `item`. For files in kv format v1, step is inferred
+// from file range: exact for single-step files, last step of range for merged ones.
+func (d *Domain) fileValue(item *filesItem, v []byte) ([]byte, uint64, error) {
+	if !hasStepMeta(item) {
+		return v, item.endTxNum/d.aggregationStep - 1, nil
+	}
+	if len(v) < 8 {
+		return nil, 0, fmt.Errorf("%s: value %x of %s has no step", d.filenameBase, v, item.decompressor.FileName())
+	}
+	return v[8:], binary.BigEndian.Uint64(v[:8]), nil
+}
+
+// appendStepMeta - appends value-word `v` of .kv file `item` to `buf` in kv format v2
+func (d *Domain) appendStepMeta(buf []byte, item *filesItem, v []byte) ([]byte, error) {
+	v, step, err := d.fileValue(item, v)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint64(buf, step)
+	return append(buf, v...), nil
+}
+
 // LastStepInDB - return the latest available step in db (at-least 1 value in such step)
 func (d *Domain) LastStepInDB(tx kv.Tx) (lstInDb uint64) {
 	lst, _ := kv.FirstKey(tx, d.valsTable)
@@ -196,11 +368,13 @@ func (d *Domain) LastStepInDB(tx kv.Tx) (lstInDb uint64) {
 func (d *Domain) StartWrites() {
 	d.defaultDc = d.MakeContext()
 	d.History.StartWrites()
+	d.tombstoneWrites = nil
 }
 
 func (d *Domain) FinishWrites() {
 	d.defaultDc.Close()
 	d.History.FinishWrites()
+	d.tombstoneWrites = nil
 }
 
 // OpenList - main method to open list of files.
@@ -325,6 +499,19 @@ func (d *Domain) openFiles() (err error) {
 			if item.decompressor, err = seg.NewDecompressor(datPath); err != nil {
 				return false
 			}
+			if item.vlog == nil && item.decompressor.Features()&kvFeatureValueLog != 0 {
+				vlogPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.vlog", d.filenameBase, fromStep, toStep))
+				if !dir.FileExist(vlogPath) { // values can't be read without it
+					err = fmt.Errorf("%s: values of %s are in missing %s", d.filenameBase, item.decompressor.FileName(), filepath.Base(vlogPath))
+				} else {
+					item.vlog, err = openValueLog(vlogPath)
+				}
+				if err != nil {
+					item.decompressor.Close()
+					item.decompressor = nil
+					return false
+				}
+			}
 
 			if item.index != nil {
 				continue
@@ -386,11 +573,16 @@ func (d *Domain) closeWhatNotInList(fNames []string) {
 			item.bindex.Close()
 			item.bindex = nil
 		}
+		if item.vlog != nil {
+			item.vlog.Close()
+			item.vlog = nil
+		}
 		d.files.Delete(item)
 	}
 }
 
 func (d *Domain) reCalcRoFiles() {
+	d.madv.apply(d.files)
 	roFiles := ctxFiles(d.files)
 	d.roFiles.Store(&roFiles)
 }
@@ -402,6 +594,12 @@ func (d *Domain) Close() {
 }
 
 func (dc *DomainContext) get(key []byte, fromTxNum uint64, roTx kv.Tx) ([]byte, bool, error) {
+	v, _, found, err := dc.getWithStep(key, fromTxNum, roTx)
+	return v, found, err
+}
+
+// getWithStep - same as get, but also returns step in which value was written
+func (dc *DomainContext) getWithStep(key []byte, fromTxNum uint64, roTx kv.Tx) ([]byte, uint64, bool, error) {
 	//var invertedStep [8]byte
 	dc.d.stats.TotalQueries.Add(1)
 
@@ -409,12 +607,12 @@ func (dc *DomainContext) get(key []byte, fromTxNum uint64, roTx kv.Tx) ([]byte,
 	binary.BigEndian.PutUint64(invertedStep[:], ^(fromTxNum / dc.d.aggregationStep))
 	keyCursor, err := roTx.CursorDupSort(dc.d.keysTable)
 	if err != nil {
-		return nil, false, err
+		return nil, 0, false, err
 	}
 	defer keyCursor.Close()
 	foundInvStep, err := keyCursor.SeekBothRange(key, invertedStep[:])
 	if err != nil {
-		return nil, false, err
+		return nil, 0, false, err
 	}
 	if len(foundInvStep) == 0 {
 		dc.d.stats.HistoryQueries.Add(1)
@@ -425,9 +623,25 @@ func (dc *DomainContext) get(key []byte, fromTxNum uint64, roTx kv.Tx) ([]byte,
 	copy(dc.keyBuf[len(key):], foundInvStep)
 	v, err := roTx.GetOne(dc.d.valsTable, dc.keyBuf[:len(key)+8])
 	if err != nil {
-		return nil, false, err
+		return nil, 0, false, err
 	}
-	return v, true, nil
+	return v, ^binary.BigEndian.Uint64(foundInvStep), true, nil
+}
+
+// getLatest - value of `key` in latest state and step in which it was written. Unlike get, applies prefix tombstones
+func (dc *DomainContext) getLatest(key []byte, roTx kv.Tx) ([]byte, uint64, error) {
+	v, step, _, err := dc.getWithStep(key, dc.d.txNum, roTx)
+	if err != nil || len(v) == 0 {
+		return v, step, err
+	}
+	deleted, err := dc.deletedByTombstone(key, step, dc.d.txNum, roTx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if deleted {
+		return nil, 0, nil
+	}
+	return v, step, nil
 }
 
 func (dc *DomainContext) Get(key1, key2 []byte, roTx kv.Tx) ([]byte, error) {
@@ -435,10 +649,18 @@ func (dc *DomainContext) Get(key1, key2 []byte, roTx kv.Tx) ([]byte, error) {
 	copy(dc.keyBuf[:], key1)
 	copy(dc.keyBuf[len(key1):], key2)
 	// keys larger than 52 bytes will panic
-	v, _, err := dc.get(dc.keyBuf[:len(key1)+len(key2)], dc.d.txNum, roTx)
+	v, _, err := dc.getLatest(dc.keyBuf[:len(key1)+len(key2)], roTx)
 	return v, err
 }
 
+// GetWithStep - same as Get, but also returns age of value: step in which it was written.
+// Exact for values in DB, in single-step files and in files of kv format v2 (see Domain.stepMeta).
+func (dc *DomainContext) GetWithStep(key1, key2 []byte, roTx kv.Tx) ([]byte, uint64, error) {
+	copy(dc.keyBuf[:], key1)
+	copy(dc.keyBuf[len(key1):], key2)
+	return dc.getLatest(dc.keyBuf[:len(key1)+len(key2)], roTx)
+}
+
 func (d *Domain) update(key, original []byte) error {
 	var invertedStep [8]byte
 	binary.BigEndian.PutUint64(invertedStep[:], ^(d.txNum / d.aggregationStep))
@@ -452,13 +674,27 @@ func (d *Domain) Put(key1, key2, val []byte) error {
 	key := make([]byte, len(key1)+len(key2))
 	copy(key, key1)
 	copy(key[len(key1):], key2)
-	original, _, err := d.defaultDc.get(key, d.txNum, d.tx)
+	original, step, _, err := d.defaultDc.getWithStep(key, d.txNum, d.tx)
 	if err != nil {
 		return err
 	}
-	if bytes.Equal(original, val) {
+	live := original
+	if len(original) > 0 {
+		deleted, err := d.defaultDc.deletedByTombstone(key, step, d.txNum, d.tx)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			live = nil
+		}
+	}
+	if bytes.Equal(live, val) {
 		return nil
 	}
+	if err = d.noteTombstoneWrite(key); err != nil {
+		return err
+	}
+	// history gets `original` as it is stored, not `live`: reads of history apply tombstones themselves
 	// This call to update needs to happen before d.tx.Put() later, because otherwise the content of `original`` slice is invalidated
 	if err = d.History.AddPrevValue(key1, key2, original); err != nil {
 		return err
@@ -480,25 +716,39 @@ func (d *Domain) Delete(key1, key2 []byte) error {
 	key := make([]byte, len(key1)+len(key2))
 	copy(key, key1)
 	copy(key[len(key1):], key2)
-	original, found, err := d.defaultDc.get(key, d.txNum, d.tx)
+	original, step, found, err := d.defaultDc.getWithStep(key, d.txNum, d.tx)
 	if err != nil {
 		return err
 	}
 	if !found {
 		return nil
 	}
+	if len(original) > 0 {
+		deleted, err := d.defaultDc.deletedByTombstone(key, step, d.txNum, d.tx)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return nil
+		}
+	}
+	return d.delete(key1, key2, key, original)
+}
+
+// delete - writes deletion of `key` (key1+key2) with `original` value in history
+func (d *Domain) delete(key1, key2, key, original []byte) error {
 	// This call to update needs to happen before d.tx.Delete() later, because otherwise the content of `original`` slice is invalidated
-	if err = d.History.AddPrevValue(key1, key2, original); err != nil {
+	if err := d.History.AddPrevValue(key1, key2, original); err != nil {
 		return err
 	}
-	if err = d.update(key, original); err != nil {
+	if err := d.update(key, original); err != nil {
 		return err
 	}
 	invertedStep := ^(d.txNum / d.aggregationStep)
 	keySuffix := make([]byte, len(key)+8)
 	copy(keySuffix, key)
 	binary.BigEndian.PutUint64(keySuffix[len(key):], invertedStep)
-	if err = d.tx.Delete(d.valsTable, keySuffix); err != nil {
+	if err := d.tx.Delete(d.valsTable, keySuffix); err != nil {
 		return err
 	}
 	return nil
@@ -514,14 +764,17 @@ const (
 // CursorItem is the item in the priority queue used to do merge interation
 // over storage of a given account
 type CursorItem struct {
-	c        kv.CursorDupSort
-	dg       *seg.Getter
-	dg2      *seg.Getter
-	key      []byte
-	val      []byte
-	endTxNum uint64
-	t        CursorType // Whether this item represents state file or DB record, or tree
-	reverse  bool
+	c          kv.CursorDupSort
+	dg         *ArchiveGetter
+	dg2        *ArchiveGetter
+	key        []byte
+	val        []byte
+	startTxNum uint64
+	endTxNum   uint64
+	fileIdx    int        // FILE_CURSOR of DomainContext: index of file in DomainContext.files
+	src        *filesItem // FILE_CURSOR of merge: source file
+	t          CursorType // Whether this item represents state file or DB record, or tree
+	reverse    bool
 }
 
 type CursorHeap []*CursorItem
@@ -559,6 +812,28 @@ func (ch *CursorHeap) Pop() interface{} {
 	return x
 }
 
+var cursorItemPool = sync.Pool{New: func() any { return &CursorItem{} }}
+
+// newCursorItem - from pool: merges create item per file and per merge. Must be returned by putCursorItem
+// when it's removed from heap (or by CursorHeap.Release)
+func newCursorItem() *CursorItem { return cursorItemPool.Get().(*CursorItem) }
+
+func putCursorItem(ci *CursorItem) {
+	ci.Reset()
+	cursorItemPool.Put(ci)
+}
+
+// Reset - drops references to cursors, getters and key/value (which may point to mmap of file)
+func (ci *CursorItem) Reset() { *ci = CursorItem{} }
+
+// Release - returns items left in heap to pool
+func (ch *CursorHeap) Release() {
+	for _, ci := range *ch {
+		putCursorItem(ci)
+	}
+	*ch = (*ch)[:0]
+}
+
 // filesItem corresponding to a pair of files (.dat and .idx)
 type ctxItem struct {
 	getter     *seg.Getter
@@ -592,6 +867,8 @@ type DomainContext struct {
 	hc      *HistoryContext
 	keyBuf  [60]byte // 52b key and 8b for inverted step
 	numBuf  [8]byte
+
+	tombstoneKey []byte // copy of key checked by deletedByTombstone: lookup of tombstone reuses keyBuf
 }
 
 func (dc *DomainContext) statelessGetter(i int) *seg.Getter {
@@ -640,6 +917,10 @@ func (d *Domain) collectFilesStats() (datsz, idxsz, files uint64) {
 			idxsz += uint64(item.index.Size())
 			idxsz += uint64(item.bindex.Size())
 			files += 3
+			if item.vlog != nil {
+				datsz += uint64(item.vlog.Size())
+				files++
+			}
 		}
 		return true
 	})
@@ -658,9 +939,7 @@ func (d *Domain) MakeContext() *DomainContext {
 		files: *d.roFiles.Load(),
 	}
 	for _, item := range dc.files {
-		if !item.src.frozen {
-			item.src.refcount.Add(1)
-		}
+		item.src.refcount.Add(1)
 	}
 
 	return dc
@@ -669,6 +948,7 @@ func (d *Domain) MakeContext() *DomainContext {
 // Collation is the set of compressors created after aggregation
 type Collation struct {
 	valuesComp   *seg.Compressor
+	valuesLog    *valueLogWriter // nil if value-log is disabled
 	historyComp  *seg.Compressor
 	indexBitmaps map[string]*roaring64.Bitmap
 	valuesPath   string
@@ -681,6 +961,7 @@ func (c Collation) Close() {
 	if c.valuesComp != nil {
 		c.valuesComp.Close()
 	}
+	c.valuesLog.Close()
 	if c.historyComp != nil {
 		c.historyComp.Close()
 	}
@@ -690,13 +971,16 @@ type kvpair struct {
 	k, v []byte
 }
 
-func (d *Domain) writeCollationPair(valuesComp *seg.Compressor, pairs chan kvpair) (count int, err error) {
+func (d *Domain) writeCollationPair(valuesComp *seg.Compressor, valuesLog *valueLogWriter, pairs chan kvpair) (count int, err error) {
 	for kv := range pairs {
 		if err = valuesComp.AddUncompressedWord(kv.k); err != nil {
 			return count, fmt.Errorf("add %s values key [%x]: %w", d.filenameBase, kv.k, err)
 		}
-		mxCollationSize.Inc()
+		d.mx.collationSize.Inc()
 		count++ // Only counting keys, not values
+		if kv.v, err = valuesLog.word(kv.v, false); err != nil {
+			return count, err
+		}
 		if err = valuesComp.AddUncompressedWord(kv.v); err != nil {
 			return count, fmt.Errorf("add %s values val [%x]=>[%x]: %w", d.filenameBase, kv.k, kv.v, err)
 		}
@@ -706,14 +990,14 @@ func (d *Domain) writeCollationPair(valuesComp *seg.Compressor, pairs chan kvpai
 
 // nolint
 func (d *Domain) aggregate(ctx context.Context, step uint64, txFrom, txTo uint64, tx kv.Tx, ps *background.ProgressSet) (err error) {
-	mxRunningCollations.Inc()
+	d.mx.runningCollations.Inc()
 	start := time.Now()
 	collation, err := d.collateStream(ctx, step, txFrom, txTo, tx)
-	mxRunningCollations.Dec()
-	mxCollateTook.ObserveDuration(start)
+	d.mx.runningCollations.Dec()
+	d.mx.collateTook.ObserveDuration(start)
 
-	mxCollationSize.SetInt(collation.valuesComp.Count())
-	mxCollationSizeHist.SetInt(collation.historyComp.Count())
+	d.mx.collationSize.SetInt(collation.valuesComp.Count())
+	d.mx.collationSizeHist.SetInt(collation.historyComp.Count())
 
 	if err != nil {
 		collation.Close()
@@ -721,7 +1005,7 @@ func (d *Domain) aggregate(ctx context.Context, step uint64, txFrom, txTo uint64
 		return err
 	}
 
-	mxRunningMerges.Inc()
+	d.mx.runningMerges.Inc()
 
 	start = time.Now()
 	sf, err := d.buildFiles(ctx, step, collation, ps)
@@ -730,11 +1014,11 @@ func (d *Domain) aggregate(ctx context.Context, step uint64, txFrom, txTo uint64
 
 	if err != nil {
 		sf.Close()
-		mxRunningMerges.Dec()
+		d.mx.runningMerges.Dec()
 		return
 	}
 
-	mxRunningMerges.Dec()
+	d.mx.runningMerges.Dec()
 
 	d.integrateFiles(sf, step*d.aggregationStep, (step+1)*d.aggregationStep)
 	d.stats.LastFileBuildingTook = time.Since(start)
@@ -750,18 +1034,20 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 		d.stats.LastCollationTook = time.Since(started)
 	}()
 
-	hCollation, err := d.History.collate(step, txFrom, txTo, roTx)
+	hCollation, err := d.History.collate(ctx, step, txFrom, txTo, roTx)
 	if err != nil {
 		return Collation{}, err
 	}
 
 	var valuesComp *seg.Compressor
+	var valuesLog *valueLogWriter
 	closeComp := true
 	defer func() {
 		if closeComp {
 			if valuesComp != nil {
 				valuesComp.Close()
 			}
+			valuesLog.Close()
 		}
 	}()
 
@@ -769,6 +1055,10 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 	if valuesComp, err = seg.NewCompressor(context.Background(), "collate values", valuesPath, d.tmpdir, seg.MinPatternScore, 1, log.LvlTrace, d.logger); err != nil {
 		return Collation{}, fmt.Errorf("create %s values compressor: %w", d.filenameBase, err)
 	}
+	valuesComp.SetChecksums(dbg.SegChecksums)
+	if valuesLog, err = d.newValueLogWriter(valuesComp, valuesPath); err != nil {
+		return Collation{}, err
+	}
 
 	keysCursor, err := roTx.CursorDupSort(d.keysTable)
 	if err != nil {
@@ -790,7 +1080,7 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 
 	eg, _ := errgroup.WithContext(ctx)
 	eg.Go(func() error {
-		valCount, err = d.writeCollationPair(valuesComp, pairs)
+		valCount, err = d.writeCollationPair(valuesComp, valuesLog, pairs)
 		return err
 	})
 
@@ -802,6 +1092,9 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 
 	for k, _, err = keysCursor.First(); err == nil && k != nil; k, _, err = keysCursor.NextNoDup() {
 		pos++
+		if d.keep != nil && !d.keep(k) {
+			continue
+		}
 
 		if v, err = keysCursor.LastDup(); err != nil {
 			return Collation{}, fmt.Errorf("find last %s key for aggregation step k=[%x]: %w", d.filenameBase, k, err)
@@ -838,6 +1131,7 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 	return Collation{
 		valuesPath:   valuesPath,
 		valuesComp:   valuesComp,
+		valuesLog:    valuesLog,
 		valuesCount:  valCount,
 		historyPath:  hCollation.historyPath,
 		historyComp:  hCollation.historyComp,
@@ -850,16 +1144,19 @@ func (d *Domain) collateStream(ctx context.Context, step, txFrom, txTo uint64, r
 // and returns compressors, elias fano, and bitmaps
 // [txFrom; txTo)
 func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.Ticker) (Collation, error) {
+	ctx, span := startSpan(ctx, "collate", d.filenameBase, txFrom, txTo, d.aggregationStep)
+	defer span.End()
 	started := time.Now()
 	defer func() {
 		d.stats.LastCollationTook = time.Since(started)
 	}()
 
-	hCollation, err := d.History.collate(step, txFrom, txTo, roTx)
+	hCollation, err := d.History.collate(ctx, step, txFrom, txTo, roTx)
 	if err != nil {
 		return Collation{}, err
 	}
 	var valuesComp *seg.Compressor
+	var valuesLog *valueLogWriter
 	closeComp := true
 	defer func() {
 		if closeComp {
@@ -867,12 +1164,17 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 			if valuesComp != nil {
 				valuesComp.Close()
 			}
+			valuesLog.Close()
 		}
 	}()
 	valuesPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, step, step+1))
 	if valuesComp, err = seg.NewCompressor(context.Background(), "collate values", valuesPath, d.tmpdir, seg.MinPatternScore, 1, log.LvlTrace, d.logger); err != nil {
 		return Collation{}, fmt.Errorf("create %s values compressor: %w", d.filenameBase, err)
 	}
+	valuesComp.SetChecksums(dbg.SegChecksums)
+	if valuesLog, err = d.newValueLogWriter(valuesComp, valuesPath); err != nil {
+		return Collation{}, err
+	}
 	keysCursor, err := roTx.CursorDupSort(d.keysTable)
 	if err != nil {
 		return Collation{}, fmt.Errorf("create %s keys cursor: %w", d.filenameBase, err)
@@ -901,6 +1203,9 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 			return Collation{}, ctx.Err()
 		default:
 		}
+		if d.keep != nil && !d.keep(k) {
+			continue
+		}
 
 		if v, err = keysCursor.LastDup(); err != nil {
 			return Collation{}, fmt.Errorf("find last %s key for aggregation step k=[%x]: %w", d.filenameBase, k, err)
@@ -918,6 +1223,9 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 				return Collation{}, fmt.Errorf("add %s values key [%x]: %w", d.filenameBase, k, err)
 			}
 			valuesCount++ // Only counting keys, not values
+			if v, err = valuesLog.word(v, false); err != nil {
+				return Collation{}, err
+			}
 			if err = valuesComp.AddUncompressedWord(v); err != nil {
 				return Collation{}, fmt.Errorf("add %s values val [%x]=>[%x]: %w", d.filenameBase, k, v, err)
 			}
@@ -930,6 +1238,7 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 	return Collation{
 		valuesPath:   valuesPath,
 		valuesComp:   valuesComp,
+		valuesLog:    valuesLog,
 		valuesCount:  int(valuesCount),
 		historyPath:  hCollation.historyPath,
 		historyComp:  hCollation.historyComp,
@@ -942,6 +1251,7 @@ type StaticFiles struct {
 	valuesDecomp    *seg.Decompressor
 	valuesIdx       *recsplit.Index
 	valuesBt        *BtIndex
+	valuesLog       *valueLog
 	historyDecomp   *seg.Decompressor
 	historyIdx      *recsplit.Index
 	efHistoryDecomp *seg.Decompressor
@@ -958,6 +1268,7 @@ func (sf StaticFiles) Close() {
 	if sf.valuesBt != nil {
 		sf.valuesBt.Close()
 	}
+	sf.valuesLog.Close()
 	if sf.historyDecomp != nil {
 		sf.historyDecomp.Close()
 	}
@@ -975,6 +1286,10 @@ func (sf StaticFiles) Close() {
 // buildFiles performs potentially resource intensive operations of creating
 // static files and their indices
 func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collation, ps *background.ProgressSet) (StaticFiles, error) {
+	ctx, span := startSpan(ctx, "build_files", d.filenameBase, step*d.aggregationStep, (step+1)*d.aggregationStep, d.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "build_files", d.filenameBase, step*d.aggregationStep, (step+1)*d.aggregationStep, d.aggregationStep)
+	defer restoreLabels()
 	hStaticFiles, err := d.History.buildFiles(ctx, step, HistoryCollation{
 		historyPath:  collation.historyPath,
 		historyComp:  collation.historyComp,
@@ -984,9 +1299,11 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 	if err != nil {
 		return StaticFiles{}, err
 	}
+	defer fileTook(mxBuildFileTook, collation.valuesPath, d.metricsLabel).PutSince()
 	valuesComp := collation.valuesComp
 	var valuesDecomp *seg.Decompressor
 	var valuesIdx *recsplit.Index
+	var valuesLog *valueLog
 	closeComp := true
 	defer func() {
 		if closeComp {
@@ -994,17 +1311,24 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 			if valuesComp != nil {
 				valuesComp.Close()
 			}
+			collation.valuesLog.Close()
 			if valuesDecomp != nil {
 				valuesDecomp.Close()
 			}
 			if valuesIdx != nil {
 				valuesIdx.Close()
 			}
+			valuesLog.Close()
 		}
 	}()
 	if d.noFsync {
 		valuesComp.DisableFsync()
 	}
+	if collation.valuesLog != nil { // before .kv: .kv with tagged values must never be without .vlog
+		if valuesLog, err = collation.valuesLog.finish(); err != nil {
+			return StaticFiles{}, fmt.Errorf("finish %s value-log: %w", d.filenameBase, err)
+		}
+	}
 	if err = valuesComp.Compress(); err != nil {
 		return StaticFiles{}, fmt.Errorf("compress %s values: %w", d.filenameBase, err)
 	}
@@ -1019,7 +1343,7 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 	{
 		p := ps.AddNew(valuesIdxFileName, uint64(valuesDecomp.Count()*2))
 		defer ps.Delete(p)
-		if valuesIdx, err = buildIndexThenOpen(ctx, valuesDecomp, valuesIdxPath, d.tmpdir, collation.valuesCount, false, p, d.logger, d.noFsync); err != nil {
+		if valuesIdx, err = buildIndexThenOpen(ctx, valuesDecomp, valuesIdxPath, d.tmpdir, collation.valuesCount, false, p, d.logger, d.noFsync, d.metricsLabel); err != nil {
 			return StaticFiles{}, fmt.Errorf("build %s values idx: %w", d.filenameBase, err)
 		}
 	}
@@ -1041,6 +1365,7 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 		valuesDecomp:    valuesDecomp,
 		valuesIdx:       valuesIdx,
 		valuesBt:        bt,
+		valuesLog:       valuesLog,
 		historyDecomp:   hStaticFiles.historyDecomp,
 		historyIdx:      hStaticFiles.historyIdx,
 		efHistoryDecomp: hStaticFiles.efHistoryDecomp,
@@ -1083,14 +1408,15 @@ func (d *Domain) BuildMissedIndices(ctx context.Context, g *errgroup.Group, ps *
 	return nil
 }
 
-func buildIndexThenOpen(ctx context.Context, d *seg.Decompressor, idxPath, tmpdir string, count int, values bool, p *background.Progress, logger log.Logger, noFsync bool) (*recsplit.Index, error) {
-	if err := buildIndex(ctx, d, idxPath, tmpdir, count, values, p, logger, noFsync); err != nil {
+func buildIndexThenOpen(ctx context.Context, d *seg.Decompressor, idxPath, tmpdir string, count int, values bool, p *background.Progress, logger log.Logger, noFsync bool, metricsLabel string) (*recsplit.Index, error) {
+	if err := buildIndex(ctx, d, idxPath, tmpdir, count, values, p, logger, noFsync, metricsLabel); err != nil {
 		return nil, err
 	}
 	return recsplit.OpenIndex(idxPath)
 }
 
-func buildIndex(ctx context.Context, d *seg.Decompressor, idxPath, tmpdir string, count int, values bool, p *background.Progress, logger log.Logger, noFsync bool) error {
+func buildIndex(ctx context.Context, d *seg.Decompressor, idxPath, tmpdir string, count int, values bool, p *background.Progress, logger log.Logger, noFsync bool, metricsLabel string) error {
+	defer fileTook(mxBuildIndexTook, idxPath, metricsLabel).PutSince()
 	var rs *recsplit.RecSplit
 	var err error
 	if rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
@@ -1135,6 +1461,9 @@ func buildIndex(ctx context.Context, d *seg.Decompressor, idxPath, tmpdir string
 
 			p.Processed.Add(1)
 		}
+		if err := g.Err(); err != nil {
+			return err
+		}
 		if err = rs.Build(ctx); err != nil {
 			if rs.Collision() {
 				logger.Info("Building recsplit. Collision happened. It's ok. Restarting...")
@@ -1161,6 +1490,7 @@ func (d *Domain) integrateFiles(sf StaticFiles, txNumFrom, txNumTo uint64) {
 	fi.decompressor = sf.valuesDecomp
 	fi.index = sf.valuesIdx
 	fi.bindex = sf.valuesBt
+	fi.vlog = sf.valuesLog
 	d.files.Set(fi)
 
 	d.reCalcRoFiles()
@@ -1168,9 +1498,13 @@ func (d *Domain) integrateFiles(sf StaticFiles, txNumFrom, txNumTo uint64) {
 
 // [txFrom; txTo)
 func (d *Domain) prune(ctx context.Context, step uint64, txFrom, txTo, limit uint64, logEvery *time.Ticker) error {
+	ctx, span := startSpan(ctx, "prune", d.filenameBase, txFrom, txTo, d.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "prune", d.filenameBase, txFrom, txTo, d.aggregationStep)
+	defer restoreLabels()
 	defer func(t time.Time) { d.stats.LastPruneTook = time.Since(t) }(time.Now())
-	mxPruningProgress.Inc()
-	defer mxPruningProgress.Dec()
+	d.mx.pruningProgress.Inc()
+	defer d.mx.pruningProgress.Dec()
 
 	var (
 		_state    = "scan steps"
@@ -1204,7 +1538,20 @@ func (d *Domain) prune(ctx context.Context, step uint64, txFrom, txTo, limit uin
 			if err != nil {
 				break
 			}
-			if kl == nil && vl == nil {
+			if kl == nil && vl == nil { // latest value of key
+				if !d.stepMeta {
+					continue
+				}
+				// files keep exact step of value (single-step file, merged into kv format v2): DB doesn't need it
+				k = common.Copy(k)
+				if _, _, err = keysCursor.SeekBothExact(k, stepBytes); err != nil {
+					break
+				}
+				if err = keysCursor.DeleteCurrent(); err != nil {
+					return fmt.Errorf("prune key %x: %w", k, err)
+				}
+				d.mx.pruneSize.Inc()
+				keyMaxSteps[string(k)] = step
 				continue
 			}
 			s := ^binary.BigEndian.Uint64(vl)
@@ -1214,11 +1561,11 @@ func (d *Domain) prune(ctx context.Context, step uint64, txFrom, txTo, limit uin
 					break
 				}
 				if bytes.Equal(vn, stepBytes) {
+					keyMaxSteps[string(k)] = s // before delete: `k` points to page of DB
 					if err := keysCursor.DeleteCurrent(); err != nil {
 						return fmt.Errorf("prune key %x: %w", k, err)
 					}
-					mxPruneSize.Inc()
-					keyMaxSteps[string(k)] = s
+					d.mx.pruneSize.Inc()
 				}
 			}
 		}
@@ -1255,13 +1602,13 @@ func (d *Domain) prune(ctx context.Context, step uint64, txFrom, txTo, limit uin
 
 	for k, _, err := valsCursor.First(); err == nil && k != nil; k, _, err = valsCursor.Next() {
 		if bytes.HasSuffix(k, stepBytes) {
-			if _, ok := keyMaxSteps[string(k)]; !ok {
+			if _, ok := keyMaxSteps[string(k[:len(k)-len(stepBytes)])]; !ok {
 				continue
 			}
 			if err := valsCursor.DeleteCurrent(); err != nil {
 				return fmt.Errorf("prune val %x: %w", k, err)
 			}
-			mxPruneSize.Inc()
+			d.mx.pruneSize.Inc()
 		}
 		pos.Add(1)
 		//_prog = 100 * (float64(pos) / float64(totalKeys))
@@ -1357,8 +1704,9 @@ func (d *Domain) warmup(ctx context.Context, txFrom, limit uint64, tx kv.Tx) err
 
 var COMPARE_INDEXES = false // if true, will compare values from Btree and INvertedIndex
 
-func (dc *DomainContext) readFromFiles(filekey []byte, fromTxNum uint64) ([]byte, bool, error) {
+func (dc *DomainContext) readFromFiles(filekey []byte, fromTxNum uint64) ([]byte, uint64, bool, error) {
 	var val []byte
+	var step uint64
 	var found bool
 
 	for i := len(dc.files) - 1; i >= 0; i-- {
@@ -1372,19 +1720,21 @@ func (dc *DomainContext) readFromFiles(filekey []byte, fromTxNum uint64) ([]byte
 		cur, err := reader.Seek(filekey)
 		if err != nil {
 			//return nil, false, nil //TODO: uncomment me
-			return nil, false, err
+			return nil, 0, false, err
 		}
 		if cur == nil {
 			continue
 		}
 
 		if bytes.Equal(cur.Key(), filekey) {
-			val = cur.Value()
+			if val, step, err = dc.fileValue(i, cur.Value()); err != nil {
+				return nil, 0, false, err
+			}
 			found = true
 			break
 		}
 	}
-	return val, found, nil
+	return val, step, found, nil
 }
 
 // historyBeforeTxNum searches history for a value of specified key before txNum
@@ -1430,7 +1780,9 @@ func (dc *DomainContext) historyBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx
 				continue
 			}
 			if bytes.Equal(cur.Key(), key) {
-				val = cur.Value()
+				if val, _, err = dc.fileValue(i, cur.Value()); err != nil {
+					return nil, false, err
+				}
 				break
 			}
 		}
@@ -1446,6 +1798,22 @@ func (dc *DomainContext) historyBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx
 // GetBeforeTxNum does not always require usage of roTx. If it is possible to determine
 // historical value based only on static files, roTx will not be used.
 func (dc *DomainContext) GetBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx) ([]byte, error) {
+	v, err := dc.getBeforeTxNum(key, txNum, roTx)
+	if err != nil || len(v) == 0 {
+		return v, err
+	}
+	deleted, err := dc.deletedByTombstoneBefore(key, txNum, roTx)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// getBeforeTxNum - GetBeforeTxNum without prefix tombstones: value as it is stored
+func (dc *DomainContext) getBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx) ([]byte, error) {
 	v, hOk, err := dc.historyBeforeTxNum(key, txNum, roTx)
 	if err != nil {
 		return nil, err
@@ -1466,9 +1834,6 @@ func (dc *DomainContext) GetBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx) ([
 
 func (dc *DomainContext) Close() {
 	for _, item := range dc.files {
-		if item.src.frozen {
-			continue
-		}
 		refCnt := item.src.refcount.Add(-1)
 		//GC: last reader responsible to remove useles files: close it and delete
 		if refCnt == 0 && item.src.canDelete.Load() {
@@ -1483,6 +1848,12 @@ func (dc *DomainContext) Close() {
 // inside the domain. Another version of this for public API use needs to be created, that uses
 // roTx instead and supports ending the iterations before it reaches the end.
 func (dc *DomainContext) IteratePrefix(prefix []byte, it func(k, v []byte)) error {
+	return dc.iteratePrefix(prefix, false, it)
+}
+
+// iteratePrefix - `raw`: keys deleted by prefix tombstones are not skipped, values are as they are stored.
+// Tombstones themselves are never passed to `it`
+func (dc *DomainContext) iteratePrefix(prefix []byte, raw bool, it func(k, v []byte)) error {
 	dc.d.stats.HistoryQueries.Add(1)
 
 	var cp CursorHeap
@@ -1524,21 +1895,34 @@ func (dc *DomainContext) IteratePrefix(prefix []byte, it func(k, v []byte)) erro
 		key := cursor.Key()
 		if bytes.HasPrefix(key, prefix) {
 			val := cursor.Value()
-			heap.Push(&cp, &CursorItem{t: FILE_CURSOR, key: key, val: val, dg: g, endTxNum: item.endTxNum, reverse: true})
+			heap.Push(&cp, &CursorItem{t: FILE_CURSOR, key: key, val: val, dg: NewArchiveGetter(g, dc.d.compressVals).ZeroCopy(), startTxNum: item.startTxNum, endTxNum: item.endTxNum, fileIdx: i, reverse: true})
 		}
 	}
 	for cp.Len() > 0 {
 		lastKey := common.Copy(cp[0].key)
-		lastVal := common.Copy(cp[0].val)
+		lastVal := cp[0].val
+		lastStep := cp[0].endTxNum / dc.d.aggregationStep // endTxNum of DB_CURSOR is start of step of value
+		var subStore bool                                 // entry of code sub-store: not a key of domain
+		if cp[0].t == FILE_CURSOR {
+			if subStore = hasCodeByHash(dc.files[cp[0].fileIdx].src) && isCodeHashKey(lastKey); !subStore {
+				if lastVal, lastStep, err = dc.fileValue(cp[0].fileIdx, lastVal); err != nil {
+					return err
+				}
+			}
+		}
+		lastVal = common.Copy(lastVal)
 		// Advance all the items that have this key (including the top)
 		for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 			ci1 := cp[0]
 			switch ci1.t {
 			case FILE_CURSOR:
 				if ci1.dg.HasNext() {
-					ci1.key, _ = ci1.dg.Next(ci1.key[:0])
+					ci1.key, _ = ci1.dg.NextKey(nil)
 					if bytes.HasPrefix(ci1.key, prefix) {
-						ci1.val, _ = ci1.dg.Next(ci1.val[:0])
+						ci1.val, _ = ci1.dg.NextVal(nil)
+						if err := ci1.dg.Err(); err != nil {
+							return err
+						}
 						heap.Fix(&cp, 0)
 					} else {
 						heap.Pop(&cp)
@@ -1553,6 +1937,7 @@ func (dc *DomainContext) IteratePrefix(prefix []byte, it func(k, v []byte)) erro
 				}
 				if k != nil && bytes.HasPrefix(k, prefix) {
 					ci1.key = common.Copy(k)
+					ci1.endTxNum = ^binary.BigEndian.Uint64(v) * dc.d.aggregationStep
 					keySuffix := make([]byte, len(k)+8)
 					copy(keySuffix, k)
 					copy(keySuffix[len(k):], v)
@@ -1566,9 +1951,19 @@ func (dc *DomainContext) IteratePrefix(prefix []byte, it func(k, v []byte)) erro
 				}
 			}
 		}
-		if len(lastVal) > 0 {
-			it(lastKey, lastVal)
+		if len(lastVal) == 0 || subStore || (dc.d.tombstonePrefixLen > 0 && len(lastKey) == dc.d.tombstonePrefixLen) {
+			continue
+		}
+		if !raw {
+			deleted, err := dc.deletedByTombstone(lastKey, lastStep, dc.d.txNum, dc.d.tx)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				continue
+			}
 		}
+		it(lastKey, lastVal)
 	}
 	return nil
 }
@@ -0,0 +1,176 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/seg"
+	"golang.org/x/crypto/sha3"
+)
+
+// Content-addressed code storage (Domain.codeByHash).
+//
+// Identical contracts (proxies, clones) are deployed at thousands of addresses. Merged .kv files of code domain
+// store `addr -> codeHash` and bytecode once per file - in sub-store of same file under `codeHash` key.
+// Keys of code domain are addresses: keys of length.Hash are entries of sub-store.
+// DB and single-step files keep `addr -> code`: migration happens at merge time. Each merged file is self-contained:
+// code of address is always in same file as address, code not referenced by any address of file is dropped on merge.
+// Layout is recorded in file (kvFeatureCodeByHash): setting can be changed for datadir, it applies to new merged files.
+// Merge of files which already have sub-store keeps it.
+
+// kvFeatureCodeByHash - .kv file stores `addr -> codeHash` and `codeHash -> code`, see Domain.codeByHash
+const kvFeatureCodeByHash uint64 = 1 << 2
+
+// withCodeByHash - new merged .kv file of range [startTxNum, endTxNum) is written with code sub-store
+func (d *Domain) withCodeByHash(startTxNum, endTxNum uint64) bool {
+	return d.codeByHash && endTxNum-startTxNum > d.aggregationStep
+}
+
+// hasCodeByHash - format of existing file: recorded in file itself, doesn't depend on current setting
+func hasCodeByHash(item *filesItem) bool {
+	return item.decompressor.Features()&kvFeatureCodeByHash != 0
+}
+
+func isCodeHashKey(k []byte) bool { return len(k) == length.Hash }
+
+// fileValue - value of key found in `i`-th file of context: without step meta, resolved from value-log
+// and with code resolved by hash
+func (dc *DomainContext) fileValue(i int, v []byte) ([]byte, uint64, error) {
+	item := dc.files[i]
+	v, step, err := dc.d.fileValue(item.src, v)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v, err = item.src.vlog.value(v); err != nil {
+		return nil, 0, err
+	}
+	if len(v) == 0 || !hasCodeByHash(item.src) {
+		return v, step, nil
+	}
+	reader := dc.statelessBtree(i)
+	cur, err := reader.Seek(v)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cur == nil || !bytes.Equal(cur.Key(), v) {
+		return nil, 0, fmt.Errorf("%s: code %x not found in %s", dc.d.filenameBase, v, reader.FileName())
+	}
+	code, _, err := dc.d.fileValue(item.src, cur.Value())
+	if err != nil {
+		return nil, 0, err
+	}
+	if code, err = item.src.vlog.value(code); err != nil {
+		return nil, 0, err
+	}
+	return code, step, nil
+}
+
+// codeMerger - collects pairs of merged code file: `addr -> codeHash` and de-duplicated `codeHash -> code`
+type codeMerger struct {
+	d          *Domain
+	stepMeta   bool // values are in kv format v2
+	keccak     hash.Hash
+	pairs      *etl.Collector
+	codes      *etl.Collector // may have duplicates
+	referenced map[string]struct{}
+	hashBuf    []byte
+	valBuf     []byte
+}
+
+func newCodeMerger(d *Domain, stepMeta bool) *codeMerger {
+	return &codeMerger{
+		d:          d,
+		stepMeta:   stepMeta,
+		keccak:     sha3.NewLegacyKeccak256(),
+		pairs:      etl.NewCollector(d.filenameBase+" merge", d.tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize/8), d.logger),
+		codes:      etl.NewCollector(d.filenameBase+" merge codes", d.tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize/8), d.logger),
+		referenced: map[string]struct{}{},
+	}
+}
+
+// add - pair of source file `src`. `v` is already in format of merged file (with step meta if enabled)
+func (m *codeMerger) add(src *filesItem, k, v []byte) error {
+	var meta []byte
+	val := v
+	if m.stepMeta {
+		meta, val = v[:8], v[8:]
+	}
+	if hasCodeByHash(src) {
+		if isCodeHashKey(k) { // survives only if still referenced
+			return m.codes.Collect(k, v)
+		}
+		if len(val) > 0 {
+			m.referenced[string(val)] = struct{}{}
+		}
+		return m.pairs.Collect(k, v)
+	}
+	if len(val) == 0 {
+		return m.pairs.Collect(k, v)
+	}
+	m.keccak.Reset()
+	m.keccak.Write(val)
+	m.hashBuf = m.keccak.Sum(m.hashBuf[:0])
+	m.referenced[string(m.hashBuf)] = struct{}{}
+	if err := m.codes.Collect(m.hashBuf, v); err != nil {
+		return err
+	}
+	m.valBuf = append(append(m.valBuf[:0], meta...), m.hashBuf...)
+	return m.pairs.Collect(k, m.valBuf)
+}
+
+// flush - writes all collected pairs to `comp` (big values to `vlog`) in sorted order, returns amount of keys
+func (m *codeMerger) flush(comp *seg.Compressor, compressVals bool, vlog *valueLogWriter) (keyCount int, err error) {
+	var prev []byte
+	if err = m.codes.Load(nil, "", func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		if bytes.Equal(k, prev) {
+			return nil
+		}
+		prev = append(prev[:0], k...)
+		if _, ok := m.referenced[string(k)]; !ok {
+			return nil
+		}
+		return m.pairs.Collect(k, v)
+	}, etl.TransformArgs{}); err != nil {
+		return 0, fmt.Errorf("merge %s codes: %w", m.d.filenameBase, err)
+	}
+	if err = m.pairs.Load(nil, "", func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		if err := comp.AddUncompressedWord(k); err != nil {
+			return err
+		}
+		keyCount++ // Only counting keys, not values
+		if v, err = vlog.word(v, m.stepMeta); err != nil {
+			return err
+		}
+		if compressVals {
+			return comp.AddWord(v)
+		}
+		return comp.AddUncompressedWord(v)
+	}, etl.TransformArgs{}); err != nil {
+		return 0, fmt.Errorf("merge %s: %w", m.d.filenameBase, err)
+	}
+	return keyCount, nil
+}
+
+func (m *codeMerger) Close() {
+	m.pairs.Close()
+	m.codes.Close()
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash"
+	"math"
 	"path/filepath"
 	"strings"
 	"time"
@@ -32,6 +33,9 @@ import (
 	"golang.org/x/crypto/sha3"
 
 	"github.com/ledgerwatch/erigon-lib/common/background"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	"github.com/ledgerwatch/erigon-lib/etl"
 
 	"github.com/ledgerwatch/erigon-lib/commitment"
 	"github.com/ledgerwatch/erigon-lib/common"
@@ -88,22 +92,40 @@ type DomainCommitted struct {
 	comKeys uint64
 	comTook time.Duration
 	logger  log.Logger
+
+	// touched keys spilled to disk when commTree grows above spillLimit (only in CommitmentModeDirect:
+	// CommitmentModeUpdate merges updates of same key in commTree). 0 - disabled
+	spillLimit int
+	spill      *etl.Collector
+	spillErr   error // first error of spill, returned by ComputeCommitment
+
+	// trie states: txNum_u64 -> commitmentState. Not part of domain (its files, history, merges): states are
+	// needed only to restart trie computation. Old releases stored them in domain under keyCommitmentState
+	stateTable string
 }
 
-func NewCommittedDomain(d *Domain, mode CommitmentMode, trieVariant commitment.TrieVariant, logger log.Logger) *DomainCommitted {
+func NewCommittedDomain(d *Domain, stateTable string, mode CommitmentMode, trieVariant commitment.TrieVariant, logger log.Logger) *DomainCommitted {
 	return &DomainCommitted{
 		Domain:       d,
+		stateTable:   stateTable,
 		patriciaTrie: commitment.InitializeTrie(trieVariant),
 		commTree:     btree.NewG[*CommitmentItem](32, commitmentItemLess),
 		keccak:       sha3.NewLegacyKeccak256(),
 		mode:         mode,
 		branchMerger: commitment.NewHexBranchMerger(8192),
 		logger:       logger,
+		spillLimit:   dbg.CommitmentSpillKeys,
 	}
 }
 
 func (d *DomainCommitted) SetCommitmentMode(m CommitmentMode) { d.mode = m }
 
+// debug - debug output of commitment is enabled (see SetDomainDebug)
+func (d *DomainCommitted) debug() bool { return d.trace || domainDebug(d.filenameBase) }
+
+// SetSpillLimit - amount of touched keys to keep in RAM, before moving them to disk. 0 - never spill
+func (d *DomainCommitted) SetSpillLimit(keys int) { d.spillLimit = keys }
+
 // TouchPlainKey marks plainKey as updated and applies different fn for different key types
 // (different behaviour for Code, Account and Storage key modifications).
 func (d *DomainCommitted) TouchPlainKey(key, val []byte, fn func(c *CommitmentItem, val []byte)) {
@@ -115,6 +137,26 @@ func (d *DomainCommitted) TouchPlainKey(key, val []byte, fn func(c *CommitmentIt
 		fn(c, val)
 	}
 	d.commTree.ReplaceOrInsert(c)
+	if d.spillLimit > 0 && d.spillErr == nil && d.mode == CommitmentModeDirect && d.commTree.Len() >= d.spillLimit {
+		d.spillErr = d.spillTouchedKeys() // keys stay in RAM, error is returned by ComputeCommitment
+	}
+}
+
+// spillTouchedKeys - moves touched keys from commTree to sorted files in tmpdir: hashedKey -> plainKey
+func (d *DomainCommitted) spillTouchedKeys() (err error) {
+	if d.spill == nil {
+		d.spill = etl.NewCollector("commitment touched keys", d.tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize/8), d.logger)
+		d.spill.LogLvl(log.LvlTrace)
+	}
+	d.commTree.Ascend(func(item *CommitmentItem) bool {
+		err = d.spill.Collect(item.hashedKey, item.plainKey)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	d.commTree.Clear(true)
+	return nil
 }
 
 func (d *DomainCommitted) TouchPlainKeyAccount(c *CommitmentItem, val []byte) {
@@ -181,6 +223,7 @@ func commitmentItemLess(i, j *CommitmentItem) bool {
 }
 
 // Returns list of both plain and hashed keys. If .mode is CommitmentModeUpdate, updates also returned.
+// Keys spilled to disk are not included: see spilledKeys.
 func (d *DomainCommitted) TouchedKeyList() ([][]byte, [][]byte, []commitment.Update) {
 	plainKeys := make([][]byte, d.commTree.Len())
 	hashedKeys := make([][]byte, d.commTree.Len())
@@ -199,6 +242,34 @@ func (d *DomainCommitted) TouchedKeyList() ([][]byte, [][]byte, []commitment.Upd
 	return plainKeys, hashedKeys, updates
 }
 
+// spilledKeys - touched keys spilled to disk together with keys still in commTree, streamed from tmpdir: sorted and
+// de-duplicated (same key could be touched before and after spill). Consumes collector: iterate once, then closeSpill.
+// `count` - amount of yielded keys.
+func (d *DomainCommitted) spilledKeys(count *uint64) commitment.KeysIter {
+	return func(yield func(plainKey, hashedKey []byte) error) error {
+		if err := d.spillTouchedKeys(); err != nil {
+			return err
+		}
+		var prev []byte
+		return d.spill.Load(nil, "", func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+			if *count > 0 && bytes.Equal(prev, k) {
+				return nil
+			}
+			prev = append(prev[:0], k...)
+			*count++
+			return yield(v, k)
+		}, etl.TransformArgs{})
+	}
+}
+
+func (d *DomainCommitted) closeSpill() {
+	if d.spill != nil {
+		d.spill.Close()
+		d.spill = nil
+	}
+	d.spillErr = nil
+}
+
 // TODO(awskii): let trie define hashing function
 func (d *DomainCommitted) hashAndNibblizeKey(key []byte) []byte {
 	hashedKey := make([]byte, length.Hash)
@@ -222,7 +293,8 @@ func (d *DomainCommitted) hashAndNibblizeKey(key []byte) []byte {
 	return nibblized
 }
 
-func (d *DomainCommitted) storeCommitmentState(blockNum, txNum uint64) error {
+// StoreState - stores current trie state as state after txNum
+func (d *DomainCommitted) StoreState(blockNum, txNum uint64) error {
 	var state []byte
 	var err error
 
@@ -240,13 +312,72 @@ func (d *DomainCommitted) storeCommitmentState(blockNum, txNum uint64) error {
 	if err != nil {
 		return err
 	}
+	return d.tx.Put(d.stateTable, hexutility.EncodeTs(txNum), encoded)
+}
 
-	var stepbuf [2]byte
-	step := uint16(txNum / d.aggregationStep)
-	binary.BigEndian.PutUint16(stepbuf[:], step)
-	if err = d.Domain.Put(keyCommitmentState, stepbuf[:], encoded); err != nil {
+// LoadState - encoded trie state stored exactly at txNum. ok=false if there is no such state
+func (d *DomainCommitted) LoadState(txNum uint64) (blockNum uint64, trieState []byte, ok bool, err error) {
+	v, err := d.tx.GetOne(d.stateTable, hexutility.EncodeTs(txNum))
+	if err != nil || len(v) == 0 {
+		return 0, nil, false, err
+	}
+	var cs commitmentState
+	if err := cs.Decode(v); err != nil {
+		return 0, nil, false, fmt.Errorf("LoadState: txNum %d: %w", txNum, err)
+	}
+	return cs.blockNum, cs.trieState, true, nil
+}
+
+// ListStates - visits stored states in ascending txNum order. Doesn't see states of old releases (stored in domain)
+func (d *DomainCommitted) ListStates(f func(blockNum, txNum uint64) error) error {
+	c, err := d.tx.Cursor(d.stateTable)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		var cs commitmentState
+		if err := cs.Decode(v); err != nil {
+			return fmt.Errorf("ListStates: txNum %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		if err := f(cs.blockNum, cs.txNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneStates - deletes states stored before txTo, except latest one: SeekCommitment needs only states of last step
+// covered by files and newer. Called after domain is pruned up to step which starts at txTo.
+func (d *DomainCommitted) PruneStates(ctx context.Context, txTo uint64) error {
+	c, err := d.tx.RwCursor(d.stateTable)
+	if err != nil {
 		return err
 	}
+	defer c.Close()
+	last, _, err := c.Last()
+	if err != nil || last == nil {
+		return err
+	}
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint64(k) >= txTo || bytes.Equal(k, last) {
+			break
+		}
+		if err = c.DeleteCurrent(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
 	return nil
 }
 
@@ -267,8 +398,9 @@ func (d *DomainCommitted) replaceKeyWithReference(fullKey, shortKey []byte, type
 
 		shortKey = encodeU64(cur.Ordinal(), numBuf[:])
 
-		if d.trace {
-			fmt.Printf("replacing %s [%x] => {%x} [step=%d, offset=%d, file=%s.%d-%d]\n", typeAS, fullKey, shortKey, step, cur.Ordinal(), typeAS, item.startTxNum, item.endTxNum)
+		if d.debug() {
+			d.logger.Debug("[dbg] replacing key with reference", "type", typeAS, "key", fmt.Sprintf("%x", fullKey), "ref", fmt.Sprintf("%x", shortKey),
+				"step", step, "offset", cur.Ordinal(), "file", fmt.Sprintf("%s.%d-%d", typeAS, item.startTxNum, item.endTxNum))
 		}
 		found = true
 		break
@@ -293,8 +425,9 @@ func (d *DomainCommitted) lookupShortenedKey(shortKey, fullKey []byte, typAS str
 		cur := item.bindex.OrdinalLookup(offset)
 		//nolint
 		fullKey = cur.Key()
-		if d.trace {
-			fmt.Printf("offsetToKey %s [%x]=>{%x} step=%d offset=%d, file=%s.%d-%d.kv\n", typAS, fullKey, shortKey, fileStep, offset, typAS, item.startTxNum, item.endTxNum)
+		if d.debug() {
+			d.logger.Debug("[dbg] offsetToKey", "type", typAS, "key", fmt.Sprintf("%x", fullKey), "ref", fmt.Sprintf("%x", shortKey),
+				"step", fileStep, "offset", offset, "file", fmt.Sprintf("%s.%d-%d.kv", typAS, item.startTxNum, item.endTxNum))
 		}
 		found = true
 		break
@@ -323,7 +456,7 @@ func (d *DomainCommitted) commitmentValTransform(files *SelectedStaticFiles, mer
 		} else {
 			f := d.lookupShortenedKey(accountPlainKey, apkBuf, "account", files.accounts)
 			if !f {
-				fmt.Printf("lost key %x\n", accountPlainKeys)
+				d.logger.Warn("[commitment] lost account key", "key", fmt.Sprintf("%x", accountPlainKey))
 			}
 		}
 		d.replaceKeyWithReference(apkBuf, accountPlainKey, "account", merged.accounts)
@@ -339,7 +472,7 @@ func (d *DomainCommitted) commitmentValTransform(files *SelectedStaticFiles, mer
 			// Optimised key referencing a state file record (file number and offset within the file)
 			f := d.lookupShortenedKey(storagePlainKey, spkBuf, "storage", files.storage)
 			if !f {
-				fmt.Printf("lost skey %x\n", storagePlainKey)
+				d.logger.Warn("[commitment] lost storage key", "key", fmt.Sprintf("%x", storagePlainKey))
 			}
 		}
 
@@ -425,21 +558,20 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 		if comp, err = seg.NewCompressor(ctx, "merge", datPath, d.dir, seg.MinPatternScore, workers, log.LvlTrace, d.logger); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s compressor: %w", d.filenameBase, err)
 		}
+		comp.SetChecksums(dbg.SegChecksums)
 		var cp CursorHeap
 		heap.Init(&cp)
 		for _, item := range domainFiles {
-			g := item.decompressor.MakeGetter()
+			g := NewArchiveGetter(item.decompressor.MakeGetter(), d.compressVals).ZeroCopy()
 			g.Reset(0)
 			if g.HasNext() {
-				key, _ := g.NextUncompressed()
-				var val []byte
-				if d.compressVals {
-					val, _ = g.Next(nil)
-				} else {
-					val, _ = g.NextUncompressed()
+				key, _ := g.NextKey(nil)
+				val, _ := g.NextVal(nil)
+				if err = g.Err(); err != nil {
+					return nil, nil, nil, err
 				}
-				if d.trace {
-					fmt.Printf("merge: read value '%x'\n", key)
+				if d.debug() {
+					d.logger.Debug("[dbg] merge: read value", "key", fmt.Sprintf("%x", key))
 				}
 				heap.Push(&cp, &CursorItem{
 					t:        FILE_CURSOR,
@@ -457,19 +589,19 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 		// instead, the pair from the previous iteration is processed first - `keyBuf=>valBuf`. After that, `keyBuf` and `valBuf` are assigned
 		// to `lastKey` and `lastVal` correspondingly, and the next step of multi-way merge happens. Therefore, after the multi-way merge loop
 		// (when CursorHeap cp is empty), there is a need to process the last pair `keyBuf=>valBuf`, because it was one step behind
-		var keyBuf, valBuf []byte
+		var keyBuf, valBuf, lastKey, lastVal []byte
 		for cp.Len() > 0 {
-			lastKey := common.Copy(cp[0].key)
-			lastVal := common.Copy(cp[0].val)
+			// getters are in ZeroCopy mode: copy before advancing cursors
+			lastKey = append(lastKey[:0], cp[0].key...)
+			lastVal = append(lastVal[:0], cp[0].val...)
 			// Advance all the items that have this key (including the top)
 			for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 				ci1 := cp[0]
 				if ci1.dg.HasNext() {
-					ci1.key, _ = ci1.dg.NextUncompressed()
-					if d.compressVals {
-						ci1.val, _ = ci1.dg.Next(ci1.val[:0])
-					} else {
-						ci1.val, _ = ci1.dg.NextUncompressed()
+					ci1.key, _ = ci1.dg.NextKey(nil)
+					ci1.val, _ = ci1.dg.NextVal(nil)
+					if err = ci1.dg.Err(); err != nil {
+						return nil, nil, nil, err
 					}
 					heap.Fix(&cp, 0)
 				} else {
@@ -495,8 +627,9 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 						}
 					}
 				}
-				keyBuf = append(keyBuf[:0], lastKey...)
-				valBuf = append(valBuf[:0], lastVal...)
+				// lastKey/lastVal are own copies: swap buffers instead of copying them once more
+				keyBuf, lastKey = lastKey, keyBuf
+				valBuf, lastVal = lastVal, valBuf
 			}
 		}
 		if keyBuf != nil {
@@ -505,9 +638,11 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 			}
 			keyCount++ // Only counting keys, not values
 			//fmt.Printf("last heap key %x\n", keyBuf)
-			valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, valBuf)
-			if err != nil {
-				return nil, nil, nil, fmt.Errorf("merge: 2valTransform [%x] %w", valBuf, err)
+			if !isLegacyStateKey(keyBuf) { // state of old releases is not a branch
+				valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, valBuf)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("merge: 2valTransform [%x] %w", valBuf, err)
+				}
 			}
 			if d.compressVals {
 				if err = comp.AddWord(valBuf); err != nil {
@@ -535,7 +670,7 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 
 		p = ps.AddNew(datFileName, uint64(keyCount))
 		defer ps.Delete(p)
-		if valuesIn.index, err = buildIndexThenOpen(ctx, valuesIn.decompressor, idxPath, d.dir, keyCount, false /* values */, p, d.logger, d.noFsync); err != nil {
+		if valuesIn.index, err = buildIndexThenOpen(ctx, valuesIn.decompressor, idxPath, d.dir, keyCount, false /* values */, p, d.logger, d.noFsync, d.metricsLabel); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s buildIndex [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
 		}
 
@@ -555,6 +690,9 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 func (d *DomainCommitted) ComputeCommitment(trace bool) (rootHash []byte, branchNodeUpdates map[string]commitment.BranchData, err error) {
 	defer func(s time.Time) { d.comTook = time.Since(s) }(time.Now())
 
+	if d.spill != nil || d.spillErr != nil {
+		return d.computeSpilledCommitment(trace)
+	}
 	touchedKeys, hashedKeys, updates := d.TouchedKeyList()
 	d.comKeys = uint64(len(touchedKeys))
 
@@ -586,51 +724,64 @@ func (d *DomainCommitted) ComputeCommitment(trace bool) (rootHash []byte, branch
 	return rootHash, branchNodeUpdates, err
 }
 
+// computeSpilledCommitment - ComputeCommitment over touched keys spilled to disk: keys are streamed to trie, not
+// materialized in RAM
+func (d *DomainCommitted) computeSpilledCommitment(trace bool) (rootHash []byte, branchNodeUpdates map[string]commitment.BranchData, err error) {
+	defer d.closeSpill()
+	if d.spillErr != nil {
+		return nil, nil, fmt.Errorf("spill commitment touched keys: %w", d.spillErr)
+	}
+	if d.mode != CommitmentModeDirect {
+		return nil, nil, fmt.Errorf("spilled commitment touched keys need direct commitment mode, got %d", d.mode)
+	}
+	d.patriciaTrie.Reset()
+	d.patriciaTrie.SetTrace(trace)
+	var count uint64
+	rootHash, branchNodeUpdates, err = d.patriciaTrie.ReviewKeysIter(d.spilledKeys(&count))
+	d.comKeys = count
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootHash, branchNodeUpdates, nil
+}
+
+// keyCommitmentState - prefix of states stored in domain by old releases (key suffix is step_u16). Only read.
 var keyCommitmentState = []byte("state")
 
-// SeekCommitment searches for last encoded state from DomainCommitted
-// and if state found, sets it up to current domain
+// SeekCommitment searches for last encoded state (stored at or after step of sinceTx-1)
+// and if state found, sets it up to current domain.
 func (d *DomainCommitted) SeekCommitment(aggStep, sinceTx uint64) (blockNum, txNum uint64, err error) {
 	if d.patriciaTrie.Variant() != commitment.VariantHexPatriciaTrie {
 		return 0, 0, fmt.Errorf("state storing is only supported hex patricia trie")
 	}
 	// todo add support of bin state dumping
 
-	var (
-		latestState []byte
-		stepbuf     [2]byte
-		step               = uint16(sinceTx/aggStep) - 1
-		latestTxNum uint64 = sinceTx - 1
-	)
-
-	d.SetTxNum(latestTxNum)
-	ctx := d.MakeContext()
-	defer ctx.Close()
-
-	for {
-		binary.BigEndian.PutUint16(stepbuf[:], step)
-
-		s, err := ctx.Get(keyCommitmentState, stepbuf[:], d.tx)
-		if err != nil {
+	var fromStep uint64
+	if sinceTx >= aggStep {
+		fromStep = sinceTx/aggStep - 1
+	}
+	latestState, err := d.latestState(fromStep * aggStep)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(latestState) == 0 {
+		if latestState, err = d.latestStateInDomain(aggStep, fromStep); err != nil {
 			return 0, 0, err
 		}
-		if len(s) < 8 {
-			break
-		}
-		v := binary.BigEndian.Uint64(s)
-		if v == latestTxNum && len(latestState) != 0 {
-			break
-		}
-		latestTxNum, latestState = v, s
-		lookupTxN := latestTxNum + aggStep
-		step = uint16(latestTxNum/aggStep) + 1
-		d.SetTxNum(lookupTxN)
+	}
+	if len(latestState) == 0 {
+		return 0, 0, nil
 	}
 
 	var latest commitmentState
 	if err := latest.Decode(latestState); err != nil {
-		return 0, 0, nil
+		return 0, 0, fmt.Errorf("SeekCommitment: %w", err)
 	}
+	if latest.version != commitmentStateLatest {
+		// no explicit migration needed: next stored state will be encoded in latest version
+		d.logger.Info("[commitment] restored state of old encoding version", "version", latest.version, "latest", commitmentStateLatest, "txNum", latest.txNum)
+	}
+	d.SetTxNum(latest.txNum)
 
 	if hext, ok := d.patriciaTrie.(*commitment.HexPatriciaHashed); ok {
 		if err := hext.SetState(latest.trieState); err != nil {
@@ -643,43 +794,135 @@ func (d *DomainCommitted) SeekCommitment(aggStep, sinceTx uint64) (blockNum, txN
 	return latest.blockNum, latest.txNum, nil
 }
 
+func isLegacyStateKey(key []byte) bool {
+	return len(key) == len(keyCommitmentState)+2 && bytes.HasPrefix(key, keyCommitmentState)
+}
+
+// latestState - last state of stateTable, nil if it's before fromTxNum
+func (d *DomainCommitted) latestState(fromTxNum uint64) ([]byte, error) {
+	c, err := d.tx.Cursor(d.stateTable)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	k, v, err := c.Last()
+	if err != nil || k == nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint64(k) < fromTxNum {
+		return nil, nil
+	}
+	return common.Copy(v), nil
+}
+
+// latestStateInDomain - last state stored by old releases in domain (files and DB).
+// State is stored at end of each step, so steps which have state form continuous range: last of them is
+// found by binary search over steps [fromStep, MaxUint16] - O(log(steps)) lookups instead of visiting every step.
+func (d *DomainCommitted) latestStateInDomain(aggStep, fromStep uint64) ([]byte, error) {
+	ctx := d.MakeContext()
+	defer ctx.Close()
+
+	lo := fromStep
+	latestState, err := d.commitmentStateAt(ctx, aggStep, lo)
+	if err != nil || len(latestState) == 0 {
+		return nil, err
+	}
+	// invariant: step `lo` has state, all steps after `hi` - don't
+	for hi := uint64(math.MaxUint16); lo < hi; {
+		mid := lo + (hi-lo+1)/2
+		s, err := d.commitmentStateAt(ctx, aggStep, mid)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) == 0 {
+			hi = mid - 1
+			continue
+		}
+		lo, latestState = mid, s
+	}
+	return latestState, nil
+}
+
+// commitmentStateAt - encoded state stored during given step, nil if there is no state for this step
+func (d *DomainCommitted) commitmentStateAt(ctx *DomainContext, aggStep, step uint64) ([]byte, error) {
+	var stepbuf [2]byte
+	binary.BigEndian.PutUint16(stepbuf[:], uint16(step))
+	d.SetTxNum((step+1)*aggStep - 1)
+	return ctx.Get(keyCommitmentState, stepbuf[:], d.tx)
+}
+
+// commitmentState - stored at the end of each step, to restore trie without re-computation from genesis.
+// Layout of encoding is versioned (first byte), decoders of all past versions must be kept:
+// node must be able to restore state written by previous release. New state is always written in latest version.
 type commitmentState struct {
+	version   byte // version of decoded buffer
 	txNum     uint64
 	blockNum  uint64
-	trieState []byte
+	trieState []byte // encoded by HexPatriciaHashed.EncodeCurrentState
 }
 
+const (
+	// commitmentStateV0 - unversioned: txNum(8) | blockNum(8) | len(trieState) uint16 | trieState.
+	// Detected by first byte == 0: it's high byte of txNum which is never reached.
+	commitmentStateV0 byte = 0
+	// commitmentStateV1 - version(1) | txNum(8) | blockNum(8) | len(trieState) uint32 | trieState
+	commitmentStateV1 byte = 1
+
+	commitmentStateLatest = commitmentStateV1
+)
+
 func (cs *commitmentState) Decode(buf []byte) error {
-	if len(buf) < 10 {
+	if len(buf) == 0 {
+		return fmt.Errorf("empty commitment state buffer")
+	}
+	switch buf[0] {
+	case commitmentStateV0:
+		return cs.decodeV0(buf)
+	case commitmentStateV1:
+		return cs.decodeV1(buf)
+	default:
+		return fmt.Errorf("unsupported commitment state version %d (probably written by newer release)", buf[0])
+	}
+}
+
+func (cs *commitmentState) decodeV0(buf []byte) error {
+	if len(buf) < 18 {
 		return fmt.Errorf("ivalid commitment state buffer size")
 	}
-	pos := 0
-	cs.txNum = binary.BigEndian.Uint64(buf[pos : pos+8])
-	pos += 8
-	cs.blockNum = binary.BigEndian.Uint64(buf[pos : pos+8])
-	pos += 8
-	cs.trieState = make([]byte, binary.BigEndian.Uint16(buf[pos:pos+2]))
-	pos += 2
-	if len(cs.trieState) == 0 && len(buf) == 10 {
-		return nil
-	}
-	copy(cs.trieState, buf[pos:pos+len(cs.trieState)])
+	cs.version = commitmentStateV0
+	cs.txNum = binary.BigEndian.Uint64(buf[0:8])
+	cs.blockNum = binary.BigEndian.Uint64(buf[8:16])
+	size := int(binary.BigEndian.Uint16(buf[16:18]))
+	if len(buf) < 18+size {
+		return fmt.Errorf("ivalid commitment state buffer size: %d, trie state size %d", len(buf), size)
+	}
+	cs.trieState = common.Copy(buf[18 : 18+size])
 	return nil
 }
 
-func (cs *commitmentState) Encode() ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	var v [18]byte
-	binary.BigEndian.PutUint64(v[:], cs.txNum)
-	binary.BigEndian.PutUint64(v[8:16], cs.blockNum)
-	binary.BigEndian.PutUint16(v[16:18], uint16(len(cs.trieState)))
-	if _, err := buf.Write(v[:]); err != nil {
-		return nil, err
+func (cs *commitmentState) decodeV1(buf []byte) error {
+	if len(buf) < 21 {
+		return fmt.Errorf("ivalid commitment state buffer size")
 	}
-	if _, err := buf.Write(cs.trieState); err != nil {
-		return nil, err
+	cs.version = commitmentStateV1
+	cs.txNum = binary.BigEndian.Uint64(buf[1:9])
+	cs.blockNum = binary.BigEndian.Uint64(buf[9:17])
+	size := int(binary.BigEndian.Uint32(buf[17:21]))
+	if len(buf) < 21+size {
+		return fmt.Errorf("ivalid commitment state buffer size: %d, trie state size %d", len(buf), size)
 	}
-	return buf.Bytes(), nil
+	cs.trieState = common.Copy(buf[21 : 21+size])
+	return nil
+}
+
+// Encode - always in latest version
+func (cs *commitmentState) Encode() ([]byte, error) {
+	buf := make([]byte, 21, 21+len(cs.trieState))
+	buf[0] = commitmentStateLatest
+	binary.BigEndian.PutUint64(buf[1:9], cs.txNum)
+	binary.BigEndian.PutUint64(buf[9:17], cs.blockNum)
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(cs.trieState)))
+	return append(buf, cs.trieState...), nil
 }
 
 func decodeU64(from []byte) uint64 {
@@ -0,0 +1,71 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// debugDomains - domains (by filenameBase: accounts, storage, commitment, ...) with enabled debug output.
+// Copy-on-write: read on hot paths without lock.
+var (
+	debugDomains     atomic.Pointer[map[string]struct{}]
+	debugDomainsLock sync.Mutex
+)
+
+// SetDomainDebug - enables/disables debug output of domain at runtime
+func SetDomainDebug(domain string, enabled bool) {
+	debugDomainsLock.Lock()
+	defer debugDomainsLock.Unlock()
+	m := map[string]struct{}{}
+	if old := debugDomains.Load(); old != nil {
+		for k := range *old {
+			m[k] = struct{}{}
+		}
+	}
+	if enabled {
+		m[domain] = struct{}{}
+	} else {
+		delete(m, domain)
+	}
+	debugDomains.Store(&m)
+}
+
+// DebugDomains - domains with enabled debug output
+func DebugDomains() []string {
+	m := debugDomains.Load()
+	if m == nil {
+		return []string{}
+	}
+	res := make([]string, 0, len(*m))
+	for k := range *m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func domainDebug(domain string) bool {
+	m := debugDomains.Load()
+	if m == nil {
+		return false
+	}
+	_, ok := (*m)[domain]
+	return ok
+}
@@ -4,6 +4,10 @@ package state
 type KvList struct {
 	Keys []string
 	Vals [][]byte
+
+	// Prefixes - prefix-range reads: reader did see all keys with this prefix, and they are in Keys/Vals too.
+	// Read is valid while no key with this prefix is added or changed.
+	Prefixes []string
 }
 
 func (l *KvList) Push(key string, val []byte) {
@@ -11,6 +15,22 @@ func (l *KvList) Push(key string, val []byte) {
 	l.Vals = append(l.Vals, val)
 }
 
+// PushPrefix - record prefix-range read. Keys seen by it must be pushed by Push
+func (l *KvList) PushPrefix(prefix string) {
+	l.Prefixes = append(l.Prefixes, prefix)
+}
+
+// Reset - keeps capacity, drops references to keys/values
+func (l *KvList) Reset() {
+	for i := range l.Vals {
+		l.Vals[i] = nil
+	}
+	for i := range l.Keys {
+		l.Keys[i] = ""
+	}
+	l.Keys, l.Vals, l.Prefixes = l.Keys[:0], l.Vals[:0], l.Prefixes[:0]
+}
+
 func (l *KvList) Len() int {
 	return len(l.Keys)
 }
@@ -19,13 +19,17 @@ package state
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/require"
@@ -45,6 +49,7 @@ func testDbAndDomain(t *testing.T, logger log.Logger) (string, kv.RwDB, *Domain)
 	historyValsTable := "HistoryVals"
 	settingsTable := "Settings" //nolint
 	indexTable := "Index"
+	stateTable := "State"
 	db := mdbx.NewMDBX(logger).InMem(path).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
 		return kv.TableCfg{
 			keysTable:        kv.TableCfgItem{Flags: kv.DupSort},
@@ -53,6 +58,7 @@ func testDbAndDomain(t *testing.T, logger log.Logger) (string, kv.RwDB, *Domain)
 			historyValsTable: kv.TableCfgItem{Flags: kv.DupSort},
 			settingsTable:    kv.TableCfgItem{},
 			indexTable:       kv.TableCfgItem{Flags: kv.DupSort},
+			stateTable:       kv.TableCfgItem{},
 		}
 	}).MustOpen()
 	t.Cleanup(db.Close)
@@ -509,6 +515,166 @@ func TestDomain_MergeFiles(t *testing.T) {
 	checkHistory(t, db, d, txs)
 }
 
+func TestDomain_StepMeta(t *testing.T) {
+	logger := log.New()
+	_, db, d, txs := filledDomain(t, logger)
+	d.stepMeta = true
+	collateAndMerge(t, db, nil, d, txs)
+	checkHistory(t, db, d, txs)
+
+	roTx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	dc := d.MakeContext()
+	defer dc.Close()
+	require.True(t, hasStepMeta(dc.files[0].src))
+	for keyNum := uint64(1); keyNum <= 31; keyNum++ {
+		var k [8]byte
+		binary.BigEndian.PutUint64(k[:], keyNum)
+		v, step, err := dc.GetWithStep(k[:], nil, roTx)
+		require.NoError(t, err)
+		expect, err := dc.Get(k[:], nil, roTx)
+		require.NoError(t, err)
+		require.Equal(t, expect, v)
+		lastWriteTxNum := txs / keyNum * keyNum
+		require.Equal(t, lastWriteTxNum/d.aggregationStep, step, "keyNum=%d", keyNum)
+	}
+
+	// prune is exact: values which files have with their step are not kept in DB, only not collated steps are
+	collated := dc.files[len(dc.files)-1].endTxNum / d.aggregationStep
+	for _, table := range []string{d.keysTable, d.valsTable} {
+		require.NoError(t, roTx.ForEach(table, nil, func(k, v []byte) error {
+			invertedStep := v
+			if table == d.valsTable {
+				invertedStep = k[len(k)-8:]
+			}
+			require.GreaterOrEqual(t, ^binary.BigEndian.Uint64(invertedStep), collated, "%s %x", table, k)
+			return nil
+		}))
+	}
+}
+
+func TestDomain_StepMetaChange(t *testing.T) {
+	logger := log.New()
+	for _, stepMeta := range []bool{false, true} {
+		_, db, d, txs := filledDomain(t, logger)
+		d.stepMeta = stepMeta
+		collateAndMerge(t, db, nil, d, txs)
+		dc := d.MakeContext()
+		require.Equal(t, stepMeta, hasStepMeta(dc.files[0].src))
+		dc.Close()
+
+		// format is recorded in files: they are read in it after setting is changed
+		d.stepMeta = !stepMeta
+		txNum := d.txNum
+		d.closeWhatNotInList([]string{})
+		require.NoError(t, d.OpenFolder())
+		d.SetTxNum(txNum)
+		checkHistory(t, db, d, txs)
+	}
+}
+
+func TestDomain_CodeByHash(t *testing.T) {
+	for _, stepMeta := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stepMeta=%t", stepMeta), func(t *testing.T) {
+			logger := log.New()
+			_, db, d, txs := filledDomain(t, logger)
+			d.codeByHash, d.stepMeta = true, stepMeta
+			collateAndMerge(t, db, nil, d, txs)
+			checkHistory(t, db, d, txs)
+
+			dc := d.MakeContext()
+			require.True(t, hasCodeByHash(dc.files[0].src))
+			// many keys have same value: each value is stored once
+			var keys, codes int
+			uniq := map[string]struct{}{}
+			g := dc.files[0].src.decompressor.MakeGetter()
+			for g.HasNext() {
+				k, _ := g.NextUncompressed()
+				v, _ := g.Next(nil)
+				if isCodeHashKey(k) {
+					codes++
+					v, _, err := d.fileValue(dc.files[0].src, v)
+					require.NoError(t, err)
+					uniq[string(v)] = struct{}{}
+					continue
+				}
+				keys++
+			}
+			require.Equal(t, 31, keys)
+			require.Equal(t, len(uniq), codes)
+			require.Less(t, codes, keys)
+
+			tx, err := db.BeginRw(context.Background())
+			require.NoError(t, err)
+			defer tx.Rollback()
+			d.SetTx(tx)
+			var iterated int
+			require.NoError(t, dc.IteratePrefix(nil, func(k, v []byte) {
+				require.False(t, isCodeHashKey(k))
+				iterated++
+			}))
+			require.Equal(t, 31, iterated)
+			tx.Rollback()
+			dc.Close()
+
+			// setting is off after re-open: files tell their layout
+			txNum := d.txNum
+			d.closeWhatNotInList([]string{})
+			d.codeByHash = false
+			require.NoError(t, d.OpenFolder())
+			d.SetTxNum(txNum)
+			checkHistory(t, db, d, txs)
+		})
+	}
+}
+
+func TestDomain_ValueLog(t *testing.T) {
+	for _, codeByHash := range []bool{false, true} {
+		t.Run(fmt.Sprintf("codeByHash=%t", codeByHash), func(t *testing.T) {
+			logger := log.New()
+			_, db, d, txs := filledDomain(t, logger)
+			d.codeByHash, d.stepMeta, d.valueLogThreshold = codeByHash, true, 8 // all values of test are 8 bytes
+			collateAndMerge(t, db, nil, d, txs)
+			checkHistory(t, db, d, txs)
+
+			dc := d.MakeContext()
+			for _, item := range dc.files {
+				require.NotNil(t, item.src.vlog)
+				require.Positive(t, item.src.vlog.Size())
+			}
+			dc.Close()
+
+			// .vlog files are found on re-open. Files tell that values are tagged, not current threshold
+			txNum := d.txNum
+			d.closeWhatNotInList([]string{})
+			d.valueLogThreshold = 0
+			require.NoError(t, d.OpenFolder())
+			d.SetTxNum(txNum)
+			checkHistory(t, db, d, txs)
+
+			dc = d.MakeContext()
+			defer dc.Close()
+			tx, err := db.BeginRw(context.Background())
+			require.NoError(t, err)
+			defer tx.Rollback()
+			d.SetTx(tx)
+			var iterated int
+			require.NoError(t, dc.IteratePrefix(nil, func(k, v []byte) {
+				require.Len(t, v, 8)
+				iterated++
+			}))
+			require.Equal(t, 31, iterated)
+
+			// .kv with tagged values is not opened without its .vlog
+			vlogPath := dc.files[0].src.vlog.FilePath()
+			d.closeWhatNotInList([]string{})
+			require.NoError(t, os.Remove(vlogPath))
+			require.ErrorContains(t, d.OpenFolder(), filepath.Base(vlogPath))
+		})
+	}
+}
+
 func TestDomain_ScanFiles(t *testing.T) {
 	logger := log.New()
 	path, db, d, txs := filledDomain(t, logger)
@@ -570,6 +736,109 @@ func TestDomain_Delete(t *testing.T) {
 	}
 }
 
+func TestDomain_DeletePrefix(t *testing.T) {
+	logger := log.New()
+	_, db, d := testDbAndDomain(t, logger)
+	d.tombstonePrefixLen = 2
+	ctx, require := context.Background(), require.New(t)
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	d.SetTx(tx)
+	d.StartWrites()
+	defer d.FinishWrites()
+
+	// step 0: 10 keys under each of prefixes "aa" and "bb"
+	for i := byte(0); i < 10; i++ {
+		d.SetTxNum(uint64(i))
+		require.NoError(d.Put([]byte("aa"), []byte{i}, []byte{i + 1}))
+		require.NoError(d.Put([]byte("bb"), []byte{i}, []byte{i + 1}))
+		if i < 3 {
+			require.NoError(d.Put([]byte("cc"), []byte{i}, []byte{i + 1}))
+		}
+	}
+	require.Error(d.DeletePrefix([]byte("a")))
+	// step 1: tombstone of "aa", then key 0 is written again with same value. Step 2: key 1 is written again
+	d.SetTxNum(20)
+	require.NoError(d.DeletePrefix([]byte("aa")))
+	d.SetTxNum(21)
+	require.NoError(d.Put([]byte("aa"), []byte{0}, []byte{1}))
+	d.SetTxNum(25) // "cc" keys are not written after tombstone
+	require.NoError(d.DeletePrefix([]byte("cc")))
+	d.SetTxNum(33)
+	require.NoError(d.Put([]byte("aa"), []byte{1}, []byte{7}))
+
+	checkLatest := func() {
+		dc := d.MakeContext()
+		defer dc.Close()
+		expect := map[byte][]byte{0: {1}, 1: {7}}
+		for i := byte(0); i < 10; i++ {
+			v, err := dc.Get([]byte("aa"), []byte{i}, tx)
+			require.NoError(err)
+			require.Equal(expect[i], v, i)
+			v, err = dc.Get([]byte("bb"), []byte{i}, tx)
+			require.NoError(err)
+			require.Equal([]byte{i + 1}, v, i)
+			v, err = dc.Get([]byte("cc"), []byte{i}, tx)
+			require.NoError(err)
+			require.Nil(v, i)
+		}
+		var iterated int
+		require.NoError(dc.IteratePrefix([]byte("aa"), func(k, v []byte) {
+			require.Equal(expect[k[2]], v)
+			iterated++
+		}))
+		require.Equal(2, iterated)
+	}
+	checkHistory := func() {
+		dc := d.MakeContext()
+		defer dc.Close()
+		for _, c := range []struct {
+			key   byte
+			txNum uint64
+			val   []byte
+		}{
+			{2, 20, []byte{3}}, {2, 21, nil}, {2, 34, nil},
+			{0, 21, nil}, {0, 22, []byte{1}},
+			{1, 20, []byte{2}}, {1, 33, nil}, {1, 34, []byte{7}},
+		} {
+			v, err := dc.GetBeforeTxNum([]byte{'a', 'a', c.key}, c.txNum, tx)
+			require.NoError(err)
+			require.Equal(c.val, v, "key=%d txNum=%d", c.key, c.txNum)
+		}
+		v, err := dc.GetBeforeTxNum([]byte("cc\x01"), 25, tx)
+		require.NoError(err)
+		require.Equal([]byte{2}, v)
+		v, err = dc.GetBeforeTxNum([]byte("cc\x01"), 26, tx)
+		require.NoError(err)
+		require.Nil(v)
+	}
+
+	checkLatest() // writes after tombstone are not in DB history yet
+	require.NoError(d.Rotate().Flush(ctx, tx))
+	checkLatest()
+	checkHistory()
+
+	// tombstone becomes deletions of keys it covers, except of written after it
+	deleted, err := d.resolveTombstones(ctx, 16, 32)
+	require.NoError(err)
+	require.Equal(8+3, deleted)
+	require.NoError(d.Rotate().Flush(ctx, tx))
+	require.Equal(uint64(33), d.txNum)
+	checkLatest()
+	checkHistory()
+
+	// resolved tombstone is deleted: reads don't pay for it anymore. Keys written after tombstone of "aa" keep it
+	dc := d.MakeContext()
+	defer dc.Close()
+	_, ok, err := dc.tombstone([]byte("cc"), d.txNum, tx)
+	require.NoError(err)
+	require.False(ok)
+	_, ok, err = dc.tombstone([]byte("aa"), d.txNum, tx)
+	require.NoError(err)
+	require.True(ok)
+}
+
 func filledDomainFixedSize(t *testing.T, keysCount, txCount uint64, logger log.Logger) (string, kv.RwDB, *Domain, map[string][]bool) {
 	t.Helper()
 	path, db, d := testDbAndDomain(t, logger)
@@ -789,3 +1058,159 @@ func TestScanStaticFilesD(t *testing.T) {
 	})
 	require.Equal(t, 6, len(found))
 }
+
+func TestCommitmentTouchedKeysSpill(t *testing.T) {
+	logger := log.New()
+	_, _, d := testDbAndDomain(t, logger)
+	touch := func(dc *DomainCommitted) {
+		for i := 0; i < 100; i++ {
+			dc.TouchPlainKey([]byte(fmt.Sprintf("%020d", i%70)), nil, nil)
+		}
+	}
+	newCommitted := func(spillLimit int) *DomainCommitted {
+		dc := NewCommittedDomain(d, "State", CommitmentModeDirect, commitment.VariantHexPatriciaTrie, logger)
+		dc.SetSpillLimit(spillLimit)
+		dc.patriciaTrie.ResetFns(
+			func(prefix []byte) ([]byte, error) { return nil, nil },
+			func(plainKey []byte, cell *commitment.Cell) error {
+				cell.Nonce = uint64(plainKey[len(plainKey)-1])
+				return nil
+			},
+			func(plainKey []byte, cell *commitment.Cell) error { return nil },
+		)
+		return dc
+	}
+
+	inRAM := newCommitted(0)
+	touch(inRAM)
+	plainKeys, hashedKeys, _ := inRAM.TouchedKeyList()
+	require.Len(t, plainKeys, 70)
+
+	spilled := newCommitted(16)
+	touch(spilled)
+	require.NotNil(t, spilled.spill)
+	require.Less(t, spilled.commTree.Len(), 16)
+	var count uint64
+	var plainKeys2, hashedKeys2 [][]byte
+	err := spilled.spilledKeys(&count)(func(plainKey, hashedKey []byte) error {
+		plainKeys2 = append(plainKeys2, common.Copy(plainKey))
+		hashedKeys2 = append(hashedKeys2, common.Copy(hashedKey))
+		return nil
+	})
+	require.NoError(t, err)
+	spilled.closeSpill()
+	require.Equal(t, uint64(70), count)
+	require.Equal(t, plainKeys, plainKeys2)
+	require.Equal(t, hashedKeys, hashedKeys2)
+	require.Nil(t, spilled.spill)
+	require.Zero(t, spilled.commTree.Len())
+
+	// same root, keys are streamed from disk
+	inRAM, spilled = newCommitted(0), newCommitted(16)
+	touch(inRAM)
+	touch(spilled)
+	expectRoot, _, err := inRAM.ComputeCommitment(false)
+	require.NoError(t, err)
+	root, _, err := spilled.ComputeCommitment(false)
+	require.NoError(t, err)
+	require.Equal(t, expectRoot, root)
+	require.Equal(t, uint64(70), spilled.comKeys)
+	require.Nil(t, spilled.spill)
+
+	// spill error is returned by ComputeCommitment, not panic
+	spilled = newCommitted(16)
+	spilled.spillErr = errors.New("no space left on device")
+	touch(spilled)
+	_, _, err = spilled.ComputeCommitment(false)
+	require.ErrorContains(t, err, "no space left on device")
+	require.NoError(t, spilled.spillErr)
+}
+
+func TestCommitmentSeekLatestState(t *testing.T) {
+	logger := log.New()
+	_, db, d := testDbAndDomain(t, logger)
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	d.SetTx(tx)
+	d.StartWrites()
+	defer d.FinishWrites()
+
+	dc := NewCommittedDomain(d, "State", CommitmentModeDirect, commitment.VariantHexPatriciaTrie, logger)
+	_, txNum, err := dc.SeekCommitment(d.aggregationStep, 0)
+	require.NoError(t, err)
+	require.Zero(t, txNum)
+
+	const steps = 37
+	// states stored in domain by old releases
+	trieState, err := dc.patriciaTrie.(*commitment.HexPatriciaHashed).EncodeCurrentState(nil)
+	require.NoError(t, err)
+	for step := uint64(0); step < steps; step++ {
+		txNum := step*d.aggregationStep + d.aggregationStep/2
+		encoded, err := (&commitmentState{txNum: txNum, blockNum: step * 10, trieState: trieState}).Encode()
+		require.NoError(t, err)
+		var stepbuf [2]byte
+		binary.BigEndian.PutUint16(stepbuf[:], uint16(step))
+		d.SetTxNum(txNum)
+		require.NoError(t, d.Put(keyCommitmentState, stepbuf[:], encoded))
+	}
+	expectTxNum := (steps-1)*d.aggregationStep + d.aggregationStep/2
+	for _, sinceTx := range []uint64{0, d.aggregationStep, 20 * d.aggregationStep, steps * d.aggregationStep} {
+		blockNum, txNum, err := dc.SeekCommitment(d.aggregationStep, sinceTx)
+		require.NoError(t, err)
+		require.Equal(t, expectTxNum, txNum, sinceTx)
+		require.Equal(t, uint64((steps-1)*10), blockNum, sinceTx)
+	}
+	// no state at or after sinceTx
+	_, txNum, err = dc.SeekCommitment(d.aggregationStep, (steps+2)*d.aggregationStep)
+	require.NoError(t, err)
+	require.Zero(t, txNum)
+
+	// new states are stored in dedicated table and have priority
+	for step := uint64(steps); step < steps+3; step++ {
+		txNum := step*d.aggregationStep + d.aggregationStep/2
+		require.NoError(t, dc.StoreState(step*10, txNum))
+	}
+	blockNum, txNum, err := dc.SeekCommitment(d.aggregationStep, 0)
+	require.NoError(t, err)
+	require.Equal(t, (steps+2)*d.aggregationStep+d.aggregationStep/2, txNum)
+	require.Equal(t, uint64((steps+2)*10), blockNum)
+
+	_, _, ok, err := dc.LoadState(txNum)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, _, ok, err = dc.LoadState(txNum + 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	listStates := func() (blockNums []uint64) {
+		require.NoError(t, dc.ListStates(func(blockNum, txNum uint64) error {
+			blockNums = append(blockNums, blockNum)
+			return nil
+		}))
+		return blockNums
+	}
+	require.Equal(t, []uint64{steps * 10, (steps + 1) * 10, (steps + 2) * 10}, listStates())
+
+	// states before pruned step are deleted, SeekCommitment after prune still finds latest state
+	require.NoError(t, dc.PruneStates(context.Background(), (steps+1)*d.aggregationStep))
+	require.Equal(t, []uint64{(steps + 1) * 10, (steps + 2) * 10}, listStates())
+	_, txNum, err = dc.SeekCommitment(d.aggregationStep, (steps+2)*d.aggregationStep)
+	require.NoError(t, err)
+	require.Equal(t, (steps+2)*d.aggregationStep+d.aggregationStep/2, txNum)
+	// latest state is never pruned
+	require.NoError(t, dc.PruneStates(context.Background(), (steps+10)*d.aggregationStep))
+	require.Equal(t, []uint64{(steps + 2) * 10}, listStates())
+}
+
+func TestSetDomainDebug(t *testing.T) {
+	require.False(t, domainDebug("accounts"))
+	SetDomainDebug("storage", true)
+	SetDomainDebug("accounts", true)
+	require.True(t, domainDebug("accounts"))
+	require.Equal(t, []string{"accounts", "storage"}, DebugDomains())
+	SetDomainDebug("accounts", false)
+	SetDomainDebug("storage", false)
+	require.False(t, domainDebug("accounts"))
+	require.Empty(t, DebugDomains())
+}
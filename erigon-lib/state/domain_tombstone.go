@@ -0,0 +1,273 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+// Prefix tombstones (Domain.tombstonePrefixLen).
+//
+// Deletion of all keys with given prefix (storage of SELFDESTRUCT-ed contract) key by key is O(keys) - and contracts
+// have millions of slots. DeletePrefix instead writes one key of domain: tombstone - prefix -> txNum_u64 of deletion.
+// Tombstone is usual key: it has history, goes to files, is merged and pruned as any other.
+// Value of key with prefix is deleted if tombstone of prefix exists and key was not written since txNum of tombstone.
+// Writes since tombstone are seen by step of value (older step - deleted) or by history index of key.
+// Keys written in same txNum as tombstone are after it: SELFDESTRUCT-ed contract doesn't write storage in its tx.
+// History stores values as they are: reads of history apply tombstone of moment of read.
+// Before collation of step, tombstones of step are replaced by deletions of keys they cover (resolveTombstones), and
+// tombstones themselves are deleted: files get usual deletions, and merges drop covered values as any deleted ones.
+// Tombstone stays only if some key it covers was written after it: history of such key needs it.
+// Enabled only by dbg.LazySelfDestruct: every read/write of domain checks tombstone of key's prefix.
+
+// DeletePrefix - deletes all keys which start with `prefix` in O(1) by tombstone.
+// Length of prefix must be tombstonePrefixLen: keys of domain must be longer.
+func (d *Domain) DeletePrefix(prefix []byte) error {
+	if d.tombstonePrefixLen == 0 || len(prefix) != d.tombstonePrefixLen {
+		return fmt.Errorf("%s: DeletePrefix %x: prefix tombstones of length %d", d.filenameBase, prefix, d.tombstonePrefixLen)
+	}
+	return d.Put(prefix, nil, hexutility.EncodeTs(d.txNum))
+}
+
+// tombstone - txNum of tombstone of `prefix` in state at `fromTxNum`
+func (dc *DomainContext) tombstone(prefix []byte, fromTxNum uint64, roTx kv.Tx) (uint64, bool, error) {
+	v, _, err := dc.get(prefix, fromTxNum, roTx)
+	if err != nil || len(v) != 8 {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+// deletedByTombstone - value of `key` written in `step` is deleted by tombstone of its prefix in state at `fromTxNum`
+func (dc *DomainContext) deletedByTombstone(key []byte, step, fromTxNum uint64, roTx kv.Tx) (bool, error) {
+	n := dc.d.tombstonePrefixLen
+	if n == 0 || len(key) <= n {
+		return false, nil
+	}
+	dc.tombstoneKey = append(dc.tombstoneKey[:0], key...)
+	key = dc.tombstoneKey
+	tombstoneTxNum, ok, err := dc.tombstone(key[:n], fromTxNum, roTx)
+	if err != nil || !ok {
+		return false, err
+	}
+	if step < tombstoneTxNum/dc.d.aggregationStep {
+		return true, nil
+	}
+	written, err := dc.writtenSince(key, tombstoneTxNum, -1, roTx)
+	return !written, err
+}
+
+// deletedByTombstoneBefore - same as deletedByTombstone, but for state before `txNum`
+func (dc *DomainContext) deletedByTombstoneBefore(key []byte, txNum uint64, roTx kv.Tx) (bool, error) {
+	n := dc.d.tombstonePrefixLen
+	if n == 0 || len(key) <= n {
+		return false, nil
+	}
+	dc.tombstoneKey = append(dc.tombstoneKey[:0], key...)
+	key = dc.tombstoneKey
+	v, err := dc.getBeforeTxNum(key[:n], txNum, roTx)
+	if err != nil || len(v) != 8 {
+		return false, err
+	}
+	written, err := dc.writtenSince(key, binary.BigEndian.Uint64(v), int(txNum), roTx)
+	return !written, err
+}
+
+// writtenSince - `key` was written in [fromTxNum, toTxNum), -1 - till latest state
+func (dc *DomainContext) writtenSince(key []byte, fromTxNum uint64, toTxNum int, roTx kv.Tx) (bool, error) {
+	if roTx == nil {
+		return false, fmt.Errorf("%s: roTx is nil", dc.d.filenameBase)
+	}
+	owner := dc.d
+	if owner.tablesOwner != nil {
+		owner = owner.tablesOwner
+	}
+	if toTxNum < 0 && roTx == kv.Tx(owner.tx) { // writer: history of recent writes may be not flushed yet
+		if txNum, ok := owner.tombstoneWrites[string(key)]; ok && txNum >= fromTxNum {
+			return true, nil
+		}
+	}
+	frozen, err := dc.hc.ic.iterateRangeFrozen(key, int(fromTxNum), toTxNum, order.Asc, 1)
+	if err != nil {
+		return false, err
+	}
+	defer frozen.Close()
+	if frozen.HasNext() {
+		_, err = frozen.Next()
+		return err == nil, err
+	}
+	return dc.writtenSinceInDB(key, fromTxNum, toTxNum, roTx)
+}
+
+// writtenSinceInDB - same as writtenSince, but only history in DB. Cursor instead of HistoryContext.IdxRange:
+// iterators of DB live until end of tx, and this check is done per key
+func (dc *DomainContext) writtenSinceInDB(key []byte, fromTxNum uint64, toTxNum int, roTx kv.Tx) (bool, error) {
+	h := dc.hc.h
+	var txNum uint64
+	if h.largeValues { // key + txNum -> value
+		c, err := roTx.Cursor(h.historyValsTable)
+		if err != nil {
+			return false, err
+		}
+		defer c.Close()
+		seek := binary.BigEndian.AppendUint64(common.Copy(key), fromTxNum)
+		k, _, err := c.Seek(seek)
+		if err != nil || len(k) != len(seek) || !bytes.HasPrefix(k, key) {
+			return false, err
+		}
+		txNum = binary.BigEndian.Uint64(k[len(key):])
+	} else { // key -> txNum + value
+		c, err := roTx.CursorDupSort(h.historyValsTable)
+		if err != nil {
+			return false, err
+		}
+		defer c.Close()
+		v, err := c.SeekBothRange(key, hexutility.EncodeTs(fromTxNum))
+		if err != nil || len(v) < 8 {
+			return false, err
+		}
+		txNum = binary.BigEndian.Uint64(v)
+	}
+	return toTxNum < 0 || txNum < uint64(toTxNum), nil
+}
+
+// noteTombstoneWrite - remembers write of `key` if its prefix has tombstone: until history is flushed (see Rotate),
+// step of value is the only trace of write, and it doesn't tell if value was written before tombstone or after
+func (d *Domain) noteTombstoneWrite(key []byte) error {
+	n := d.tombstonePrefixLen
+	if n == 0 || len(key) <= n {
+		return nil
+	}
+	_, ok, err := d.defaultDc.tombstone(key[:n], d.txNum, d.tx)
+	if err != nil || !ok {
+		return err
+	}
+	if d.tombstoneWrites == nil {
+		d.tombstoneWrites = map[string]uint64{}
+	}
+	d.tombstoneWrites[string(key)] = d.txNum
+	return nil
+}
+
+// Rotate - same as History.Rotate: noted writes are in history buffers to flush
+func (d *Domain) Rotate() historyFlusher {
+	d.tombstoneWrites = nil
+	return d.History.Rotate()
+}
+
+// resolveTombstones - replaces tombstones written in [txFrom, txTo) by deletions of keys they cover, at txNum of
+// tombstone, and deletes tombstones. History of the range must be flushed, deletions are written to history buffers:
+// caller flushes them before collation. Returns amount of deleted keys.
+func (d *Domain) resolveTombstones(ctx context.Context, txFrom, txTo uint64) (deleted int, err error) {
+	n := d.tombstonePrefixLen
+	if n == 0 {
+		return 0, nil
+	}
+	type tombstone struct {
+		prefix    []byte
+		txNum     uint64
+		rewritten bool // some covered key was written after tombstone
+	}
+	var tombstones []tombstone
+	keysCursor, err := d.tx.CursorDupSort(d.indexKeysTable)
+	if err != nil {
+		return 0, err
+	}
+	defer keysCursor.Close()
+	var k, v []byte
+	for k, v, err = keysCursor.Seek(hexutility.EncodeTs(txFrom)); err == nil && k != nil; k, v, err = keysCursor.Next() {
+		txNum := binary.BigEndian.Uint64(k)
+		if txNum >= txTo {
+			break
+		}
+		if len(v) == n && (d.keep == nil || d.keep(v)) {
+			tombstones = append(tombstones, tombstone{prefix: common.Copy(v), txNum: txNum})
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: tombstones of [%d, %d): %w", d.filenameBase, txFrom, txTo, err)
+	}
+	if len(tombstones) == 0 {
+		return 0, nil
+	}
+
+	savedTxNum := d.txNum
+	defer d.SetTxNum(savedTxNum)
+	for i := range tombstones {
+		ts := &tombstones[i]
+		covered := etl.NewCollector(d.filenameBase+" tombstone", d.tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize/8), d.logger)
+		covered.LogLvl(log.LvlTrace)
+		var collectErr error
+		if err := d.defaultDc.iteratePrefix(ts.prefix, true, func(k, v []byte) {
+			if collectErr == nil {
+				collectErr = covered.Collect(k, v)
+			}
+		}); err != nil {
+			covered.Close()
+			return deleted, err
+		}
+		if collectErr != nil {
+			covered.Close()
+			return deleted, collectErr
+		}
+		d.SetTxNum(ts.txNum)
+		err = covered.Load(nil, "", func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+			written, err := d.defaultDc.writtenSince(k, ts.txNum, -1, d.tx)
+			if err != nil {
+				return err
+			}
+			if written {
+				ts.rewritten = true
+				return nil
+			}
+			deleted++
+			return d.delete(k, nil, k, v)
+		}, etl.TransformArgs{Quit: ctx.Done()})
+		covered.Close()
+		if err != nil {
+			return deleted, fmt.Errorf("%s: resolve tombstone %x: %w", d.filenameBase, ts.prefix, err)
+		}
+	}
+
+	// step has one value per key: last tombstone of prefix in step replaced previous ones, and only it is deleted.
+	// Keys written after tombstone keep it: their history stores values as they are, and reads of history need it
+	last := make(map[string]tombstone, len(tombstones))
+	for _, ts := range tombstones {
+		last[string(ts.prefix)] = ts
+	}
+	for prefix, ts := range last {
+		if ts.rewritten {
+			continue
+		}
+		d.SetTxNum(ts.txNum)
+		if err := d.delete([]byte(prefix), nil, []byte(prefix), hexutility.EncodeTs(ts.txNum)); err != nil {
+			return deleted, fmt.Errorf("%s: delete tombstone %x: %w", d.filenameBase, prefix, err)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,272 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Era archive of state history: e2store container (same framing as era/era1 files of other clients):
+//
+//	Version | StateFileName | StateFileData... | StateFileName | StateFileData... | ...
+//
+// entry = type(2 bytes) | length(4 bytes, little-endian) | reserved(2 bytes, zero) | data
+//
+// Deviation from spec: it's e2store, but not era/era1 file. Only Version entry is of spec'd type.
+// era1 defines records of blocks (CompressedHeader 0x03, CompressedBody 0x04, CompressedReceipts 0x05,
+// TotalDifficulty 0x06, Accumulator 0x07, BlockIndex 0x3266) and era - of beacon blocks and states:
+// none of them can hold state history, so files are stored in Erigon-specific record types 0xE301/0xE302,
+// which are not assigned by e2store spec. There is no Accumulator/BlockIndex: archive is read sequentially.
+// Any e2store reader can walk the archive (unknown types are skipped by spec), era1 readers reject it.
+// On import, entries of other types - including spec'd era1 records - are skipped.
+// Only data files (.v, .ef) are archived, indices are re-built on import.
+const (
+	eraTypeVersion       uint16 = 0x3265 // "e2", e2store spec
+	eraTypeStateFileName uint16 = 0xE301 // Erigon-specific: not assigned by e2store spec
+	eraTypeStateFileData uint16 = 0xE302 // Erigon-specific: file content split to chunks, e2store entry length is uint32
+
+	eraHeaderSize     = 8
+	eraDataChunkLimit = 1 << 30
+)
+
+// ExportEra - write frozen history and inverted index files of steps [fromStep, toStep) to `w`.
+// Returns names of archived files.
+func (a *AggregatorV3) ExportEra(ctx context.Context, w io.Writer, fromStep, toStep uint64) ([]string, error) {
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	fromTxNum, toTxNum := fromStep*a.aggregationStep, toStep*a.aggregationStep
+	var paths []string
+	add := func(files []ctxItem) {
+		for _, item := range files {
+			if item.startTxNum >= fromTxNum && item.endTxNum <= toTxNum {
+				paths = append(paths, item.src.decompressor.FilePath())
+			}
+		}
+	}
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		add(hc.files)
+		add(hc.ic.files)
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		add(ic.files)
+	}
+	if err := writeEra(ctx, w, paths); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		names = append(names, filepath.Base(p))
+	}
+	return names, nil
+}
+
+// ImportEra - unpack files from era archive into aggregator's dir, open them and build missed indices.
+// Existing files are not overwritten.
+func (a *AggregatorV3) ImportEra(ctx context.Context, r io.Reader, workers int) ([]string, error) {
+	names, err := readEra(ctx, r, a.dir)
+	if err != nil {
+		return names, err
+	}
+	if err = a.OpenFolder(); err != nil {
+		return names, err
+	}
+	if err = a.BuildMissedIndices(ctx, workers); err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
+func writeEra(ctx context.Context, w io.Writer, paths []string) error {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if err := writeEraEntry(bw, eraTypeVersion, nil); err != nil {
+		return err
+	}
+	buf := make([]byte, 4<<20)
+	for _, fPath := range paths {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := writeEraEntry(bw, eraTypeStateFileName, []byte(filepath.Base(fPath))); err != nil {
+			return err
+		}
+		if err := writeEraFileData(bw, fPath, buf); err != nil {
+			return fmt.Errorf("era export %s: %w", fPath, err)
+		}
+	}
+	return bw.Flush()
+}
+
+func writeEraFileData(w io.Writer, fPath string, buf []byte) error {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	for left := st.Size(); left > 0; {
+		chunk := left
+		if chunk > eraDataChunkLimit {
+			chunk = eraDataChunkLimit
+		}
+		if err = writeEraHeader(w, eraTypeStateFileData, uint32(chunk)); err != nil {
+			return err
+		}
+		if _, err = io.CopyBuffer(w, io.LimitReader(f, chunk), buf); err != nil {
+			return err
+		}
+		left -= chunk
+	}
+	return nil
+}
+
+func writeEraHeader(w io.Writer, typ uint16, length uint32) error {
+	var header [eraHeaderSize]byte
+	binary.LittleEndian.PutUint16(header[:], typ)
+	binary.LittleEndian.PutUint32(header[2:], length)
+	_, err := w.Write(header[:])
+	return err
+}
+
+func writeEraEntry(w io.Writer, typ uint16, data []byte) error {
+	if err := writeEraHeader(w, typ, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readEraHeader(r io.Reader) (typ uint16, length uint32, err error) {
+	var header [eraHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	if header[6] != 0 || header[7] != 0 {
+		return 0, 0, fmt.Errorf("era: reserved bytes of entry header must be zero")
+	}
+	return binary.LittleEndian.Uint16(header[:]), binary.LittleEndian.Uint32(header[2:]), nil
+}
+
+// readEra - unpack state files from archive `r` into `dir`. Files are written to `.tmp` and renamed after full read.
+func readEra(ctx context.Context, r io.Reader, dir string) (names []string, err error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	typ, length, err := readEraHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("era: %w", err)
+	}
+	if typ != eraTypeVersion || length != 0 {
+		return nil, fmt.Errorf("era: first entry must be version, got type 0x%x", typ)
+	}
+
+	var f *os.File
+	var fPath string
+	finish := func() error {
+		if f == nil {
+			return nil
+		}
+		defer func() { f = nil }()
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(fPath+".tmp", fPath); err != nil {
+			return err
+		}
+		names = append(names, filepath.Base(fPath))
+		return nil
+	}
+	defer func() {
+		if f != nil { // archive is broken: don't leave partial file
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return names, ctx.Err()
+		default:
+		}
+		typ, length, err = readEraHeader(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return names, fmt.Errorf("era: %w", err)
+		}
+		switch typ {
+		case eraTypeStateFileName:
+			if err = finish(); err != nil {
+				return names, err
+			}
+			name := make([]byte, length)
+			if _, err = io.ReadFull(br, name); err != nil {
+				return names, fmt.Errorf("era: %w", err)
+			}
+			if !eraFileNameAllowed(string(name)) {
+				return names, fmt.Errorf("era: unexpected file name %q", name)
+			}
+			fPath = filepath.Join(dir, string(name))
+			if _, err = os.Stat(fPath); err == nil {
+				return names, fmt.Errorf("era: file already exists: %s", fPath)
+			}
+			if f, err = os.Create(fPath + ".tmp"); err != nil {
+				return names, err
+			}
+		case eraTypeStateFileData:
+			if f == nil {
+				return names, fmt.Errorf("era: data entry without file name")
+			}
+			if _, err = io.CopyN(f, br, int64(length)); err != nil {
+				return names, fmt.Errorf("era: %w", err)
+			}
+		default: // unknown entries must be skipped by e2store readers
+			if _, err = br.Discard(int(length)); err != nil {
+				return names, fmt.Errorf("era: %w", err)
+			}
+		}
+	}
+	if err = finish(); err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
+// eraFileNameAllowed - only plain names of data files: archive must not write outside of dir
+func eraFileNameAllowed(name string) bool {
+	if name == "" || filepath.Base(name) != name || name == "." || name == ".." {
+		return false
+	}
+	switch filepath.Ext(name) {
+	case ".v", ".ef":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEraRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	files := map[string][]byte{
+		"accounts.0-1.v":   []byte("accounts history"),
+		"accounts.0-1.ef":  []byte("accounts index"),
+		"logaddrs.0-1.ef":  {},
+		"tracesto.1-2.ef":  bytes.Repeat([]byte{1}, 10_000),
+		"accounts.0-1.vi":  []byte("not archived: indices are re-built"),
+		"accounts.0-1.efi": []byte("not archived: indices are re-built"),
+	}
+	var paths []string
+	for name, data := range files {
+		p := filepath.Join(srcDir, name)
+		require.NoError(t, os.WriteFile(p, data, 0644))
+		if eraFileNameAllowed(name) {
+			paths = append(paths, p)
+		}
+	}
+
+	var archive bytes.Buffer
+	require.NoError(t, writeEra(ctx, &archive, paths))
+	names, err := readEra(ctx, bytes.NewReader(archive.Bytes()), dstDir)
+	require.NoError(t, err)
+	require.Equal(t, len(paths), len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dstDir, name))
+		require.NoError(t, err)
+		require.Equal(t, files[name], data)
+	}
+
+	// existing files are not overwritten
+	_, err = readEra(ctx, bytes.NewReader(archive.Bytes()), dstDir)
+	require.Error(t, err)
+
+	// entries of other types (era1 block records) are skipped
+	archive.Reset()
+	require.NoError(t, writeEraEntry(&archive, eraTypeVersion, nil))
+	require.NoError(t, writeEraEntry(&archive, 0x03, []byte("era1 compressed header")))
+	require.NoError(t, writeEraEntry(&archive, eraTypeStateFileName, []byte("accounts.0-1.v")))
+	require.NoError(t, writeEraEntry(&archive, eraTypeStateFileData, files["accounts.0-1.v"]))
+	require.NoError(t, writeEraEntry(&archive, 0x3266, []byte("era1 block index")))
+	otherDir := t.TempDir()
+	names, err = readEra(ctx, bytes.NewReader(archive.Bytes()), otherDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"accounts.0-1.v"}, names)
+	data, err := os.ReadFile(filepath.Join(otherDir, "accounts.0-1.v"))
+	require.NoError(t, err)
+	require.Equal(t, files["accounts.0-1.v"], data)
+
+	// archive can't write outside of dir
+	archive.Reset()
+	require.NoError(t, writeEraEntry(&archive, eraTypeVersion, nil))
+	require.NoError(t, writeEraEntry(&archive, eraTypeStateFileName, []byte("../accounts.0-1.v")))
+	_, err = readEra(ctx, bytes.NewReader(archive.Bytes()), t.TempDir())
+	require.Error(t, err)
+}
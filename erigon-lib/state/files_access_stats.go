@@ -0,0 +1,90 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+type fileAccessStats struct {
+	queries, misses, reads, bytesRead uint64
+}
+
+// filesAccessStats - per-file counters of context: local to avoid atomics on hot path,
+// flushed to filesItem on context Close. Allocated on first access.
+type filesAccessStats []fileAccessStats
+
+func (s *filesAccessStats) at(i, filesAmount int) *fileAccessStats {
+	if *s == nil {
+		*s = make(filesAccessStats, filesAmount)
+	}
+	return &(*s)[i]
+}
+
+func (s filesAccessStats) flush(files []ctxItem) {
+	for i := range s {
+		st, item := &s[i], files[i].src
+		if st.queries > 0 {
+			item.queries.Add(st.queries)
+		}
+		if st.misses > 0 {
+			item.misses.Add(st.misses)
+		}
+		if st.reads > 0 {
+			item.reads.Add(st.reads)
+			item.bytesRead.Add(st.bytesRead)
+		}
+	}
+}
+
+// FileAccessStats - counters since file was opened. Hot steps are candidates for warm-up, fast storage and merge.
+type FileAccessStats struct {
+	Name      string `json:"name"`
+	StartStep uint64 `json:"startStep"`
+	EndStep   uint64 `json:"endStep"`
+	Frozen    bool   `json:"frozen"`
+	Queries   uint64 `json:"queries"`   // lookups served by file
+	Misses    uint64 `json:"misses"`    // lookups of keys which are not in file: rejected by accessor index or by key compare
+	Reads     uint64 `json:"reads"`     // values read
+	BytesRead uint64 `json:"bytesRead"` // after decompression
+}
+
+func (a *AggregatorV3) FilesAccessStats() (res []FileAccessStats) {
+	ac := a.MakeContext()
+	defer ac.Close()
+	add := func(files []ctxItem) {
+		for _, item := range files {
+			if item.src.decompressor == nil {
+				continue
+			}
+			res = append(res, FileAccessStats{
+				Name:      item.src.decompressor.FileName(),
+				StartStep: item.startTxNum / a.aggregationStep,
+				EndStep:   item.endTxNum / a.aggregationStep,
+				Frozen:    item.src.frozen,
+				Queries:   item.src.queries.Load(),
+				Misses:    item.src.misses.Load(),
+				Reads:     item.src.reads.Load(),
+				BytesRead: item.src.bytesRead.Load(),
+			})
+		}
+	}
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		add(hc.files)
+		add(hc.ic.files)
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		add(ic.files)
+	}
+	return res
+}
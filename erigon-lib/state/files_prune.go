@@ -0,0 +1,166 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	btree2 "github.com/tidwall/btree"
+)
+
+// SetKeepSteps - minimal-state mode: keep only files of last `steps` steps of history and indices, older files
+// (including frozen) are deleted by PruneOldFiles. Deleted files are reported to OnFilesDelete. Latest state is not affected (it's in PlainState),
+// historical reads before EarliestServiceableTxNum return ErrHistoryNotAvailable. 0 - keep all files.
+func (a *AggregatorV3) SetKeepSteps(steps uint64) { a.keepSteps.Store(steps) }
+
+// PruneOldFiles - see SetKeepSteps. Only whole files are deleted: file which has at least 1 of last `steps` steps is kept.
+// Before delete: checks that remaining files of every history/index have no gaps - otherwise nothing is deleted.
+func (a *AggregatorV3) PruneOldFiles() (removed []string, err error) {
+	keepSteps := a.keepSteps.Load()
+	if keepSteps == 0 || a.readonly.Load() {
+		return nil, nil
+	}
+	keepTxNums := keepSteps * a.aggregationStep
+	if a.minimaxTxNumInFiles.Load() <= keepTxNums {
+		return nil, nil
+	}
+	cutoff := a.minimaxTxNumInFiles.Load() - keepTxNums
+
+	removed, err = a.removeFilesBefore(cutoff, "minimal-state: older than kept steps")
+	if err != nil {
+		return nil, fmt.Errorf("PruneOldFiles: %w", err)
+	}
+	if len(removed) > 0 {
+		a.logger.Info("[snapshots] minimal-state: deleted old files", "keep_steps", keepSteps, "before_step", cutoff/a.aggregationStep, "files", len(removed))
+		a.onFilesDelete(removed)
+	}
+	return removed, nil
+}
+
+// ExpireFilesBefore - history expiry (EIP-4444): deletes files of history and indices which have only txNums < `cutoff`
+// (including frozen). Caller must make sure that cutoff is below executed and verified blocks. Files which reach
+// last built txNum are never deleted. After that historical reads before EarliestServiceableTxNum return ErrHistoryNotAvailable.
+func (a *AggregatorV3) ExpireFilesBefore(cutoff uint64) (removed []string, err error) {
+	if cutoff == 0 || a.readonly.Load() {
+		return nil, nil
+	}
+	if cutoff >= a.minimaxTxNumInFiles.Load() {
+		return nil, nil
+	}
+	removed, err = a.removeFilesBefore(cutoff, "history expiry: older than configured cutoff")
+	if err != nil {
+		return nil, fmt.Errorf("ExpireFilesBefore: %w", err)
+	}
+	if len(removed) > 0 {
+		a.logger.Info("[snapshots] history expiry: deleted old files", "before_step", cutoff/a.aggregationStep, "files", len(removed))
+		a.onFilesDelete(removed)
+	}
+	return removed, nil
+}
+
+// removeFilesBefore - deletes files of all histories and indices which end before `cutoff`.
+// Before delete: checks that remaining files of every history/index have no gaps - otherwise nothing is deleted.
+func (a *AggregatorV3) removeFilesBefore(cutoff uint64, reason string) (removed []string, err error) {
+	if err := a.lockDirForWrite(); err != nil {
+		return nil, err
+	}
+	a.filesMutationLock.Lock()
+	defer a.filesMutationLock.Unlock()
+
+	type component struct {
+		name          string
+		trees         []*btree2.BTreeG[*filesItem] // history: .v and .ef files of same ranges
+		reCalcRoFiles func()
+	}
+	components := []component{
+		{a.accounts.filenameBase, []*btree2.BTreeG[*filesItem]{a.accounts.files, a.accounts.InvertedIndex.files}, func() { a.accounts.reCalcRoFiles(); a.accounts.InvertedIndex.reCalcRoFiles() }},
+		{a.storage.filenameBase, []*btree2.BTreeG[*filesItem]{a.storage.files, a.storage.InvertedIndex.files}, func() { a.storage.reCalcRoFiles(); a.storage.InvertedIndex.reCalcRoFiles() }},
+		{a.code.filenameBase, []*btree2.BTreeG[*filesItem]{a.code.files, a.code.InvertedIndex.files}, func() { a.code.reCalcRoFiles(); a.code.InvertedIndex.reCalcRoFiles() }},
+	}
+	for _, ii := range append([]*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo, a.txLookup}, a.extraIndices...) {
+		components = append(components, component{ii.filenameBase, []*btree2.BTreeG[*filesItem]{ii.files}, ii.reCalcRoFiles})
+	}
+
+	// verify all before delete anything
+	for _, c := range components {
+		for _, tree := range c.trees {
+			if err := checkNoGaps(ctxFiles(tree), cutoff); err != nil {
+				return nil, fmt.Errorf("%s: %w", c.name, err)
+			}
+		}
+	}
+
+	var retire []*filesItem
+	for _, c := range components {
+		for _, tree := range c.trees {
+			var toDelete []*filesItem
+			tree.Walk(func(items []*filesItem) bool {
+				for _, item := range items {
+					if item.endTxNum <= cutoff && !item.external {
+						toDelete = append(toDelete, item)
+					}
+				}
+				return true
+			})
+			for _, item := range toDelete {
+				tree.Delete(item)
+				removed = append(removed, item.fileNames()...)
+			}
+			retire = append(retire, toDelete...)
+		}
+		c.reCalcRoFiles()
+	}
+	// new contexts don't see them anymore
+	for _, item := range retire {
+		a.retirePruned(item, reason)
+	}
+	if len(removed) > 0 {
+		a.needSaveFilesListInDB.Store(true)
+		a.recalcMaxTxNum()
+	}
+	return removed, nil
+}
+
+// checkNoGaps - files which will remain after delete of files ending before `cutoff` must cover continuous range
+func checkNoGaps(files []ctxItem, cutoff uint64) error {
+	var prevEnd uint64
+	started := false
+	for _, f := range files {
+		if f.endTxNum <= cutoff {
+			continue
+		}
+		if started && f.startTxNum != prevEnd {
+			return fmt.Errorf("gap in files: [%d, %d)", prevEnd, f.startTxNum)
+		}
+		started, prevEnd = true, f.endTxNum
+	}
+	return nil
+}
+
+// retirePruned - as retireReplaced, but files are removed from disk also when frozen. Last reader closes and removes them:
+// opened file can't be removed on some OS (Windows). Their .torrent files are removed right away: Downloader must not
+// seed or download them again.
+func (a *AggregatorV3) retirePruned(item *filesItem, reason string) {
+	for _, path := range item.filePaths() {
+		removeFile(path + ".torrent")
+	}
+	item.pruned.Store(true)
+	item.markCanDelete(a.retired, reason, nil)
+	if item.refcount.Load() == 0 {
+		item.closeFilesAndRemove()
+	}
+}
@@ -0,0 +1,153 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+)
+
+// filesRefs - debug-mode (dbg.TraceFilesRefs) tracker of references to files.
+// Each context (which increments filesItem.refcount) stores stack of it's creation here,
+// so leaked contexts and reads of closed files can be reported with stack of the owner.
+type filesRefs struct {
+	enabled bool
+	nextID  atomic.Uint64
+
+	lock sync.Mutex
+	refs map[*filesItem]map[uint64]fileRef
+
+	reportOnce sync.Once
+}
+
+type fileRef struct {
+	stack   string
+	created time.Time
+}
+
+func newFilesRefs(enabled bool) *filesRefs {
+	return &filesRefs{enabled: enabled, refs: map[*filesItem]map[uint64]fileRef{}}
+}
+
+// acquire - must be called after refcount increment. returns id of references - pass it to `release`.
+// nil tracker is disabled one
+func (t *filesRefs) acquire(files []ctxItem) uint64 {
+	if t == nil || !t.enabled {
+		return 0
+	}
+	t.reportOnce.Do(func() { go t.reportLeaksLoop() })
+	id := t.nextID.Add(1)
+	ref := fileRef{stack: dbg.StackSkip(3), created: time.Now()}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, item := range files {
+		if item.src.canDelete.Load() {
+			log.Warn("[dbg] context opened file which is already marked canDelete", "files", item.src.fileNames(), "stack", ref.stack)
+		}
+		refs, ok := t.refs[item.src]
+		if !ok {
+			refs = map[uint64]fileRef{}
+			t.refs[item.src] = refs
+		}
+		refs[id] = ref
+	}
+	return id
+}
+
+// release - must be called before refcount decrement
+func (t *filesRefs) release(id uint64, files []ctxItem) {
+	if t == nil || !t.enabled {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, item := range files {
+		refs := t.refs[item.src]
+		if _, ok := refs[id]; !ok {
+			log.Error("[dbg] release of not acquired file reference (double Close?)", "files", item.src.fileNames(), "stack", dbg.StackSkip(3))
+			continue
+		}
+		delete(refs, id)
+		if len(refs) == 0 {
+			delete(t.refs, item.src)
+		}
+	}
+}
+
+func (t *filesRefs) checkRefcount(item *filesItem, refCnt int32) {
+	if t == nil || !t.enabled || refCnt >= 0 {
+		return
+	}
+	log.Error("[dbg] refcount underflow", "files", item.fileNames(), "refcount", refCnt, "stack", dbg.StackSkip(3))
+}
+
+// checkRead - reports read of file which is already closed by `closeFilesAndRemove`, with stacks of all owners
+func (t *filesRefs) checkRead(item *filesItem) {
+	if t == nil || !t.enabled || (item.decompressor != nil && item.index != nil) {
+		return
+	}
+	log.Error("[dbg] read of closed file", "range", fmt.Sprintf("%d-%d", item.startTxNum, item.endTxNum),
+		"refcount", item.refcount.Load(), "canDelete", item.canDelete.Load(), "stack", dbg.StackSkip(3), "owners", strings.Join(t.owners(item), "; "))
+}
+
+func (t *filesRefs) owners(item *filesItem) (res []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for id, ref := range t.refs[item] {
+		res = append(res, fmt.Sprintf("%d(%s): %s", id, time.Since(ref.created), ref.stack))
+	}
+	return res
+}
+
+// leaked - references older than `olderThan`: contexts which are likely never closed
+func (t *filesRefs) leaked(olderThan time.Duration) (res []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for item, refs := range t.refs {
+		for id, ref := range refs {
+			if time.Since(ref.created) < olderThan {
+				continue
+			}
+			res = append(res, fmt.Sprintf("%s %d(%s): %s", item.fileNames(), id, time.Since(ref.created), ref.stack))
+			if len(res) > 10 { // protect logs from too many output
+				return res
+			}
+		}
+	}
+	return res
+}
+
+func (t *filesRefs) reportLeaksLoop() {
+	olderThan := dbg.SlowTx()
+	if olderThan == 0 {
+		olderThan = 5 * time.Minute
+	}
+	logEvery := time.NewTicker(60 * time.Second)
+	defer logEvery.Stop()
+	for range logEvery.C {
+		if list := t.leaked(olderThan); len(list) > 0 {
+			log.Warn("[dbg] long living references to state files", "list", strings.Join(list, ", "))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	btree2 "github.com/tidwall/btree"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+var replaceFileNameRe = regexp.MustCompile(`^([a-z]+)\.([0-9]+)-([0-9]+)\.(v|vi|ef|efi)$`)
+
+// ReplaceFiles - files were replaced on disk by operator (for example re-downloaded after corruption).
+// Given any file of range (data or accessor) - data and accessor of this range are re-opened,
+// cross-checked (see IntegrityCheck) and atomically switched: contexts created after return see new files.
+// Old files are closed when their last reader is done.
+// Files are never removed from disk by this method.
+func (a *AggregatorV3) ReplaceFiles(ctx context.Context, fileNames []string) (replaced []string, err error) {
+	a.filesMutationLock.Lock()
+	defer a.filesMutationLock.Unlock()
+
+	type target struct {
+		base             string
+		history          bool
+		fromStep, toStep uint64
+	}
+	seen := map[target]bool{}
+	for _, name := range fileNames {
+		subs := replaceFileNameRe.FindStringSubmatch(filepath.Base(name))
+		if len(subs) != 5 {
+			return replaced, fmt.Errorf("ReplaceFiles: unexpected file name: %s", name)
+		}
+		fromStep, err := strconv.ParseUint(subs[2], 10, 64)
+		if err != nil {
+			return replaced, fmt.Errorf("ReplaceFiles: %s: %w", name, err)
+		}
+		toStep, err := strconv.ParseUint(subs[3], 10, 64)
+		if err != nil {
+			return replaced, fmt.Errorf("ReplaceFiles: %s: %w", name, err)
+		}
+		t := target{base: subs[1], history: subs[4] == "v" || subs[4] == "vi", fromStep: fromStep, toStep: toStep}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		var names []string
+		if t.history {
+			names, err = a.replaceHistoryFile(ctx, t.base, t.fromStep, t.toStep)
+		} else {
+			names, err = a.replaceInvertedIndexFile(ctx, t.base, t.fromStep, t.toStep)
+		}
+		if err != nil {
+			return replaced, fmt.Errorf("ReplaceFiles: %s: %w", name, err)
+		}
+		replaced = append(replaced, names...)
+	}
+	a.recalcMaxTxNum()
+	return replaced, nil
+}
+
+func (a *AggregatorV3) replaceHistoryFile(ctx context.Context, base string, fromStep, toStep uint64) ([]string, error) {
+	var h *History
+	for _, candidate := range []*History{a.accounts, a.storage, a.code} {
+		if candidate.filenameBase == base {
+			h = candidate
+		}
+	}
+	if h == nil {
+		return nil, fmt.Errorf("unknown history: %s", base)
+	}
+	iiItem, ok := h.InvertedIndex.files.Get(&filesItem{startTxNum: fromStep * a.aggregationStep, endTxNum: toStep * a.aggregationStep})
+	if !ok {
+		return nil, fmt.Errorf("matching .ef file is not open")
+	}
+	return a.replaceFile(h.files, h.InvertedIndex, "v", "vi", fromStep, toStep, func(item *filesItem) error {
+		return a.checkReplacement(ctx, iiItem, item, h.compressVals)
+	}, h.reCalcRoFiles)
+}
+
+func (a *AggregatorV3) replaceInvertedIndexFile(ctx context.Context, base string, fromStep, toStep uint64) ([]string, error) {
+	var ii *InvertedIndex
+	for _, candidate := range a.invertedIndices() {
+		if candidate.filenameBase == base {
+			ii = candidate
+		}
+	}
+	if ii == nil {
+		return nil, fmt.Errorf("unknown inverted index: %s", base)
+	}
+	return a.replaceFile(ii.files, ii, "ef", "efi", fromStep, toStep, func(item *filesItem) error {
+		return a.checkReplacement(ctx, item, nil, false)
+	}, ii.reCalcRoFiles)
+}
+
+func (a *AggregatorV3) checkReplacement(ctx context.Context, iiItem, hItem *filesItem, compressVals bool) error {
+	r, err := checkFilesIntegrity(ctx, iiItem, hItem, compressVals)
+	if err != nil {
+		return err
+	}
+	if len(r.Errs) > 0 {
+		return fmt.Errorf("new files are inconsistent: %s", r.Errs[0])
+	}
+	return nil
+}
+
+// replaceFile - `paths` resolves file names (History uses its embedded InvertedIndex)
+func (a *AggregatorV3) replaceFile(files *btree2.BTreeG[*filesItem], paths *InvertedIndex, dataExt, idxExt string, fromStep, toStep uint64, check func(*filesItem) error, reCalcRoFiles func()) ([]string, error) {
+	old, ok := files.Get(&filesItem{startTxNum: fromStep * a.aggregationStep, endTxNum: toStep * a.aggregationStep})
+	if !ok || old.decompressor == nil {
+		return nil, fmt.Errorf("file is not open, use OpenFolder")
+	}
+	datPath, external := paths.filePath(fmt.Sprintf("%s.%d-%d.%s", paths.filenameBase, fromStep, toStep, dataExt))
+	idxPath, _ := paths.filePath(fmt.Sprintf("%s.%d-%d.%s", paths.filenameBase, fromStep, toStep, idxExt))
+
+	item := &filesItem{startTxNum: old.startTxNum, endTxNum: old.endTxNum, frozen: old.frozen, external: external}
+	var err error
+	if item.decompressor, err = seg.NewDecompressor(datPath); err != nil {
+		return nil, err
+	}
+	if dir.FileExist(idxPath) {
+		if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
+			item.decompressor.Close()
+			return nil, err
+		}
+	}
+	if err := check(item); err != nil {
+		item.closeFiles()
+		return nil, err
+	}
+
+	files.Set(item)
+	reCalcRoFiles()
+	a.retireReplaced(old)
+	a.logger.Info("[snapshots] replaced", "files", item.fileNames())
+	return item.fileNames(), nil
+}
+
+// retireReplaced - `old` is not visible for new contexts anymore, but existing contexts may still read it
+func (a *AggregatorV3) retireReplaced(old *filesItem) {
+	old.replaced.Store(true) // its paths now belong to new files
+	old.markCanDelete(a.retired, "replaced on disk", nil)
+	if old.refcount.Load() == 0 {
+		old.closeFilesAndRemove()
+	}
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func TestAggregatorV3_ReplaceFiles(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	dir, tmpdir := filepath.Join(path, "e4"), filepath.Join(path, "e4tmp")
+	defer agg.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	var txnHash [32]byte
+	for txNum := uint64(0); txNum < 40; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(txnHash[:], txNum)
+		require.NoError(t, agg.PutIdx(kv.TblTxLookupIdx, txnHash[:]))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+
+	for step := uint64(0); step < 2; step++ {
+		sf, err := agg.buildFiles(ctx, step, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+		require.NoError(t, err)
+		agg.integrateFiles(sf, step*agg.aggregationStep, (step+1)*agg.aggregationStep)
+	}
+
+	// replace file on disk the way re-download does: new inode under same name
+	replaceOnDisk := func(name string, data []byte) {
+		tmp := filepath.Join(tmpdir, name)
+		require.NoError(t, os.WriteFile(tmp, data, 0644))
+		require.NoError(t, os.Rename(tmp, filepath.Join(dir, name)))
+	}
+	efi, err := os.ReadFile(filepath.Join(dir, "txlookup.0-1.efi"))
+	require.NoError(t, err)
+	otherEfi, err := os.ReadFile(filepath.Join(dir, "txlookup.1-2.efi"))
+	require.NoError(t, err)
+
+	before := agg.txLookup.MakeContext() // closed below
+	old := before.files[0].src
+
+	// accessor of other range doesn't match data: old files stay in use
+	replaceOnDisk("txlookup.0-1.efi", otherEfi)
+	_, err = agg.ReplaceFiles(ctx, []string{"txlookup.0-1.efi"})
+	require.ErrorContains(t, err, "inconsistent")
+	ic := agg.txLookup.MakeContext()
+	require.Equal(t, old, ic.files[0].src)
+	ic.Close()
+
+	replaceOnDisk("txlookup.0-1.efi", efi)
+	replaced, err := agg.ReplaceFiles(ctx, []string{"txlookup.0-1.ef", "txlookup.0-1.efi"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"txlookup.0-1.ef", "txlookup.0-1.efi"}, replaced)
+
+	ic = agg.txLookup.MakeContext()
+	defer ic.Close()
+	require.NotEqual(t, old, ic.files[0].src)
+	binary.BigEndian.PutUint64(txnHash[:], 3)
+	_, ok := ic.statelessIdxReader(0).Lookup(txnHash[:])
+	require.True(t, ok)
+
+	// context created before replace still reads old files, they are closed by its last reader
+	require.True(t, old.replaced.Load())
+	require.NotNil(t, old.decompressor)
+	_, ok = before.statelessIdxReader(0).Lookup(txnHash[:])
+	require.True(t, ok)
+	before.Close()
+	require.Nil(t, old.decompressor)
+	require.FileExists(t, filepath.Join(dir, "txlookup.0-1.ef"))
+
+	replaced, err = agg.ReplaceFiles(ctx, []string{"accounts.1-2.v"})
+	require.NoError(t, err)
+	require.Contains(t, replaced, "accounts.1-2.v")
+
+	_, err = agg.ReplaceFiles(ctx, []string{"txlookup.0-1.kv"})
+	require.ErrorContains(t, err, "unexpected file name")
+	_, err = agg.ReplaceFiles(ctx, []string{"unknown.0-1.ef"})
+	require.ErrorContains(t, err, "unknown inverted index")
+	_, err = agg.ReplaceFiles(ctx, []string{"txlookup.4-5.ef"})
+	require.ErrorContains(t, err, "not open")
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"sync"
+
+	btree2 "github.com/tidwall/btree"
+)
+
+// retiredFiles - files marked canDelete and not closed yet: they are not in files lists anymore, but may be still used
+// by contexts. One registry per AggregatorV3, for FilesState only. Item leaves registry under `lock` before its files
+// are closed: fields of registered items can be read under `lock`.
+type retiredFiles struct {
+	lock  sync.Mutex
+	items map[*filesItem]struct{}
+
+	// lockDir - takes writer lock of dir before removal of files (see AggregatorV3.lockDirForWrite). nil - not needed
+	lockDir func() error
+}
+
+func newRetiredFiles() *retiredFiles { return &retiredFiles{items: map[*filesItem]struct{}{}} }
+
+func (r *retiredFiles) add(item *filesItem) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.items[item] = struct{}{}
+}
+
+// ownsDir - files of retired items can be removed from disk by this process
+func (r *retiredFiles) ownsDir() bool { return r == nil || r.lockDir == nil || r.lockDir() == nil }
+
+func (r *retiredFiles) remove(item *filesItem) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.items, item)
+}
+
+// FileState - lifecycle state of file: for debugging of files which are not deleted/closed or are missing in views
+type FileState struct {
+	Name         string `json:"name"`
+	StartStep    uint64 `json:"startStep"`
+	EndStep      uint64 `json:"endStep"`
+	Frozen       bool   `json:"frozen"`
+	Refcount     int32  `json:"refcount"`  // amount of contexts (views) which use file
+	Visible      bool   `json:"visible"`   // file is in files list of new contexts
+	CanDelete    bool   `json:"canDelete"` // file will be removed when refcount is 0
+	DeleteReason string `json:"deleteReason,omitempty"`
+	Replaced     bool   `json:"replaced"` // replaced on disk, will be closed but not removed
+	External     bool   `json:"external"` // opened from read-only search dir
+	Opened       bool   `json:"opened"`   // decompressor is open
+	Index        bool   `json:"index"`    // recsplit index is open
+	BtIndex      bool   `json:"btIndex"`  // btree index is open
+}
+
+// FilesState - all files known to aggregator, including ones which wait for deletion (retired).
+// Doesn't open context: refcounts are reported as they are.
+func (a *AggregatorV3) FilesState() (res []FileState) {
+	a.filesMutationLock.Lock()
+	defer a.filesMutationLock.Unlock()
+	a.retired.lock.Lock()
+	defer a.retired.lock.Unlock()
+
+	visible := map[*filesItem]bool{}
+	addVisible := func(files []ctxItem) {
+		for _, item := range files {
+			visible[item.src] = true
+		}
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		addVisible(*h.roFiles.Load())
+	}
+	for _, ii := range a.invertedIndices() {
+		addVisible(*ii.roFiles.Load())
+	}
+
+	seen := map[*filesItem]bool{}
+	add := func(item *filesItem, name string) {
+		seen[item] = true
+		if item.decompressor != nil {
+			name = item.decompressor.FileName()
+		}
+		st := FileState{
+			Name:      name,
+			StartStep: item.startTxNum / a.aggregationStep,
+			EndStep:   item.endTxNum / a.aggregationStep,
+			Frozen:    item.frozen,
+			Refcount:  item.refcount.Load(),
+			Visible:   visible[item],
+			CanDelete: item.canDelete.Load(),
+			Replaced:  item.replaced.Load(),
+			External:  item.external,
+			Opened:    item.decompressor != nil,
+			Index:     item.index != nil,
+			BtIndex:   item.bindex != nil,
+		}
+		if reason := item.deleteReason.Load(); reason != nil {
+			st.DeleteReason = *reason
+		}
+		res = append(res, st)
+	}
+	addAll := func(files *btree2.BTreeG[*filesItem], filenameBase, ext string) {
+		files.Walk(func(items []*filesItem) bool {
+			for _, item := range items {
+				add(item, fmt.Sprintf("%s.%d-%d.%s", filenameBase, item.startTxNum/a.aggregationStep, item.endTxNum/a.aggregationStep, ext))
+			}
+			return true
+		})
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		addAll(h.files, h.filenameBase, "v")
+		addAll(h.InvertedIndex.files, h.filenameBase, "ef")
+	}
+	for _, ii := range append([]*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo, a.txLookup}, a.extraIndices...) {
+		addAll(ii.files, ii.filenameBase, "ef")
+	}
+	for item := range a.retired.items {
+		if !seen[item] {
+			add(item, "")
+		}
+	}
+	return res
+}
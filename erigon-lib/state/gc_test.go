@@ -173,3 +173,25 @@ func TestDomainGCReadAfterRemoveFile(t *testing.T) {
 	_, db, d, txs := filledDomain(t, logger)
 	test(t, d, db, txs)
 }
+
+func TestFilesRefsLeaked(t *testing.T) {
+	refs := newFilesRefs(true)
+	files := []ctxItem{{startTxNum: 0, endTxNum: 16, src: &filesItem{startTxNum: 0, endTxNum: 16}}}
+
+	id1 := refs.acquire(files)
+	id2 := refs.acquire(files)
+	require.NotEqual(t, id1, id2)
+	require.Len(t, refs.owners(files[0].src), 2)
+	require.Len(t, refs.leaked(0), 2)
+	require.Empty(t, refs.leaked(time.Hour))
+
+	refs.release(id1, files)
+	require.Len(t, refs.leaked(0), 1)
+	refs.release(id2, files)
+	require.Empty(t, refs.leaked(0))
+	require.Empty(t, refs.refs)
+
+	frozen := []ctxItem{{src: &filesItem{frozen: true}}}
+	refs.release(refs.acquire(frozen), frozen)
+	require.Empty(t, refs.refs)
+}
@@ -30,6 +30,7 @@ import (
 	"time"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/golang/snappy"
 	"github.com/ledgerwatch/log/v3"
 	btree2 "github.com/tidwall/btree"
 	"golang.org/x/exp/slices"
@@ -38,6 +39,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -210,36 +212,55 @@ func (h *History) openFiles() error {
 				continue
 			}
 			fromStep, toStep := item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep
-			datPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.v", h.filenameBase, fromStep, toStep))
+			datPath, external := h.filePath(fmt.Sprintf("%s.%d-%d.v", h.filenameBase, fromStep, toStep))
 			if !dir.FileExist(datPath) {
 				invalidFileItems = append(invalidFileItems, item)
 				continue
 			}
+			idxPath, idxExternal := h.filePath(fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep))
+			if !dir.FileExist(idxPath) && !h.ownsDir() {
+				// index is not built yet by owner of dir: file is not readable without it, will be opened by next OpenFolder
+				invalidFileItems = append(invalidFileItems, item)
+				continue
+			}
 			if item.decompressor, err = seg.NewDecompressor(datPath); err != nil {
-				h.logger.Debug("Hisrory.openFiles: %w, %s", err, datPath)
-				return false
+				invalidFileItems = append(invalidFileItems, item)
+				if !isCorruption(err) {
+					err = fmt.Errorf("%s: %w", datPath, err)
+					return false
+				}
+				h.quarantine(err, external, datPath)
+				h.quarantine(err, idxExternal, idxPath) // useless without data file
+				err = nil
+				continue
 			}
+			item.external = external
 
 			if item.index != nil {
 				continue
 			}
-			idxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep))
 			if dir.FileExist(idxPath) {
 				if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
-					h.logger.Debug(fmt.Errorf("Hisrory.openFiles: %w, %s", err, idxPath).Error())
-					return false
+					item.index = nil
+					if !isCorruption(err) {
+						err = fmt.Errorf("%s: %w", idxPath, err)
+						return false
+					}
+					h.quarantine(err, idxExternal, idxPath)
+					err = nil
+					continue
 				}
 				totalKeys += item.index.KeyCount()
 			}
 		}
 		return true
 	})
-	if err != nil {
-		return err
-	}
 	for _, item := range invalidFileItems {
 		h.files.Delete(item)
 	}
+	if err != nil {
+		return err
+	}
 
 	h.reCalcRoFiles()
 	return nil
@@ -304,9 +325,24 @@ func (h *History) missedIdxFiles() (l []*filesItem) {
 	return l
 }
 
-// BuildMissedIndices - produce .efi/.vi/.kvi from .ef/.v/.kv
-func (hc *HistoryContext) BuildOptionalMissedIndices(ctx context.Context) (err error) {
-	return hc.h.localityIndex.BuildMissedIndices(ctx, hc.ic)
+// BuildOptionalMissedIndices - same as InvertedIndexContext.BuildOptionalMissedIndices, plus .vi of visible history files
+func (hc *HistoryContext) BuildOptionalMissedIndices(ctx context.Context, g *errgroup.Group, ps *background.ProgressSet) (scheduled int) {
+	scheduled = hc.ic.BuildOptionalMissedIndices(ctx, g, ps)
+	for _, item := range hc.files {
+		fromStep, toStep := item.startTxNum/hc.h.aggregationStep, item.endTxNum/hc.h.aggregationStep
+		if idxPath, _ := hc.h.filePath(fmt.Sprintf("%s.%d-%d.vi", hc.h.filenameBase, fromStep, toStep)); dir.FileExist(idxPath) {
+			continue
+		}
+		item := item
+		scheduled++
+		g.Go(func() error {
+			p := &background.Progress{}
+			ps.Add(p)
+			defer ps.Delete(p)
+			return hc.h.buildVi(ctx, item.src, p)
+		})
+	}
+	return scheduled
 }
 
 func (h *History) buildVi(ctx context.Context, item *filesItem, p *background.Progress) (err error) {
@@ -329,7 +365,7 @@ func (h *History) buildVi(ctx context.Context, item *filesItem, p *background.Pr
 	if err != nil {
 		return err
 	}
-	return buildVi(ctx, item, iiItem, idxPath, h.tmpdir, count, p, h.compressVals, h.logger)
+	return buildVi(ctx, item, iiItem, idxPath, h.tmpdir, count, p, h.compressVals, h.madv, h.logger)
 }
 
 func (h *History) BuildMissedIndices(ctx context.Context, g *errgroup.Group, ps *background.ProgressSet) {
@@ -349,12 +385,12 @@ func (h *History) BuildMissedIndices(ctx context.Context, g *errgroup.Group, ps
 func iterateForVi(historyItem, iiItem *filesItem, p *background.Progress, compressVals bool, f func(v []byte) error) (count int, err error) {
 	var cp CursorHeap
 	heap.Init(&cp)
-	g := iiItem.decompressor.MakeGetter()
+	g := NewArchiveGetter(iiItem.decompressor.MakeGetter(), false).ZeroCopy()
 	g.Reset(0)
 	if g.HasNext() {
-		g2 := historyItem.decompressor.MakeGetter()
-		key, _ := g.NextUncompressed()
-		val, _ := g.NextUncompressed()
+		g2 := NewArchiveGetter(historyItem.decompressor.MakeGetter(), compressVals).ZeroCopy()
+		key, _ := g.NextKey(nil)
+		val, _ := g.NextKey(nil)
 		heap.Push(&cp, &CursorItem{
 			t:        FILE_CURSOR,
 			dg:       g,
@@ -371,19 +407,18 @@ func iterateForVi(historyItem, iiItem *filesItem, p *background.Progress, compre
 	// instead, the pair from the previous iteration is processed first - `keyBuf=>valBuf`. After that, `keyBuf` and `valBuf` are assigned
 	// to `lastKey` and `lastVal` correspondingly, and the next step of multi-way merge happens. Therefore, after the multi-way merge loop
 	// (when CursorHeap cp is empty), there is a need to process the last pair `keyBuf=>valBuf`, because it was one step behind
-	var valBuf []byte
+	var valBuf, lastKey []byte
 	for cp.Len() > 0 {
-		lastKey := common.Copy(cp[0].key)
+		lastKey = append(lastKey[:0], cp[0].key...)
 		// Advance all the items that have this key (including the top)
 		//var mergeOnce bool
 		for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 			ci1 := cp[0]
 			keysCount := eliasfano32.Count(ci1.val)
 			for i := uint64(0); i < keysCount; i++ {
-				if compressVals {
-					valBuf, _ = ci1.dg2.Next(valBuf[:0])
-				} else {
-					valBuf, _ = ci1.dg2.NextUncompressed()
+				valBuf, _ = ci1.dg2.NextVal(nil)
+				if err = ci1.dg2.Err(); err != nil {
+					return count, err
 				}
 				if err = f(valBuf); err != nil {
 					return count, err
@@ -391,8 +426,8 @@ func iterateForVi(historyItem, iiItem *filesItem, p *background.Progress, compre
 			}
 			count += int(keysCount)
 			if ci1.dg.HasNext() {
-				ci1.key, _ = ci1.dg.NextUncompressed()
-				ci1.val, _ = ci1.dg.NextUncompressed()
+				ci1.key, _ = ci1.dg.NextKey(nil)
+				ci1.val, _ = ci1.dg.NextKey(nil)
 				heap.Fix(&cp, 0)
 			} else {
 				heap.Remove(&cp, 0)
@@ -404,7 +439,7 @@ func iterateForVi(historyItem, iiItem *filesItem, p *background.Progress, compre
 	return count, nil
 }
 
-func buildVi(ctx context.Context, historyItem, iiItem *filesItem, historyIdxPath, tmpdir string, count int, p *background.Progress, compressVals bool, logger log.Logger) error {
+func buildVi(ctx context.Context, historyItem, iiItem *filesItem, historyIdxPath, tmpdir string, count int, p *background.Progress, compressVals bool, madv *MadvConfig, logger log.Logger) error {
 	rs, err := recsplit.NewRecSplit(recsplit.RecSplitArgs{
 		KeyCount:   count,
 		Enums:      false,
@@ -422,12 +457,14 @@ func buildVi(ctx context.Context, historyItem, iiItem *filesItem, historyIdxPath
 	var txKey [8]byte
 	var valOffset uint64
 
-	defer iiItem.decompressor.EnableMadvNormal().DisableReadAhead()
-	defer historyItem.decompressor.EnableMadvNormal().DisableReadAhead()
+	defer madv.forMerge(iiItem)()
+	defer madv.forMerge(historyItem)()
 
 	g := iiItem.decompressor.MakeGetter()
 	g2 := historyItem.decompressor.MakeGetter()
 	var keyBuf, valBuf []byte
+	var ef eliasfano32.EliasFano // re-used for every key
+	var efIt eliasfano32.EliasFanoIter
 	for {
 		g.Reset(0)
 		g2.Reset(0)
@@ -441,8 +478,8 @@ func buildVi(ctx context.Context, historyItem, iiItem *filesItem, historyIdxPath
 
 			keyBuf, _ = g.NextUncompressed()
 			valBuf, _ = g.NextUncompressed()
-			ef, _ := eliasfano32.ReadEliasFano(valBuf)
-			efIt := ef.Iterator()
+			ef.Reset(valBuf)
+			efIt.ResetTo(&ef)
 			for efIt.HasNext() {
 				txNum, _ := efIt.Next()
 				binary.BigEndian.PutUint64(txKey[:], txNum)
@@ -527,6 +564,9 @@ type historyWAL struct {
 	historyKey       []byte
 	buffered         bool
 	discard          bool
+	compressed       bool   // previous values are snappy-compressed in historyVals, see dbg.CompressWAL
+	compressBuf      []byte // buffer for addPrevValue
+	decompressBuf    []byte // buffer for flush
 
 	// not large:
 	//   keys: txNum -> key1+key2
@@ -555,6 +595,7 @@ func (h *History) newWriter(tmpdir string, buffered, discard bool) *historyWAL {
 		autoIncrementBuf: make([]byte, 8),
 		historyKey:       make([]byte, 0, 128),
 		largeValues:      h.largeValues,
+		compressed:       buffered && dbg.CompressWAL,
 	}
 	if buffered {
 		w.historyVals = etl.NewCollector(h.historyValsTable, tmpdir, etl.NewSortableBuffer(WALCollectorRAM), h.logger)
@@ -567,13 +608,49 @@ func (h *historyWAL) flush(ctx context.Context, tx kv.RwTx) error {
 	if h.discard || !h.buffered {
 		return nil
 	}
-	if err := h.historyVals.Load(tx, h.h.historyValsTable, loadFunc, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
+	load := loadFunc
+	if h.compressed {
+		load = h.decompressLoadFunc
+	}
+	if err := h.historyVals.Load(tx, h.h.historyValsTable, load, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
 		return err
 	}
 	h.close()
 	return nil
 }
 
+// compress - `original` value as it's kept in historyVals. nil stays nil: it means deletion for etl
+func (h *historyWAL) compress(original []byte) []byte {
+	if !h.compressed || original == nil {
+		return original
+	}
+	h.compressBuf = snappy.Encode(h.compressBuf[:cap(h.compressBuf)], original)
+	return h.compressBuf
+}
+
+func (h *historyWAL) decompressLoadFunc(k, v []byte, table etl.CurrentTableReader, next etl.LoadNextFunc) error {
+	prefix := 0
+	if !h.largeValues {
+		prefix = 8 // txNum
+	}
+	if len(v) == prefix { // nil `original`
+		return next(k, k, v)
+	}
+	n, err := snappy.DecodedLen(v[prefix:])
+	if err != nil {
+		return fmt.Errorf("history WAL %s: %w", h.h.filenameBase, err)
+	}
+	if cap(h.decompressBuf) < prefix+n {
+		h.decompressBuf = make([]byte, prefix+n)
+	}
+	buf := h.decompressBuf[:prefix+n]
+	copy(buf, v[:prefix])
+	if _, err = snappy.Decode(buf[prefix:], v[prefix:]); err != nil {
+		return fmt.Errorf("history WAL %s: %w", h.h.filenameBase, err)
+	}
+	return next(k, k, buf)
+}
+
 func (h *historyWAL) addPrevValue(key1, key2, original []byte) error {
 	if h.discard {
 		return nil
@@ -598,7 +675,7 @@ func (h *historyWAL) addPrevValue(key1, key2, original []byte) error {
 			}
 			return nil
 		}
-		if err := h.historyVals.Collect(historyKey, original); err != nil {
+		if err := h.historyVals.Collect(historyKey, h.compress(original)); err != nil {
 			return err
 		}
 		if err := ii.wal.indexKeys.Collect(ii.txNumBytes[:], historyKey[:lk]); err != nil {
@@ -626,6 +703,9 @@ func (h *historyWAL) addPrevValue(key1, key2, original []byte) error {
 		}
 		return nil
 	}
+	if h.compressed {
+		historyVal = append(historyVal[:8], h.compress(original)...)
+	}
 	if err := h.historyVals.Collect(historyKey1, historyVal); err != nil {
 		return err
 	}
@@ -651,7 +731,9 @@ func (c HistoryCollation) Close() {
 	}
 }
 
-func (h *History) collate(step, txFrom, txTo uint64, roTx kv.Tx) (HistoryCollation, error) {
+func (h *History) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv.Tx) (HistoryCollation, error) {
+	_, span := startSpan(ctx, "collate", h.filenameBase, txFrom, txTo, h.aggregationStep)
+	defer span.End()
 	var historyComp *seg.Compressor
 	var err error
 	closeComp := true
@@ -666,6 +748,7 @@ func (h *History) collate(step, txFrom, txTo uint64, roTx kv.Tx) (HistoryCollati
 	if historyComp, err = seg.NewCompressor(context.Background(), "collate history", historyPath, h.tmpdir, seg.MinPatternScore, h.compressWorkers, log.LvlTrace, h.logger); err != nil {
 		return HistoryCollation{}, fmt.Errorf("create %s history compressor: %w", h.filenameBase, err)
 	}
+	historyComp.SetChecksums(dbg.SegChecksums)
 	keysCursor, err := roTx.CursorDupSort(h.indexKeysTable)
 	if err != nil {
 		return HistoryCollation{}, fmt.Errorf("create %s history cursor: %w", h.filenameBase, err)
@@ -680,6 +763,9 @@ func (h *History) collate(step, txFrom, txTo uint64, roTx kv.Tx) (HistoryCollati
 		if txNum >= txTo {
 			break
 		}
+		if h.keep != nil && !h.keep(v) {
+			continue
+		}
 		var bitmap *roaring64.Bitmap
 		var ok bool
 		if bitmap, ok = indexBitmaps[string(v)]; !ok {
@@ -782,6 +868,7 @@ func (sf HistoryFiles) Close() {
 	}
 }
 func (h *History) reCalcRoFiles() {
+	h.madv.apply(h.files)
 	roFiles := ctxFiles(h.files)
 	h.roFiles.Store(&roFiles)
 }
@@ -789,6 +876,11 @@ func (h *History) reCalcRoFiles() {
 // buildFiles performs potentially resource intensive operations of creating
 // static files and their indices
 func (h *History) buildFiles(ctx context.Context, step uint64, collation HistoryCollation, ps *background.ProgressSet) (HistoryFiles, error) {
+	ctx, span := startSpan(ctx, "build_files", h.filenameBase, step*h.aggregationStep, (step+1)*h.aggregationStep, h.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "build_files", h.filenameBase, step*h.aggregationStep, (step+1)*h.aggregationStep, h.aggregationStep)
+	defer restoreLabels()
+	defer fileTook(mxBuildFileTook, collation.historyPath, h.metricsLabel).PutSince()
 	historyComp := collation.historyComp
 	if h.noFsync {
 		historyComp.DisableFsync()
@@ -898,7 +990,7 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 	efHistoryIdxPath := filepath.Join(h.dir, efHistoryIdxFileName)
 	p := ps.AddNew(efHistoryIdxFileName, uint64(len(keys)*2))
 	defer ps.Delete(p)
-	if efHistoryIdx, err = buildIndexThenOpen(ctx, efHistoryDecomp, efHistoryIdxPath, h.tmpdir, len(keys), false /* values */, p, h.logger, h.noFsync); err != nil {
+	if efHistoryIdx, err = buildIndexThenOpen(ctx, efHistoryDecomp, efHistoryIdxPath, h.tmpdir, len(keys), false /* values */, p, h.logger, h.noFsync, h.metricsLabel); err != nil {
 		return HistoryFiles{}, fmt.Errorf("build %s ef history idx: %w", h.filenameBase, err)
 	}
 	if rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
@@ -937,7 +1029,7 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 		}
 		if err = rs.Build(ctx); err != nil {
 			if rs.Collision() {
-				log.Info("Building recsplit. Collision happened. It's ok. Restarting...")
+				h.logger.Info("Building recsplit. Collision happened. It's ok. Restarting...")
 				rs.ResetNextSalt()
 			} else {
 				return HistoryFiles{}, fmt.Errorf("build idx: %w", err)
@@ -1049,6 +1141,10 @@ func (h *History) isEmpty(tx kv.Tx) (bool, error) {
 }
 
 func (h *History) prune(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error {
+	ctx, span := startSpan(ctx, "prune", h.filenameBase, txFrom, txTo, h.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "prune", h.filenameBase, txFrom, txTo, h.aggregationStep)
+	defer restoreLabels()
 	historyKeysCursorForDeletes, err := h.tx.RwCursorDupSort(h.indexKeysTable)
 	if err != nil {
 		return fmt.Errorf("create %s history cursor: %w", h.filenameBase, err)
@@ -1123,6 +1219,8 @@ type HistoryContext struct {
 	files   []ctxItem // have no garbage (canDelete=true, overlaps, etc...)
 	getters []*seg.Getter
 	readers []*recsplit.IndexReader
+	stats   filesAccessStats
+	refsID  uint64 // see filesRefs
 
 	trace bool
 }
@@ -1137,10 +1235,9 @@ func (h *History) MakeContext() *HistoryContext {
 		trace: false,
 	}
 	for _, item := range hc.files {
-		if !item.src.frozen {
-			item.src.refcount.Add(1)
-		}
+		item.src.refcount.Add(1)
 	}
+	hc.refsID = hc.h.refs.acquire(hc.files)
 
 	return &hc
 }
@@ -1151,6 +1248,7 @@ func (hc *HistoryContext) statelessGetter(i int) *seg.Getter {
 	}
 	r := hc.getters[i]
 	if r == nil {
+		hc.h.refs.checkRead(hc.files[i].src)
 		r = hc.files[i].src.decompressor.MakeGetter()
 		hc.getters[i] = r
 	}
@@ -1162,6 +1260,7 @@ func (hc *HistoryContext) statelessIdxReader(i int) *recsplit.IndexReader {
 	}
 	r := hc.readers[i]
 	if r == nil {
+		hc.h.refs.checkRead(hc.files[i].src)
 		r = hc.files[i].src.index.GetReaderFromPool()
 		hc.readers[i] = r
 	}
@@ -1170,11 +1269,11 @@ func (hc *HistoryContext) statelessIdxReader(i int) *recsplit.IndexReader {
 
 func (hc *HistoryContext) Close() {
 	hc.ic.Close()
+	hc.stats.flush(hc.files)
+	hc.h.refs.release(hc.refsID, hc.files)
 	for _, item := range hc.files {
-		if item.src.frozen {
-			continue
-		}
 		refCnt := item.src.refcount.Add(-1)
+		hc.h.refs.checkRefcount(item.src, refCnt)
 		//if hc.h.filenameBase == "accounts" && item.src.canDelete.Load() {
 		//	log.Warn("[history] HistoryContext.Close: check file to remove", "refCnt", refCnt, "name", item.src.decompressor.FileName())
 		//}
@@ -1199,6 +1298,14 @@ func (hc *HistoryContext) getFile(from, to uint64) (it ctxItem, ok bool) {
 }
 
 func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, error) {
+	defer hc.h.readLimiter.acquire(hc.ic.class)()
+	return hc.getNoState(key, txNum)
+}
+
+func (hc *HistoryContext) getNoState(key []byte, txNum uint64) ([]byte, bool, error) {
+	if err := hc.ic.checkWatched(key, txNum); err != nil {
+		return nil, false, err
+	}
 	exactStep1, exactStep2, lastIndexedTxNum, foundExactShard1, foundExactShard2 := hc.h.localityIndex.lookupIdxFiles(hc.ic.loc, key, txNum)
 
 	//fmt.Printf("GetNoState [%x] %d\n", key, txNum)
@@ -1213,6 +1320,7 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 		}
 		offset, ok := reader.Lookup(key)
 		if !ok {
+			hc.ic.countMiss(item.i)
 			return false
 		}
 		g := hc.ic.statelessGetter(item.i)
@@ -1220,18 +1328,19 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 		k, _ := g.NextUncompressed()
 
 		if !bytes.Equal(k, key) {
+			hc.ic.countMiss(item.i)
 			//if bytes.Equal(key, hex.MustDecodeString("009ba32869045058a3f05d6f3dd2abb967e338f6")) {
 			//	fmt.Printf("not in this shard: %x, %d, %d-%d\n", k, txNum, item.startTxNum/hc.h.aggregationStep, item.endTxNum/hc.h.aggregationStep)
 			//}
 			return true
 		}
 		eliasVal, _ := g.NextUncompressed()
-		ef, _ := eliasfano32.ReadEliasFano(eliasVal)
-		n, ok := ef.Search(txNum)
-		if hc.trace {
-			n2, _ := ef.Search(n + 1)
-			n3, _ := ef.Search(n - 1)
-			fmt.Printf("hist: files: %s %d<-%d->%d->%d, %x\n", hc.h.filenameBase, n3, txNum, n, n2, key)
+		hc.ic.countRead(item.i, len(eliasVal))
+		n, ok := eliasfano32.Seek(eliasVal, txNum)
+		if hc.trace || domainDebug(hc.h.filenameBase) {
+			n2, _ := eliasfano32.Seek(eliasVal, n+1)
+			n3, _ := eliasfano32.Seek(eliasVal, n-1)
+			hc.h.logger.Debug("[dbg] hist: files", "domain", hc.h.filenameBase, "prev", n3, "txNum", txNum, "found", n, "next", n2, "key", fmt.Sprintf("%x", key))
 		}
 		if ok {
 			foundTxNum = n
@@ -1297,18 +1406,27 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 		var txKey [8]byte
 		binary.BigEndian.PutUint64(txKey[:], foundTxNum)
 		reader := hc.statelessIdxReader(historyItem.i)
+		stats := hc.stats.at(historyItem.i, len(hc.files))
+		stats.queries++
 		offset, ok := reader.Lookup2(txKey[:], key)
 		if !ok {
+			stats.misses++
 			return nil, false, nil
 		}
 		//fmt.Printf("offset = %d, txKey=[%x], key=[%x]\n", offset, txKey[:], key)
 		g := hc.statelessGetter(historyItem.i)
 		g.Reset(offset)
+		var v []byte
 		if hc.h.compressVals {
-			v, _ := g.Next(nil)
-			return v, true, nil
+			v, _ = g.Next(nil)
+		} else {
+			v, _ = g.NextUncompressed()
+		}
+		if err := g.Err(); err != nil {
+			return nil, false, err
 		}
-		v, _ := g.NextUncompressed()
+		stats.reads++
+		stats.bytesRead += uint64(len(v))
 		return v, true, nil
 	}
 	return nil, false, nil
@@ -1331,10 +1449,9 @@ func (hs *HistoryStep) GetNoState(key []byte, txNum uint64) ([]byte, bool, uint6
 	}
 	//fmt.Printf("Found key=%x\n", k)
 	eliasVal, _ := g.NextUncompressed()
-	ef, _ := eliasfano32.ReadEliasFano(eliasVal)
-	n, ok := ef.Search(txNum)
+	n, ok := eliasfano32.Seek(eliasVal, txNum)
 	if !ok {
-		return nil, false, ef.Max()
+		return nil, false, eliasfano32.Max(eliasVal)
 	}
 	var txKey [8]byte
 	binary.BigEndian.PutUint64(txKey[:], n)
@@ -1375,7 +1492,8 @@ func (hs *HistoryStep) MaxTxNum(key []byte) (bool, uint64) {
 // GetNoStateWithRecent searches history for a value of specified key before txNum
 // second return value is true if the value is found in the history (even if it is nil)
 func (hc *HistoryContext) GetNoStateWithRecent(key []byte, txNum uint64, roTx kv.Tx) ([]byte, bool, error) {
-	v, ok, err := hc.GetNoState(key, txNum)
+	defer hc.h.readLimiter.acquire(hc.ic.class)()
+	v, ok, err := hc.getNoState(key, txNum)
 	if err != nil {
 		return nil, ok, err
 	}
@@ -1494,18 +1612,9 @@ func (hi *StateAsOfIterF) advanceInFiles() error {
 	for hi.h.Len() > 0 {
 		top := heap.Pop(&hi.h).(*ReconItem)
 		key := top.key
-		var idxVal []byte
-		if hi.compressVals {
-			idxVal, _ = top.g.Next(nil)
-		} else {
-			idxVal, _ = top.g.NextUncompressed()
-		}
+		idxVal, _ := top.g.NextUncompressed() // .ef files are never compressed: no copy, slices of mmap
 		if top.g.HasNext() {
-			if hi.compressVals {
-				top.key, _ = top.g.Next(nil)
-			} else {
-				top.key, _ = top.g.NextUncompressed()
-			}
+			top.key, _ = top.g.NextUncompressed()
 			if hi.to == nil || bytes.Compare(top.key, hi.to) < 0 {
 				heap.Push(&hi.h, top)
 			}
@@ -1518,8 +1627,7 @@ func (hi *StateAsOfIterF) advanceInFiles() error {
 		if bytes.Equal(key, hi.nextKey) {
 			continue
 		}
-		ef, _ := eliasfano32.ReadEliasFano(idxVal)
-		n, ok := ef.Search(hi.startTxNum)
+		n, ok := eliasfano32.Seek(idxVal, hi.startTxNum)
 		if !ok {
 			continue
 		}
@@ -1538,11 +1646,12 @@ func (hi *StateAsOfIterF) advanceInFiles() error {
 		g := hi.hc.statelessGetter(historyItem.i)
 		g.Reset(offset)
 		if hi.compressVals {
-			hi.nextVal, _ = g.Next(nil)
+			// Next() copies nextVal before advance: decompress into previous buffer. `compressVals` doesn't change - buffer is never mmap
+			hi.nextVal, _ = g.Next(hi.nextVal[:0])
 		} else {
 			hi.nextVal, _ = g.NextUncompressed()
 		}
-		return nil
+		return g.Err()
 	}
 	hi.nextKey = nil
 	return nil
@@ -1810,26 +1919,16 @@ func (hi *HistoryChangesIterFiles) advance() error {
 	for hi.h.Len() > 0 {
 		top := heap.Pop(&hi.h).(*ReconItem)
 		key := top.key
-		var idxVal []byte
-		if hi.compressVals {
-			idxVal, _ = top.g.Next(nil)
-		} else {
-			idxVal, _ = top.g.NextUncompressed()
-		}
+		idxVal, _ := top.g.NextUncompressed() // .ef files are never compressed: no copy, slices of mmap
 		if top.g.HasNext() {
-			if hi.compressVals {
-				top.key, _ = top.g.Next(nil)
-			} else {
-				top.key, _ = top.g.NextUncompressed()
-			}
+			top.key, _ = top.g.NextUncompressed()
 			heap.Push(&hi.h, top)
 		}
 
 		if bytes.Equal(key, hi.nextKey) {
 			continue
 		}
-		ef, _ := eliasfano32.ReadEliasFano(idxVal)
-		n, ok := ef.Search(hi.startTxNum) //TODO: if startTxNum==0, can do ef.Get(0)
+		n, ok := eliasfano32.Seek(idxVal, hi.startTxNum) //TODO: if startTxNum==0, can do ef.Get(0)
 		if !ok {
 			continue
 		}
@@ -1851,11 +1950,12 @@ func (hi *HistoryChangesIterFiles) advance() error {
 		g := hi.hc.statelessGetter(historyItem.i)
 		g.Reset(offset)
 		if hi.compressVals {
-			hi.nextVal, _ = g.Next(nil)
+			// Next() copies nextVal before advance: decompress into previous buffer. `compressVals` doesn't change - buffer is never mmap
+			hi.nextVal, _ = g.Next(hi.nextVal[:0])
 		} else {
 			hi.nextVal, _ = g.NextUncompressed()
 		}
-		return nil
+		return g.Err()
 	}
 	hi.nextKey = nil
 	return nil
@@ -2042,6 +2142,10 @@ func (h *History) DisableReadAhead() {
 	h.InvertedIndex.DisableReadAhead()
 	h.files.Walk(func(items []*filesItem) bool {
 		for _, item := range items {
+			if h.madv != nil {
+				h.madv.atRest(item)
+				continue
+			}
 			item.decompressor.DisableReadAhead()
 			if item.index != nil {
 				item.index.DisableReadAhead()
@@ -2218,6 +2322,7 @@ func (hc *HistoryContext) idxRangeRecent(key []byte, startTxNum, endTxNum int, a
 	return dbIt, nil
 }
 func (hc *HistoryContext) IdxRange(key []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (iter.U64, error) {
+	defer hc.h.readLimiter.acquire(hc.ic.class)()
 	frozenIt, err := hc.ic.iterateRangeFrozen(key, startTxNum, endTxNum, asc, limit)
 	if err != nil {
 		return nil, err
@@ -25,7 +25,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/background"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
@@ -36,6 +38,7 @@ import (
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/require"
 	btree2 "github.com/tidwall/btree"
+	"golang.org/x/sync/errgroup"
 )
 
 func testDbAndHistory(tb testing.TB, largeValues bool, logger log.Logger) (string, kv.RwDB, *History) {
@@ -105,7 +108,7 @@ func TestHistoryCollationBuild(t *testing.T) {
 		err = h.Rotate().Flush(ctx, tx)
 		require.NoError(err)
 
-		c, err := h.collate(0, 0, 8, tx)
+		c, err := h.collate(ctx, 0, 0, 8, tx)
 		require.NoError(err)
 		require.True(strings.HasSuffix(c.historyPath, "hist.0-1.v"))
 		require.Equal(6, c.historyCount)
@@ -215,7 +218,7 @@ func TestHistoryAfterPrune(t *testing.T) {
 		err = h.Rotate().Flush(ctx, tx)
 		require.NoError(err)
 
-		c, err := h.collate(0, 0, 16, tx)
+		c, err := h.collate(ctx, 0, 0, 16, tx)
 		require.NoError(err)
 
 		sf, err := h.buildFiles(ctx, 0, c, background.NewProgressSet())
@@ -347,7 +350,7 @@ func TestHistoryHistory(t *testing.T) {
 		// Leave the last 2 aggregation steps un-collated
 		for step := uint64(0); step < txs/h.aggregationStep-1; step++ {
 			func() {
-				c, err := h.collate(step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx)
+				c, err := h.collate(ctx, step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx)
 				require.NoError(err)
 				sf, err := h.buildFiles(ctx, step, c, background.NewProgressSet())
 				require.NoError(err)
@@ -383,7 +386,7 @@ func collateAndMergeHistory(tb testing.TB, db kv.RwDB, h *History, txs uint64) {
 
 	// Leave the last 2 aggregation steps un-collated
 	for step := uint64(0); step < txs/h.aggregationStep-1; step++ {
-		c, err := h.collate(step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx)
+		c, err := h.collate(ctx, step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx)
 		require.NoError(err)
 		sf, err := h.buildFiles(ctx, step, c, background.NewProgressSet())
 		require.NoError(err)
@@ -418,7 +421,10 @@ func collateAndMergeHistory(tb testing.TB, db kv.RwDB, h *History, txs uint64) {
 
 	hc := h.MakeContext()
 	defer hc.Close()
-	err = hc.BuildOptionalMissedIndices(ctx)
+	g, gCtx := errgroup.WithContext(ctx)
+	hc.BuildOptionalMissedIndices(gCtx, g, background.NewProgressSet())
+	require.NoError(g.Wait())
+	err = hc.h.localityIndex.BuildMissedIndices(ctx, hc.ic)
 	require.NoError(err)
 
 	err = tx.Commit()
@@ -833,3 +839,105 @@ func TestScanStaticFilesH(t *testing.T) {
 	require.Equal(t, 0, h.files.Len())
 
 }
+
+func TestHistoryWatchList(t *testing.T) {
+	logger := log.New()
+	ctx := context.Background()
+	keepEven := func(key []byte) bool { return key[7]%2 == 0 }
+	test := func(t *testing.T, h *History, db kv.RwDB, txs uint64) {
+		t.Helper()
+		h.keep = keepEven
+		collateAndMergeHistory(t, db, h, txs)
+
+		hc := h.MakeContext()
+		defer hc.Close()
+		require.NotEmpty(t, hc.files)
+		for _, item := range hc.files {
+			iiItem, ok := hc.ic.getFile(item.startTxNum, item.endTxNum)
+			require.True(t, ok)
+			r, err := checkFilesIntegrity(ctx, iiItem.src, item.src, h.compressVals)
+			require.NoError(t, err)
+			require.Empty(t, r.Errs, r.Files)
+		}
+
+		var k [8]byte
+		k[0] = 1
+		k[7] = 2
+		v, ok, err := hc.GetNoState(k[:], 10)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte{255, 0, 0, 0, 0, 0, 0, 4}, v) // value at txNum 9
+
+		k[7] = 3
+		_, _, err = hc.GetNoState(k[:], 10)
+		require.ErrorIs(t, err, ErrHistoryNotAvailable)
+	}
+	// files built before watch-list was set are filtered by merge
+	testMerge := func(t *testing.T, h *History, db kv.RwDB, txs uint64) {
+		t.Helper()
+		collateAndMergeHistory(t, db, h, txs)
+		h.keep = keepEven
+
+		hc := h.MakeContext()
+		defer hc.Close()
+		require.Greater(t, len(hc.files), 1)
+		var indexFiles, historyFiles []*filesItem
+		for _, item := range hc.files {
+			iiItem, ok := hc.ic.getFile(item.startTxNum, item.endTxNum)
+			require.True(t, ok)
+			indexFiles, historyFiles = append(indexFiles, iiItem.src), append(historyFiles, item.src)
+		}
+		from, to := hc.files[0].startTxNum, hc.files[len(hc.files)-1].endTxNum
+		r := HistoryRanges{historyStartTxNum: from, historyEndTxNum: to, indexStartTxNum: from, indexEndTxNum: to, history: true, index: true}
+		indexIn, historyIn, err := h.mergeFiles(ctx, indexFiles, historyFiles, r, 1, background.NewProgressSet())
+		require.NoError(t, err)
+		defer indexIn.closeFiles()
+		defer historyIn.closeFiles()
+
+		integrity, err := checkFilesIntegrity(ctx, indexIn, historyIn, h.compressVals)
+		require.NoError(t, err)
+		require.Empty(t, integrity.Errs)
+		g := indexIn.decompressor.MakeGetter()
+		for g.HasNext() {
+			key, _ := g.NextUncompressed()
+			require.True(t, keepEven(key), "%x", key)
+			g.SkipUncompressed()
+		}
+	}
+	t.Run("large_values", func(t *testing.T) {
+		_, db, h, txs := filledHistory(t, true, logger)
+		test(t, h, db, txs)
+	})
+	t.Run("small_values", func(t *testing.T) {
+		_, db, h, txs := filledHistory(t, false, logger)
+		test(t, h, db, txs)
+	})
+	t.Run("merge", func(t *testing.T) {
+		_, db, h, txs := filledHistory(t, false, logger)
+		testMerge(t, h, db, txs)
+	})
+}
+
+func TestHistoryCompressedWAL(t *testing.T) {
+	logger := log.New()
+	ctx := context.Background()
+	tableContent := func(db kv.RwDB, table string) (res [][2][]byte) {
+		require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+			return tx.ForEach(table, nil, func(k, v []byte) error {
+				res = append(res, [2][]byte{common.Copy(k), common.Copy(v)})
+				return nil
+			})
+		}))
+		return res
+	}
+	for _, largeValues := range []bool{true, false} {
+		_, db, h, _ := filledHistory(t, largeValues, logger)
+		expect := tableContent(db, h.historyValsTable)
+		require.NotEmpty(t, expect)
+
+		dbg.CompressWAL = true
+		_, db, h, _ = filledHistory(t, largeValues, logger)
+		dbg.CompressWAL = false
+		require.Equal(t, expect, tableContent(db, h.historyValsTable), "largeValues=%t", largeValues)
+	}
+}
@@ -0,0 +1,346 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+// IntegrityReport - result of cross-check of one .ef file (and matching .v file for histories), or of one .kv file
+type IntegrityReport struct {
+	Files  []string
+	Keys   uint64
+	TxNums uint64
+	Errs   []string
+}
+
+const integrityMaxErrsPerFile = 100
+
+func (r *IntegrityReport) errorf(format string, args ...interface{}) {
+	if len(r.Errs) < integrityMaxErrsPerFile {
+		r.Errs = append(r.Errs, fmt.Sprintf(format, args...))
+	}
+}
+
+// IntegrityCheck - cross-check frozen files of steps [fromStep, toStep):
+//   - keys of .ef are sorted and .ef txNums are within file's range
+//   - every value of history .v file has posting in matching .ef file (and vice-versa)
+//   - accessor indices (.efi, .vi) resolve all keys to right offsets
+func (a *AggregatorV3) IntegrityCheck(ctx context.Context, fromStep, toStep uint64, workers int) ([]IntegrityReport, error) {
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	fromTxNum, toTxNum := stepsToTxNums(fromStep, toStep, a.aggregationStep)
+	inRange := func(item ctxItem) bool { return item.startTxNum >= fromTxNum && item.endTxNum <= toTxNum }
+
+	type job struct {
+		iiItem, hItem *filesItem
+		compressVals  bool
+	}
+	var jobs []job
+	var reports []IntegrityReport
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		for _, item := range hc.files {
+			if !inRange(item) {
+				continue
+			}
+			iiItem, ok := hc.ic.getFile(item.startTxNum, item.endTxNum)
+			if !ok {
+				reports = append(reports, IntegrityReport{
+					Files: []string{item.src.decompressor.FileName()},
+					Errs:  []string{"matching .ef file not found"},
+				})
+				continue
+			}
+			jobs = append(jobs, job{iiItem: iiItem.src, hItem: item.src, compressVals: hc.h.compressVals})
+		}
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		for _, item := range ic.files {
+			if inRange(item) {
+				jobs = append(jobs, job{iiItem: item.src})
+			}
+		}
+	}
+
+	jobReports := make([]IntegrityReport, len(jobs))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for i := range jobs {
+		i := i
+		g.Go(func() (err error) {
+			jobReports[i], err = checkFilesIntegrity(ctx, jobs[i].iiItem, jobs[i].hItem, jobs[i].compressVals)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return append(reports, jobReports...), nil
+}
+
+// stepsToTxNums - [fromStep, toStep) in txNums. Huge toStep (for example "till the end") doesn't overflow
+func stepsToTxNums(fromStep, toStep, aggregationStep uint64) (fromTxNum, toTxNum uint64) {
+	if toStep > math.MaxUint64/aggregationStep {
+		return fromStep * aggregationStep, math.MaxUint64
+	}
+	return fromStep * aggregationStep, toStep * aggregationStep
+}
+
+// checkFilesIntegrity - hItem is optional: nil for pure inverted indices
+func checkFilesIntegrity(ctx context.Context, iiItem, hItem *filesItem, compressVals bool) (r IntegrityReport, err error) {
+	r.Files = append(r.Files, iiItem.decompressor.FileName())
+	if iiItem.index == nil {
+		r.errorf("%s: accessor index not found", iiItem.decompressor.FileName())
+	}
+	var efiReader, viReader *recsplit.IndexReader
+	if iiItem.index != nil {
+		efiReader = iiItem.index.GetReaderFromPool()
+		defer efiReader.Close()
+	}
+
+	g := iiItem.decompressor.MakeGetter()
+	var g2 *seg.Getter
+	if hItem != nil {
+		r.Files = append(r.Files, hItem.decompressor.FileName())
+		if err := hItem.decompressor.VerifyChecksums(); err != nil {
+			r.errorf("%s", err)
+			return r, nil
+		}
+		g2 = hItem.decompressor.MakeGetter()
+		if hItem.index == nil {
+			r.errorf("%s: accessor index not found", hItem.decompressor.FileName())
+		} else {
+			viReader = hItem.index.GetReaderFromPool()
+			defer viReader.Close()
+		}
+	}
+
+	var prevKey, historyKey []byte
+	var txKey [8]byte
+	var keyOffset, valOffset uint64
+	for g.HasNext() {
+		if r.Keys%4096 == 0 {
+			select {
+			case <-ctx.Done():
+				return r, ctx.Err()
+			default:
+			}
+		}
+		key, _ := g.NextUncompressed()
+		efBuf, nextOffset := g.NextUncompressed()
+		r.Keys++
+
+		if prevKey != nil && bytes.Compare(prevKey, key) >= 0 {
+			r.errorf("%s: keys are not sorted: %x after %x", iiItem.decompressor.FileName(), key, prevKey)
+		}
+		prevKey = append(prevKey[:0], key...)
+		if efiReader != nil && !efiReader.Empty() {
+			if offset, ok := efiReader.Lookup(key); !ok || offset != keyOffset {
+				r.errorf("%s: accessor index resolved key %x to offset %d, expected %d", iiItem.decompressor.FileName(), key, offset, keyOffset)
+			}
+		}
+		keyOffset = nextOffset
+
+		ef, _ := eliasfano32.ReadEliasFano(efBuf)
+		r.TxNums += ef.Count()
+		if ef.Min() < iiItem.startTxNum || ef.Max() >= iiItem.endTxNum {
+			r.errorf("%s: key %x has txNums [%d, %d] out of file range [%d, %d)", iiItem.decompressor.FileName(), key, ef.Min(), ef.Max(), iiItem.startTxNum, iiItem.endTxNum)
+		}
+		if g2 == nil {
+			continue
+		}
+		for efIt := ef.Iterator(); efIt.HasNext(); {
+			txNum, _ := efIt.Next()
+			if !g2.HasNext() {
+				r.errorf("%s: less values than postings of %s", hItem.decompressor.FileName(), iiItem.decompressor.FileName())
+				g2 = nil
+				break
+			}
+			if viReader != nil && !viReader.Empty() {
+				binary.BigEndian.PutUint64(txKey[:], txNum)
+				historyKey = append(append(historyKey[:0], txKey[:]...), key...)
+				if offset, ok := viReader.Lookup(historyKey); !ok || offset != valOffset {
+					r.errorf("%s: accessor index resolved key %x at txNum %d to offset %d, expected %d", hItem.decompressor.FileName(), key, txNum, offset, valOffset)
+				}
+			}
+			if compressVals {
+				valOffset, _ = g2.Skip()
+			} else {
+				valOffset, _ = g2.SkipUncompressed()
+			}
+		}
+	}
+	if g2 != nil && g2.HasNext() {
+		r.errorf("%s: more values than postings of %s", hItem.decompressor.FileName(), iiItem.decompressor.FileName())
+	}
+	return r, nil
+}
+
+// IntegrityCheck - cross-check frozen files of domains of steps [fromStep, toStep): history and inverted index files
+// same way as AggregatorV3.IntegrityCheck, and every latest value of .kv files against history (see checkLatestValues).
+// Commitment domain is not checked: values of its merged files are references to keys, not values of history.
+// `db` - DB of domains: recent history which is not in files yet.
+func (a *Aggregator) IntegrityCheck(ctx context.Context, fromStep, toStep uint64, workers int, db kv.RoDB) ([]IntegrityReport, error) {
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	fromTxNum, toTxNum := stepsToTxNums(fromStep, toStep, a.aggregationStep)
+	inRange := func(item ctxItem) bool { return item.startTxNum >= fromTxNum && item.endTxNum <= toTxNum }
+
+	var jobs []func() ([]IntegrityReport, error)
+	var reports []IntegrityReport
+	for _, dc := range append([]*DomainContext{ac.accounts, ac.storage, ac.code}, ac.extraDomains...) {
+		d, hc := dc.d, dc.hc
+		for _, item := range hc.files {
+			if !inRange(item) {
+				continue
+			}
+			iiItem, ok := hc.ic.getFile(item.startTxNum, item.endTxNum)
+			if !ok {
+				reports = append(reports, IntegrityReport{
+					Files: []string{item.src.decompressor.FileName()},
+					Errs:  []string{"matching .ef file not found"},
+				})
+				continue
+			}
+			hItem := item.src
+			jobs = append(jobs, func() ([]IntegrityReport, error) {
+				r, err := checkFilesIntegrity(ctx, iiItem.src, hItem, d.compressVals)
+				return []IntegrityReport{r}, err
+			})
+		}
+		var ranges [][2]uint64
+		for _, item := range dc.files {
+			if inRange(item) {
+				ranges = append(ranges, [2]uint64{item.startTxNum, item.endTxNum})
+			}
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+		// .bt indices are shared by all contexts and are not thread-safe: .kv files of domain are checked by one job
+		jobs = append(jobs, func() (reports []IntegrityReport, err error) {
+			dc := d.MakeContext() // getters of context are not thread-safe. Files are held by `ac`
+			defer dc.Close()
+			err = db.View(ctx, func(tx kv.Tx) error {
+				for _, rng := range ranges {
+					i := -1
+					for j, item := range dc.files {
+						if item.startTxNum == rng[0] && item.endTxNum == rng[1] {
+							i = j
+						}
+					}
+					if i < 0 {
+						return fmt.Errorf("%s: file of txNums [%d, %d) is not open", d.filenameBase, rng[0], rng[1])
+					}
+					r, err := dc.checkLatestValues(ctx, i, tx)
+					if err != nil {
+						return err
+					}
+					reports = append(reports, r)
+				}
+				return nil
+			})
+			return reports, err
+		})
+	}
+
+	jobReports := make([][]IntegrityReport, len(jobs))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for i := range jobs {
+		i := i
+		g.Go(func() (err error) {
+			jobReports[i], err = jobs[i]()
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	for _, r := range jobReports {
+		reports = append(reports, r...)
+	}
+	return reports, nil
+}
+
+// checkLatestValues - replays history for every key of i-th .kv file: value of key at end of file's range is value
+// before first change of key after file's range (history of files or of DB). If key wasn't changed since - newer files
+// must not have other value of it.
+func (dc *DomainContext) checkLatestValues(ctx context.Context, i int, roTx kv.Tx) (r IntegrityReport, err error) {
+	item := dc.files[i]
+	fileName := item.src.decompressor.FileName()
+	r.Files = append(r.Files, fileName)
+	codeByHash := hasCodeByHash(item.src)
+
+	g := NewArchiveGetter(dc.statelessGetter(i), dc.d.compressVals)
+	g.Reset(0)
+	var key, word []byte
+	for g.HasNext() {
+		if r.Keys%4096 == 0 {
+			select {
+			case <-ctx.Done():
+				return r, ctx.Err()
+			default:
+			}
+		}
+		key, _ = g.NextKey(key[:0])
+		word, _ = g.NextVal(word[:0])
+		if codeByHash && isCodeHashKey(key) { // entry of code sub-store, not a key of domain
+			continue
+		}
+		if dc.d.tombstonePrefixLen > 0 && len(key) == dc.d.tombstonePrefixLen {
+			continue
+		}
+		r.Keys++
+		v, _, err := dc.fileValue(i, word)
+		if err != nil {
+			return r, err
+		}
+		expected, ok, err := dc.hc.GetNoStateWithRecent(key, item.endTxNum, roTx)
+		if err != nil {
+			return r, err
+		}
+		if ok {
+			r.TxNums++
+			if !bytes.Equal(v, expected) {
+				r.errorf("%s: value of key %x is %x, history has %x at txNum %d", fileName, key, v, expected, item.endTxNum)
+			}
+			continue
+		}
+		latest, _, found, err := dc.readFromFiles(key, item.endTxNum)
+		if err != nil {
+			return r, err
+		}
+		if found && !bytes.Equal(v, latest) {
+			r.errorf("%s: value of key %x is %x, newer file has %x but history has no changes", fileName, key, v, latest)
+		}
+	}
+	return r, nil
+}
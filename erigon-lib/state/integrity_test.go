@@ -0,0 +1,85 @@
+package state
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func TestCheckFilesIntegrity(t *testing.T) {
+	logger := log.New()
+	ctx := context.Background()
+	test := func(t *testing.T, h *History, db kv.RwDB, txs uint64) {
+		t.Helper()
+		collateAndMergeHistory(t, db, h, txs)
+
+		hc := h.MakeContext()
+		defer hc.Close()
+		require.NotEmpty(t, hc.files)
+		for _, item := range hc.files {
+			iiItem, ok := hc.ic.getFile(item.startTxNum, item.endTxNum)
+			require.True(t, ok)
+			r, err := checkFilesIntegrity(ctx, iiItem.src, item.src, h.compressVals)
+			require.NoError(t, err)
+			require.Empty(t, r.Errs, r.Files)
+			require.NotZero(t, r.Keys)
+			require.GreaterOrEqual(t, r.TxNums, r.Keys)
+		}
+
+		// .v file doesn't match .ef of other range
+		if len(hc.files) > 1 {
+			r, err := checkFilesIntegrity(ctx, hc.ic.files[0].src, hc.files[len(hc.files)-1].src, h.compressVals)
+			require.NoError(t, err)
+			require.NotEmpty(t, r.Errs)
+		}
+	}
+	t.Run("large_values", func(t *testing.T) {
+		_, db, h, txs := filledHistory(t, true, logger)
+		test(t, h, db, txs)
+	})
+	t.Run("small_values", func(t *testing.T) {
+		_, db, h, txs := filledHistory(t, false, logger)
+		test(t, h, db, txs)
+	})
+}
+
+func TestCheckLatestValues(t *testing.T) {
+	logger := log.New()
+	ctx := context.Background()
+	_, db, d, txs := filledDomain(t, logger)
+	collateAndMerge(t, db, nil, d, txs)
+
+	dc := d.MakeContext()
+	defer dc.Close()
+	require.NotEmpty(t, dc.files)
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	for i := range dc.files {
+		r, err := dc.checkLatestValues(ctx, i, roTx)
+		require.NoError(t, err)
+		require.Empty(t, r.Errs, r.Files)
+		require.NotZero(t, r.Keys)
+	}
+
+	// values of file don't match history at end of other range
+	item := dc.files[0]
+	dc.files[0].endTxNum += d.aggregationStep
+	r, err := dc.checkLatestValues(ctx, 0, roTx)
+	dc.files[0] = item
+	require.NoError(t, err)
+	require.NotEmpty(t, r.Errs)
+}
+
+func TestStepsToTxNums(t *testing.T) {
+	from, to := stepsToTxNums(1, 3, 16)
+	require.Equal(t, uint64(16), from)
+	require.Equal(t, uint64(48), to)
+	_, to = stepsToTxNums(0, math.MaxUint64/2, 1_562_500)
+	require.Equal(t, uint64(math.MaxUint64), to)
+}
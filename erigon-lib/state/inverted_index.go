@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -39,12 +40,14 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common/background"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/metrics"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 	"github.com/ledgerwatch/erigon-lib/seg"
@@ -57,9 +60,12 @@ type InvertedIndex struct {
 	// MakeContext() using this field in zero-copy way
 	roFiles atomic.Pointer[[]ctxItem]
 
-	indexKeysTable  string // txnNum_u64 -> key (k+auto_increment)
-	indexTable      string // k -> txnNum_u64 , Needs to be table with DupSort
-	dir, tmpdir     string // Directory where static files are created
+	indexKeysTable string // txnNum_u64 -> key (k+auto_increment)
+	indexTable     string // k -> txnNum_u64 , Needs to be table with DupSort
+	dir, tmpdir    string // Directory where static files are created
+	// searchDirs - additional read-only dirs with files (for example shared archive volume), searched after `dir` in order.
+	// New files are created only in `dir`, files of searchDirs are never removed.
+	searchDirs      []string
 	filenameBase    string
 	aggregationStep uint64
 	compressWorkers int
@@ -71,6 +77,27 @@ type InvertedIndex struct {
 
 	garbageFiles []*filesItem // files that exist on disk, but ignored on opening folder - because they are garbage
 
+	quarantined     []QuarantinedFile // see quarantine
+	quarantinedLock sync.Mutex
+	indexRebuild    atomic.Bool // accessor index was quarantined: see AggregatorV3.rebuildQuarantinedInBackground
+	// lockDir - takes writer lock of `dir` (see AggregatorV3.lockDirForWrite). nil for components of legacy Aggregator
+	lockDir func() error
+	retired *retiredFiles // registry of AggregatorV3 (see FilesState). nil for components of legacy Aggregator
+
+	// keep - if set: new files (collation and merge) have postings only of keys for which it returns true
+	// (see AggregatorV3.SetWatchList). Domain files - values only of such keys (see Aggregator.AddStorageContract)
+	keep func(key []byte) bool
+
+	// pruneKeep - if set: DB has postings only of keys for which it returns true, see AggregatorV3.SetPruneIdxKeys
+	pruneKeep func(key []byte) bool
+
+	keepStepsInDB atomic.Uint64 // see AggregatorV3.KeepStepsInDB
+	madv          *MadvConfig   // nil - kernel default, see AggregatorV3.SetMadvConfig
+	metricsLabel  string        // see AggregatorV3.SetMetricsLabel
+	mx            *aggMetrics   // see AggregatorV3.SetMetricsLabel
+	refs          *filesRefs    // debug tracker of references to files, see dbg.TraceFilesRefs
+	readLimiter   *ReadLimiter  // nil - reads are not limited, see AggregatorV3.SetReadLimits
+
 	// fields for history write
 	txNum      uint64
 	txNumBytes [8]byte
@@ -102,6 +129,8 @@ func NewInvertedIndex(
 		integrityFileExtensions: integrityFileExtensions,
 		withLocalityIndex:       withLocalityIndex,
 		logger:                  logger,
+		mx:                      defaultAggMetrics,
+		refs:                    newFilesRefs(dbg.TraceFilesRefs),
 	}
 	ii.roFiles.Store(&[]ctxItem{})
 
@@ -116,20 +145,47 @@ func NewInvertedIndex(
 }
 
 func (ii *InvertedIndex) fileNamesOnDisk() ([]string, error) {
-	files, err := os.ReadDir(ii.dir)
-	if err != nil {
-		return nil, err
-	}
-	filteredFiles := make([]string, 0, len(files))
-	for _, f := range files {
-		if !f.Type().IsRegular() {
-			continue
+	var filteredFiles []string
+	seen := map[string]struct{}{}
+	for _, d := range append([]string{ii.dir}, ii.searchDirs...) {
+		files, err := os.ReadDir(d)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.Type().IsRegular() {
+				continue
+			}
+			if _, ok := seen[f.Name()]; ok { // first dir wins
+				continue
+			}
+			seen[f.Name()] = struct{}{}
+			filteredFiles = append(filteredFiles, f.Name())
 		}
-		filteredFiles = append(filteredFiles, f.Name())
 	}
 	return filteredFiles, nil
 }
 
+func (ii *InvertedIndex) SetSearchDirs(dirs []string) { ii.searchDirs = dirs }
+
+// ownsDir - files of `dir` can be moved and indices built by this process. Otherwise they belong to another process
+// (node, when it's read-only aggregator of rpcdaemon or historyserver): they are only opened as-is.
+func (ii *InvertedIndex) ownsDir() bool { return ii.lockDir == nil || ii.lockDir() == nil }
+
+// filePath - path of file in `dir` or in first of `searchDirs` where it exists. external=true if found in searchDirs
+func (ii *InvertedIndex) filePath(fileName string) (path string, external bool) {
+	path = filepath.Join(ii.dir, fileName)
+	if len(ii.searchDirs) == 0 || dir.FileExist(path) {
+		return path, false
+	}
+	for _, d := range ii.searchDirs {
+		if p := filepath.Join(d, fileName); dir.FileExist(p) {
+			return p, true
+		}
+	}
+	return path, false
+}
+
 func (ii *InvertedIndex) OpenList(fNames []string) error {
 	if err := ii.localityIndex.OpenList(fNames); err != nil {
 		return err
@@ -252,6 +308,7 @@ func ctxFiles(files *btree2.BTreeG[*filesItem]) (roItems []ctxItem) {
 }
 
 func (ii *InvertedIndex) reCalcRoFiles() {
+	ii.madv.apply(ii.files)
 	roFiles := ctxFiles(ii.files)
 	ii.roFiles.Store(&roFiles)
 }
@@ -276,7 +333,7 @@ func (ii *InvertedIndex) buildEfi(ctx context.Context, item *filesItem, p *backg
 	p.Name.Store(&fName)
 	p.Total.Store(uint64(item.decompressor.Count()))
 	//ii.logger.Info("[snapshots] build idx", "file", fName)
-	return buildIndex(ctx, item.decompressor, idxPath, ii.tmpdir, item.decompressor.Count()/2, false, p, ii.logger, ii.noFsync)
+	return buildIndex(ctx, item.decompressor, idxPath, ii.tmpdir, item.decompressor.Count()/2, false, p, ii.logger, ii.noFsync, ii.metricsLabel)
 }
 
 // BuildMissedIndices - produce .efi/.vi/.kvi from .ef/.v/.kv
@@ -293,6 +350,26 @@ func (ii *InvertedIndex) BuildMissedIndices(ctx context.Context, g *errgroup.Gro
 	}
 }
 
+// BuildOptionalMissedIndices - schedule into `g` re-build of accessor indices (.efi) of visible files, which are missing
+// on disk: deleted or quarantined while node works. Returns amount of scheduled builds, built indices are opened by OpenFolder.
+func (ic *InvertedIndexContext) BuildOptionalMissedIndices(ctx context.Context, g *errgroup.Group, ps *background.ProgressSet) (scheduled int) {
+	for _, item := range ic.files {
+		fromStep, toStep := item.startTxNum/ic.ii.aggregationStep, item.endTxNum/ic.ii.aggregationStep
+		if idxPath, _ := ic.ii.filePath(fmt.Sprintf("%s.%d-%d.efi", ic.ii.filenameBase, fromStep, toStep)); dir.FileExist(idxPath) {
+			continue
+		}
+		item := item
+		scheduled++
+		g.Go(func() error {
+			p := &background.Progress{}
+			ps.Add(p)
+			defer ps.Delete(p)
+			return ic.ii.buildEfi(ctx, item.src, p)
+		})
+	}
+	return scheduled
+}
+
 func (ii *InvertedIndex) openFiles() error {
 	var err error
 	var totalKeys uint64
@@ -303,25 +380,44 @@ func (ii *InvertedIndex) openFiles() error {
 				continue
 			}
 			fromStep, toStep := item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep
-			datPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, fromStep, toStep))
+			datPath, external := ii.filePath(fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, fromStep, toStep))
 			if !dir.FileExist(datPath) {
 				invalidFileItems = append(invalidFileItems, item)
 				continue
 			}
 
+			idxPath, idxExternal := ii.filePath(fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))
+			if !dir.FileExist(idxPath) && !ii.ownsDir() {
+				// index is not built yet by owner of dir: file is not readable without it, will be opened by next OpenFolder
+				invalidFileItems = append(invalidFileItems, item)
+				continue
+			}
 			if item.decompressor, err = seg.NewDecompressor(datPath); err != nil {
-				ii.logger.Debug("InvertedIndex.openFiles: %w, %s", err, datPath)
+				invalidFileItems = append(invalidFileItems, item)
+				if !isCorruption(err) {
+					err = fmt.Errorf("%s: %w", datPath, err)
+					return false
+				}
+				ii.quarantine(err, external, datPath)
+				ii.quarantine(err, idxExternal, idxPath) // useless without data file
+				err = nil
 				continue
 			}
+			item.external = external
 
 			if item.index != nil {
 				continue
 			}
-			idxPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))
 			if dir.FileExist(idxPath) {
 				if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
-					ii.logger.Debug("InvertedIndex.openFiles: %w, %s", err, idxPath)
-					return false
+					item.index = nil
+					if !isCorruption(err) {
+						err = fmt.Errorf("%s: %w", idxPath, err)
+						return false
+					}
+					ii.quarantine(err, idxExternal, idxPath)
+					err = nil
+					continue
 				}
 				totalKeys += item.index.KeyCount()
 			}
@@ -397,7 +493,11 @@ func (ii *InvertedIndex) SetTxNum(txNum uint64) {
 }
 
 // Add - !NotThreadSafe. Must use WalRLock/BatchHistoryWriteEnd
+// Key added many times within same txNum is written once.
 func (ii *InvertedIndex) Add(key []byte) error {
+	if ii.wal.addedAtTxNum(key) {
+		return nil
+	}
 	return ii.wal.add(key, key)
 }
 func (ii *InvertedIndex) add(key, indexKey []byte) error { //nolint
@@ -433,6 +533,11 @@ type invertedIndexWAL struct {
 	tmpdir    string
 	buffered  bool
 	discard   bool
+
+	// keys added at txNum `addedTxNum`: same address/topic is met in many logs of one tx,
+	// duplicated (key, txNum) pairs only bloat collectors and then are skipped by collation
+	addedTxNum uint64
+	added      map[string]struct{}
 }
 
 // loadFunc - is analog of etl.Identity, but it signaling to etl - use .Put instead of .AppendDup - to allow duplicates
@@ -497,6 +602,27 @@ func (ii *InvertedIndex) newWriter(tmpdir string, buffered, discard bool) *inver
 	return w
 }
 
+// addedAtTxNum - true if key was already added at current txNum, otherwise remembers key
+func (ii *invertedIndexWAL) addedAtTxNum(key []byte) bool {
+	if ii.discard {
+		return false
+	}
+	if ii.added == nil {
+		ii.added = map[string]struct{}{}
+	}
+	if ii.addedTxNum != ii.ii.txNum {
+		for k := range ii.added {
+			delete(ii.added, k)
+		}
+		ii.addedTxNum = ii.ii.txNum
+	}
+	if _, ok := ii.added[string(key)]; ok {
+		return true
+	}
+	ii.added[string(key)] = struct{}{}
+	return false
+}
+
 func (ii *invertedIndexWAL) add(key, indexKey []byte) error {
 	if ii.discard {
 		return nil
@@ -528,18 +654,17 @@ func (ii *InvertedIndex) MakeContext() *InvertedIndexContext {
 		loc:   ii.localityIndex.MakeContext(),
 	}
 	for _, item := range ic.files {
-		if !item.src.frozen {
-			item.src.refcount.Add(1)
-		}
+		item.src.refcount.Add(1)
 	}
+	ic.refsID = ic.ii.refs.acquire(ic.files)
 	return &ic
 }
 func (ic *InvertedIndexContext) Close() {
+	ic.stats.flush(ic.files)
+	ic.ii.refs.release(ic.refsID, ic.files)
 	for _, item := range ic.files {
-		if item.src.frozen {
-			continue
-		}
 		refCnt := item.src.refcount.Add(-1)
+		ic.ii.refs.checkRefcount(item.src, refCnt)
 		//GC: last reader responsible to remove useles files: close it and delete
 		if refCnt == 0 && item.src.canDelete.Load() {
 			item.src.closeFilesAndRemove()
@@ -558,7 +683,10 @@ type InvertedIndexContext struct {
 	files   []ctxItem // have no garbage (overlaps, etc...)
 	getters []*seg.Getter
 	readers []*recsplit.IndexReader
+	stats   filesAccessStats
 	loc     *ctxLocalityIdx
+	refsID  uint64    // see filesRefs
+	class   ReadClass // see SetReadClass
 }
 
 func (ic *InvertedIndexContext) statelessGetter(i int) *seg.Getter {
@@ -567,23 +695,34 @@ func (ic *InvertedIndexContext) statelessGetter(i int) *seg.Getter {
 	}
 	r := ic.getters[i]
 	if r == nil {
+		ic.ii.refs.checkRead(ic.files[i].src)
 		r = ic.files[i].src.decompressor.MakeGetter()
 		ic.getters[i] = r
 	}
 	return r
 }
 func (ic *InvertedIndexContext) statelessIdxReader(i int) *recsplit.IndexReader {
+	ic.countQuery(i)
 	if ic.readers == nil {
 		ic.readers = make([]*recsplit.IndexReader, len(ic.files))
 	}
 	r := ic.readers[i]
 	if r == nil {
+		ic.ii.refs.checkRead(ic.files[i].src)
 		r = ic.files[i].src.index.GetReaderFromPool()
 		ic.readers[i] = r
 	}
 	return r
 }
 
+func (ic *InvertedIndexContext) countQuery(i int) { ic.stats.at(i, len(ic.files)).queries++ }
+func (ic *InvertedIndexContext) countMiss(i int)  { ic.stats.at(i, len(ic.files)).misses++ }
+func (ic *InvertedIndexContext) countRead(i, size int) {
+	st := ic.stats.at(i, len(ic.files))
+	st.reads++
+	st.bytesRead += uint64(size)
+}
+
 func (ic *InvertedIndexContext) getFile(from, to uint64) (it ctxItem, ok bool) {
 	for _, item := range ic.files {
 		if item.startTxNum == from && item.endTxNum == to {
@@ -598,6 +737,7 @@ func (ic *InvertedIndexContext) getFile(from, to uint64) (it ctxItem, ok bool) {
 // so that iteration can be done even when the inverted index is being updated.
 // [startTxNum; endNumTx)
 func (ic *InvertedIndexContext) IdxRange(key []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (iter.U64, error) {
+	defer ic.ii.readLimiter.acquire(ic.class)()
 	frozenIt, err := ic.iterateRangeFrozen(key, startTxNum, endTxNum, asc, limit)
 	if err != nil {
 		return nil, err
@@ -644,6 +784,14 @@ func (ic *InvertedIndexContext) recentIterateRange(key []byte, startTxNum, endTx
 	}), nil
 }
 
+// checkWatched - files don't have postings of keys out of watch-list: answer for them would be wrong
+func (ic *InvertedIndexContext) checkWatched(key []byte, txNum uint64) error {
+	if ic.ii.keep == nil || ic.ii.keep(key) || len(ic.files) == 0 || txNum >= ic.files[len(ic.files)-1].endTxNum {
+		return nil
+	}
+	return fmt.Errorf("%w: %s key %x is not in watch-list", ErrHistoryNotAvailable, ic.ii.filenameBase, key)
+}
+
 // IdxRange is to be used in public API, therefore it relies on read-only transaction
 // so that iteration can be done even when the inverted index is being updated.
 // [startTxNum; endNumTx)
@@ -654,6 +802,13 @@ func (ic *InvertedIndexContext) iterateRangeFrozen(key []byte, startTxNum, endTx
 	if !asc && (startTxNum >= 0 && endTxNum >= 0) && startTxNum < endTxNum {
 		return nil, fmt.Errorf("startTxNum=%d epected to be bigger than endTxNum=%d", startTxNum, endTxNum)
 	}
+	lowerTxNum := startTxNum
+	if !asc {
+		lowerTxNum = endTxNum
+	}
+	if err := ic.checkWatched(key, uint64(cmp.Max(lowerTxNum, 0))); err != nil {
+		return nil, err
+	}
 
 	it := &FrozenInvertedIdxIter{
 		key:         key,
@@ -673,6 +828,7 @@ func (ic *InvertedIndexContext) iterateRangeFrozen(key []byte, startTxNum, endTx
 			if startTxNum >= 0 && ic.files[i].endTxNum <= uint64(startTxNum) {
 				break
 			}
+			ic.countQuery(i)
 			it.stack = append(it.stack, ic.files[i])
 			it.stack[len(it.stack)-1].getter = it.stack[len(it.stack)-1].src.decompressor.MakeGetter()
 			it.stack[len(it.stack)-1].reader = it.stack[len(it.stack)-1].src.index.GetReaderFromPool()
@@ -688,6 +844,7 @@ func (ic *InvertedIndexContext) iterateRangeFrozen(key []byte, startTxNum, endTx
 				break
 			}
 
+			ic.countQuery(i)
 			it.stack = append(it.stack, ic.files[i])
 			it.stack[len(it.stack)-1].getter = it.stack[len(it.stack)-1].src.decompressor.MakeGetter()
 			it.stack[len(it.stack)-1].reader = it.stack[len(it.stack)-1].src.index.GetReaderFromPool()
@@ -1005,10 +1162,8 @@ func (it *InvertedIterator1) advanceInFiles() {
 			heap.Push(&it.h, top)
 		}
 		if !bytes.Equal(key, it.key) {
-			ef, _ := eliasfano32.ReadEliasFano(val)
-			min := ef.Get(0)
-			max := ef.Max()
-			if min < it.endTxNum && max >= it.startTxNum { // Intersection of [min; max) and [it.startTxNum; it.endTxNum)
+			// key has txNum in [it.startTxNum; it.endTxNum)
+			if n, ok := eliasfano32.Seek(val, it.startTxNum); ok && n < it.endTxNum {
 				it.key = key
 				it.nextFileKey = key
 				return
@@ -1124,6 +1279,8 @@ func (ic *InvertedIndexContext) IterateChangedKeys(startTxNum, endTxNum uint64,
 }
 
 func (ii *InvertedIndex) collate(ctx context.Context, txFrom, txTo uint64, roTx kv.Tx) (map[string]*roaring64.Bitmap, error) {
+	ctx, span := startSpan(ctx, "collate", ii.filenameBase, txFrom, txTo, ii.aggregationStep)
+	defer span.End()
 	keysCursor, err := roTx.CursorDupSort(ii.indexKeysTable)
 	if err != nil {
 		return nil, fmt.Errorf("create %s keys cursor: %w", ii.filenameBase, err)
@@ -1138,6 +1295,9 @@ func (ii *InvertedIndex) collate(ctx context.Context, txFrom, txTo uint64, roTx
 		if txNum >= txTo {
 			break
 		}
+		if ii.keep != nil && !ii.keep(v) {
+			continue
+		}
 		var bitmap *roaring64.Bitmap
 		var ok bool
 		if bitmap, ok = indexBitmaps[string(v)]; !ok {
@@ -1173,6 +1333,10 @@ func (sf InvertedFiles) Close() {
 }
 
 func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps map[string]*roaring64.Bitmap, ps *background.ProgressSet) (InvertedFiles, error) {
+	ctx, span := startSpan(ctx, "build_files", ii.filenameBase, step*ii.aggregationStep, (step+1)*ii.aggregationStep, ii.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "build_files", ii.filenameBase, step*ii.aggregationStep, (step+1)*ii.aggregationStep, ii.aggregationStep)
+	defer restoreLabels()
 	var decomp *seg.Decompressor
 	var index *recsplit.Index
 	var comp *seg.Compressor
@@ -1194,6 +1358,7 @@ func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps ma
 	txNumFrom := step * ii.aggregationStep
 	txNumTo := (step + 1) * ii.aggregationStep
 	datFileName := fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, txNumFrom/ii.aggregationStep, txNumTo/ii.aggregationStep)
+	defer fileTook(mxBuildFileTook, datFileName, ii.metricsLabel).PutSince()
 	datPath := filepath.Join(ii.dir, datFileName)
 	keys := make([]string, 0, len(bitmaps))
 	for key := range bitmaps {
@@ -1239,7 +1404,7 @@ func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps ma
 	idxPath := filepath.Join(ii.dir, idxFileName)
 	p := ps.AddNew(idxFileName, uint64(decomp.Count()*2))
 	defer ps.Delete(p)
-	if index, err = buildIndexThenOpen(ctx, decomp, idxPath, ii.tmpdir, len(keys), false /* values */, p, ii.logger, ii.noFsync); err != nil {
+	if index, err = buildIndexThenOpen(ctx, decomp, idxPath, ii.tmpdir, len(keys), false /* values */, p, ii.logger, ii.noFsync, ii.metricsLabel); err != nil {
 		return InvertedFiles{}, fmt.Errorf("build %s efi: %w", ii.filenameBase, err)
 	}
 	closeComp = false
@@ -1302,6 +1467,10 @@ func (ii *InvertedIndex) warmup(ctx context.Context, txFrom, limit uint64, tx kv
 
 // [txFrom; txTo)
 func (ii *InvertedIndex) prune(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error {
+	ctx, span := startSpan(ctx, "prune", ii.filenameBase, txFrom, txTo, ii.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "prune", ii.filenameBase, txFrom, txTo, ii.aggregationStep)
+	defer restoreLabels()
 	keysCursor, err := ii.tx.RwCursorDupSort(ii.indexKeysTable)
 	if err != nil {
 		return fmt.Errorf("create %s keys cursor: %w", ii.filenameBase, err)
@@ -1402,9 +1571,76 @@ func (ii *InvertedIndex) prune(ctx context.Context, txFrom, txTo, limit uint64,
 	return nil
 }
 
+// pruneKeys - deletes from DB postings in [txFrom, txTo) of keys for which `keep` returns false.
+// Unlike prune - doesn't depend on files: postings of kept keys stay in DB.
+func (ii *InvertedIndex) pruneKeys(ctx context.Context, txFrom, txTo uint64, keep func(key []byte) bool, logEvery *time.Ticker) (pruned uint64, err error) {
+	keysCursorForDeletes, err := ii.tx.RwCursorDupSort(ii.indexKeysTable)
+	if err != nil {
+		return 0, fmt.Errorf("create %s keys cursor: %w", ii.filenameBase, err)
+	}
+	defer keysCursorForDeletes.Close()
+	keysCursor, err := ii.tx.RwCursorDupSort(ii.indexKeysTable)
+	if err != nil {
+		return 0, fmt.Errorf("create %s keys cursor: %w", ii.filenameBase, err)
+	}
+	defer keysCursor.Close()
+	idxCForDeletes, err := ii.tx.RwCursorDupSort(ii.indexTable)
+	if err != nil {
+		return 0, err
+	}
+	defer idxCForDeletes.Close()
+
+	var txKey [8]byte
+	binary.BigEndian.PutUint64(txKey[:], txFrom)
+	var k, v []byte
+	for k, v, err = keysCursor.Seek(txKey[:]); err == nil && k != nil; k, v, err = keysCursor.Next() {
+		txNum := binary.BigEndian.Uint64(k)
+		if txNum >= txTo {
+			break
+		}
+		if keep(v) {
+			continue
+		}
+
+		idxV, err := idxCForDeletes.SeekBothRange(v, k)
+		if err != nil {
+			return pruned, err
+		}
+		if bytes.Equal(idxV, k) {
+			if err = idxCForDeletes.DeleteCurrent(); err != nil {
+				return pruned, err
+			}
+		}
+		// This DeleteCurrent needs to the last in the loop iteration, because it invalidates k and v
+		if _, _, err = keysCursorForDeletes.SeekBothExact(k, v); err != nil {
+			return pruned, err
+		}
+		if err = keysCursorForDeletes.DeleteCurrent(); err != nil {
+			return pruned, err
+		}
+		pruned++
+
+		select {
+		case <-ctx.Done():
+			return pruned, ctx.Err()
+		case <-logEvery.C:
+			ii.logger.Info("[snapshots] prune keys", "name", ii.filenameBase, "txNum", txNum, "pruned", pruned)
+		default:
+		}
+	}
+	if err != nil {
+		return pruned, fmt.Errorf("iterate over %s keys: %w", ii.filenameBase, err)
+	}
+	return pruned, nil
+}
+
 func (ii *InvertedIndex) DisableReadAhead() {
 	ii.files.Walk(func(items []*filesItem) bool {
 		for _, item := range items {
+			if ii.madv != nil {
+				ii.madv.atRest(item)
+				continue
+			}
 			item.decompressor.DisableReadAhead()
 			if item.index != nil {
 				item.index.DisableReadAhead()
@@ -1484,3 +1720,30 @@ func (ii *InvertedIndex) stepsRangeInDB(tx kv.Tx) (from, to float64) {
 	}
 	return from, to
 }
+
+// lag - pruneLagSteps: steps in DB which are in files already (not pruned yet), filesLagSteps: steps in DB which
+// are not in files yet, filesLagTxs: distance between `headTxNum` and end of files
+func (ii *InvertedIndex) lag(tx kv.Tx, headTxNum uint64) (pruneLagSteps, filesLagSteps float64, filesLagTxs uint64) {
+	from, to := ii.stepsRangeInDB(tx)
+	endTxNum := ii.endTxNumMinimax()
+	frozen := float64(endTxNum) / float64(ii.aggregationStep)
+	if to > 0 {
+		pruneLagSteps = cmp.Max(cmp.Min(frozen, to)-from, 0)
+		filesLagSteps = cmp.Max(to-cmp.Max(frozen, from), 0)
+	}
+	if headTxNum > endTxNum {
+		filesLagTxs = headTxNum - endTxNum
+	}
+	return pruneLagSteps, filesLagSteps, filesLagTxs
+}
+
+func (ii *InvertedIndex) updateLagMetrics(tx kv.Tx, headTxNum uint64) {
+	pruneLagSteps, filesLagSteps, filesLagTxs := ii.lag(tx, headTxNum)
+	labels := fmt.Sprintf(`domain="%s"`, ii.filenameBase)
+	if ii.metricsLabel != "" {
+		labels += fmt.Sprintf(`,instance="%s"`, ii.metricsLabel)
+	}
+	metrics.GetOrCreateGauge(`domain_prune_lag_steps{` + labels + `}`).Set(pruneLagSteps)
+	metrics.GetOrCreateGauge(`domain_files_lag_steps{` + labels + `}`).Set(filesLagSteps)
+	metrics.GetOrCreateGauge(`domain_files_lag_txs{` + labels + `}`).SetUint64(filesLagTxs)
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/require"
 	btree2 "github.com/tidwall/btree"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
@@ -133,6 +135,79 @@ func TestInvIndexCollationBuild(t *testing.T) {
 	}
 }
 
+func TestInvIndexAddDedup(t *testing.T) {
+	logger := log.New()
+	_, db, ii := testDbAndInvertedIndex(t, 16, logger)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	ii.SetTx(tx)
+	ii.StartWrites()
+	defer ii.FinishWrites()
+
+	ii.SetTxNum(2)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, ii.Add([]byte("key1")))
+		require.NoError(t, ii.Add([]byte("key2")))
+	}
+	require.Len(t, ii.wal.added, 2)
+
+	// same key at next txNum is not a duplicate
+	ii.SetTxNum(3)
+	require.NoError(t, ii.Add([]byte("key1")))
+	require.Len(t, ii.wal.added, 1)
+
+	require.NoError(t, ii.Rotate().Flush(ctx, tx))
+	bs, err := ii.collate(ctx, 0, 16, tx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 3}, bs["key1"].ToArray())
+	require.Equal(t, []uint64{2}, bs["key2"].ToArray())
+}
+
+func TestInvIndexPruneKeys(t *testing.T) {
+	logger := log.New()
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, ii, txs := filledInvIndex(t, logger)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	ii.SetTx(tx)
+
+	keepEven := func(key []byte) bool { return binary.BigEndian.Uint64(key)%2 == 0 }
+	pruned, err := ii.pruneKeys(ctx, 0, txs/2, keepEven, logEvery)
+	require.NoError(t, err)
+	require.NotZero(t, pruned)
+
+	bs, err := ii.collate(ctx, 0, txs+1, tx)
+	require.NoError(t, err)
+	for keyNum := uint64(1); keyNum <= 31; keyNum++ {
+		var k [8]byte
+		binary.BigEndian.PutUint64(k[:], keyNum)
+		bm := bs[string(k[:])]
+		require.NotNil(t, bm, keyNum)
+		if keyNum%2 == 0 {
+			require.Equal(t, keyNum, bm.Minimum(), keyNum)
+			continue
+		}
+		require.GreaterOrEqual(t, bm.Minimum(), txs/2, keyNum)
+	}
+	ic := ii.MakeContext()
+	defer ic.Close()
+	var k1 [8]byte
+	binary.BigEndian.PutUint64(k1[:], 1)
+	it, err := ic.IdxRange(k1[:], 0, int(txs/2), order.Asc, -1, tx)
+	require.NoError(t, err)
+	require.False(t, it.HasNext())
+
+	// nothing left to prune in range
+	pruned, err = ii.pruneKeys(ctx, 0, txs/2, keepEven, logEvery)
+	require.NoError(t, err)
+	require.Zero(t, pruned)
+}
+
 func TestInvIndexAfterPrune(t *testing.T) {
 	logger := log.New()
 	logEvery := time.NewTicker(30 * time.Second)
@@ -412,6 +487,40 @@ func TestInvIndexRanges(t *testing.T) {
 	checkRanges(t, db, ii, txs)
 }
 
+func TestInvIndexLag(t *testing.T) {
+	logger := log.New()
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, ii, txs := filledInvIndex(t, logger)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	ii.SetTx(tx)
+
+	pruneLag, filesLag, filesLagTxs := ii.lag(tx, txs)
+	require.Zero(t, pruneLag)
+	require.InDelta(t, float64(txs-1)/float64(ii.aggregationStep), filesLag, 0.001)
+	require.Equal(t, txs, filesLagTxs)
+
+	for step := uint64(0); step < 3; step++ {
+		bs, err := ii.collate(ctx, step*ii.aggregationStep, (step+1)*ii.aggregationStep, tx)
+		require.NoError(t, err)
+		sf, err := ii.buildFiles(ctx, step, bs, background.NewProgressSet())
+		require.NoError(t, err)
+		ii.integrateFiles(sf, step*ii.aggregationStep, (step+1)*ii.aggregationStep)
+	}
+	frozen := 3 * ii.aggregationStep
+	pruneLag, filesLag, filesLagTxs = ii.lag(tx, txs)
+	require.InDelta(t, float64(frozen-1)/float64(ii.aggregationStep), pruneLag, 0.001)
+	require.InDelta(t, float64(txs-frozen)/float64(ii.aggregationStep), filesLag, 0.001)
+	require.Equal(t, txs-frozen, filesLagTxs)
+
+	require.NoError(t, ii.prune(ctx, 0, frozen, math.MaxUint64, logEvery))
+	pruneLag, _, _ = ii.lag(tx, txs)
+	require.Zero(t, pruneLag)
+}
+
 func TestInvIndexMerge(t *testing.T) {
 	logger := log.New()
 	_, db, ii, txs := filledInvIndex(t, logger)
@@ -420,6 +529,32 @@ func TestInvIndexMerge(t *testing.T) {
 	checkRanges(t, db, ii, txs)
 }
 
+func TestInvIndexBuildOptionalMissedIndices(t *testing.T) {
+	logger := log.New()
+	_, db, ii, txs := filledInvIndex(t, logger)
+	mergeInverted(t, db, ii, txs)
+
+	ic := ii.MakeContext()
+	defer ic.Close()
+	require.NotEmpty(t, ic.files)
+	item := ic.files[0]
+	fromStep, toStep := item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep
+	efiPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))
+	require.NoError(t, os.Remove(efiPath))
+
+	g, ctx := errgroup.WithContext(context.Background())
+	scheduled := ic.BuildOptionalMissedIndices(ctx, g, background.NewProgressSet())
+	require.NoError(t, g.Wait())
+	require.Equal(t, 1, scheduled)
+	_, err := os.Stat(efiPath)
+	require.NoError(t, err)
+
+	// nothing is missing anymore
+	g, ctx = errgroup.WithContext(context.Background())
+	require.Zero(t, ic.BuildOptionalMissedIndices(ctx, g, background.NewProgressSet()))
+	require.NoError(t, g.Wait())
+}
+
 func TestInvIndexScanFiles(t *testing.T) {
 	logger := log.New()
 	path, db, ii, txs := filledInvIndex(t, logger)
@@ -494,6 +629,21 @@ func TestChangedKeysIterator(t *testing.T) {
 		"000000000000000c",
 		"000000000000001b",
 	}, keys)
+
+	// in files: only keys which have txNum inside of range, not only around it
+	it = ic.IterateChangedKeys(401, 403, roTx)
+	keys = keys[:0]
+	for it.HasNext() {
+		k := it.Next(nil)
+		keys = append(keys, fmt.Sprintf("%x", k))
+	}
+	it.Close()
+	require.Equal(t, []string{
+		"0000000000000001",
+		"0000000000000002",
+		"0000000000000003",
+		"0000000000000006",
+	}, keys)
 }
 
 func TestScanStaticFiles(t *testing.T) {
@@ -564,3 +714,58 @@ func TestCtxFiles(t *testing.T) {
 	require.Equal(t, 480, int(roFiles[2].startTxNum))
 	require.Equal(t, 512, int(roFiles[2].endTxNum))
 }
+
+func TestFilesAccessStatsFlush(t *testing.T) {
+	files := []ctxItem{{src: &filesItem{}}, {src: &filesItem{}}}
+	var stats filesAccessStats
+	stats.flush(files) // not allocated
+	stats.at(1, len(files)).queries += 2
+	stats.at(1, len(files)).misses++
+	st := stats.at(0, len(files))
+	st.reads, st.bytesRead = 1, 10
+	stats.flush(files)
+
+	require.Equal(t, uint64(0), files[0].src.queries.Load())
+	require.Equal(t, uint64(1), files[0].src.reads.Load())
+	require.Equal(t, uint64(10), files[0].src.bytesRead.Load())
+	require.Equal(t, uint64(2), files[1].src.queries.Load())
+	require.Equal(t, uint64(1), files[1].src.misses.Load())
+}
+
+func TestInvIndexSearchDirs(t *testing.T) {
+	logger := log.New()
+	_, db, ii, txs := filledInvIndex(t, logger)
+	mergeInverted(t, db, ii, txs)
+	filesBefore := ii.Files()
+	require.NotEmpty(t, filesBefore)
+	ii.Close()
+
+	// move all files to read-only archive dir
+	archive := t.TempDir()
+	entries, err := os.ReadDir(ii.dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.NoError(t, os.Rename(filepath.Join(ii.dir, e.Name()), filepath.Join(archive, e.Name())))
+	}
+
+	ii.SetSearchDirs([]string{archive})
+	require.NoError(t, ii.OpenFolder())
+	defer ii.Close()
+	require.Equal(t, filesBefore, ii.Files())
+	ic := ii.MakeContext()
+	var notFrozen *filesItem
+	for _, item := range ic.files {
+		require.True(t, item.src.external)
+		require.Equal(t, archive, filepath.Dir(item.src.decompressor.FilePath()))
+		if !item.src.frozen {
+			notFrozen = item.src
+		}
+	}
+	ic.Close()
+
+	// files of search dirs are never removed
+	require.NotNil(t, notFrozen)
+	path := notFrozen.decompressor.FilePath()
+	notFrozen.closeFilesAndRemove()
+	require.FileExists(t, path)
+}
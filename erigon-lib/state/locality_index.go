@@ -380,7 +380,7 @@ func (li *LocalityIndex) buildFiles(ctx context.Context, ic *InvertedIndexContex
 
 func (li *LocalityIndex) integrateFiles(sf LocalityIndexFiles, txNumFrom, txNumTo uint64) {
 	if li.file != nil {
-		li.file.canDelete.Store(true)
+		li.file.markCanDelete(nil, "replaced by new locality index", nil)
 	}
 	li.file = &filesItem{
 		startTxNum: txNumFrom,
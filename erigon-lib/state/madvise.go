@@ -0,0 +1,175 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	btree2 "github.com/tidwall/btree"
+
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+type MadvPolicy uint8
+
+const (
+	MadvNormal MadvPolicy = iota
+	MadvRandom
+	MadvSequential
+	MadvWillNeed
+)
+
+func (p MadvPolicy) String() string {
+	switch p {
+	case MadvNormal:
+		return "normal"
+	case MadvRandom:
+		return "random"
+	case MadvSequential:
+		return "sequential"
+	case MadvWillNeed:
+		return "willneed"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(p))
+	}
+}
+
+func ParseMadvPolicy(s string) (MadvPolicy, error) {
+	for _, p := range []MadvPolicy{MadvNormal, MadvRandom, MadvSequential, MadvWillNeed} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown madvise policy %q, expected: normal|random|sequential|willneed", s)
+}
+
+func (p MadvPolicy) applyDecompressor(d *seg.Decompressor) {
+	switch p {
+	case MadvNormal:
+		d.EnableMadvNormal()
+	case MadvRandom:
+		d.DisableReadAhead()
+	case MadvSequential:
+		d.EnableReadAhead()
+	case MadvWillNeed:
+		d.EnableMadvWillNeed()
+	}
+}
+
+func (p MadvPolicy) applyIndex(idx *recsplit.Index) {
+	switch p {
+	case MadvNormal:
+		idx.EnableMadvNormal()
+	case MadvRandom:
+		idx.DisableReadAhead()
+	case MadvSequential:
+		idx.EnableReadAhead()
+	case MadvWillNeed:
+		idx.EnableWillNeed()
+	}
+}
+
+// MadvConfig - madvise of state files: per file type (extension) while files are served, and separate one for files
+// which are read by merge or by index build (they are read once, sequentially).
+type MadvConfig struct {
+	ByExt map[string]MadvPolicy // "ef", "efi", "v", "vi", "kv". Extensions which are not in map - kernel default
+	Merge MadvPolicy
+}
+
+// DefaultMadvConfig - lookups in served files are random: readahead only pollutes page cache by neighbour pages.
+// Merge reads whole files: readahead makes it few times faster on archive node.
+func DefaultMadvConfig() *MadvConfig {
+	return &MadvConfig{
+		ByExt: map[string]MadvPolicy{"ef": MadvRandom, "efi": MadvRandom, "v": MadvRandom, "vi": MadvRandom, "kv": MadvRandom},
+		Merge: MadvSequential,
+	}
+}
+
+// ParseMadvConfig - overrides of DefaultMadvConfig, format: "<ext>:<policy>,...,merge:<policy>". Example: "efi:willneed,merge:normal"
+func ParseMadvConfig(s string) (*MadvConfig, error) {
+	cfg := DefaultMadvConfig()
+	if s == "" {
+		return cfg, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		name, policyStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("madvise config: expected <ext>:<policy>, got %q", part)
+		}
+		policy, err := ParseMadvPolicy(policyStr)
+		if err != nil {
+			return nil, fmt.Errorf("madvise config: %w", err)
+		}
+		if name == "merge" {
+			cfg.Merge = policy
+			continue
+		}
+		cfg.ByExt[strings.TrimPrefix(name, ".")] = policy
+	}
+	return cfg, nil
+}
+
+// apply - sets policy of served files to files which were not yet seen. nil config - nothing to do (kernel default).
+func (c *MadvConfig) apply(files *btree2.BTreeG[*filesItem]) {
+	if c == nil {
+		return
+	}
+	files.Walk(func(items []*filesItem) bool {
+		for _, item := range items {
+			if item.madvApplied.Load() {
+				continue
+			}
+			c.atRest(item)
+			item.madvApplied.Store(true)
+		}
+		return true
+	})
+}
+
+func (c *MadvConfig) atRest(item *filesItem) {
+	if item.decompressor != nil {
+		if p, ok := c.ByExt[fileExt(item.decompressor.FileName())]; ok {
+			p.applyDecompressor(item.decompressor)
+		}
+	}
+	if item.index != nil {
+		if p, ok := c.ByExt[fileExt(item.index.FileName())]; ok {
+			p.applyIndex(item.index)
+		}
+	}
+}
+
+// forMerge - usage: `defer c.forMerge(item)()`. nil config - normal while merge, random after.
+func (c *MadvConfig) forMerge(item *filesItem) (restore func()) {
+	if c == nil {
+		item.decompressor.EnableMadvNormal()
+		return func() { item.decompressor.DisableReadAhead() }
+	}
+	c.Merge.applyDecompressor(item.decompressor)
+	return func() {
+		if p, ok := c.ByExt[fileExt(item.decompressor.FileName())]; ok {
+			p.applyDecompressor(item.decompressor)
+			return
+		}
+		MadvNormal.applyDecompressor(item.decompressor)
+	}
+}
+
+func fileExt(fileName string) string { return strings.TrimPrefix(filepath.Ext(fileName), ".") }
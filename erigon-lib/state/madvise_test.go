@@ -0,0 +1,25 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMadvConfig(t *testing.T) {
+	cfg, err := ParseMadvConfig("")
+	require.NoError(t, err)
+	require.Equal(t, DefaultMadvConfig(), cfg)
+
+	cfg, err = ParseMadvConfig("efi:willneed, .kvi:random,merge:normal")
+	require.NoError(t, err)
+	require.Equal(t, MadvWillNeed, cfg.ByExt["efi"])
+	require.Equal(t, MadvRandom, cfg.ByExt["kvi"])
+	require.Equal(t, MadvRandom, cfg.ByExt["ef"])
+	require.Equal(t, MadvNormal, cfg.Merge)
+
+	_, err = ParseMadvConfig("efi")
+	require.Error(t, err)
+	_, err = ParseMadvConfig("efi:fast")
+	require.Error(t, err)
+}
@@ -24,14 +24,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/ledgerwatch/log/v3"
+	btree2 "github.com/tidwall/btree"
 
 	"github.com/ledgerwatch/erigon-lib/common/background"
 
-	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/mmap"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 	"github.com/ledgerwatch/erigon-lib/seg"
@@ -144,6 +148,7 @@ func (d *Domain) findMergeRange(maxEndTxNum, maxSpan uint64) DomainRanges {
 		indexEndTxNum:     hr.indexEndTxNum,
 		index:             hr.index,
 	}
+	filesStart := filesStartTxNum(d.files)
 	d.files.Walk(func(items []*filesItem) bool {
 		for _, item := range items {
 			if item.endTxNum > maxEndTxNum {
@@ -153,6 +158,9 @@ func (d *Domain) findMergeRange(maxEndTxNum, maxSpan uint64) DomainRanges {
 			spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
 			span := cmp.Min(spanStep*d.aggregationStep, maxSpan)
 			start := item.endTxNum - span
+			if start < filesStart {
+				continue
+			}
 			if start < item.startTxNum {
 				if !r.values || start < r.valuesStartTxNum {
 					r.values = true
@@ -176,6 +184,7 @@ func (d *Domain) findMergeRange(maxEndTxNum, maxSpan uint64) DomainRanges {
 func (ii *InvertedIndex) findMergeRange(maxEndTxNum, maxSpan uint64) (bool, uint64, uint64) {
 	var minFound bool
 	var startTxNum, endTxNum uint64
+	filesStart := filesStartTxNum(ii.files)
 	ii.files.Walk(func(items []*filesItem) bool {
 		for _, item := range items {
 			if item.endTxNum > maxEndTxNum {
@@ -185,6 +194,9 @@ func (ii *InvertedIndex) findMergeRange(maxEndTxNum, maxSpan uint64) (bool, uint
 			spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
 			span := cmp.Min(spanStep*ii.aggregationStep, maxSpan)
 			start := item.endTxNum - span
+			if start < filesStart {
+				continue
+			}
 			foundSuperSet := startTxNum == item.startTxNum && item.endTxNum >= endTxNum
 			if foundSuperSet {
 				minFound = false
@@ -203,6 +215,49 @@ func (ii *InvertedIndex) findMergeRange(maxEndTxNum, maxSpan uint64) (bool, uint
 	return minFound, startTxNum, endTxNum
 }
 
+// filesStartTxNum - startTxNum of oldest file after which files have no gaps. Older files may be not downloaded
+// (partial snapshot sync) or quarantined, merge must not produce file which covers such missing range.
+func filesStartTxNum(files *btree2.BTreeG[*filesItem]) (start uint64) {
+	var end uint64
+	first := true
+	files.Walk(func(items []*filesItem) bool {
+		for _, item := range items { // ordered by endTxNum
+			switch {
+			case first:
+				start, first = item.startTxNum, false
+			case item.startTxNum > end: // gap
+				start = item.startTxNum
+			case item.startTxNum < start: // super-set of files after gap
+				start = item.startTxNum
+			}
+			end = cmp.Max(end, item.endTxNum)
+		}
+		return true
+	})
+	return start
+}
+
+// ctxFilesStartTxNum - same as filesStartTxNum, but for visible files (they don't overlap)
+func ctxFilesStartTxNum(files []ctxItem) uint64 {
+	if len(files) == 0 {
+		return 0
+	}
+	start := files[0].startTxNum
+	for i := 1; i < len(files); i++ {
+		if files[i].startTxNum > files[i-1].endTxNum {
+			start = files[i].startTxNum
+		}
+	}
+	return start
+}
+
+func ctxFilesEndTxNum(files []ctxItem) uint64 {
+	if len(files) == 0 {
+		return 0
+	}
+	return files[len(files)-1].endTxNum
+}
+
 func (ii *InvertedIndex) mergeRangesUpTo(ctx context.Context, maxTxNum, maxSpan uint64, workers int, ictx *InvertedIndexContext, ps *background.ProgressSet) (err error) {
 	closeAll := true
 	for updated, startTx, endTx := ii.findMergeRange(maxSpan, maxTxNum); updated; updated, startTx, endTx = ii.findMergeRange(maxTxNum, maxSpan) {
@@ -262,6 +317,7 @@ func (r HistoryRanges) any() bool {
 func (h *History) findMergeRange(maxEndTxNum, maxSpan uint64) HistoryRanges {
 	var r HistoryRanges
 	r.index, r.indexStartTxNum, r.indexEndTxNum = h.InvertedIndex.findMergeRange(maxEndTxNum, maxSpan)
+	filesStart := filesStartTxNum(h.files)
 	h.files.Walk(func(items []*filesItem) bool {
 		for _, item := range items {
 			if item.endTxNum > maxEndTxNum {
@@ -271,6 +327,9 @@ func (h *History) findMergeRange(maxEndTxNum, maxSpan uint64) HistoryRanges {
 			spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
 			span := cmp.Min(spanStep*h.aggregationStep, maxSpan)
 			start := item.endTxNum - span
+			if start < filesStart {
+				continue
+			}
 			foundSuperSet := r.indexStartTxNum == item.startTxNum && item.endTxNum >= r.historyEndTxNum
 			if foundSuperSet {
 				r.history = false
@@ -421,7 +480,7 @@ func (hc *HistoryContext) staticFilesInRange(r HistoryRanges) (indexFiles, histo
 					sHist = append(sHist, fmt.Sprintf("%+v", fName))
 				}
 			}
-			log.Warn("[snapshots] something wrong with files for merge", "idx", strings.Join(sIdx, ","), "hist", strings.Join(sHist, ","))
+			hc.h.logger.Warn("[snapshots] something wrong with files for merge", "idx", strings.Join(sIdx, ","), "hist", strings.Join(sHist, ","))
 		}
 	}
 	return
@@ -432,31 +491,35 @@ func (h *History) staticFilesInRange(r HistoryRanges, hc *HistoryContext) (index
 	panic("deprecated: use HistoryContext.staticFilesInRange")
 }
 
-func mergeEfs(preval, val, buf []byte) ([]byte, error) {
-	preef, _ := eliasfano32.ReadEliasFano(preval)
-	ef, _ := eliasfano32.ReadEliasFano(val)
-	preIt := preef.Iterator()
-	efIt := ef.Iterator()
-	newEf := eliasfano32.NewEliasFano(preef.Count()+ef.Count(), ef.Max())
-	for preIt.HasNext() {
-		v, err := preIt.Next()
-		if err != nil {
-			return nil, err
-		}
-		newEf.AddOffset(v)
-	}
-	for efIt.HasNext() {
-		v, err := efIt.Next()
-		if err != nil {
-			return nil, err
+// efMerger - merges serialized EliasFano sequences. Decoder and iterator are re-used for every key of merge
+type efMerger struct {
+	ef eliasfano32.EliasFano
+	it eliasfano32.EliasFanoIter
+}
+
+// merge - `preval` must have smaller values than `val`
+func (m *efMerger) merge(preval, val, buf []byte) ([]byte, error) {
+	newEf := eliasfano32.NewEliasFano(eliasfano32.Count(preval)+eliasfano32.Count(val), eliasfano32.Max(val))
+	for _, v := range [2][]byte{preval, val} {
+		m.ef.Reset(v)
+		m.it.ResetTo(&m.ef)
+		for m.it.HasNext() {
+			n, err := m.it.Next()
+			if err != nil {
+				return nil, err
+			}
+			newEf.AddOffset(n)
 		}
-		newEf.AddOffset(v)
 	}
 	newEf.Build()
 	return newEf.AppendBytes(buf), nil
 }
 
 func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, historyFiles []*filesItem, r DomainRanges, workers int, ps *background.ProgressSet) (valuesIn, indexIn, historyIn *filesItem, err error) {
+	ctx, span := startSpan(ctx, "merge_files", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, d.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "merge_files", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, d.aggregationStep)
+	defer restoreLabels()
 	if !r.any() {
 		return
 	}
@@ -500,6 +563,7 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 				if valuesIn.bindex != nil {
 					valuesIn.bindex.Close()
 				}
+				valuesIn.vlog.Close()
 			}
 		}
 	}()
@@ -515,89 +579,136 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 	}
 	if r.values {
 		for _, f := range valuesFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+			defer d.madv.forMerge(f)()
 		}
 		datFileName := fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep)
+		defer fileTook(mxMergeTook, datFileName, d.metricsLabel).PutSince()
 		datPath := filepath.Join(d.dir, datFileName)
 		if comp, err = seg.NewCompressor(ctx, "merge", datPath, d.tmpdir, seg.MinPatternScore, workers, log.LvlTrace, d.logger); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s history compressor: %w", d.filenameBase, err)
 		}
+		comp.SetChecksums(dbg.SegChecksums)
 		if d.noFsync {
 			comp.DisableFsync()
 		}
+		var vlog *valueLogWriter // big values of merged file
+		if vlog, err = d.newValueLogWriter(comp, datPath); err != nil {
+			return nil, nil, nil, err
+		}
+		defer vlog.Close()
 		p := ps.AddNew("merege "+datFileName, 1)
 		defer ps.Delete(p)
 
 		var cp CursorHeap
 		heap.Init(&cp)
+		defer cp.Release()
 		for _, item := range valuesFiles {
-			g := item.decompressor.MakeGetter()
+			g := NewArchiveGetter(item.decompressor.MakeGetter(), d.compressVals).ZeroCopy()
 			g.Reset(0)
 			if g.HasNext() {
-				key, _ := g.NextUncompressed()
-				var val []byte
-				if d.compressVals {
-					val, _ = g.Next(nil)
-				} else {
-					val, _ = g.NextUncompressed()
+				key, _ := g.NextKey(nil)
+				val, _ := g.NextVal(nil)
+				if err = g.Err(); err != nil {
+					return nil, nil, nil, err
 				}
-				heap.Push(&cp, &CursorItem{
-					t:        FILE_CURSOR,
-					dg:       g,
-					key:      key,
-					val:      val,
-					endTxNum: item.endTxNum,
-					reverse:  true,
-				})
+				ci := newCursorItem()
+				ci.t, ci.dg, ci.key, ci.val, ci.startTxNum, ci.endTxNum, ci.reverse = FILE_CURSOR, g, key, val, item.startTxNum, item.endTxNum, true
+				ci.src = item
+				heap.Push(&cp, ci)
 			}
 		}
+		stepMeta := d.withStepMeta(r.valuesStartTxNum, r.valuesEndTxNum)
+		if stepMeta {
+			comp.SetFeatures(comp.Features() | kvFeatureStepMeta)
+		}
+		var codes *codeMerger // all pairs go through it: code sub-store entries have to be sorted together with addresses
+		withCodes := d.withCodeByHash(r.valuesStartTxNum, r.valuesEndTxNum)
+		for _, item := range valuesFiles {
+			withCodes = withCodes || hasCodeByHash(item) // `addr -> codeHash` of source can't be written without sub-store
+		}
+		if withCodes {
+			codes = newCodeMerger(d, stepMeta)
+			defer codes.Close()
+			comp.SetFeatures(comp.Features() | kvFeatureCodeByHash)
+		}
 		keyCount := 0
 		// In the loop below, the pair `keyBuf=>valBuf` is always 1 item behind `lastKey=>lastVal`.
 		// `lastKey` and `lastVal` are taken from the top of the multi-way merge (assisted by the CursorHeap cp), but not processed right away
 		// instead, the pair from the previous iteration is processed first - `keyBuf=>valBuf`. After that, `keyBuf` and `valBuf` are assigned
 		// to `lastKey` and `lastVal` correspondingly, and the next step of multi-way merge happens. Therefore, after the multi-way merge loop
 		// (when CursorHeap cp is empty), there is a need to process the last pair `keyBuf=>valBuf`, because it was one step behind
-		var keyBuf, valBuf []byte
+		var keyBuf, valBuf, lastKey, lastVal, srcBuf, valWord []byte
 		for cp.Len() > 0 {
-			lastKey := common.Copy(cp[0].key)
-			lastVal := common.Copy(cp[0].val)
+			lastKey = append(lastKey[:0], cp[0].key...)
+			src := cp[0].src
+			srcVal := cp[0].val
+			if src.vlog != nil { // merged file has own value-log: values are resolved and written again
+				if srcBuf, err = src.vlog.appendWord(srcBuf[:0], srcVal, hasStepMeta(src)); err != nil {
+					return nil, nil, nil, err
+				}
+				srcVal = srcBuf
+			}
+			valLen := len(srcVal)
+			if stepMeta { // source files may be in kv format v1: step of value is taken from file range
+				if lastVal, err = d.appendStepMeta(lastVal[:0], src, srcVal); err != nil {
+					return nil, nil, nil, err
+				}
+				valLen = len(lastVal) - 8
+			} else {
+				if srcVal, _, err = d.fileValue(src, srcVal); err != nil {
+					return nil, nil, nil, err
+				}
+				lastVal = append(lastVal[:0], srcVal...)
+				valLen = len(lastVal)
+			}
 			// Advance all the items that have this key (including the top)
 			for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 				ci1 := cp[0]
 				if ci1.dg.HasNext() {
-					ci1.key, _ = ci1.dg.NextUncompressed()
-					if d.compressVals {
-						ci1.val, _ = ci1.dg.Next(ci1.val[:0])
-					} else {
-						ci1.val, _ = ci1.dg.NextUncompressed()
+					ci1.key, _ = ci1.dg.NextKey(nil)
+					ci1.val, _ = ci1.dg.NextVal(nil)
+					if err = ci1.dg.Err(); err != nil {
+						return nil, nil, nil, err
 					}
 					heap.Fix(&cp, 0)
 				} else {
-					heap.Pop(&cp)
+					putCursorItem(heap.Pop(&cp).(*CursorItem))
 				}
 			}
 
 			// empty value means deletion
-			deleted := r.valuesStartTxNum == 0 && len(lastVal) == 0
+			deleted := r.valuesStartTxNum == 0 && valLen == 0
+			if codes != nil {
+				if !deleted {
+					if err = codes.add(src, lastKey, lastVal); err != nil {
+						return nil, nil, nil, err
+					}
+				}
+				continue
+			}
 			if !deleted {
 				if keyBuf != nil {
 					if err = comp.AddUncompressedWord(keyBuf); err != nil {
 						return nil, nil, nil, err
 					}
 					keyCount++ // Only counting keys, not values
+					if valWord, err = vlog.word(valBuf, stepMeta); err != nil {
+						return nil, nil, nil, err
+					}
 					switch d.compressVals {
 					case true:
-						if err = comp.AddWord(valBuf); err != nil {
+						if err = comp.AddWord(valWord); err != nil {
 							return nil, nil, nil, err
 						}
 					default:
-						if err = comp.AddUncompressedWord(valBuf); err != nil {
+						if err = comp.AddUncompressedWord(valWord); err != nil {
 							return nil, nil, nil, err
 						}
 					}
 				}
-				keyBuf = append(keyBuf[:0], lastKey...)
-				valBuf = append(valBuf[:0], lastVal...)
+				// lastKey/lastVal are own copies: swap buffers instead of copying them once more
+				keyBuf, lastKey = lastKey, keyBuf
+				valBuf, lastVal = lastVal, valBuf
 			}
 		}
 		if keyBuf != nil {
@@ -605,23 +716,36 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 				return nil, nil, nil, err
 			}
 			keyCount++ // Only counting keys, not values
+			if valWord, err = vlog.word(valBuf, stepMeta); err != nil {
+				return nil, nil, nil, err
+			}
 			if d.compressVals {
-				if err = comp.AddWord(valBuf); err != nil {
+				if err = comp.AddWord(valWord); err != nil {
 					return nil, nil, nil, err
 				}
 			} else {
-				if err = comp.AddUncompressedWord(valBuf); err != nil {
+				if err = comp.AddUncompressedWord(valWord); err != nil {
 					return nil, nil, nil, err
 				}
 			}
 		}
+		if codes != nil {
+			if keyCount, err = codes.flush(comp, d.compressVals, vlog); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		valuesIn = newFilesItem(r.valuesStartTxNum, r.valuesEndTxNum, d.aggregationStep)
+		if vlog != nil { // before .kv: .kv with tagged values must never be without .vlog
+			if valuesIn.vlog, err = vlog.finish(); err != nil {
+				return nil, nil, nil, fmt.Errorf("merge %s value-log [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
+			}
+		}
 		if err = comp.Compress(); err != nil {
 			return nil, nil, nil, err
 		}
 		comp.Close()
 		comp = nil
 		ps.Delete(p)
-		valuesIn = newFilesItem(r.valuesStartTxNum, r.valuesEndTxNum, d.aggregationStep)
 		if valuesIn.decompressor, err = seg.NewDecompressor(datPath); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s decompressor [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
 		}
@@ -633,7 +757,7 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 		ps.Delete(p)
 
 		//		if valuesIn.index, err = buildIndex(valuesIn.decompressor, idxPath, d.dir, keyCount, false /* values */); err != nil {
-		if valuesIn.index, err = buildIndexThenOpen(ctx, valuesIn.decompressor, idxPath, d.tmpdir, keyCount, false /* values */, p, d.logger, d.noFsync); err != nil {
+		if valuesIn.index, err = buildIndexThenOpen(ctx, valuesIn.decompressor, idxPath, d.tmpdir, keyCount, false /* values */, p, d.logger, d.noFsync, d.metricsLabel); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s buildIndex [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
 		}
 
@@ -658,8 +782,12 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 }
 
 func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, startTxNum, endTxNum uint64, workers int, ps *background.ProgressSet) (*filesItem, error) {
+	ctx, span := startSpan(ctx, "merge_files", ii.filenameBase, startTxNum, endTxNum, ii.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "merge_files", ii.filenameBase, startTxNum, endTxNum, ii.aggregationStep)
+	defer restoreLabels()
 	for _, h := range files {
-		defer h.decompressor.EnableMadvNormal().DisableReadAhead()
+		defer ii.madv.forMerge(h)()
 	}
 
 	var outItem *filesItem
@@ -691,6 +819,7 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 	}
 
 	datFileName := fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep)
+	defer fileTook(mxMergeTook, datFileName, ii.metricsLabel).PutSince()
 	datPath := filepath.Join(ii.dir, datFileName)
 	if comp, err = seg.NewCompressor(ctx, "Snapshots merge", datPath, ii.tmpdir, seg.MinPatternScore, workers, log.LvlTrace, ii.logger); err != nil {
 		return nil, fmt.Errorf("merge %s inverted index compressor: %w", ii.filenameBase, err)
@@ -703,22 +832,18 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 
 	var cp CursorHeap
 	heap.Init(&cp)
+	defer cp.Release()
 
 	for _, item := range files {
-		g := item.decompressor.MakeGetter()
+		g := NewArchiveGetter(item.decompressor.MakeGetter(), false).ZeroCopy()
 		g.Reset(0)
 		if g.HasNext() {
-			key, _ := g.Next(nil)
-			val, _ := g.Next(nil)
+			key, _ := g.NextKey(nil)
+			val, _ := g.NextKey(nil)
 			//fmt.Printf("heap push %s [%d] %x\n", item.decompressor.FilePath(), item.endTxNum, key)
-			heap.Push(&cp, &CursorItem{
-				t:        FILE_CURSOR,
-				dg:       g,
-				key:      key,
-				val:      val,
-				endTxNum: item.endTxNum,
-				reverse:  true,
-			})
+			ci := newCursorItem()
+			ci.t, ci.dg, ci.key, ci.val, ci.endTxNum, ci.reverse = FILE_CURSOR, g, key, val, item.endTxNum, true
+			heap.Push(&cp, ci)
 		}
 	}
 	keyCount := 0
@@ -728,17 +853,19 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 	// instead, the pair from the previous iteration is processed first - `keyBuf=>valBuf`. After that, `keyBuf` and `valBuf` are assigned
 	// to `lastKey` and `lastVal` correspondingly, and the next step of multi-way merge happens. Therefore, after the multi-way merge loop
 	// (when CursorHeap cp is empty), there is a need to process the last pair `keyBuf=>valBuf`, because it was one step behind
-	var keyBuf, valBuf []byte
+	var keyBuf, valBuf, lastKey, lastValBuf []byte
+	var efm efMerger
 	for cp.Len() > 0 {
-		lastKey := common.Copy(cp[0].key)
-		lastVal := common.Copy(cp[0].val)
+		lastKey = append(lastKey[:0], cp[0].key...)
+		lastValBuf = append(lastValBuf[:0], cp[0].val...)
+		lastVal := lastValBuf // efm.merge below returns new slice, buffer stays for next key
 		var mergedOnce bool
 
 		// Advance all the items that have this key (including the top)
 		for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 			ci1 := cp[0]
 			if mergedOnce {
-				if lastVal, err = mergeEfs(ci1.val, lastVal, nil); err != nil {
+				if lastVal, err = efm.merge(ci1.val, lastVal, nil); err != nil {
 					return nil, fmt.Errorf("merge %s inverted index: %w", ii.filenameBase, err)
 				}
 			} else {
@@ -746,14 +873,17 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 			}
 			//fmt.Printf("multi-way %s [%d] %x\n", ii.indexKeysTable, ci1.endTxNum, ci1.key)
 			if ci1.dg.HasNext() {
-				ci1.key, _ = ci1.dg.NextUncompressed()
-				ci1.val, _ = ci1.dg.NextUncompressed()
+				ci1.key, _ = ci1.dg.NextKey(nil)
+				ci1.val, _ = ci1.dg.NextKey(nil)
 				//fmt.Printf("heap next push %s [%d] %x\n", ii.indexKeysTable, ci1.endTxNum, ci1.key)
 				heap.Fix(&cp, 0)
 			} else {
-				heap.Pop(&cp)
+				putCursorItem(heap.Pop(&cp).(*CursorItem))
 			}
 		}
+		if ii.keep != nil && !ii.keep(lastKey) {
+			continue // `keyBuf` stays pending
+		}
 		if keyBuf != nil {
 			if err = comp.AddUncompressedWord(keyBuf); err != nil {
 				return nil, err
@@ -790,14 +920,21 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 	idxPath := filepath.Join(ii.dir, idxFileName)
 	p = ps.AddNew("merge "+idxFileName, uint64(outItem.decompressor.Count()*2))
 	defer ps.Delete(p)
-	if outItem.index, err = buildIndexThenOpen(ctx, outItem.decompressor, idxPath, ii.tmpdir, keyCount, false /* values */, p, ii.logger, ii.noFsync); err != nil {
+	if outItem.index, err = buildIndexThenOpen(ctx, outItem.decompressor, idxPath, ii.tmpdir, keyCount, false /* values */, p, ii.logger, ii.noFsync, ii.metricsLabel); err != nil {
 		return nil, fmt.Errorf("merge %s buildIndex [%d-%d]: %w", ii.filenameBase, startTxNum, endTxNum, err)
 	}
+	if dbg.MergeDropPageCache {
+		outItem.dropPageCache(ii.logger)
+	}
 	closeItem = false
 	return outItem, nil
 }
 
 func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*filesItem, r HistoryRanges, workers int, ps *background.ProgressSet) (indexIn, historyIn *filesItem, err error) {
+	ctx, span := startSpan(ctx, "merge_files", h.filenameBase, r.historyStartTxNum, r.historyEndTxNum, h.aggregationStep)
+	defer span.End()
+	ctx, restoreLabels := pprofLabels(ctx, "merge_files", h.filenameBase, r.historyStartTxNum, r.historyEndTxNum, h.aggregationStep)
+	defer restoreLabels()
 	if !r.any() {
 		return nil, nil, nil
 	}
@@ -815,10 +952,10 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 	}
 	if r.history {
 		for _, f := range indexFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+			defer h.madv.forMerge(f)()
 		}
 		for _, f := range historyFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+			defer h.madv.forMerge(f)()
 		}
 
 		var comp *seg.Compressor
@@ -852,11 +989,13 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 		}()
 		datFileName := fmt.Sprintf("%s.%d-%d.v", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep)
 		idxFileName := fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep)
+		defer fileTook(mxMergeTook, datFileName, h.metricsLabel).PutSince()
 		datPath := filepath.Join(h.dir, datFileName)
 		idxPath := filepath.Join(h.dir, idxFileName)
 		if comp, err = seg.NewCompressor(ctx, "merge", datPath, h.tmpdir, seg.MinPatternScore, workers, log.LvlTrace, h.logger); err != nil {
 			return nil, nil, fmt.Errorf("merge %s history compressor: %w", h.filenameBase, err)
 		}
+		comp.SetChecksums(dbg.SegChecksums)
 		if h.noFsync {
 			comp.DisableFsync()
 		}
@@ -864,31 +1003,26 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 		defer ps.Delete(p)
 		var cp CursorHeap
 		heap.Init(&cp)
+		defer cp.Release()
 		for _, item := range indexFiles {
-			g := item.decompressor.MakeGetter()
+			g := NewArchiveGetter(item.decompressor.MakeGetter(), false).ZeroCopy()
 			g.Reset(0)
 			if g.HasNext() {
-				var g2 *seg.Getter
+				var g2 *ArchiveGetter
 				for _, hi := range historyFiles { // full-scan, because it's ok to have different amount files. by unclean-shutdown.
 					if hi.startTxNum == item.startTxNum && hi.endTxNum == item.endTxNum {
-						g2 = hi.decompressor.MakeGetter()
+						g2 = NewArchiveGetter(hi.decompressor.MakeGetter(), h.compressVals).ZeroCopy()
 						break
 					}
 				}
 				if g2 == nil {
 					panic(fmt.Sprintf("for file: %s, not found corresponding file to merge", g.FileName()))
 				}
-				key, _ := g.NextUncompressed()
-				val, _ := g.NextUncompressed()
-				heap.Push(&cp, &CursorItem{
-					t:        FILE_CURSOR,
-					dg:       g,
-					dg2:      g2,
-					key:      key,
-					val:      val,
-					endTxNum: item.endTxNum,
-					reverse:  false,
-				})
+				key, _ := g.NextKey(nil)
+				val, _ := g.NextKey(nil)
+				ci := newCursorItem()
+				ci.t, ci.dg, ci.dg2, ci.key, ci.val, ci.endTxNum, ci.reverse = FILE_CURSOR, g, g2, key, val, item.endTxNum, false
+				heap.Push(&cp, ci)
 			}
 		}
 		// In the loop below, the pair `keyBuf=>valBuf` is always 1 item behind `lastKey=>lastVal`.
@@ -896,11 +1030,12 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 		// instead, the pair from the previous iteration is processed first - `keyBuf=>valBuf`. After that, `keyBuf` and `valBuf` are assigned
 		// to `lastKey` and `lastVal` correspondingly, and the next step of multi-way merge happens. Therefore, after the multi-way merge loop
 		// (when CursorHeap cp is empty), there is a need to process the last pair `keyBuf=>valBuf`, because it was one step behind
-		var valBuf []byte
+		var valBuf, lastKey []byte
 		var keyCount int
 		for cp.Len() > 0 {
-			lastKey := common.Copy(cp[0].key)
+			lastKey = append(lastKey[:0], cp[0].key...)
 			// Advance all the items that have this key (including the top)
+			keep := h.keep == nil || h.keep(lastKey) // merged index doesn't have this key: skip values
 			for cp.Len() > 0 && bytes.Equal(cp[0].key, lastKey) {
 				ci1 := cp[0]
 				count := eliasfano32.Count(ci1.val)
@@ -909,25 +1044,33 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 						panic(fmt.Errorf("assert: no value??? %s, i=%d, count=%d, lastKey=%x, ci1.key=%x", ci1.dg2.FileName(), i, count, lastKey, ci1.key))
 					}
 
+					if !keep {
+						ci1.dg2.SkipVal()
+						continue
+					}
+					valBuf, _ = ci1.dg2.NextVal(nil)
+					if err = ci1.dg2.Err(); err != nil {
+						return nil, nil, err
+					}
 					if h.compressVals {
-						valBuf, _ = ci1.dg2.Next(valBuf[:0])
 						if err = comp.AddWord(valBuf); err != nil {
 							return nil, nil, err
 						}
 					} else {
-						valBuf, _ = ci1.dg2.NextUncompressed()
 						if err = comp.AddUncompressedWord(valBuf); err != nil {
 							return nil, nil, err
 						}
 					}
 				}
-				keyCount += int(count)
+				if keep {
+					keyCount += int(count)
+				}
 				if ci1.dg.HasNext() {
-					ci1.key, _ = ci1.dg.NextUncompressed()
-					ci1.val, _ = ci1.dg.NextUncompressed()
+					ci1.key, _ = ci1.dg.NextKey(nil)
+					ci1.val, _ = ci1.dg.NextKey(nil)
 					heap.Fix(&cp, 0)
 				} else {
-					heap.Remove(&cp, 0)
+					putCursorItem(heap.Remove(&cp, 0).(*CursorItem))
 				}
 			}
 		}
@@ -963,6 +1106,8 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 		g := indexIn.decompressor.MakeGetter()
 		g2 := decomp.MakeGetter()
 		var keyBuf []byte
+		var ef eliasfano32.EliasFano // re-used for every key
+		var efIt eliasfano32.EliasFanoIter
 		for {
 			g.Reset(0)
 			g2.Reset(0)
@@ -970,8 +1115,8 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 			for g.HasNext() {
 				keyBuf, _ = g.NextUncompressed()
 				valBuf, _ = g.NextUncompressed()
-				ef, _ := eliasfano32.ReadEliasFano(valBuf)
-				efIt := ef.Iterator()
+				ef.Reset(valBuf)
+				efIt.ResetTo(&ef)
 				for efIt.HasNext() {
 					txNum, _ := efIt.Next()
 					binary.BigEndian.PutUint64(txKey[:], txNum)
@@ -989,7 +1134,7 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 			}
 			if err = rs.Build(ctx); err != nil {
 				if rs.Collision() {
-					log.Info("Building recsplit. Collision happened. It's ok. Restarting...")
+					h.logger.Info("Building recsplit. Collision happened. It's ok. Restarting...")
 					rs.ResetNextSalt()
 				} else {
 					return nil, nil, fmt.Errorf("build %s idx: %w", h.filenameBase, err)
@@ -1009,11 +1154,44 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 
 		closeItem = false
 	}
+	if dbg.MergeDropPageCache {
+		// building of .vi did read .ef again
+		if indexIn != nil {
+			indexIn.dropPageCache(h.logger)
+		}
+		if historyIn != nil {
+			historyIn.dropPageCache(h.logger)
+		}
+	}
 
 	closeIndex = false
 	return
 }
 
+// dropPageCache - evict merged files from page cache. They are written once and read back by index building,
+// but rarely read right after merge - keep page cache for hot (small, recent) files.
+// Also used for sources of merge: new contexts don't read them, and they will be deleted soon.
+func (i *filesItem) dropPageCache(logger log.Logger) {
+	var paths []string
+	if i.decompressor != nil {
+		paths = append(paths, i.decompressor.FilePath())
+	}
+	if i.index != nil {
+		paths = append(paths, i.index.FilePath())
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			logger.Warn("[snapshots] drop page cache", "err", err, "file", path)
+			continue
+		}
+		if err = mmap.FadviseDontNeed(f); err != nil {
+			logger.Warn("[snapshots] drop page cache", "err", err, "file", path)
+		}
+		f.Close()
+	}
+}
+
 func (d *Domain) integrateMergedFiles(valuesOuts, indexOuts, historyOuts []*filesItem, valuesIn, indexIn, historyIn *filesItem) {
 	d.History.integrateMergedFiles(indexOuts, historyOuts, indexIn, historyIn)
 	if valuesIn != nil {
@@ -1038,7 +1216,10 @@ func (d *Domain) integrateMergedFiles(valuesOuts, indexOuts, historyOuts []*file
 			panic("must not happen")
 		}
 		d.files.Delete(out)
-		out.canDelete.Store(true)
+		out.markCanDelete(d.retired, "merged", valuesIn)
+		if dbg.MergeDropPageCache {
+			out.dropPageCache(d.logger)
+		}
 	}
 	d.reCalcRoFiles()
 }
@@ -1066,7 +1247,10 @@ func (ii *InvertedIndex) integrateMergedFiles(outs []*filesItem, in *filesItem)
 			panic("must not happen: " + ii.filenameBase)
 		}
 		ii.files.Delete(out)
-		out.canDelete.Store(true)
+		out.markCanDelete(ii.retired, "merged", in)
+		if dbg.MergeDropPageCache {
+			out.dropPageCache(ii.logger)
+		}
 	}
 	ii.reCalcRoFiles()
 }
@@ -1096,7 +1280,10 @@ func (h *History) integrateMergedFiles(indexOuts, historyOuts []*filesItem, inde
 			panic("must not happen: " + h.filenameBase)
 		}
 		h.files.Delete(out)
-		out.canDelete.Store(true)
+		out.markCanDelete(h.retired, "merged", historyIn)
+		if dbg.MergeDropPageCache {
+			out.dropPageCache(h.logger)
+		}
 	}
 	h.reCalcRoFiles()
 }
@@ -1125,6 +1312,21 @@ func (hc *HistoryContext) frozenTo() uint64 {
 	}
 	return 0
 }
+func (hc *HistoryContext) filesStartTxNum() uint64 {
+	if len(hc.files) == 0 {
+		return hc.ic.filesStartTxNum()
+	}
+	return cmp.Max(ctxFilesStartTxNum(hc.files), hc.ic.filesStartTxNum())
+}
+func (hc *HistoryContext) filesEndTxNum() uint64 {
+	return cmp.Min(ctxFilesEndTxNum(hc.files), hc.ic.filesEndTxNum())
+}
+func (ic *InvertedIndexContext) filesStartTxNum() uint64 {
+	return ctxFilesStartTxNum(ic.files)
+}
+func (ic *InvertedIndexContext) filesEndTxNum() uint64 {
+	return ctxFilesEndTxNum(ic.files)
+}
 func (ic *InvertedIndexContext) frozenTo() uint64 {
 	if len(ic.files) == 0 {
 		return 0
@@ -1160,11 +1362,11 @@ func (d *Domain) cleanAfterFreeze(frozenTo uint64) {
 			panic("must not happen: " + d.filenameBase)
 		}
 		d.files.Delete(out)
+		out.markCanDelete(d.retired, fmt.Sprintf("covered by frozen files up to step %d", frozenTo/d.aggregationStep), nil)
 		if out.refcount.Load() == 0 {
 			// if it has no readers (invisible even for us) - it's safe to remove file right here
 			out.closeFilesAndRemove()
 		}
-		out.canDelete.Store(true)
 	}
 	d.History.cleanAfterFreeze(frozenTo)
 }
@@ -1194,7 +1396,7 @@ func (h *History) cleanAfterFreeze(frozenTo uint64) {
 		if out == nil {
 			panic("must not happen: " + h.filenameBase)
 		}
-		out.canDelete.Store(true)
+		out.markCanDelete(h.retired, fmt.Sprintf("covered by frozen files up to step %d", frozenTo/h.aggregationStep), nil)
 
 		//if out.refcount.Load() == 0 {
 		//	if h.filenameBase == "accounts" {
@@ -1237,7 +1439,7 @@ func (ii *InvertedIndex) cleanAfterFreeze(frozenTo uint64) {
 		if out == nil {
 			panic("must not happen: " + ii.filenameBase)
 		}
-		out.canDelete.Store(true)
+		out.markCanDelete(ii.retired, fmt.Sprintf("covered by frozen files up to step %d", frozenTo/ii.aggregationStep), nil)
 		if out.refcount.Load() == 0 {
 			// if it has no readers (invisible even for us) - it's safe to remove file right here
 			out.closeFilesAndRemove()
@@ -1255,11 +1457,11 @@ func (d *Domain) deleteGarbageFiles() {
 			continue
 		}
 		f1 := fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, item.startTxNum/d.aggregationStep, item.endTxNum/d.aggregationStep)
-		os.Remove(filepath.Join(d.dir, f1))
-		log.Debug("[snapshots] delete garbage", f1)
+		removeFile(filepath.Join(d.dir, f1))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f1, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 		f2 := fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, item.startTxNum/d.aggregationStep, item.endTxNum/d.aggregationStep)
-		os.Remove(filepath.Join(d.dir, f2))
-		log.Debug("[snapshots] delete garbage", f2)
+		removeFile(filepath.Join(d.dir, f2))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f2, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 	}
 	d.garbageFiles = nil
 	d.History.deleteGarbageFiles()
@@ -1271,11 +1473,11 @@ func (h *History) deleteGarbageFiles() {
 			continue
 		}
 		f1 := fmt.Sprintf("%s.%d-%d.v", h.filenameBase, item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep)
-		os.Remove(filepath.Join(h.dir, f1))
-		log.Debug("[snapshots] delete garbage", f1)
+		removeFile(filepath.Join(h.dir, f1))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f1, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 		f2 := fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep)
-		os.Remove(filepath.Join(h.dir, f2))
-		log.Debug("[snapshots] delete garbage", f2)
+		removeFile(filepath.Join(h.dir, f2))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f2, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 	}
 	h.garbageFiles = nil
 	h.InvertedIndex.deleteGarbageFiles()
@@ -1287,11 +1489,90 @@ func (ii *InvertedIndex) deleteGarbageFiles() {
 			continue
 		}
 		f1 := fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep)
-		os.Remove(filepath.Join(ii.dir, f1))
-		log.Debug("[snapshots] delete garbage", f1)
+		removeFile(filepath.Join(ii.dir, f1))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f1, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 		f2 := fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep)
-		os.Remove(filepath.Join(ii.dir, f2))
-		log.Debug("[snapshots] delete garbage", f2)
+		removeFile(filepath.Join(ii.dir, f2))
+		log.Log(deleteAuditLvl(), "[snapshots] delete garbage", "file", f2, "reason", "garbage on startup", "dry_run", dbg.SnapshotsDeleteDryRun)
 	}
 	ii.garbageFiles = nil
 }
+
+// GarbageFiles - paths of files on disk which aggregator doesn't use and which can be deleted:
+// sub-sets of visible (already merged) files and files without required companion files (see scanStateFiles).
+// Same rules as used on files opening. Files of biggest size are never reported (paranoic-mode).
+func (a *AggregatorV3) GarbageFiles() ([]string, error) {
+	ac := a.MakeContext()
+	defer ac.Close()
+
+	var res []string
+	for _, hc := range []*HistoryContext{ac.accounts, ac.storage, ac.code} {
+		g, err := garbageFilesOnDisk(a.dir, hc.h.filenameBase, []string{"v", "vi"}, hc.files, hc.h.garbageFiles, a.aggregationStep)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, g...)
+		if g, err = garbageFilesOnDisk(a.dir, hc.h.filenameBase, []string{"ef", "efi"}, hc.ic.files, hc.h.InvertedIndex.garbageFiles, a.aggregationStep); err != nil {
+			return nil, err
+		}
+		res = append(res, g...)
+	}
+	for _, ic := range append([]*InvertedIndexContext{ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		g, err := garbageFilesOnDisk(a.dir, ic.ii.filenameBase, []string{"ef", "efi"}, ic.files, ic.ii.garbageFiles, a.aggregationStep)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, g...)
+	}
+	return res, nil
+}
+
+func garbageFilesOnDisk(dir, filenameBase string, exts []string, visible []ctxItem, scanned []*filesItem, aggStep uint64) ([]string, error) {
+	re := regexp.MustCompile("^" + filenameBase + `\.([0-9]+)-([0-9]+)\.(` + strings.Join(exts, "|") + ")$")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, e := range entries {
+		subs := re.FindStringSubmatch(e.Name())
+		if len(subs) != 4 || !e.Type().IsRegular() {
+			continue
+		}
+		startStep, err := strconv.ParseUint(subs[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		endStep, err := strconv.ParseUint(subs[2], 10, 64)
+		if err != nil || startStep >= endStep {
+			continue
+		}
+		item := newFilesItem(startStep*aggStep, endStep*aggStep, aggStep)
+		if item.frozen {
+			continue
+		}
+		if isGarbageFile(item, visible, scanned) {
+			res = append(res, filepath.Join(dir, e.Name()))
+		}
+	}
+	return res, nil
+}
+
+func isGarbageFile(item *filesItem, visible []ctxItem, scanned []*filesItem) bool {
+	for _, v := range visible {
+		if v.startTxNum == item.startTxNum && v.endTxNum == item.endTxNum {
+			return false
+		}
+	}
+	for _, v := range visible {
+		if item.isSubsetOf(v.src) {
+			return true
+		}
+	}
+	for _, g := range scanned {
+		if g.startTxNum == item.startTxNum && g.endTxNum == item.endTxNum {
+			return true
+		}
+	}
+	return false
+}
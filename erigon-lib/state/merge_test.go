@@ -1,6 +1,8 @@
 package state
 
 import (
+	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 
@@ -8,6 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 	btree2 "github.com/tidwall/btree"
 
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 )
 
@@ -310,6 +313,38 @@ func TestFindMergeRangeCornerCases(t *testing.T) {
 		idxFiles, _ := ic.staticFilesInRange(from, to)
 		require.Equal(t, 3, len(idxFiles))
 	})
+	t.Run("older files not downloaded", func(t *testing.T) {
+		ii := &InvertedIndex{filenameBase: "test", aggregationStep: 1, files: btree2.NewBTreeG[*filesItem](filesItemLess)}
+		ii.scanStateFiles([]string{
+			"test.4-5.ef",
+			"test.5-6.ef",
+			"test.6-7.ef",
+			"test.7-8.ef",
+		})
+		ii.reCalcRoFiles()
+		ic := ii.MakeContext()
+		defer ic.Close()
+		require.Equal(t, 4, int(ic.filesStartTxNum()))
+
+		// 7-8 can be merged into 0-8 only, but files before 4 are missing
+		needMerge, from, to := ii.findMergeRange(8, 32)
+		assert.True(t, needMerge)
+		require.Equal(t, 4, int(from))
+		require.Equal(t, 6, int(to))
+
+		h := &History{InvertedIndex: ii, files: btree2.NewBTreeG[*filesItem](filesItemLess)}
+		h.scanStateFiles([]string{
+			"test.4-5.v",
+			"test.5-6.v",
+			"test.6-7.v",
+			"test.7-8.v",
+		})
+		h.reCalcRoFiles()
+		r := h.findMergeRange(8, 32)
+		assert.True(t, r.history)
+		require.Equal(t, 4, int(r.historyStartTxNum))
+		require.Equal(t, 6, int(r.historyEndTxNum))
+	})
 }
 func Test_mergeEliasFano(t *testing.T) {
 	t.Skip()
@@ -353,8 +388,14 @@ func Test_mergeEliasFano(t *testing.T) {
 		require.Contains(t, secondList, int(v))
 	}
 
-	menc, err := mergeEfs(firstBytes, secondBytes, nil)
+	var efm efMerger
+	menc, err := efm.merge(firstBytes, secondBytes, nil)
+	require.NoError(t, err)
+
+	// merger is re-used for next key
+	menc2, err := efm.merge(firstBytes, secondBytes, nil)
 	require.NoError(t, err)
+	require.Equal(t, menc, menc2)
 
 	merged, _ := eliasfano32.ReadEliasFano(menc)
 	require.NoError(t, err)
@@ -371,3 +412,81 @@ func Test_mergeEliasFano(t *testing.T) {
 		require.Contains(t, mergedLists, int(v))
 	}
 }
+
+func TestCheckMergeCoverage(t *testing.T) {
+	files := []ctxItem{{startTxNum: 0, endTxNum: 2}, {startTxNum: 2, endTxNum: 3}, {startTxNum: 3, endTxNum: 4}, {startTxNum: 6, endTxNum: 7}}
+	require.NoError(t, checkMergeCoverage(files, 0, 4, 1))
+	require.NoError(t, checkMergeCoverage(files, 2, 4, 1))
+	require.Error(t, checkMergeCoverage(files, 1, 4, 1)) // crosses border of 0-2
+	require.Error(t, checkMergeCoverage(files, 3, 7, 1)) // gap 4-6
+	require.Error(t, checkMergeCoverage(files, 0, 2, 1)) // nothing to merge
+	require.Error(t, checkMergeCoverage(files, 0, 8, 1)) // no files for 7-8
+}
+
+func TestIsGarbageFile(t *testing.T) {
+	merged := newFilesItem(0, 4, 1)
+	visible := []ctxItem{{startTxNum: 0, endTxNum: 4, src: merged}, {startTxNum: 4, endTxNum: 5, src: newFilesItem(4, 5, 1)}}
+	scanned := []*filesItem{newFilesItem(5, 6, 1)} // for example: .v without .vi
+
+	require.False(t, isGarbageFile(newFilesItem(0, 4, 1), visible, scanned))
+	require.False(t, isGarbageFile(newFilesItem(4, 5, 1), visible, scanned))
+	require.True(t, isGarbageFile(newFilesItem(0, 2, 1), visible, scanned))
+	require.True(t, isGarbageFile(newFilesItem(2, 4, 1), visible, scanned))
+	require.True(t, isGarbageFile(newFilesItem(5, 6, 1), visible, scanned))
+	require.False(t, isGarbageFile(newFilesItem(6, 7, 1), visible, scanned))
+}
+
+func TestMergeJobsOrder(t *testing.T) {
+	item := func(queries uint64) *filesItem {
+		it := newFilesItem(0, 1, 1)
+		it.queries.Store(queries)
+		return it
+	}
+	cold := []*filesItem{item(100), item(100)}                  // 1 file saved, 200 queries
+	hot := []*filesItem{item(50), item(50), item(50), item(50)} // 3 files saved, 200 queries
+	require.Equal(t, uint64(200), mergeScore(cold))
+	require.Equal(t, uint64(600), mergeScore(hot))
+	require.Zero(t, mergeScore([]*filesItem{item(1000)}))
+
+	var order []string
+	jobs := []mergeJob{
+		{score: mergeScore(nil), run: func() error { order = append(order, "empty"); return nil }},
+		{score: mergeScore(cold), run: func() error { order = append(order, "cold"); return nil }},
+		{score: mergeScore(hot), run: func() error { order = append(order, "hot"); return nil }},
+	}
+	sortMergeJobs(jobs)
+	for _, job := range jobs {
+		require.NoError(t, job.run())
+	}
+	require.Equal(t, []string{"hot", "cold", "empty"}, order)
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	item := newFilesItem(0, 2, 1)
+	item.markCanDelete(nil, "covered by frozen files up to step 2", nil)
+	require.True(t, item.canDelete.Load())
+	require.Equal(t, "covered by frozen files up to step 2", *item.deleteReason.Load())
+
+	path := filepath.Join(t.TempDir(), "accounts.0-2.v")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+
+	defer func(v bool) { dbg.SnapshotsDeleteDryRun = v }(dbg.SnapshotsDeleteDryRun)
+	dbg.SnapshotsDeleteDryRun = true
+	removeFile(path)
+	require.FileExists(t, path)
+
+	dbg.SnapshotsDeleteDryRun = false
+	removeFile(path)
+	require.NoFileExists(t, path)
+}
+
+func TestCheckMergeAlignment(t *testing.T) {
+	require.NoError(t, checkMergeAlignment(0, 2))
+	require.NoError(t, checkMergeAlignment(4, 8))
+	require.NoError(t, checkMergeAlignment(6, 7))
+	require.NoError(t, checkMergeAlignment(0, StepsInBiggestFile))
+	require.Error(t, checkMergeAlignment(2, 2))                    // empty
+	require.Error(t, checkMergeAlignment(0, 3))                    // not power of 2
+	require.Error(t, checkMergeAlignment(2, 6))                    // from not multiple of span
+	require.Error(t, checkMergeAlignment(0, 2*StepsInBiggestFile)) // too big
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+)
+
+// QuarantineDirName - subdir of snapshots dir: files which failed validation on open (corrupted, truncated, checksum
+// mismatch) are moved there by OpenFolder - instead of failing it. Kept for post-mortem, never opened. Safe to delete.
+// OS errors (EMFILE, ENOMEM of mmap, EACCES, ...) say nothing about file: OpenFolder fails with them, see isCorruption.
+const QuarantineDirName = "quarantine"
+
+// Rebuild plans of quarantined files
+const (
+	RebuildIndex    = "index"    // accessor index: re-built from data file in background, see rebuildQuarantinedInBackground
+	RebuildDownload = "download" // data file: re-downloaded if it's published, until then files before it are not visible
+)
+
+type QuarantinedFile struct {
+	Name    string
+	Reason  string
+	Rebuild string
+	At      time.Time
+}
+
+// quarantine - move broken files out of the way. Files of searchDirs and of dir owned by another process (see ownsDir)
+// are never moved: they are just not opened.
+// Files before quarantined data file are not serviceable until it's back (see filesStartTxNum): reads of its range
+// return ErrHistoryNotAvailable instead of wrong (empty) history, and merge doesn't produce file over the gap.
+func (ii *InvertedIndex) quarantine(reason error, external bool, fPaths ...string) {
+	for _, fPath := range fPaths {
+		if !dir.FileExist(fPath) {
+			continue
+		}
+		name := filepath.Base(fPath)
+		q := QuarantinedFile{Name: name, Reason: reason.Error(), Rebuild: RebuildDownload, At: time.Now()}
+		if isIndexFile(name) {
+			q.Rebuild = RebuildIndex
+		}
+		to := ""
+		if !external && ii.ownsDir() {
+			to = filepath.Join(filepath.Dir(fPath), QuarantineDirName, name)
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				ii.logger.Error("[snapshots] quarantine", "file", name, "err", err)
+				to = ""
+			} else if err := os.Rename(fPath, to); err != nil {
+				ii.logger.Error("[snapshots] quarantine", "file", name, "err", err)
+				to = ""
+			}
+		}
+		ii.logger.Warn("[snapshots] file quarantined", "file", name, "reason", reason, "rebuild", q.Rebuild, "moved_to", to, "external", external)
+
+		ii.quarantinedLock.Lock()
+		ii.quarantined = append(ii.quarantined, q)
+		ii.quarantinedLock.Unlock()
+		if q.Rebuild == RebuildIndex {
+			ii.indexRebuild.Store(true)
+		}
+	}
+}
+
+// isCorruption - error of seg.NewDecompressor/recsplit.OpenIndex is caused by content of file (failed validation of
+// header, size or checksums), not by environment: errors of open/stat/mmap syscalls are transient.
+func isCorruption(err error) bool {
+	var errno syscall.Errno
+	var pathErr *fs.PathError
+	return !errors.As(err, &errno) && !errors.As(err, &pathErr)
+}
+
+func isIndexFile(name string) bool {
+	switch strings.TrimPrefix(filepath.Ext(name), ".") {
+	case "efi", "vi", "kvi", "bt":
+		return true
+	}
+	return false
+}
+
+// QuarantinedFiles - files quarantined by OpenFolder since start
+func (a *AggregatorV3) QuarantinedFiles() (res []QuarantinedFile) {
+	for _, ii := range a.invertedIndices() {
+		ii.quarantinedLock.Lock()
+		res = append(res, ii.quarantined...)
+		ii.quarantinedLock.Unlock()
+	}
+	return res
+}
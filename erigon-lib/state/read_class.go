@@ -0,0 +1,109 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ReadClass - who reads AggregatorV3Context (history point lookups and index ranges). Reads of non-sync classes are limited by ReadLimiter:
+// over the limit they wait in queue, so heavy RPC/analytics load can't slow down execution stage.
+type ReadClass uint8
+
+const (
+	ReadClassSync      ReadClass = iota // execution and other stages. never limited, default
+	ReadClassRPC                        // JSON-RPC and other user-facing APIs
+	ReadClassAnalytics                  // bulk exports, indexers
+
+	readClassesAmount
+)
+
+func (c ReadClass) String() string {
+	switch c {
+	case ReadClassSync:
+		return "sync"
+	case ReadClassRPC:
+		return "rpc"
+	case ReadClassAnalytics:
+		return "analytics"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// ReadLimiter - per-class limit of concurrent lookups. Shared by all histories and indices of aggregator.
+type ReadLimiter struct {
+	sems    [readClassesAmount]*semaphore.Weighted // nil - unlimited
+	waiting [readClassesAmount]atomic.Int64
+}
+
+// NewReadLimiter - `rpc` and `analytics` are limits of concurrent lookups of corresponding class, 0 means unlimited
+func NewReadLimiter(rpc, analytics int) *ReadLimiter {
+	l := &ReadLimiter{}
+	if rpc > 0 {
+		l.sems[ReadClassRPC] = semaphore.NewWeighted(int64(rpc))
+	}
+	if analytics > 0 {
+		l.sems[ReadClassAnalytics] = semaphore.NewWeighted(int64(analytics))
+	}
+	return l
+}
+
+// acquire - returns func which must be called when lookup is done
+func (l *ReadLimiter) acquire(c ReadClass) func() {
+	if l == nil || c >= readClassesAmount || l.sems[c] == nil {
+		return func() {}
+	}
+	sem := l.sems[c]
+	if !sem.TryAcquire(1) {
+		l.waiting[c].Add(1)
+		_ = sem.Acquire(context.Background(), 1) // never fails with non-cancelable ctx
+		l.waiting[c].Add(-1)
+	}
+	return func() { sem.Release(1) }
+}
+
+// Waiting - amount of lookups of class `c` which are in queue now
+func (l *ReadLimiter) Waiting(c ReadClass) int64 {
+	if l == nil || c >= readClassesAmount {
+		return 0
+	}
+	return l.waiting[c].Load()
+}
+
+// SetReadClass - all further lookups of this context are accounted as class `c`
+func (ic *InvertedIndexContext) SetReadClass(c ReadClass) { ic.class = c }
+
+// SetReadLimits - limits concurrent lookups of ReadClassRPC and ReadClassAnalytics contexts (0 - unlimited).
+// ReadClassSync lookups are never limited. Must be called before any reads (right after NewAggregatorV3).
+func (a *AggregatorV3) SetReadLimits(rpc, analytics int) {
+	l := NewReadLimiter(rpc, analytics)
+	for _, ii := range a.invertedIndices() {
+		ii.readLimiter = l
+	}
+}
+
+// SetReadClass - see ReadClass. Context of RPC request must be marked as ReadClassRPC to not compete with sync.
+func (ac *AggregatorV3Context) SetReadClass(c ReadClass) {
+	for _, ic := range append([]*InvertedIndexContext{ac.accounts.ic, ac.storage.ic, ac.code.ic, ac.logAddrs, ac.logTopics, ac.tracesFrom, ac.tracesTo, ac.txLookup}, ac.extraIndices...) {
+		ic.SetReadClass(c)
+	}
+}
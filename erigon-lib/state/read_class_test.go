@@ -0,0 +1,76 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+func TestReadLimiter(t *testing.T) {
+	l := NewReadLimiter(1, 0)
+
+	// sync and unlimited classes never wait
+	for i := 0; i < 10; i++ {
+		defer l.acquire(ReadClassSync)()
+		defer l.acquire(ReadClassAnalytics)()
+	}
+
+	release := l.acquire(ReadClassRPC)
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		l.acquire(ReadClassRPC)()
+	}()
+	require.Eventually(t, func() bool { return l.Waiting(ReadClassRPC) == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-acquired:
+		t.Fatal("rpc read must wait in queue")
+	default:
+	}
+	release()
+	<-acquired
+	require.Zero(t, l.Waiting(ReadClassRPC))
+
+	var nilLimiter *ReadLimiter
+	nilLimiter.acquire(ReadClassRPC)()
+}
+
+func TestAggregatorV3_ReadClass(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	defer agg.Close()
+	agg.SetReadLimits(1, 0)
+	l := agg.accounts.readLimiter
+
+	tx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	ac := agg.MakeContext()
+	defer ac.Close()
+
+	// sync context is not limited
+	release := l.acquire(ReadClassRPC)
+	_, _, err = ac.ReadAccountDataNoStateWithRecent([]byte("addr"), 1, tx)
+	require.NoError(t, err)
+
+	ac.SetReadClass(ReadClassRPC)
+	done := make(chan error, 2)
+	go func() {
+		_, _, err := ac.ReadAccountDataNoStateWithRecent([]byte("addr"), 1, tx)
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return l.Waiting(ReadClassRPC) == 1 }, time.Second, time.Millisecond)
+	release()
+	require.NoError(t, <-done)
+
+	go func() {
+		_, err := ac.IndexRange(kv.LogAddrIdx, []byte("addr"), 0, 10, order.Asc, -1, tx)
+		done <- err
+	}()
+	require.NoError(t, <-done)
+	require.Zero(t, l.Waiting(ReadClassRPC))
+}
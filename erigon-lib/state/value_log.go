@@ -0,0 +1,247 @@
+/*
+   Copyright 2024 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/mmap"
+	"github.com/ledgerwatch/erigon-lib/seg"
+)
+
+// Value-log storage (Domain.valueLogThreshold).
+//
+// Huge values (contract code, giant storage values) inflate patterns dictionary of .kv and slow down merge.
+// Values of size >= threshold are written once to `.vlog` file of same range, .kv stores reference to them.
+// .kv with tagged values has kvFeatureValueLog in features of file (see seg.Compressor.SetFeatures): its .vlog
+// must exist, .kv without this feature is read as-is and never opens .vlog.
+// Tagged value-word: empty (deletion), or `valueInline+value`, or `valueRef+offset(8 bytes)+length(4 bytes)`.
+// Layout of .vlog: just concatenated values - they are not compressed.
+
+// kvFeatureValueLog - values of .kv are tagged, big values are in .vlog of same range
+const kvFeatureValueLog uint64 = 1 << 0
+
+const (
+	valueInline byte = 0
+	valueRef    byte = 1
+
+	valueRefSize = 1 + 8 + 4
+)
+
+// valueLog - opened read-only .vlog file
+type valueLog struct {
+	f           *os.File
+	data        []byte // mmap-ed, nil for empty file
+	mmapHandle2 *[mmap.MaxMapSize]byte
+	filePath    string
+	fileName    string
+}
+
+func openValueLog(filePath string) (*valueLog, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l := &valueLog{f: f, filePath: filePath, fileName: filepath.Base(filePath)}
+	if stat.Size() > 0 {
+		if l.data, l.mmapHandle2, err = mmap.Mmap(f, int(stat.Size())); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("mmap %s: %w", l.fileName, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *valueLog) FileName() string { return l.fileName }
+func (l *valueLog) FilePath() string { return l.filePath }
+func (l *valueLog) Size() int64      { return int64(len(l.data)) }
+
+func (l *valueLog) Close() {
+	if l == nil {
+		return
+	}
+	if l.data != nil {
+		if err := mmap.Munmap(l.data, l.mmapHandle2); err != nil {
+			log.Trace("munmap", "err", err, "file", l.fileName)
+		}
+		l.data = nil
+	}
+	if err := l.f.Close(); err != nil {
+		log.Trace("close", "err", err, "file", l.fileName)
+	}
+}
+
+// value - resolves tagged value-word. nil receiver: file has no .vlog, value-word is returned as-is.
+// Referenced values are copied: they may outlive file.
+func (l *valueLog) value(v []byte) ([]byte, error) {
+	if l == nil || len(v) == 0 {
+		return v, nil
+	}
+	if v[0] == valueInline {
+		return v[1:], nil
+	}
+	return l.appendValue(nil, v)
+}
+
+// appendValue - appends resolved tagged value-word to `buf`
+func (l *valueLog) appendValue(buf, v []byte) ([]byte, error) {
+	if l == nil || len(v) == 0 {
+		return append(buf, v...), nil
+	}
+	switch v[0] {
+	case valueInline:
+		return append(buf, v[1:]...), nil
+	case valueRef:
+		if len(v) != valueRefSize {
+			return nil, fmt.Errorf("%s: invalid reference %x", l.fileName, v)
+		}
+		offset, size := binary.BigEndian.Uint64(v[1:]), uint64(binary.BigEndian.Uint32(v[9:]))
+		if offset+size > uint64(len(l.data)) {
+			return nil, fmt.Errorf("%s: reference %d+%d out of file size %d", l.fileName, offset, size, len(l.data))
+		}
+		return append(buf, l.data[offset:offset+size]...), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown value tag %d", l.fileName, v[0])
+	}
+}
+
+// appendWord - appends value-word of .kv (prefixed by step if `stepMeta`) with resolved reference to `buf`
+func (l *valueLog) appendWord(buf, v []byte, stepMeta bool) ([]byte, error) {
+	if stepMeta {
+		if len(v) < 8 {
+			return nil, fmt.Errorf("%s: value %x has no step", l.fileName, v)
+		}
+		buf, v = append(buf, v[:8]...), v[8:]
+	}
+	return l.appendValue(buf, v)
+}
+
+// valueLogWriter - writes .vlog file of .kv which is being built. File appears on disk only after finish.
+type valueLogWriter struct {
+	filePath  string
+	f         *os.File
+	w         *bufio.Writer
+	offset    uint64
+	threshold int
+	noFsync   bool
+	buf       []byte
+}
+
+func newValueLogWriter(filePath string, threshold int) (*valueLogWriter, error) {
+	f, err := os.Create(filePath + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &valueLogWriter{filePath: filePath, f: f, w: bufio.NewWriterSize(f, 1024*1024), threshold: threshold}, nil
+}
+
+func (w *valueLogWriter) DisableFsync() { w.noFsync = true }
+
+// appendValue - appends tagged value-word of `v` to `buf`: big values are written to .vlog
+func (w *valueLogWriter) appendValue(buf, v []byte) ([]byte, error) {
+	if len(v) == 0 { // deletion
+		return buf, nil
+	}
+	if len(v) < w.threshold {
+		return append(append(buf, valueInline), v...), nil
+	}
+	if _, err := w.w.Write(v); err != nil {
+		return nil, fmt.Errorf("write %s: %w", filepath.Base(w.filePath), err)
+	}
+	buf = append(buf, valueRef)
+	buf = binary.BigEndian.AppendUint64(buf, w.offset)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+	w.offset += uint64(len(v))
+	return buf, nil
+}
+
+// word - value-word of .kv for value `v` (prefixed by step if `stepMeta`). nil receiver: `v` as-is.
+// Result is valid until next call.
+func (w *valueLogWriter) word(v []byte, stepMeta bool) (_ []byte, err error) {
+	if w == nil {
+		return v, nil
+	}
+	w.buf = w.buf[:0]
+	if stepMeta {
+		w.buf, v = append(w.buf, v[:8]...), v[8:]
+	}
+	if w.buf, err = w.appendValue(w.buf, v); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// finish - makes .vlog visible on disk and opens it for reading
+func (w *valueLogWriter) finish() (*valueLog, error) {
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+	if !w.noFsync {
+		if err := w.f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.f.Close(); err != nil {
+		return nil, err
+	}
+	w.f = nil
+	if err := os.Rename(w.filePath+".tmp", w.filePath); err != nil {
+		return nil, err
+	}
+	return openValueLog(w.filePath)
+}
+
+// Close - removes unfinished file
+func (w *valueLogWriter) Close() {
+	if w == nil || w.f == nil {
+		return
+	}
+	w.f.Close()
+	w.f = nil
+	removeFile(w.filePath + ".tmp")
+}
+
+// newValueLogWriter - writer of .vlog for .kv file at `valuesPath`, marks `comp` of .kv as having tagged values.
+// nil if value-log is disabled
+func (d *Domain) newValueLogWriter(comp *seg.Compressor, valuesPath string) (*valueLogWriter, error) {
+	vlogPath := strings.TrimSuffix(valuesPath, "kv") + "vlog"
+	if d.valueLogThreshold <= 0 {
+		removeFile(vlogPath) // leftover of previous build of same range
+		return nil, nil
+	}
+	comp.SetFeatures(comp.Features() | kvFeatureValueLog)
+	w, err := newValueLogWriter(vlogPath, d.valueLogThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("create %s value-log: %w", d.filenameBase, err)
+	}
+	if d.noFsync {
+		w.DisableFsync()
+	}
+	return w, nil
+}
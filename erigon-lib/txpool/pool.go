@@ -229,9 +229,16 @@ type TxPool struct {
 	isPostCancun            atomic.Bool
 	maxBlobsPerBlock        uint64
 	feeCalculator           FeeCalculator
+	pendingState            PendingState // guarded by lock
 	logger                  log.Logger
 }
 
+// PendingState - read-only view of state of block in progress: executed, but not committed to DB yet
+type PendingState interface {
+	// PendingAccount - account in storage encoding, ok=false - account is not changed by block in progress
+	PendingAccount(addr common.Address) (enc []byte, ok bool)
+}
+
 type FeeCalculator interface {
 	CurrentFees(chainConfig *chain.Config, db kv.Getter) (baseFee uint64, blobFee uint64, minBlobGasPrice, blockGasLimit uint64, err error)
 }
@@ -700,6 +707,28 @@ func (p *TxPool) IsLocal(idHash []byte) bool {
 	defer p.lock.Unlock()
 	return p.isLocalLRU.Contains(hashS)
 }
+
+// SetPendingState - new txs are validated (nonce, balance) against state of block in progress, falling back to
+// last committed block. nil - only committed state is used.
+func (p *TxPool) SetPendingState(s PendingState) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pendingState = s
+}
+
+// pendingSenderInfo - like sendersBatch.info, but sees changes of block in progress
+func (p *TxPool) pendingSenderInfo(stateCache kvcache.CacheView, id uint64) (nonce uint64, balance uint256.Int, err error) {
+	if p.pendingState != nil {
+		if enc, ok := p.pendingState.PendingAccount(p.senders.senderID2Addr[id]); ok {
+			if len(enc) == 0 {
+				return emptySender.nonce, emptySender.balance, nil
+			}
+			return types.DecodeSender(enc)
+		}
+	}
+	return p.senders.info(stateCache, id)
+}
+
 func (p *TxPool) AddNewGoodPeer(peerID types.PeerID) { p.recentlyConnectedPeers.AddPeer(peerID) }
 func (p *TxPool) Started() bool                      { return p.started.Load() }
 
@@ -917,7 +946,7 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 	}
 
 	// check nonce and balance
-	senderNonce, senderBalance, _ := p.senders.info(stateCache, txn.SenderID)
+	senderNonce, senderBalance, _ := p.pendingSenderInfo(stateCache, txn.SenderID)
 	if senderNonce > txn.Nonce {
 		if txn.Traced {
 			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx nonce too low idHash=%x nonce in state=%d, txn.nonce=%d", txn.IDHash, senderNonce, txn.Nonce))
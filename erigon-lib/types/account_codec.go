@@ -0,0 +1,175 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+// AccountCodec - encoding of accounts in state (accounts domain and its history).
+// Chosen per chain (chain.Config.AccountCodec): commitment and RPC decode accounts only via codec,
+// so alternative encodings (RLP-compatible, EIP-7702-aware, ...) don't require changes there.
+type AccountCodec interface {
+	Name() string
+	// EncodeLen, EncodeTo - empty `codeHash` means account without code
+	EncodeLen(nonce uint64, balance *uint256.Int, codeHash []byte, incarnation uint64) int
+	EncodeTo(buf []byte, nonce uint64, balance *uint256.Int, codeHash []byte, incarnation uint64)
+	// Decode - writes balance to given `balance`. Returned `codeHash` is nil for account without code, or sub-slice of `enc`
+	Decode(enc []byte, balance *uint256.Int) (nonce uint64, codeHash []byte, incarnation uint64, err error)
+}
+
+// AccountCodecV3 - default encoding: length-prefixed nonce, balance, codeHash and incarnation
+var AccountCodecV3 AccountCodec = accountCodecV3{}
+
+var (
+	accountCodecsLock sync.RWMutex
+	accountCodecs     = map[string]AccountCodec{AccountCodecV3.Name(): AccountCodecV3}
+)
+
+// RegisterAccountCodec - makes codec available for chain.Config.AccountCodec. Usually called from init()
+func RegisterAccountCodec(c AccountCodec) {
+	accountCodecsLock.Lock()
+	defer accountCodecsLock.Unlock()
+	if _, ok := accountCodecs[c.Name()]; ok {
+		panic(fmt.Sprintf("account codec %q registered twice", c.Name()))
+	}
+	accountCodecs[c.Name()] = c
+}
+
+// AccountCodecByName - empty name is AccountCodecV3
+func AccountCodecByName(name string) (AccountCodec, error) {
+	if name == "" {
+		return AccountCodecV3, nil
+	}
+	accountCodecsLock.RLock()
+	defer accountCodecsLock.RUnlock()
+	c, ok := accountCodecs[name]
+	if !ok {
+		names := make([]string, 0, len(accountCodecs))
+		for n := range accountCodecs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown account codec %q, known: %v", name, names)
+	}
+	return c, nil
+}
+
+type accountCodecV3 struct{}
+
+func (accountCodecV3) Name() string { return "v3" }
+
+func (accountCodecV3) EncodeLen(nonce uint64, balance *uint256.Int, codeHash []byte, incarnation uint64) int {
+	l := 4
+	if nonce > 0 {
+		l += common.BitLenToByteLen(bits.Len64(nonce))
+	}
+	if !balance.IsZero() {
+		l += balance.ByteLen()
+	}
+	if len(codeHash) > 0 {
+		l += length.Hash
+	}
+	if incarnation > 0 {
+		l += common.BitLenToByteLen(bits.Len64(incarnation))
+	}
+	return l
+}
+
+func (accountCodecV3) EncodeTo(buf []byte, nonce uint64, balance *uint256.Int, codeHash []byte, incarnation uint64) {
+	pos := putUint64V3(buf, nonce)
+	if balance.IsZero() {
+		buf[pos] = 0
+		pos++
+	} else {
+		balanceBytes := balance.ByteLen()
+		buf[pos] = byte(balanceBytes)
+		pos++
+		balance.WriteToSlice(buf[pos : pos+balanceBytes])
+		pos += balanceBytes
+	}
+	if len(codeHash) == 0 {
+		buf[pos] = 0
+		pos++
+	} else {
+		buf[pos] = length.Hash
+		pos++
+		copy(buf[pos:pos+length.Hash], codeHash)
+		pos += length.Hash
+	}
+	putUint64V3(buf[pos:], incarnation)
+}
+
+// putUint64V3 - length byte, then big-endian bytes without leading zeroes
+func putUint64V3(buf []byte, x uint64) int {
+	if x == 0 {
+		buf[0] = 0
+		return 1
+	}
+	n := common.BitLenToByteLen(bits.Len64(x))
+	buf[0] = byte(n)
+	for i := n; i > 0; i-- {
+		buf[i] = byte(x)
+		x >>= 8
+	}
+	return n + 1
+}
+
+func (accountCodecV3) Decode(enc []byte, balance *uint256.Int) (nonce uint64, codeHash []byte, incarnation uint64, err error) {
+	balance.Clear()
+	pos := 0
+	field := func() ([]byte, error) {
+		if pos >= len(enc) {
+			return nil, fmt.Errorf("decode account v3: %d >= %d, enc=%x", pos, len(enc), enc)
+		}
+		l := int(enc[pos])
+		pos++
+		if pos+l > len(enc) {
+			return nil, fmt.Errorf("decode account v3: %d > %d, enc=%x", pos+l, len(enc), enc)
+		}
+		pos += l
+		return enc[pos-l : pos], nil
+	}
+	var b []byte
+	if b, err = field(); err != nil {
+		return 0, nil, 0, err
+	}
+	nonce = bytesToUint64(b)
+	if b, err = field(); err != nil {
+		return 0, nil, 0, err
+	}
+	balance.SetBytes(b)
+	if codeHash, err = field(); err != nil {
+		return 0, nil, 0, err
+	}
+	if len(codeHash) == 0 {
+		codeHash = nil
+	}
+	if b, err = field(); err != nil {
+		return 0, nil, 0, err
+	}
+	incarnation = bytesToUint64(b)
+	return nonce, codeHash, incarnation, nil
+}
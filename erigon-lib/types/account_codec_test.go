@@ -0,0 +1,59 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+func TestAccountCodecV3(t *testing.T) {
+	c, err := AccountCodecByName("")
+	require.NoError(t, err)
+	require.Equal(t, "v3", c.Name())
+	_, err = AccountCodecByName("no-such-codec")
+	require.Error(t, err)
+
+	codeHash := common.HexToHash("0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470")
+	for _, tc := range []struct {
+		nonce, incarnation uint64
+		balance            *uint256.Int
+		codeHash           []byte
+	}{
+		{0, 0, uint256.NewInt(0), nil},
+		{1, 0, uint256.NewInt(1_000_000), nil},
+		{1 << 40, 2, new(uint256.Int).Lsh(uint256.NewInt(1), 200), codeHash[:]},
+	} {
+		buf := make([]byte, c.EncodeLen(tc.nonce, tc.balance, tc.codeHash, tc.incarnation))
+		c.EncodeTo(buf, tc.nonce, tc.balance, tc.codeHash, tc.incarnation)
+
+		balance := uint256.NewInt(7) // must be overwritten
+		nonce, ch, incarnation, err := c.Decode(buf, balance)
+		require.NoError(t, err)
+		require.Equal(t, tc.nonce, nonce)
+		require.Equal(t, tc.incarnation, incarnation)
+		require.Equal(t, tc.balance, balance)
+		require.Equal(t, tc.codeHash, ch)
+
+		_, _, _, err = c.Decode(buf[:len(buf)-1], balance)
+		require.Error(t, err)
+	}
+}
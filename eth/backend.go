@@ -21,6 +21,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math"
 	"math/big"
@@ -50,6 +51,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/chain/snapcfg"
 	libcommon "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/direct"
 	"github.com/ledgerwatch/erigon-lib/downloader"
 	"github.com/ledgerwatch/erigon-lib/downloader/downloadercfg"
@@ -93,8 +95,10 @@ import (
 	"github.com/ledgerwatch/erigon/core/vm"
 	"github.com/ledgerwatch/erigon/crypto"
 	"github.com/ledgerwatch/erigon/eth/ethconfig"
+	"github.com/ledgerwatch/erigon/eth/ethconfig/estimate"
 	"github.com/ledgerwatch/erigon/eth/ethconsensusconfig"
 	"github.com/ledgerwatch/erigon/eth/ethutils"
+	"github.com/ledgerwatch/erigon/eth/integrity"
 	"github.com/ledgerwatch/erigon/eth/protocols/eth"
 	"github.com/ledgerwatch/erigon/eth/stagedsync"
 	"github.com/ledgerwatch/erigon/eth/stagedsync/stages"
@@ -118,6 +122,7 @@ import (
 	"github.com/ledgerwatch/erigon/turbo/engineapi/engine_helpers"
 	"github.com/ledgerwatch/erigon/turbo/execution/eth1"
 	"github.com/ledgerwatch/erigon/turbo/execution/eth1/eth1_chain_reader.go"
+	"github.com/ledgerwatch/erigon/turbo/firehose"
 	"github.com/ledgerwatch/erigon/turbo/jsonrpc"
 	"github.com/ledgerwatch/erigon/turbo/services"
 	"github.com/ledgerwatch/erigon/turbo/shards"
@@ -137,8 +142,11 @@ type Ethereum struct {
 	config *ethconfig.Config
 
 	// DB interfaces
-	chainDB    kv.RwDB
-	privateAPI *grpc.Server
+	chainDB       kv.RwDB
+	privateAPI    *grpc.Server
+	stateQueryAPI *grpc.Server
+	firehoseAPI   *grpc.Server
+	firehose      *firehose.Printer
 
 	engine consensus.Engine
 
@@ -279,6 +287,7 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 
 	var chainConfig *chain.Config
 	var genesis *types.Block
+	var accountCodec types2.AccountCodec
 	if err := backend.chainDB.Update(context.Background(), func(tx kv.RwTx) error {
 		h, err := rawdb.ReadCanonicalHash(tx, 0)
 		if err != nil {
@@ -287,6 +296,16 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 		genesisSpec := config.Genesis
 		if h != (libcommon.Hash{}) { // fallback to db content
 			genesisSpec = nil
+			// datadir initialised before account codec was persisted: only default one existed
+			codecName, err := kvcfg.AccountCodec.GetString(tx)
+			if err != nil {
+				return err
+			}
+			if codecName == "" {
+				if err := kvcfg.AccountCodec.EnsureNotChangedString(tx, types2.AccountCodecV3.Name()); err != nil {
+					return err
+				}
+			}
 		}
 		var genesisErr error
 		chainConfig, genesis, genesisErr = core.WriteGenesisBlock(tx, genesisSpec, config.OverrideCancunTime, tmpdir, logger)
@@ -294,7 +313,11 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 			return genesisErr
 		}
 
-		return nil
+		// files and tables are encoded by codec of datadir: it can't be changed by chain config later
+		if accountCodec, err = types2.AccountCodecByName(chainConfig.AccountCodec); err != nil {
+			return err
+		}
+		return kvcfg.AccountCodec.EnsureNotChangedString(tx, accountCodec.Name())
 	}); err != nil {
 		panic(err)
 	}
@@ -326,8 +349,16 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 		return nil, err
 	}
 	backend.agg, backend.blockSnapshots, backend.blockReader, backend.blockWriter = agg, allSnapshots, blockReader, blockWriter
+	agg.SetAccountCodec(accountCodec)
+
+	if config.VerifyDatadir {
+		if err := integrity.Datadir(ctx, chainKv, agg, blockReader, config.Dirs, estimate.CompressSnapshot.Workers(), logger); err != nil {
+			return nil, err
+		}
+	}
 
 	if config.HistoryV3 {
+		agg.SetReadLimits(stack.Config().Http.StateReadsLimit, 0)
 		backend.chainDB, err = temporal.New(backend.chainDB, agg, systemcontracts.SystemContractCodeLookup[config.Genesis.Config.ChainName])
 		if err != nil {
 			return nil, err
@@ -342,6 +373,35 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 	kvRPC := remotedbserver.NewKvServer(ctx, backend.chainDB, allSnapshots, allBorSnapshots, agg, logger)
 	backend.notifications.StateChangesConsumer = kvRPC
 	backend.kvRPC = kvRPC
+	if config.FirehoseOutput != "" || config.FirehoseAddr != "" {
+		var out io.Writer
+		switch config.FirehoseOutput {
+		case "":
+		case "stdout":
+			out = os.Stdout
+		default:
+			// open for whole process lifetime, closed by Printer.Close
+			if out, err = os.OpenFile(config.FirehoseOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+				return nil, err
+			}
+		}
+		headers := func(ctx context.Context, number uint64, hash libcommon.Hash) (h *types.Header, err error) {
+			if err := backend.chainDB.View(ctx, func(tx kv.Tx) error {
+				h, err = blockReader.Header(ctx, tx, hash, number)
+				return err
+			}); err != nil {
+				return nil, err
+			}
+			if h == nil {
+				return nil, fmt.Errorf("header not found")
+			}
+			return h, nil
+		}
+		if backend.firehose, err = firehose.NewPrinter(out, headers, kvRPC, logger); err != nil {
+			return nil, err
+		}
+		backend.notifications.StateChangesConsumer = backend.firehose
+	}
 
 	backend.gasPrice, _ = uint256.FromBig(config.Miner.GasPrice)
 
@@ -606,6 +666,7 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 		if err != nil {
 			return nil, err
 		}
+		backend.txPool.SetPendingState(backend.notifications.Accumulator)
 	}
 
 	backend.notifyMiningAboutNewTxs = make(chan struct{}, 1)
@@ -697,6 +758,28 @@ func New(ctx context.Context, stack *node.Node, config *ethconfig.Config, logger
 			return nil, fmt.Errorf("private api: %w", err)
 		}
 	}
+	if stack.Config().StateApiAddr != "" {
+		if creds == nil && stack.Config().TLSConnection {
+			if creds, err = grpcutil.TLS(stack.Config().TLSCACert, stack.Config().TLSCertFile, stack.Config().TLSKeyFile); err != nil {
+				return nil, err
+			}
+		}
+		backend.stateQueryAPI, err = privateapi.StartStateQueryGrpc(kvRPC, stack.Config().StateApiAddr, stack.Config().StateApiToken, stack.Config().PrivateApiRateLimit, creds, logger)
+		if err != nil {
+			return nil, fmt.Errorf("state query api: %w", err)
+		}
+	}
+	if config.FirehoseAddr != "" {
+		if creds == nil && stack.Config().TLSConnection {
+			if creds, err = grpcutil.TLS(stack.Config().TLSCACert, stack.Config().TLSCertFile, stack.Config().TLSKeyFile); err != nil {
+				return nil, err
+			}
+		}
+		backend.firehoseAPI, err = firehose.StartGrpc(backend.firehose, config.FirehoseAddr, stack.Config().PrivateApiRateLimit, creds, logger)
+		if err != nil {
+			return nil, fmt.Errorf("firehose api: %w", err)
+		}
+	}
 
 	if currentBlock == nil {
 		currentBlock = genesis
@@ -905,7 +988,11 @@ func (s *Ethereum) Init(stack *node.Node, config *ethconfig.Config, chainConfig
 		}
 	}
 
-	s.apiList = jsonrpc.APIList(chainKv, ethRpcClient, txPoolRpcClient, miningRpcClient, ff, stateCache, blockReader, s.agg, &httpRpcCfg, s.engine, s.logger)
+	rpcKv := chainKv
+	if tdb, ok := chainKv.(*temporal.DB); ok { // RPC lookups don't compete with sync, see --rpc.state.reads.limit
+		rpcKv = tdb.WithReadClass(libstate.ReadClassRPC)
+	}
+	s.apiList = jsonrpc.APIList(rpcKv, ethRpcClient, txPoolRpcClient, miningRpcClient, ff, stateCache, blockReader, s.agg, &httpRpcCfg, s.engine, s.logger)
 
 	if config.SilkwormRpcDaemon && httpRpcCfg.Enabled {
 		silkwormRPCDaemonService := silkworm.NewRpcDaemonService(s.silkworm, chainKv)
@@ -1273,6 +1360,18 @@ func (s *Ethereum) setUpSnapDownloader(ctx context.Context, downloaderCfg *downl
 			}
 		}
 	})
+	s.agg.OnFilesDelete(func(deletedFileNames []string) {
+		if s.downloaderClient == nil {
+			return
+		}
+		req := &proto_downloader.DeleteRequest{Paths: make([]string, 0, len(deletedFileNames))}
+		for _, fName := range deletedFileNames {
+			req.Paths = append(req.Paths, filepath.Join("history", fName))
+		}
+		if _, err := s.downloaderClient.Delete(ctx, req); err != nil {
+			s.logger.Warn("[snapshots] notify downloader", "err", err)
+		}
+	})
 	return err
 }
 
@@ -1311,6 +1410,43 @@ func setUpBlockReader(ctx context.Context, db kv.RwDB, dirs datadir.Dirs, snConf
 	if err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
+	agg.SetSearchDirs(snConfig.Snapshot.StateSearchDirs)
+	if len(snConfig.Snapshot.StateWatchList) > 0 {
+		watchList := make([][]byte, 0, len(snConfig.Snapshot.StateWatchList))
+		for _, addr := range snConfig.Snapshot.StateWatchList {
+			watchList = append(watchList, libcommon.HexToAddress(addr).Bytes())
+		}
+		agg.SetWatchList(watchList)
+	}
+	if len(snConfig.Snapshot.StateDBWatchList) > 0 {
+		watched := make(map[libcommon.Address]struct{}, len(snConfig.Snapshot.StateDBWatchList))
+		for _, addr := range snConfig.Snapshot.StateDBWatchList {
+			watched[libcommon.HexToAddress(addr)] = struct{}{}
+		}
+		keep := func(key []byte) bool {
+			_, ok := watched[libcommon.BytesToAddress(key)]
+			return len(key) == length.Addr && ok
+		}
+		for _, idx := range []kv.InvertedIdx{kv.TblLogAddressIdx, kv.TblTracesFromIdx, kv.TblTracesToIdx} {
+			if err := agg.SetPruneIdxKeys(idx, keep); err != nil {
+				return nil, nil, nil, nil, nil, err
+			}
+		}
+	}
+	agg.SetKeepSteps(snConfig.Snapshot.StateKeepSteps)
+	if snConfig.Snapshot.StateDBKeep > 0 {
+		agg.KeepInDB(snConfig.Snapshot.StateDBKeep * ethconfig.HistoryV3AggregationStep)
+	}
+	for name, steps := range snConfig.Snapshot.StateDBKeepSteps {
+		if err := agg.KeepStepsInDB(name, steps); err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+	}
+	madv, err := libstate.ParseMadvConfig(snConfig.Snapshot.StateMadvise)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("--%s: %w", ethconfig.FlagSnapStateMadvise, err)
+	}
+	agg.SetMadvConfig(madv)
 	if err = agg.OpenFolder(); err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
@@ -1414,6 +1550,12 @@ func (s *Ethereum) Stop() error {
 		case <-shutdownDone:
 		}
 	}
+	if s.stateQueryAPI != nil {
+		s.stateQueryAPI.Stop()
+	}
+	if s.firehoseAPI != nil {
+		s.firehoseAPI.Stop()
+	}
 	libcommon.SafeClose(s.sentriesClient.Hd.QuitPoWMining)
 	_ = s.engine.Close()
 	if s.waitForStageLoopStop != nil {
@@ -1422,6 +1564,11 @@ func (s *Ethereum) Stop() error {
 	if s.config.Miner.Enabled {
 		<-s.waitForMiningStop
 	}
+	if s.firehose != nil {
+		if err := s.firehose.Close(); err != nil {
+			s.logger.Error("firehose.Close error", "err", err)
+		}
+	}
 	for _, sentryServer := range s.sentryServers {
 		sentryServer.Close()
 	}
@@ -1452,6 +1599,9 @@ func (s *Ethereum) Stop() error {
 	return nil
 }
 
+// Agg - nil if HistoryV3 is not enabled
+func (s *Ethereum) Agg() *libstate.AggregatorV3 { return s.agg }
+
 func (s *Ethereum) ChainDB() kv.RwDB {
 	return s.chainDB
 }
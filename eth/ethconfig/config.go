@@ -18,11 +18,13 @@
 package ethconfig
 
 import (
+	"fmt"
 	"math/big"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -135,12 +137,27 @@ func init() {
 //go:generate gencodec -dir . -type Config -formats toml -out gen_config.go
 
 type BlocksFreezing struct {
-	Enabled        bool
-	KeepBlocks     bool // produce new snapshots of blocks but don't remove blocks from DB
-	Produce        bool // produce new snapshots
-	NoDownloader   bool // possible to use snapshots without calling Downloader
-	Verify         bool // verify snapshots on startup
-	DownloaderAddr string
+	Enabled          bool
+	KeepBlocks       bool // produce new snapshots of blocks but don't remove blocks from DB
+	Produce          bool // produce new snapshots
+	NoDownloader     bool // possible to use snapshots without calling Downloader
+	Verify           bool // verify snapshots on startup
+	DownloaderAddr   string
+	Provider         string            // source of snapshot files: torrent (default), http, rsync. See snapshotsync.NewProvider
+	ProviderURL      string            // base url of http/rsync provider
+	StateFromStep    uint64            // download only state history files of steps >= StateFromStep. 0 - download all
+	StateSearchDirs  []string          // additional read-only dirs with state history files, searched after datadir in order
+	StateWatchList   []string          // if set - new state history files keep history only of these addresses
+	StateDBWatchList []string          // if set - DB-part of logAddrs/tracesFrom/tracesTo keeps postings only of these addresses
+	StateKeepSteps   uint64            // if set - state history files older than last StateKeepSteps steps are deleted. 0 - keep all
+	StateDBKeepSteps map[string]uint64 // history/index name -> steps which stay in DB after files are built
+	StateDBKeep      uint64            // steps of recent state history which stay in DB before files are built, see AggregatorV3.KeepInDB. 0 - default (2 steps)
+	StateMadvise     string            // madvise policy per state file type, see state.ParseMadvConfig. "" - defaults
+	StatePeers       []string          // urls of manifests of trusted peers: state files which are missing locally are requested from Downloader
+	StatePeerSigners []string          // hex ed25519 keys which may sign manifests of StatePeers (in addition to known publishers of chain)
+	ManifestSigners  []string          // hex ed25519 keys of publishers of chain's manifest, in addition to snapcfg.KnownManifestSigners
+	ManifestSources  []string          // base urls (webseeds) where publisher's manifest is fetched from, see snaptype.ManifestFileName
+	ExpireBefore     uint64            // history expiry (EIP-4444): block and state history files of blocks before it are deleted once executed. 0 - keep all
 }
 
 func (s BlocksFreezing) String() string {
@@ -154,12 +171,70 @@ func (s BlocksFreezing) String() string {
 	if !s.Produce {
 		out = append(out, "--"+FlagSnapStop+"=true")
 	}
+	if s.Provider != "" && s.Provider != "torrent" { // url is not printed: may have credentials
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapProvider, s.Provider))
+	}
+	if s.StateFromStep > 0 {
+		out = append(out, fmt.Sprintf("--%s=%d", FlagSnapStateFromStep, s.StateFromStep))
+	}
+	if len(s.StateSearchDirs) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStateSearchDirs, strings.Join(s.StateSearchDirs, ",")))
+	}
+	if len(s.StateWatchList) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStateWatchList, strings.Join(s.StateWatchList, ",")))
+	}
+	if len(s.StateDBWatchList) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStateDBWatchList, strings.Join(s.StateDBWatchList, ",")))
+	}
+	if s.StateKeepSteps > 0 {
+		out = append(out, fmt.Sprintf("--%s=%d", FlagSnapStateKeepSteps, s.StateKeepSteps))
+	}
+	if len(s.StateDBKeepSteps) > 0 {
+		keep := make([]string, 0, len(s.StateDBKeepSteps))
+		for name, steps := range s.StateDBKeepSteps {
+			keep = append(keep, fmt.Sprintf("%s:%d", name, steps))
+		}
+		sort.Strings(keep)
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStateDBKeepSteps, strings.Join(keep, ",")))
+	}
+	if s.StateDBKeep > 0 {
+		out = append(out, fmt.Sprintf("--%s=%d", FlagSnapStateDBKeep, s.StateDBKeep))
+	}
+	if len(s.StatePeers) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStatePeers, strings.Join(s.StatePeers, ",")))
+	}
+	if len(s.StatePeerSigners) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStatePeerSigners, strings.Join(s.StatePeerSigners, ",")))
+	}
+	if len(s.ManifestSigners) > 0 {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapManifestSigners, strings.Join(s.ManifestSigners, ",")))
+	}
+	if s.StateMadvise != "" {
+		out = append(out, fmt.Sprintf("--%s=%s", FlagSnapStateMadvise, s.StateMadvise))
+	}
+	if s.ExpireBefore > 0 {
+		out = append(out, fmt.Sprintf("--%s=%d", FlagSnapExpireBefore, s.ExpireBefore))
+	}
 	return strings.Join(out, " ")
 }
 
 var (
-	FlagSnapKeepBlocks = "snap.keepblocks"
-	FlagSnapStop       = "snap.stop"
+	FlagSnapKeepBlocks       = "snap.keepblocks"
+	FlagSnapStop             = "snap.stop"
+	FlagSnapProvider         = "snap.provider"
+	FlagSnapProviderURL      = "snap.provider.url"
+	FlagSnapStateFromStep    = "snap.state.from.step"
+	FlagSnapStateSearchDirs  = "snap.state.search.dirs"
+	FlagSnapStateWatchList   = "snap.state.watchlist"
+	FlagSnapStateDBWatchList = "snap.state.db.watchlist"
+	FlagSnapStateKeepSteps   = "snap.state.keep.steps"
+	FlagSnapStateDBKeepSteps = "snap.state.db.keep.steps"
+	FlagSnapStateDBKeep      = "snap.state.db.keep"
+	FlagSnapStateMadvise     = "snap.state.madvise"
+	FlagSnapStatePeers       = "snap.state.peers"
+	FlagSnapStatePeerSigners = "snap.state.peers.signers"
+	FlagSnapManifestSigners  = "snap.manifest.signers"
+	FlagSnapExpireBefore     = "snap.expire.before"
 )
 
 func NewSnapCfg(enabled, keepBlocks, produce bool) BlocksFreezing {
@@ -227,6 +302,11 @@ type Config struct {
 
 	StateStream bool
 
+	// Path of file (or "stdout") to write blocks and their state changes in Firehose console reader framing. Empty - disabled
+	FirehoseOutput string
+	// Address of gRPC server which serves `sf.firehose.v2.Stream` of blocks and their state changes. Empty - disabled
+	FirehoseAddr string
+
 	//  New DB and Snapshots format of history allows: parallel blocks execution, get state as of given transaction without executing whole block.",
 	HistoryV3 bool
 
@@ -257,6 +337,8 @@ type Config struct {
 	SilkwormSentry    bool
 
 	DisableTxPoolGossip bool
+
+	VerifyDatadir bool // deep verification of datadir on startup (restored from backup or copied), see integrity.Datadir
 }
 
 type Sync struct {
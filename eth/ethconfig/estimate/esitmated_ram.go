@@ -1,8 +1,6 @@
 package estimate
 
 import (
-	"runtime"
-
 	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/mmap"
@@ -34,7 +32,7 @@ const (
 )
 
 // AlmostAllCPUs - return all-but-one cpus. Leaving 1 cpu for "work producer", also cloud-providers do recommend leave 1 CPU for their IO software
-// user can reduce GOMAXPROCS env variable
+// user can reduce GOMAXPROCS env variable. Respects cgroup CPU quota - containers must not oversubscribe CPU
 func AlmostAllCPUs() int {
-	return cmp.Max(1, runtime.GOMAXPROCS(-1)-1)
+	return cmp.Max(1, mmap.TotalCPUs()-1)
 }
@@ -0,0 +1,116 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/state"
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
+	"github.com/ledgerwatch/erigon/eth/stagedsync/stages"
+	"github.com/ledgerwatch/erigon/turbo/backup"
+	"github.com/ledgerwatch/erigon/turbo/services"
+	"github.com/ledgerwatch/erigon/turbo/trie"
+)
+
+// Datadir - deep verification of datadir restored from backup (or copied from other node), before node serves traffic:
+//   - sizes and hashes of state files: by manifest of backup (see backup.HotBackup), or by published snapshots manifest
+//   - state files are consistent and their accessor indices resolve all keys (see AggregatorV3.IntegrityCheck)
+//   - state root at head of IntermediateHashes stage matches root in header
+//
+// Slow: reads all state files and whole HashedState.
+func Datadir(ctx context.Context, db kv.RoDB, agg *state.AggregatorV3, br services.FullBlockReader, dirs datadir.Dirs, workers int, logger log.Logger) error {
+	start := time.Now()
+	logger.Info("[integrity] datadir verification started")
+
+	var m *snaptype.Manifest
+	if b, err := backup.ReadStateBackup(dirs); err == nil {
+		m = b.Manifest
+		missing, err := m.Missing(dirs.Snap, nil)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("[integrity] %d files of backup are missing or truncated, first: %s", len(missing), missing[0].Name)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if manifestPath := filepath.Join(dirs.Snap, snaptype.ManifestFileName); dir.FileExist(manifestPath) {
+		if m, err = snaptype.ReadManifest(manifestPath); err != nil {
+			return err
+		}
+	}
+	if m != nil {
+		if err := m.Verify(nil); err != nil {
+			return fmt.Errorf("[integrity] %w", err)
+		}
+		checked, err := m.VerifyFiles(ctx, dirs.Snap, snaptype.IsStateFile)
+		if err != nil {
+			return fmt.Errorf("[integrity] %w", err)
+		}
+		logger.Info("[integrity] state files match manifest", "files", checked)
+	} else {
+		logger.Warn("[integrity] no manifest in datadir: hashes of state files are not checked")
+	}
+
+	reports, err := agg.IntegrityCheck(ctx, 0, agg.EndTxNumMinimax()/ethconfig.HistoryV3AggregationStep+1, workers)
+	if err != nil {
+		return err
+	}
+	var broken int
+	for _, r := range reports {
+		if len(r.Errs) == 0 {
+			continue
+		}
+		broken++
+		for _, e := range r.Errs {
+			logger.Error("[integrity] state files", "files", r.Files, "err", e)
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("[integrity] %d of %d state files are broken", broken, len(reports))
+	}
+	logger.Info("[integrity] state files are consistent", "files", len(reports))
+
+	if err := db.View(ctx, func(tx kv.Tx) error { return checkRootAtHead(ctx, tx, br, logger) }); err != nil {
+		return err
+	}
+	logger.Info("[integrity] datadir verification done", "took", time.Since(start))
+	return nil
+}
+
+func checkRootAtHead(ctx context.Context, tx kv.Tx, br services.FullBlockReader, logger log.Logger) error {
+	head, err := stages.GetStageProgress(tx, stages.IntermediateHashes)
+	if err != nil {
+		return err
+	}
+	if head == 0 {
+		logger.Info("[integrity] state root not checked: IntermediateHashes stage not started yet")
+		return nil
+	}
+	header, err := br.HeaderByNumber(ctx, tx, head)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return fmt.Errorf("[integrity] header of head block %d not found", head)
+	}
+	root, err := trie.CalcRoot("integrity", tx)
+	if err != nil {
+		return err
+	}
+	if root != header.Root {
+		return fmt.Errorf("[integrity] state root mismatch at block %d: expected %x, got %x", head, header.Root, root)
+	}
+	logger.Info("[integrity] state root matches header", "block", head, "root", root)
+	return nil
+}
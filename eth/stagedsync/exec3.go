@@ -35,6 +35,7 @@ import (
 	"github.com/ledgerwatch/erigon/common/math"
 	"github.com/ledgerwatch/erigon/consensus"
 	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/rawdb"
 	"github.com/ledgerwatch/erigon/core/rawdb/rawdbhelpers"
 	"github.com/ledgerwatch/erigon/core/state"
 	"github.com/ledgerwatch/erigon/core/types"
@@ -233,7 +234,12 @@ func ExecV3(ctx context.Context,
 	var count uint64
 	var lock sync.RWMutex
 
-	rs := state.NewStateV3(cfg.dirs.Tmp, logger)
+	rs := state.NewStateV3(cfg.dirs.Tmp, cfg.agg.AccountCodec(), logger)
+	if cfg.accumulator != nil {
+		// let txpool validate new txs against state of block in progress
+		cfg.accumulator.SetPendingState(rs)
+		defer cfg.accumulator.SetPendingState(nil)
+	}
 
 	//TODO: owner of `resultCh` is main goroutine, but owner of `retryQueue` is applyLoop.
 	// Now rwLoop closing both (because applyLoop we completely restart)
@@ -336,6 +342,7 @@ func ExecV3(ctx context.Context,
 				case <-logEvery.C:
 					stepsInDB := rawdbhelpers.IdxStepsCountV3(tx)
 					progress.Log(rs, in, rws, rs.DoneCount(), inputBlockNum.Load(), outputBlockNum.GetValueUint64(), outputTxNum.Load(), execRepeats.GetValueUint64(), stepsInDB)
+					agg.UpdateLagMetrics(tx, outputTxNum.Load())
 					if agg.HasBackgroundFilesBuild() {
 						logger.Info(fmt.Sprintf("[%s] Background files build", logPrefix), "progress", agg.BackgroundProgress())
 					}
@@ -472,8 +479,8 @@ func ExecV3(ctx context.Context,
 	}
 
 	if block < cfg.blockReader.FrozenBlocks() {
+		defer agg.KeepInDB(agg.KeptInDB())
 		agg.KeepInDB(0)
-		defer agg.KeepInDB(ethconfig.HistoryV3AggregationStep)
 	}
 
 	getHeaderFunc := func(hash common.Hash, number uint64) (h *types.Header) {
@@ -506,16 +513,50 @@ func ExecV3(ctx context.Context,
 
 	stateStream := !initialCycle && cfg.stateStream && maxBlockNum-block < stateStreamLimit
 
-	var b *types.Block
+	var prefetcher *state.PrefetcherV3
+	if dbg.ExecPrefetch {
+		prefetcher = state.NewPrefetcherV3(ctx, chainDb, logger)
+		defer prefetcher.Close()
+	}
+
+	if dbg.ExecWAL && !parallel && !stateStream {
+		wal, err := state.OpenStateWAL(filepath.Join(cfg.dirs.DataDir, "exec.wal"))
+		if err != nil {
+			return err
+		}
+		defer wal.Close()
+		canonical := func(blockNum uint64) (common.Hash, error) { return rawdb.ReadCanonicalHash(applyTx, blockNum) }
+		lastBlock, lastTxNum, ok, err := rs.ReplayWAL(wal, inputTxNum, canonical, agg)
+		if err != nil {
+			return err
+		}
+		if ok {
+			logger.Info(fmt.Sprintf("[%s] Replayed WAL", logPrefix), "from", block, "to", lastBlock)
+			block, stageProgress, inputTxNum = lastBlock+1, lastBlock, lastTxNum+1
+			outputTxNum.Store(inputTxNum)
+			agg.SetTxNum(inputTxNum)
+		}
+	}
+
+	var b, nextB *types.Block
 	var blockNum uint64
 	var err error
 Loop:
 	for blockNum = block; blockNum <= maxBlockNum; blockNum++ {
 		inputBlockNum.Store(blockNum)
-		b, err = blockWithSenders(chainDb, applyTx, blockReader, blockNum)
-		if err != nil {
+		if nextB != nil && nextB.NumberU64() == blockNum {
+			b = nextB
+		} else if b, err = blockWithSenders(chainDb, applyTx, blockReader, blockNum); err != nil {
 			return err
 		}
+		if prefetcher != nil && blockNum < maxBlockNum {
+			if nextB, err = blockWithSenders(chainDb, applyTx, blockReader, blockNum+1); err != nil {
+				return err
+			}
+			if nextB != nil {
+				prefetcher.Hint(state.BlockPrefetchHints(nextB))
+			}
+		}
 		if b == nil {
 			// TODO: panic here and see that overall process deadlock
 			return fmt.Errorf("nil block %d", blockNum)
@@ -671,11 +712,15 @@ Loop:
 
 		if !parallel {
 			outputBlockNum.SetUint64(blockNum)
+			if err := rs.WALBlockEnd(blockNum, inputTxNum-1, b.Hash()); err != nil {
+				return err
+			}
 
 			select {
 			case <-logEvery.C:
 				stepsInDB := rawdbhelpers.IdxStepsCountV3(applyTx)
 				progress.Log(rs, in, rws, count, inputBlockNum.Load(), outputBlockNum.GetValueUint64(), outputTxNum.Load(), execRepeats.GetValueUint64(), stepsInDB)
+				agg.UpdateLagMetrics(applyTx, outputTxNum.Load())
 				if rs.SizeEstimate() < commitThreshold {
 					break
 				}
@@ -685,9 +730,12 @@ Loop:
 				if err := func() error {
 					t1 = time.Since(commitStart)
 					tt := time.Now()
-					if err := rs.Flush(ctx, applyTx, logPrefix, logEvery); err != nil {
+					// applyTx is not committed here: enough to flush largest tables, others stay in RAM
+					flushed, err := rs.FlushLargest(ctx, applyTx, commitThreshold/2, logPrefix, logEvery)
+					if err != nil {
 						return err
 					}
+					logger.Debug(fmt.Sprintf("[%s] Partial flush", logPrefix), "tables", flushed)
 					t2 = time.Since(tt)
 
 					tt = time.Now()
@@ -1333,6 +1381,17 @@ func safeCloseTxTaskCh(ch chan *exec22.TxTask) {
 	}
 }
 
+func reconHistoryAvailable(agg *libstate.AggregatorV3) error {
+	ac := agg.MakeContext()
+	defer ac.Close()
+	for _, name := range []kv.History{kv.AccountsHistory, kv.StorageHistory, kv.CodeHistory} {
+		if earliest := ac.HistoryEarliestTxNum(name); earliest > 0 {
+			return fmt.Errorf("%w: reconstitution needs %s from txNum=0, files start at %d", libstate.ErrHistoryNotAvailable, name, earliest)
+		}
+	}
+	return nil
+}
+
 func ReconstituteState(ctx context.Context, s *StageState, dirs datadir.Dirs, workerCount int, batchSize datasize.ByteSize, chainDb kv.RwDB,
 	blockReader services.FullBlockReader,
 	logger log.Logger, agg *libstate.AggregatorV3, engine consensus.Engine,
@@ -1346,6 +1405,11 @@ func ReconstituteState(ctx context.Context, s *StageState, dirs datadir.Dirs, wo
 		return err
 	}
 
+	// reconstitution replays history from txNum=0: partial history (snapshot sync from some step) is not enough
+	if err := reconHistoryAvailable(agg); err != nil {
+		return err
+	}
+
 	// Incremental reconstitution, step by step (snapshot range by snapshot range)
 	aggSteps, err := agg.MakeSteps()
 	if err != nil {
@@ -0,0 +1,216 @@
+package stagedsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
+	libtypes "github.com/ledgerwatch/erigon-lib/types"
+
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/state/temporal"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+// unwindVerifier - checks that after unwind PlainState has exactly values which history has as of unwind target.
+// Unwind restores PlainState from history range scan, so expected values are taken from independent path:
+// point lookups (inverted index seek + history read - same as RPC uses) of every key changed after unwind target.
+// Expected values must be collected before unwind: unwind prunes history of unwound txs.
+// Trie root of unwind target is verified by Trie stage unwind (against header).
+type unwindVerifier struct {
+	accounts, storage, code *etl.Collector
+	notInHistory            map[string]int // domain -> amount of keys which range scan has, but point lookup doesn't find
+	accountCodec            libtypes.AccountCodec
+	logger                  log.Logger
+}
+
+func newUnwindVerifier(ctx context.Context, tx kv.Tx, agg *libstate.AggregatorV3, txUnwindTo uint64, tmpdir, logPrefix string, logger log.Logger) (*unwindVerifier, error) {
+	var actx *libstate.AggregatorV3Context
+	switch ttx := tx.(type) {
+	case *temporal.Tx:
+		actx = ttx.AggCtx()
+	default:
+		actx = agg.MakeContext()
+		defer actx.Close()
+	}
+
+	v := &unwindVerifier{notInHistory: map[string]int{}, accountCodec: actx.AccountCodec(), logger: logger}
+	// collect - keys changed after txUnwindTo, with values as of txUnwindTo found by `asOf`
+	collect := func(domain string, it iter.KV, asOf func(k []byte) ([]byte, bool, error)) (*etl.Collector, error) {
+		keys := etl.NewCollector(logPrefix, tmpdir, etl.NewOldestEntryBuffer(etl.BufferOptimalSize), logger)
+		defer keys.Close()
+		for it.HasNext() {
+			k, _, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			if err := keys.Collect(k, nil); err != nil {
+				return nil, err
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+		}
+		c := etl.NewCollector(logPrefix, tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize), logger)
+		if err := keys.Load(nil, "", func(k, _ []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+			val, ok, err := asOf(k)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				v.notInHistory[domain]++
+				if v.notInHistory[domain] <= unwindVerifyLogLimit {
+					logger.Warn(fmt.Sprintf("[%s] unwind verification: key is not found in history", logPrefix), "domain", domain, "key", fmt.Sprintf("%x", k), "txNum", txUnwindTo)
+				}
+				return nil
+			}
+			return c.Collect(k, val)
+		}, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	it, err := actx.AccountHistoryRange(int(txUnwindTo), -1, order.Asc, -1, tx)
+	if err != nil {
+		return nil, err
+	}
+	if v.accounts, err = collect("accounts", it, func(k []byte) ([]byte, bool, error) {
+		return actx.ReadAccountDataNoStateWithRecent(k, txUnwindTo, tx)
+	}); err != nil {
+		return nil, err
+	}
+	if it, err = actx.StorageHistoryRange(int(txUnwindTo), -1, order.Asc, -1, tx); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if v.storage, err = collect("storage", it, func(k []byte) ([]byte, bool, error) {
+		return actx.ReadAccountStorageNoStateWithRecent2(k, txUnwindTo, tx)
+	}); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if it, err = actx.CodeHistoryRange(int(txUnwindTo), -1, order.Asc, -1, tx); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if v.code, err = collect("code", it, func(k []byte) ([]byte, bool, error) {
+		return actx.ReadAccountCodeNoStateWithRecent(k, txUnwindTo, tx)
+	}); err != nil {
+		v.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *unwindVerifier) Close() {
+	for _, c := range []*etl.Collector{v.accounts, v.storage, v.code} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+const unwindVerifyLogLimit = 10 // per domain
+
+// Verify - compares unwound PlainState with collected values, logs first discrepancies of every domain
+func (v *unwindVerifier) Verify(ctx context.Context, tx kv.Tx, logPrefix string) error {
+	reader := state.NewPlainStateReader(tx)
+	mismatches := map[string]int{}
+	for domain, n := range v.notInHistory {
+		mismatches[domain] += n
+	}
+	report := func(domain string, k, expected, got []byte) {
+		mismatches[domain]++
+		if mismatches[domain] <= unwindVerifyLogLimit {
+			v.logger.Warn(fmt.Sprintf("[%s] unwind verification: mismatch", logPrefix), "domain", domain, "key", fmt.Sprintf("%x", k), "expected", fmt.Sprintf("%x", expected), "got", fmt.Sprintf("%x", got))
+		}
+	}
+	args := etl.TransformArgs{Quit: ctx.Done()}
+
+	if err := v.accounts.Load(nil, "", func(k, expected []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		got, err := reader.ReadAccountData(common.BytesToAddress(k))
+		if err != nil {
+			return err
+		}
+		if len(expected) == 0 {
+			if got != nil {
+				report("accounts", k, nil, accounts.Serialise(v.accountCodec, got))
+			}
+			return nil
+		}
+		var acc accounts.Account
+		if err := accounts.Deserialise(v.accountCodec, &acc, expected); err != nil {
+			return fmt.Errorf("%w, %x", err, expected)
+		}
+		if got == nil || !got.Equals(&acc) {
+			var gotEnc []byte
+			if got != nil {
+				gotEnc = accounts.Serialise(v.accountCodec, got)
+			}
+			report("accounts", k, expected, gotEnc)
+		}
+		return nil
+	}, args); err != nil {
+		return err
+	}
+
+	if err := v.storage.Load(nil, "", func(k, expected []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		addr := common.BytesToAddress(k[:length.Addr])
+		acc, err := reader.ReadAccountData(addr)
+		if err != nil {
+			return err
+		}
+		var got []byte
+		if acc != nil {
+			loc := common.BytesToHash(k[length.Addr:])
+			if got, err = reader.ReadAccountStorage(addr, acc.Incarnation, &loc); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(got, expected) {
+			report("storage", k, expected, got)
+		}
+		return nil
+	}, args); err != nil {
+		return err
+	}
+
+	if err := v.code.Load(nil, "", func(k, expected []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		addr := common.BytesToAddress(k[:length.Addr])
+		acc, err := reader.ReadAccountData(addr)
+		if err != nil {
+			return err
+		}
+		var got []byte
+		if acc != nil {
+			if got, err = reader.ReadAccountCode(addr, acc.Incarnation, acc.CodeHash); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(got, expected) {
+			report("code", k, expected, got)
+		}
+		return nil
+	}, args); err != nil {
+		return err
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("unwind verification failed: mismatches per domain: %v", mismatches)
+	}
+	v.logger.Info(fmt.Sprintf("[%s] unwind verification: ok", logPrefix))
+	return nil
+}
@@ -327,18 +327,30 @@ func reconstituteBlock(agg *libstate.AggregatorV3, db kv.RoDB, tx kv.Tx) (n uint
 
 func unwindExec3(u *UnwindState, s *StageState, txc wrap.TxContainer, ctx context.Context, cfg ExecuteBlockCfg, accumulator *shards.Accumulator, logger log.Logger) (err error) {
 	cfg.agg.SetLogPrefix(s.LogPrefix())
-	rs := state.NewStateV3(cfg.dirs.Tmp, logger)
+	rs := state.NewStateV3(cfg.dirs.Tmp, cfg.agg.AccountCodec(), logger)
 	// unwind all txs of u.UnwindPoint block. 1 txn in begin/end of block - system txs
 	txNum, err := rawdbv3.TxNums.Min(txc.Tx, u.UnwindPoint+1)
 	if err != nil {
 		return err
 	}
+	var verifier *unwindVerifier
+	if dbg.VerifyUnwind {
+		if verifier, err = newUnwindVerifier(ctx, txc.Tx, cfg.agg, txNum, cfg.dirs.Tmp, s.LogPrefix(), logger); err != nil {
+			return err
+		}
+		defer verifier.Close()
+	}
 	if err := rs.Unwind(ctx, txc.Tx, u.UnwindPoint, txNum, cfg.agg, accumulator); err != nil {
 		return fmt.Errorf("StateV3.Unwind: %w", err)
 	}
 	if err := rs.Flush(ctx, txc.Tx, s.LogPrefix(), time.NewTicker(30*time.Second)); err != nil {
 		return fmt.Errorf("StateV3.Flush: %w", err)
 	}
+	if verifier != nil {
+		if err := verifier.Verify(ctx, txc.Tx, s.LogPrefix()); err != nil {
+			return fmt.Errorf("unwind to block %d: %w", u.UnwindPoint, err)
+		}
+	}
 
 	if err := rawdb.TruncateReceipts(txc.Tx, u.UnwindPoint+1); err != nil {
 		return fmt.Errorf("truncate receipts: %w", err)
@@ -25,6 +25,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ledgerwatch/erigon/common/math"
+	"github.com/ledgerwatch/erigon/core/state"
 	"github.com/ledgerwatch/erigon/core/types/accounts"
 	"github.com/ledgerwatch/erigon/eth/ethconfig/estimate"
 )
@@ -727,6 +728,10 @@ func (p *Promoter) UnwindOnHistoryV3(logPrefix string, unwindFrom, unwindTo uint
 	collector := etl.NewCollector(logPrefix, p.dirs.Tmp, etl.NewOldestEntryBuffer(etl.BufferOptimalSize), p.logger)
 	defer collector.Close()
 
+	accountCodec, err := state.AccountCodec(p.tx)
+	if err != nil {
+		return err
+	}
 	acc := accounts.NewAccount()
 	if codes {
 		it, err := p.tx.(kv.TemporalTx).HistoryRange(kv.AccountsHistory, int(txnFrom), int(txnTo), order.Asc, kv.Unlim)
@@ -742,7 +747,7 @@ func (p *Promoter) UnwindOnHistoryV3(logPrefix string, unwindFrom, unwindTo uint
 			if len(v) == 0 {
 				continue
 			}
-			if err := accounts.DeserialiseV3(&acc, v); err != nil {
+			if err := accounts.Deserialise(accountCodec, &acc, v); err != nil {
 				return err
 			}
 
@@ -818,7 +823,7 @@ func (p *Promoter) UnwindOnHistoryV3(logPrefix string, unwindFrom, unwindTo uint
 			}
 			continue
 		}
-		if err := accounts.DeserialiseV3(&acc, v); err != nil {
+		if err := accounts.Deserialise(accountCodec, &acc, v); err != nil {
 			return err
 		}
 		if acc.Incarnation > 0 && acc.IsEmptyCodeHash() {
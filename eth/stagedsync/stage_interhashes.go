@@ -113,6 +113,8 @@ func SpawnIntermediateHashesStage(s *StageState, u Unwinder, tx kv.RwTx, cfg Tri
 		}
 		tooBigJump = s.BlockNumber < n
 	}
+	_, span := state.StartCommitmentSpan(ctx, s.BlockNumber, to)
+	defer span.End()
 	if s.BlockNumber == 0 || tooBigJump {
 		if root, err = RegenerateIntermediateHashes(logPrefix, tx, cfg, expectedRootHash, ctx, logger); err != nil {
 			return trie.EmptyRoot, err
@@ -408,6 +410,10 @@ func (p *HashPromoter) UnwindOnHistoryV3(logPrefix string, unwindFrom, unwindTo
 	if err != nil {
 		return err
 	}
+	accountCodec, err := state.ReadAccountCodec(p.tx)
+	if err != nil {
+		return err
+	}
 	for it.HasNext() {
 		k, v, err := it.Next()
 		if err != nil {
@@ -430,7 +436,7 @@ func (p *HashPromoter) UnwindOnHistoryV3(logPrefix string, unwindFrom, unwindTo
 					deletedAccounts = append(deletedAccounts, newK)
 				} else {
 					var newAccount accounts.Account
-					if err = accounts.DeserialiseV3(&newAccount, v); err != nil {
+					if err = accounts.Deserialise(accountCodec, &newAccount, v); err != nil {
 						return err
 					}
 					if newAccount.Incarnation > oldInc {
@@ -459,6 +459,9 @@ func SnapshotsPrune(s *PruneState, initialCycle bool, cfg SnapshotsCfg, ctx cont
 		if err := cfg.blockRetire.PruneAncientBlocks(tx, cfg.syncConfig.PruneLimit); err != nil {
 			return err
 		}
+		if err := expireHistory(ctx, cfg, tx); err != nil {
+			return err
+		}
 	}
 
 	if cfg.snapshotUploader != nil {
@@ -492,6 +495,40 @@ func SnapshotsPrune(s *PruneState, initialCycle bool, cfg SnapshotsCfg, ctx cont
 	return nil
 }
 
+// expireHistory - history expiry (EIP-4444): deletes block files and state history files of blocks before configured cutoff.
+// Only after these blocks are executed (verified) and moved to files - until then it's no-op.
+func expireHistory(ctx context.Context, cfg SnapshotsCfg, tx kv.RwTx) error {
+	before := cfg.blockReader.FreezingCfg().ExpireBefore
+	if before == 0 {
+		return nil
+	}
+	executed, err := stages.GetStageProgress(tx, stages.Execution)
+	if err != nil {
+		return err
+	}
+	if executed < before || cfg.blockReader.FrozenBlocks() < before {
+		return nil
+	}
+	if err := cfg.blockRetire.ExpireBlocks(before, func(l []string) error {
+		if cfg.snapshotDownloader == nil || reflect.ValueOf(cfg.snapshotDownloader).IsNil() {
+			return nil
+		}
+		_, err := cfg.snapshotDownloader.Delete(ctx, &protodownloader.DeleteRequest{Paths: l})
+		return err
+	}); err != nil {
+		return err
+	}
+	if !cfg.historyV3 {
+		return nil
+	}
+	cutoff, err := rawdbv3.TxNums.Min(tx, before)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.agg.ExpireFilesBefore(cutoff)
+	return err
+}
+
 type uploadState struct {
 	sync.Mutex
 	file             string
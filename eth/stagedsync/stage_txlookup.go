@@ -14,6 +14,8 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
+	"github.com/ledgerwatch/erigon/core/state/temporal"
 	"github.com/ledgerwatch/erigon/polygon/bor/borcfg"
 	"github.com/ledgerwatch/erigon/turbo/services"
 
@@ -238,6 +240,11 @@ func PruneTxLookup(s *PruneState, tx kv.RwTx, cfg TxLookupCfg, ctx context.Conte
 	} else if cfg.blockReader.FreezingCfg().Enabled {
 		blockTo = cfg.blockReader.CanPruneTo(s.ForwardProgress)
 	}
+	idxPruneTo, err := txLookupIdxPruneTo(tx, blockFrom)
+	if err != nil {
+		return err
+	}
+	blockTo = cmp.Max(blockTo, cmp.Min(idxPruneTo, s.ForwardProgress))
 	// can't prune much here: because tx_lookup index has crypto-hashed-keys, and 1 block producing hundreds of deletes
 	blockTo = cmp.Min(blockTo, blockFrom+10)
 
@@ -265,6 +272,32 @@ func PruneTxLookup(s *PruneState, tx kv.RwTx, cfg TxLookupCfg, ctx context.Conte
 	return nil
 }
 
+// txLookupIdxPruneTo - with HistoryV3, TxLookup table is not needed for blocks which txs are in TxLookupIdx files
+func txLookupIdxPruneTo(tx kv.Tx, blockFrom uint64) (uint64, error) {
+	ttx, ok := tx.(*temporal.Tx)
+	if !ok {
+		return 0, nil
+	}
+	fromTxNum, toTxNum := ttx.AggCtx().TxLookupFilesRange()
+	if toTxNum == 0 {
+		return 0, nil
+	}
+	if fromTxNum > 0 {
+		minTxNum, err := rawdbv3.TxNums.Min(tx, blockFrom)
+		if err != nil {
+			return 0, err
+		}
+		if minTxNum < fromTxNum { // txs executed before TxLookupIdx appeared are only in TxLookup table
+			return 0, nil
+		}
+	}
+	ok, blockNum, err := rawdbv3.TxNums.FindBlockNum(tx, toTxNum)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return blockNum, nil // block of toTxNum may be partially in files
+}
+
 // deleteTxLookupRange - [blockFrom, blockTo)
 func deleteTxLookupRange(tx kv.RwTx, logPrefix string, blockFrom, blockTo uint64, ctx context.Context, cfg TxLookupCfg, logger log.Logger) error {
 	return etl.Transform(logPrefix, tx, kv.HeaderCanonical, kv.TxLookup, cfg.tmpdir, func(k, v []byte, next etl.ExtractNextFunc) error {
@@ -0,0 +1,104 @@
+package privateapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ledgerwatch/log/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv/remotedbserver"
+)
+
+// stateQueryMethods - read-only subset of KV service which is exposed to external indexers.
+// All of them are unary: TxId must be 0 - each request reads in own read transaction.
+// Tx stream is not here: it gives cursors over any table.
+//   - DomainGet: latest value (Latest=true) or value as of txNum (Ts)
+//   - HistoryGet, HistoryRange: values before change
+//   - IndexRange: txNums of key, paginated
+//   - DomainRange: prefix iteration, paginated: FromKey=prefix, ToKey=kv.NextSubtree(prefix)
+var stateQueryMethods = map[string]bool{
+	remote.KV_Version_FullMethodName:      true,
+	remote.KV_DomainGet_FullMethodName:    true,
+	remote.KV_HistoryGet_FullMethodName:   true,
+	remote.KV_IndexRange_FullMethodName:   true,
+	remote.KV_HistoryRange_FullMethodName: true,
+	remote.KV_DomainRange_FullMethodName:  true,
+}
+
+// StateQueryAuth - checks that method belongs to state query API and request has `authorization: Bearer <token>` metadata.
+// Empty token disables authentication.
+type StateQueryAuth struct {
+	token []byte
+}
+
+func NewStateQueryAuth(token string) *StateQueryAuth { return &StateQueryAuth{token: []byte(token)} }
+
+func (a *StateQueryAuth) check(ctx context.Context, method string) error {
+	if !stateQueryMethods[method] {
+		return status.Errorf(codes.PermissionDenied, "method %s is not part of state query API", method)
+	}
+	if len(a.token) == 0 {
+		return nil
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing authorization")
+	}
+	for _, v := range md.Get("authorization") {
+		token, found := strings.CutPrefix(v, "Bearer ")
+		if found && subtle.ConstantTimeCompare([]byte(token), a.token) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid authorization")
+}
+
+func (a *StateQueryAuth) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	if r, ok := req.(interface{ GetTxId() uint64 }); ok && r.GetTxId() != 0 { // don't read in transactions of private API clients
+		return nil, status.Error(codes.InvalidArgument, "state query API doesn't accept TxId")
+	}
+	return handler(ctx, req)
+}
+
+func (a *StateQueryAuth) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// StartStateQueryGrpc - standalone listener of state query API (see stateQueryMethods), separate from private API:
+// it can be exposed to indexers without exposing txpool, mining, ethbackend and raw tables access.
+func StartStateQueryGrpc(kv *remotedbserver.KvServer, addr, token string, rateLimit uint32, creds credentials.TransportCredentials, logger log.Logger) (*grpc.Server, error) {
+	logger.Info("Starting state query RPC server", "on", addr, "auth", token != "")
+	if token == "" {
+		logger.Warn("state query RPC server has no auth token, do not expose it to public network")
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create listener: %w, addr=%s", err, addr)
+	}
+
+	auth := NewStateQueryAuth(token)
+	grpcServer := grpcutil.NewServerWithInterceptors(rateLimit, creds, []grpc.UnaryServerInterceptor{auth.Unary}, []grpc.StreamServerInterceptor{auth.Stream})
+	remote.RegisterKVServer(grpcServer, kv)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("state query RPC server fail", "err", err)
+		}
+	}()
+	return grpcServer, nil
+}
@@ -0,0 +1,38 @@
+package privateapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestStateQueryAuth(t *testing.T) {
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	auth := NewStateQueryAuth("secret")
+	require.NoError(t, auth.check(withToken("secret"), remote.KV_DomainGet_FullMethodName))
+	require.Equal(t, codes.Unauthenticated, status.Code(auth.check(withToken("wrong"), remote.KV_DomainGet_FullMethodName)))
+	require.Equal(t, codes.Unauthenticated, status.Code(auth.check(context.Background(), remote.KV_IndexRange_FullMethodName)))
+	require.Equal(t, codes.PermissionDenied, status.Code(auth.check(withToken("secret"), remote.KV_Range_FullMethodName)))
+	require.Equal(t, codes.PermissionDenied, status.Code(auth.check(withToken("secret"), remote.KV_StateChanges_FullMethodName)))
+	require.Equal(t, codes.PermissionDenied, status.Code(auth.check(withToken("secret"), remote.KV_Tx_FullMethodName)))
+
+	noAuth := NewStateQueryAuth("")
+	require.NoError(t, noAuth.check(context.Background(), remote.KV_DomainRange_FullMethodName))
+	require.Equal(t, codes.PermissionDenied, status.Code(noAuth.check(context.Background(), remote.KV_Snapshots_FullMethodName)))
+
+	info := &grpc.UnaryServerInfo{FullMethod: remote.KV_DomainGet_FullMethodName}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return &remote.DomainGetReply{}, nil }
+	_, err := noAuth.Unary(context.Background(), &remote.DomainGetReq{}, info, handler)
+	require.NoError(t, err)
+	_, err = noAuth.Unary(context.Background(), &remote.DomainGetReq{TxId: 1}, info, handler)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
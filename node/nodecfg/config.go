@@ -148,6 +148,11 @@ type Config struct {
 	PrivateApiAddr      string
 	PrivateApiRateLimit uint32
 
+	// Address of standalone state query API (read-only subset of remote database interface for indexers),
+	// empty string means not to start the listener. StateApiToken - required bearer token, empty means no auth
+	StateApiAddr  string
+	StateApiToken string
+
 	staticNodesWarning  bool
 	trustedNodesWarning bool
 
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"reflect"
 	"strconv"
 	"strings"
@@ -233,6 +234,26 @@ func (h *handler) handleMsg(msg *jsonrpcMessage, stream *jsoniter.Stream) {
 		return
 	}
 	h.startCallProc(func(cp *callProc) {
+		if sc, ok := h.conn.(streamingConn); ok && stream == nil && h.isStreamable(msg) {
+			// write answer directly to connection: responses like debug_traceBlock* may be hundreds of MB
+			if err := sc.streamJSON(func(w io.Writer) error {
+				stream := jsoniter.NewStream(jsoniter.ConfigDefault, w, 4096)
+				if answer := h.handleCallMsg(cp, msg, stream); answer != nil {
+					buffer, _ := json.Marshal(answer)
+					stream.Write(buffer)
+				}
+				return stream.Flush()
+			}); err != nil {
+				// nothing of answer is written, or connection is already closed
+				h.logger.Debug("[rpc] streaming response failed", "method", msg.Method, "err", err)
+				h.conn.WriteJSON(cp.ctx, msg.errorResponse(err))
+			}
+			h.addSubscriptions(cp.notifiers)
+			for _, n := range cp.notifiers {
+				n.activate()
+			}
+			return
+		}
 		needWriteStream := false
 		if stream == nil {
 			stream = jsoniter.NewStream(jsoniter.ConfigDefault, nil, 4096)
@@ -255,6 +276,21 @@ func (h *handler) handleMsg(msg *jsonrpcMessage, stream *jsoniter.Stream) {
 	})
 }
 
+// streamingConn - connection which can write single message by parts (for example: websocket frames),
+// then answers of streamable methods don't need to be buffered in RAM.
+type streamingConn interface {
+	streamJSON(write func(w io.Writer) error) error
+}
+
+// isStreamable - msg is call of method which writes its result to *jsoniter.Stream
+func (h *handler) isStreamable(msg *jsonrpcMessage) bool {
+	if !msg.isCall() || msg.isSubscribe() || msg.isUnsubscribe() || !h.isMethodAllowedByGranularControl(msg.Method) {
+		return false
+	}
+	callb := h.reg.callback(msg.Method)
+	return callb != nil && callb.streamable
+}
+
 // close cancels all requests except for inflightReq and waits for
 // call goroutines to shut down.
 func (h *handler) close(err error, inflightReq *requestOp) {
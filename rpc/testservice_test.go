@@ -24,6 +24,7 @@ import (
 	"sync"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/ledgerwatch/log/v3"
 )
 
@@ -206,3 +207,41 @@ type largeRespService struct {
 func (x largeRespService) LargeResp() string {
 	return strings.Repeat("x", x.length)
 }
+
+// streamRespService writes JSON array of `n` items to stream, flushing after every item.
+type streamRespService struct {
+	release chan struct{} // WaitResp answers when it's closed
+}
+
+// WaitResp writes part of answer bigger than websocket RAM buffer, then finishes it when `release` is closed
+func (s streamRespService) WaitResp(stream *jsoniter.Stream) error {
+	stream.WriteArrayStart()
+	stream.WriteString(strings.Repeat("x", wsStreamBufferSize))
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+	<-s.release
+	stream.WriteMore()
+	stream.WriteString("released")
+	stream.WriteArrayEnd()
+	return stream.Flush()
+}
+
+func (streamRespService) Echo(str string) string {
+	return str
+}
+
+func (streamRespService) StreamResp(n int, stream *jsoniter.Stream) error {
+	stream.WriteArrayStart()
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteInt(i)
+		if err := stream.Flush(); err != nil {
+			return err
+		}
+	}
+	stream.WriteArrayEnd()
+	return stream.Flush()
+}
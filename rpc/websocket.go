@@ -17,9 +17,12 @@
 package rpc
 
 import (
+	"bufio"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -277,6 +280,117 @@ func (wc *websocketCodec) WriteJSON(ctx context.Context, v interface{}) error {
 	return err
 }
 
+// wsStreamBufferSize - streamed message is buffered in RAM up to this size, bigger one is spilled to temporary file
+const wsStreamBufferSize = 1 << 20
+
+// wsStreamChunkSize - spilled message is written to connection by frames of this size
+const wsStreamChunkSize = 64 * 1024
+
+// streamJSON - write one message, which may be bigger than RAM buffer. Frames of websocket message can't be
+// interleaved with other messages, so message is produced without holding connection's writer (answer may be
+// produced slowly: re-execution of heavy block) and written only when it's complete. If message can't be produced -
+// nothing is written, caller can answer by error. If connection fails in the middle of message - it's closed by
+// CloseInternalServerErr, instead of leaving peer with truncated JSON.
+func (wc *websocketCodec) streamJSON(write func(w io.Writer) error) error {
+	sw := &wsStreamWriter{}
+	defer sw.close()
+	if err := write(sw); err != nil {
+		return err
+	}
+	if err := sw.flush(); err != nil {
+		return err
+	}
+	if err := wc.writeStreamed(sw); err != nil {
+		wc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "response write failed"), time.Now().Add(wsPingWriteTimeout)) //nolint:errcheck
+		wc.jsonCodec.Close()
+		return err
+	}
+	// Notify pingLoop to delay the next idle ping.
+	select {
+	case wc.pingReset <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// writeStreamed - writes produced message under encoder lock. Write deadline is prolonged on every frame:
+// big message may take long, but peer must keep reading.
+func (wc *websocketCodec) writeStreamed(sw *wsStreamWriter) error {
+	wc.jsonCodec.encMu.Lock()
+	defer wc.jsonCodec.encMu.Unlock()
+	wc.conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeout)) //nolint:errcheck
+	if sw.file == nil {
+		return wc.conn.WriteMessage(websocket.TextMessage, sw.buf)
+	}
+	if _, err := sw.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w, err := wc.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	chunk := make([]byte, wsStreamChunkSize)
+	for {
+		n, err := sw.file.Read(chunk)
+		if n > 0 {
+			wc.conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeout)) //nolint:errcheck
+			if _, werr := w.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// wsStreamWriter - buffers message in RAM until it's bigger than wsStreamBufferSize, then spills it to temporary file
+type wsStreamWriter struct {
+	buf   []byte
+	file  *os.File // nil - message is in buf
+	fileW *bufio.Writer
+}
+
+func (sw *wsStreamWriter) Write(p []byte) (int, error) {
+	if sw.file == nil && len(sw.buf)+len(p) <= wsStreamBufferSize {
+		sw.buf = append(sw.buf, p...)
+		return len(p), nil
+	}
+	if sw.file == nil {
+		f, err := os.CreateTemp("", "erigon-rpc-ws-*")
+		if err != nil {
+			return 0, err
+		}
+		sw.file, sw.fileW = f, bufio.NewWriterSize(f, wsStreamChunkSize)
+		if _, err = sw.fileW.Write(sw.buf); err != nil {
+			return 0, err
+		}
+		sw.buf = nil
+	}
+	return sw.fileW.Write(p)
+}
+
+// flush - message is produced: write rest of it to file
+func (sw *wsStreamWriter) flush() error {
+	if sw.fileW == nil {
+		return nil
+	}
+	return sw.fileW.Flush()
+}
+
+// close - removes temporary file
+func (sw *wsStreamWriter) close() {
+	if sw.file == nil {
+		return
+	}
+	sw.file.Close()
+	os.Remove(sw.file.Name())
+}
+
 // pingLoop sends periodic ping frames when the connection is idle.
 func (wc *websocketCodec) pingLoop() {
 	timer := time.NewTimer(wsPingInterval)
@@ -194,6 +194,86 @@ func TestClientWebsocketLargeMessage(t *testing.T) {
 	}
 }
 
+// This checks that answers of streamable methods are written to websocket as they are produced.
+func TestClientWebsocketStreamingResponse(t *testing.T) {
+	logger := log.New()
+	var (
+		srv     = NewServer(50, false /* traceRequests */, true, logger, 100)
+		httpsrv = httptest.NewServer(srv.WebsocketHandler(nil, nil, false, logger))
+		wsURL   = "ws:" + strings.TrimPrefix(httpsrv.URL, "http:")
+	)
+	defer srv.Stop()
+	defer httpsrv.Close()
+
+	if err := srv.RegisterName("test", streamRespService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := DialWebsocket(context.Background(), wsURL, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var r []int
+	if err := c.Call(&r, "test_streamResp", 10_000); err != nil {
+		t.Fatal("call failed:", err)
+	}
+	if len(r) != 10_000 || r[9_999] != 9_999 {
+		t.Fatalf("wrong response, len %d", len(r))
+	}
+	// errors of argument parsing are also written by stream
+	if err := c.Call(&r, "test_streamResp", "not a number"); err == nil {
+		t.Fatal("no error for invalid params")
+	}
+}
+
+// This checks that answer of streamable method doesn't block other answers of connection while it's produced,
+// also when it doesn't fit RAM buffer.
+func TestClientWebsocketStreamingResponseConcurrent(t *testing.T) {
+	logger := log.New()
+	var (
+		srv     = NewServer(50, false /* traceRequests */, true, logger, 100)
+		httpsrv = httptest.NewServer(srv.WebsocketHandler(nil, nil, false, logger))
+		wsURL   = "ws:" + strings.TrimPrefix(httpsrv.URL, "http:")
+		release = make(chan struct{})
+	)
+	defer srv.Stop()
+	defer httpsrv.Close()
+
+	if err := srv.RegisterName("test", streamRespService{release: release}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := DialWebsocket(context.Background(), wsURL, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	waitErr := make(chan error, 1)
+	var waitResp []string
+	go func() { waitErr <- c.Call(&waitResp, "test_waitResp") }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var echo string
+	if err := c.CallContext(ctx, &echo, "test_echo", "hello"); err != nil {
+		t.Fatal("call blocked by streaming answer:", err)
+	}
+	if echo != "hello" {
+		t.Fatalf("wrong response: %q", echo)
+	}
+
+	close(release)
+	if err := <-waitErr; err != nil {
+		t.Fatal("streaming call failed:", err)
+	}
+	if len(waitResp) != 2 || len(waitResp[0]) != wsStreamBufferSize || waitResp[1] != "released" {
+		t.Fatalf("wrong response, len %d", len(waitResp))
+	}
+}
+
 // wsPingTestServer runs a WebSocket server which accepts a single subscription request.
 // When a value arrives on sendPing, the server sends a ping frame, waits for a matching
 // pong and finally delivers a single subscription result.
@@ -8,6 +8,8 @@ import (
 	libcommon "github.com/ledgerwatch/erigon-lib/common"
 
 	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/crypto"
 )
 
 type StateOverrides map[libcommon.Address]Account
@@ -49,3 +51,58 @@ func (overrides *StateOverrides) Override(state *state.IntraBlockState) error {
 
 	return nil
 }
+
+// OverrideOverlay - same as Override, but writes overrides directly to overlay: they survive commit of
+// IntraBlockState (which doesn't persist replaced storage) and are visible to all bundles executed on top of overlay.
+func (overrides *StateOverrides) OverrideOverlay(overlay *state.OverlayState) error {
+	for addr, account := range *overrides {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+		original, err := overlay.ReadAccountData(addr)
+		if err != nil {
+			return err
+		}
+		acc := accounts.NewAccount()
+		if original != nil {
+			acc.Copy(original)
+		}
+		// Override account nonce.
+		if account.Nonce != nil {
+			acc.Nonce = uint64(*account.Nonce)
+		}
+		// Override account(contract) code.
+		if account.Code != nil {
+			acc.CodeHash = crypto.Keccak256Hash(*account.Code)
+			if err := overlay.UpdateAccountCode(addr, acc.Incarnation, acc.CodeHash, *account.Code); err != nil {
+				return err
+			}
+		}
+		// Override account balance.
+		if account.Balance != nil {
+			balance, overflow := uint256.FromBig((*big.Int)(*account.Balance))
+			if overflow {
+				return fmt.Errorf("account.Balance higher than 2^256-1")
+			}
+			acc.Balance = *balance
+		}
+		if err := overlay.UpdateAccountData(addr, original, &acc); err != nil {
+			return err
+		}
+		// Replace entire state if caller requires.
+		storage := account.StateDiff
+		if account.State != nil {
+			overlay.ClearStorage(addr)
+			storage = account.State
+		}
+		if storage != nil {
+			for key, value := range *storage {
+				key, value := key, value
+				if err := overlay.WriteAccountStorage(addr, acc.Incarnation, &key, nil, &value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
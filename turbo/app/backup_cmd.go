@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"os"
@@ -10,10 +11,13 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
 	"github.com/ledgerwatch/erigon/cmd/utils"
 	"github.com/ledgerwatch/erigon/cmd/utils/flags"
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
 	"github.com/ledgerwatch/erigon/turbo/backup"
 	"github.com/ledgerwatch/erigon/turbo/debug"
+	"github.com/ledgerwatch/log/v3"
 	"github.com/urfave/cli/v2"
 )
 
@@ -23,7 +27,7 @@ var backupCommand = cli.Command{
 	Description: `Alpha verison of command. Backup all databases without stopping of Erigon.
 While this command has Alpha prefix - we recommend to stop Erigon for backup. 
 Limitations: 
-- no support of datadir/snapshots folder. Recommendation: backup snapshots dir manually AFTER databases backup. Possible to implement in future.
+- no support of block snapshots (datadir/snapshots folder). Recommendation: backup them manually AFTER databases backup. State history files are backed up by --state flag.
 - no support of Consensus DB (copy it manually if you need). Possible to implement in future.
 - way to pipe output to compressor (lz4/zstd). Can compress target floder later or use zfs-with-enabled-compression.
 - jwt tocken: copy it manually - if need. 
@@ -44,7 +48,18 @@ TODO:
 		&BackupLabelsFlag,
 		&BackupTablesFlag,
 		&WarmupThreadsFlag,
+		&BackupStateFlag,
 	}),
+	Subcommands: []*cli.Command{
+		{
+			Name:   "verify",
+			Action: doBackupVerify,
+			Usage:  "Check that datadir restored from backup with --state is usable: all state files are in place, not corrupted and consistent with chaindata",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+			}),
+		},
+	},
 }
 
 var (
@@ -65,6 +80,10 @@ var (
 		Name:  "to.pagesize",
 		Usage: utils.DbPageSizeFlag.Usage,
 	}
+	BackupStateFlag = cli.BoolFlag{
+		Name:  "state",
+		Usage: "Also backup state history files (hard-linked), consistently with chaindata. Restored datadir can be checked by `verify` subcommand",
+	}
 	WarmupThreadsFlag = cli.Uint64Flag{
 		Name: "warmup.threads",
 		Usage: `Erigon's db works as blocking-io: means it stops when read from disk. 
@@ -137,6 +156,12 @@ func doBackup(cliCtx *cli.Context) error {
 		if err := os.MkdirAll(to, 0740); err != nil { //owner: rw, group: r, others: -
 			return fmt.Errorf("mkdir: %w, %s", err, to)
 		}
+		if label == kv.ChainDB && cliCtx.Bool(BackupStateFlag.Name) {
+			if err := backupChaindataWithState(ctx, dirs, toDirs, targetPageSize, readAheadThreads, logger); err != nil {
+				return err
+			}
+			continue
+		}
 		logger.Info("[backup] start", "label", label)
 		fromDB, toDB := backup.OpenPair(from, to, label, targetPageSize, logger)
 		if err := backup.Kv2kv(ctx, fromDB, toDB, nil, readAheadThreads, logger); err != nil {
@@ -146,3 +171,33 @@ func doBackup(cliCtx *cli.Context) error {
 
 	return nil
 }
+
+func backupChaindataWithState(ctx context.Context, dirs, toDirs datadir.Dirs, targetPageSize datasize.ByteSize, readAheadThreads int, logger log.Logger) error {
+	fromDB := backup.OpenSrc(dirs.Chaindata, kv.ChainDB, logger)
+	defer fromDB.Close()
+	agg, err := libstate.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, ethconfig.HistoryV3AggregationStep, fromDB, logger)
+	if err != nil {
+		return err
+	}
+	defer agg.Close()
+	agg.SetReadOnly(true) // files are owned by running Erigon
+	return backup.HotBackup(ctx, fromDB, agg, dirs, toDirs, targetPageSize, readAheadThreads, logger)
+}
+
+func doBackupVerify(cliCtx *cli.Context) error {
+	logger, _, err := debug.Setup(cliCtx, true /* rootLogger */)
+	if err != nil {
+		return err
+	}
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+	db := backup.OpenSrc(dirs.Chaindata, kv.ChainDB, logger)
+	defer db.Close()
+	agg, err := libstate.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, ethconfig.HistoryV3AggregationStep, db, logger)
+	if err != nil {
+		return err
+	}
+	defer agg.Close()
+	agg.SetReadOnly(true)
+	return backup.VerifyStateBackup(ctx, dirs, agg, logger)
+}
@@ -4,16 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/urfave/cli/v2"
@@ -23,12 +28,15 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
 	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 	"github.com/ledgerwatch/erigon-lib/metrics"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 	"github.com/ledgerwatch/erigon-lib/seg"
 	libstate "github.com/ledgerwatch/erigon-lib/state"
 	"github.com/ledgerwatch/erigon/cmd/hack/tool/fromdb"
@@ -57,8 +65,9 @@ func joinFlags(lists ...[]cli.Flag) (res []cli.Flag) {
 }
 
 var snapshotCommand = cli.Command{
-	Name:  "snapshots",
-	Usage: `Managing snapshots (historical data partitions)`,
+	Name:    "snapshots",
+	Aliases: []string{"seg"},
+	Usage:   `Managing snapshots (historical data partitions)`,
 	Before: func(context *cli.Context) error {
 		_, _, err := debug.Setup(context, true /* rootLogger */)
 		if err != nil {
@@ -154,11 +163,84 @@ var snapshotCommand = cli.Command{
 				},
 			}),
 		},
+		{
+			Name:   "manifest",
+			Action: doManifest,
+			Usage:  "Build signed manifest (sizes, hashes) of frozen state files before publishing them. Downloading nodes verify files against it",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+				&cli.PathFlag{
+					Name:  "key",
+					Usage: "File with hex-encoded ed25519 seed of publisher. If not set - manifest is not signed",
+				},
+			}),
+		},
+		{
+			Name:   "era-export",
+			Action: doEraExport,
+			Usage:  "Export frozen state history of steps [from, to) into e2store archive (era framing, Erigon-specific record types: not readable by era1 tools)",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+				&utils.SnapStateSearchDirsFlag,
+				&cli.Uint64Flag{Name: "from", Usage: "From step", Value: 0},
+				&cli.Uint64Flag{Name: "to", Usage: "To step", Required: true},
+				&cli.PathFlag{Name: "out", Usage: "Archive file path", Required: true},
+			}),
+		},
+		{
+			Name:   "era-import",
+			Action: doEraImport,
+			Usage:  "Import state history from e2store archive of era-export and build missing indices",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+				&cli.PathFlag{Name: "in", Usage: "Archive file path", Required: true},
+			}),
+		},
+		{
+			Name:   "view",
+			Action: doSegView,
+			Usage:  "Print words (or key/value pairs of .ef/.kv files) and accessor index stats: erigon seg view --file=accounts.0-32.ef --limit=10",
+			Flags: joinFlags([]cli.Flag{
+				&cli.PathFlag{Name: "file", Required: true},
+				&cli.IntFlag{Name: "limit", Usage: "Max amount of printed words/pairs, 0 - unlimited", Value: 100},
+				&cli.StringFlag{Name: "prefix", Usage: "Print only words/keys with given hex prefix"},
+				&cli.BoolFlag{Name: "hex", Usage: "Print words as hex (by default - as quoted strings)"},
+				&cli.BoolFlag{Name: "uncompressed", Usage: "Words of file are not compressed (always true for .ef)"},
+			}),
+		},
+		{
+			Name:   "merge",
+			Action: doMerge,
+			Usage:  "Synchronously merge state files of one component in steps [from-step, to-step), range is aligned as in background merge (to-step - from-step is power of 2 up to 32, from-step is multiple of it): erigon seg merge --domain=storage --from-step=0 --to-step=32",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+				&utils.SnapStateSearchDirsFlag,
+				&cli.StringFlag{Name: "domain", Usage: "One of: accounts, storage, code, logaddrs, logtopics, tracesfrom, tracesto", Required: true},
+				&cli.Uint64Flag{Name: "from-step", Required: true},
+				&cli.Uint64Flag{Name: "to-step", Required: true},
+			}),
+		},
+		{
+			Name:   "ls-garbage",
+			Action: doLsGarbage,
+			Usage:  "List state files which are not used (sub-sets of merged files, files without indices) and can be deleted",
+			Flags:  joinFlags([]cli.Flag{&utils.DataDirFlag}),
+		},
+		{
+			Name:   "rm-garbage",
+			Action: doRmGarbage,
+			Usage:  "Delete files listed by ls-garbage",
+			Flags: joinFlags([]cli.Flag{
+				&utils.DataDirFlag,
+				&cli.BoolFlag{Name: "yes", Usage: "Don't ask for confirmation"},
+			}),
+		},
 		{
 			Name:   "integrity",
 			Action: doIntegrity,
 			Flags: joinFlags([]cli.Flag{
 				&utils.DataDirFlag,
+				&utils.SnapStateSearchDirsFlag,
 			}),
 		},
 		//{
@@ -205,6 +287,7 @@ func doIntegrity(cliCtx *cli.Context) error {
 	defer chainDB.Close()
 
 	cfg := ethconfig.NewSnapCfg(true, false, true)
+	cfg.StateSearchDirs = cliCtx.StringSlice(utils.SnapStateSearchDirsFlag.Name)
 
 	blockSnaps, borSnaps, blockRetire, agg, err := openSnaps(ctx, cfg, dirs, chainDB, logger)
 	if err != nil {
@@ -230,6 +313,297 @@ func doIntegrity(cliCtx *cli.Context) error {
 	return nil
 }
 
+func doManifest(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+
+	var names []string
+	if err := filepath.WalkDir(dirs.SnapHistory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !snaptype.IsStateFile(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dirs.Snap, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, rel)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	m, err := snaptype.BuildManifest(ctx, dirs.Snap, names)
+	if err != nil {
+		return err
+	}
+	// infohashes of seeded files: peers can download only files they miss (see snapshotsync.StateDiffFromPeers)
+	for i := range m.Files {
+		mi, err := metainfo.LoadFromFile(filepath.Join(dirs.Snap, filepath.FromSlash(m.Files[i].Name)) + ".torrent")
+		if err != nil {
+			continue
+		}
+		m.Files[i].TorrentHash = mi.HashInfoBytes().HexString()
+	}
+	if keyPath := cliCtx.Path("key"); keyPath != "" {
+		seedHex, err := os.ReadFile(keyPath)
+		if err != nil {
+			return err
+		}
+		seed, err := hex.DecodeString(strings.TrimSpace(string(seedHex)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("key file must contain hex-encoded %d bytes ed25519 seed", ed25519.SeedSize)
+		}
+		m.Sign(ed25519.NewKeyFromSeed(seed))
+	}
+	if err := snaptype.WriteManifest(filepath.Join(dirs.Snap, snaptype.ManifestFileName), m); err != nil {
+		return err
+	}
+	log.Info("[snapshots] manifest", "files", len(m.Files), "root", m.Root, "signer", m.Signer)
+	return nil
+}
+
+func doEraExport(cliCtx *cli.Context) error {
+	logger, _, err := debug.Setup(cliCtx, true /* rootLogger */)
+	if err != nil {
+		return err
+	}
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+	chainDB := dbCfg(kv.ChainDB, dirs.Chaindata).MustOpen()
+	defer chainDB.Close()
+	agg := openAgg(ctx, dirs, cliCtx.StringSlice(utils.SnapStateSearchDirsFlag.Name), chainDB, logger)
+	defer agg.Close()
+
+	f, err := os.Create(cliCtx.Path("out"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	names, err := agg.ExportEra(ctx, f, cliCtx.Uint64("from"), cliCtx.Uint64("to"))
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	logger.Info("[snapshots] era export", "files", len(names), "out", f.Name())
+	return nil
+}
+
+func doEraImport(cliCtx *cli.Context) error {
+	logger, _, err := debug.Setup(cliCtx, true /* rootLogger */)
+	if err != nil {
+		return err
+	}
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+	chainDB := dbCfg(kv.ChainDB, dirs.Chaindata).MustOpen()
+	defer chainDB.Close()
+	agg := openAgg(ctx, dirs, nil, chainDB, logger)
+	defer agg.Close()
+
+	f, err := os.Open(cliCtx.Path("in"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	names, err := agg.ImportEra(ctx, f, estimate.IndexSnapshot.Workers())
+	if err != nil {
+		return err
+	}
+	logger.Info("[snapshots] era import", "files", len(names))
+	return nil
+}
+
+func doMerge(cliCtx *cli.Context) error {
+	logger, _, err := debug.Setup(cliCtx, true /* rootLogger */)
+	if err != nil {
+		return err
+	}
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+	chainDB := dbCfg(kv.ChainDB, dirs.Chaindata).MustOpen()
+	defer chainDB.Close()
+	agg := openAgg(ctx, dirs, cliCtx.StringSlice(utils.SnapStateSearchDirsFlag.Name), chainDB, logger)
+	defer agg.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		logEvery := time.NewTicker(20 * time.Second)
+		defer logEvery.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-logEvery.C:
+				var m runtime.MemStats
+				dbg.ReadMemStats(&m)
+				logger.Info("[snapshots] merging", "progress", agg.BackgroundProgress(), "alloc", common.ByteCount(m.Alloc), "sys", common.ByteCount(m.Sys))
+			}
+		}
+	}()
+
+	domain, from, to := cliCtx.String("domain"), cliCtx.Uint64("from-step"), cliCtx.Uint64("to-step")
+	start := time.Now()
+	logger.Info("[snapshots] merge", "domain", domain, "from", from, "to", to)
+	merged, err := agg.MergeRange(ctx, domain, from, to, estimate.CompressSnapshot.Workers())
+	if err != nil {
+		return err
+	}
+	logger.Info("[snapshots] merge done", "files", merged, "took", time.Since(start))
+	return chainDB.Update(ctx, func(tx kv.RwTx) error {
+		blockFiles, _, err := rawdb.ReadSnapshots(tx)
+		if err != nil {
+			return err
+		}
+		return rawdb.WriteSnapshots(tx, blockFiles, agg.Files())
+	})
+}
+
+func doLsGarbage(cliCtx *cli.Context) error {
+	_, err := listGarbage(cliCtx)
+	return err
+}
+
+func doRmGarbage(cliCtx *cli.Context) error {
+	files, err := listGarbage(cliCtx)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	if !cliCtx.Bool("yes") {
+		fmt.Printf("Delete %d files? [y/N]: ", len(files))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return nil
+		}
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	log.Info("[snapshots] garbage deleted", "files", len(files))
+	return nil
+}
+
+func listGarbage(cliCtx *cli.Context) ([]string, error) {
+	logger, _, err := debug.Setup(cliCtx, true /* rootLogger */)
+	if err != nil {
+		return nil, err
+	}
+	ctx := cliCtx.Context
+	dirs := datadir.New(cliCtx.String(utils.DataDirFlag.Name))
+	chainDB := dbCfg(kv.ChainDB, dirs.Chaindata).MustOpen()
+	defer chainDB.Close()
+	agg := openAgg(ctx, dirs, nil, chainDB, logger) // files of search dirs are never removed
+	defer agg.Close()
+
+	files, err := agg.GarbageFiles()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		total += st.Size()
+		fmt.Printf("%s\t%s\n", filepath.Base(f), datasize.ByteSize(st.Size()).HumanReadable())
+	}
+	fmt.Printf("total: %d files, %s\n", len(files), datasize.ByteSize(total).HumanReadable())
+	return files, nil
+}
+
+// segAccessorIdxExt - extension of recsplit index built over file with given extension
+var segAccessorIdxExt = map[string]string{".seg": ".idx", ".ef": ".efi", ".v": ".vi", ".kv": ".kvi"}
+
+func doSegView(cliCtx *cli.Context) error {
+	fPath := cliCtx.Path("file")
+	limit := cliCtx.Int("limit")
+	asHex := cliCtx.Bool("hex")
+	prefix, err := hex.DecodeString(strings.TrimPrefix(cliCtx.String("prefix"), "0x"))
+	if err != nil {
+		return fmt.Errorf("--prefix: %w", err)
+	}
+
+	d, err := seg.NewDecompressor(fPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	fmt.Printf("file: %s, size: %s, words: %d, empty words: %d\n", d.FileName(), datasize.ByteSize(d.Size()).HumanReadable(), d.Count(), d.EmptyWordsCount())
+
+	ext := filepath.Ext(fPath)
+	if idxExt, ok := segAccessorIdxExt[ext]; ok {
+		idxPath := strings.TrimSuffix(fPath, ext) + idxExt
+		if !dir.FileExist(idxPath) {
+			fmt.Printf("index: %s not found\n", filepath.Base(idxPath))
+		} else {
+			idx, err := recsplit.OpenIndex(idxPath)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("index: %s, size: %s, keys: %d, base data id: %d, salt: %d\n", idx.FileName(), datasize.ByteSize(idx.Size()).HumanReadable(), idx.KeyCount(), idx.BaseDataID(), idx.Salt())
+			idx.Close()
+		}
+	}
+
+	pairs := ext == ".ef" || ext == ".kv"
+	uncompressed := ext == ".ef" || cliCtx.Bool("uncompressed")
+	format := func(b []byte) string {
+		if asHex {
+			return hex.EncodeToString(b)
+		}
+		return fmt.Sprintf("%q", b)
+	}
+	g := d.MakeGetter()
+	next := func(buf []byte) []byte {
+		if uncompressed {
+			buf, _ = g.NextUncompressed()
+			return buf
+		}
+		buf, _ = g.Next(buf[:0])
+		return buf
+	}
+
+	var k, v []byte
+	for printed := 0; g.HasNext() && (limit == 0 || printed < limit); {
+		if !pairs {
+			if v = next(v); bytes.HasPrefix(v, prefix) {
+				fmt.Printf("%s\n", format(v))
+				printed++
+			}
+			continue
+		}
+		k = next(k)
+		if !g.HasNext() {
+			return fmt.Errorf("key %x without value: file is broken", k)
+		}
+		v = next(v)
+		if !bytes.HasPrefix(k, prefix) {
+			continue
+		}
+		printed++
+		if ext != ".ef" {
+			fmt.Printf("%s => %s\n", format(k), format(v))
+			continue
+		}
+		ef, _ := eliasfano32.ReadEliasFano(v)
+		txNums := make([]uint64, 0, 16)
+		for it := ef.Iterator(); it.HasNext() && len(txNums) < cap(txNums); {
+			n, _ := it.Next()
+			txNums = append(txNums, n)
+		}
+		fmt.Printf("%s => count=%d, min=%d, max=%d, txNums=%v\n", format(k), ef.Count(), ef.Min(), ef.Max(), txNums)
+	}
+	return nil
+}
+
 func doDiff(cliCtx *cli.Context) error {
 	defer log.Info("Done")
 	srcF, dstF := cliCtx.String("src"), cliCtx.String("dst")
@@ -387,7 +761,7 @@ func openSnaps(ctx context.Context, cfg ethconfig.BlocksFreezing, dirs datadir.D
 		return
 	}
 	borSnaps.LogStat("open")
-	agg = openAgg(ctx, dirs, chainDB, logger)
+	agg = openAgg(ctx, dirs, cfg.StateSearchDirs, chainDB, logger)
 	err = chainDB.View(ctx, func(tx kv.Tx) error {
 		ac := agg.MakeContext()
 		defer ac.Close()
@@ -792,11 +1166,12 @@ func dbCfg(label kv.Label, path string) mdbx.MdbxOpts {
 	opts = opts.Accede()
 	return opts
 }
-func openAgg(ctx context.Context, dirs datadir.Dirs, chainDB kv.RwDB, logger log.Logger) *libstate.AggregatorV3 {
+func openAgg(ctx context.Context, dirs datadir.Dirs, searchDirs []string, chainDB kv.RwDB, logger log.Logger) *libstate.AggregatorV3 {
 	agg, err := libstate.NewAggregatorV3(ctx, dirs.Snap, dirs.Tmp, ethconfig.HistoryV3AggregationStep, chainDB, logger)
 	if err != nil {
 		panic(err)
 	}
+	agg.SetSearchDirs(searchDirs)
 	if err = agg.OpenFolder(); err != nil {
 		panic(err)
 	}
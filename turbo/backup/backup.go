@@ -23,13 +23,23 @@ import (
 )
 
 func OpenPair(from, to string, label kv.Label, targetPageSize datasize.ByteSize, logger log.Logger) (kv.RoDB, kv.RwDB) {
+	src := OpenSrc(from, label, logger)
+	return src, OpenDst(src, to, label, targetPageSize, logger)
+}
+
+// OpenSrc - db of running Erigon, opened in parallel with it
+func OpenSrc(from string, label kv.Label, logger log.Logger) kv.RoDB {
 	const ThreadsHardLimit = 9_000
-	src := mdbx2.NewMDBX(logger).Path(from).
+	return mdbx2.NewMDBX(logger).Path(from).
 		Label(label).
 		RoTxsLimiter(semaphore.NewWeighted(ThreadsHardLimit)).
 		WithTableCfg(func(_ kv.TableCfg) kv.TableCfg { return kv.TablesCfgByLabel(label) }).
 		Flags(func(flags uint) uint { return flags | mdbx.Accede }).
 		MustOpen()
+}
+
+// OpenDst - target db of backup of `src`
+func OpenDst(src kv.RoDB, to string, label kv.Label, targetPageSize datasize.ByteSize, logger log.Logger) kv.RwDB {
 	if targetPageSize <= 0 {
 		targetPageSize = datasize.ByteSize(src.PageSize())
 	}
@@ -45,7 +55,7 @@ func OpenPair(from, to string, label kv.Label, targetPageSize datasize.ByteSize,
 		Flags(func(flags uint) uint { return flags | mdbx.WriteMap }).
 		WithTableCfg(func(_ kv.TableCfg) kv.TableCfg { return kv.TablesCfgByLabel(label) }).
 		MustOpen()
-	return src, dst
+	return dst
 }
 
 func Kv2kv(ctx context.Context, src kv.RoDB, dst kv.RwDB, tables []string, readAheadThreads int, logger log.Logger) error {
@@ -54,7 +64,11 @@ func Kv2kv(ctx context.Context, src kv.RoDB, dst kv.RwDB, tables []string, readA
 		return err1
 	}
 	defer srcTx.Rollback()
+	return Kv2kvTx(ctx, src, srcTx, dst, tables, readAheadThreads, logger)
+}
 
+// Kv2kvTx - as Kv2kv, but copies data seen by given `srcTx`: for backups which must be consistent with other data
+func Kv2kvTx(ctx context.Context, src kv.RoDB, srcTx kv.Tx, dst kv.RwDB, tables []string, readAheadThreads int, logger log.Logger) error {
 	commitEvery := time.NewTicker(5 * time.Minute)
 	defer commitEvery.Stop()
 	logEvery := time.NewTicker(20 * time.Second)
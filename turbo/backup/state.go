@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/state"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// StateBackupFileName - description of hot backup, lives in root of backup datadir
+const StateBackupFileName = "state-backup.json"
+
+type StateBackup struct {
+	TxNum    uint64             `json:"tx_num"` // state files have all txs before it, chaindata - after
+	Manifest *snaptype.Manifest `json:"manifest"`
+}
+
+// HotBackup - consistent backup of chaindata, state files and block files of running node into `toDirs`.
+// Chaindata RoTx is opened before files are listed: node prunes DB only after files are built, so files seen after
+// RoTx and DB of this RoTx together have all data. `db` - chaindata (see OpenSrc), `agg` - on `dirs`, not opened yet:
+// it's opened here after RoTx. Files are owned by running node and may be removed by it's merge while they are
+// linked - then files are listed again (merged file covers removed ones).
+func HotBackup(ctx context.Context, db kv.RoDB, agg *state.AggregatorV3, dirs, toDirs datadir.Dirs, targetPageSize datasize.ByteSize, readAheadThreads int, logger log.Logger) error {
+	srcTx, err := db.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcTx.Rollback()
+
+	var names []string
+	var txNum uint64
+	for attempt := 1; ; attempt++ {
+		names, txNum, err = backupFiles(ctx, agg, dirs, toDirs)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || attempt == maxBackupFilesAttempts {
+			return err
+		}
+		logger.Debug("[backup] files were merged meanwhile, list them again", "err", err)
+	}
+	m, err := snaptype.BuildManifest(ctx, toDirs.Snap, names)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(toDirs.Chaindata, 0740); err != nil {
+		return err
+	}
+	dst := OpenDst(db, toDirs.Chaindata, kv.ChainDB, targetPageSize, logger)
+	defer dst.Close()
+	logger.Info("[backup] start", "label", kv.ChainDB, "files", len(names), "tx_num", txNum)
+	if err := Kv2kvTx(ctx, db, srcTx, dst, nil, readAheadThreads, logger); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&StateBackup{TxNum: txNum, Manifest: m}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(toDirs.DataDir, StateBackupFileName), data, 0644)
+}
+
+const maxBackupFilesAttempts = 5
+
+// backupFiles - state files (by `agg`) and block files of `dirs`. Returns names relative to `toDirs.Snap`.
+func backupFiles(ctx context.Context, agg *state.AggregatorV3, dirs, toDirs datadir.Dirs) (names []string, txNum uint64, err error) {
+	if err = agg.OpenFolder(); err != nil {
+		return nil, 0, err
+	}
+	stateNames, txNum, err := agg.Backup(ctx, toDirs.SnapHistory)
+	if err != nil {
+		return nil, 0, err
+	}
+	relDir, err := filepath.Rel(toDirs.Snap, toDirs.SnapHistory)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, name := range stateNames {
+		names = append(names, filepath.Join(relDir, name))
+	}
+
+	blockFiles, err := dir.ListFiles(dirs.Snap, ".seg", ".idx")
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, path := range blockFiles {
+		name := filepath.Base(path)
+		if err := dir.LinkOrCopyFile(path, filepath.Join(toDirs.Snap, name)); err != nil {
+			return nil, 0, fmt.Errorf("backup %s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	return names, txNum, nil
+}
+
+// VerifyStateBackup - check that datadir restored from HotBackup is usable: all state files of backup are in place and
+// not corrupted, and `agg` (opened on restored datadir) has files up to txNum of backup.
+func VerifyStateBackup(ctx context.Context, dirs datadir.Dirs, agg *state.AggregatorV3, logger log.Logger) error {
+	b, err := ReadStateBackup(dirs)
+	if err != nil {
+		return err
+	}
+	if err := b.Manifest.Verify(nil); err != nil {
+		return err
+	}
+	missing, err := b.Manifest.Missing(dirs.Snap, nil)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("backup: %d files are missing or truncated, first: %s", len(missing), missing[0].Name)
+	}
+	checked, err := b.Manifest.VerifyFiles(ctx, dirs.Snap, nil)
+	if err != nil {
+		return err
+	}
+	if err := agg.OpenFolder(); err != nil {
+		return err
+	}
+	if agg.EndTxNumMinimax() < b.TxNum {
+		return fmt.Errorf("backup: state files end at txNum %d, expected at least %d", agg.EndTxNumMinimax(), b.TxNum)
+	}
+	logger.Info("[backup] verified", "files", checked, "tx_num", b.TxNum)
+	return nil
+}
+
+// ReadStateBackup - description of backup from which datadir was restored. os.ErrNotExist - datadir is not restored by HotBackup.
+func ReadStateBackup(dirs datadir.Dirs) (*StateBackup, error) {
+	data, err := os.ReadFile(filepath.Join(dirs.DataDir, StateBackupFileName))
+	if err != nil {
+		return nil, err
+	}
+	b := &StateBackup{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", StateBackupFileName, err)
+	}
+	if b.Manifest == nil {
+		return nil, fmt.Errorf("%s: no manifest", StateBackupFileName)
+	}
+	return b, nil
+}
@@ -42,12 +42,16 @@ var DefaultFlags = []cli.Flag{
 	&DatabaseVerbosityFlag,
 	&PrivateApiAddr,
 	&PrivateApiRateLimit,
+	&StateApiAddr,
+	&StateApiToken,
 	&EtlBufferSizeFlag,
 	&TLSFlag,
 	&TLSCertFlag,
 	&TLSKeyFlag,
 	&TLSCACertFlag,
 	&StateStreamDisableFlag,
+	&FirehoseOutputFlag,
+	&FirehoseAddrFlag,
 	&SyncLoopThrottleFlag,
 	&BadBlockFlag,
 
@@ -79,6 +83,10 @@ var DefaultFlags = []cli.Flag{
 	&utils.RpcReturnDataLimit,
 	&utils.AllowUnprotectedTxs,
 	&utils.RpcMaxGetProofRewindBlockCount,
+	&utils.RpcGetLogsMaxBlocksFlag,
+	&utils.RpcGetLogsMaxResultsFlag,
+	&utils.RpcGetLogsTimeoutFlag,
+	&utils.RpcStateReadsLimitFlag,
 	&utils.RPCGlobalTxFeeCapFlag,
 	&utils.TxpoolApiAddrFlag,
 	&utils.TraceMaxtracesFlag,
@@ -92,8 +100,25 @@ var DefaultFlags = []cli.Flag{
 
 	&utils.SnapKeepBlocksFlag,
 	&utils.SnapStopFlag,
+	&utils.SnapStateFromStepFlag,
+	&utils.SnapStateSearchDirsFlag,
+	&utils.SnapStateWatchListFlag,
+	&utils.SnapStateDBWatchListFlag,
+	&utils.SnapProviderFlag,
+	&utils.SnapProviderURLFlag,
+	&utils.SnapStateKeepStepsFlag,
+	&utils.SnapStateDBKeepStepsFlag,
+	&utils.SnapStateDBKeepFlag,
+	&utils.SnapStateMadviseFlag,
+	&utils.SnapStatePeersFlag,
+	&utils.SnapStatePeerSignersFlag,
+	&utils.SnapManifestSignersFlag,
+	&utils.SnapExpireBeforeFlag,
 	&utils.DbPageSizeFlag,
 	&utils.DbSizeLimitFlag,
+	&utils.TmpDirQuotaFlag,
+	&utils.TmpDirMinFreeFlag,
+	&utils.TmpDirSpillFlag,
 	&utils.ForcePartialCommitFlag,
 	&utils.TorrentPortFlag,
 	&utils.TorrentMaxPeersFlag,
@@ -146,6 +171,7 @@ var DefaultFlags = []cli.Flag{
 	&utils.DisableIPV6,
 	&utils.NoDownloaderFlag,
 	&utils.DownloaderVerifyFlag,
+	&utils.VerifyDatadirFlag,
 	&HealthCheckFlag,
 	&utils.HeimdallURLFlag,
 	&utils.WebSeedsFlag,
@@ -164,6 +190,8 @@ var DefaultFlags = []cli.Flag{
 
 	&utils.OtsSearchMaxCapFlag,
 
+	&utils.DiagnosticsStateReplaceFlag,
+
 	&utils.SilkwormExecutionFlag,
 	&utils.SilkwormRpcDaemonFlag,
 	&utils.SilkwormSentryFlag,
@@ -36,7 +36,7 @@ var (
 	}
 	BatchSizeFlag = cli.StringFlag{
 		Name:  "batchSize",
-		Usage: "Batch size for the execution stage",
+		Usage: "Batch size for the execution stage. With HistoryV3 - limit of RAM used by not flushed state: keys, values and structure of maps",
 		Value: "256M",
 	}
 	EtlBufferSizeFlag = cli.StringFlag{
@@ -62,6 +62,17 @@ var (
 		Value: kv.ReadersLimit - 128,
 	}
 
+	StateApiAddr = cli.StringFlag{
+		Name:  "state.api.addr",
+		Usage: "Standalone grpc API for external indexers: latest/historical state, inverted indices and paginated prefix iteration (read-only subset of remote database interface). example: 127.0.0.1:9095, empty string means not to start the listener",
+		Value: "",
+	}
+	StateApiToken = cli.StringFlag{
+		Name:  "state.api.token",
+		Usage: "Clients of --state.api.addr must send 'authorization: Bearer <token>' metadata. Empty means no auth",
+		Value: "",
+	}
+
 	PruneFlag = cli.StringFlag{
 		Name: "prune",
 		Usage: `Choose which ancient data delete from DB:
@@ -141,6 +152,16 @@ var (
 		Name:  "state.stream.disable",
 		Usage: "Disable streaming of state changes from core to RPC daemon",
 	}
+	FirehoseOutputFlag = cli.StringFlag{
+		Name:  "firehose.output",
+		Usage: "Write blocks and their state changes in Firehose console reader framing to this file (or 'stdout') - for external indexers. Requires state stream",
+		Value: "",
+	}
+	FirehoseAddrFlag = cli.StringFlag{
+		Name:  "firehose.addr",
+		Usage: "Serve live stream of blocks and their state changes as Firehose gRPC (sf.firehose.v2.Stream) on this address - for external indexers. Requires state stream",
+		Value: "",
+	}
 
 	// Throttling Flags
 	SyncLoopThrottleFlag = cli.StringFlag{
@@ -282,6 +303,14 @@ func ApplyFlagsForEthConfig(ctx *cli.Context, cfg *ethconfig.Config, logger log.
 	}
 
 	cfg.StateStream = !ctx.Bool(StateStreamDisableFlag.Name)
+	cfg.FirehoseOutput = ctx.String(FirehoseOutputFlag.Name)
+	if cfg.FirehoseOutput != "" && !cfg.StateStream {
+		utils.Fatalf("Option %s requires state stream, remove %s", FirehoseOutputFlag.Name, StateStreamDisableFlag.Name)
+	}
+	cfg.FirehoseAddr = ctx.String(FirehoseAddrFlag.Name)
+	if cfg.FirehoseAddr != "" && !cfg.StateStream {
+		utils.Fatalf("Option %s requires state stream, remove %s", FirehoseAddrFlag.Name, StateStreamDisableFlag.Name)
+	}
 	if ctx.String(BodyCacheLimitFlag.Name) != "" {
 		err := cfg.Sync.BodyCacheLimit.UnmarshalText([]byte(ctx.String(BodyCacheLimitFlag.Name)))
 		if err != nil {
@@ -478,6 +507,10 @@ func setEmbeddedRpcDaemon(ctx *cli.Context, cfg *nodecfg.Config, logger log.Logg
 		ReturnDataLimit:             ctx.Int(utils.RpcReturnDataLimit.Name),
 		AllowUnprotectedTxs:         ctx.Bool(utils.AllowUnprotectedTxs.Name),
 		MaxGetProofRewindBlockCount: ctx.Int(utils.RpcMaxGetProofRewindBlockCount.Name),
+		GetLogsMaxBlocks:            ctx.Uint64(utils.RpcGetLogsMaxBlocksFlag.Name),
+		GetLogsMaxResults:           ctx.Int(utils.RpcGetLogsMaxResultsFlag.Name),
+		GetLogsTimeout:              ctx.Duration(utils.RpcGetLogsTimeoutFlag.Name),
+		StateReadsLimit:             ctx.Int(utils.RpcStateReadsLimitFlag.Name),
 
 		OtsMaxPageSize: ctx.Uint64(utils.OtsSearchMaxCapFlag.Name),
 
@@ -531,6 +564,8 @@ func setPrivateApi(ctx *cli.Context, cfg *nodecfg.Config) {
 		log.Warn("private.api.ratelimit is too big", "force", maxRateLimit)
 		cfg.PrivateApiRateLimit = maxRateLimit
 	}
+	cfg.StateApiAddr = ctx.String(StateApiAddr.Name)
+	cfg.StateApiToken = ctx.String(StateApiToken.Name)
 	if ctx.Bool(TLSFlag.Name) {
 		certFile := ctx.String(TLSCertFlag.Name)
 		keyFile := ctx.String(TLSKeyFlag.Name)
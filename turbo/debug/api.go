@@ -35,6 +35,7 @@ import (
 	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/metrics"
 	"github.com/ledgerwatch/log/v3"
 )
 
@@ -50,6 +51,8 @@ type HandlerT struct {
 	cpuFile   string
 	traceW    io.WriteCloser
 	traceFile string
+	spans     *metrics.SpansFile
+	spansFile string
 }
 
 // Verbosity sets the log verbosity ceiling. The verbosity of individual packages
@@ -144,6 +147,37 @@ func (h *HandlerT) GoTrace(file string, nsec uint) error {
 	return nil
 }
 
+// StartSpans turns on export of OpenTelemetry spans to the given file.
+func (h *HandlerT) StartSpans(file string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.spans != nil {
+		return errors.New("spans export already in progress")
+	}
+	spans, err := metrics.StartSpansFile(expandHome(file))
+	if err != nil {
+		return err
+	}
+	h.spans = spans
+	h.spansFile = file
+	log.Info("OpenTelemetry spans export started", "dump", h.spansFile)
+	return nil
+}
+
+// StopSpans flushes and closes file of spans export.
+func (h *HandlerT) StopSpans() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.spans == nil {
+		return errors.New("spans export not in progress")
+	}
+	log.Info("Done writing OpenTelemetry spans", "dump", h.spansFile)
+	err := h.spans.Close()
+	h.spans = nil
+	h.spansFile = ""
+	return err
+}
+
 // BlockProfile turns on goroutine profiling for nsec seconds and writes profile data to
 // file. It uses a profile rate of 1 for most accurate information. If a different rate is
 // desired, set the rate and write the profile manually.
@@ -78,12 +78,16 @@ var (
 		Name:  "trace",
 		Usage: "Write execution trace to the given file",
 	}
+	traceSpansFlag = cli.StringFlag{
+		Name:  "trace.spans",
+		Usage: "Write OpenTelemetry spans (collate, build, merge and prune of state files, commitment) to the given file, one JSON object per line",
+	}
 )
 
 // Flags holds all command-line flags required for debugging.
 var Flags = []cli.Flag{
 	&pprofFlag, &pprofAddrFlag, &pprofPortFlag,
-	&cpuprofileFlag, &traceFlag,
+	&cpuprofileFlag, &traceFlag, &traceSpansFlag,
 }
 
 // SetupCobra sets up logging, profiling and tracing for cobra commands
@@ -197,6 +201,11 @@ func Setup(ctx *cli.Context, rootLogger bool) (log.Logger, *http.ServeMux, error
 			return logger, nil, err
 		}
 	}
+	if spansFile := ctx.String(traceSpansFlag.Name); spansFile != "" {
+		if err := Handler.StartSpans(spansFile); err != nil {
+			return logger, nil, err
+		}
+	}
 	pprofEnabled := ctx.Bool(pprofFlag.Name)
 	metricsEnabled := ctx.Bool(metricsEnabledFlag.Name)
 	metricsAddr := ctx.String(metricsAddrFlag.Name)
@@ -250,6 +259,7 @@ func StartPProf(address string, metricsMux *http.ServeMux) {
 func Exit() {
 	_ = Handler.StopCPUProfile()
 	_ = Handler.StopGoTrace()
+	_ = Handler.StopSpans()
 }
 
 // RaiseFdLimit raises out the number of allowed file handles per process
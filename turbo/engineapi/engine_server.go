@@ -90,6 +90,7 @@ func (e *EngineServer) Start(
 	base := jsonrpc.NewBaseApi(filters, stateCache, blockReader, agg, httpConfig.WithDatadir, httpConfig.EvmCallTimeout, engineReader, httpConfig.Dirs)
 
 	ethImpl := jsonrpc.NewEthAPI(base, db, eth, txPool, mining, httpConfig.Gascap, httpConfig.ReturnDataLimit, httpConfig.AllowUnprotectedTxs, httpConfig.MaxGetProofRewindBlockCount, e.logger)
+	ethImpl.GetLogsMaxBlocks, ethImpl.GetLogsMaxResults, ethImpl.GetLogsTimeout = httpConfig.GetLogsMaxBlocks, httpConfig.GetLogsMaxResults, httpConfig.GetLogsTimeout
 
 	// engineImpl := NewEngineAPI(base, db, engineBackend)
 	// e.startEngineMessageHandler()
@@ -153,15 +153,34 @@ func (e *EthereumExecutionModule) GetBodiesByHashes(ctx context.Context, req *ex
 }
 
 func (e *EthereumExecutionModule) GetBodiesByRange(ctx context.Context, req *execution.GetBodiesByRangeRequest) (*execution.GetBodiesBatchResponse, error) {
+	bodies := make([]*execution.BlockBody, 0, req.Count)
+
+	// blocks in files are canonical: served from files only, without DB reads
+	var i uint64
+	if e.blockReader != nil {
+		frozen, err := e.blockReader.FrozenRawBodies(ctx, req.Start, req.Count)
+		if err != nil {
+			return nil, err
+		}
+		for _, body := range frozen {
+			bodies = append(bodies, &execution.BlockBody{
+				Transactions: body.Transactions,
+				Withdrawals:  eth1_utils.ConvertWithdrawalsToRpc(body.Withdrawals),
+			})
+		}
+		i = uint64(len(frozen))
+		if i == req.Count {
+			return &execution.GetBodiesBatchResponse{Bodies: bodies}, nil
+		}
+	}
+
 	tx, err := e.db.BeginRo(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	bodies := make([]*execution.BlockBody, 0, req.Count)
-
-	for i := uint64(0); i < req.Count; i++ {
+	for ; i < req.Count; i++ {
 		hash, err := rawdb.ReadCanonicalHash(tx, req.Start+i)
 		if err != nil {
 			return nil, err
@@ -193,9 +212,10 @@ func (e *EthereumExecutionModule) GetBodiesByRange(ctx context.Context, req *exe
 	// Remove trailing nil values as per spec
 	// See point 4 in https://github.com/ethereum/execution-apis/blob/main/src/engine/shanghai.md#specification-4
 	for i := len(bodies) - 1; i >= 0; i-- {
-		if bodies[i] == nil {
-			bodies = bodies[:i]
+		if bodies[i] != nil {
+			break
 		}
+		bodies = bodies[:i]
 	}
 
 	return &execution.GetBodiesBatchResponse{
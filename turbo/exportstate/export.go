@@ -0,0 +1,192 @@
+// Package exportstate - streams state of domains (or history ranges) with stable schema, for loading into analytics warehouses.
+package exportstate
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+type Kind uint8
+
+const (
+	KindBytes  Kind = iota // csv: hex without 0x, parquet: BYTE_ARRAY
+	KindUint64             // csv: decimal, parquet: INT64 (UINT_64)
+	KindString             // csv: as is, parquet: BYTE_ARRAY (UTF8)
+)
+
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+var (
+	AccountsColumns = []Column{{"address", KindBytes}, {"nonce", KindUint64}, {"balance", KindString}, {"code_hash", KindBytes}, {"incarnation", KindUint64}}
+	StorageColumns  = []Column{{"address", KindBytes}, {"location", KindBytes}, {"value", KindBytes}}
+	HistoryColumns  = []Column{{"key", KindBytes}, {"tx_num_from", KindUint64}, {"tx_num_to", KindUint64}, {"value_before", KindBytes}}
+)
+
+// Writer - writes rows of values of Column.Kind types: []byte, uint64, string
+type Writer interface {
+	Write(row []any) error
+	// Close - flushes buffered rows, doesn't close underlying writer
+	Close() error
+}
+
+func NewWriter(format string, out io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(out, columns)
+	case FormatParquet:
+		return newParquetWriter(out, columns)
+	default:
+		return nil, fmt.Errorf("unsupported format %q, available: %s, %s", format, FormatCSV, FormatParquet)
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(out io.Writer, columns []Column) (*csvWriter, error) {
+	w := &csvWriter{w: csv.NewWriter(out), record: make([]string, len(columns))}
+	for i, c := range columns {
+		w.record[i] = c.Name
+	}
+	if err := w.w.Write(w.record); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvWriter) Write(row []any) error {
+	if len(row) != len(w.record) {
+		return fmt.Errorf("csv: expected %d values, got %d", len(w.record), len(row))
+	}
+	for i, v := range row {
+		switch v := v.(type) {
+		case []byte:
+			w.record[i] = hex.EncodeToString(v)
+		case uint64:
+			w.record[i] = strconv.FormatUint(v, 10)
+		case string:
+			w.record[i] = v
+		default:
+			return fmt.Errorf("csv: unsupported value type %T", v)
+		}
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Domain - writes state of domain (accounts or storage of `addr`) as of txNum, in AccountsColumns or StorageColumns schema
+func Domain(ctx context.Context, tx kv.TemporalTx, domain string, addr []byte, txNum uint64, w Writer) (rows uint64, err error) {
+	var it iter.KV
+	switch domain {
+	case "accounts":
+		if it, err = tx.DomainRange(kv.AccountsDomain, nil, nil, txNum, order.Asc, -1); err != nil {
+			return 0, err
+		}
+	case "storage":
+		if len(addr) != length.Addr {
+			return 0, fmt.Errorf("storage domain requires address, got %x", addr)
+		}
+		to, _ := kv.NextSubtree(addr)
+		if it, err = tx.DomainRange(kv.StorageDomain, addr, to, txNum, order.Asc, -1); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported domain %q", domain)
+	}
+
+	var acc accounts.Account
+	for it.HasNext() {
+		k, v, err := it.Next()
+		if err != nil {
+			return rows, err
+		}
+		if len(v) == 0 { // deleted as of txNum
+			continue
+		}
+		if domain == "accounts" {
+			if err := acc.DecodeForStorage(v); err != nil {
+				return rows, fmt.Errorf("account %x: %w", k, err)
+			}
+			err = w.Write([]any{k, acc.Nonce, acc.Balance.ToBig().String(), acc.CodeHash[:], acc.Incarnation})
+		} else {
+			err = w.Write([]any{k[:length.Addr], k[length.Addr:], v})
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows++
+		if rows%100_000 == 0 {
+			select {
+			case <-ctx.Done():
+				return rows, ctx.Err()
+			default:
+			}
+		}
+	}
+	return rows, nil
+}
+
+// History - writes value of every key before its first change in [fromTxNum, toTxNum), in HistoryColumns schema
+func History(ctx context.Context, tx kv.TemporalTx, history string, fromTxNum, toTxNum uint64, w Writer) (rows uint64, err error) {
+	var name kv.History
+	switch history {
+	case "accounts":
+		name = kv.AccountsHistory
+	case "storage":
+		name = kv.StorageHistory
+	case "code":
+		name = kv.CodeHistory
+	default:
+		return 0, fmt.Errorf("unsupported history %q", history)
+	}
+	if toTxNum <= fromTxNum {
+		return 0, fmt.Errorf("empty range [%d, %d)", fromTxNum, toTxNum)
+	}
+	it, err := tx.HistoryRange(name, int(fromTxNum), int(toTxNum), order.Asc, -1)
+	if err != nil {
+		return 0, err
+	}
+	for it.HasNext() {
+		k, v, err := it.Next()
+		if err != nil {
+			return rows, err
+		}
+		if err := w.Write([]any{k, fromTxNum, toTxNum, v}); err != nil {
+			return rows, err
+		}
+		rows++
+		if rows%100_000 == 0 {
+			select {
+			case <-ctx.Done():
+				return rows, ctx.Err()
+			default:
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,124 @@
+package exportstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/state"
+
+	"github.com/ledgerwatch/erigon/core/state/temporal"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+// testDB - account `addr` has nonce 1 before txNum 10 and nonce 2 (latest) after
+func testDB(t *testing.T) (kv.RwDB, libcommon.Address) {
+	t.Helper()
+	ctx, logger := context.Background(), log.New()
+	dirs := datadir.New(t.TempDir())
+	dir.MustExist(dirs.SnapHistory, dirs.Tmp)
+	rawDB := memdb.NewTestDB(t)
+	require.NoError(t, rawDB.Update(ctx, func(tx kv.RwTx) error {
+		_, err := kvcfg.HistoryV3.WriteOnce(tx, true)
+		return err
+	}))
+	agg, err := state.NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, 16, rawDB, logger)
+	require.NoError(t, err)
+	t.Cleanup(agg.Close)
+	db, err := temporal.New(rawDB, agg, nil)
+	require.NoError(t, err)
+
+	addr := libcommon.HexToAddress("0x01")
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		latest := accounts.Account{Nonce: 2, Balance: *uint256.NewInt(1000), CodeHash: libcommon.HexToHash("0xcc"), Incarnation: 1}
+		v := make([]byte, latest.EncodingLengthForStorage())
+		latest.EncodeForStorage(v)
+		if err := tx.Put(kv.PlainState, addr[:], v); err != nil {
+			return err
+		}
+
+		agg.SetTx(tx)
+		agg.StartWrites()
+		defer agg.FinishWrites()
+		agg.SetTxNum(10)
+		prev := accounts.Account{Nonce: 1, Balance: *uint256.NewInt(500), CodeHash: libcommon.HexToHash("0xcc"), Incarnation: 1}
+		if err := agg.AddAccountPrev(addr[:], accounts.Serialise(agg.AccountCodec(), &prev)); err != nil {
+			return err
+		}
+		return agg.Flush(ctx, tx)
+	}))
+	return db, addr
+}
+
+func TestDomainCSV(t *testing.T) {
+	db, addr := testDB(t)
+	tx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	for txNum, want := range map[uint64][]string{5: {"1", "500"}, 20: {"2", "1000"}} {
+		var out bytes.Buffer
+		w, err := NewWriter(FormatCSV, &out, AccountsColumns)
+		require.NoError(t, err)
+		rows, err := Domain(context.Background(), tx.(kv.TemporalTx), "accounts", nil, txNum, w)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.EqualValues(t, 1, rows)
+
+		records, err := csv.NewReader(&out).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"address", "nonce", "balance", "code_hash", "incarnation"},
+			{hex.EncodeToString(addr[:]), want[0], want[1], libcommon.HexToHash("0xcc").Hex()[2:], "1"},
+		}, records)
+	}
+
+	_, err = Domain(context.Background(), tx.(kv.TemporalTx), "storage", []byte{1}, 5, nil)
+	require.Error(t, err)
+	_, err = Domain(context.Background(), tx.(kv.TemporalTx), "unknown", nil, 5, nil)
+	require.Error(t, err)
+}
+
+func TestHistoryParquet(t *testing.T) {
+	db, addr := testDB(t)
+	tx, err := db.BeginRo(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	var out bytes.Buffer
+	w, err := NewWriter(FormatParquet, &out, HistoryColumns)
+	require.NoError(t, err)
+	rows, err := History(context.Background(), tx.(kv.TemporalTx), "accounts", 0, 20, w)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.EqualValues(t, 1, rows)
+
+	schema, values := readParquet(t, out.Bytes())
+	for i, c := range HistoryColumns {
+		require.Equal(t, c.Name, string(schema[i][4].([]byte)))
+	}
+	require.Equal(t, [][]any{{addr[:]}, {uint64(0)}, {uint64(20)}, {values[3][0]}}, values)
+	var prev accounts.Account
+	require.NoError(t, accounts.Deserialise(tx.(*temporal.Tx).AggCtx().AccountCodec(), &prev, values[3][0].([]byte)))
+	require.EqualValues(t, 1, prev.Nonce)
+
+	_, err = History(context.Background(), tx.(kv.TemporalTx), "accounts", 20, 20, w)
+	require.Error(t, err)
+}
+
+func TestNewWriterUnsupported(t *testing.T) {
+	_, err := NewWriter("json", &bytes.Buffer{}, HistoryColumns)
+	require.Error(t, err)
+}
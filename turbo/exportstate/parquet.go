@@ -0,0 +1,298 @@
+package exportstate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Minimal Parquet writer: flat schema of REQUIRED columns, PLAIN encoding, no compression, one data page
+// per column chunk. It's enough for warehouses loaders and avoids new dependency for one command.
+// Spec: https://github.com/apache/parquet-format
+
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize - size of buffered column values after which row group is written
+var parquetRowGroupSize = 64 * 1024 * 1024
+
+// parquet.thrift enums
+const (
+	parquetTypeInt64     = 2
+	parquetTypeByteArray = 6
+
+	parquetRequired = 0
+
+	parquetConvertedUTF8   = 0
+	parquetConvertedUint64 = 14
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageData          = 0
+)
+
+type parquetChunk struct {
+	offset, size int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	size   int64
+	rows   int64
+}
+
+type parquetWriter struct {
+	out       io.Writer
+	offset    int64
+	columns   []Column
+	pages     [][]byte // PLAIN-encoded values of current row group, per column
+	buffered  int
+	rows      int64
+	rowGroups []parquetRowGroup
+}
+
+func newParquetWriter(out io.Writer, columns []Column) (*parquetWriter, error) {
+	w := &parquetWriter{out: out, columns: columns, pages: make([][]byte, len(columns))}
+	if err := w.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *parquetWriter) write(b []byte) error {
+	n, err := w.out.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+func (w *parquetWriter) Write(row []any) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: expected %d values, got %d", len(w.columns), len(row))
+	}
+	// check all values before encoding: row must not be written partially
+	for i, c := range w.columns {
+		var ok bool
+		switch c.Kind {
+		case KindUint64:
+			_, ok = row[i].(uint64)
+		case KindBytes:
+			_, ok = row[i].([]byte)
+		case KindString:
+			_, ok = row[i].(string)
+		}
+		if !ok {
+			return fmt.Errorf("parquet: column %s: unexpected value type %T", c.Name, row[i])
+		}
+	}
+	for i, v := range row {
+		before := len(w.pages[i])
+		switch v := v.(type) {
+		case uint64:
+			w.pages[i] = binary.LittleEndian.AppendUint64(w.pages[i], v)
+		case []byte:
+			w.pages[i] = binary.LittleEndian.AppendUint32(w.pages[i], uint32(len(v)))
+			w.pages[i] = append(w.pages[i], v...)
+		case string:
+			w.pages[i] = binary.LittleEndian.AppendUint32(w.pages[i], uint32(len(v)))
+			w.pages[i] = append(w.pages[i], v...)
+		}
+		w.buffered += len(w.pages[i]) - before
+	}
+	w.rows++
+	if w.buffered >= parquetRowGroupSize {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+func (w *parquetWriter) flushRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rg := parquetRowGroup{rows: w.rows, chunks: make([]parquetChunk, len(w.columns))}
+	for i := range w.columns {
+		var e thriftEncoder
+		e.i32(1, parquetPageData)
+		e.i32(2, int32(len(w.pages[i])))
+		e.i32(3, int32(len(w.pages[i])))
+		e.strct(5, func() {
+			e.i32(1, int32(w.rows))
+			e.i32(2, parquetEncodingPlain)
+			e.i32(3, parquetEncodingRLE)
+			e.i32(4, parquetEncodingRLE)
+		})
+		e.stop()
+
+		rg.chunks[i] = parquetChunk{offset: w.offset, size: int64(len(e.buf) + len(w.pages[i]))}
+		rg.size += rg.chunks[i].size
+		if err := w.write(e.buf); err != nil {
+			return err
+		}
+		if err := w.write(w.pages[i]); err != nil {
+			return err
+		}
+		w.pages[i] = w.pages[i][:0]
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.rows, w.buffered = 0, 0
+	return nil
+}
+
+// Close - writes last row group and footer. Doesn't close underlying writer
+func (w *parquetWriter) Close() error {
+	if err := w.flushRowGroup(); err != nil {
+		return err
+	}
+	var numRows int64
+	for _, rg := range w.rowGroups {
+		numRows += rg.rows
+	}
+
+	var e thriftEncoder // FileMetaData
+	e.i32(1, 1)
+	e.list(2, thriftStruct, len(w.columns)+1)
+	e.elem(func() { // root of schema
+		e.binary(4, []byte("schema"))
+		e.i32(5, int32(len(w.columns)))
+	})
+	for _, c := range w.columns {
+		c := c
+		e.elem(func() {
+			e.i32(1, c.parquetType())
+			e.i32(3, parquetRequired)
+			e.binary(4, []byte(c.Name))
+			if converted, ok := c.parquetConvertedType(); ok {
+				e.i32(6, converted)
+			}
+		})
+	}
+	e.i64(3, numRows)
+	e.list(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		rg := rg
+		e.elem(func() {
+			e.list(1, thriftStruct, len(rg.chunks))
+			for i, chunk := range rg.chunks {
+				c, chunk := w.columns[i], chunk
+				e.elem(func() { // ColumnChunk
+					e.i64(2, chunk.offset)
+					e.strct(3, func() { // ColumnMetaData
+						e.i32(1, c.parquetType())
+						e.list(2, thriftI32, 2)
+						e.elemI32(parquetEncodingPlain)
+						e.elemI32(parquetEncodingRLE)
+						e.list(3, thriftBinary, 1)
+						e.elemBinary([]byte(c.Name))
+						e.i32(4, parquetCodecUncompressed)
+						e.i64(5, rg.rows)
+						e.i64(6, chunk.size)
+						e.i64(7, chunk.size)
+						e.i64(9, chunk.offset)
+					})
+				})
+			}
+			e.i64(2, rg.size)
+			e.i64(3, rg.rows)
+		})
+	}
+	e.binary(6, []byte("erigon export_state"))
+	e.stop()
+
+	if err := w.write(e.buf); err != nil {
+		return err
+	}
+	if err := w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(e.buf)))); err != nil {
+		return err
+	}
+	return w.write([]byte(parquetMagic))
+}
+
+func (c Column) parquetType() int32 {
+	if c.Kind == KindUint64 {
+		return parquetTypeInt64
+	}
+	return parquetTypeByteArray
+}
+
+func (c Column) parquetConvertedType() (int32, bool) {
+	switch c.Kind {
+	case KindUint64:
+		return parquetConvertedUint64, true
+	case KindString:
+		return parquetConvertedUTF8, true
+	default:
+		return 0, false
+	}
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftEncoder - writer of thrift compact protocol, only types which Parquet metadata needs.
+// Fields of struct must be written in ascending order of ids, struct is finished by stop()
+type thriftEncoder struct {
+	buf    []byte
+	lastID int16
+}
+
+func (e *thriftEncoder) field(id int16, typ byte) {
+	if delta := id - e.lastID; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.buf = binary.AppendVarint(e.buf, int64(id))
+	}
+	e.lastID = id
+}
+
+func (e *thriftEncoder) i32(id int16, v int32) {
+	e.field(id, thriftI32)
+	e.elemI32(v)
+}
+
+func (e *thriftEncoder) i64(id int16, v int64) {
+	e.field(id, thriftI64)
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *thriftEncoder) binary(id int16, v []byte) {
+	e.field(id, thriftBinary)
+	e.elemBinary(v)
+}
+
+func (e *thriftEncoder) strct(id int16, fields func()) {
+	e.field(id, thriftStruct)
+	e.elem(fields)
+}
+
+func (e *thriftEncoder) list(id int16, elemType byte, size int) {
+	e.field(id, thriftList)
+	if size < 15 {
+		e.buf = append(e.buf, byte(size)<<4|elemType)
+		return
+	}
+	e.buf = append(e.buf, 0xf0|elemType)
+	e.buf = binary.AppendUvarint(e.buf, uint64(size))
+}
+
+// elem - writes struct without field header: element of list or value of struct field
+func (e *thriftEncoder) elem(fields func()) {
+	lastID := e.lastID
+	e.lastID = 0
+	fields()
+	e.stop()
+	e.lastID = lastID
+}
+
+func (e *thriftEncoder) elemI32(v int32) { e.buf = binary.AppendVarint(e.buf, int64(v)) }
+func (e *thriftEncoder) elemBinary(v []byte) {
+	e.buf = append(binary.AppendUvarint(e.buf, uint64(len(v))), v...)
+}
+func (e *thriftEncoder) stop() { e.buf = append(e.buf, 0) }
@@ -0,0 +1,170 @@
+package exportstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// thriftDecoder - generic reader of thrift compact protocol: struct is map of field id to value,
+// list is []any, integers are int64, binary is []byte
+type thriftDecoder struct{ b []byte }
+
+func (d *thriftDecoder) byte() byte {
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *thriftDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *thriftDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *thriftDecoder) strct() map[int16]any {
+	fields := map[int16]any{}
+	var id int16
+	for {
+		h := d.byte()
+		if h == 0 {
+			return fields
+		}
+		if delta := h >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(d.varint())
+		}
+		fields[id] = d.value(h & 0x0f)
+	}
+}
+
+func (d *thriftDecoder) value(typ byte) any {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 3:
+		return int64(d.byte())
+	case 4, 5, 6:
+		return d.varint()
+	case 8:
+		n := d.uvarint()
+		v := d.b[:n]
+		d.b = d.b[n:]
+		return v
+	case 9:
+		h := d.byte()
+		size := uint64(h >> 4)
+		if size == 15 {
+			size = d.uvarint()
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = d.value(h & 0x0f)
+		}
+		return list
+	case 12:
+		return d.strct()
+	default:
+		panic(typ)
+	}
+}
+
+// readParquet - reads all values of flat parquet file by columns, using only footer metadata
+func readParquet(t *testing.T, data []byte) (schema []map[int16]any, columns [][]any) {
+	t.Helper()
+	require.Equal(t, parquetMagic, string(data[:4]))
+	require.Equal(t, parquetMagic, string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	d := &thriftDecoder{b: data[len(data)-8-footerLen : len(data)-8]}
+	meta := d.strct()
+	require.Empty(t, d.b)
+
+	for _, el := range meta[2].([]any)[1:] {
+		schema = append(schema, el.(map[int16]any))
+	}
+	columns = make([][]any, len(schema))
+	var rows int64
+	for _, rg := range meta[4].([]any) {
+		rg := rg.(map[int16]any)
+		rows += rg[3].(int64)
+		for i, chunk := range rg[1].([]any) {
+			colMeta := chunk.(map[int16]any)[3].(map[int16]any)
+			require.Equal(t, schema[i][4], colMeta[3].([]any)[0])
+			require.Equal(t, rg[3], colMeta[5])
+
+			offset := colMeta[9].(int64)
+			page := &thriftDecoder{b: data[offset:]}
+			header := page.strct()
+			require.EqualValues(t, parquetPageData, header[1])
+			require.EqualValues(t, parquetEncodingPlain, header[5].(map[int16]any)[2])
+			require.Equal(t, colMeta[7], int64(len(data[offset:])-len(page.b))+header[3].(int64))
+			values := page.b[:header[3].(int64)]
+			for n := int64(0); n < rg[3].(int64); n++ {
+				if schema[i][1].(int64) == parquetTypeInt64 {
+					columns[i] = append(columns[i], binary.LittleEndian.Uint64(values))
+					values = values[8:]
+					continue
+				}
+				l := binary.LittleEndian.Uint32(values)
+				columns[i] = append(columns[i], values[4:4+l])
+				values = values[4+l:]
+			}
+			require.Empty(t, values)
+		}
+	}
+	require.Equal(t, meta[3], rows)
+	return schema, columns
+}
+
+func TestParquetWriter(t *testing.T) {
+	defer func(size int) { parquetRowGroupSize = size }(parquetRowGroupSize)
+	parquetRowGroupSize = 64 // force few row groups
+
+	columns := []Column{{"key", KindBytes}, {"num", KindUint64}, {"name", KindString}}
+	var out bytes.Buffer
+	w, err := NewWriter(FormatParquet, &out, columns)
+	require.NoError(t, err)
+	const rows = 20
+	for i := uint64(0); i < rows; i++ {
+		require.NoError(t, w.Write([]any{[]byte{byte(i), 0xff}, i * 1_000_000_000_000, "row"}))
+	}
+	require.Error(t, w.Write([]any{[]byte{1}, "wrong type", "row"}))
+	require.NoError(t, w.Close())
+
+	schema, values := readParquet(t, out.Bytes())
+	require.Len(t, schema, 3)
+	for i, c := range columns {
+		require.Equal(t, c.Name, string(schema[i][4].([]byte)))
+		require.EqualValues(t, parquetRequired, schema[i][3])
+	}
+	require.EqualValues(t, parquetConvertedUint64, schema[1][6])
+	require.EqualValues(t, parquetConvertedUTF8, schema[2][6])
+	require.NotContains(t, schema[0], int16(6))
+
+	for i := uint64(0); i < rows; i++ {
+		require.Equal(t, []byte{byte(i), 0xff}, values[0][i])
+		require.Equal(t, i*1_000_000_000_000, values[1][i])
+		require.Equal(t, "row", string(values[2][i].([]byte)))
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(FormatParquet, &out, HistoryColumns)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	schema, values := readParquet(t, out.Bytes())
+	require.Len(t, schema, len(HistoryColumns))
+	for _, col := range values {
+		require.Empty(t, col)
+	}
+}
@@ -0,0 +1,197 @@
+// Package firehose - emits blocks and their flat state changes for downstream indexers which consume
+// node output live (same data as KV.StateChanges gRPC stream), in Firehose formats:
+//
+// 1. Firehose 3.0 console reader framing - one line per message, fields are separated by space:
+//
+//	FIRE INIT 3.0 remote.StateChange
+//	FIRE BLOCK <block_num> <block_hash_hex> <parent_num> <parent_hash_hex> <lib_num> <timestamp_unix_nano> <base64(proto remote.StateChange)>
+//
+// Unwinds are not printed: reader detects forks by parent hash, as for any other Firehose chain.
+//
+// 2. gRPC `sf.firehose.v2.Stream/Blocks` - live stream of blocks as `google.protobuf.Any` of `remote.StateChange`,
+// with STEP_NEW/STEP_UNDO fork steps (see grpc.go).
+package firehose
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ledgerwatch/log/v3"
+	"google.golang.org/protobuf/proto"
+
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/turbo/shards"
+)
+
+const (
+	ProtocolVersion = "3.0"
+	// BlockType - full name of payload message, type url of `google.protobuf.Any` is built from it
+	BlockType    = "remote.StateChange"
+	BlockTypeURL = "type.googleapis.com/" + BlockType
+)
+
+// subscriberBuffer - amount of blocks which slow gRPC subscriber may lag behind before it's dropped
+const subscriberBuffer = 1024
+
+// HeaderReader - returns header of block, which state changes are emitted. Must not return nil header without error
+type HeaderReader func(ctx context.Context, number uint64, hash libcommon.Hash) (*types.Header, error)
+
+type block struct {
+	num, parentNum uint64
+	hash           libcommon.Hash
+	parentHash     libcommon.Hash
+	lib            uint64
+	timeNanos      uint64
+	undo           bool
+	payload        []byte
+}
+
+// Printer - shards.StateChangeConsumer which emits every block of batch to output file and to gRPC subscribers,
+// and passes batch to `next`
+type Printer struct {
+	mu          sync.Mutex
+	out         io.Writer // nil if file output is disabled
+	w           *bufio.Writer
+	headers     HeaderReader
+	subscribers map[chan *block]struct{}
+	next        shards.StateChangeConsumer
+	logger      log.Logger
+}
+
+// NewPrinter - `out` may be nil if only gRPC stream is served. Printer owns `out` and closes it in Close (except os.Stdout)
+func NewPrinter(out io.Writer, headers HeaderReader, next shards.StateChangeConsumer, logger log.Logger) (*Printer, error) {
+	p := &Printer{out: out, headers: headers, subscribers: map[chan *block]struct{}{}, next: next, logger: logger}
+	if out == nil {
+		return p, nil
+	}
+	p.w = bufio.NewWriter(out)
+	if _, err := fmt.Fprintf(p.w, "FIRE INIT %s %s\n", ProtocolVersion, BlockType); err != nil {
+		return nil, err
+	}
+	if err := p.w.Flush(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Printer) SendStateChanges(ctx context.Context, sc *remote.StateChangeBatch) {
+	if err := p.emit(ctx, sc); err != nil {
+		p.logger.Warn("[firehose] emit failed", "err", err)
+	}
+	if p.next != nil {
+		p.next.SendStateChanges(ctx, sc)
+	}
+}
+
+// Close - flushes and closes output file, drops all gRPC subscribers
+func (p *Printer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subscribers {
+		delete(p.subscribers, ch)
+		close(ch)
+	}
+	if p.w == nil {
+		return nil
+	}
+	err := p.w.Flush()
+	p.w = nil
+	if c, ok := p.out.(io.Closer); ok && p.out != os.Stdout {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (p *Printer) emit(ctx context.Context, sc *remote.StateChangeBatch) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.w == nil && len(p.subscribers) == 0 {
+		return nil
+	}
+	for _, change := range sc.ChangeBatch {
+		b, err := p.makeBlock(ctx, change, sc.FinalizedBlock)
+		if err != nil {
+			return err
+		}
+		p.broadcast(b)
+		if p.w == nil || b.undo {
+			continue
+		}
+		if _, err := fmt.Fprintf(p.w, "FIRE BLOCK %d %x %d %x %d %d %s\n", b.num, b.hash, b.parentNum, b.parentHash, b.lib, b.timeNanos, base64.StdEncoding.EncodeToString(b.payload)); err != nil {
+			return err
+		}
+	}
+	if p.w == nil {
+		return nil
+	}
+	// consumer must see whole block as soon as it's executed
+	return p.w.Flush()
+}
+
+func (p *Printer) makeBlock(ctx context.Context, change *remote.StateChange, finalized uint64) (*block, error) {
+	payload, err := proto.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+	b := &block{
+		num:     change.BlockHeight,
+		hash:    gointerfaces.ConvertH256ToHash(change.BlockHash),
+		lib:     cmp.Min(finalized, change.BlockHeight),
+		undo:    change.Direction == remote.Direction_UNWIND,
+		payload: payload,
+	}
+	if b.undo {
+		return b, nil
+	}
+	header, err := p.headers(ctx, b.num, b.hash)
+	if err != nil {
+		return nil, fmt.Errorf("header %d %x: %w", b.num, b.hash, err)
+	}
+	if b.num > 0 {
+		b.parentNum = b.num - 1
+	}
+	b.parentHash, b.timeNanos = header.ParentHash, header.Time*1_000_000_000
+	return b, nil
+}
+
+// broadcast - doesn't block execution: subscriber which can't keep up is dropped
+func (p *Printer) broadcast(b *block) {
+	for ch := range p.subscribers {
+		select {
+		case ch <- b:
+		default:
+			p.logger.Warn("[firehose] dropping slow subscriber", "block", b.num)
+			delete(p.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (p *Printer) subscribe() chan *block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan *block, subscriberBuffer)
+	p.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (p *Printer) unsubscribe(ch chan *block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.subscribers[ch]; ok {
+		delete(p.subscribers, ch)
+		close(ch)
+	}
+}
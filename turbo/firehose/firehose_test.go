@@ -0,0 +1,163 @@
+package firehose
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+
+	"github.com/ledgerwatch/erigon/core/types"
+)
+
+type consumerMock struct{ batches int }
+
+func (c *consumerMock) SendStateChanges(_ context.Context, _ *remote.StateChangeBatch) { c.batches++ }
+
+var (
+	testHash   = libcommon.HexToHash("0xaa")
+	testParent = libcommon.HexToHash("0xbb")
+	testAddr   = libcommon.HexToAddress("0x01")
+)
+
+func testHeaders(_ context.Context, number uint64, hash libcommon.Hash) (*types.Header, error) {
+	if hash != testHash {
+		return nil, fmt.Errorf("unknown block %d %x", number, hash)
+	}
+	return &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: testParent, Time: 1700000000}, nil
+}
+
+func testBatch() *remote.StateChangeBatch {
+	return &remote.StateChangeBatch{FinalizedBlock: 7, ChangeBatch: []*remote.StateChange{
+		{BlockHeight: 10, BlockHash: gointerfaces.ConvertHashToH256(testHash), Direction: remote.Direction_FORWARD,
+			Changes: []*remote.AccountChange{{Address: gointerfaces.ConvertAddressToH160(testAddr), Action: remote.Action_UPSERT, Data: []byte{1}}}},
+		{BlockHeight: 10, BlockHash: gointerfaces.ConvertHashToH256(testHash), Direction: remote.Direction_UNWIND},
+	}}
+}
+
+func TestPrinter(t *testing.T) {
+	var out bytes.Buffer
+	next := &consumerMock{}
+	p, err := NewPrinter(&out, testHeaders, next, log.New())
+	require.NoError(t, err)
+
+	p.SendStateChanges(context.Background(), testBatch())
+	require.Equal(t, 1, next.batches)
+
+	// unwind is not printed
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, "FIRE INIT 3.0 remote.StateChange", lines[0])
+
+	fields := strings.Split(lines[1], " ")
+	require.Len(t, fields, 9)
+	require.Equal(t, []string{"FIRE", "BLOCK", "10", testHash.Hex()[2:], "9", testParent.Hex()[2:], "7", "1700000000000000000"}, fields[:8])
+	payload, err := base64.StdEncoding.DecodeString(fields[8])
+	require.NoError(t, err)
+	var change remote.StateChange
+	require.NoError(t, proto.Unmarshal(payload, &change))
+	require.Len(t, change.Changes, 1)
+	require.Equal(t, testAddr, libcommon.Address(gointerfaces.ConvertH160toAddress(change.Changes[0].Address)))
+
+	// unknown header: block is not emitted, batch still reaches next consumer
+	out.Reset()
+	p.SendStateChanges(context.Background(), &remote.StateChangeBatch{ChangeBatch: []*remote.StateChange{{BlockHeight: 11, BlockHash: gointerfaces.ConvertHashToH256(testParent), Direction: remote.Direction_FORWARD}}})
+	require.Equal(t, 2, next.batches)
+	require.Empty(t, out.String())
+}
+
+func TestPrinterClose(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), "firehose.log")
+	f, err := os.Create(fPath)
+	require.NoError(t, err)
+	p, err := NewPrinter(f, testHeaders, nil, log.New())
+	require.NoError(t, err)
+	p.SendStateChanges(context.Background(), testBatch())
+	require.NoError(t, p.Close())
+	require.ErrorIs(t, f.Close(), os.ErrClosed)
+
+	data, err := os.ReadFile(fPath)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	// emit after Close is no-op
+	p.SendStateChanges(context.Background(), testBatch())
+	require.NoError(t, p.Close())
+}
+
+func TestStreamServer(t *testing.T) {
+	p, err := NewPrinter(nil, testHeaders, nil, log.New())
+	require.NoError(t, err)
+
+	grpcServer, conn := grpc.NewServer(), bufconn.Listen(1024*1024)
+	RegisterStreamServer(grpcServer, p)
+	go func() {
+		if err := grpcServer.Serve(conn); err != nil {
+			log.Error("firehose RPC server fail", "err", err)
+		}
+	}()
+	defer grpcServer.Stop()
+	cc, err := grpc.Dial("", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, url string) (net.Conn, error) { return conn.Dial() }))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := cc.NewStream(ctx, &streamServiceDesc.Streams[0], "/sf.firehose.v2.Stream/Blocks")
+	require.NoError(t, err)
+	req := dynamicpb.NewMessage(requestDesc)
+	require.NoError(t, stream.SendMsg(req))
+	require.NoError(t, stream.CloseSend())
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.subscribers) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	p.SendStateChanges(context.Background(), testBatch())
+
+	fields := responseDesc.Fields()
+	for _, step := range []int{stepNew, stepUndo} {
+		resp := dynamicpb.NewMessage(responseDesc)
+		require.NoError(t, stream.RecvMsg(resp))
+		require.EqualValues(t, step, resp.Get(fields.ByName("step")).Enum())
+		require.Equal(t, fmt.Sprintf("10:%x", testHash), resp.Get(fields.ByName("cursor")).String())
+
+		var block anypb.Any
+		require.NoError(t, proto.Unmarshal(mustMarshal(t, resp.Get(fields.ByName("block")).Message().Interface()), &block))
+		require.Equal(t, BlockTypeURL, block.TypeUrl)
+		var change remote.StateChange
+		require.NoError(t, block.UnmarshalTo(&change))
+		require.EqualValues(t, 10, change.BlockHeight)
+	}
+
+	// subscription is closed on Close
+	require.NoError(t, p.Close())
+	require.Error(t, stream.RecvMsg(dynamicpb.NewMessage(responseDesc)))
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	data, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,184 @@
+package firehose
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ledgerwatch/log/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
+)
+
+// Subset of `sf/firehose/v2/firehose.proto` which is served: only live stream of `Blocks`.
+// Messages are described here instead of generated code - to not vendor Firehose protos for 2 messages.
+//
+//	service Stream { rpc Blocks(Request) returns (stream Response); }
+//	message Request { int64 start_block_num = 1; string cursor = 2; uint64 stop_block_num = 3; bool final_blocks_only = 4; repeated google.protobuf.Any transforms = 10; }
+//	message Response { google.protobuf.Any block = 1; ForkStep step = 6; string cursor = 10; }
+//	enum ForkStep { STEP_UNSET = 0; STEP_NEW = 1; STEP_UNDO = 2; STEP_FINAL = 3; }
+const (
+	stepNew  = 1
+	stepUndo = 2
+)
+
+var requestDesc, responseDesc = func() (protoreflect.MessageDescriptor, protoreflect.MessageDescriptor) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	transforms := field("transforms", 10, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Any")
+	transforms.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("sf/firehose/v2/firehose.proto"),
+		Package:    proto.String("sf.firehose.v2"),
+		Dependency: []string{anypb.File_google_protobuf_any_proto.Path()},
+		Syntax:     proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("ForkStep"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STEP_UNSET"), Number: proto.Int32(0)},
+				{Name: proto.String("STEP_NEW"), Number: proto.Int32(stepNew)},
+				{Name: proto.String("STEP_UNDO"), Number: proto.Int32(stepUndo)},
+				{Name: proto.String("STEP_FINAL"), Number: proto.Int32(3)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Request"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("start_block_num", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				field("cursor", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("stop_block_num", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+				field("final_blocks_only", 4, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+				transforms,
+			},
+		}, {
+			Name: proto.String("Response"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("block", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Any"),
+				field("step", 6, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".sf.firehose.v2.ForkStep"),
+				field("cursor", 10, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Messages().ByName("Request"), fd.Messages().ByName("Response")
+}()
+
+type streamServer interface {
+	blocks(req *dynamicpb.Message, stream grpc.ServerStream) error
+}
+
+var streamServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sf.firehose.v2.Stream",
+	HandlerType: (*streamServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Blocks",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := dynamicpb.NewMessage(requestDesc)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(streamServer).blocks(req, stream)
+		},
+	}},
+	Metadata: "sf/firehose/v2/firehose.proto",
+}
+
+// RegisterStreamServer - registers `sf.firehose.v2.Stream` service, which streams blocks emitted by `p`
+func RegisterStreamServer(s *grpc.Server, p *Printer) {
+	s.RegisterService(&streamServiceDesc, p)
+}
+
+func StartGrpc(p *Printer, addr string, rateLimit uint32, creds credentials.TransportCredentials, logger log.Logger) (*grpc.Server, error) {
+	logger.Info("Starting firehose RPC server", "on", addr)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create listener: %w, addr=%s", err, addr)
+	}
+
+	grpcServer := grpcutil.NewServer(rateLimit, creds)
+	RegisterStreamServer(grpcServer, p)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("firehose RPC server fail", "err", err)
+		}
+	}()
+	return grpcServer, nil
+}
+
+// blocks - streams blocks executed after subscription. Resume from cursor and history replay are not supported:
+// node keeps only state changes of blocks it's executing now
+func (p *Printer) blocks(req *dynamicpb.Message, stream grpc.ServerStream) error {
+	fields := requestDesc.Fields()
+	if req.Get(fields.ByName("cursor")).String() != "" {
+		return status.Error(codes.Unimplemented, "resume from cursor is not supported")
+	}
+	if req.Get(fields.ByName("final_blocks_only")).Bool() {
+		return status.Error(codes.Unimplemented, "final_blocks_only is not supported")
+	}
+	if req.Get(fields.ByName("transforms")).List().Len() > 0 {
+		return status.Error(codes.Unimplemented, "transforms are not supported")
+	}
+	startNum, stopNum := req.Get(fields.ByName("start_block_num")).Int(), req.Get(fields.ByName("stop_block_num")).Uint()
+	if startNum < 0 {
+		return status.Error(codes.Unimplemented, "relative start_block_num is not supported")
+	}
+
+	ch := p.subscribe()
+	defer p.unsubscribe(ch)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case b, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "subscription closed: consumer is too slow or node is shutting down")
+			}
+			if b.num < uint64(startNum) {
+				continue
+			}
+			if err := stream.SendMsg(newResponse(b)); err != nil {
+				return err
+			}
+			if stopNum > 0 && !b.undo && b.num >= stopNum {
+				return nil
+			}
+		}
+	}
+}
+
+func newResponse(b *block) *dynamicpb.Message {
+	fields := responseDesc.Fields()
+	step := protoreflect.EnumNumber(stepNew)
+	if b.undo {
+		step = stepUndo
+	}
+	resp := dynamicpb.NewMessage(responseDesc)
+	resp.Set(fields.ByName("block"), protoreflect.ValueOfMessage((&anypb.Any{TypeUrl: BlockTypeURL, Value: b.payload}).ProtoReflect()))
+	resp.Set(fields.ByName("step"), protoreflect.ValueOfEnum(step))
+	resp.Set(fields.ByName("cursor"), protoreflect.ValueOfString(fmt.Sprintf("%d:%x", b.num, b.hash)))
+	return resp
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	libstate "github.com/ledgerwatch/erigon-lib/state"
 	"github.com/ledgerwatch/erigon/p2p"
 
 	"github.com/ledgerwatch/erigon/turbo/rpchelper"
@@ -22,17 +24,39 @@ type AdminAPI interface {
 
 	// AddPeer requests connecting to a remote node.
 	AddPeer(ctx context.Context, url string) (bool, error)
+
+	// BuildStateFiles starts background build of state files of all data in DB.
+	BuildStateFiles(ctx context.Context) error
+
+	// MergeStateFiles merges files of component (accounts, storage, code, logaddrs, logtopics, tracesfrom,
+	// tracesto, txlookup) in steps [fromStep, toStep) and returns names of merged files.
+	MergeStateFiles(ctx context.Context, component string, fromStep, toStep uint64) ([]string, error)
+
+	// PruneState requests prune of state data which is already in files, with time budget (like "30s"),
+	// on next execution cycle.
+	PruneState(ctx context.Context, budget string) error
+
+	// PauseStateBackground stops background build, merge and prune of state files until ResumeStateBackground.
+	PauseStateBackground(ctx context.Context) error
+
+	// ResumeStateBackground resumes background build, merge and prune of state files.
+	ResumeStateBackground(ctx context.Context) error
+
+	// StateFiles returns lifecycle state of all state files: range, refcount, canDelete, opened indices.
+	StateFiles(ctx context.Context) ([]libstate.FileState, error)
 }
 
 // AdminAPIImpl data structure to store things needed for admin_* commands.
 type AdminAPIImpl struct {
 	ethBackend rpchelper.ApiBackend
+	agg        *libstate.AggregatorV3
 }
 
 // NewAdminAPI returns AdminAPIImpl instance.
-func NewAdminAPI(eth rpchelper.ApiBackend) *AdminAPIImpl {
+func NewAdminAPI(eth rpchelper.ApiBackend, agg *libstate.AggregatorV3) *AdminAPIImpl {
 	return &AdminAPIImpl{
 		ethBackend: eth,
+		agg:        agg,
 	}
 }
 
@@ -63,3 +87,59 @@ func (api *AdminAPIImpl) AddPeer(ctx context.Context, url string) (bool, error)
 	}
 	return result.Success, nil
 }
+
+var errNoStateFiles = errors.New("state files are not available: HistoryV3 is not enabled")
+
+func (api *AdminAPIImpl) BuildStateFiles(ctx context.Context) error {
+	if api.agg == nil {
+		return errNoStateFiles
+	}
+	return api.agg.TriggerFilesBuild()
+}
+
+func (api *AdminAPIImpl) MergeStateFiles(ctx context.Context, component string, fromStep, toStep uint64) ([]string, error) {
+	if api.agg == nil {
+		return nil, errNoStateFiles
+	}
+	return api.agg.MergeRange(ctx, component, fromStep, toStep, 1)
+}
+
+func (api *AdminAPIImpl) PruneState(ctx context.Context, budget string) error {
+	if api.agg == nil {
+		return errNoStateFiles
+	}
+	d, err := time.ParseDuration(budget)
+	if err != nil {
+		return fmt.Errorf("budget: %w", err)
+	}
+	return api.agg.RequestPrune(d)
+}
+
+func (api *AdminAPIImpl) PauseStateBackground(ctx context.Context) error {
+	if api.agg == nil {
+		return errNoStateFiles
+	}
+	if api.agg.ReadOnly() {
+		return libstate.ErrAggregatorReadOnly
+	}
+	api.agg.PauseBackground()
+	return nil
+}
+
+func (api *AdminAPIImpl) ResumeStateBackground(ctx context.Context) error {
+	if api.agg == nil {
+		return errNoStateFiles
+	}
+	if api.agg.ReadOnly() {
+		return libstate.ErrAggregatorReadOnly
+	}
+	api.agg.ResumeBackground()
+	return nil
+}
+
+func (api *AdminAPIImpl) StateFiles(ctx context.Context) ([]libstate.FileState, error) {
+	if api.agg == nil {
+		return nil, errNoStateFiles
+	}
+	return api.agg.FilesState(), nil
+}
@@ -177,6 +177,33 @@ func TestFilterNoAddresses(t *testing.T) {
 	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, blockNumbersFromTraces(t, stream.Buffer()))
 }
 
+func TestFilterAfterCount(t *testing.T) {
+	m := mock.Mock(t)
+	chain, err := core.GenerateChain(m.ChainConfig, m.Genesis, m.Engine, m.DB, 10, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(common.Address{1})
+	})
+	require.NoError(t, err, "generate chain")
+	require.NoError(t, m.InsertChain(chain), "inserting chain")
+	api := NewTraceAPI(newBaseApiForTest(m), m.DB, &httpcfg.HttpCfg{})
+
+	stream := jsoniter.ConfigDefault.BorrowStream(nil)
+	defer jsoniter.ConfigDefault.ReturnStream(stream)
+	fromBlock, toBlock := uint64(1), uint64(100) // toBlock is after head
+	after, count := uint64(2), uint64(3)
+	toAddress1 := common.Address{1}
+	traceReq1 := TraceFilterRequest{
+		FromBlock: (*hexutil.Uint64)(&fromBlock),
+		ToBlock:   (*hexutil.Uint64)(&toBlock),
+		ToAddress: []*common.Address{&toAddress1, nil},
+		After:     &after,
+		Count:     &count,
+	}
+	if err = api.Filter(context.Background(), traceReq1, new(bool), stream); err != nil {
+		t.Fatalf("trace_filter failed: %v", err)
+	}
+	assert.Equal(t, []int{3, 4, 5}, blockNumbersFromTraces(t, stream.Buffer()))
+}
+
 func TestFilterAddressIntersection(t *testing.T) {
 	m := mock.Mock(t)
 	api := NewTraceAPI(newBaseApiForTest(m), m.DB, &httpcfg.HttpCfg{})
@@ -23,6 +23,7 @@ func APIList(db kv.RoDB, eth rpchelper.ApiBackend, txPool txpool.TxpoolClient, m
 ) (list []rpc.API) {
 	base := NewBaseApi(filters, stateCache, blockReader, agg, cfg.WithDatadir, cfg.EvmCallTimeout, engine, cfg.Dirs)
 	ethImpl := NewEthAPI(base, db, eth, txPool, mining, cfg.Gascap, cfg.ReturnDataLimit, cfg.AllowUnprotectedTxs, cfg.MaxGetProofRewindBlockCount, logger)
+	ethImpl.GetLogsMaxBlocks, ethImpl.GetLogsMaxResults, ethImpl.GetLogsTimeout = cfg.GetLogsMaxBlocks, cfg.GetLogsMaxResults, cfg.GetLogsTimeout
 	erigonImpl := NewErigonAPI(base, db, eth)
 	txpoolImpl := NewTxPoolAPI(base, db, txPool)
 	netImpl := NewNetAPIImpl(eth)
@@ -30,7 +31,7 @@ func APIList(db kv.RoDB, eth rpchelper.ApiBackend, txPool txpool.TxpoolClient, m
 	traceImpl := NewTraceAPI(base, db, cfg)
 	web3Impl := NewWeb3APIImpl(eth)
 	dbImpl := NewDBAPIImpl() /* deprecated */
-	adminImpl := NewAdminAPI(eth)
+	adminImpl := NewAdminAPI(eth, agg)
 	parityImpl := NewParityAPIImpl(base, db)
 
 	var borImpl *BorImpl
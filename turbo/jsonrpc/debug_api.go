@@ -10,6 +10,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 
@@ -76,11 +77,15 @@ func (api *PrivateDebugAPIImpl) StorageRangeAt(ctx context.Context, blockHash co
 
 	if api.historyV3(tx) {
 		number := rawdb.ReadHeaderNumber(tx, blockHash)
+		if number == nil {
+			return StorageRangeResult{}, nil
+		}
 		minTxNum, err := rawdbv3.TxNums.Min(tx, *number)
 		if err != nil {
 			return StorageRangeResult{}, err
 		}
-		return storageRangeAtV3(tx.(kv.TemporalTx), contractAddress, keyStart, minTxNum+txIndex, maxResult)
+		// state before transaction `txIndex`: first txNum of block is system tx
+		return storageRangeAtV3(tx.(kv.TemporalTx), contractAddress, keyStart, minTxNum+txIndex+1, maxResult)
 	}
 
 	block, err := api.blockByHashWithSenders(tx, blockHash)
@@ -200,15 +205,26 @@ func (api *PrivateDebugAPIImpl) GetModifiedAccountsByNumber(ctx context.Context,
 		if err != nil {
 			return nil, err
 		}
-		return getModifiedAccountsV3(tx.(kv.TemporalTx), startTxNum, endTxNum)
+		return getModifiedAccountsV3(tx.(kv.TemporalTx), startTxNum, endTxNum+1)
 	}
 	return changeset.GetModifiedAccounts(tx, startNum, endNum)
 }
 
-// getModifiedAccountsV3 returns a list of addresses that were modified in the block range
-// [startNum:endNum)
+// historyChangedKeys - implemented by local TemporalTx: keys of history changed in txNum range, from inverted index only
+type historyChangedKeys interface {
+	HistoryChangedKeys(name kv.History, fromTs, toTs uint64) (iter.KV, error)
+}
+
+// getModifiedAccountsV3 returns a list of addresses that were modified in the txNum range
+// [startTxNum:endTxNum)
 func getModifiedAccountsV3(tx kv.TemporalTx, startTxNum, endTxNum uint64) ([]common.Address, error) {
-	it, err := tx.HistoryRange(kv.AccountsHistory, int(startTxNum), int(endTxNum), order.Asc, kv.Unlim)
+	var it iter.KV
+	var err error
+	if ttx, ok := tx.(historyChangedKeys); ok {
+		it, err = ttx.HistoryChangedKeys(kv.AccountsHistory, startTxNum, endTxNum)
+	} else {
+		it, err = tx.HistoryRange(kv.AccountsHistory, int(startTxNum), int(endTxNum), order.Asc, kv.Unlim)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -279,7 +295,7 @@ func (api *PrivateDebugAPIImpl) GetModifiedAccountsByHash(ctx context.Context, s
 		if err != nil {
 			return nil, err
 		}
-		return getModifiedAccountsV3(tx.(kv.TemporalTx), startTxNum, endTxNum)
+		return getModifiedAccountsV3(tx.(kv.TemporalTx), startTxNum, endTxNum+1)
 	}
 	return changeset.GetModifiedAccounts(tx, startNum, endNum)
 }
@@ -315,8 +331,12 @@ func (api *PrivateDebugAPIImpl) AccountAt(ctx context.Context, blockHash common.
 			return &AccountResult{}, nil
 		}
 
+		accountCodec, err := state.AccountCodec(tx)
+		if err != nil {
+			return nil, err
+		}
 		var a accounts.Account
-		if err := accounts.DeserialiseV3(&a, v); err != nil {
+		if err := accounts.Deserialise(accountCodec, &a, v); err != nil {
 			return nil, err
 		}
 		result := &AccountResult{}
@@ -276,6 +276,24 @@ func TestStorageRangeAt(t *testing.T) {
 		if !reflect.DeepEqual(result, expect) {
 			t.Fatalf("wrong result:\ngot %s\nwant %s", dumper.Sdump(result), dumper.Sdump(&expect))
 		}
+
+		// paginate by 1
+		all := storageMap{}
+		var next []byte
+		for i := 0; i < len(storage)+1; i++ {
+			result, err = api.StorageRangeAt(m.Ctx, latestBlock.Hash(), 0, addr, next, 1)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(result.Storage), 1)
+			for k, v := range result.Storage {
+				all[k] = v
+			}
+			if result.NextKey == nil {
+				break
+			}
+			next = result.NextKey.Bytes()
+		}
+		require.Nil(t, result.NextKey)
+		require.Equal(t, storage, all)
 	})
 
 }
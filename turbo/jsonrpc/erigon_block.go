@@ -18,6 +18,7 @@ import (
 	"github.com/ledgerwatch/erigon/turbo/services"
 
 	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/state"
 	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/core/types/accounts"
 	"github.com/ledgerwatch/erigon/rpc"
@@ -221,6 +222,10 @@ func (api *ErigonImpl) GetBalanceChangesInBlock(ctx context.Context, blockNrOrHa
 
 	if api.historyV3(tx) {
 		minTxNum, _ := rawdbv3.TxNums.Min(tx, blockNumber)
+		accountCodec, err := state.AccountCodec(tx)
+		if err != nil {
+			return nil, err
+		}
 		it, err := tx.(kv.TemporalTx).HistoryRange(kv.AccountsHistory, int(minTxNum), -1, order.Asc, -1)
 		if err != nil {
 			return nil, err
@@ -233,7 +238,7 @@ func (api *ErigonImpl) GetBalanceChangesInBlock(ctx context.Context, blockNrOrHa
 
 			var oldAcc accounts.Account
 			if len(v) > 0 {
-				if err = accounts.DeserialiseV3(&oldAcc, v); err != nil {
+				if err = accounts.Deserialise(accountCodec, &oldAcc, v); err != nil {
 					return nil, err
 				}
 			}
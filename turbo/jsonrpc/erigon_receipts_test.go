@@ -35,6 +35,13 @@ func TestGetLogs(t *testing.T) {
 		assert.NoError(err)
 		assert.Equal(uint64(10), logs[0].BlockNumber)
 
+		// range is filtered by steps, result doesn't depend on step
+		defer func(step uint64) { getLogsStepBlocks = step }(getLogsStepBlocks)
+		getLogsStepBlocks = 3
+		stepLogs, err := ethApi.GetLogs(context.Background(), filters.FilterCriteria{FromBlock: big.NewInt(0), ToBlock: big.NewInt(10)})
+		assert.NoError(err)
+		assert.Equal(logs, stepLogs)
+
 		// filter by wrong address
 		logs, err = ethApi.GetLogs(context.Background(), filters.FilterCriteria{
 			FromBlock: big.NewInt(10),
@@ -52,6 +59,17 @@ func TestGetLogs(t *testing.T) {
 		})
 		assert.NoError(err)
 		assert.Equal(1, len(logs))
+
+		// budgets
+		ethApi.GetLogsMaxResults = 1
+		_, err = ethApi.GetLogs(m.Ctx, filters.FilterCriteria{FromBlock: big.NewInt(0), ToBlock: big.NewInt(10)})
+		assert.Error(err)
+		ethApi.GetLogsMaxResults, ethApi.GetLogsMaxBlocks = 0, 1
+		_, err = ethApi.GetLogs(m.Ctx, filters.FilterCriteria{FromBlock: big.NewInt(0), ToBlock: big.NewInt(10)})
+		assert.Error(err)
+		logs, err = ethApi.GetLogs(m.Ctx, filters.FilterCriteria{FromBlock: big.NewInt(10), ToBlock: big.NewInt(10)})
+		assert.NoError(err)
+		assert.NotEmpty(logs)
 	}
 }
 
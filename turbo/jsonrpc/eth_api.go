@@ -23,6 +23,8 @@ import (
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/kvcache"
 	"github.com/ledgerwatch/erigon-lib/kv/kvcfg"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 	libstate "github.com/ledgerwatch/erigon-lib/state"
 	types2 "github.com/ledgerwatch/erigon-lib/types"
 
@@ -90,7 +92,7 @@ type EthAPI interface {
 	GasPrice(_ context.Context) (*hexutil.Big, error)
 
 	// Sending related (see ./eth_call.go)
-	Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *ethapi2.StateOverrides) (hexutility.Bytes, error)
+	Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *ethapi2.StateOverrides, blockOverrides *BlockOverrides) (hexutility.Bytes, error)
 	EstimateGas(ctx context.Context, argsOrNil *ethapi2.CallArgs, blockNrOrHash *rpc.BlockNumberOrHash) (hexutil.Uint64, error)
 	SendRawTransaction(ctx context.Context, encodedTx hexutility.Bytes) (common.Hash, error)
 	SendTransaction(_ context.Context, txObject interface{}) (common.Hash, error)
@@ -155,9 +157,33 @@ func (api *BaseAPI) genesis(tx kv.Tx) (*types.Block, error) {
 }
 
 func (api *BaseAPI) txnLookup(tx kv.Tx, txnHash common.Hash) (uint64, bool, error) {
+	if ttx, ok := tx.(kv.TemporalTx); ok && api.historyV3(tx) {
+		blockNum, ok, err := txnLookupV3(ttx, txnHash)
+		if err != nil || ok {
+			return blockNum, ok, err
+		}
+		// txs executed before TxLookupIdx appeared are not indexed
+	}
 	return api._txnReader.TxnLookup(context.Background(), tx, txnHash)
 }
 
+// txnLookupV3 - resolves txHash by TxLookupIdx (mostly frozen files) instead of TxLookup table
+func txnLookupV3(tx kv.TemporalTx, txnHash common.Hash) (blockNum uint64, ok bool, err error) {
+	it, err := tx.IndexRange(kv.TxLookupIdx, txnHash[:], 0, -1, order.Asc, 1)
+	if err != nil {
+		return 0, false, err
+	}
+	if !it.HasNext() {
+		return 0, false, nil
+	}
+	txNum, err := it.Next()
+	if err != nil {
+		return 0, false, err
+	}
+	ok, blockNum, err = rawdbv3.TxNums.FindBlockNum(tx, txNum)
+	return blockNum, ok, err
+}
+
 func (api *BaseAPI) blockByNumberWithSenders(tx kv.Tx, number uint64) (*types.Block, error) {
 	hash, hashErr := api._blockReader.CanonicalHash(context.Background(), tx, number)
 	if hashErr != nil {
@@ -191,6 +217,9 @@ func (api *BaseAPI) blockWithSenders(tx kv.Tx, hash common.Hash, number uint64)
 		return nil, err
 	}
 	if block == nil { // don't save nil's to cache
+		if err := api.checkBlockExpired(number); err != nil {
+			return nil, err
+		}
 		return nil, nil
 	}
 	// don't save empty blocks to cache, because in Erigon
@@ -274,7 +303,18 @@ func (api *BaseAPI) headerByRPCNumber(number rpc.BlockNumber, tx kv.Tx) (*types.
 // block in state history or not.  Some strange issues arise getting account
 // history for blocks that have been pruned away giving nonce too low errors
 // etc. as red herrings
+// checkBlockExpired - files of blocks before EarliestAvailableBlock are deleted by history expiry (EIP-4444)
+func (api *BaseAPI) checkBlockExpired(block uint64) error {
+	if earliest := api._blockReader.EarliestAvailableBlock(); block < earliest {
+		return fmt.Errorf("history has been expired for block %d, earliest available block is %d", block, earliest)
+	}
+	return nil
+}
+
 func (api *BaseAPI) checkPruneHistory(tx kv.Tx, block uint64) error {
+	if err := api.checkBlockExpired(block); err != nil {
+		return err
+	}
 	p, err := api.pruneMode(tx)
 	if err != nil {
 		return err
@@ -329,6 +369,11 @@ type APIImpl struct {
 	AllowUnprotectedTxs         bool
 	MaxGetProofRewindBlockCount int
 	logger                      log.Logger
+
+	// eth_getLogs budgets, 0 - unlimited
+	GetLogsMaxBlocks  uint64
+	GetLogsMaxResults int
+	GetLogsTimeout    time.Duration
 }
 
 // NewEthAPI returns APIImpl instance
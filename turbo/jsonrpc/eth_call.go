@@ -39,7 +39,9 @@ import (
 var latestNumOrHash = rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
 
 // Call implements eth_call. Executes a new message call immediately without creating a transaction on the block chain.
-func (api *APIImpl) Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *ethapi2.StateOverrides) (hexutility.Bytes, error) {
+// Optional `blockOverrides` (same position as in geth) - fields of block header to override, and `txIndex` - call is
+// executed on state before transaction `txIndex` of block (requires history v3).
+func (api *APIImpl) Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *ethapi2.StateOverrides, blockOverrides *BlockOverrides) (hexutility.Bytes, error) {
 	tx, err := api.db.BeginRo(ctx)
 	if err != nil {
 		return nil, err
@@ -68,11 +70,21 @@ func (api *APIImpl) Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHa
 		return nil, nil
 	}
 
-	stateReader, err := rpchelper.CreateStateReader(ctx, tx, blockNrOrHash, 0, api.filters, api.stateCache, api.historyV3(tx), chainConfig.ChainName)
+	var stateReader state.StateReader
+	if blockOverrides == nil || blockOverrides.TxIndex == nil {
+		stateReader, err = rpchelper.CreateStateReader(ctx, tx, blockNrOrHash, 0, api.filters, api.stateCache, api.historyV3(tx), chainConfig.ChainName)
+	} else {
+		stateReader, err = rpchelper.CreateTxIndexStateReader(tx, blockNumber, uint64(*blockOverrides.TxIndex), api.historyV3(tx), chainConfig.ChainName)
+	}
 	if err != nil {
 		return nil, err
 	}
 	header := block.HeaderNoCopy()
+	if blockOverrides != nil {
+		if header, err = blockOverrides.overrideHeader(header); err != nil {
+			return nil, err
+		}
+	}
 	result, err := transactions.DoCall(ctx, engine, args, tx, blockNrOrHash, header, overrides, api.GasCap, chainConfig, stateReader, api._blockReader, api.evmCallTimeout)
 	if err != nil {
 		return nil, err
@@ -90,6 +102,34 @@ func (api *APIImpl) Call(ctx context.Context, args ethapi2.CallArgs, blockNrOrHa
 	return result.Return(), result.Err
 }
 
+// overrideHeader - copy of `header` with overridden fields. BlockHash override is not supported by eth_call: DoCall
+// resolves BLOCKHASH from db.
+func (o *BlockOverrides) overrideHeader(header *types.Header) (*types.Header, error) {
+	if o.BlockHash != nil {
+		return nil, fmt.Errorf("blockHash override is not supported by eth_call")
+	}
+	header = types.CopyHeader(header)
+	if o.BlockNumber != nil {
+		header.Number = new(big.Int).SetUint64(uint64(*o.BlockNumber))
+	}
+	if o.Coinbase != nil {
+		header.Coinbase = *o.Coinbase
+	}
+	if o.Timestamp != nil {
+		header.Time = uint64(*o.Timestamp)
+	}
+	if o.GasLimit != nil {
+		header.GasLimit = uint64(*o.GasLimit)
+	}
+	if o.Difficulty != nil {
+		header.Difficulty = new(big.Int).SetUint64(uint64(*o.Difficulty))
+	}
+	if o.BaseFee != nil {
+		header.BaseFee = o.BaseFee.ToBig()
+	}
+	return header, nil
+}
+
 // headerByNumberOrHash - intent to read recent headers only, tries from the lru cache before reading from the db
 func headerByNumberOrHash(ctx context.Context, tx kv.Tx, blockNrOrHash rpc.BlockNumberOrHash, api *APIImpl) (*types.Header, error) {
 	_, bNrOrHashHash, _, err := rpchelper.GetCanonicalBlockNumber(blockNrOrHash, tx, api.filters)
@@ -325,9 +365,6 @@ func (api *APIImpl) GetProof(ctx context.Context, address libcommon.Address, sto
 		return nil, err
 	}
 	defer tx.Rollback()
-	if api.historyV3(tx) {
-		return nil, fmt.Errorf("not supported by Erigon3")
-	}
 
 	blockNr, _, _, err := rpchelper.GetBlockNumber(blockNrOrHash, tx, api.filters)
 	if err != nil {
@@ -355,7 +392,13 @@ func (api *APIImpl) GetProof(ctx context.Context, address libcommon.Address, sto
 		if latestBlock-blockNr > uint64(api.MaxGetProofRewindBlockCount) {
 			return nil, fmt.Errorf("requested block is too old, block must be within %d blocks of the head block number (currently %d)", uint64(api.MaxGetProofRewindBlockCount), latestBlock)
 		}
-		batch := membatchwithdb.NewMemoryBatch(tx, api.dirs.Tmp, api.logger)
+		var batch kv.RwTx
+		if ttx, ok := tx.(kv.TemporalTx); ok && api.historyV3(tx) {
+			// hashed state of old block is restored from history files - it's available for whole chain on archive node
+			batch = membatchwithdb.NewTemporalMemoryBatch(ttx, api.dirs.Tmp, api.logger)
+		} else {
+			batch = membatchwithdb.NewMemoryBatch(tx, api.dirs.Tmp, api.logger)
+		}
 		defer batch.Rollback()
 
 		unwindState := &stagedsync.UnwindState{UnwindPoint: blockNr}
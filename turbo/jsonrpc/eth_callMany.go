@@ -32,6 +32,9 @@ type BlockOverrides struct {
 	Difficulty  *hexutil.Uint
 	BaseFee     *uint256.Int
 	BlockHash   *map[uint64]common.Hash
+	// TxIndex - eth_call only: call is executed on state before transaction `TxIndex` of block (requires history v3).
+	// Bundles of eth_callMany use StateContext.TransactionIndex instead.
+	TxIndex *hexutil.Uint64
 }
 
 type Bundle struct {
@@ -98,6 +101,9 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 		if len(bundle.Transactions) != 0 {
 			empty = false
 		}
+		if bundle.BlockOverride.TxIndex != nil {
+			return nil, fmt.Errorf("txIndex of block override is not supported by eth_callMany, use transactionIndex of state context")
+		}
 	}
 
 	if empty {
@@ -136,7 +142,9 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 		return nil, err
 	}
 
-	st := state.New(stateReader)
+	// all changes (replayed txs, overrides, bundles) stay in memory: db is never touched
+	overlay := state.NewOverlayState(stateReader)
+	st := state.New(overlay)
 
 	header := block.Header()
 
@@ -212,12 +220,14 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 			return nil, fmt.Errorf("execution aborted (timeout = %v)", timeout)
 		}
 	}
+	if err = st.CommitBlock(rules, overlay); err != nil {
+		return nil, err
+	}
 
 	// after replaying the txns, we want to overload the state
 	// overload state
 	if stateOverride != nil {
-		err = stateOverride.Override((evm.IntraBlockState()).(*state.IntraBlockState))
-		if err != nil {
+		if err = stateOverride.OverrideOverlay(overlay); err != nil {
 			return nil, err
 		}
 	}
@@ -226,29 +236,9 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 
 	for _, bundle := range bundles {
 		// first change blockContext
-		if bundle.BlockOverride.BlockNumber != nil {
-			blockCtx.BlockNumber = uint64(*bundle.BlockOverride.BlockNumber)
-		}
-		if bundle.BlockOverride.BaseFee != nil {
-			blockCtx.BaseFee = bundle.BlockOverride.BaseFee
-		}
-		if bundle.BlockOverride.Coinbase != nil {
-			blockCtx.Coinbase = *bundle.BlockOverride.Coinbase
-		}
-		if bundle.BlockOverride.Difficulty != nil {
-			blockCtx.Difficulty = big.NewInt(int64(*bundle.BlockOverride.Difficulty))
-		}
-		if bundle.BlockOverride.Timestamp != nil {
-			blockCtx.Time = uint64(*bundle.BlockOverride.Timestamp)
-		}
-		if bundle.BlockOverride.GasLimit != nil {
-			blockCtx.GasLimit = uint64(*bundle.BlockOverride.GasLimit)
-		}
-		if bundle.BlockOverride.BlockHash != nil {
-			for blockNum, hash := range *bundle.BlockOverride.BlockHash {
-				overrideBlockHash[blockNum] = hash
-			}
-		}
+		blockHeaderOverride(&blockCtx, bundle.BlockOverride, overrideBlockHash)
+		// each bundle is executed atomically on top of previous ones: committed to overlay only after all its txs
+		st = state.New(overlay)
 		results := []map[string]interface{}{}
 		for _, txn := range bundle.Transactions {
 			if txn.Gas == nil || *(txn.Gas) == 0 {
@@ -259,7 +249,7 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 				return nil, err
 			}
 			txCtx = core.NewEVMTxContext(msg)
-			evm = vm.NewEVM(blockCtx, txCtx, evm.IntraBlockState(), chainConfig, vm.Config{Debug: false})
+			evm = vm.NewEVM(blockCtx, txCtx, st, chainConfig, vm.Config{Debug: false})
 			result, err := core.ApplyMessage(evm, msg, gp, true, false)
 			if err != nil {
 				return nil, err
@@ -284,6 +274,9 @@ func (api *APIImpl) CallMany(ctx context.Context, bundles []Bundle, simulateCont
 
 			results = append(results, jsonResult)
 		}
+		if err = st.CommitBlock(rules, overlay); err != nil {
+			return nil, err
+		}
 
 		blockCtx.BlockNumber++
 		blockCtx.Time++
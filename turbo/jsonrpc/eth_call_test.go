@@ -64,7 +64,7 @@ func TestEthCallNonCanonical(t *testing.T) {
 	if _, err := api.Call(context.Background(), ethapi.CallArgs{
 		From: &from,
 		To:   &to,
-	}, rpc.BlockNumberOrHashWithHash(libcommon.HexToHash("0x3fcb7c0d4569fddc89cbea54b42f163e0c789351d98810a513895ab44b47020b"), true), nil); err != nil {
+	}, rpc.BlockNumberOrHashWithHash(libcommon.HexToHash("0x3fcb7c0d4569fddc89cbea54b42f163e0c789351d98810a513895ab44b47020b"), true), nil, nil); err != nil {
 		if fmt.Sprintf("%v", err) != "hash 3fcb7c0d4569fddc89cbea54b42f163e0c789351d98810a513895ab44b47020b is not currently canonical" {
 			t.Errorf("wrong error: %v", err)
 		}
@@ -86,18 +86,49 @@ func TestEthCallToPrunedBlock(t *testing.T) {
 		From: &bankAddress,
 		To:   &contractAddress,
 		Data: &callDataBytes,
-	}, rpc.BlockNumberOrHashWithNumber(ethCallBlockNumber), nil); err != nil {
+	}, rpc.BlockNumberOrHashWithNumber(ethCallBlockNumber), nil, nil); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
 
+func TestEthCallAtTxIndex(t *testing.T) {
+	m, bankAddress, contractAddress := chainWithDeployedContract(t)
+	api := NewEthAPI(newBaseApiForTest(m), m.DB, nil, nil, nil, 5000000, 100_000, false, 100_000, log.New())
+
+	callData := hexutil.MustDecode("0x2e64cec1")
+	callDataBytes := hexutility.Bytes(callData)
+	args := ethapi.CallArgs{From: &bankAddress, To: &contractAddress, Data: &callDataBytes}
+	callAt := func(blockNum uint64, txIndex hexutil.Uint64) (hexutility.Bytes, error) {
+		return api.Call(context.Background(), args, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNum)), nil, &BlockOverrides{TxIndex: &txIndex})
+	}
+
+	// block 3 has single tx: store(2), on top of store(1) of block 2
+	res, err := callAt(3, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint256.NewInt(1).PaddedBytes(32), []byte(res))
+
+	res, err = callAt(3, 1)
+	if !m.HistoryV3 {
+		require.Error(t, err, "state inside of block requires history v3")
+	} else {
+		require.NoError(t, err)
+		require.Equal(t, uint256.NewInt(2).PaddedBytes(32), []byte(res))
+	}
+
+	_, err = callAt(3, 1000)
+	require.Error(t, err)
+
+	// txIndex is not allowed in bundles of eth_callMany
+	txIndex := hexutil.Uint64(0)
+	_, err = api.CallMany(context.Background(), []Bundle{{Transactions: []ethapi.CallArgs{args}, BlockOverride: BlockOverrides{TxIndex: &txIndex}}},
+		StateContext{BlockNumber: rpc.BlockNumberOrHashWithNumber(3)}, nil, nil)
+	require.Error(t, err)
+}
+
 func TestGetProof(t *testing.T) {
 	var maxGetProofRewindBlockCount = 1 // Note, this is unsafe for parallel tests, but, this test is the only consumer for now
 
 	m, bankAddr, contractAddr := chainWithDeployedContract(t)
-	if m.HistoryV3 {
-		t.Skip("not supported by Erigon3")
-	}
 	api := NewEthAPI(newBaseApiForTest(m), m.DB, nil, nil, nil, 5000000, 100_000, false, maxGetProofRewindBlockCount, log.New())
 
 	key := func(b byte) libcommon.Hash {
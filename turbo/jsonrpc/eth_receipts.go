@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 
 	"github.com/RoaringBitmap/roaring"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/chain"
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
@@ -79,6 +81,12 @@ func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (t
 	var begin, end uint64
 	logs := types.Logs{}
 
+	if api.GetLogsTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.GetLogsTimeout)
+		defer cancel()
+	}
+
 	tx, beginErr := api.db.BeginRo(ctx)
 	if beginErr != nil {
 		return logs, beginErr
@@ -151,22 +159,40 @@ func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (t
 	if api.historyV3(tx) {
 		return api.getLogsV3(ctx, tx.(kv.TemporalTx), begin, end, crit)
 	}
-	blockNumbers := bitmapdb.NewBitmap()
-	defer bitmapdb.ReturnToPool(blockNumbers)
-	if err := applyFilters(blockNumbers, tx, begin, end, crit); err != nil {
-		return logs, err
-	}
-	if blockNumbers.IsEmpty() {
-		return logs, nil
-	}
 	addrMap := make(map[common.Address]struct{}, len(crit.Addresses))
 	for _, v := range crit.Addresses {
 		addrMap[v] = struct{}{}
 	}
+	blockNumbers := bitmapdb.NewBitmap()
+	defer bitmapdb.ReturnToPool(blockNumbers)
+	var blocks uint64
+	var err error
+	// posting lists of addresses and topics are read and intersected by steps of range: block budget is checked
+	// before whole range is read, and RAM doesn't grow with range
+	for from := begin; from <= end; from += getLogsStepBlocks {
+		blockNumbers.Clear()
+		if err := applyFilters(blockNumbers, tx, from, cmp.Min(from+getLogsStepBlocks-1, end), crit); err != nil {
+			return logs, err
+		}
+		blocks += blockNumbers.GetCardinality()
+		if err := api.checkGetLogsBlocks(blocks); err != nil {
+			return nil, err
+		}
+		if logs, err = api.appendLogsOfBlocks(ctx, tx, logs, blockNumbers, addrMap, crit); err != nil {
+			return nil, err
+		}
+	}
+	return logs, nil
+}
+
+// getLogsStepBlocks - eth_getLogs without history v3 filters range by this amount of blocks at a time. Var for tests
+var getLogsStepBlocks uint64 = 100_000
+
+func (api *APIImpl) appendLogsOfBlocks(ctx context.Context, tx kv.Tx, logs types.Logs, blockNumbers *roaring.Bitmap, addrMap map[common.Address]struct{}, crit filters.FilterCriteria) (types.Logs, error) {
 	iter := blockNumbers.Iterator()
 	for iter.HasNext() {
 		if err := ctx.Err(); err != nil {
-			return nil, err
+			return nil, api.getLogsCtxErr(err)
 		}
 
 		blockNumber := uint64(iter.Next())
@@ -232,11 +258,38 @@ func (api *APIImpl) GetLogs(ctx context.Context, crit filters.FilterCriteria) (t
 			}
 		}
 		logs = append(logs, blockLogs...)
+		if err := api.checkGetLogsResults(len(logs)); err != nil {
+			return nil, err
+		}
 	}
 
 	return logs, nil
 }
 
+// checkGetLogsBlocks - limit amount of blocks which eth_getLogs will read. It's not limit of requested range: blocks are
+// counted after index filtering - wide ranges with selective filters are cheap, but filter without addresses/topics must
+// read every block of range.
+func (api *APIImpl) checkGetLogsBlocks(blocks uint64) error {
+	if api.GetLogsMaxBlocks > 0 && blocks > api.GetLogsMaxBlocks {
+		return fmt.Errorf("eth_getLogs: query matches more than %d blocks, narrow block range or add addresses/topics", api.GetLogsMaxBlocks)
+	}
+	return nil
+}
+
+func (api *APIImpl) checkGetLogsResults(results int) error {
+	if api.GetLogsMaxResults > 0 && results > api.GetLogsMaxResults {
+		return fmt.Errorf("eth_getLogs: query returned more than %d results, narrow block range", api.GetLogsMaxResults)
+	}
+	return nil
+}
+
+func (api *APIImpl) getLogsCtxErr(err error) error {
+	if api.GetLogsTimeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("eth_getLogs: query timeout %s exceeded, narrow block range", api.GetLogsTimeout)
+	}
+	return err
+}
+
 // The Topic list restricts matches to particular event topics. Each event has a list
 // of topics. Topics matches a prefix of that list. An empty element slice matches any
 // topic. Non-empty elements represent an alternative that matches any of the
@@ -305,6 +358,9 @@ func applyFilters(out *roaring.Bitmap, tx kv.Tx, begin, end uint64, crit filters
 	if topicsBitmap != nil {
 		out.And(topicsBitmap)
 	}
+	if out.IsEmpty() { // no need to read posting lists of addresses
+		return nil
+	}
 	addrBitmap, err := getAddrsBitmap(tx, crit.Addresses, begin, end)
 	if err != nil {
 		return err
@@ -412,11 +468,12 @@ func (api *APIImpl) getLogsV3(ctx context.Context, tx kv.TemporalTx, begin, end
 
 	var blockHash common.Hash
 	var header *types.Header
+	var blocks uint64
 
 	iter := MapTxNum2BlockNum(tx, txNumbers)
 	for iter.HasNext() {
 		if err = ctx.Err(); err != nil {
-			return nil, err
+			return nil, api.getLogsCtxErr(err)
 		}
 		txNum, blockNum, txIndex, isFinalTxn, blockNumChanged, err := iter.Next()
 		if err != nil {
@@ -428,6 +485,11 @@ func (api *APIImpl) getLogsV3(ctx context.Context, tx kv.TemporalTx, begin, end
 
 		// if block number changed, calculate all related field
 		if blockNumChanged {
+			// index iteration is lazy: budget is checked while walking, not after materializing whole range
+			blocks++
+			if err = api.checkGetLogsBlocks(blocks); err != nil {
+				return nil, err
+			}
 			if header, err = api._blockReader.HeaderByNumber(ctx, tx, blockNum); err != nil {
 				return nil, err
 			}
@@ -465,6 +527,9 @@ func (api *APIImpl) getLogsV3(ctx context.Context, tx kv.TemporalTx, begin, end
 			log.TxHash = txn.Hash()
 		}
 		logs = append(logs, filtered...)
+		if err = api.checkGetLogsResults(len(logs)); err != nil {
+			return nil, err
+		}
 	}
 
 	//stats := api._agg.GetAndResetStats()
@@ -0,0 +1,97 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+// accountHistoryProbeStep - amount of account changes between history probes
+const accountHistoryProbeStep = 4096
+
+// findAccountChangeV3 - finds txNum of the account change after which `reached` holds for the account.
+//
+// AccountsHistoryIdx has txNums of all changes of account, and HistoryGet(txNum) returns account value
+// before txNum. `reached` must be monotonic over changes (nonce and incarnation only grow): then the wanted
+// txNum is the last change whose previous value doesn't satisfy `reached`. Nil account means "not exists".
+//
+// Index is traversed forward on purpose: popular contracts may have dozens of state changes
+// after creation. History is probed once per chunk of changes, then the found chunk is binary-searched.
+func findAccountChangeV3(tx kv.TemporalTx, addr common.Address, reached func(acc *accounts.Account) bool) (txNum uint64, found bool, err error) {
+	accountCodec, err := state.AccountCodec(tx)
+	if err != nil {
+		return 0, false, err
+	}
+	reachedBefore := func(txNum uint64) (bool, error) {
+		v, ok, err := tx.HistoryGet(kv.AccountsHistory, addr[:], txNum)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, fmt.Errorf("couldn't find history txnID=%v addr=%v", txNum, addr)
+		}
+		if len(v) == 0 {
+			return reached(nil), nil
+		}
+		var acc accounts.Account
+		if err := accounts.Deserialise(accountCodec, &acc, v); err != nil {
+			return false, err
+		}
+		return reached(&acc), nil
+	}
+
+	it, err := tx.IndexRange(kv.AccountsHistoryIdx, addr[:], 0, -1, order.Asc, kv.Unlim)
+	if err != nil {
+		return 0, false, err
+	}
+	chunk := make([]uint64, 0, accountHistoryProbeStep)
+	for {
+		chunk = chunk[:0]
+		for len(chunk) < cap(chunk) && it.HasNext() {
+			n, err := it.Next()
+			if err != nil {
+				return 0, false, err
+			}
+			chunk = append(chunk, n)
+		}
+		if len(chunk) == 0 {
+			return txNum, found, nil
+		}
+		if it.HasNext() {
+			ok, err := reachedBefore(chunk[len(chunk)-1])
+			if err != nil {
+				return 0, false, err
+			}
+			if !ok {
+				txNum, found = chunk[len(chunk)-1], true
+				continue
+			}
+		}
+
+		var searchErr error
+		i := sort.Search(len(chunk), func(i int) bool {
+			if searchErr != nil {
+				return true
+			}
+			ok, err := reachedBefore(chunk[i])
+			if err != nil {
+				searchErr = err
+				return true
+			}
+			return ok
+		})
+		if searchErr != nil {
+			return 0, false, searchErr
+		}
+		if i > 0 {
+			return chunk[i-1], true, nil
+		}
+		return txNum, found, nil
+	}
+}
@@ -264,38 +264,94 @@ func (api *OtterscanAPIImpl) searchTransactionsBeforeV3(tx kv.TemporalTx, ctx co
 	}
 
 	isFirstPage := false
+	fromTxNum := -1 // blockNum == 0 means no max
 	if fromBlockNum == 0 {
 		isFirstPage = true
 	} else {
 		// Internal search code considers blockNum [including], so adjust the value
 		fromBlockNum--
+		maxTxNum, err := rawdbv3.TxNums.Max(tx, fromBlockNum)
+		if err != nil {
+			return nil, err
+		}
+		fromTxNum = int(maxTxNum)
 	}
-	fromTxNum, err := rawdbv3.TxNums.Max(tx, fromBlockNum)
+	txNums, err := addrTxNumsV3(tx, addr, fromTxNum, order.Desc)
 	if err != nil {
 		return nil, err
 	}
-	itTo, err := tx.IndexRange(kv.TracesToIdx, addr[:], int(fromTxNum), -1, order.Desc, kv.Unlim)
+	txNumsIter := MapDescendTxNum2BlockNum(tx, txNums)
+
+	txs, receipts, hasMore, err := api.execTxsV3(ctx, tx, chainConfig, txNumsIter, pageSize)
 	if err != nil {
 		return nil, err
 	}
-	itFrom, err := tx.IndexRange(kv.TracesFromIdx, addr[:], int(fromTxNum), -1, order.Desc, kv.Unlim)
+	return &TransactionsWithReceipts{txs, receipts, isFirstPage, !hasMore}, nil
+}
+
+func (api *OtterscanAPIImpl) searchTransactionsAfterV3(tx kv.TemporalTx, ctx context.Context, addr common.Address, fromBlockNum uint64, pageSize uint16) (*TransactionsWithReceipts, error) {
+	chainConfig, err := api.chainConfig(tx)
 	if err != nil {
 		return nil, err
 	}
-	txNums := iter.Union[uint64](itFrom, itTo, order.Desc, kv.Unlim)
-	txNumsIter := MapDescendTxNum2BlockNum(tx, txNums)
 
+	isLastPage := false
+	if fromBlockNum == 0 {
+		isLastPage = true
+	} else {
+		// Internal search code considers blockNum [including], so adjust the value
+		fromBlockNum++
+	}
+	fromTxNum, err := rawdbv3.TxNums.Min(tx, fromBlockNum)
+	if err != nil {
+		return nil, err
+	}
+	txNums, err := addrTxNumsV3(tx, addr, int(fromTxNum), order.Asc)
+	if err != nil {
+		return nil, err
+	}
+	txNumsIter := MapTxNum2BlockNum(tx, txNums)
+
+	txs, receipts, hasMore, err := api.execTxsV3(ctx, tx, chainConfig, txNumsIter, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	// Reverse results: page is sorted descending
+	lentxs := len(txs)
+	for i := 0; i < lentxs/2; i++ {
+		txs[i], txs[lentxs-1-i] = txs[lentxs-1-i], txs[i]
+		receipts[i], receipts[lentxs-1-i] = receipts[lentxs-1-i], receipts[i]
+	}
+	return &TransactionsWithReceipts{txs, receipts, !hasMore, isLastPage}, nil
+}
+
+// addrTxNumsV3 - txNums where `addr` was traced as sender or receiver, starting from `fromTxNum` [including].
+// fromTxNum == -1 means: from the edge of history in given order
+func addrTxNumsV3(tx kv.TemporalTx, addr common.Address, fromTxNum int, asc order.By) (iter.U64, error) {
+	itTo, err := tx.IndexRange(kv.TracesToIdx, addr[:], fromTxNum, -1, asc, kv.Unlim)
+	if err != nil {
+		return nil, err
+	}
+	itFrom, err := tx.IndexRange(kv.TracesFromIdx, addr[:], fromTxNum, -1, asc, kv.Unlim)
+	if err != nil {
+		return nil, err
+	}
+	return iter.Union[uint64](itFrom, itTo, asc, kv.Unlim), nil
+}
+
+// execTxsV3 - re-execute up to `pageSize` txs found by indices, on top of history state
+func (api *OtterscanAPIImpl) execTxsV3(ctx context.Context, tx kv.TemporalTx, chainConfig *chain.Config, txNumsIter *MapTxNum2BlockNumIter, pageSize uint16) (txs []*RPCTransaction, receipts []map[string]interface{}, hasMore bool, err error) {
 	exec := txnExecutor(tx, chainConfig, api.engine(), api._blockReader, nil)
 	var blockHash common.Hash
 	var header *types.Header
-	txs := make([]*RPCTransaction, 0, pageSize)
-	receipts := make([]map[string]interface{}, 0, pageSize)
+	txs = make([]*RPCTransaction, 0, pageSize)
+	receipts = make([]map[string]interface{}, 0, pageSize)
 	resultCount := uint16(0)
 
 	for txNumsIter.HasNext() {
 		txNum, blockNum, txIndex, isFinalTxn, blockNumChanged, err := txNumsIter.Next()
 		if err != nil {
-			return nil, err
+			return nil, nil, false, err
 		}
 		if isFinalTxn {
 			continue
@@ -303,7 +359,7 @@ func (api *OtterscanAPIImpl) searchTransactionsBeforeV3(tx kv.TemporalTx, ctx co
 
 		if blockNumChanged { // things which not changed within 1 block
 			if header, err = api._blockReader.HeaderByNumber(ctx, tx, blockNum); err != nil {
-				return nil, err
+				return nil, nil, false, err
 			}
 			if header == nil {
 				log.Warn("[rpc] header is nil", "blockNum", blockNum)
@@ -316,14 +372,14 @@ func (api *OtterscanAPIImpl) searchTransactionsBeforeV3(tx kv.TemporalTx, ctx co
 		//fmt.Printf("txNum=%d, blockNum=%d, txIndex=%d, maxTxNumInBlock=%d,mixTxNumInBlock=%d\n", txNum, blockNum, txIndex, maxTxNumInBlock, minTxNumInBlock)
 		txn, err := api._txnReader.TxnByIdxInBlock(ctx, tx, blockNum, txIndex)
 		if err != nil {
-			return nil, err
+			return nil, nil, false, err
 		}
 		if txn == nil {
 			continue
 		}
 		rawLogs, res, err := exec.execTx(txNum, txIndex, txn)
 		if err != nil {
-			return nil, err
+			return nil, nil, false, err
 		}
 		rpcTx := NewRPCTransaction(txn, blockHash, blockNum, uint64(txIndex), header.BaseFee)
 		txs = append(txs, rpcTx)
@@ -341,8 +397,7 @@ func (api *OtterscanAPIImpl) searchTransactionsBeforeV3(tx kv.TemporalTx, ctx co
 			break
 		}
 	}
-	hasMore := txNumsIter.HasNext()
-	return &TransactionsWithReceipts{txs, receipts, isFirstPage, !hasMore}, nil
+	return txs, receipts, txNumsIter.HasNext(), nil
 }
 
 // Search transactions that touch a certain address.
@@ -364,6 +419,10 @@ func (api *OtterscanAPIImpl) SearchTransactionsAfter(ctx context.Context, addr c
 	}
 	defer dbtx.Rollback()
 
+	if api.historyV3(dbtx) {
+		return api.searchTransactionsAfterV3(dbtx.(kv.TemporalTx), ctx, addr, blockNum, pageSize)
+	}
+
 	callFromCursor, err := dbtx.Cursor(kv.CallFromIndex)
 	if err != nil {
 		return nil, err
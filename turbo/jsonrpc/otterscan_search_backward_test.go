@@ -169,6 +169,15 @@ func TestSearchTransactionsBefore(t *testing.T) {
 		require.Equal(3, len(results.Txs))
 		require.Equal(3, len(results.Receipts))
 	})
+	t.Run("first page", func(t *testing.T) {
+		require := require.New(t)
+		results, err := api.SearchTransactionsBefore(m.Ctx, addr, 0, 25)
+		require.NoError(err)
+		require.True(results.FirstPage)
+		require.True(results.LastPage)
+		require.GreaterOrEqual(len(results.Txs), 3)
+		require.Equal(3, int(results.Txs[len(results.Txs)-1].BlockNumber.ToInt().Uint64()))
+	})
 	t.Run("filter last block", func(t *testing.T) {
 		require := require.New(t)
 		results, err := api.SearchTransactionsBefore(m.Ctx, addr, 5, 10)
@@ -5,6 +5,10 @@ import (
 	"testing"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/rpcdaemontest"
 )
 
 func newMockForwardChunkLocator(chunks [][]byte) ChunkLocator {
@@ -141,3 +145,32 @@ func TestForwardBlockProviderWithMultipleChunksBlockNotFound(t *testing.T) {
 
 	checkNext(t, blockProvider, 0, false)
 }
+
+func TestSearchTransactionsAfter(t *testing.T) {
+	m, _, _ := rpcdaemontest.CreateTestSentry(t)
+	api := NewOtterscanAPI(newBaseApiForTest(m), m.DB, 25)
+
+	addr := libcommon.HexToAddress("0x537e697c7ab75a26f9ecf0ce810e3154dfcaaf44")
+	t.Run("small page size", func(t *testing.T) {
+		require := require.New(t)
+		results, err := api.SearchTransactionsAfter(m.Ctx, addr, 2, 2)
+		require.NoError(err)
+		require.False(results.FirstPage)
+		require.False(results.LastPage)
+		require.Equal(2, len(results.Txs))
+		require.Equal(2, len(results.Receipts))
+
+		// page is sorted descending
+		require.Equal(4, int(results.Txs[0].BlockNumber.ToInt().Uint64()))
+		require.Equal(3, int(results.Txs[1].BlockNumber.ToInt().Uint64()))
+	})
+	t.Run("big page size", func(t *testing.T) {
+		require := require.New(t)
+		results, err := api.SearchTransactionsAfter(m.Ctx, addr, 2, 25)
+		require.NoError(err)
+		require.True(results.FirstPage)
+		require.False(results.LastPage)
+		require.GreaterOrEqual(len(results.Txs), 3)
+		require.Equal(3, int(results.Txs[len(results.Txs)-1].BlockNumber.ToInt().Uint64()))
+	})
+}
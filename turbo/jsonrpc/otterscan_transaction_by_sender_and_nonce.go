@@ -8,14 +8,13 @@ import (
 
 	"github.com/RoaringBitmap/roaring/roaring64"
 	"github.com/ledgerwatch/erigon-lib/common"
-	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/kv"
-	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 	"github.com/ledgerwatch/erigon-lib/kv/temporal/historyv2"
 	"github.com/ledgerwatch/log/v3"
 
 	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/turbo/rpchelper"
 )
 
 func (api *OtterscanAPIImpl) GetTransactionBySenderAndNonce(ctx context.Context, addr common.Address, nonce uint64) (*common.Hash, error) {
@@ -27,114 +26,40 @@ func (api *OtterscanAPIImpl) GetTransactionBySenderAndNonce(ctx context.Context,
 
 	var acc accounts.Account
 	if api.historyV3(tx) {
-		ttx := tx.(kv.TemporalTx)
-		it, err := ttx.IndexRange(kv.AccountsHistoryIdx, addr[:], -1, -1, order.Asc, kv.Unlim)
+		latestAcc, err := rpchelper.NewLatestStateReader(tx).ReadAccountData(addr)
 		if err != nil {
 			return nil, err
 		}
-
-		var prevTxnID, nextTxnID uint64
-		for i := 0; it.HasNext(); i++ {
-			txnID, err := it.Next()
-			if err != nil {
-				return nil, err
-			}
-
-			if i%4096 != 0 { // probe history periodically, not on every change
-				nextTxnID = txnID
-				continue
-			}
-
-			v, ok, err := ttx.HistoryGet(kv.AccountsHistory, addr[:], txnID)
-			if err != nil {
-				log.Error("Unexpected error, couldn't find changeset", "txNum", i, "addr", addr)
-				return nil, err
-			}
-			if !ok {
-				err = fmt.Errorf("couldn't find history txnID=%v addr=%v", txnID, addr)
-				log.Error("[rpc] Unexpected error", "err", err)
-				return nil, err
-			}
-
-			if len(v) == 0 { // creation, but maybe not our Incarnation
-				prevTxnID = txnID
-				continue
-			}
-
-			if err := accounts.DeserialiseV3(&acc, v); err != nil {
-				return nil, err
-			}
-			// Desired nonce was found in this chunk
-			if acc.Nonce > nonce {
-				break
-			}
-			prevTxnID = txnID
-		}
-
-		// The sort.Search function finds the first block where the incarnation has
-		// changed to the desired one, so we get the previous block from the bitmap;
-		// however if the creationTxnID block is already the first one from the bitmap, it means
-		// the block we want is the max block from the previous shard.
-		var creationTxnID uint64
-		var searchErr error
-
-		if nextTxnID == 0 {
-			nextTxnID = prevTxnID + 1
+		if latestAcc == nil || latestAcc.Nonce <= nonce { // nonce is not used yet
+			return nil, nil
 		}
-		// Binary search in [prevTxnID, nextTxnID] range; get first block where desired incarnation appears
-		// can be replaced by full-scan over ttx.HistoryRange([prevTxnID, nextTxnID])?
-		idx := sort.Search(int(nextTxnID-prevTxnID), func(i int) bool {
-			txnID := uint64(i) + prevTxnID
-			v, ok, err := ttx.HistoryGet(kv.AccountsHistory, addr[:], txnID)
-			if err != nil {
-				log.Error("[rpc] Unexpected error, couldn't find changeset", "txNum", i, "addr", addr)
-				panic(err)
-			}
-			if !ok {
-				return false
-			}
-			if len(v) == 0 {
-				creationTxnID = cmp.Max(creationTxnID, txnID)
-				return false
-			}
-
-			if err := accounts.DeserialiseV3(&acc, v); err != nil {
-				searchErr = err
-				return false
-			}
-
-			// Since the state contains the nonce BEFORE the block changes, we look for
-			// the block when the nonce changed to be > the desired once, which means the
-			// previous history block contains the actual change; it may contain multiple
-			// nonce changes.
-			if acc.Nonce <= nonce {
-				creationTxnID = cmp.Max(creationTxnID, txnID)
-				return false
-			}
-			return true
+		// Since the history contains the nonce BEFORE the change, we look for the last
+		// change whose previous nonce is <= the desired one: it's the tx with desired nonce.
+		txnID, ok, err := findAccountChangeV3(tx.(kv.TemporalTx), addr, func(acc *accounts.Account) bool {
+			return acc != nil && acc.Nonce > nonce
 		})
-		if searchErr != nil {
-			return nil, searchErr
+		if err != nil {
+			return nil, err
 		}
-		if creationTxnID == 0 {
-			return nil, fmt.Errorf("binary search between %d-%d doesn't find anything", nextTxnID, prevTxnID)
+		if !ok {
+			return nil, nil
 		}
-		ok, bn, err := rawdbv3.TxNums.FindBlockNum(tx, creationTxnID)
+		ok, bn, err := rawdbv3.TxNums.FindBlockNum(tx, txnID)
 		if err != nil {
 			return nil, err
 		}
 		if !ok {
-			return nil, fmt.Errorf("block not found by txnID=%d", creationTxnID)
+			return nil, fmt.Errorf("block not found by txnID=%d", txnID)
 		}
 		minTxNum, err := rawdbv3.TxNums.Min(tx, bn)
 		if err != nil {
 			return nil, err
 		}
-		txIndex := int(creationTxnID) - int(minTxNum) - 1 /* system-tx */
-		if txIndex == -1 {
-			txIndex = (idx + int(prevTxnID)) - int(minTxNum) - 1
+		txIndex := int(txnID) - int(minTxNum) - 1 /* system-tx */
+		if txIndex < 0 {
+			return nil, nil
 		}
-		txn, err := api._txnReader.TxnByIdxInBlock(ctx, ttx, bn, txIndex)
+		txn, err := api._txnReader.TxnByIdxInBlock(ctx, tx, bn, txIndex)
 		if err != nil {
 			return nil, err
 		}
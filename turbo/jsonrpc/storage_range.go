@@ -46,17 +46,21 @@ func storageRangeAt(stateReader walker, contractAddress libcommon.Address, start
 	return result, nil
 }
 
+// storageRangeAtV3 - storage of contract as of txNum: keys changed after txNum come from history, others from latest
+// state. Entries which didn't exist as of txNum are skipped and don't count against `maxResult`; `NextKey` is first
+// existing key after returned ones.
 func storageRangeAtV3(ttx kv.TemporalTx, contractAddress libcommon.Address, start []byte, txNum uint64, maxResult int) (StorageRangeResult, error) {
 	result := StorageRangeResult{Storage: storageMap{}}
 
 	fromKey := append(libcommon.Copy(contractAddress.Bytes()), start...)
 	toKey, _ := kv.NextSubtree(contractAddress.Bytes())
 
-	r, err := ttx.DomainRange(kv.StorageDomain, fromKey, toKey, txNum, order.Asc, maxResult+1)
+	// no limit: deleted entries are filtered here
+	r, err := ttx.DomainRange(kv.StorageDomain, fromKey, toKey, txNum, order.Asc, -1)
 	if err != nil {
 		return StorageRangeResult{}, err
 	}
-	for i := 0; i < maxResult && r.HasNext(); i++ {
+	for resultCount := 0; r.HasNext(); {
 		k, v, err := r.Next()
 		if err != nil {
 			return StorageRangeResult{}, err
@@ -65,6 +69,10 @@ func storageRangeAtV3(ttx kv.TemporalTx, contractAddress libcommon.Address, star
 			continue // Skip deleted entries
 		}
 		key := libcommon.BytesToHash(k[20:])
+		if resultCount == maxResult {
+			result.NextKey = &key
+			break
+		}
 		seckey, err := libcommon.HashData(k[20:])
 		if err != nil {
 			return StorageRangeResult{}, err
@@ -72,17 +80,7 @@ func storageRangeAtV3(ttx kv.TemporalTx, contractAddress libcommon.Address, star
 		var value uint256.Int
 		value.SetBytes(v)
 		result.Storage[seckey] = StorageEntry{Key: &key, Value: value.Bytes32()}
-	}
-
-	if r.HasNext() {
-		k, v, err := r.Next()
-		if err != nil {
-			return StorageRangeResult{}, err
-		}
-		if len(v) > 0 {
-			key := libcommon.BytesToHash(k[20:])
-			result.NextKey = &key
-		}
+		resultCount++
 	}
 	return result, nil
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/hexutil"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
 	types2 "github.com/ledgerwatch/erigon-lib/types"
 	math2 "github.com/ledgerwatch/erigon/common/math"
 	"github.com/ledgerwatch/erigon/core"
@@ -746,6 +747,9 @@ func (api *TraceAPIImpl) ReplayTransaction(ctx context.Context, txHash libcommon
 
 		isBorStateSyncTxn = true
 	}
+	if err = api.BaseAPI.checkPruneHistory(tx, blockNum); err != nil {
+		return nil, err
+	}
 
 	block, err := api.blockByNumberWithSenders(tx, blockNum)
 	if err != nil {
@@ -829,6 +833,9 @@ func (api *TraceAPIImpl) ReplayBlockTransactions(ctx context.Context, blockNrOrH
 	if err != nil {
 		return nil, err
 	}
+	if err = api.BaseAPI.checkPruneHistory(tx, blockNumber); err != nil {
+		return nil, err
+	}
 
 	// Extract transactions from block
 	block, bErr := api.blockWithSenders(tx, blockHash, blockNumber)
@@ -1117,9 +1124,27 @@ func (api *TraceAPIImpl) doCallMany(ctx context.Context, dbtx kv.Tx, msgs []type
 	if err != nil {
 		return nil, nil, err
 	}
-	stateReader, err := rpchelper.CreateStateReader(ctx, dbtx, *parentNrOrHash, 0, api.filters, api.stateCache, api.historyV3(dbtx), chainConfig.ChainName)
-	if err != nil {
-		return nil, nil, err
+	// With history v3 state is available as of any txNum: each transaction of block is executed on state right before
+	// it - read from History/Domain as of its txNum - instead of state accumulated by re-executing previous
+	// transactions of block. trace_replayBlockTransactions/trace_replayTransaction don't need trace tables then, and
+	// trace of single transaction doesn't execute previous ones.
+	var historyReader *state.HistoryReaderV3
+	var minTxNum uint64
+	if header != nil && api.historyV3(dbtx) {
+		if minTxNum, err = rawdbv3.TxNums.Min(dbtx, header.Number.Uint64()); err != nil {
+			return nil, nil, err
+		}
+		historyReader = state.NewHistoryReaderV3()
+		historyReader.SetTx(dbtx)
+	}
+	var stateReader state.StateReader
+	if historyReader != nil {
+		stateReader = historyReader
+	} else {
+		stateReader, err = rpchelper.CreateStateReader(ctx, dbtx, *parentNrOrHash, 0, api.filters, api.stateCache, api.historyV3(dbtx), chainConfig.ChainName)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 	stateCache := shards.NewStateCache(32, 0 /* no limit */) // this cache living only during current RPC call, but required to store state writes
 	cachedReader := state.NewCachedReader(stateReader, stateCache)
@@ -1164,6 +1189,19 @@ func (api *TraceAPIImpl) doCallMany(ctx context.Context, dbtx kv.Tx, msgs []type
 		if err := libcommon.Stopped(ctx.Done()); err != nil {
 			return nil, nil, err
 		}
+		if historyReader != nil {
+			if txIndexNeeded != -1 && txIndex < txIndexNeeded {
+				// not executed: results are positional
+				results = append(results, &TraceCallResult{Trace: []*ParityTrace{}, TransactionHash: callParams[txIndex].txHash})
+				continue
+			}
+			// first txNum of block is system tx, bor state sync tx is last
+			historyReader.SetTxNum(minTxNum + 1 + uint64(txIndex))
+			stateCache = shards.NewStateCache(32, 0 /* no limit */)
+			cachedReader = state.NewCachedReader(stateReader, stateCache)
+			cachedWriter = state.NewCachedWriter(noop, stateCache)
+			ibs = state.New(cachedReader)
+		}
 
 		var traceTypeTrace, traceTypeStateDiff, traceTypeVmTrace bool
 		args := callParams[txIndex]
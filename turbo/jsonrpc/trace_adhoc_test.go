@@ -12,6 +12,7 @@ import (
 
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/cli/httpcfg"
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/rpcdaemontest"
+	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/rpc"
 )
 
@@ -100,3 +101,56 @@ func TestReplayBlockTransactions(t *testing.T) {
 	v := addrDiff.Balance.(map[string]*hexutil.Big)["+"].ToInt().Uint64()
 	require.Equal(t, uint64(1_000_000_000_000_000), v)
 }
+
+func TestReplayTransactionMatchesBlockReplay(t *testing.T) {
+	m, _, _ := rpcdaemontest.CreateTestSentry(t)
+	api := NewTraceAPI(newBaseApiForTest(m), m.DB, &httpcfg.HttpCfg{})
+	traceTypes := []string{"trace", "stateDiff", "vmTrace"}
+
+	n := rpc.BlockNumber(6)
+	blockResults, err := api.ReplayBlockTransactions(m.Ctx, rpc.BlockNumberOrHash{BlockNumber: &n}, traceTypes, new(bool))
+	require.NoError(t, err)
+	for i, expect := range blockResults {
+		result, err := api.ReplayTransaction(m.Ctx, *expect.TransactionHash, traceTypes, new(bool))
+		require.NoError(t, err)
+		expectJson, err := json.Marshal(expect)
+		require.NoError(t, err)
+		resultJson, err := json.Marshal(result)
+		require.NoError(t, err)
+		require.JSONEq(t, string(expectJson), string(resultJson), "tx %d", i)
+	}
+}
+
+// Each transaction of block must see state right after previous one: with history v3 it's read as of txNum of
+// transaction (run with e3 build tag), otherwise accumulated by re-execution of previous transactions
+func TestReplayBlockTransactionsNonces(t *testing.T) {
+	m, _, _ := rpcdaemontest.CreateTestSentry(t)
+	api := NewTraceAPI(newBaseApiForTest(m), m.DB, &httpcfg.HttpCfg{})
+	signer := types.LatestSigner(m.ChainConfig)
+
+	for _, blockNum := range []uint64{1, 6, 7} { // 6 and 7 have many txs of same sender
+		var txs types.Transactions
+		err := m.DB.View(m.Ctx, func(tx kv.Tx) error {
+			b, err := m.BlockReader.BlockByNumber(m.Ctx, tx, blockNum)
+			if err != nil {
+				return err
+			}
+			txs = b.Transactions()
+			return nil
+		})
+		require.NoError(t, err)
+
+		n := rpc.BlockNumber(blockNum)
+		results, err := api.ReplayBlockTransactions(m.Ctx, rpc.BlockNumberOrHash{BlockNumber: &n}, []string{"stateDiff"}, new(bool))
+		require.NoError(t, err)
+		require.Equal(t, len(txs), len(results))
+		for i, txn := range txs {
+			sender, err := signer.Sender(txn)
+			require.NoError(t, err)
+			nonceDiff, ok := results[i].StateDiff[sender].Nonce.(map[string]*StateDiffNonce)
+			require.True(t, ok, "block %d tx %d", blockNum, i)
+			require.Equal(t, txn.GetNonce(), uint64(nonceDiff["*"].From), "block %d tx %d", blockNum, i)
+			require.Equal(t, txn.GetNonce()+1, uint64(nonceDiff["*"].To), "block %d tx %d", blockNum, i)
+		}
+	}
+}
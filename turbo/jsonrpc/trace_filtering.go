@@ -286,51 +286,61 @@ func traceFilterBitmaps(tx kv.Tx, req TraceFilterRequest, from, to uint64) (from
 	return fromAddresses, toAddresses, allBlocks, nil
 }
 
-func traceFilterBitmapsV3(tx kv.TemporalTx, req TraceFilterRequest, from, to uint64) (fromAddresses, toAddresses map[common.Address]struct{}, allBlocks iter.U64, err error) {
+// traceFilterBitmapsV3 resolves candidate txNums in [from, to) through TracesFrom/TracesTo indices:
+// only these txs must be replayed. If no addresses specified - all txs of range are candidates.
+func traceFilterBitmapsV3(tx kv.TemporalTx, req TraceFilterRequest, from, to uint64) (fromAddresses, toAddresses map[common.Address]struct{}, allTxs iter.U64, err error) {
 	fromAddresses = make(map[common.Address]struct{}, len(req.FromAddress))
 	toAddresses = make(map[common.Address]struct{}, len(req.ToAddress))
-	var blocksTo iter.U64
-
 	for _, addr := range req.FromAddress {
 		if addr != nil {
-			it, err := tx.IndexRange(kv.TracesFromIdx, addr.Bytes(), int(from), int(to), order.Asc, kv.Unlim)
-			if errors.Is(err, ethdb.ErrKeyNotFound) {
-				continue
-			}
-			allBlocks = iter.Union[uint64](allBlocks, it, order.Asc, -1)
 			fromAddresses[*addr] = struct{}{}
 		}
 	}
-
 	for _, addr := range req.ToAddress {
 		if addr != nil {
-			it, err := tx.IndexRange(kv.TracesToIdx, addr.Bytes(), int(from), int(to), order.Asc, kv.Unlim)
-			if errors.Is(err, ethdb.ErrKeyNotFound) {
-				continue
-			}
-			blocksTo = iter.Union[uint64](blocksTo, it, order.Asc, -1)
 			toAddresses[*addr] = struct{}{}
 		}
 	}
 
+	// Special case - if no addresses specified, take all traces
+	if len(fromAddresses) == 0 && len(toAddresses) == 0 {
+		return fromAddresses, toAddresses, iter.Range[uint64](from, to), nil
+	}
+
+	txsFrom, err := traceFilterIndexV3(tx, kv.TracesFromIdx, fromAddresses, from, to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	txsTo, err := traceFilterIndexV3(tx, kv.TracesToIdx, toAddresses, from, to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	switch req.Mode {
 	case TraceFilterModeIntersection:
-		allBlocks = iter.Intersect[uint64](allBlocks, blocksTo, -1)
+		allTxs = iter.Intersect[uint64](txsFrom, txsTo, -1)
 	case TraceFilterModeUnion:
 		fallthrough
 	default:
-		allBlocks = iter.Union[uint64](allBlocks, blocksTo, order.Asc, -1)
+		allTxs = iter.Union[uint64](txsFrom, txsTo, order.Asc, -1)
 	}
+	return fromAddresses, toAddresses, allTxs, nil
+}
 
-	// Special case - if no addresses specified, take all traces
-	if len(req.FromAddress) == 0 && len(req.ToAddress) == 0 {
-		allBlocks = iter.Range[uint64](from, to)
-		//} else {
-		//allBlocks.RemoveRange(0, from)
-		//allBlocks.RemoveRange(to, uint64(0x100000000))
+// traceFilterIndexV3 - union of txNums in [from, to) of given addresses in given inverted index
+func traceFilterIndexV3(tx kv.TemporalTx, idx kv.InvertedIdx, addrs map[common.Address]struct{}, from, to uint64) (iter.U64, error) {
+	var txs iter.U64 = iter.EmptyU64
+	for addr := range addrs {
+		it, err := tx.IndexRange(idx, addr.Bytes(), int(from), int(to), order.Asc, kv.Unlim)
+		if errors.Is(err, ethdb.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		txs = iter.Union[uint64](txs, it, order.Asc, -1)
 	}
-
-	return fromAddresses, toAddresses, allBlocks, nil
+	return txs, nil
 }
 
 // Filter implements trace_filter
@@ -529,6 +539,18 @@ func (api *TraceAPIImpl) Filter(ctx context.Context, req TraceFilterRequest, gas
 func (api *TraceAPIImpl) filterV3(ctx context.Context, dbtx kv.TemporalTx, fromBlock, toBlock uint64, req TraceFilterRequest, stream *jsoniter.Stream) error {
 	var fromTxNum, toTxNum uint64
 	var err error
+	// clamp range by executed blocks: indices have no data after it
+	lastBlock, _, err := rawdbv3.TxNums.Last(dbtx)
+	if err != nil {
+		return err
+	}
+	if toBlock > lastBlock {
+		toBlock = lastBlock
+	}
+	if fromBlock > toBlock {
+		stream.WriteEmptyArray()
+		return stream.Flush()
+	}
 	if fromBlock > 0 {
 		fromTxNum, err = rawdbv3.TxNums.Min(dbtx, fromBlock)
 		if err != nil {
@@ -580,17 +602,12 @@ func (api *TraceAPIImpl) filterV3(ctx context.Context, dbtx kv.TemporalTx, fromB
 	noop := state.NewNoopWriter()
 	isPos := false
 	for it.HasNext() {
+		if nExported >= count { // candidates are replayed lazily: no reason to execute txs which will not be returned
+			break
+		}
 		txNum, blockNum, txIndex, isFnalTxn, blockNumChanged, err := it.Next()
-		if err != nil {
-			if first {
-				first = false
-			} else {
-				stream.WriteMore()
-			}
-			stream.WriteObjectStart()
-			rpc.HandleError(err, stream)
-			stream.WriteObjectEnd()
-			continue
+		if err != nil { // candidates come from indices: can't skip broken part of them
+			return err
 		}
 
 		if blockNumChanged {
@@ -145,6 +145,33 @@ func CreateHistoryStateReader(tx kv.Tx, blockNumber uint64, txnIndex int, histor
 	return r, nil
 }
 
+// CreateTxIndexStateReader - state before transaction `txnIndex` of block `blockNumber` (txnIndex == number of
+// transactions of block - state after all of them). Inside of block state is available only with HistoryV3.
+func CreateTxIndexStateReader(tx kv.Tx, blockNumber uint64, txnIndex uint64, historyV3 bool, chainName string) (state.StateReader, error) {
+	if !historyV3 {
+		if txnIndex > 0 {
+			return nil, fmt.Errorf("state at transaction index %d of block %d requires history v3", txnIndex, blockNumber)
+		}
+		return CreateHistoryStateReader(tx, blockNumber, 0, historyV3, chainName)
+	}
+	minTxNum, err := rawdbv3.TxNums.Min(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	maxTxNum, err := rawdbv3.TxNums.Max(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if maxTxNum <= minTxNum {
+		return nil, fmt.Errorf("block %d not found in txNums index", blockNumber)
+	}
+	// first and last txNums of block are system txs
+	if txsAmount := maxTxNum - minTxNum - 1; txnIndex > txsAmount {
+		return nil, fmt.Errorf("transaction index %d out of range: block %d has %d transactions", txnIndex, blockNumber, txsAmount)
+	}
+	return CreateHistoryStateReader(tx, blockNumber, int(txnIndex), historyV3, chainName)
+}
+
 func NewLatestStateReader(tx kv.Getter) state.StateReader {
 	if ethconfig.EnableHistoryV4InTest {
 		panic("implement me")
@@ -104,6 +104,10 @@ type FullBlockReader interface {
 	FrozenBorBlocks() uint64
 	FrozenFiles() (list []string)
 	FreezingCfg() ethconfig.BlocksFreezing
+	// FrozenRawBodies - bodies of blocks [fromBlock, fromBlock+count) which are in files, without DB reads
+	FrozenRawBodies(ctx context.Context, fromBlock, count uint64) ([]*types.RawBody, error)
+	// EarliestAvailableBlock - blocks before it are deleted by history expiry (EIP-4444)
+	EarliestAvailableBlock() uint64
 	CanPruneTo(currentBlockInDB uint64) (canPruneBlocksTo uint64)
 
 	Snapshots() BlockSnapshots
@@ -111,6 +115,7 @@ type FullBlockReader interface {
 }
 
 type BlockSnapshots interface {
+	Dir() string
 	LogStat(label string)
 	ReopenFolder() error
 	SegmentsMax() uint64
@@ -123,6 +128,7 @@ type BlockRetire interface {
 	PruneAncientBlocks(tx kv.RwTx, limit int) error
 	RetireBlocksInBackground(ctx context.Context, miBlockNum uint64, maxBlockNum uint64, lvl log.Lvl, seedNewSnapshots func(downloadRequest []DownloadRequest) error, onDelete func(l []string) error)
 	HasNewFrozenFiles() bool
+	ExpireBlocks(before uint64, onDelete func(l []string) error) error
 	BuildMissedIndicesIfNeed(ctx context.Context, logPrefix string, notifier DBEventNotifier, cc *chain.Config) error
 	SetWorkers(workers int)
 }
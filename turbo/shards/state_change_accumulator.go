@@ -2,10 +2,12 @@ package shards
 
 import (
 	"context"
+	"sync"
 
 	libcommon "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv"
 )
 
 // Accumulator collects state changes in a form that can then be delivered to the RPC daemon
@@ -15,6 +17,14 @@ type Accumulator struct {
 	latestChange       *remote.StateChange
 	accountChangeIndex map[libcommon.Address]int // For the latest changes, allows finding account change by account's address
 	storageChangeIndex map[libcommon.Address]map[libcommon.Hash]int
+
+	pendingStateLock sync.RWMutex
+	pendingState     PendingStateReader // not-yet-committed writes of block in progress, see SetPendingState
+}
+
+// PendingStateReader - read access to writes which are not committed to DB yet (for example state.StateV3)
+type PendingStateReader interface {
+	Get(table string, key []byte) (v []byte, ok bool)
 }
 
 func NewAccumulator() *Accumulator {
@@ -42,6 +52,31 @@ func (a *Accumulator) SendAndReset(ctx context.Context, c StateChangeConsumer, p
 	a.Reset(0) // reset here for GC, but there will be another Reset with correct viewID
 }
 
+// SetPendingState - exposes writes of block in progress to other goroutines (txpool). Pass nil to detach.
+// Unlike other methods of Accumulator it's thread-safe.
+func (a *Accumulator) SetPendingState(r PendingStateReader) {
+	if a == nil {
+		return
+	}
+	a.pendingStateLock.Lock()
+	defer a.pendingStateLock.Unlock()
+	a.pendingState = r
+}
+
+// PendingAccount - returns account (in storage encoding) from block in progress, ok=false if it was not touched yet
+func (a *Accumulator) PendingAccount(addr libcommon.Address) (enc []byte, ok bool) {
+	if a == nil {
+		return nil, false
+	}
+	a.pendingStateLock.RLock()
+	r := a.pendingState
+	a.pendingStateLock.RUnlock()
+	if r == nil {
+		return nil, false
+	}
+	return r.Get(kv.PlainState, addr[:])
+}
+
 func (a *Accumulator) SetStateID(stateID uint64) {
 	a.plainStateID = stateID
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/ledgerwatch/log/v3"
 
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
@@ -106,6 +107,14 @@ func (r *RemoteBlockReader) FrozenBorBlocks() uint64               { panic("not
 func (r *RemoteBlockReader) FrozenFiles() (list []string)          { panic("not supported") }
 func (r *RemoteBlockReader) FreezingCfg() ethconfig.BlocksFreezing { panic("not supported") }
 
+// FrozenRawBodies - remote reader has no access to files: none of blocks are served from them, caller reads from DB
+func (r *RemoteBlockReader) FrozenRawBodies(ctx context.Context, fromBlock, count uint64) ([]*types.RawBody, error) {
+	return nil, nil
+}
+
+// EarliestAvailableBlock - unknown here: remote side answers "not found" for expired blocks
+func (r *RemoteBlockReader) EarliestAvailableBlock() uint64 { return 0 }
+
 func (r *RemoteBlockReader) HeaderByHash(ctx context.Context, tx kv.Getter, hash common.Hash) (*types.Header, error) {
 	blockNum := rawdb.ReadHeaderNumber(tx, hash)
 	if blockNum == nil {
@@ -320,6 +329,12 @@ func (r *BlockReader) BorSnapshots() services.BlockSnapshots {
 }
 
 func (r *BlockReader) FrozenBlocks() uint64 { return r.sn.BlocksAvailable() }
+func (r *BlockReader) EarliestAvailableBlock() uint64 {
+	if r.sn == nil {
+		return 0
+	}
+	return r.sn.BlocksAvailableFrom()
+}
 func (r *BlockReader) FrozenBorBlocks() uint64 {
 	if r.borSn != nil {
 		return r.borSn.BlocksAvailable()
@@ -526,6 +541,60 @@ func (r *BlockReader) Body(ctx context.Context, tx kv.Getter, hash common.Hash,
 	return body, txAmount, nil
 }
 
+// FrozenRawBodies - bodies of blocks [fromBlock, fromBlock+count) from snapshot files only (no DB reads), transactions
+// in binary encoding. Stops at first block which is not in files: result may be shorter than `count`.
+func (r *BlockReader) FrozenRawBodies(ctx context.Context, fromBlock, count uint64) ([]*types.RawBody, error) {
+	maxBlockNumInFiles := r.sn.BlocksAvailable()
+	if maxBlockNumInFiles == 0 || fromBlock > maxBlockNumInFiles {
+		return nil, nil
+	}
+	toBlock := cmp.Min(fromBlock+count, maxBlockNumInFiles+1)
+
+	view := r.sn.View()
+	defer view.Close()
+
+	bodies := make([]*types.RawBody, 0, toBlock-fromBlock)
+	var buf []byte
+	for blockNum := fromBlock; blockNum < toBlock; blockNum++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		seg, ok := view.BodiesSegment(blockNum)
+		if !ok {
+			break
+		}
+		var b *types.BodyForStorage
+		var err error
+		b, buf, err = r.bodyForStorageFromSnapshot(blockNum, seg, buf)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		txnSeg, ok := view.TxsSegment(blockNum)
+		if !ok {
+			break
+		}
+		var txsAmount uint32
+		if b.TxAmount >= 2 {
+			txsAmount = b.TxAmount - 2
+		}
+		var txs [][]byte
+		txs, buf, err = r.rawTxsFromSnapshot(b.BaseTxId+1, txsAmount, txnSeg, buf) // empty txs in the beginning and end of block
+		if err != nil {
+			return nil, err
+		}
+		if txs == nil {
+			break
+		}
+		bodies = append(bodies, &types.RawBody{Transactions: txs, Uncles: b.Uncles, Withdrawals: b.Withdrawals})
+	}
+	return bodies, nil
+}
+
 func (r *BlockReader) HasSenders(ctx context.Context, tx kv.Getter, hash common.Hash, blockHeight uint64) (bool, error) {
 	maxBlockNumInFiles := r.sn.BlocksAvailable()
 	if maxBlockNumInFiles == 0 || blockHeight > maxBlockNumInFiles {
@@ -782,6 +851,48 @@ func (r *BlockReader) txsFromSnapshot(baseTxnID uint64, txsAmount uint32, txsSeg
 	return txs, senders, nil
 }
 
+// rawTxsFromSnapshot - like txsFromSnapshot, but without decoding: transactions in binary encoding (as in block body of
+// Engine API). Typed transactions are stored RLP-wrapped - unwrapped here.
+func (r *BlockReader) rawTxsFromSnapshot(baseTxnID uint64, txsAmount uint32, txsSeg *Segment, buf []byte) (txs [][]byte, _ []byte, err error) {
+	idxTxnHash := txsSeg.Index(snaptype.Indexes.TxnHash)
+	if idxTxnHash == nil {
+		return nil, buf, nil
+	}
+	if baseTxnID < idxTxnHash.BaseDataID() {
+		return nil, buf, fmt.Errorf(".idx file has wrong baseDataID? %d<%d, %s", baseTxnID, idxTxnHash.BaseDataID(), txsSeg.FilePath())
+	}
+
+	txs = make([][]byte, txsAmount)
+	if txsAmount == 0 {
+		return txs, buf, nil
+	}
+	txnOffset := idxTxnHash.OrdinalLookup(baseTxnID - idxTxnHash.BaseDataID())
+	gg := txsSeg.MakeGetter()
+	gg.Reset(txnOffset)
+	for i := uint32(0); i < txsAmount; i++ {
+		if !gg.HasNext() {
+			return nil, buf, nil
+		}
+		buf, _ = gg.Next(buf[:0])
+		if len(buf) < 1+20+1 {
+			return nil, buf, fmt.Errorf("segment %s has too short record: len(buf)=%d < 22", txsSeg.FilePath(), len(buf))
+		}
+		txRlp := buf[1+20:]
+		if txRlp[0] >= 0x80 && txRlp[0] < 0xc0 { // typed tx as RLP string
+			kind, content, _, err := rlp.Split(txRlp)
+			if err != nil {
+				return nil, buf, err
+			}
+			if kind != rlp.String {
+				return nil, buf, fmt.Errorf("segment %s: unexpected tx encoding", txsSeg.FilePath())
+			}
+			txRlp = content
+		}
+		txs[i] = common.Copy(txRlp)
+	}
+	return txs, buf, nil
+}
+
 func (r *BlockReader) txnByID(txnID uint64, sn *Segment, buf []byte) (txn types.Transaction, err error) {
 	idxTxnHash := sn.Index(snaptype.Indexes.TxnHash)
 
@@ -595,6 +595,12 @@ func (s *RoSnapshots) ReopenFolder() error {
 }
 
 func (s *RoSnapshots) ReopenSegments(types []snaptype.Type) error {
+	if s.cfg.ExpireBefore > 0 && s.segmentsMin.Load() == 0 {
+		// files of expired blocks may be deleted already (see RemoveSegmentsBefore): segments start from first remaining file
+		if from, ok := firstSegmentFrom(s.dir, types); ok {
+			s.segmentsMin.Store(from)
+		}
+	}
 	files, _, err := typedSegments(s.dir, s.segmentsMin.Load(), types)
 
 	if err != nil {
@@ -608,6 +614,83 @@ func (s *RoSnapshots) ReopenSegments(types []snaptype.Type) error {
 	return s.ReopenList(list, false)
 }
 
+// RemoveSegmentsBefore - history expiry (EIP-4444): deletes segments of all types which have only blocks < `before`,
+// remaining segments start from new SegmentsMin. Nothing is deleted if types of segments end at different blocks.
+func (s *RoSnapshots) RemoveSegmentsBefore(before uint64, onDelete func(l []string) error) (removed []string, err error) {
+	files, _, err := typedSegments(s.dir, s.segmentsMin.Load(), s.types)
+	if err != nil {
+		return nil, err
+	}
+	expiredTo := map[snaptype.Enum]uint64{}
+	var toReopen []string
+	for _, f := range files {
+		if f.To > before {
+			toReopen = append(toReopen, filepath.Base(f.Path))
+			continue
+		}
+		removed = append(removed, f.Path)
+		expiredTo[f.Type.Enum()] = cmp.Max(expiredTo[f.Type.Enum()], f.To)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	// verify: otherwise some blocks would have headers, but no bodies
+	var newMin uint64
+	for i, t := range s.types {
+		to, ok := expiredTo[t.Enum()]
+		if !ok || (i > 0 && to != newMin) {
+			return nil, fmt.Errorf("RemoveSegmentsBefore(%d): segments of type %s are not aligned with other types", before, t)
+		}
+		newMin = to
+	}
+
+	s.SetSegmentsMin(newMin)
+	if err := s.ReopenList(toReopen, true); err != nil {
+		return nil, err
+	}
+	if onDelete != nil {
+		if err := onDelete(removed); err != nil {
+			return nil, err
+		}
+	}
+	removeOldFiles(removed, s.dir)
+	s.logger.Info("[snapshots] history expiry: deleted block files", "before", newMin, "files", len(removed))
+	return removed, nil
+}
+
+// BlocksAvailableFrom - first block in segments. Segments of older blocks may be deleted by history expiry (EIP-4444).
+func (s *RoSnapshots) BlocksAvailableFrom() uint64 {
+	if s == nil {
+		return 0
+	}
+	view := s.View()
+	defer view.Close()
+	headers := view.Headers()
+	if len(headers) == 0 {
+		return 0
+	}
+	return headers[0].from
+}
+
+// firstSegmentFrom - smallest first block of segments of given types in dir
+func firstSegmentFrom(dir string, types []snaptype.Type) (from uint64, ok bool) {
+	list, err := snaptype.Segments(dir)
+	if err != nil {
+		return 0, false
+	}
+	for _, f := range list {
+		for _, t := range types {
+			if f.Type.Enum() != t.Enum() {
+				continue
+			}
+			if !ok || f.From < from {
+				from, ok = f.From, true
+			}
+		}
+	}
+	return from, ok
+}
+
 func (s *RoSnapshots) ReopenWithDB(db kv.RoDB) error {
 	if err := db.View(context.Background(), func(tx kv.Tx) error {
 		snList, _, err := rawdb.ReadSnapshots(tx)
@@ -1323,6 +1406,30 @@ func (br *BlockRetire) RetireBlocksInBackground(ctx context.Context, minBlockNum
 	}()
 }
 
+// ExpireBlocks - history expiry (EIP-4444): deletes block files of blocks < before. Does nothing while files are retired or merged:
+// will be done on next call.
+func (br *BlockRetire) ExpireBlocks(before uint64, onDeleteSnapshots func(l []string) error) error {
+	if before == 0 {
+		return nil
+	}
+	if !br.working.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer br.working.Store(false)
+
+	removed, err := br.snapshots().RemoveSegmentsBefore(before, onDeleteSnapshots)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		br.needSaveFilesListInDB.Store(true)
+		if br.notifier != nil {
+			br.notifier.OnNewSnapshot()
+		}
+	}
+	return nil
+}
+
 func (br *BlockRetire) RetireBlocks(ctx context.Context, minBlockNum uint64, maxBlockNum uint64, lvl log.Lvl, seedNewSnapshots func(downloadRequest []services.DownloadRequest) error, onDeleteSnapshots func(l []string) error) (err error) {
 	includeBor := br.chainConfig.Bor != nil
 	minBlockNum = cmp.Max(br.blockReader.FrozenBlocks(), minBlockNum)
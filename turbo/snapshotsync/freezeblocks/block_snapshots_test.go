@@ -360,6 +360,50 @@ func TestOpenAllSnapshot(t *testing.T) {
 	}
 }
 
+func TestRemoveSegmentsBefore(t *testing.T) {
+	logger := log.New()
+	dir, require := t.TempDir(), require.New(t)
+	for _, r := range []Range{{0, 500_000}, {500_000, 1_000_000}} {
+		for _, snT := range snaptype.BlockSnapshotTypes {
+			createTestSegmentFile(t, r.from, r.to, snT.Enum(), dir, 1, logger)
+		}
+	}
+	cfg := ethconfig.BlocksFreezing{Enabled: true, ExpireBefore: 600_000}
+	s := NewRoSnapshots(cfg, dir, 0, logger)
+	defer s.Close()
+	require.NoError(s.ReopenFolder())
+	require.Equal(uint64(0), s.BlocksAvailableFrom())
+
+	var deleted []string
+	removed, err := s.RemoveSegmentsBefore(cfg.ExpireBefore, func(l []string) error {
+		deleted = append(deleted, l...)
+		return nil
+	})
+	require.NoError(err)
+	require.Equal(3, len(removed))
+	require.Equal(removed, deleted)
+	require.NoFileExists(filepath.Join(dir, snaptype.SegmentFileName(1, 0, 500_000, snaptype.Enums.Headers)))
+	require.FileExists(filepath.Join(dir, snaptype.SegmentFileName(1, 500_000, 1_000_000, snaptype.Enums.Headers)))
+	require.Equal(uint64(500_000), s.BlocksAvailableFrom())
+	require.Equal(uint64(500_000), s.SegmentsMin())
+
+	// nothing left to expire
+	removed, err = s.RemoveSegmentsBefore(cfg.ExpireBefore, nil)
+	require.NoError(err)
+	require.Empty(removed)
+
+	// after restart: remaining segments are open
+	s.Close()
+	s = NewRoSnapshots(cfg, dir, 0, logger)
+	defer s.Close()
+	require.NoError(s.ReopenFolder())
+	require.Equal(uint64(500_000), s.BlocksAvailableFrom())
+	view := s.View()
+	defer view.Close()
+	_, ok := view.HeadersSegment(700_000)
+	require.True(ok)
+}
+
 func TestParseCompressedFileName(t *testing.T) {
 	require := require.New(t)
 	fs := fstest.MapFS{
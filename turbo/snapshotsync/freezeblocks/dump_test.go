@@ -21,6 +21,7 @@ import (
 	"github.com/ledgerwatch/erigon/core"
 	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/crypto"
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
 	"github.com/ledgerwatch/erigon/ethdb/prune"
 	"github.com/ledgerwatch/erigon/params"
 	"github.com/ledgerwatch/erigon/rlp"
@@ -284,3 +285,77 @@ func createDumpTestKV(t *testing.T, chainConfig *chain.Config, chainSize int) *m
 
 	return m
 }
+
+func TestFrozenRawBodies(t *testing.T) {
+	require := require.New(t)
+	logger := log.New()
+	chainSize := 1000 // one segment of blocks [0, 1000)
+
+	// dynamic-fee txs need London
+	chainConfig := *params.TestChainConfig
+	chainConfig.LondonBlock = big.NewInt(0)
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &types.Genesis{
+			Config: &chainConfig,
+			Alloc:  types.GenesisAlloc{addr: {Balance: (&big.Int{}).Mul(big.NewInt(math.MaxInt64), big.NewInt(int64(chainSize)))}},
+		}
+		signer  = types.LatestSigner(gspec.Config)
+		chainID = uint256.MustFromBig(gspec.Config.ChainID)
+	)
+	m := mock.MockWithGenesisPruneMode(t, gspec, key, chainSize+5, prune.DefaultMode, false)
+	// legacy and typed txs: typed ones are stored RLP-wrapped in files
+	chain, err := core.GenerateChain(m.ChainConfig, m.Genesis, m.Engine, m.DB, chainSize+5, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(libcommon.Address{1})
+		legacyTx, err := types.SignTx(types.NewTransaction(b.TxNonce(addr), libcommon.HexToAddress("deadbeef"), uint256.NewInt(100), 21000, uint256.NewInt(100*params.GWei), nil), *signer, key)
+		require.NoError(err)
+		b.AddTx(legacyTx)
+		typedTx, err := types.SignTx(types.NewEIP1559Transaction(*chainID, b.TxNonce(addr), libcommon.HexToAddress("deadbeef"), uint256.NewInt(100), 21000, nil, uint256.NewInt(params.GWei), uint256.NewInt(100*params.GWei), nil), *signer, key)
+		require.NoError(err)
+		b.AddTx(typedTx)
+	})
+	require.NoError(err)
+	require.NoError(m.InsertChain(chain))
+
+	snapDir := t.TempDir()
+	require.NoError(freezeblocks.DumpBlocks(m.Ctx, 0, uint64(chainSize), m.ChainConfig, t.TempDir(), snapDir, m.DB, 1, log.LvlInfo, logger, m.BlockReader))
+	snapshots := freezeblocks.NewRoSnapshots(ethconfig.BlocksFreezing{Enabled: true}, snapDir, 0, logger)
+	defer snapshots.Close()
+	require.NoError(snapshots.ReopenFolder())
+	reader := freezeblocks.NewBlockReader(snapshots, nil)
+
+	// stops at first block which is not in files
+	bodies, err := reader.FrozenRawBodies(m.Ctx, 0, uint64(chainSize+5))
+	require.NoError(err)
+	require.Len(bodies, chainSize)
+
+	tx, err := m.DB.BeginRo(m.Ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	for i, body := range bodies {
+		block, err := m.BlockReader.BlockByNumber(m.Ctx, tx, uint64(i))
+		require.NoError(err)
+		expect, err := types.MarshalTransactionsBinary(block.Transactions())
+		require.NoError(err)
+		require.Equal(len(expect), len(body.Transactions), "block %d", i)
+		for j := range expect {
+			require.Equal(expect[j], body.Transactions[j], "block %d tx %d", i, j)
+		}
+		if i > 0 {
+			require.Equal(byte(types.DynamicFeeTxType), body.Transactions[1][0], "block %d: typed tx is not RLP-wrapped", i)
+		}
+	}
+
+	bodies, err = reader.FrozenRawBodies(m.Ctx, uint64(chainSize-3), 10)
+	require.NoError(err)
+	require.Len(bodies, 3)
+	bodies, err = reader.FrozenRawBodies(m.Ctx, uint64(chainSize+1), 10)
+	require.NoError(err)
+	require.Empty(bodies)
+
+	remote := freezeblocks.NewRemoteBlockReader(nil)
+	bodies, err = remote.FrozenRawBodies(m.Ctx, 0, 10)
+	require.NoError(err)
+	require.Empty(bodies)
+}
@@ -0,0 +1,167 @@
+package snapshotsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/ledgerwatch/erigon-lib/downloader"
+	proto_downloader "github.com/ledgerwatch/erigon-lib/gointerfaces/downloader"
+
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
+	"github.com/ledgerwatch/erigon/turbo/services"
+)
+
+func TestNewProvider(t *testing.T) {
+	p, err := NewProvider(ethconfig.BlocksFreezing{}, t.TempDir(), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &torrentProvider{}, p)
+	p, err = NewProvider(ethconfig.BlocksFreezing{Provider: ProviderHttp, ProviderURL: "http://mirror"}, t.TempDir(), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &fileProvider{}, p)
+	p, err = NewProvider(ethconfig.BlocksFreezing{Provider: ProviderRsync, ProviderURL: "rsync://mirror"}, t.TempDir(), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &fileProvider{}, p)
+
+	_, err = NewProvider(ethconfig.BlocksFreezing{Provider: ProviderHttp}, t.TempDir(), nil, nil)
+	require.Error(t, err)
+	_, err = NewProvider(ethconfig.BlocksFreezing{Provider: "ftp", ProviderURL: "ftp://mirror"}, t.TempDir(), nil, nil)
+	require.Error(t, err)
+}
+
+func TestHttpProvider(t *testing.T) {
+	require := require.New(t)
+	ctx, mirrorDir, snapDir := context.Background(), t.TempDir(), t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(mirrorDir, "history"), 0755))
+	files := map[string][]byte{
+		"v1-000000-000500-headers.seg":  {1, 2, 3},
+		"history/v1-accounts.0-32.v":    {4, 5, 6},
+		"history/v1-accounts.32-48.v":   {7, 8, 9},
+		"history/v1-accounts.48-64.v":   {10},
+		"history/v1-accounts.64-80.v":   {11},
+		"history/v1-accounts.80-96.v":   {12},
+		"history/v1-accounts.96-112.v":  {13},
+		"history/v1-accounts.112-128.v": {14},
+	}
+	var requests []services.DownloadRequest
+	for name, data := range files {
+		fPath := filepath.Join(mirrorDir, name)
+		require.NoError(os.WriteFile(fPath, data, 0644))
+		hash, err := downloader.InfoHashOfFile(fPath, name)
+		require.NoError(err)
+		requests = append(requests, services.NewDownloadRequest(name, hash.HexString()))
+	}
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		http.FileServer(http.Dir(mirrorDir)).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// existing file is not fetched again
+	require.NoError(os.WriteFile(filepath.Join(snapDir, "v1-000000-000500-headers.seg"), files["v1-000000-000500-headers.seg"], 0644))
+
+	p, err := NewProvider(ethconfig.BlocksFreezing{Provider: ProviderHttp, ProviderURL: srv.URL + "/"}, snapDir, nil, nil)
+	require.NoError(err)
+	require.NoError(p.Fetch(ctx, "test", requests))
+	require.EqualValues(len(files)-1, served.Load())
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(snapDir, name))
+		require.NoError(err)
+		require.Equal(data, got)
+	}
+	requireNoTmpFiles(t, snapDir)
+}
+
+func TestHttpProviderFailure(t *testing.T) {
+	require := require.New(t)
+	ctx, mirrorDir, snapDir := context.Background(), t.TempDir(), t.TempDir()
+	name := "v1-000000-000500-bodies.seg"
+	require.NoError(os.WriteFile(filepath.Join(mirrorDir, name), []byte{1, 2, 3}, 0644))
+	srv := httptest.NewServer(http.FileServer(http.Dir(mirrorDir)))
+	defer srv.Close()
+	p, err := NewProvider(ethconfig.BlocksFreezing{Provider: ProviderHttp, ProviderURL: srv.URL}, snapDir, nil, nil)
+	require.NoError(err)
+
+	// leftover of interrupted fetch is removed
+	leftover := filepath.Join(snapDir, name+".123"+fileProviderTmpSuffix)
+	require.NoError(os.WriteFile(leftover, []byte{1}, 0644))
+
+	// hash mismatch
+	err = p.Fetch(ctx, "test", []services.DownloadRequest{services.NewDownloadRequest(name, "aa")})
+	require.ErrorContains(err, "hash mismatch")
+	require.NoFileExists(filepath.Join(snapDir, name))
+	requireNoTmpFiles(t, snapDir)
+
+	// not found
+	err = p.Fetch(ctx, "test", []services.DownloadRequest{services.NewDownloadRequest("v1-000000-000500-headers.seg", "")})
+	require.ErrorContains(err, "404")
+	requireNoTmpFiles(t, snapDir)
+
+	// canceled
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = p.Fetch(canceled, "test", []services.DownloadRequest{services.NewDownloadRequest(name, "")})
+	require.ErrorIs(err, context.Canceled)
+	require.NoFileExists(filepath.Join(snapDir, name))
+	requireNoTmpFiles(t, snapDir)
+}
+
+func requireNoTmpFiles(t *testing.T, snapDir string) {
+	t.Helper()
+	require.NoError(t, filepath.WalkDir(snapDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		require.NotEqual(t, fileProviderTmpSuffix, filepath.Ext(path), path)
+		return nil
+	}))
+}
+
+type downloaderClientMock struct {
+	proto_downloader.DownloaderClient
+	added    []*proto_downloader.AddItem
+	verified bool
+}
+
+func (c *downloaderClientMock) Add(_ context.Context, in *proto_downloader.AddRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.added = append(c.added, in.Items...)
+	return &emptypb.Empty{}, nil
+}
+
+func (c *downloaderClientMock) Stats(context.Context, *proto_downloader.StatsRequest, ...grpc.CallOption) (*proto_downloader.StatsReply, error) {
+	return &proto_downloader.StatsReply{Completed: true}, nil
+}
+
+func (c *downloaderClientMock) Verify(context.Context, *proto_downloader.VerifyRequest, ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.verified = true
+	return &emptypb.Empty{}, nil
+}
+
+func TestTorrentProvider(t *testing.T) {
+	client := &downloaderClientMock{}
+	p, err := NewProvider(ethconfig.BlocksFreezing{Verify: true}, t.TempDir(), client, nil)
+	require.NoError(t, err)
+	requests := []services.DownloadRequest{services.NewDownloadRequest("v1-000000-000500-headers.seg", "aa")}
+	require.NoError(t, p.Fetch(context.Background(), "test", requests))
+	require.Len(t, client.added, 1)
+	require.Equal(t, "v1-000000-000500-headers.seg", client.added[0].Path)
+	require.True(t, client.verified)
+
+	// canceled: nothing is requested
+	client = &downloaderClientMock{}
+	p, err = NewProvider(ethconfig.BlocksFreezing{}, t.TempDir(), client, nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, p.Fetch(ctx, "test", requests), context.Canceled)
+	require.Empty(t, client.added)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"net/http"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -11,7 +13,9 @@ import (
 	"github.com/ledgerwatch/erigon-lib/chain"
 	"github.com/ledgerwatch/erigon-lib/chain/snapcfg"
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/diagnostics"
 	"github.com/ledgerwatch/erigon-lib/downloader/downloadergrpc"
 	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
@@ -21,6 +25,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/state"
 
 	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/eth/ethconfig"
 	"github.com/ledgerwatch/erigon/turbo/services"
 	"github.com/ledgerwatch/log/v3"
 )
@@ -65,6 +70,58 @@ func RequestSnapshotsDownload(ctx context.Context, downloadRequest []services.Do
 	return nil
 }
 
+// redownloadQuarantined - data files quarantined by AggregatorV3.OpenFolder are fetched again if they are published.
+// Downloader has them as complete: must forget them first. Other providers fetch missing files anyway.
+func redownloadQuarantined(ctx context.Context, logPrefix string, agg *state.AggregatorV3, cfg ethconfig.BlocksFreezing, downloadRequest []services.DownloadRequest, client proto_downloader.DownloaderClient) error {
+	var paths []string
+	for _, q := range agg.QuarantinedFiles() {
+		if q.Rebuild != state.RebuildDownload {
+			continue
+		}
+		published := false
+		for _, r := range downloadRequest {
+			if filepath.Base(r.Path) == q.Name {
+				paths = append(paths, r.Path)
+				published = true
+				break
+			}
+		}
+		if !published {
+			log.Error(fmt.Sprintf("[%s] Quarantined state file is not published: history before it is not available until re-sync", logPrefix), "file", q.Name, "reason", q.Reason)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	log.Warn(fmt.Sprintf("[%s] Re-downloading quarantined state files", logPrefix), "files", paths)
+	if cfg.Provider != "" && cfg.Provider != ProviderTorrent {
+		return nil
+	}
+	_, err := client.Delete(ctx, &proto_downloader.DeleteRequest{Paths: paths})
+	return err
+}
+
+// stateFilesDeletedBefore - state files which end before earliest state file known to DB (see rawdb.WriteSnapshots)
+// were deleted on purpose (minimal-state mode, history expiry) or never downloaded (partial sync): don't download them.
+// 0 - DB has no state files yet (first sync).
+func stateFilesDeletedBefore(tx kv.Tx) (step uint64, err error) {
+	_, histFiles, err := rawdb.ReadSnapshots(tx)
+	if err != nil {
+		return 0, err
+	}
+	first := true
+	for _, name := range histFiles {
+		from, _, ok := snaptype.StateFileSteps(name)
+		if !ok {
+			continue
+		}
+		if first || from < step {
+			step, first = from, false
+		}
+	}
+	return step, nil
+}
+
 // WaitForDownloader - wait for Downloader service to download all expected snapshots
 // for MVP we sync with Downloader only once, in future will send new snapshots also
 func WaitForDownloader(ctx context.Context, logPrefix string, histV3, blobs bool, caplin CaplinMode, agg *state.AggregatorV3, tx kv.RwTx, blockReader services.FullBlockReader, cc *chain.Config, snapshotDownloader proto_downloader.DownloaderClient, stagesIdsList []string) error {
@@ -98,12 +155,24 @@ func WaitForDownloader(ctx context.Context, logPrefix string, histV3, blobs bool
 	downloadRequest := make([]services.DownloadRequest, 0, len(preverifiedBlockSnapshots))
 
 	// build all download requests
+	stateFromStep := blockReader.FreezingCfg().StateFromStep
+	if histV3 {
+		deletedBefore, err := stateFilesDeletedBefore(tx)
+		if err != nil {
+			return err
+		}
+		stateFromStep = cmp.Max(stateFromStep, deletedBefore)
+	}
 	for _, p := range preverifiedBlockSnapshots {
 		if !histV3 {
 			if strings.HasPrefix(p.Name, "domain") || strings.HasPrefix(p.Name, "history") || strings.HasPrefix(p.Name, "idx") {
 				continue
 			}
 		}
+		// partial sync: skip state files which are older than configured window
+		if _, to, ok := snaptype.StateFileSteps(p.Name); ok && stateFromStep > 0 && to <= stateFromStep {
+			continue
+		}
 		if caplin == NoCaplin && (strings.Contains(p.Name, "beaconblocks") || strings.Contains(p.Name, "blobsidecars")) {
 			continue
 		}
@@ -116,70 +185,35 @@ func WaitForDownloader(ctx context.Context, logPrefix string, histV3, blobs bool
 		downloadRequest = append(downloadRequest, services.NewDownloadRequest(p.Name, p.Hash))
 	}
 
-	log.Info(fmt.Sprintf("[%s] Requesting downloads", logPrefix))
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	manifestSigners := append(append([]string{}, snapcfg.KnownManifestSigners[cc.ChainName]...), blockReader.FreezingCfg().ManifestSigners...)
+	peerSigners := append(append([]string{}, manifestSigners...), blockReader.FreezingCfg().StatePeerSigners...)
+	if peers := blockReader.FreezingCfg().StatePeers; histV3 && len(peers) > 0 {
+		diff, err := StateDiffFromPeers(ctx, logPrefix, snapshots.Dir(), peers, peerSigners, stateFromStep)
+		if err != nil {
+			return err
 		}
-		if err := RequestSnapshotsDownload(ctx, downloadRequest, snapshotDownloader); err != nil {
-			log.Error(fmt.Sprintf("[%s] call downloader", logPrefix), "err", err)
-			time.Sleep(10 * time.Second)
-			continue
+		requested := make(map[string]struct{}, len(downloadRequest))
+		for _, r := range downloadRequest {
+			requested[r.Path] = struct{}{}
+		}
+		for _, r := range diff {
+			if _, ok := requested[r.Path]; !ok {
+				downloadRequest = append(downloadRequest, r)
+			}
 		}
-		break
-
 	}
 
-	downloadStartTime := time.Now()
-	const logInterval = 20 * time.Second
-	logEvery := time.NewTicker(logInterval)
-	defer logEvery.Stop()
-
-	// Check once without delay, for faster erigon re-start
-	stats, err := snapshotDownloader.Stats(ctx, &proto_downloader.StatsRequest{})
-
+	provider, err := NewProvider(blockReader.FreezingCfg(), snapshots.Dir(), snapshotDownloader, stagesIdsList)
 	if err != nil {
 		return err
 	}
-
-	// Print download progress until all segments are available
-
-	for !stats.Completed {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-logEvery.C:
-			if stats, err = snapshotDownloader.Stats(ctx, &proto_downloader.StatsRequest{}); err != nil {
-				log.Warn("Error while waiting for snapshots progress", "err", err)
-			} else {
-				logStats(ctx, stats, downloadStartTime, stagesIdsList, logPrefix, "download")
-			}
-		}
-	}
-
-	if blockReader.FreezingCfg().Verify {
-		if _, err := snapshotDownloader.Verify(ctx, &proto_downloader.VerifyRequest{}); err != nil {
+	if histV3 {
+		if err := redownloadQuarantined(ctx, logPrefix, agg, blockReader.FreezingCfg(), downloadRequest, snapshotDownloader); err != nil {
 			return err
 		}
-
-		if stats, err = snapshotDownloader.Stats(ctx, &proto_downloader.StatsRequest{}); err != nil {
-			log.Warn("Error while waiting for snapshots progress", "err", err)
-		}
 	}
-
-	for !stats.Completed {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-logEvery.C:
-			if stats, err = snapshotDownloader.Stats(ctx, &proto_downloader.StatsRequest{}); err != nil {
-				log.Warn("Error while waiting for snapshots progress", "err", err)
-			} else {
-				logStats(ctx, stats, downloadStartTime, stagesIdsList, logPrefix, "download")
-			}
-		}
+	if err := provider.Fetch(ctx, logPrefix, downloadRequest); err != nil {
+		return err
 	}
 
 	if err := snapshots.ReopenFolder(); err != nil {
@@ -192,9 +226,23 @@ func WaitForDownloader(ctx context.Context, logPrefix string, histV3, blobs bool
 		}
 	}
 
+	if histV3 {
+		if err := FetchPublishedManifest(ctx, logPrefix, snapshots.Dir(), blockReader.FreezingCfg().ManifestSources, manifestSigners); err != nil {
+			return err
+		}
+	}
+	if err := VerifyStateFilesByManifest(ctx, logPrefix, snapshots.Dir(), manifestSigners, peerSigners); err != nil {
+		return err
+	}
+
 	if err := agg.OpenFolder(); err != nil {
 		return err
 	}
+	if stateFromStep > 0 {
+		ac := agg.MakeContext()
+		log.Info(fmt.Sprintf("[%s] Partial state history", logPrefix), "fromStep", stateFromStep, "earliestServiceableTxNum", ac.EarliestServiceableTxNum())
+		ac.Close()
+	}
 
 	// ProhibitNewDownloads implies - so only make the download request once,
 	//
@@ -314,3 +362,123 @@ func calculateTime(amountLeft, rate uint64) string {
 
 	return fmt.Sprintf("%dhrs:%dm", hours, minutes)
 }
+
+// FetchPublishedManifest - publisher uploads manifest (see `erigon snapshots manifest`) to webseeds next to files.
+// Fetched from first of `sources` which has it, accepted only if it's signed by one of `signers`, and replaces local one.
+// Without signers nothing is fetched: unsigned manifest can't protect from anything.
+func FetchPublishedManifest(ctx context.Context, logPrefix, snapDir string, sources, signers []string) error {
+	if len(signers) == 0 || len(sources) == 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: time.Minute}
+	for _, source := range sources {
+		m, err := snaptype.FetchManifest(ctx, client, strings.TrimSuffix(source, "/")+"/"+snaptype.ManifestFileName)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Debug(fmt.Sprintf("[%s] no manifest at webseed", logPrefix), "webseed", source, "err", err)
+			continue
+		}
+		if err := m.Verify(signers); err != nil {
+			log.Warn(fmt.Sprintf("[%s] manifest of webseed is not trusted, skip", logPrefix), "webseed", source, "err", err)
+			continue
+		}
+		if err := snaptype.WriteManifest(filepath.Join(snapDir, snaptype.ManifestFileName), m); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("[%s] Manifest fetched", logPrefix), "webseed", source, "files", len(m.Files), "signer", m.Signer)
+		return nil
+	}
+	log.Warn(fmt.Sprintf("[%s] no trusted manifest at webseeds, state files are verified by local one (if any)", logPrefix))
+	return nil
+}
+
+// VerifyStateFilesByManifest - if publisher provided signed manifest (see `erigon snapshots manifest`, FetchPublishedManifest):
+// check it's signature and verify downloaded state/history files (.kv/.v/.ef/...) against it.
+// Files downloaded from state peer are verified against it's manifest (see StateDiffFromPeers), signed by one of `peerSigners`.
+// Files are hashed only once: until their size or modification time changes (see snaptype.VerifiedFilesCacheName).
+// Must be called before AggregatorV3.OpenFolder - to not open corrupted files.
+func VerifyStateFilesByManifest(ctx context.Context, logPrefix, snapDir string, signers, peerSigners []string) error {
+	if err := verifyStateFilesByManifest(ctx, logPrefix, filepath.Join(snapDir, snaptype.ManifestFileName), snapDir, signers); err != nil {
+		return err
+	}
+	peerManifestPath := filepath.Join(snapDir, snaptype.PeerManifestFileName)
+	if !dir.FileExist(peerManifestPath) {
+		return nil
+	}
+	if len(peerSigners) == 0 {
+		return fmt.Errorf("[%s] %s exists, but no trusted signers, see --%s", logPrefix, snaptype.PeerManifestFileName, ethconfig.FlagSnapStatePeerSigners)
+	}
+	return verifyStateFilesByManifest(ctx, logPrefix, peerManifestPath, snapDir, peerSigners)
+}
+
+func verifyStateFilesByManifest(ctx context.Context, logPrefix, manifestPath, snapDir string, signers []string) error {
+	if !dir.FileExist(manifestPath) {
+		return nil
+	}
+	m, err := snaptype.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if err := m.Verify(signers); err != nil {
+		return fmt.Errorf("[%s] %s: %w", logPrefix, filepath.Base(manifestPath), err)
+	}
+	t := time.Now()
+	checked, err := m.VerifyFilesCached(ctx, snapDir, snaptype.IsStateFile)
+	if err != nil {
+		return fmt.Errorf("[%s] state files verification by %s: %w", logPrefix, filepath.Base(manifestPath), err)
+	}
+	log.Info(fmt.Sprintf("[%s] State files verified by manifest", logPrefix), "manifest", filepath.Base(manifestPath), "files", checked, "took", time.Since(t))
+	return nil
+}
+
+// StateDiffFromPeers - differential state sync: fetch manifest of trusted peer and build download requests only for
+// state files which are missing locally (node which lags behind by few steps doesn't re-download all files).
+// Peers are tried in order, first reachable is used. Manifest must be signed by one of `signers` - unsigned manifests
+// are never trusted. Files are downloaded by Downloader (by infohash from manifest, peers seed their files) and verified
+// against manifest hashes before OpenFolder - see VerifyStateFilesByManifest.
+func StateDiffFromPeers(ctx context.Context, logPrefix, snapDir string, peers, signers []string, stateFromStep uint64) ([]services.DownloadRequest, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("[%s] state peers require trusted manifest signers, see --%s", logPrefix, ethconfig.FlagSnapStatePeerSigners)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	for _, peer := range peers {
+		m, err := snaptype.FetchManifest(ctx, client, peer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Warn(fmt.Sprintf("[%s] state peer unavailable", logPrefix), "peer", peer, "err", err)
+			continue
+		}
+		if err := m.Verify(signers); err != nil {
+			return nil, fmt.Errorf("[%s] state peer %s: %w", logPrefix, peer, err)
+		}
+		missing, err := m.Missing(snapDir, snaptype.IsStateFile)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]services.DownloadRequest, 0, len(missing))
+		for _, f := range missing {
+			if _, to, ok := snaptype.StateFileSteps(f.Name); ok && stateFromStep > 0 && to <= stateFromStep {
+				continue
+			}
+			if f.TorrentHash == "" { // peer doesn't seed it
+				log.Debug(fmt.Sprintf("[%s] state peer has file without infohash, skip", logPrefix), "peer", peer, "file", f.Name)
+				continue
+			}
+			res = append(res, services.NewDownloadRequest(f.Name, f.TorrentHash))
+		}
+		// keep peer's manifest: downloaded files are verified against it. Local manifest stays as is.
+		if len(res) > 0 {
+			if err := snaptype.WriteManifest(filepath.Join(snapDir, snaptype.PeerManifestFileName), m); err != nil {
+				return nil, err
+			}
+		}
+		log.Info(fmt.Sprintf("[%s] State diff from peer", logPrefix), "peer", peer, "files_in_manifest", len(m.Files), "requested", len(res))
+		return res, nil
+	}
+	log.Warn(fmt.Sprintf("[%s] no state peer available, continue without state diff", logPrefix))
+	return nil, nil
+}
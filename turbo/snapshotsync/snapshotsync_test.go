@@ -0,0 +1,98 @@
+package snapshotsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/downloader/snaptype"
+)
+
+func TestStateDiffFromPeers(t *testing.T) {
+	require := require.New(t)
+	ctx, peerDir, snapDir := context.Background(), t.TempDir(), t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(peerDir, "history"), 0755))
+	names := []string{"history/v1-accounts.0-32.v", "history/v1-accounts.32-48.v"}
+	for i, name := range names {
+		require.NoError(os.WriteFile(filepath.Join(peerDir, name), []byte{byte(i), 1, 2, 3}, 0644))
+	}
+	m, err := snaptype.BuildManifest(ctx, peerDir, names)
+	require.NoError(err)
+	for i := range m.Files {
+		m.Files[i].TorrentHash = "aa"
+	}
+	unsigned := *m
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	m.Sign(priv)
+	signer := hex.EncodeToString(pub)
+
+	serve := func(m *snaptype.Manifest) string {
+		path := filepath.Join(t.TempDir(), snaptype.ManifestFileName)
+		require.NoError(snaptype.WriteManifest(path, m))
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { http.ServeFile(w, r, path) }))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	signedPeer, unsignedPeer := serve(m), serve(&unsigned)
+
+	// fail closed: no trusted signers, or manifest is not signed by them
+	_, err = StateDiffFromPeers(ctx, "test", snapDir, []string{signedPeer}, nil, 0)
+	require.Error(err)
+	_, err = StateDiffFromPeers(ctx, "test", snapDir, []string{unsignedPeer}, []string{signer}, 0)
+	require.Error(err)
+
+	localManifest := []byte("local")
+	require.NoError(os.WriteFile(filepath.Join(snapDir, snaptype.ManifestFileName), localManifest, 0644))
+	diff, err := StateDiffFromPeers(ctx, "test", snapDir, []string{signedPeer}, []string{signer}, 32)
+	require.NoError(err)
+	require.Len(diff, 1) // file of steps 0-32 is before --snap.state.from.step
+	require.Equal("history/v1-accounts.32-48.v", diff[0].Path)
+
+	// peer's manifest doesn't replace local one
+	got, err := os.ReadFile(filepath.Join(snapDir, snaptype.ManifestFileName))
+	require.NoError(err)
+	require.Equal(localManifest, got)
+	require.FileExists(filepath.Join(snapDir, snaptype.PeerManifestFileName))
+}
+
+func TestFetchPublishedManifest(t *testing.T) {
+	require := require.New(t)
+	ctx, webseedDir, snapDir := context.Background(), t.TempDir(), t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(webseedDir, "history"), 0755))
+	require.NoError(os.MkdirAll(filepath.Join(snapDir, "history"), 0755))
+	name := "history/v1-accounts.0-32.v"
+	require.NoError(os.WriteFile(filepath.Join(webseedDir, name), []byte{1, 2, 3}, 0644))
+	m, err := snaptype.BuildManifest(ctx, webseedDir, []string{name})
+	require.NoError(err)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	m.Sign(priv)
+	require.NoError(snaptype.WriteManifest(filepath.Join(webseedDir, snaptype.ManifestFileName), m))
+	srv := httptest.NewServer(http.FileServer(http.Dir(webseedDir)))
+	defer srv.Close()
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	// not signed by trusted publisher: not accepted
+	require.NoError(FetchPublishedManifest(ctx, "test", snapDir, []string{srv.URL}, []string{hex.EncodeToString(otherPub)}))
+	require.NoFileExists(filepath.Join(snapDir, snaptype.ManifestFileName))
+
+	signers := []string{hex.EncodeToString(pub)}
+	require.NoError(FetchPublishedManifest(ctx, "test", snapDir, []string{"http://127.0.0.1:1", srv.URL}, signers))
+	require.FileExists(filepath.Join(snapDir, snaptype.ManifestFileName))
+
+	// downloaded file is verified by it
+	require.NoError(os.WriteFile(filepath.Join(snapDir, name), []byte{1, 2, 4}, 0644))
+	require.Error(VerifyStateFilesByManifest(ctx, "test", snapDir, signers, nil))
+	require.NoError(os.WriteFile(filepath.Join(snapDir, name), []byte{1, 2, 3}, 0644))
+	require.NoError(VerifyStateFilesByManifest(ctx, "test", snapDir, signers, nil))
+	require.FileExists(filepath.Join(snapDir, snaptype.VerifiedFilesCacheName))
+}